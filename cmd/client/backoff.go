@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+)
+
+// defaultMinConnectTimeout は gRPC の既定の最小接続タイムアウト
+const defaultMinConnectTimeout = 20 * time.Second
+
+// newConnectParams は --backoff-* / --min-connect-timeout から再接続のバックオフ設定を組み立てる。
+// サーバーの再起動直後に全クライアントが一斉に再接続する(reconnection storm)様子を、
+// 遅延や jitter を変えて再現/観測するために使う。各値の既定は gRPC の既定と同じ
+func newConnectParams(baseDelay, maxDelay time.Duration, multiplier, jitter float64, minConnectTimeout time.Duration) (grpc.ConnectParams, error) {
+	switch {
+	case baseDelay <= 0:
+		return grpc.ConnectParams{}, fmt.Errorf("backoff-base-delay must be > 0, got %s", baseDelay)
+	case maxDelay < baseDelay:
+		return grpc.ConnectParams{}, fmt.Errorf("backoff-max-delay (%s) must be >= backoff-base-delay (%s)", maxDelay, baseDelay)
+	case math.IsNaN(multiplier) || multiplier < 1:
+		return grpc.ConnectParams{}, fmt.Errorf("backoff-multiplier must be >= 1.0, got %v", multiplier)
+	case math.IsNaN(jitter) || jitter < 0 || jitter > 1:
+		return grpc.ConnectParams{}, fmt.Errorf("backoff-jitter must be between 0.0 and 1.0, got %v", jitter)
+	case minConnectTimeout <= 0:
+		return grpc.ConnectParams{}, fmt.Errorf("min-connect-timeout must be > 0, got %s", minConnectTimeout)
+	}
+	return grpc.ConnectParams{
+		Backoff: backoff.Config{
+			BaseDelay:  baseDelay,
+			Multiplier: multiplier,
+			Jitter:     jitter,
+			MaxDelay:   maxDelay,
+		},
+		MinConnectTimeout: minConnectTimeout,
+	}, nil
+}
+
+// isDefaultConnectParams は gRPC の既定から変更されていないかどうかを返す
+func isDefaultConnectParams(p grpc.ConnectParams) bool {
+	return p.Backoff == backoff.DefaultConfig && p.MinConnectTimeout == defaultMinConnectTimeout
+}
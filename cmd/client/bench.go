@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// defaultMix は bench の --mix の既定値。軽い ping を主体に、小さい DoWork とストリームを混ぜる
+const defaultMix = "ping=70,do-work-unary:100ms=20,do-work-server=10"
+
+// benchModes は --mix に指定できる呼び出し種別
+var benchModes = map[string]bool{
+	"health":         true,
+	"ping":           true,
+	"do-work-unary":  true,
+	"do-work-server": true,
+	"do-work-client": true,
+	"do-work-bidi":   true,
+}
+
+// mixArm は traffic mix の 1 種類の呼び出し。"mode[:work-duration]=weight" で指定する
+type mixArm struct {
+	Name   string
+	Mode   string
+	Weight int
+	// WorkDuration はこの種類だけ --work-duration を上書きする値。0 なら --work-duration
+	WorkDuration time.Duration
+}
+
+// trafficMix は重み付きの呼び出し種別の一覧
+type trafficMix []mixArm
+
+// parseMix は "ping=70,do-work-unary:100ms=20,do-work-server=10" の形式の traffic mix を解析する
+func parseMix(s string) (trafficMix, error) {
+	var mix trafficMix
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, weightStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mix entry %q: want mode[:work-duration]=weight", part)
+		}
+		name = strings.TrimSpace(name)
+		weight, err := strconv.Atoi(strings.TrimSpace(weightStr))
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid mix weight %q for %s: must be a positive integer", weightStr, name)
+		}
+
+		arm := mixArm{Name: name, Mode: name, Weight: weight}
+		if mode, dur, ok := strings.Cut(name, ":"); ok {
+			d, err := time.ParseDuration(dur)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid mix work-duration %q for %s", dur, mode)
+			}
+			arm.Mode, arm.WorkDuration = mode, d
+		}
+		if !benchModes[arm.Mode] {
+			return nil, fmt.Errorf("unsupported mix mode %q (expected health|ping|do-work-unary|do-work-server|do-work-client|do-work-bidi)", arm.Mode)
+		}
+		if seen[arm.Name] {
+			return nil, fmt.Errorf("duplicate mix entry %q", arm.Name)
+		}
+		seen[arm.Name] = true
+		mix = append(mix, arm)
+	}
+	if len(mix) == 0 {
+		return nil, errors.New("mix must have at least one entry")
+	}
+	return mix, nil
+}
+
+// pick は重みに従って呼び出し種別のインデックスを 1 つ選ぶ
+func (m trafficMix) pick(rng *rand.Rand) int {
+	total := 0
+	for _, a := range m {
+		total += a.Weight
+	}
+	n := rng.Intn(total)
+	for i, a := range m {
+		if n < a.Weight {
+			return i
+		}
+		n -= a.Weight
+	}
+	return len(m) - 1
+}
+
+// workDuration はこの種類の 1 回の負荷時間
+func (a mixArm) workDuration(opts *options) time.Duration {
+	if a.WorkDuration > 0 {
+		return a.WorkDuration
+	}
+	return opts.WorkDuration
+}
+
+// timeout は 1 回の呼び出しのタイムアウトの既定値(--timeout=auto の時)。autoTimeout と同じ見積もり
+func (a mixArm) timeout(opts *options) time.Duration {
+	perWork := a.workDuration(opts) + opts.Latency
+	switch a.Mode {
+	case "do-work-unary":
+		return perWork + timeoutMargin
+	case "do-work-server", "do-work-client", "do-work-bidi":
+		return time.Duration(opts.Repeat)*perWork + timeoutMargin
+	default:
+		return baseTimeout
+	}
+}
+
+// benchTimeout は mix の中で最も時間のかかる呼び出しに合わせたタイムアウト
+func benchTimeout(opts *options) time.Duration {
+	d := baseTimeout
+	for _, a := range opts.Mix {
+		d = max(d, a.timeout(opts))
+	}
+	return d
+}
+
+// mixWorkConfigs は呼び出し種別ごとの WorkConfig を返す。負荷を伴わない種類では nil
+func mixWorkConfigs(opts *options) ([]*grpcburnerv1.WorkConfig, error) {
+	configs := make([]*grpcburnerv1.WorkConfig, len(opts.Mix))
+	for i, arm := range opts.Mix {
+		if !strings.HasPrefix(arm.Mode, "do-work-") {
+			continue
+		}
+		wc, err := workConfigFromOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		wc.DurationMs = int64(arm.workDuration(opts) / time.Millisecond)
+		configs[i] = wc
+	}
+	return configs, nil
+}
+
+// armStats は呼び出し種別ごとの集計
+type armStats struct {
+	latencies []time.Duration
+	codes     map[string]int
+}
+
+// armSummary は "client bench end" ログと標準出力に出す、呼び出し種別ごとの結果
+type armSummary struct {
+	Arm    string         `json:"arm"`
+	Count  int            `json:"count"`
+	Failed int            `json:"failed"`
+	Codes  map[string]int `json:"codes"`
+	P50Ms  float64        `json:"p50_ms"`
+	P95Ms  float64        `json:"p95_ms"`
+	P99Ms  float64        `json:"p99_ms"`
+	MaxMs  float64        `json:"max_ms"`
+}
+
+// benchResult は --output=json に載せる bench の結果
+type benchResult struct {
+	Requests int          `json:"requests"`
+	Failed   int          `json:"failed"`
+	Arms     []armSummary `json:"arms"`
+}
+
+// callBench は --mix の重みに従って呼び出し種別を混ぜ、--concurrency 人の仮想ユーザーで合計 --requests 回呼び出す。
+// 仮想ユーザーは前の呼び出しが終わり、--think-time の分布から取った時間だけ待ってから次を呼ぶ(closed loop)。
+// 同じ種類の呼び出しだけを流すのではなく、実際のサービスに近い混在したトラフィックをダッシュボードで見るためのモード
+func callBench(conn *grpc.ClientConn, opts *options, directOpts []grpc.DialOption) (retErr error) {
+	logger := observability.NewLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	tracer := otel.Tracer("cno-app-client")
+	// 呼び出しごとのログは出さず、最後に種類ごとの集計をまとめて出す
+	ctx, span := tracer.Start(withoutCallLog(context.Background()), "grpc.client/Bench")
+	defer span.End()
+	spanStart := time.Now()
+	defer func() {
+		observability.RecordSpanResult(span, retErr, time.Since(spanStart))
+	}()
+
+	traceID := trace.SpanFromContext(ctx).SpanContext().TraceID().String()
+
+	// 接続先の異常や接続の確立にかかる時間を、計測する呼び出しの統計に混ぜない
+	if opts.Precheck {
+		if err := benchPrecheck(ctx, tracer, conn, opts, directOpts, logger); err != nil {
+			return err
+		}
+	}
+	if opts.Warmup > 0 || opts.WarmupDuration > 0 {
+		if err := benchWarmup(ctx, tracer, conn, opts, logger); err != nil {
+			return err
+		}
+	}
+
+	configs, err := mixWorkConfigs(opts)
+	if err != nil {
+		return err
+	}
+	rep32, err := mustInt32("repeat", opts.Repeat)
+	if err != nil {
+		return err
+	}
+
+	// 呼び出しの順番は開始前にまとめて決めておく。
+	// --seed を指定すると呼び出し種別の並びと think time が毎回同じになり、サーバーの変更前後を同じ負荷で比較できる
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	span.SetAttributes(attribute.Int64("bench.seed", seed))
+	rng := rand.New(rand.NewSource(seed))
+	schedule := make([]int, opts.Requests)
+	for i := range schedule {
+		schedule[i] = opts.Mix.pick(rng)
+	}
+	// think time は仮想ユーザーごとの乱数で取り出す(rand.Rand は goroutine 間で共有できない)
+	userRngs := make([]*rand.Rand, opts.Concurrency)
+	for u := range userRngs {
+		userRngs[u] = rand.New(rand.NewSource(rng.Int63()))
+	}
+
+	mixNames := make([]string, len(opts.Mix))
+	for i, arm := range opts.Mix {
+		mixNames[i] = arm.Name + "=" + strconv.Itoa(arm.Weight)
+	}
+	logger.Infow("client bench start",
+		"trace_id", traceID,
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"mix", strings.Join(mixNames, ","),
+		"requests", opts.Requests,
+		"concurrency", opts.Concurrency,
+		"think_time", opts.ThinkTime.String(),
+		"seed", seed,
+		"work_mode", opts.WorkMode,
+	)
+
+	b := &benchCaller{
+		burner: grpcburnerv1.NewBurnerClient(conn),
+		health: healthpb.NewHealthClient(conn),
+		repeat: rep32,
+	}
+	stats := make([]armStats, len(opts.Mix))
+	for i := range stats {
+		stats[i].codes = map[string]int{}
+	}
+
+	start := time.Now()
+	var (
+		mu   sync.Mutex
+		next atomic.Int64
+		wg   sync.WaitGroup
+	)
+	for u := 0; u < opts.Concurrency; u++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for first := true; ; first = false {
+				i := int(next.Add(1) - 1)
+				if i >= len(schedule) {
+					return
+				}
+				if !first && opts.ThinkTime.Enabled() {
+					time.Sleep(opts.ThinkTime.Sample(rng))
+				}
+				armIdx := schedule[i]
+				arm := opts.Mix[armIdx]
+
+				// 呼び出しごとに別トレースとし、run のルート span へリンクする
+				ictx, ispan := observability.StartIterationSpan(ctx, tracer, "grpc.client/Bench.iteration", i)
+				ispan.SetAttributes(attribute.String("bench.arm", arm.Name))
+				cctx, cancel := context.WithTimeout(ictx, opts.Timeout)
+				istart := time.Now()
+				err := b.call(cctx, arm.Mode, configs[armIdx])
+				elapsed := time.Since(istart)
+				cancel()
+				observability.RecordSpanResult(ispan, err, elapsed)
+				ispan.End()
+
+				mu.Lock()
+				stats[armIdx].latencies = append(stats[armIdx].latencies, elapsed)
+				stats[armIdx].codes[status.Code(err).String()]++
+				mu.Unlock()
+			}
+		}(userRngs[u])
+	}
+	wg.Wait()
+
+	summaries := make([]armSummary, len(opts.Mix))
+	failed := 0
+	for i, arm := range opts.Mix {
+		summaries[i] = summarizeArm(arm.Name, stats[i])
+		failed += summaries[i].Failed
+	}
+
+	fields := []any{
+		"trace_id", traceID,
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"latency_ms", time.Since(start).Milliseconds(),
+		"requests", opts.Requests,
+		"failed", failed,
+		"arms", summaries,
+	}
+	if failed > 0 {
+		logger.Errorw("client bench end", fields...)
+	} else {
+		logger.Infow("client bench end", fields...)
+	}
+
+	opts.Out.setResult(benchResult{Requests: opts.Requests, Failed: failed, Arms: summaries})
+	for _, s := range summaries {
+		opts.Out.printf("bench: arm=%s count=%d failed=%d p50=%.1fms p95=%.1fms p99=%.1fms max=%.1fms\n",
+			s.Arm, s.Count, s.Failed, s.P50Ms, s.P95Ms, s.P99Ms, s.MaxMs)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("bench: %d/%d requests failed", failed, opts.Requests)
+	}
+	return nil
+}
+
+// benchCaller は bench の 1 回分の呼び出しを行う。ログは呼び出しごとには出さず、最後にまとめて出す
+type benchCaller struct {
+	burner grpcburnerv1.BurnerClient
+	health healthpb.HealthClient
+	repeat int32
+}
+
+func (b *benchCaller) call(ctx context.Context, mode string, wc *grpcburnerv1.WorkConfig) error {
+	ctx, requestID := withRequestID(ctx)
+
+	switch mode {
+	case "health":
+		_, err := b.health.Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	case "ping":
+		_, err := b.burner.Ping(ctx, &grpcburnerv1.PingRequest{})
+		return err
+	case "do-work-unary":
+		resp, err := b.burner.DoWork(ctx, &grpcburnerv1.DoWorkRequest{RequestId: requestID, Config: wc})
+		if err == nil && !resp.GetOk() {
+			return errors.New(resp.GetErrorMessage())
+		}
+		return err
+	case "do-work-server":
+		return drainServerStream(ctx, b.burner, &grpcburnerv1.DoWorkServerStreamingRequest{
+			RequestId: requestID,
+			Config:    wc,
+			Repeat:    b.repeat,
+		})
+	case "do-work-client":
+		stream, err := b.burner.DoWorkClientStreaming(ctx)
+		if err != nil {
+			return err
+		}
+		for i := int32(0); i < b.repeat; i++ {
+			if err := stream.Send(&grpcburnerv1.DoWorkRequest{RequestId: requestID, Config: wc}); err != nil {
+				break // 実際のエラーは CloseAndRecv で受け取る
+			}
+		}
+		summary, err := stream.CloseAndRecv()
+		if err == nil && summary.GetFailed() > 0 {
+			return fmt.Errorf("%d/%d works failed", summary.GetFailed(), summary.GetTotal())
+		}
+		return err
+	case "do-work-bidi":
+		stream, err := b.burner.DoWorkBidiStreaming(ctx)
+		if err != nil {
+			return err
+		}
+		for i := int32(0); i < b.repeat; i++ {
+			if err := stream.Send(&grpcburnerv1.DoWorkRequest{RequestId: requestID, Config: wc}); err != nil {
+				break
+			}
+			resp, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			if !resp.GetOk() {
+				return errors.New(resp.GetErrorMessage())
+			}
+		}
+		_ = stream.CloseSend()
+		if _, err := stream.Recv(); err != io.EOF {
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported mix mode %q", mode)
+	}
+}
+
+// summarizeArm は呼び出し種別ごとの件数・失敗数・レイテンシのパーセンタイルを計算する。
+// DoWork の ok=false はステータスコードが OK でも失敗として数える(codes には Unknown として載る)
+func summarizeArm(name string, s armStats) armSummary {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	sum := armSummary{
+		Arm:   name,
+		Count: len(s.latencies),
+		Codes: s.codes,
+		P50Ms: percentileMs(s.latencies, 0.50),
+		P95Ms: percentileMs(s.latencies, 0.95),
+		P99Ms: percentileMs(s.latencies, 0.99),
+	}
+	if n := len(s.latencies); n > 0 {
+		sum.MaxMs = durationMs(s.latencies[n-1])
+	}
+	sum.Failed = sum.Count - s.codes["OK"]
+	return sum
+}
+
+// percentileMs はソート済みの latencies の p パーセンタイル(nearest-rank)をミリ秒で返す
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(float64(len(sorted))*p+0.999999) - 1
+	rank = min(max(rank, 0), len(sorted)-1)
+	return durationMs(sorted[rank])
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
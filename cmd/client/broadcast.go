@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+// broadcastResult は broadcast の接続先ごとの結果
+type broadcastResult struct {
+	Target    string  `json:"target"`
+	Code      string  `json:"code"`
+	OK        bool    `json:"ok"`
+	Error     string  `json:"error,omitempty"`
+	SkewMs    *int64  `json:"start_skew_ms,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// broadcastRunResult は --output=json に載せる broadcast 全体の結果
+type broadcastRunResult struct {
+	StartAt       string            `json:"start_at"`
+	Failed        int               `json:"failed"`
+	StartSpreadMs int64             `json:"start_spread_ms"`
+	Targets       []broadcastResult `json:"targets"`
+}
+
+// broadcastTargets は broadcast で負荷を送る接続先。--kube-service で解決した Pod、--broadcast-addrs、--addr の順に使う
+func broadcastTargets(opts *options) []string {
+	if len(opts.Targets) > 0 {
+		return opts.Targets
+	}
+	if len(opts.BroadcastAddrs) > 0 {
+		return opts.BroadcastAddrs
+	}
+	return []string{opts.Addr}
+}
+
+// callBroadcast はコーディネーターとして、全ての接続先に同じ開始時刻(現在 + --start-delay)の x-start-at を付けた
+// DoWork を同時に送る。各レプリカはその時刻まで待ってから負荷を開始するため、Pod ごとにずれた小さな山ではなく
+// クラスタ全体で同時に負荷がかかる状況を作れる。接続先ごとの開始の遅れ(x-start-skew-ms)とその幅を出す
+func callBroadcast(opts *options, directOpts []grpc.DialOption) (retErr error) {
+	logger := observability.NewLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	targets := broadcastTargets(opts)
+	wc, err := workConfigFromOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	// 接続先ごとのログは出さず、最後に結果をまとめて出す
+	ctx, span := otel.Tracer("cno-app-client").Start(withoutCallLog(context.Background()), "grpc.client/Broadcast")
+	defer span.End()
+	spanStart := time.Now()
+	defer func() {
+		observability.RecordSpanResult(span, retErr, time.Since(spanStart))
+	}()
+	traceID := span.SpanContext().TraceID().String()
+
+	startAt := time.Now().Add(opts.StartDelay)
+	span.SetAttributes(
+		attribute.Int("broadcast.targets", len(targets)),
+		attribute.String("broadcast.start_at", startAt.UTC().Format(time.RFC3339Nano)),
+	)
+	logger.Infow("client broadcast start",
+		"trace_id", traceID,
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"targets", targets,
+		"start_at", startAt.UTC().Format(time.RFC3339Nano),
+		"work_mode", opts.WorkMode,
+	)
+
+	md := metadata.Pairs(appserver.StartAtMetadataKey, strconv.FormatInt(startAt.UnixMilli(), 10))
+	if opts.Instances > 1 {
+		md.Set(appserver.InstancesMetadataKey, strconv.Itoa(opts.Instances))
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	results := make([]broadcastResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = broadcastTo(ctx, target, wc, opts, directOpts)
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	var minSkew, maxSkew int64
+	skewed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+		}
+		if r.SkewMs == nil {
+			continue
+		}
+		if skewed == 0 || *r.SkewMs < minSkew {
+			minSkew = *r.SkewMs
+		}
+		if skewed == 0 || *r.SkewMs > maxSkew {
+			maxSkew = *r.SkewMs
+		}
+		skewed++
+	}
+
+	fields := []any{
+		"trace_id", traceID,
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"start_at", startAt.UTC().Format(time.RFC3339Nano),
+		"targets", results,
+		"failed", failed,
+		"start_spread_ms", maxSkew - minSkew,
+	}
+	if failed > 0 {
+		logger.Errorw("client broadcast end", fields...)
+	} else {
+		logger.Infow("client broadcast end", fields...)
+	}
+
+	opts.Out.setResult(broadcastRunResult{
+		StartAt:       startAt.UTC().Format(time.RFC3339Nano),
+		Failed:        failed,
+		StartSpreadMs: maxSkew - minSkew,
+		Targets:       results,
+	})
+	for _, r := range results {
+		skew := "-"
+		if r.SkewMs != nil {
+			skew = strconv.FormatInt(*r.SkewMs, 10) + "ms"
+		}
+		opts.Out.printf("broadcast: target=%s code=%s ok=%v start_skew=%s latency=%.1fms %s\n", r.Target, r.Code, r.OK, skew, r.LatencyMs, r.Error)
+	}
+	opts.Out.printf("broadcast: started=%d/%d start_spread=%dms\n", skewed, len(targets), maxSkew-minSkew)
+
+	if failed > 0 {
+		return fmt.Errorf("broadcast: %d/%d targets failed", failed, len(targets))
+	}
+	return nil
+}
+
+// broadcastTo は 1 つの接続先に直接接続して DoWork を送る
+func broadcastTo(ctx context.Context, target string, wc *grpcburnerv1.WorkConfig, opts *options, directOpts []grpc.DialOption) broadcastResult {
+	r := broadcastResult{Target: target}
+
+	cc, err := grpc.NewClient("passthrough:///"+target, directOpts...)
+	if err != nil {
+		r.Code, r.Error = "DIAL_ERROR", err.Error()
+		return r
+	}
+	defer func() {
+		_ = cc.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	ctx, requestID := withRequestID(ctx)
+
+	start := time.Now()
+	var trailer metadata.MD
+	resp, err := grpcburnerv1.NewBurnerClient(cc).DoWork(ctx, &grpcburnerv1.DoWorkRequest{RequestId: requestID, Config: wc}, grpc.Trailer(&trailer))
+	r.LatencyMs = durationMs(time.Since(start))
+	r.Code = status.Code(err).String()
+	if v := trailer.Get(appserver.StartSkewTrailerKey); len(v) > 0 {
+		if ms, err := strconv.ParseInt(v[0], 10, 64); err == nil {
+			r.SkewMs = &ms
+		}
+	}
+	switch {
+	case err != nil:
+		r.Error = err.Error()
+	case !resp.GetOk():
+		r.Error = resp.GetErrorMessage()
+	default:
+		r.OK = true
+	}
+	return r
+}
+
+// parseAddrList は "host:port,host:port" を分割する
+func parseAddrList(s string) []string {
+	var addrs []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// channelzServer はサーバー 1 つ分の channelz の集計
+type channelzServer struct {
+	ID             int64            `json:"id"`
+	ListenSockets  []string         `json:"listen_sockets,omitempty"`
+	CallsStarted   int64            `json:"calls_started"`
+	CallsSucceeded int64            `json:"calls_succeeded"`
+	CallsFailed    int64            `json:"calls_failed"`
+	Sockets        []channelzSocket `json:"sockets"`
+}
+
+// channelzSocket はサーバーが受け付けたコネクション 1 本分の統計
+type channelzSocket struct {
+	ID                      int64  `json:"id"`
+	Remote                  string `json:"remote,omitempty"`
+	Local                   string `json:"local,omitempty"`
+	StreamsStarted          int64  `json:"streams_started"`
+	StreamsSucceeded        int64  `json:"streams_succeeded"`
+	StreamsFailed           int64  `json:"streams_failed"`
+	MessagesSent            int64  `json:"messages_sent"`
+	MessagesReceived        int64  `json:"messages_received"`
+	KeepAlivesSent          int64  `json:"keepalives_sent"`
+	LocalFlowControlWindow  int64  `json:"local_flow_control_window,omitempty"`
+	RemoteFlowControlWindow int64  `json:"remote_flow_control_window,omitempty"`
+}
+
+// callChannelz はサーバーの channelz からサーバー/ソケットごとのストリーム・メッセージ数などを取得し、JSON で標準出力に出す。
+// HTTP/2 のコネクションやストリームの状態を、トランスポート層まで降りて確認するためのワークショップ用モード
+func callChannelz(conn *grpc.ClientConn, opts *options) error {
+	logger := observability.NewLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	// サーバー / ソケットごとの呼び出しのログは出さず、読み取った結果をまとめて出す
+	ctx, cancel := context.WithTimeout(withoutCallLog(context.Background()), opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	servers, err := readChannelz(ctx, channelzpb.NewChannelzClient(conn))
+	if err != nil {
+		logger.Errorw("channelz read failed", "addr", opts.Addr, "error", err)
+		return fmt.Errorf("channelz failed: %w", err)
+	}
+
+	sockets := 0
+	for _, s := range servers {
+		sockets += len(s.Sockets)
+	}
+	logger.Infow("channelz read",
+		"addr", opts.Addr,
+		"servers", len(servers),
+		"sockets", sockets,
+		"latency_ms", time.Since(start).Milliseconds(),
+	)
+
+	return opts.Out.printJSON(map[string]any{"servers": servers})
+}
+
+// readChannelz は全サーバーと、各サーバーが受け付けた全ソケットをページングしながら取得する
+func readChannelz(ctx context.Context, cz channelzpb.ChannelzClient) ([]channelzServer, error) {
+	var out []channelzServer
+	for start := int64(0); ; {
+		resp, err := cz.GetServers(ctx, &channelzpb.GetServersRequest{StartServerId: start})
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range resp.GetServer() {
+			s, err := readChannelzServer(ctx, cz, srv)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+			start = srv.GetRef().GetServerId() + 1
+		}
+		if resp.GetEnd() || len(resp.GetServer()) == 0 {
+			return out, nil
+		}
+	}
+}
+
+func readChannelzServer(ctx context.Context, cz channelzpb.ChannelzClient, srv *channelzpb.Server) (channelzServer, error) {
+	data := srv.GetData()
+	s := channelzServer{
+		ID:             srv.GetRef().GetServerId(),
+		CallsStarted:   data.GetCallsStarted(),
+		CallsSucceeded: data.GetCallsSucceeded(),
+		CallsFailed:    data.GetCallsFailed(),
+		Sockets:        []channelzSocket{},
+	}
+	for _, ls := range srv.GetListenSocket() {
+		s.ListenSockets = append(s.ListenSockets, ls.GetName())
+	}
+
+	for start := int64(0); ; {
+		resp, err := cz.GetServerSockets(ctx, &channelzpb.GetServerSocketsRequest{ServerId: s.ID, StartSocketId: start})
+		if err != nil {
+			return s, err
+		}
+		for _, ref := range resp.GetSocketRef() {
+			sock, err := cz.GetSocket(ctx, &channelzpb.GetSocketRequest{SocketId: ref.GetSocketId()})
+			if err != nil {
+				return s, err
+			}
+			s.Sockets = append(s.Sockets, socketStats(sock.GetSocket()))
+			start = ref.GetSocketId() + 1
+		}
+		if resp.GetEnd() || len(resp.GetSocketRef()) == 0 {
+			return s, nil
+		}
+	}
+}
+
+func socketStats(sock *channelzpb.Socket) channelzSocket {
+	data := sock.GetData()
+	return channelzSocket{
+		ID:                      sock.GetRef().GetSocketId(),
+		Remote:                  channelzAddr(sock.GetRemote()),
+		Local:                   channelzAddr(sock.GetLocal()),
+		StreamsStarted:          data.GetStreamsStarted(),
+		StreamsSucceeded:        data.GetStreamsSucceeded(),
+		StreamsFailed:           data.GetStreamsFailed(),
+		MessagesSent:            data.GetMessagesSent(),
+		MessagesReceived:        data.GetMessagesReceived(),
+		KeepAlivesSent:          data.GetKeepAlivesSent(),
+		LocalFlowControlWindow:  data.GetLocalFlowControlWindow().GetValue(),
+		RemoteFlowControlWindow: data.GetRemoteFlowControlWindow().GetValue(),
+	}
+}
+
+func channelzAddr(a *channelzpb.Address) string {
+	if tcp := a.GetTcpipAddress(); tcp != nil {
+		return net.JoinHostPort(net.IP(tcp.GetIpAddress()).String(), strconv.Itoa(int(tcp.GetPort())))
+	}
+	if uds := a.GetUdsAddress(); uds != nil {
+		return uds.GetFilename()
+	}
+	return ""
+}
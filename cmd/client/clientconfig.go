@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/clientconfig"
+)
+
+const fetchConfigTimeout = 2 * time.Second
+
+// fetchClientConfig は起動時にサーバーの ClientConfigService から推奨設定を取得する。
+// extra は接続先の解決に必要な DialOption(--kube-service の resolver など)。
+// 取得に失敗した場合(古いサーバーなど)は警告ログを出し、ok=false を返して gRPC の既定設定のまま続行させる
+func fetchClientConfig(opts *options, creds credentials.TransportCredentials, extra []grpc.DialOption, logger *zap.SugaredLogger) (cfg clientconfig.Config, ok bool) {
+	conn, err := grpc.NewClient(opts.Addr, append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, extra...)...)
+	if err != nil {
+		logger.Warnw("client config fetch skipped", "addr", opts.Addr, "error", err)
+		return clientconfig.Config{}, false
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchConfigTimeout)
+	defer cancel()
+
+	cfg, err = clientconfig.Fetch(ctx, conn)
+	if err != nil {
+		logger.Warnw("client config fetch failed, using defaults", "addr", opts.Addr, "error", err)
+		return clientconfig.Config{}, false
+	}
+
+	logger.Infow("client config applied",
+		"addr", opts.Addr,
+		"timeout_ms", cfg.TimeoutMs,
+		"max_recv_msg_size", cfg.MaxRecvMsgSize,
+		"max_send_msg_size", cfg.MaxSendMsgSize,
+		"retry_max_attempts", cfg.RetryPolicy.MaxAttempts,
+		"retryable_status_codes", cfg.RetryPolicy.RetryableStatusCodes,
+	)
+	return cfg, true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"google.golang.org/grpc/stats"
+
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+// compressionNone は --compression で圧縮しない(既定)
+const compressionNone = "none"
+
+// newCompression は --compression の値を検証し、grpc.UseCompressor に渡す名前を返す。none なら空文字列。
+// zstd は pkg/server が登録する Compressor で、サーバーも同じものを登録しているため両方向で使える
+func newCompression(name string) (string, error) {
+	switch name {
+	case "", compressionNone:
+		return "", nil
+	case "gzip", appserver.CompressionZstd:
+		return name, nil
+	default:
+		return "", fmt.Errorf("compression must be none, gzip or %s, got %q", appserver.CompressionZstd, name)
+	}
+}
+
+// wireBytes は 1 回の呼び出し(ストリームは全メッセージ)で実際に送受信した圧縮後のバイト数。
+// 再試行した場合は全ての試行の分を含む
+type wireBytes struct {
+	out, in atomic.Int64
+}
+
+type wireBytesKey struct{}
+
+// withWireBytes は ctx で行う呼び出しの圧縮後のバイト数を受け取るカウンターを付ける
+func withWireBytes(ctx context.Context) (context.Context, *wireBytes) {
+	w := &wireBytes{}
+	return context.WithValue(ctx, wireBytesKey{}, w), w
+}
+
+// wireBytesHandler は gRPC が圧縮した後のメッセージのサイズを、呼び出しの ctx のカウンター(withWireBytes)に加算する stats.Handler。
+// 圧縮前のサイズ(bytes_out / bytes_in)はメッセージから計算できるが、圧縮後のサイズは gRPC の内側でしか分からないため
+type wireBytesHandler struct{}
+
+func (wireBytesHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context { return ctx }
+
+func (wireBytesHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	w, ok := ctx.Value(wireBytesKey{}).(*wireBytes)
+	if !ok {
+		return
+	}
+	switch p := s.(type) {
+	case *stats.OutPayload:
+		w.out.Add(int64(p.CompressedLength))
+	case *stats.InPayload:
+		w.in.Add(int64(p.CompressedLength))
+	}
+}
+
+func (wireBytesHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (wireBytesHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// compressionLogFields は終了ログに圧縮方式と圧縮後のバイト数を出すフィールドを返す。
+// 圧縮前の bytes_out / bytes_in と並べて圧縮率を見る。w が nil(圧縮しない)なら何も出さない
+func compressionLogFields(compression string, w *wireBytes) []any {
+	if w == nil {
+		return nil
+	}
+	return []any{
+		"compression", compression,
+		"bytes_out_compressed", w.out.Load(),
+		"bytes_in_compressed", w.in.Load(),
+	}
+}
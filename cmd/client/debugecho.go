@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/debugecho"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// callDebugEcho は DebugEcho を呼び、サーバーに届いた metadata / peer / trace context を JSON で標準出力に出す。
+// 送った trace_id がサーバーに届いたかどうかを trace_propagated として "client debug echo" ログに残すため、
+// proxy や mesh が traceparent を落としているかをすぐに判断できる
+func callDebugEcho(conn *grpc.ClientConn, opts *options) error {
+	logger := observability.NewLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	var sc trace.SpanContext
+	echo, err := debugecho.Call(ctx, conn, captureSpan(&sc))
+	if err != nil {
+		return fmt.Errorf("debug echo failed: %w", err)
+	}
+
+	traceID := sc.TraceID().String()
+	logger.Infow("client debug echo",
+		"trace_id", traceID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"trace_propagated", echo.Trace.Incoming.TraceID == traceID,
+		"server_trace_id", echo.Trace.Server.TraceID,
+		"peer_addr", echo.Peer.Addr,
+	)
+
+	return opts.Out.printJSON(echo)
+}
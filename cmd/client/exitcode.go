@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+// 終了コード。CI や Kubernetes の Job が失敗の種類で分岐できるよう、失敗の分類ごとに分ける
+const (
+	exitOK       = 0
+	exitFailure  = 1 // 以下に当てはまらない失敗(合格条件を満たさない、集計に失敗を含むなど)
+	exitUsage    = 2 // フラグや設定ファイルの値が不正で、RPC を送っていない
+	exitConnect  = 3 // 接続できない(UNAVAILABLE)
+	exitDeadline = 4 // タイムアウト(DEADLINE_EXCEEDED)
+	exitInvalid  = 5 // サーバーがリクエストを不正として拒否した(INVALID_ARGUMENT、DoWork の invalid config など)
+	exitInjected = 6 // error_rate / fail_after / return-code などで意図的に起こしたエラー
+	exitLimit    = 7 // サーバーの上限を超えた(RESOURCE_EXHAUSTED)
+)
+
+// usageError は RPC を送る前の、オプションの検証で見つかった誤り
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+// workError は DoWork が ok=false で返した失敗。ステータスは OK のため、最初に失敗した応答の error_message から分類する
+type workError struct {
+	mode          string
+	failed, total int
+	first         string
+}
+
+func (e workError) Error() string {
+	if e.total <= 1 {
+		return fmt.Sprintf("%s: work failed: %s", e.mode, e.first)
+	}
+	return fmt.Sprintf("%s: %d/%d works failed (first: %s)", e.mode, e.failed, e.total, e.first)
+}
+
+// exitCode は error_message の内容で分類する。サーバーは検証での拒否を "invalid ..." で返す
+func (e workError) exitCode() int {
+	switch {
+	case strings.Contains(e.first, load.ErrInjected.Error()):
+		return exitInjected
+	case strings.HasPrefix(e.first, "invalid "):
+		return exitInvalid
+	default:
+		return exitFailure
+	}
+}
+
+// exitCode は run の結果 err を終了コードに変換する。
+// サーバーが ErrorInfo で injected と分類したエラーは、コード(return-code の UNAVAILABLE など)より優先して exitInjected にする
+func exitCode(err error) int {
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	var ue usageError
+	if errors.As(err, &ue) {
+		return exitUsage
+	}
+	var we workError
+	if errors.As(err, &we) {
+		return we.exitCode()
+	}
+	if apperrors.CategoryOf(err) == apperrors.Injected {
+		return exitInjected
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return exitDeadline
+	}
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return exitConnect
+	case codes.DeadlineExceeded:
+		return exitDeadline
+	case codes.InvalidArgument:
+		return exitInvalid
+	case codes.ResourceExhausted:
+		return exitLimit
+	default:
+		return exitFailure
+	}
+}
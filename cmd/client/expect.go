@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+// expectations は --expect-code / --max-latency-ms / --min-success-rate で指定する合格条件。
+// CI のスモークテストでクライアントをそのまま検証に使えるよう、既定値から変えた項目があれば
+// mode の結果の代わりに、計測した RPC がこの条件を満たしたかどうかで終了コードを決める
+type expectations struct {
+	// Code は各呼び出しが返すべきステータス。既定は OK
+	Code codes.Code
+	// MaxLatency は 1 回の呼び出し(ストリームは開始から終了まで)の上限。0 なら確認しない
+	MaxLatency time.Duration
+	// MinSuccessRate は Code を返した呼び出しの割合の下限(0〜1)。既定は 1(全て)
+	MinSuccessRate float64
+}
+
+// enabled は既定値から変えた項目があるかどうかを返す
+func (e expectations) enabled() bool {
+	return e.Code != codes.OK || e.MaxLatency > 0 || e.MinSuccessRate < 1
+}
+
+// newExpectations は --expect-code / --max-latency-ms / --min-success-rate の値を検証する
+func newExpectations(code string, maxLatencyMs int, minSuccessRate float64) (expectations, error) {
+	c, err := appserver.ParseCode(code)
+	if err != nil {
+		return expectations{}, fmt.Errorf("expect-code: %w", err)
+	}
+	if maxLatencyMs < 0 {
+		return expectations{}, fmt.Errorf("max-latency-ms must be >= 0, got %d", maxLatencyMs)
+	}
+	if math.IsNaN(minSuccessRate) || minSuccessRate < 0 || minSuccessRate > 1 {
+		return expectations{}, fmt.Errorf("min-success-rate must be between 0 and 1, got %v", minSuccessRate)
+	}
+	return expectations{
+		Code:           c,
+		MaxLatency:     time.Duration(maxLatencyMs) * time.Millisecond,
+		MinSuccessRate: minSuccessRate,
+	}, nil
+}
+
+// callResult は計測した 1 回の呼び出しの結果
+type callResult struct {
+	Code    codes.Code
+	Latency time.Duration
+}
+
+// callRecorder は interceptor で全ての RPC のステータスとレイテンシを記録する。
+// DoWork の ok=false や失敗を含む集計(failed > 0)はステータスが OK でも UNKNOWN として数える(bench と同じ)
+type callRecorder struct {
+	mu    sync.Mutex
+	calls []callResult
+}
+
+type unmeasuredKey struct{}
+
+// unmeasured は ctx で行う RPC を合格条件の判定に含めないようにする(bench の precheck / warmup)
+func unmeasured(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unmeasuredKey{}, true)
+}
+
+func isUnmeasured(ctx context.Context) bool {
+	v, _ := ctx.Value(unmeasuredKey{}).(bool)
+	return v
+}
+
+func (r *callRecorder) add(err error, failed bool, latency time.Duration) {
+	code := status.Code(err)
+	if code == codes.OK && failed {
+		code = codes.Unknown
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, callResult{Code: code, Latency: latency})
+}
+
+func (r *callRecorder) results() []callResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]callResult(nil), r.calls...)
+}
+
+// reportsFailure はレスポンスのメッセージが(ステータスとは別に)失敗を示しているかどうかを返す
+func reportsFailure(m any) bool {
+	return observability.ResponseFailure(m, "") != nil
+}
+
+func (r *callRecorder) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+			if isUnmeasured(ctx) {
+				return invoker(ctx, method, req, reply, cc, callOpts...)
+			}
+			start := time.Now()
+			err := invoker(ctx, method, req, reply, cc, callOpts...)
+			r.add(err, err == nil && reportsFailure(reply), time.Since(start))
+			return err
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+			if isUnmeasured(ctx) {
+				return streamer(ctx, desc, cc, method, callOpts...)
+			}
+			start := time.Now()
+			cs, err := streamer(ctx, desc, cc, method, callOpts...)
+			if err != nil {
+				r.add(err, false, time.Since(start))
+				return nil, err
+			}
+			return &recordedStream{ClientStream: cs, r: r, start: start, serverStreams: desc.ServerStreams}, nil
+		}),
+	}
+}
+
+// recordedStream はストリームの終了(EOF / エラー / client streaming の応答)を受け取った時点で 1 回だけ記録する。
+// 最後まで受信しなかったストリームは記録しない
+type recordedStream struct {
+	grpc.ClientStream
+	r             *callRecorder
+	start         time.Time
+	serverStreams bool
+
+	failed bool
+	once   sync.Once
+}
+
+func (s *recordedStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		if reportsFailure(m) {
+			s.failed = true
+		}
+		if !s.serverStreams {
+			// client streaming は応答を受け取った時点で終わっている
+			s.finish(nil)
+		}
+	case errors.Is(err, io.EOF):
+		s.finish(nil)
+	default:
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *recordedStream) finish(err error) {
+	s.once.Do(func() {
+		s.r.add(err, s.failed, time.Since(s.start))
+	})
+}
+
+// expectationsReport は --output=json に載せる合格条件の判定結果
+type expectationsReport struct {
+	Passed       bool           `json:"passed"`
+	Calls        int            `json:"calls"`
+	Codes        map[string]int `json:"codes"`
+	ExpectCode   string         `json:"expect_code"`
+	SuccessRate  float64        `json:"success_rate"`
+	MaxLatencyMs float64        `json:"max_latency_ms"`
+	Violations   []string       `json:"violations,omitempty"`
+}
+
+// check は記録した呼び出しが合格条件を満たしたかどうかを "client expectations" ログと標準出力に出し、満たさなければエラーを返す
+func (e expectations) check(calls []callResult, logger *zap.SugaredLogger, out *resultWriter) error {
+	if len(calls) == 0 {
+		return errors.New("expectations: no calls were recorded")
+	}
+	matched, slow := 0, 0
+	var maxLatency time.Duration
+	codeCounts := map[string]int{}
+	for _, c := range calls {
+		codeCounts[c.Code.String()]++
+		if c.Code == e.Code {
+			matched++
+		}
+		if e.MaxLatency > 0 && c.Latency > e.MaxLatency {
+			slow++
+		}
+		maxLatency = max(maxLatency, c.Latency)
+	}
+	rate := float64(matched) / float64(len(calls))
+
+	var violations []string
+	if rate < e.MinSuccessRate {
+		violations = append(violations, fmt.Sprintf("%d/%d calls returned %s (rate %.3f < %.3f)", matched, len(calls), e.Code, rate, e.MinSuccessRate))
+	}
+	if slow > 0 {
+		violations = append(violations, fmt.Sprintf("%d/%d calls took longer than %dms (max %.1fms)", slow, len(calls), e.MaxLatency.Milliseconds(), durationMs(maxLatency)))
+	}
+
+	fields := []any{
+		"calls", len(calls),
+		"codes", codeCounts,
+		"expect_code", e.Code.String(),
+		"success_rate", rate,
+		"min_success_rate", e.MinSuccessRate,
+		"max_latency_ms", durationMs(maxLatency),
+		"limit_latency_ms", e.MaxLatency.Milliseconds(),
+		"passed", len(violations) == 0,
+	}
+	out.setExpectations(expectationsReport{
+		Passed:       len(violations) == 0,
+		Calls:        len(calls),
+		Codes:        codeCounts,
+		ExpectCode:   e.Code.String(),
+		SuccessRate:  rate,
+		MaxLatencyMs: durationMs(maxLatency),
+		Violations:   violations,
+	})
+	if len(violations) > 0 {
+		logger.Errorw("client expectations", append(fields, "violations", violations)...)
+		out.printf("expectations: FAIL %s\n", strings.Join(violations, "; "))
+		return fmt.Errorf("expectations not met: %s", strings.Join(violations, "; "))
+	}
+	logger.Infow("client expectations", fields...)
+	out.printf("expectations: PASS calls=%d code=%s rate=%.3f max=%.1fms\n", len(calls), e.Code, rate, durationMs(maxLatency))
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/scenario"
+)
+
+// runExport は "client export" サブコマンド。
+// シナリオファイルを ghz の設定ファイル(ステップごと)または k6 スクリプトに変換する
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+
+	scenarioPath := fs.String("scenario", "", "scenario file (JSON)")
+	format := fs.String("format", "ghz", "output format (ghz, k6)")
+	out := fs.String("out", "", "output path: directory for ghz, file for k6 (default: stdout for k6, current directory for ghz)")
+	addr := fs.String("addr", getenvOrDefault(envAddr, defaultAddr), "gRPC server address used when the scenario has no addr")
+	insecureFlag := fs.Bool("insecure", false, "generate plaintext connection settings")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *scenarioPath == "" {
+		return fmt.Errorf("export: --scenario is required")
+	}
+
+	sc, err := scenario.Load(*scenarioPath)
+	if err != nil {
+		return err
+	}
+	opts := scenario.ExportOptions{Addr: *addr, Insecure: *insecureFlag}
+
+	switch *format {
+	case "ghz":
+		files, err := sc.ExportGhz(opts)
+		if err != nil {
+			return err
+		}
+		dir := *out
+		if dir == "" {
+			dir = "."
+		}
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return err
+		}
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		written := make(map[string]string, len(names))
+		for _, name := range names {
+			path := filepath.Join(dir, fmt.Sprintf("%s-%s.ghz.json", safeFileName(sc.Name), safeFileName(name)))
+			if prev, ok := written[path]; ok {
+				return fmt.Errorf("export: steps %q and %q map to the same file %s", prev, name, path)
+			}
+			written[path] = name
+			if err := os.WriteFile(path, files[name], 0o644); err != nil {
+				return err
+			}
+			fmt.Println(path)
+		}
+		return nil
+	case "k6":
+		script, err := sc.ExportK6(opts)
+		if err != nil {
+			return err
+		}
+		if *out == "" {
+			_, err := os.Stdout.Write(script)
+			return err
+		}
+		return os.WriteFile(*out, script, 0o644)
+	default:
+		return fmt.Errorf("export: unsupported format %q (expected ghz|k6)", *format)
+	}
+}
+
+// safeFileName はシナリオ名・ステップ名を --out のディレクトリの外を指さないファイル名にする。
+// パスの区切りより前を捨て(filepath.Base)、英数字・'_'・'-' 以外は '_' に置き換える
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, filepath.Base(name))
+}
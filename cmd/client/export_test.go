@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+// ghz の出力ファイル名は --out のディレクトリの外を指さず、英数字・'_'・'-' だけにする
+func TestSafeFileName(t *testing.T) {
+	tests := map[string]string{
+		"cpu-burst_1":        "cpu-burst_1",
+		"../../etc/passwd":   "passwd",
+		"a/b":                "b",
+		"..":                 "__",
+		"":                   "_",
+		"step one":           "step_one",
+		"ステップ":               "____",
+		"smoke.v2":           "smoke_v2",
+		"C:\\tmp\\evil":      "C__tmp_evil",
+		"name\nwith-newline": "name_with-newline",
+	}
+	for in, want := range tests {
+		if got := safeFileName(in); got != want {
+			t.Errorf("safeFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// reservedHeaders は gRPC / HTTP/2 が自分で付けるため、ファイルから上書きさせないヘッダー
+var reservedHeaders = map[string]bool{
+	"content-type": true,
+	"te":           true,
+	"user-agent":   true,
+	"host":         true,
+	"connection":   true,
+}
+
+// clientManagedHeaders はクライアントが RPC ごとに付けるため、--metadata / --header-from-file で指定させないヘッダー
+var clientManagedHeaders = map[string]bool{
+	requestIDMetadataKey:                true,
+	observability.RunIDMetadataKey:      true,
+	observability.ClientModeMetadataKey: true,
+}
+
+// metadataFlag は繰り返し指定できる --metadata key=value。同じキーを複数回指定すると複数値になる。
+// サーバー側のルーティング・テナント分離・認証の interceptor を、コードを変えずに試すために使う
+type metadataFlag struct {
+	md metadata.MD
+}
+
+func (f *metadataFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(headerKeys(f.md), ",")
+}
+
+func (f *metadataFlag) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("expected key=value, got %q", v)
+	}
+	if f.md == nil {
+		f.md = metadata.MD{}
+	}
+	return addHeader(f.md, key, value)
+}
+
+// loadHeaderFile は --header-from-file のファイルから全 RPC に付与する metadata を読み込む。
+// 形式は次のどちらか(先頭が "{" なら JSON とみなす)。
+//
+//   - 1 行 1 ヘッダーの "key: value"。空行と "#" で始まる行は無視し、同じキーを複数回書くと複数値になる
+//   - JSON オブジェクト {"key": "value", "key2": ["v1", "v2"]}
+//
+// 認証トークンやメッシュのルーティング用ヘッダーのように、フラグで並べるには多い/長いものを渡すために使う
+func loadHeaderFile(path string) (metadata.MD, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read header file: %w", err)
+	}
+
+	var md metadata.MD
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+		md, err = parseHeaderJSON(trimmed)
+	} else {
+		md, err = parseHeaderLines(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("header file %s: %w", path, err)
+	}
+	return md, nil
+}
+
+func parseHeaderLines(b []byte) (metadata.MD, error) {
+	md := metadata.MD{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", n)
+		}
+		if err := addHeader(md, key, strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+func parseHeaderJSON(b []byte) (metadata.MD, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	md := metadata.MD{}
+	for key, v := range raw {
+		var values []string
+		var single string
+		switch {
+		case json.Unmarshal(v, &single) == nil:
+			values = []string{single}
+		case json.Unmarshal(v, &values) == nil:
+		default:
+			return nil, fmt.Errorf("key %q: value must be a string or an array of strings", key)
+		}
+		for _, value := range values {
+			if err := addHeader(md, key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return md, nil
+}
+
+func addHeader(md metadata.MD, key, value string) error {
+	key = strings.ToLower(strings.TrimSpace(key))
+	switch {
+	case key == "":
+		return fmt.Errorf("empty header name")
+	case strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") || reservedHeaders[key]:
+		return fmt.Errorf("header %q is reserved", key)
+	case clientManagedHeaders[key]:
+		return fmt.Errorf("header %q is set by the client", key)
+	}
+	md.Append(key, value)
+	return nil
+}
+
+// headerKeys はログに出すためのキー一覧。トークンなどの値は出さない
+func headerKeys(md metadata.MD) []string {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	return keys
+}
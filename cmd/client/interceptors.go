@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+const requestIDMetadataKey = "x-request-id"
+
+// instrumentationDialOptions は全ての mode の RPC に共通の計装を行う interceptor を返す。
+// 各 mode の関数で request_id / span / 開始・終了ログを手で書かなくても、新しい mode が同じ観測性を持てるようにする。
+// chain の順番は run で組み立て、外側から
+//  1. runMetadataDialOptions(run_id / mode / --header-from-file / --metadata などの metadata)
+//  2. request id(x-request-id がなければ付与する)
+//  3. tracing(grpc.client/<Service>.<Method> span と、その結果の記録)
+//  4. logging(client request start / end、client stream start / end と、--output=json の calls)。
+//     --compression 指定時は圧縮後のバイト数(bytes_out_compressed / bytes_in_compressed)も終了ログに出す
+//  5. callRecorder(--expect-* の合格条件)
+//  6. retryPolicy(--retries による Unary の再試行)
+//
+// 再試行(--retries、またはサービス設定のリトライ)は chain の内側で行われるため、ログは 1 回の呼び出しにつき 1 組になる
+func instrumentationDialOptions(opts *options, logger *zap.SugaredLogger, out *resultWriter) []grpc.DialOption {
+	tracer := otel.Tracer("cno-app-client")
+	static := []any{"mode", opts.Mode, "addr", opts.Addr}
+	dialOpts := []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(
+			unaryRequestIDInterceptor,
+			unaryTracingInterceptor(tracer),
+			unaryLoggingInterceptor(logger, static, out, opts.Compression),
+		),
+		grpc.WithChainStreamInterceptor(
+			streamRequestIDInterceptor,
+			streamTracingInterceptor(tracer),
+			streamLoggingInterceptor(logger, static, out, opts.Compression),
+		),
+	}
+	if opts.Compression != "" {
+		dialOpts = append(dialOpts, grpc.WithStatsHandler(wireBytesHandler{}))
+	}
+	return dialOpts
+}
+
+// withRequestID は新しい request_id を outgoing metadata(x-request-id)に付けて返す。
+// DoWorkRequest.RequestId のように、メッセージにも同じ値を入れたい時に使う
+func withRequestID(ctx context.Context) (context.Context, string) {
+	requestID := uuid.New().String()
+	return metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, requestID), requestID
+}
+
+// outgoingRequestID は outgoing metadata の x-request-id を返す
+func outgoingRequestID(ctx context.Context) string {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if vals := md.Get(requestIDMetadataKey); len(vals) > 0 {
+		return vals[len(vals)-1]
+	}
+	return ""
+}
+
+func ensureRequestID(ctx context.Context) context.Context {
+	if outgoingRequestID(ctx) != "" {
+		return ctx
+	}
+	ctx, _ = withRequestID(ctx)
+	return ctx
+}
+
+func unaryRequestIDInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+	return invoker(ensureRequestID(ctx), method, req, reply, cc, callOpts...)
+}
+
+func streamRequestIDInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(ensureRequestID(ctx), desc, cc, method, callOpts...)
+}
+
+// clientSpanName は "/observability.grpcburner.v1.Burner/DoWork" を "grpc.client/Burner.DoWork" にする
+func clientSpanName(method string) string {
+	svc, m, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if i := strings.LastIndex(svc, "."); i >= 0 {
+		svc = svc[i+1:]
+	}
+	return "grpc.client/" + svc + "." + m
+}
+
+// spanCapture は呼び出しの span の SpanContext を受け取る CallOption(grpc.Peer / grpc.Trailer と同じ使い方)。
+// 送った trace_id とサーバーが受け取った値を比べる debug-echo のように、mode の関数から trace_id を参照する時に使う
+type spanCapture struct {
+	grpc.EmptyCallOption
+	sc *trace.SpanContext
+}
+
+func captureSpan(sc *trace.SpanContext) grpc.CallOption {
+	return spanCapture{sc: sc}
+}
+
+func setCapturedSpan(callOpts []grpc.CallOption, sc trace.SpanContext) {
+	for _, o := range callOpts {
+		if c, ok := o.(spanCapture); ok {
+			*c.sc = sc
+		}
+	}
+}
+
+// unaryTracingInterceptor は呼び出しごとに span を作り、otelgrpc の RPC span をその子にする。
+// bench / loadtest の iteration span の中で呼べば、その子になる
+func unaryTracingInterceptor(tracer trace.Tracer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		ctx, span := tracer.Start(ctx, clientSpanName(method))
+		defer span.End()
+		setCapturedSpan(callOpts, span.SpanContext())
+
+		start := time.Now()
+		var trailer metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(callOpts, grpc.Trailer(&trailer))...)
+		var failure error
+		if err == nil {
+			failure = observability.ResponseFailure(reply, observability.ResponseCategoryFromTrailer(trailer))
+		}
+		observability.RecordSpanResponse(span, err, failure, time.Since(start))
+		return err
+	}
+}
+
+// streamTracingInterceptor はストリームの終了(finishingStream)まで続く span を作る
+func streamTracingInterceptor(tracer trace.Tracer) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		sctx, span := tracer.Start(ctx, clientSpanName(method))
+		setCapturedSpan(callOpts, span.SpanContext())
+
+		start := time.Now()
+		cs, err := streamer(sctx, desc, cc, method, callOpts...)
+		if err != nil {
+			observability.RecordSpanResult(span, err, time.Since(start))
+			span.End()
+			return nil, err
+		}
+		return newFinishingStream(ctx, cs, desc, func(err error, st *streamStats) {
+			failure := st.firstFailure
+			if category := observability.ResponseCategoryFromTrailer(st.trailer); failure != nil && category != "" {
+				failure = apperrors.New(category, failure)
+			}
+			observability.RecordSpanResponse(span, err, failure, time.Since(start))
+			span.End()
+		}), nil
+	}
+}
+
+type quietKey struct{}
+
+// withoutCallLog は ctx で行う RPC の開始・終了ログを出さないようにする。
+// bench / loadtest のように呼び出しが多く、最後に集計をまとめて出す mode で使う(span は作る)
+func withoutCallLog(ctx context.Context) context.Context {
+	return context.WithValue(ctx, quietKey{}, true)
+}
+
+func isQuiet(ctx context.Context) bool {
+	v, _ := ctx.Value(quietKey{}).(bool)
+	return v
+}
+
+type logFieldsKey struct{}
+
+// withLogFields は ctx で行う RPC の開始・終了ログに、mode ごとのフィールド(work_mode など)を加える
+func withLogFields(ctx context.Context, kv ...any) context.Context {
+	prev, _ := ctx.Value(logFieldsKey{}).([]any)
+	return context.WithValue(ctx, logFieldsKey{}, append(slices.Clip(prev), kv...))
+}
+
+// callLogFields は開始・終了ログに共通のフィールド
+func callLogFields(ctx context.Context, method string, static []any) []any {
+	fields := []any{
+		"trace_id", trace.SpanFromContext(ctx).SpanContext().TraceID().String(),
+	}
+	fields = append(fields, static...)
+	fields = append(fields,
+		"method", method,
+		"request_id", outgoingRequestID(ctx),
+	)
+	extra, _ := ctx.Value(logFieldsKey{}).([]any)
+	return append(fields, extra...)
+}
+
+// trailerLogFields はサーバーが trailer で返した処理の内訳や打ち切りの理由をログフィールドにする
+func trailerLogFields(trailer metadata.MD) []any {
+	fields := []any{"server_timing", serverTiming(trailer)}
+	if v := trailer.Get(appserver.CPUWorkersGrantedTrailerKey); len(v) > 0 {
+		fields = append(fields, "cpu_workers_granted", v[0])
+	}
+	// サーバーがストリームの上限で打ち切った場合は、その理由を trailer から出す
+	if v := trailer.Get(appserver.StreamLimitTrailerKey); len(v) > 0 {
+		fields = append(fields, "stream_limit_exceeded", v[0])
+	}
+	// 失敗数(--abort-after-failures)で打ち切った場合は、その時点の失敗数と処理数を出す
+	if v := trailer.Get(appserver.AbortAfterFailuresTrailerKey); len(v) > 0 {
+		fields = append(fields, "aborted_after_failures", v[0])
+	}
+	// サーバーが同時の DoWork を 1 回の実行にまとめた場合は、役割とまとめた数を出す
+	if v := trailer.Get(appserver.CoalescedTrailerKey); len(v) > 0 {
+		fields = append(fields, "coalesced", v[0])
+	}
+	return fields
+}
+
+// logCallEnd は終了ログを出す。エラーなら apperrors のカテゴリ(error_category / error_reason)も出す
+func logCallEnd(logger *zap.SugaredLogger, msg string, fields []any, err error) {
+	if err != nil {
+		fields = append(fields, "error", err)
+		fields = append(fields, apperrors.LogFields(err)...)
+		logger.Errorw(msg, fields...)
+		return
+	}
+	logger.Infow(msg, fields...)
+}
+
+// compression が空でなければ(--compression)、その名前と圧縮後のバイト数も終了ログに出す
+func unaryLoggingInterceptor(logger *zap.SugaredLogger, static []any, out *resultWriter, compression string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		if isQuiet(ctx) {
+			return invoker(ctx, method, req, reply, cc, callOpts...)
+		}
+		fields := callLogFields(ctx, method, static)
+		logger.Infow("client request start", fields...)
+
+		var wire *wireBytes
+		if compression != "" {
+			ctx, wire = withWireBytes(ctx)
+		}
+		var trailer metadata.MD
+		ctx, attempts := withAttemptCounter(ctx)
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, append(callOpts, grpc.Trailer(&trailer))...)
+		latency := time.Since(start)
+
+		fields = append(fields,
+			"code", status.Code(err).String(),
+			"latency_ms", latency.Milliseconds(),
+			"bytes_out", messageSize(req),
+			"bytes_in", messageSize(reply),
+		)
+		fields = append(fields, compressionLogFields(compression, wire)...)
+		// --retries 指定時は、再試行を含めた試行回数を出す
+		if n := attempts.Load(); n > 0 {
+			fields = append(fields, "attempts", n)
+		}
+		fields = append(fields, trailerLogFields(trailer)...)
+		logCallEnd(logger, "client request end", fields, err)
+
+		r := newCallReport(ctx, method, err, latency, messageSize(req), messageSize(reply), trailer)
+		r.Attempts = int(attempts.Load())
+		out.addCall(r)
+		return err
+	}
+}
+
+func streamLoggingInterceptor(logger *zap.SugaredLogger, static []any, out *resultWriter, compression string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if isQuiet(ctx) {
+			return streamer(ctx, desc, cc, method, callOpts...)
+		}
+		fields := append(callLogFields(ctx, method, static), "stream_kind", streamKind(desc))
+		logger.Infow("client stream start", fields...)
+
+		var wire *wireBytes
+		if compression != "" {
+			ctx, wire = withWireBytes(ctx)
+		}
+
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			latency := time.Since(start)
+			fields = append(fields, "code", status.Code(err).String(), "latency_ms", latency.Milliseconds())
+			logCallEnd(logger, "client stream end", fields, err)
+
+			r := newCallReport(ctx, method, err, latency, 0, 0, nil)
+			r.Stream = &streamReport{Kind: streamKind(desc)}
+			out.addCall(r)
+			return nil, err
+		}
+		return newFinishingStream(ctx, cs, desc, func(err error, st *streamStats) {
+			latency := time.Since(start)
+			fields := append(fields,
+				"code", status.Code(err).String(),
+				"latency_ms", latency.Milliseconds(),
+				"sent", st.sent,
+				"received", st.received,
+				"bytes_out", st.bytesOut,
+				"bytes_in", st.bytesIn,
+				// Send がブロックした時間(フロー制御で待たされた時間を含む)。サーバーの受信が遅い時のバックプレッシャーを観察できる
+				"send_blocked_ms", durationMs(st.sendBlocked),
+				"send_blocked_max_ms", durationMs(st.sendBlockedMax),
+			)
+			fields = append(fields, compressionLogFields(compression, wire)...)
+			if st.failedMessages > 0 {
+				fields = append(fields, "failed_messages", st.failedMessages)
+			}
+			if st.summary != nil {
+				fields = append(fields,
+					"summary_total", st.summary.GetTotal(),
+					"summary_success", st.summary.GetSuccess(),
+					"summary_failed", st.summary.GetFailed(),
+				)
+			}
+			if st.trailer != nil {
+				fields = append(fields, trailerLogFields(st.trailer)...)
+			}
+			logCallEnd(logger, "client stream end", fields, err)
+
+			r := newCallReport(ctx, method, err, latency, st.bytesOut, st.bytesIn, st.trailer)
+			r.Stream = &streamReport{
+				Kind:             streamKind(desc),
+				Sent:             st.sent,
+				Received:         st.received,
+				FailedMessages:   st.failedMessages,
+				SendBlockedMs:    durationMs(st.sendBlocked),
+				SendBlockedMaxMs: durationMs(st.sendBlockedMax),
+			}
+			out.addCall(r)
+		}), nil
+	}
+}
+
+func newCallReport(ctx context.Context, method string, err error, latency time.Duration, bytesOut, bytesIn int, trailer metadata.MD) callReport {
+	r := callReport{
+		TraceID:      trace.SpanFromContext(ctx).SpanContext().TraceID().String(),
+		Method:       method,
+		RequestID:    outgoingRequestID(ctx),
+		Code:         status.Code(err).String(),
+		LatencyMs:    durationMs(latency),
+		BytesOut:     bytesOut,
+		BytesIn:      bytesIn,
+		ServerTiming: serverTiming(trailer),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func streamKind(desc *grpc.StreamDesc) string {
+	switch {
+	case desc.ClientStreams && desc.ServerStreams:
+		return "bidi"
+	case desc.ClientStreams:
+		return "client"
+	default:
+		return "server"
+	}
+}
+
+func messageSize(m any) int {
+	if pm, ok := m.(proto.Message); ok {
+		return proto.Size(pm)
+	}
+	return 0
+}
+
+// workSummary は client streaming / progress stream が返す集計
+type workSummary interface {
+	GetTotal() int32
+	GetSuccess() int32
+	GetFailed() int32
+}
+
+// streamStats はストリームの終了時点での送受信の集計
+type streamStats struct {
+	sent, received    int
+	bytesOut, bytesIn int
+	sendBlocked       time.Duration
+	sendBlockedMax    time.Duration
+	// failedMessages は受信したメッセージのうち、ok=false や失敗を含む集計だったものの数
+	failedMessages int
+	// firstFailure は最初に失敗を示したメッセージの内容(observability.ResponseFailure)。span のエラーに使う。
+	// カテゴリはストリームの終了後に trailer(observability.ResponseCategoryTrailerKey)で受け取る
+	firstFailure error
+	// summary は最後に受信した集計(DoWorkSummary)。集計を返さないストリームでは nil
+	summary workSummary
+	// trailer はサーバーがストリームを閉じた後(EOF / エラー)にだけ入る
+	trailer metadata.MD
+}
+
+// finishingStream はストリームの終了(EOF / エラー / client streaming の応答 / 呼び出し元の ctx の終了)を受け取った時点で
+// onFinish を 1 回だけ呼ぶ。送受信は別の goroutine から行われることがある(progress stream)ため、集計は mu で守る
+type finishingStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	onFinish      func(error, *streamStats)
+
+	mu    sync.Mutex
+	stats streamStats
+	once  sync.Once
+	stop  func() bool
+}
+
+// newFinishingStream は cs を包んで返す。最後まで受信されずに放置されたストリームも、ctx の終了で onFinish を呼ぶ
+func newFinishingStream(ctx context.Context, cs grpc.ClientStream, desc *grpc.StreamDesc, onFinish func(error, *streamStats)) *finishingStream {
+	s := &finishingStream{ClientStream: cs, serverStreams: desc.ServerStreams, onFinish: onFinish}
+	s.stop = context.AfterFunc(ctx, func() {
+		s.finish(status.FromContextError(ctx.Err()).Err(), false)
+	})
+	return s
+}
+
+func (s *finishingStream) SendMsg(m any) error {
+	start := time.Now()
+	err := s.ClientStream.SendMsg(m)
+	blocked := time.Since(start)
+
+	s.mu.Lock()
+	s.stats.sendBlocked += blocked
+	s.stats.sendBlockedMax = max(s.stats.sendBlockedMax, blocked)
+	if err == nil {
+		s.stats.sent++
+		s.stats.bytesOut += messageSize(m)
+	}
+	s.mu.Unlock()
+
+	// io.EOF はサーバーがストリームを終了したことを示すだけで、実際のステータスは RecvMsg で受け取る
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.finish(err, true)
+	}
+	return err
+}
+
+func (s *finishingStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		s.mu.Lock()
+		s.stats.received++
+		s.stats.bytesIn += messageSize(m)
+		if f := observability.ResponseFailure(m, ""); f != nil {
+			s.stats.failedMessages++
+			if s.stats.firstFailure == nil {
+				s.stats.firstFailure = f
+			}
+		}
+		if sum, ok := m.(workSummary); ok {
+			s.stats.summary = sum
+		}
+		s.mu.Unlock()
+		if !s.serverStreams {
+			// client streaming は応答を受け取った時点で終わっている
+			s.finish(nil, true)
+		}
+	case errors.Is(err, io.EOF):
+		s.finish(nil, true)
+	default:
+		s.finish(err, true)
+	}
+	return err
+}
+
+func (s *finishingStream) finish(err error, closed bool) {
+	s.once.Do(func() {
+		s.stop()
+		s.mu.Lock()
+		st := s.stats
+		s.mu.Unlock()
+		if closed {
+			st.trailer = s.ClientStream.Trailer()
+		}
+		s.onFinish(err, &st)
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/keepalive"
+)
+
+// minKeepaliveTime は gRPC が受け付ける keepalive ping の最短の間隔。これより短い値は黙って 10s に切り上げられるため、エラーにする
+const minKeepaliveTime = 10 * time.Second
+
+// defaultKeepaliveTimeout は gRPC の既定の keepalive.ClientParameters.Timeout
+const defaultKeepaliveTimeout = 20 * time.Second
+
+// newKeepaliveParams は --keepalive-time / --keepalive-timeout / --keepalive-permit-without-stream から
+// HTTP/2 の keepalive ping の設定を組み立てる。途中の NAT やロードバランサーのアイドルタイムアウトで、
+// 長く開いたまま通信の間が空く双方向ストリーム(soak など)が切られないようにするために使う。
+// --keepalive-time が 0(既定)なら ping を送らない(gRPC の既定と同じ)
+func newKeepaliveParams(kaTime, kaTimeout time.Duration, permitWithoutStream bool) (keepalive.ClientParameters, error) {
+	switch {
+	case kaTime < 0 || (kaTime > 0 && kaTime < minKeepaliveTime):
+		return keepalive.ClientParameters{}, fmt.Errorf("keepalive-time must be 0 (off) or >= %s, got %s", minKeepaliveTime, kaTime)
+	case kaTimeout <= 0:
+		return keepalive.ClientParameters{}, fmt.Errorf("keepalive-timeout must be > 0, got %s", kaTimeout)
+	case kaTime == 0 && permitWithoutStream:
+		return keepalive.ClientParameters{}, errors.New("--keepalive-permit-without-stream requires --keepalive-time")
+	}
+	return keepalive.ClientParameters{
+		Time:                kaTime,
+		Timeout:             kaTimeout,
+		PermitWithoutStream: permitWithoutStream,
+	}, nil
+}
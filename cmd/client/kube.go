@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/kube"
+)
+
+const kubeResolverScheme = "kube"
+
+// kubeDialOptions は --kube-service の Service を Kubernetes API で Pod のアドレスへ解決し、
+// それらを直接の接続先にする resolver と、どの Pod が応答したかを記録する interceptor を返す。
+// opts.Addr は "kube:///<namespace>/<name>" に差し替える
+func kubeDialOptions(ctx context.Context, opts *options, logger *zap.SugaredLogger) ([]grpc.DialOption, error) {
+	ref, err := kube.ParseServiceRef(opts.KubeService)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := kube.LoadConfig(opts.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Namespace == "" {
+		ref.Namespace = cfg.Namespace
+	}
+
+	endpoints, err := kube.NewClient(cfg).ServiceEndpoints(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make(map[string]string, len(endpoints))
+	addrs := make([]resolver.Address, 0, len(endpoints))
+	for _, ep := range endpoints {
+		pods[ep.Addr] = ep.Pod
+		opts.Targets = append(opts.Targets, ep.Addr)
+		addrs = append(addrs, resolver.Address{Addr: ep.Addr})
+		logger.Infow("kube endpoint resolved",
+			"service", ref.String(),
+			"addr", ep.Addr,
+			"pod", ep.Pod,
+		)
+	}
+
+	r := manual.NewBuilderWithScheme(kubeResolverScheme)
+	r.InitialState(resolver.State{Addresses: addrs})
+	opts.Addr = fmt.Sprintf("%s:///%s/%s", kubeResolverScheme, ref.Namespace, ref.Name)
+
+	return []grpc.DialOption{
+		grpc.WithResolvers(r),
+		grpc.WithChainUnaryInterceptor(unaryPodLoggingInterceptor(pods, logger)),
+		grpc.WithChainStreamInterceptor(streamPodLoggingInterceptor(pods, logger)),
+	}, nil
+}
+
+// unaryPodLoggingInterceptor は応答した Pod をログに出し、Pod ごとの差を比較できるようにする
+func unaryPodLoggingInterceptor(pods map[string]string, logger *zap.SugaredLogger) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		var p peer.Peer
+		err := invoker(ctx, method, req, reply, cc, append(callOpts, grpc.Peer(&p))...)
+		logPod(logger, pods, method, p.Addr, err)
+		return err
+	}
+}
+
+// streamPodLoggingInterceptor はストリーム確立時点で割り当てられた Pod をログに出す
+func streamPodLoggingInterceptor(pods map[string]string, logger *zap.SugaredLogger) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			logPod(logger, pods, method, nil, err)
+			return nil, err
+		}
+		var addr fmt.Stringer
+		if p, ok := peer.FromContext(cs.Context()); ok {
+			addr = p.Addr
+		}
+		logPod(logger, pods, method, addr, nil)
+		return cs, nil
+	}
+}
+
+func logPod(logger *zap.SugaredLogger, pods map[string]string, method string, addr fmt.Stringer, err error) {
+	if addr == nil {
+		logger.Debugw("kube rpc without peer", "method", method, "error", err)
+		return
+	}
+	logger.Infow("kube rpc routed",
+		"method", method,
+		"addr", addr.String(),
+		"pod", pods[addr.String()],
+		"error", err,
+	)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// loadtestSummary は "client loadtest end" ログと標準出力に出す、全体の結果
+type loadtestSummary struct {
+	TargetRPS   float64 `json:"target_rps"`
+	AchievedRPS float64 `json:"achieved_rps"`
+	Sent        int     `json:"sent"`
+	Failed      int     `json:"failed"`
+	// Skipped は --rps の時刻が来た時に全てのワーカーが呼び出し中で、送れなかった回数。
+	// 0 より大きければ --concurrency が足りず、目標の RPS を出せていない
+	Skipped    int     `json:"skipped"`
+	DurationMs float64 `json:"duration_ms"`
+	P50Ms      float64 `json:"p50_ms"`
+	P95Ms      float64 `json:"p95_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	MaxMs      float64 `json:"max_ms"`
+}
+
+// loadtestResult は --output=json に載せる loadtest の結果
+type loadtestResult struct {
+	Interrupted bool            `json:"interrupted"`
+	Summary     loadtestSummary `json:"summary"`
+	Arms        []armSummary    `json:"arms"`
+}
+
+// callLoadtest は --duration の間、--rps の一定間隔で --mix の呼び出しを送る(open loop)。
+// 呼び出しは --concurrency 個のワーカーが受け持ち、時刻が来た時に空いているワーカーがなければ送らずに skipped に数える。
+// 前の呼び出しの完了を待つ bench(closed loop)と違い、サーバーが遅くなっても送る間隔が変わらないため、
+// シェルのループで回さなくても一定のトラフィックを流し続けられる。--rps=0 では各ワーカーが間を空けずに呼び出す。
+// Ctrl+C で止めた場合もそれまでの集計を出す
+func callLoadtest(conn *grpc.ClientConn, opts *options, directOpts []grpc.DialOption) (retErr error) {
+	logger := observability.NewLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	// 呼び出しごとのログは出さず、最後に全体と種類ごとの集計をまとめて出す
+	ctx, stop := signal.NotifyContext(withoutCallLog(context.Background()), os.Interrupt)
+	defer stop()
+
+	tracer := otel.Tracer("cno-app-client")
+	ctx, span := tracer.Start(ctx, "grpc.client/Loadtest")
+	defer span.End()
+	spanStart := time.Now()
+	defer func() {
+		observability.RecordSpanResult(span, retErr, time.Since(spanStart))
+	}()
+
+	traceID := span.SpanContext().TraceID().String()
+
+	// 接続先の異常や接続の確立にかかる時間を、計測する呼び出しの統計に混ぜない
+	if opts.Precheck {
+		if err := benchPrecheck(ctx, tracer, conn, opts, directOpts, logger); err != nil {
+			return err
+		}
+	}
+	if opts.Warmup > 0 || opts.WarmupDuration > 0 {
+		if err := benchWarmup(ctx, tracer, conn, opts, logger); err != nil {
+			return err
+		}
+	}
+
+	configs, err := mixWorkConfigs(opts)
+	if err != nil {
+		return err
+	}
+	rep32, err := mustInt32("repeat", opts.Repeat)
+	if err != nil {
+		return err
+	}
+
+	// 呼び出し種別はディスパッチャーだけが選ぶため、--seed を指定すれば並びが毎回同じになる
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	span.SetAttributes(
+		attribute.Int64("bench.seed", seed),
+		attribute.Float64("loadtest.target_rps", opts.RPS),
+		attribute.Int("loadtest.concurrency", opts.Concurrency),
+	)
+
+	mixNames := make([]string, len(opts.Mix))
+	for i, arm := range opts.Mix {
+		mixNames[i] = arm.Name + "=" + strconv.Itoa(arm.Weight)
+	}
+	logger.Infow("client loadtest start",
+		"trace_id", traceID,
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"mix", strings.Join(mixNames, ","),
+		"rps", opts.RPS,
+		"concurrency", opts.Concurrency,
+		"duration", opts.Duration.String(),
+		"seed", seed,
+		"work_mode", opts.WorkMode,
+	)
+
+	b := &benchCaller{
+		burner: grpcburnerv1.NewBurnerClient(conn),
+		health: healthpb.NewHealthClient(conn),
+		repeat: rep32,
+	}
+	stats := make([]armStats, len(opts.Mix))
+	for i := range stats {
+		stats[i].codes = map[string]int{}
+	}
+
+	// slots は呼び出し中のワーカーの数。呼び出しが終わった時点で空くため、空きがなければ送らずに skipped に数える
+	slots := make(chan struct{}, opts.Concurrency)
+	jobs := make(chan int, opts.Concurrency)
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+		n  int
+	)
+	for u := 0; u < opts.Concurrency; u++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for armIdx := range jobs {
+				mu.Lock()
+				i := n
+				n++
+				mu.Unlock()
+				arm := opts.Mix[armIdx]
+
+				// 呼び出しごとに別トレースとし、run のルート span へリンクする。
+				// Ctrl+C で止めた時も実行中の呼び出しは最後まで待つ
+				ictx, ispan := observability.StartIterationSpan(context.WithoutCancel(ctx), tracer, "grpc.client/Loadtest.iteration", i)
+				ispan.SetAttributes(attribute.String("bench.arm", arm.Name))
+				cctx, cancel := context.WithTimeout(ictx, opts.Timeout)
+				istart := time.Now()
+				err := b.call(cctx, arm.Mode, configs[armIdx])
+				elapsed := time.Since(istart)
+				cancel()
+				observability.RecordSpanResult(ispan, err, elapsed)
+				ispan.End()
+
+				mu.Lock()
+				stats[armIdx].latencies = append(stats[armIdx].latencies, elapsed)
+				stats[armIdx].codes[status.Code(err).String()]++
+				mu.Unlock()
+				<-slots
+			}
+		}()
+	}
+
+	start := time.Now()
+	skipped := dispatchLoadtest(ctx, slots, jobs, opts, rng, start)
+	window := time.Since(start)
+	// 実行中の呼び出しを待つ間に 2 回目の Ctrl+C で強制終了できるよう、シグナルの扱いを既定に戻す
+	stop()
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	interrupted := ctx.Err() != nil
+	summaries := make([]armSummary, len(opts.Mix))
+	var all []time.Duration
+	for i, arm := range opts.Mix {
+		all = append(all, stats[i].latencies...)
+		summaries[i] = summarizeArm(arm.Name, stats[i])
+	}
+	total := summarizeLoadtest(summaries, all, skipped, opts.RPS, window, elapsed)
+
+	fields := []any{
+		"trace_id", traceID,
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"interrupted", interrupted,
+		"summary", total,
+		"arms", summaries,
+	}
+	if total.Failed > 0 {
+		logger.Errorw("client loadtest end", fields...)
+	} else if total.Skipped > 0 {
+		logger.Warnw("client loadtest end", fields...)
+	} else {
+		logger.Infow("client loadtest end", fields...)
+	}
+
+	opts.Out.setResult(loadtestResult{Interrupted: interrupted, Summary: total, Arms: summaries})
+	for _, s := range summaries {
+		opts.Out.printf("loadtest: arm=%s count=%d failed=%d p50=%.1fms p95=%.1fms p99=%.1fms max=%.1fms\n",
+			s.Arm, s.Count, s.Failed, s.P50Ms, s.P95Ms, s.P99Ms, s.MaxMs)
+	}
+	opts.Out.printf("loadtest: duration=%s target_rps=%s achieved_rps=%.1f sent=%d failed=%d skipped=%d p50=%.1fms p95=%.1fms p99=%.1fms max=%.1fms\n",
+		elapsed.Round(time.Millisecond), formatRPS(total.TargetRPS), total.AchievedRPS, total.Sent, total.Failed, total.Skipped,
+		total.P50Ms, total.P95Ms, total.P99Ms, total.MaxMs)
+
+	if total.Failed > 0 {
+		return fmt.Errorf("loadtest: %d/%d requests failed", total.Failed, total.Sent)
+	}
+	return nil
+}
+
+// dispatchLoadtest は --duration が過ぎるか ctx が終わるまで、slots の空きを取ってから呼び出し種別を jobs に送り、
+// 空きがなくて送れなかった回数を返す。送る時刻は start からの i 回目 × 間隔で決め、
+// ディスパッチャーが遅れても次の時刻までに追いつく(間隔の誤差を積み重ねない)
+func dispatchLoadtest(ctx context.Context, slots chan<- struct{}, jobs chan<- int, opts *options, rng *rand.Rand, start time.Time) (skipped int) {
+	end := time.NewTimer(time.Until(start.Add(opts.Duration)))
+	defer end.Stop()
+
+	if opts.RPS <= 0 {
+		for {
+			select {
+			case slots <- struct{}{}:
+				jobs <- opts.Mix.pick(rng)
+			case <-end.C:
+				return 0
+			case <-ctx.Done():
+				return 0
+			}
+		}
+	}
+
+	interval := time.Duration(float64(time.Second) / opts.RPS)
+	tick := time.NewTimer(0)
+	defer tick.Stop()
+	for i := 1; ; i++ {
+		select {
+		case <-tick.C:
+		case <-end.C:
+			return skipped
+		case <-ctx.Done():
+			return skipped
+		}
+		select {
+		case slots <- struct{}{}:
+			jobs <- opts.Mix.pick(rng)
+		default:
+			skipped++
+		}
+		tick.Reset(time.Until(start.Add(time.Duration(i) * interval)))
+	}
+}
+
+// summarizeLoadtest は種類ごとの結果と全ての呼び出しのレイテンシから全体の結果を計算する。
+// achieved_rps は送っていた時間(window)で割り、最後の呼び出しの完了を待った時間を含めない
+func summarizeLoadtest(arms []armSummary, latencies []time.Duration, skipped int, targetRPS float64, window, elapsed time.Duration) loadtestSummary {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	s := loadtestSummary{
+		TargetRPS:  targetRPS,
+		Sent:       len(latencies),
+		Skipped:    skipped,
+		DurationMs: durationMs(elapsed),
+		P50Ms:      percentileMs(latencies, 0.50),
+		P95Ms:      percentileMs(latencies, 0.95),
+		P99Ms:      percentileMs(latencies, 0.99),
+	}
+	if n := len(latencies); n > 0 {
+		s.MaxMs = durationMs(latencies[n-1])
+	}
+	if window > 0 {
+		s.AchievedRPS = float64(s.Sent) / window.Seconds()
+	}
+	for _, a := range arms {
+		s.Failed += a.Failed
+	}
+	return s
+}
+
+// formatRPS は --rps を表示用に整える。0 は上限なし
+func formatRPS(rps float64) string {
+	if rps <= 0 {
+		return "unlimited"
+	}
+	return strconv.FormatFloat(rps, 'f', -1, 64)
+}
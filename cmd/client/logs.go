@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/logquery"
+)
+
+const (
+	envLokiURL          = "CNO_APP_LOKI_URL"
+	defaultLokiURL      = "http://localhost:3100"
+	envLokiSelector     = "CNO_APP_LOKI_SELECTOR"
+	defaultLokiSelector = `{service_name=~".+"}`
+)
+
+// runLogs は "client logs" サブコマンド。
+// 実行結果のログに出た request_id または trace_id で Loki を検索し、一致したサーバー/クライアントのログ行を時刻順に出力する
+func runLogs(args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+
+	requestID := fs.String("request-id", "", "request_id to search for")
+	traceID := fs.String("trace-id", "", "trace_id to search for")
+	lokiURL := fs.String("loki-url", getenvOrDefault(envLokiURL, defaultLokiURL), "Loki HTTP API base URL (env "+envLokiURL+")")
+	selector := fs.String("selector", getenvOrDefault(envLokiSelector, defaultLokiSelector), "LogQL stream selector for the server/client logs (env "+envLokiSelector+")")
+	since := fs.Duration("since", time.Hour, "how far back to search")
+	limit := fs.Int("limit", 1000, "maximum number of lines fetched from Loki")
+	raw := fs.Bool("raw", false, "print only the log lines (no timestamp/source prefix)")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout for the Loki query")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	q := logquery.Query{Selector: *selector, Limit: *limit}
+	switch {
+	case *requestID != "" && *traceID != "":
+		return errors.New("logs: specify only one of --request-id and --trace-id")
+	case *requestID != "":
+		q.Field, q.Value = "request_id", *requestID
+	case *traceID != "":
+		q.Field, q.Value = "trace_id", *traceID
+	default:
+		return errors.New("logs: --request-id or --trace-id is required")
+	}
+	if *since <= 0 {
+		return fmt.Errorf("logs: --since must be > 0, got %s", *since)
+	}
+	q.End = time.Now()
+	q.Start = q.End.Add(-*since)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	entries, err := logquery.NewClient(*lokiURL).Search(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if *raw {
+			fmt.Println(e.Line)
+			continue
+		}
+		fmt.Printf("%s %-16s %s\n", e.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00"), e.Source(), e.Line)
+	}
+	fmt.Fprintf(os.Stderr, "logs: %d lines matched %s=%s (query: %s)\n", len(entries), q.Field, q.Value, q.LogQL())
+	return nil
+}
@@ -2,24 +2,31 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/clientconfig"
 	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/scenario"
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
 	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -36,34 +43,163 @@ type options struct {
 	Latency      time.Duration
 	ErrorRate    float64
 	Repeat       int
+	Streams      int
+	Instances    int
+	RunID        string
+	Insecure     bool
+	ServerName   string
+	// CACert / ClientCert / ClientKey は --ca-cert / --client-cert / --client-key で指定する PEM ファイル
+	CACert      string
+	ClientCert  string
+	ClientKey   string
+	FetchConfig bool
+	KubeService string
+	Kubeconfig  string
+	// Headers は --header-from-file で読み込んだ metadata と --metadata で指定した metadata を合わせた、全 RPC に付与する metadata
+	Headers metadata.MD
+	// ConnectParams は --backoff-* / --min-connect-timeout で指定する再接続のバックオフ設定
+	ConnectParams grpc.ConnectParams
+	// Keepalive は --keepalive-* で指定する keepalive ping の設定。Time が 0 なら ping を送らない
+	Keepalive keepalive.ClientParameters
+	// Compression は --compression で全ての RPC に使う圧縮方式(gzip / zstd)。空なら圧縮しない
+	Compression string
+	// Retry は --retries / --retry-* で指定する Unary の再試行
+	Retry retryPolicy
+
+	DependencyLatency   time.Duration
+	DependencyTimeout   time.Duration
+	DependencyOnTimeout string
+
+	// ResponsePaddingBytes は DoWork 系のレスポンスをサーバーに水増ししてもらう目標サイズ。0 なら水増ししない
+	ResponsePaddingBytes int
+
+	// ErrorScope はストリーミング系で error_rate をメッセージごと(message)とストリームごと(stream)のどちらで適用してもらうか。空ならサーバーの既定
+	ErrorScope string
+	// AbortAfterFailures はストリーミング系でこの数のメッセージが失敗したらサーバーにストリームを打ち切ってもらう。0 なら打ち切らない
+	AbortAfterFailures int
+	// FailAfter は負荷を開始してからこの時間でサーバーに失敗してもらう(途中での失敗注入)。0 なら無効
+	FailAfter time.Duration
+	// ReturnCode / ReturnCodeDelay / ReturnCodeMessage は return-code モードでサーバーに返してもらうステータス、応答までの遅延、メッセージ
+	ReturnCode        codes.Code
+	ReturnCodeDelay   time.Duration
+	ReturnCodeMessage string
+
+	// CPUAffinity はサーバーに CPU ワーカーを固定してもらうコアの番号(カンマ区切り)。空なら固定しない
+	CPUAffinity string
+	// CPUShare は parallelism の代わりにサーバーの CPU ワーカー枠に対する割合(0 < share <= 1)で CPU ワーカー数を頼む。0 なら parallelism を使う
+	CPUShare float64
+	// IOCache は io モードでページキャッシュに当てる(warm)か外す(cold)か。空ならサーバーの既定(warm)
+	IOCache string
+	// ElevationToken はサーバーの admin が発行した、1 回の RPC に限って上限を引き上げる token(x-elevation-token)
+	ElevationToken string
+
+	// RequestPaddingBytes は do-work-client で送る 1 メッセージを水増しする目標サイズ。0 なら水増ししない
+	RequestPaddingBytes int
+	// SummaryEvery が 0 より大きい時、do-work-client は途中経過を返す BurnerProgress を使い、この間隔で累計を受け取る
+	SummaryEvery int
+	// RecvDelay は do-work-client でサーバーに 1 メッセージごとの受信を遅らせてもらう時間(遅い consumer の模擬)
+	RecvDelay time.Duration
+	// MessageSpans はストリーミング系で 1 メッセージごとに記録する方法(span|event|off)
+	MessageSpans string
+
+	// Mix / Requests / Concurrency は bench モードの traffic mix と総呼び出し数・仮想ユーザー数(loadtest ではワーカー数)
+	Mix         trafficMix
+	Requests    int
+	Concurrency int
+	// ThinkTime は bench の仮想ユーザーが次の呼び出しまで待つ時間の分布
+	ThinkTime scenario.ThinkTime
+	// Warmup は bench の計測前に送る(統計に含めない)Ping の回数。
+	// WarmupDuration は --warmup に時間を指定した時の、計測前に traffic mix を送る(統計に含めない)時間
+	Warmup         int
+	WarmupDuration time.Duration
+	// Precheck が true なら bench の計測前に全ての接続先へ Health Check を送り、SERVING でなければ開始しない
+	Precheck bool
+	// Targets は --kube-service で解決した Pod のアドレス。bench の precheck で Pod ごとに確認するために使う
+	Targets []string
+	// Seed は bench のクライアント側の乱数(traffic mix の選択、think time)のシード。0 なら実行ごとに変える
+	Seed int64
+
+	// RPS / Duration は loadtest モードで呼び出しを送る間隔(1 秒あたりの回数、0 なら上限なし)と送り続ける時間
+	RPS      float64
+	Duration time.Duration
+
+	// SoakReportEvery は soak モードで集計をログと標準出力に出す間隔
+	SoakReportEvery time.Duration
+
+	// RunFor は --run-for で指定する、1 回の呼び出しの mode を繰り返し続ける時間(0 なら 1 回だけ)
+	RunFor time.Duration
+
+	// BroadcastAddrs / StartDelay は broadcast モードで負荷を送る接続先と、同時に開始する時刻までの猶予
+	BroadcastAddrs []string
+	StartDelay     time.Duration
+
+	// Scenario は --scenario で読み込んだ、scenario モードで順番に実行するステップ
+	Scenario *scenario.Scenario
+	// ScenarioState は --scenario-state で指定する、進み具合を書き出すファイル。
+	// Resume はそのファイルから読み込んだ中断した実行の状態で、最初から実行する場合は nil
+	ScenarioState string
+	Resume        *scenarioState
+
+	// Expect は終了コードを決める合格条件(--expect-code / --max-latency-ms / --min-success-rate)
+	Expect expectations
+
+	// Output は標準出力に出す結果の形式(text|json)。Out は run で作る、その出力先
+	Output string
+	Out    *resultWriter
 }
 
 const (
 	defaultAddr    = "localhost:8080"
-	defaultTimeout = "3s"
+	defaultTimeout = "auto"
 
-	envAddr    = "CNO_APP_CLIENT_ADDR"
-	envTimeout = "CNO_APP_CLIENT_TIMEOUT"
-	envMode    = "CNO_APP_CLIENT_MODE"
-	envPayload = "CNO_APP_CLIENT_PAYLOAD"
+	// timeout=auto の時に使う値
+	baseTimeout   = 3 * time.Second
+	timeoutMargin = 2 * time.Second
+
+	envAddr     = "CNO_APP_CLIENT_ADDR"
+	envTimeout  = "CNO_APP_CLIENT_TIMEOUT"
+	envMode     = "CNO_APP_CLIENT_MODE"
+	envPayload  = "CNO_APP_CLIENT_PAYLOAD"
+	envInsecure = "CNO_APP_CLIENT_INSECURE"
 )
 
 func main() {
-	if err := run(); err != nil {
+	var sub string
+	if len(os.Args) > 1 {
+		sub = os.Args[1]
+	}
+	var err error
+	switch sub {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "trace-report":
+		err = runTraceReport(os.Args[2:])
+	case "logs":
+		err = runLogs(os.Args[2:])
+	default:
+		err = run()
+	}
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
 		fmt.Fprintln(os.Stderr, "client error:", err)
-		os.Exit(1)
 	}
+	os.Exit(exitCode(err))
 }
 
 func run() error {
 	opts, err := parseOptions()
 	if err != nil {
-		return err
+		return usageError{err: err}
+	}
+
+	// 1 回の実行を識別する run_id。全 span に属性として付与する(中断したシナリオの再開では同じ run_id を使う)
+	opts.RunID = uuid.New().String()
+	if opts.Resume != nil {
+		opts.RunID = opts.Resume.RunID
 	}
 
 	// TracerProviderをクライアント用に初期化
 	ctx := context.Background()
-	shutdown, err := observability.InitClientTracerProvider(ctx)
+	shutdown, err := observability.InitClientTracerProvider(ctx, observability.WithRunID(opts.RunID))
 	if err != nil {
 		return fmt.Errorf("init client tracer provider: %w", err)
 	}
@@ -77,11 +213,108 @@ func run() error {
 	// 今後Dowork/Ping呼び出しに差し替えるまで「proto依存」にしておく
 	_ = grpcburnerv1.PingRequest{}
 
-	conn, err := grpc.NewClient(
-		opts.Addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	connLogger := observability.NewLogger()
+	defer func() {
+		_ = connLogger.Sync()
+	}()
+	creds, err := transportCredentials(opts, connLogger)
+	if err != nil {
+		return err
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
 		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
-	)
+		grpc.WithConnectParams(opts.ConnectParams),
+	}
+	if !isDefaultConnectParams(opts.ConnectParams) {
+		connLogger.Infow("connect backoff configured",
+			"base_delay", opts.ConnectParams.Backoff.BaseDelay.String(),
+			"max_delay", opts.ConnectParams.Backoff.MaxDelay.String(),
+			"multiplier", opts.ConnectParams.Backoff.Multiplier,
+			"jitter", opts.ConnectParams.Backoff.Jitter,
+			"min_connect_timeout", opts.ConnectParams.MinConnectTimeout.String(),
+		)
+	}
+	if opts.Keepalive.Time > 0 {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(opts.Keepalive))
+		connLogger.Infow("client keepalive configured",
+			"keepalive_time", opts.Keepalive.Time.String(),
+			"keepalive_timeout", opts.Keepalive.Timeout.String(),
+			"permit_without_stream", opts.Keepalive.PermitWithoutStream,
+		)
+	}
+	if opts.Compression != "" {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.UseCompressor(opts.Compression)))
+		connLogger.Infow("client compression configured", "compression", opts.Compression)
+	}
+	dialOpts = append(dialOpts, runMetadataDialOptions(opts)...)
+	opts.Out = newResultWriter(opts)
+	dialOpts = append(dialOpts, instrumentationDialOptions(opts, connLogger, opts.Out)...)
+	if len(opts.Headers) > 0 {
+		connLogger.Infow("attaching custom metadata", "keys", headerKeys(opts.Headers))
+	}
+
+	// 合格条件を指定した時は全ての RPC の結果を記録し、mode の結果の代わりに判定に使う
+	var recorder *callRecorder
+	if opts.Expect.enabled() {
+		recorder = &callRecorder{}
+		dialOpts = append(dialOpts, recorder.dialOptions()...)
+	}
+	if opts.Retry.enabled() {
+		connLogger.Infow("client retry configured",
+			"retries", opts.Retry.Retries,
+			"backoff", opts.Retry.Backoff.String(),
+			"max_backoff", opts.Retry.MaxBackoff.String(),
+			"codes", opts.Retry.codeNames(),
+		)
+		dialOpts = append(dialOpts, opts.Retry.dialOptions(connLogger, opts.Out)...)
+	}
+
+	// bench の precheck や broadcast で Pod に直接接続する時に使う(resolver や負荷分散の設定を含まない)
+	directOpts := slices.Clip(dialOpts)
+
+	// 複数の接続先に振り分ける時は、どのレプリカが応答したかを接続先ごとに集計する
+	multiTarget := opts.KubeService != "" || isMultiTarget(opts.Addr)
+	var targets *targetRecorder
+	if multiTarget {
+		targets = newTargetRecorder()
+		dialOpts = append(dialOpts, targets.dialOptions()...)
+	}
+
+	// --kube-service 指定時は Service の背後の Pod を直接解決し、opts.Addr を差し替える。
+	// カンマ区切りの --addr も同じく各アドレスを直接の接続先にする
+	var resolveOpts []grpc.DialOption
+	if opts.KubeService != "" {
+		resolveOpts, err = kubeDialOptions(ctx, opts, connLogger)
+		if err != nil {
+			return err
+		}
+	} else {
+		resolveOpts = multiAddrDialOptions(opts, connLogger)
+	}
+	dialOpts = append(dialOpts, resolveOpts...)
+
+	var clientCfg clientconfig.Config
+	applyCfg := false
+	if opts.FetchConfig {
+		clientCfg, applyCfg = fetchClientConfig(opts, creds, resolveOpts, connLogger)
+	}
+	if multiTarget {
+		// ClusterIP の round-robin に隠れる Pod ごとの差や、レプリカごとの差を見るため、クライアント側で振り分ける
+		clientCfg.LoadBalancingPolicy = "round_robin"
+		applyCfg = true
+	}
+	if applyCfg {
+		cfgOpts, err := clientCfg.DialOptions()
+		if err != nil {
+			connLogger.Warnw("client config is invalid, using defaults", "addr", opts.Addr, "error", err)
+		} else {
+			dialOpts = append(dialOpts, cfgOpts...)
+		}
+	}
+
+	conn, err := grpc.NewClient(opts.Addr, dialOpts...)
 	if err != nil {
 		return fmt.Errorf("failed to dial %s: %w", opts.Addr, err)
 	}
@@ -89,11 +322,51 @@ func run() error {
 		_ = conn.Close()
 	}()
 
+	// 再接続や TRANSIENT_FAILURE をタイムアウトとしてではなく状態遷移として見えるようにする
+	watcher := observability.WatchConnectivity(conn, connLogger)
+	defer watcher.Stop()
+
+	start := time.Now()
+	if opts.RunFor > 0 {
+		err = callRunFor(conn, opts, directOpts)
+	} else {
+		err = callMode(conn, opts, directOpts)
+	}
+	if targets != nil {
+		reportTargets(targets, opts, connLogger)
+	}
+	if recorder != nil {
+		err = judgeExpectations(err, recorder, opts, connLogger)
+	}
+	return opts.Out.finish(err, time.Since(start))
+}
+
+// judgeExpectations は記録した呼び出しがあれば、mode の結果の代わりに合格条件で判定する
+func judgeExpectations(err error, recorder *callRecorder, opts *options, logger *zap.SugaredLogger) error {
+	calls := recorder.results()
+	if len(calls) == 0 {
+		// RPC を送る前に失敗した(設定の誤りなど)
+		return err
+	}
+	if err != nil {
+		logger.Infow("mode error is judged by expectations", "mode", opts.Mode, "error", err)
+	}
+	return opts.Expect.check(calls, logger, opts.Out)
+}
+
+// callMode は --mode の呼び出しを行う
+func callMode(conn *grpc.ClientConn, opts *options, directOpts []grpc.DialOption) error {
 	switch opts.Mode {
 	case "health", "":
 		return callHealth(conn, opts)
 	case "ping":
 		return callPing(conn, opts)
+	case "debug-echo":
+		return callDebugEcho(conn, opts)
+	case "return-code":
+		return callReturnCode(conn, opts)
+	case "channelz":
+		return callChannelz(conn, opts)
 	case "do-work-unary":
 		return callDoWorkUnary(conn, opts)
 	case "do-work-server":
@@ -102,6 +375,18 @@ func run() error {
 		return callDoWorkClientStreaming(conn, opts)
 	case "do-work-bidi":
 		return callDoWorkBidiStreaming(conn, opts)
+	case "stream-storm":
+		return callStreamStorm(conn, opts)
+	case "broadcast":
+		return callBroadcast(opts, directOpts)
+	case "bench":
+		return callBench(conn, opts, directOpts)
+	case "loadtest":
+		return callLoadtest(conn, opts, directOpts)
+	case "soak":
+		return callSoak(conn, opts)
+	case "scenario":
+		return callScenario(conn, opts)
 	default:
 		return fmt.Errorf("unsupported mode %q", opts.Mode)
 	}
@@ -112,43 +397,205 @@ func parseOptions() (*options, error) {
 	timeoutDefault := getenvOrDefault(envTimeout, defaultTimeout)
 	modeDefault := getenvOrDefault(envMode, "health")
 	payloadDefault := getenvOrDefault(envPayload, "")
+	insecureDefault := strings.EqualFold(os.Getenv(envInsecure), "true")
 
 	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 
-	addr := fs.String("addr", addrDefault, "gRPC server address (host:port)")
-	timeoutStr := fs.String("timeout", timeoutDefault, "request timeout (e.g. 3s, 500ms)")
-	mode := fs.String("mode", modeDefault, "client mode (health, ,ping, do-work-unary, do-work-server, do-work-client, do-work-bidi)")
+	addr := fs.String("addr", addrDefault, "gRPC server address (host:port). A comma-separated list or a dns:/// target is load-balanced with round_robin")
+	timeoutStr := fs.String("timeout", timeoutDefault, `request timeout (e.g. 3s, 500ms); "auto" derives it from work-duration, latency and repeat`)
+	mode := fs.String("mode", modeDefault, "client mode (health, ping, debug-echo, return-code, channelz, do-work-unary, do-work-server, do-work-client, do-work-bidi, stream-storm, broadcast, bench, loadtest, soak, scenario)")
 	payload := fs.String("payload", payloadDefault, "optional payload for future use")
-
-	workMode := fs.String("work-mode", "cpu", "work load mode (cpu, mem, cpu-mem, io)")
+	output := fs.String("output", outputText, "result format on stdout: human-readable lines (text) or a single JSON document per run (json); logs always go to stderr")
+	insecureFlag := fs.Bool("insecure", insecureDefault, "use plaintext instead of TLS (system cert pool)")
+	tlsFlag := fs.Bool("tls", false, "use TLS even when CNO_APP_CLIENT_INSECURE=true (TLS is the default otherwise)")
+	serverName := fs.String("server-name", "", "override TLS server name (SNI / certificate verification)")
+	caCert := fs.String("ca-cert", "", "PEM file of CA certificates to verify the server with, instead of the system cert pool")
+	clientCert := fs.String("client-cert", "", "PEM client certificate to present for mutual TLS (requires --client-key)")
+	clientKey := fs.String("client-key", "", "PEM private key for --client-cert")
+	fetchConfig := fs.Bool("fetch-config", true, "fetch recommended timeout/retry/message size settings from the server at startup")
+	kubeService := fs.String("kube-service", "", `resolve pods behind a Kubernetes Service ("[namespace/]name[:port]") and load balance across them directly; overrides --addr`)
+	kubeconfig := fs.String("kubeconfig", "", "kubeconfig path for --kube-service (default: in-cluster, then $KUBECONFIG, then ~/.kube/config)")
+
+	returnCode := fs.String("return-code", "UNAVAILABLE", `return-code: gRPC status the server should return, by name or number (e.g. "UNAVAILABLE", "deadline_exceeded", "14")`)
+	returnCodeDelay := fs.Duration("return-code-delay", 0, "return-code: how long the server waits before returning the status")
+	returnCodeMessage := fs.String("return-code-message", "", "return-code: status message (default: \"requested status <Code>\")")
+
+	workMode := fs.String("work-mode", "cpu", "work load mode (cpu, mem, cpu-mem, io, page-fault)")
 	workDuration := fs.Duration("work-duration", 3*time.Second, "duration for each work (e.g. 3s)")
-	allocMB := fs.Int("alloc-mb", 32, "memory allocation in MB for mem/cpu-mem mode")
+	allocMB := fs.Int("alloc-mb", 32, "memory allocation in MB for mem/cpu-mem/page-fault mode")
 	parallelism := fs.Int("parallelism", 1, "number of goroutines for cpu/cpu-mem mode")
 	ioBytes := fs.Int("io-bytes", 1024*64, "I/O bytes per loop for io mode")
 	latency := fs.Duration("latency", 0, "fixed latency per work (e.g. 200ms)")
 	errorRate := fs.Float64("error-rate", 0.0, "error rate between 0.0 and 1.0")
 
 	repeat := fs.Int("repeat", 3, "number of works for streaming modes")
+	runFor := fs.Duration("run-for", 0, "keep issuing the --mode call back to back until this much time has elapsed (e.g. 10m) for soak tests; Ctrl+C stops after the call in flight (0 calls once)")
+	streams := fs.Int("streams", 100, "number of concurrent streams for stream-storm mode")
+	instances := fs.Int("instances", 1, "number of parallel load runs within one do-work-unary request")
+	mix := fs.String("mix", defaultMix, `bench and loadtest: weighted call types as "mode[:work-duration]=weight,..." (modes: health, ping, do-work-unary, do-work-server, do-work-client, do-work-bidi)`)
+	requests := fs.Int("requests", 100, "bench: total number of calls")
+	concurrency := fs.Int("concurrency", 10, "bench: number of virtual users calling in a closed loop; loadtest: number of workers making calls")
+	rps := fs.Float64("rps", 10, "loadtest: target calls per second, sent at a fixed interval regardless of how long calls take (0 calls as fast as the workers allow); soak: messages per second on the stream (0 sends back to back)")
+	duration := fs.Duration("duration", 30*time.Second, "loadtest: how long to keep sending calls; soak: how long to keep the stream open (e.g. 6h)")
+	soakReportEvery := fs.Duration("soak-report-every", time.Minute, "soak: interval of the per-window count, failures, latency and drift report")
+	seed := fs.Int64("seed", 0, "bench and loadtest: seed for client-side randomness (traffic mix selection, think time); 0 picks a new seed per run, which is logged")
+	thinkTime := fs.String("think-time", "", `bench: wait between calls per virtual user: "200ms" (fixed), "exp:200ms" (exponential) or "normal:500ms:100ms" (mean:stddev)`)
+	depLatency := fs.Duration("dependency-latency", 0, "do-work-unary: simulate a downstream dependency taking this long before the work (0 disables)")
+	depTimeout := fs.Duration("dependency-timeout", 0, "do-work-unary: how long the server waits for the simulated dependency (0 uses the server default)")
+	depOnTimeout := fs.String("dependency-on-timeout", "", `do-work-unary: behavior when the simulated dependency times out ("fail" or "continue")`)
+	responsePadding := fs.Int("response-padding-bytes", 0, "do-work modes and bench: ask the server to inflate each DoWork response message to this size in bytes (0 disables)")
+	errorScope := fs.String("error-scope", "", `do-work streaming modes: apply --error-rate per message ("message", the server default) or once per stream ("stream")`)
+	abortAfterFailures := fs.Int("abort-after-failures", 0, "do-work streaming modes: ask the server to abort the stream once this many messages have failed (0 disables)")
+	failAfter := fs.Duration("fail-after", 0, "do-work modes and bench: ask the server to run each work normally and fail it this long after it started (must be < work-duration; 0 disables)")
+	elevationToken := fs.String("elevation-token", "", "do-work modes: token from the server's /admin/elevation that raises the limits for one call")
+	ioCache := fs.String("io-cache", "", `io work mode: "warm" rewrites and reads back the same file region (page-cache hits), "cold" rotates regions and evicts them from the page cache before reading back (device reads)`)
+	cpuShare := fs.Float64("cpu-share", 0, "do-work modes and bench: instead of parallelism, ask the server for this fraction (0 < share <= 1) of its cpu workers, granted from what concurrent requests leave free (0 disables)")
+	cpuAffinity := fs.String("cpu-affinity", "", `do-work modes and bench: ask a Linux server (CNO_APP_LOAD_CPU_AFFINITY=true) to pin cpu workers to these cores, e.g. "0,2"`)
+	requestPadding := fs.Int("request-padding-bytes", 0, "do-work-client: inflate each request message to this size in bytes (0 disables)")
+	summaryEvery := fs.Int("summary-every", 0, "do-work-client: receive running totals every N messages via the progress RPC (0 uses the plain client stream)")
+	recvDelay := fs.Duration("recv-delay", 0, "do-work-client: ask the server to delay processing each received message by this long (slow consumer)")
+	messageSpans := fs.String("message-spans", messageSpansSpan, "do-work streaming modes: record per-message latency as child spans (span), span events on the stream span (event), or not at all (off)")
+	warmup := fs.String("warmup", "10", `bench and loadtest: unmeasured traffic before measuring; a count (e.g. 10) sends that many Ping calls, a duration (e.g. 30s) sends the --mix traffic for that long (0 disables)`)
+	precheck := fs.Bool("precheck", true, "bench and loadtest: health check every target before measuring and fail fast if any is not SERVING")
+	expectCode := fs.String("expect-code", "OK", "expected gRPC status of every call, by name or number; setting any expectation makes the exit code reflect the expectations instead of the mode result")
+	maxLatencyMs := fs.Int("max-latency-ms", 0, "fail (exit non-zero) if any call, or any whole stream, takes longer than this (0 disables)")
+	minSuccessRate := fs.Float64("min-success-rate", 1, "fail (exit non-zero) if the fraction of calls returning --expect-code is below this (0.0-1.0)")
+	broadcastAddrs := fs.String("broadcast-addrs", "", `broadcast: comma-separated "host:port" servers to start the work on simultaneously (default: the pods from --kube-service, else --addr)`)
+	startDelay := fs.Duration("start-delay", 2*time.Second, "broadcast: how far ahead the shared start time is set; must leave time to reach every server")
+	scenarioPath := fs.String("scenario", "", "run the steps of this scenario file (YAML or JSON) in order and report each step; implies --mode scenario")
+	scenarioStatePath := fs.String("scenario-state", "", "scenario: save progress to this file after each step and, if it already exists, resume an interrupted run from the next step with the same run_id, seed and trace")
+	headerFile := fs.String("header-from-file", "", `file of metadata attached to every RPC: "key: value" per line, or a JSON object`)
+	var metadataFlags metadataFlag
+	fs.Var(&metadataFlags, "metadata", "key=value metadata attached to every RPC; repeatable, and repeating a key sends multiple values (added to --header-from-file)")
+
+	backoffBase := fs.Duration("backoff-base-delay", backoff.DefaultConfig.BaseDelay, "reconnect backoff delay after the first connection failure")
+	backoffMax := fs.Duration("backoff-max-delay", backoff.DefaultConfig.MaxDelay, "upper bound of the reconnect backoff delay")
+	backoffMultiplier := fs.Float64("backoff-multiplier", backoff.DefaultConfig.Multiplier, "factor the reconnect backoff delay grows by after each failure")
+	backoffJitter := fs.Float64("backoff-jitter", backoff.DefaultConfig.Jitter, "randomization factor of the reconnect backoff delay (0.0-1.0)")
+	minConnectTimeout := fs.Duration("min-connect-timeout", defaultMinConnectTimeout, "minimum time to give a connection attempt to complete")
+	keepaliveTime := fs.Duration("keepalive-time", 0, "send an HTTP/2 keepalive ping after the connection is idle this long (0 disables; min 10s). The server must allow it, see CNO_APP_GRPC_KEEPALIVE_MIN_TIME")
+	keepaliveTimeout := fs.Duration("keepalive-timeout", defaultKeepaliveTimeout, "close the connection if a keepalive ping is not acknowledged within this time")
+	keepalivePermitWithoutStream := fs.Bool("keepalive-permit-without-stream", false, "send keepalive pings even when no RPC is in flight")
+	compressionName := fs.String("compression", compressionNone, "compress every RPC message with none, gzip or zstd; the end-of-request log adds the compressed sizes (bytes_out_compressed / bytes_in_compressed)")
+	retries := fs.Int("retries", 0, "retry a failed unary call up to this many more times when it returns one of --retry-codes (0 disables; streams are not retried); replaces the retry policy from --fetch-config")
+	retryBackoff := fs.Duration("retry-backoff", 100*time.Millisecond, "wait before the first retry; doubles on each further retry up to --retry-max-backoff, with ±20% jitter")
+	retryMaxBackoff := fs.Duration("retry-max-backoff", 2*time.Second, "upper bound of the wait between retries")
+	retryCodes := fs.String("retry-codes", defaultRetryCodes, "comma-separated gRPC statuses that are retried, by name or number")
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		return nil, err
 	}
 
-	dur, err := time.ParseDuration(*timeoutStr)
+	if *repeat <= 0 {
+		return nil, fmt.Errorf("repeat must be > 0, got %d", *repeat)
+	}
+	if *runFor < 0 {
+		return nil, fmt.Errorf("run-for must be >= 0, got %s", *runFor)
+	}
+	if *streams <= 0 {
+		return nil, fmt.Errorf("streams must be > 0, got %d", *streams)
+	}
+	if *instances <= 0 {
+		return nil, fmt.Errorf("instances must be > 0, got %d", *instances)
+	}
+	if *responsePadding < 0 || *responsePadding > appserver.MaxResponsePadding {
+		return nil, fmt.Errorf("response-padding-bytes must be between 0 and %d, got %d", appserver.MaxResponsePadding, *responsePadding)
+	}
+	switch *errorScope {
+	case "", appserver.ErrorScopeMessage, appserver.ErrorScopeStream:
+	default:
+		return nil, fmt.Errorf("error-scope must be %s or %s, got %q", appserver.ErrorScopeMessage, appserver.ErrorScopeStream, *errorScope)
+	}
+	if *abortAfterFailures < 0 {
+		return nil, fmt.Errorf("abort-after-failures must be >= 0, got %d", *abortAfterFailures)
+	}
+	if *failAfter < 0 {
+		return nil, fmt.Errorf("fail-after must be >= 0, got %s", *failAfter)
+	}
+	if err := validateCPUAffinity(*cpuAffinity); err != nil {
+		return nil, err
+	}
+	code, err := appserver.ParseCode(*returnCode)
 	if err != nil {
-		return nil, fmt.Errorf("invalid timeout %q: %w", *timeoutStr, err)
+		return nil, fmt.Errorf("return-code: %w", err)
 	}
-	if dur <= 0 {
-		return nil, fmt.Errorf("timeout must be > 0, got %s", dur)
+	if *returnCodeDelay < 0 || *returnCodeDelay > appserver.MaxReturnCodeDelay {
+		return nil, fmt.Errorf("return-code-delay must be between 0 and %s, got %s", appserver.MaxReturnCodeDelay, *returnCodeDelay)
 	}
-
-	if *repeat <= 0 {
-		return nil, fmt.Errorf("repeat must be > 0, got %d", *repeat)
+	if *ioCache != "" && *ioCache != "warm" && *ioCache != "cold" {
+		return nil, fmt.Errorf("io-cache must be warm or cold, got %q", *ioCache)
+	}
+	if math.IsNaN(*cpuShare) || *cpuShare < 0 || *cpuShare > 1 {
+		return nil, fmt.Errorf("cpu-share must be between 0 and 1, got %v", *cpuShare)
+	}
+	if *requestPadding < 0 || *requestPadding > appserver.MaxResponsePadding {
+		return nil, fmt.Errorf("request-padding-bytes must be between 0 and %d, got %d", appserver.MaxResponsePadding, *requestPadding)
+	}
+	if *summaryEvery < 0 {
+		return nil, fmt.Errorf("summary-every must be >= 0, got %d", *summaryEvery)
+	}
+	if *recvDelay < 0 || *recvDelay > appserver.MaxRecvDelay {
+		return nil, fmt.Errorf("recv-delay must be between 0 and %s, got %s", appserver.MaxRecvDelay, *recvDelay)
+	}
+	if err := validateMessageSpans(*messageSpans); err != nil {
+		return nil, err
+	}
+	if err := validateOutput(*output); err != nil {
+		return nil, err
+	}
+	warmupCount, warmupDuration, err := parseWarmup(*warmup)
+	if err != nil {
+		return nil, err
+	}
+	if *requests <= 0 {
+		return nil, fmt.Errorf("requests must be > 0, got %d", *requests)
+	}
+	if *concurrency <= 0 {
+		return nil, fmt.Errorf("concurrency must be > 0, got %d", *concurrency)
+	}
+	if math.IsNaN(*rps) || *rps < 0 {
+		return nil, fmt.Errorf("rps must be >= 0, got %v", *rps)
+	}
+	if *duration <= 0 {
+		return nil, fmt.Errorf("duration must be > 0, got %s", *duration)
+	}
+	if *soakReportEvery <= 0 {
+		return nil, fmt.Errorf("soak-report-every must be > 0, got %s", *soakReportEvery)
+	}
+	trafficMix, err := parseMix(*mix)
+	if err != nil {
+		return nil, err
+	}
+	think, err := scenario.ParseThinkTime(*thinkTime)
+	if err != nil {
+		return nil, err
+	}
+	if *startDelay < 0 || *startDelay > appserver.MaxStartAtLead {
+		return nil, fmt.Errorf("start-delay must be between 0 and %s, got %s", appserver.MaxStartAtLead, *startDelay)
+	}
+	expect, err := newExpectations(*expectCode, *maxLatencyMs, *minSuccessRate)
+	if err != nil {
+		return nil, err
+	}
+	connectParams, err := newConnectParams(*backoffBase, *backoffMax, *backoffMultiplier, *backoffJitter, *minConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	keepaliveParams, err := newKeepaliveParams(*keepaliveTime, *keepaliveTimeout, *keepalivePermitWithoutStream)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := newCompression(*compressionName)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := newRetryPolicy(*retries, *retryBackoff, *retryMaxBackoff, *retryCodes)
+	if err != nil {
+		return nil, err
 	}
 
-	return &options{
+	opts := &options{
 		Addr:         *addr,
-		Timeout:      dur,
 		Mode:         *mode,
 		Payload:      *payload,
 		WorkMode:     *workMode,
@@ -159,270 +606,242 @@ func parseOptions() (*options, error) {
 		Latency:      *latency,
 		ErrorRate:    *errorRate,
 		Repeat:       *repeat,
-	}, nil
-}
-
-// callHealth は HealthチェックRPCを実行し、
-// request_id 生成+metadata伝播/trace_id付きログを出力する
-func callHealth(conn *grpc.ClientConn, opts *options) error {
-	logger := observability.NewLogger()
-	defer func() {
-		_ = logger.Sync()
-	}()
-
-	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
-	defer cancel()
-
-	requestID := uuid.New().String()
+		RunFor:       *runFor,
+		Streams:      *streams,
+		Instances:    *instances,
+		Insecure:     *insecureFlag && !*tlsFlag,
+		ServerName:   *serverName,
+		CACert:       *caCert,
+		ClientCert:   *clientCert,
+		ClientKey:    *clientKey,
+		FetchConfig:  *fetchConfig,
+		KubeService:  *kubeService,
+		Kubeconfig:   *kubeconfig,
+
+		ConnectParams: connectParams,
+		Keepalive:     keepaliveParams,
+		Compression:   compression,
+		Retry:         retry,
+
+		DependencyLatency:   *depLatency,
+		DependencyTimeout:   *depTimeout,
+		DependencyOnTimeout: *depOnTimeout,
+
+		ResponsePaddingBytes: *responsePadding,
+
+		ErrorScope:         *errorScope,
+		AbortAfterFailures: *abortAfterFailures,
+		FailAfter:          *failAfter,
+		CPUAffinity:        *cpuAffinity,
+		ReturnCode:         code,
+		ReturnCodeDelay:    *returnCodeDelay,
+		ReturnCodeMessage:  *returnCodeMessage,
+		CPUShare:           *cpuShare,
+		IOCache:            *ioCache,
+		ElevationToken:     *elevationToken,
+
+		RequestPaddingBytes: *requestPadding,
+		SummaryEvery:        *summaryEvery,
+		RecvDelay:           *recvDelay,
+		MessageSpans:        *messageSpans,
+
+		Mix:            trafficMix,
+		Requests:       *requests,
+		Concurrency:    *concurrency,
+		ThinkTime:      think,
+		Seed:           *seed,
+		Warmup:         warmupCount,
+		WarmupDuration: warmupDuration,
+		Precheck:       *precheck,
+
+		RPS:      *rps,
+		Duration: *duration,
+
+		SoakReportEvery: *soakReportEvery,
+
+		BroadcastAddrs: parseAddrList(*broadcastAddrs),
+		StartDelay:     *startDelay,
+
+		Expect: expect,
+
+		Output: *output,
+	}
 
-	md := metadata.New(map[string]string{
-		"x-request-id": requestID,
+	tlsSet, insecureSet := false, false
+	fs.Visit(func(f *flag.Flag) {
+		tlsSet = tlsSet || f.Name == "tls"
+		insecureSet = insecureSet || f.Name == "insecure"
 	})
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	tracer := otel.Tracer("cno-app-client")
-	ctx, span := tracer.Start(ctx, "grpc.client/HealthCheck")
-	defer span.End()
-
-	spanCtx := trace.SpanFromContext(ctx).SpanContext()
-	traceID := spanCtx.TraceID().String()
-
-	req := &healthpb.HealthCheckRequest{Service: ""}
-
-	reqBytes, _ := proto.Marshal(req)
-
-	start := time.Now()
-
-	// リクエスト開始ログ
-	logger.Infow("client request start",
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"request_id", requestID,
-	)
+	// Insecure は --tls で false に畳み込んであるため、指定されたフラグの値そのもので組み合わせを確かめる
+	if err := validateTLSFlags(opts, tlsSet && *tlsFlag, insecureSet && *insecureFlag); err != nil {
+		return nil, err
+	}
 
-	cl := healthpb.NewHealthClient(conn)
-	resp, err := cl.Check(ctx, req)
+	if *scenarioPath != "" {
+		modeSet := false
+		fs.Visit(func(f *flag.Flag) { modeSet = modeSet || f.Name == "mode" })
+		if modeSet && opts.Mode != "scenario" {
+			return nil, fmt.Errorf("--scenario runs the scenario mode, got --mode %s", opts.Mode)
+		}
+		sc, err := scenario.Load(*scenarioPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.Mode, opts.Scenario = "scenario", sc
+		if *scenarioStatePath != "" {
+			if opts.Resume, err = loadScenarioState(*scenarioStatePath, sc); err != nil {
+				return nil, err
+			}
+			opts.ScenarioState = *scenarioStatePath
+		}
+		// export と同じく、シナリオに addr があれば --addr より優先する
+		if sc.Addr != "" {
+			opts.Addr = sc.Addr
+		}
+	} else if opts.Mode == "scenario" {
+		return nil, errors.New("scenario mode requires --scenario")
+	} else if *scenarioStatePath != "" {
+		return nil, errors.New("--scenario-state requires --scenario")
+	}
 
-	latencyMs := time.Since(start).Milliseconds()
+	if opts.RunFor > 0 && !runForModes[opts.Mode] {
+		return nil, fmt.Errorf("--run-for repeats a single call and does not support mode %s (loadtest runs for --duration)", opts.Mode)
+	}
 
-	bytesIn := 0
-	if resp != nil {
-		if b, errMarshal := proto.Marshal(resp); errMarshal == nil {
-			bytesIn = len(b)
+	if *headerFile != "" {
+		headers, err := loadHeaderFile(*headerFile)
+		if err != nil {
+			return nil, err
 		}
+		opts.Headers = headers
+	}
+	if len(metadataFlags.md) > 0 {
+		opts.Headers = metadata.Join(opts.Headers, metadataFlags.md)
 	}
 
-	code := "OK"
-	if st, ok := status.FromError(err); ok {
-		code = st.Code().String()
+	if *timeoutStr == "auto" {
+		opts.Timeout = autoTimeout(opts)
+	} else {
+		dur, err := time.ParseDuration(*timeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %q: %w", *timeoutStr, err)
+		}
+		if dur <= 0 {
+			return nil, fmt.Errorf("timeout must be > 0, got %s", dur)
+		}
+		opts.Timeout = dur
 	}
 
-	fields := []any{
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"request_id", requestID,
-		"code", code,
-		"latency_ms", latencyMs,
-		"bytes_out", len(reqBytes),
-		"bytes_in", bytesIn,
+	return opts, nil
+}
+
+// autoTimeout は mode ごとに必要な処理時間を見積もり、RPC タイムアウトの既定値を返す。
+// DoWork 系は (work-duration + latency) × 実行回数 に余裕を足した値にし、
+// ストリーミングで repeat を増やした時に固定の 3 秒で打ち切られないようにする
+func autoTimeout(opts *options) time.Duration {
+	perWork := opts.WorkDuration + opts.Latency
+
+	switch opts.Mode {
+	case "do-work-unary":
+		return opts.DependencyLatency + perWork + timeoutMargin
+	case "do-work-client":
+		return time.Duration(opts.Repeat)*(perWork+opts.RecvDelay) + timeoutMargin
+	case "do-work-server", "do-work-bidi", "stream-storm":
+		return time.Duration(opts.Repeat)*perWork + timeoutMargin
+	case "return-code":
+		return opts.ReturnCodeDelay + timeoutMargin
+	case "broadcast":
+		return opts.StartDelay + perWork + timeoutMargin
+	case "soak":
+		// soak ではストリーム全体のタイムアウトとして使う
+		return opts.Duration + perWork + timeoutMargin
+	case "bench", "loadtest":
+		// bench / loadtest では 1 回の呼び出しごとのタイムアウトとして使う
+		return benchTimeout(opts)
+	case "scenario":
+		return scenarioTimeout(opts.Scenario)
+	default:
+		return baseTimeout
 	}
+}
+
+// callHealth は HealthチェックRPCを実行する。
+// request_id / span / trace_id 付きの開始・終了ログは interceptor(instrumentationDialOptions)が付ける
+func callHealth(conn *grpc.ClientConn, opts *options) error {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
 
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: ""})
 	if err != nil {
-		fields = append(fields, "error", err)
-		logger.Errorw("client request end", fields...)
 		return fmt.Errorf("health check failed: %w", err)
 	}
 
-	logger.Infow("client request end", fields...)
-
-	fmt.Printf("health:%+v\n", resp)
+	opts.Out.setResult(healthResult{Status: resp.GetStatus().String()})
+	opts.Out.printf("health:%+v\n", resp)
 
 	return nil
 }
 
 func callPing(conn *grpc.ClientConn, opts *options) error {
-	logger := observability.NewLogger()
-	defer func() {
-		_ = logger.Sync()
-	}()
-
 	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
 	defer cancel()
 
-	requestID := uuid.New().String()
-
-	md := metadata.New(map[string]string{
-		"x-request-id": requestID,
-	})
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	tracer := otel.Tracer("cno-app-client")
-	ctx, span := tracer.Start(ctx, "grpc.client/Burner.Ping")
-	defer span.End()
-
-	spanCtx := trace.SpanFromContext(ctx).SpanContext()
-	traceID := spanCtx.TraceID().String()
-
-	req := &grpcburnerv1.PingRequest{}
-	reqBytes, _ := proto.Marshal(req)
-
-	start := time.Now()
-
-	logger.Infow("client request start",
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"request_id", requestID,
-	)
-
-	cl := grpcburnerv1.NewBurnerClient(conn)
-	resp, err := cl.Ping(ctx, req)
-
-	latencyMs := time.Since(start).Milliseconds()
-
-	bytesIn := 0
-	if resp != nil {
-		if b, errMarshal := proto.Marshal(resp); errMarshal == nil {
-			bytesIn = len(b)
-		}
-	}
-
-	code := "OK"
-	if st, ok := status.FromError(err); ok {
-		code = st.Code().String()
-	}
-
-	fields := []any{
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"request_id", requestID,
-		"code", code,
-		"latency_ms", latencyMs,
-		"bytes_out", len(reqBytes),
-		"bytes_in", bytesIn,
-	}
-
+	resp, err := grpcburnerv1.NewBurnerClient(conn).Ping(ctx, &grpcburnerv1.PingRequest{})
 	if err != nil {
-		fields = append(fields, "error", err)
-		logger.Errorw("client request end", fields...)
 		return fmt.Errorf("ping failed: %w", err)
 	}
 
-	logger.Infow("client request end", fields...)
-
-	fmt.Printf("ping reply: %s\n", resp.GetMessage())
+	opts.Out.setResult(pingResult{Message: resp.GetMessage()})
+	opts.Out.printf("ping reply: %s\n", resp.GetMessage())
 	return nil
 }
 
 func callDoWorkUnary(conn *grpc.ClientConn, opts *options) error {
-	logger := observability.NewLogger()
-	defer func() {
-		_ = logger.Sync()
-	}()
-
 	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
 	defer cancel()
 
-	requestID := uuid.New().String()
-	md := metadata.New(map[string]string{
-		"x-request-id": requestID,
-	})
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	tracer := otel.Tracer("cno-app-client")
-	ctx, span := tracer.Start(ctx, "grpc.client/Burner.DoWork")
-	defer span.End()
-
-	spanCtx := trace.SpanFromContext(ctx).SpanContext()
-	traceID := spanCtx.TraceID().String()
-
 	wc, err := workConfigFromOptions(opts)
 	if err != nil {
 		return err
 	}
 
-	req := &grpcburnerv1.DoWorkRequest{
-		RequestId: requestID,
-		Config:    wc,
+	var pairs []string
+	if opts.Instances > 1 {
+		pairs = append(pairs, appserver.InstancesMetadataKey, strconv.Itoa(opts.Instances))
 	}
-	reqBytes, _ := proto.Marshal(req)
-
-	start := time.Now()
-
-	logger.Infow("client request start",
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"request_id", requestID,
-		"work_mode", opts.WorkMode,
-	)
-
-	cl := grpcburnerv1.NewBurnerClient(conn)
-	resp, err := cl.DoWork(ctx, req)
-
-	latencyMs := time.Since(start).Milliseconds()
-
-	bytesIn := 0
-	if resp != nil {
-		if b, errMarshal := proto.Marshal(resp); errMarshal == nil {
-			bytesIn = len(b)
+	if opts.DependencyLatency > 0 {
+		pairs = append(pairs, appserver.DependencyLatencyMetadataKey, opts.DependencyLatency.String())
+		if opts.DependencyTimeout > 0 {
+			pairs = append(pairs, appserver.DependencyTimeoutMetadataKey, opts.DependencyTimeout.String())
+		}
+		if opts.DependencyOnTimeout != "" {
+			pairs = append(pairs, appserver.DependencyOnTimeoutMetadataKey, opts.DependencyOnTimeout)
 		}
 	}
+	ctx = metadata.AppendToOutgoingContext(ctx, pairs...)
+	ctx, requestID := withRequestID(ctx)
+	ctx = withLogFields(ctx, "work_mode", opts.WorkMode, "instances", opts.Instances)
 
-	code := "OK"
-	if st, ok := status.FromError(err); ok {
-		code = st.Code().String()
-	}
-
-	fields := []any{
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"request_id", requestID,
-		"code", code,
-		"latency_ms", latencyMs,
-		"bytes_out", len(reqBytes),
-		"bytes_in", bytesIn,
-		"work_mode", opts.WorkMode,
-	}
-
+	resp, err := grpcburnerv1.NewBurnerClient(conn).DoWork(ctx, &grpcburnerv1.DoWorkRequest{
+		RequestId: requestID,
+		Config:    wc,
+	})
 	if err != nil {
-		fields = append(fields, "error", err)
-		logger.Errorw("client request end", fields...)
 		return fmt.Errorf("do-work failed: %w", err)
 	}
 
-	logger.Infow("client request end", fields...)
-
-	fmt.Printf("do-work unary: ok=%v error=%s\n", resp.GetOk(), resp.GetErrorMessage())
+	opts.Out.setResult(workResult{OK: resp.GetOk(), ErrorMessage: resp.GetErrorMessage()})
+	opts.Out.printf("do-work unary: ok=%v error=%s\n", resp.GetOk(), resp.GetErrorMessage())
+	if !resp.GetOk() {
+		return workError{mode: opts.Mode, failed: 1, total: 1, first: resp.GetErrorMessage()}
+	}
 	return nil
 }
 
 func callDoWorkServerStreaming(conn *grpc.ClientConn, opts *options) error {
-	logger := observability.NewLogger()
-	defer func() {
-		_ = logger.Sync()
-	}()
-
 	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
 	defer cancel()
 
-	requestID := uuid.New().String()
-	md := metadata.New(map[string]string{
-		"x-request-id": requestID,
-	})
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	tracer := otel.Tracer("cno-app-client")
-	ctx, span := tracer.Start(ctx, "grpc.client/Burner.DoWorkServerStreaming")
-	defer span.End()
-
-	spanCtx := trace.SpanFromContext(ctx).SpanContext()
-	traceID := spanCtx.TraceID().String()
-
 	wc, err := workConfigFromOptions(opts)
 	if err != nil {
 		return err
@@ -433,64 +852,43 @@ func callDoWorkServerStreaming(conn *grpc.ClientConn, opts *options) error {
 		return err
 	}
 
-	req := &grpcburnerv1.DoWorkServerStreamingRequest{
+	ctx, requestID := withRequestID(ctx)
+	ctx = withLogFields(ctx, "repeat", opts.Repeat, "work_mode", opts.WorkMode)
+
+	stream, err := grpcburnerv1.NewBurnerClient(conn).DoWorkServerStreaming(ctx, &grpcburnerv1.DoWorkServerStreamingRequest{
 		RequestId: requestID,
 		Config:    wc,
 		Repeat:    rep32,
-	}
-	reqBytes, _ := proto.Marshal(req)
-
-	start := time.Now()
-
-	logger.Infow("client request start",
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"request_id", requestID,
-		"repeat", opts.Repeat,
-		"work_mode", opts.WorkMode,
-	)
-
-	cl := grpcburnerv1.NewBurnerClient(conn)
-	stream, err := cl.DoWorkServerStreaming(ctx, req)
+	})
 	if err != nil {
-		logger.Errorw("stream open error", "err", err)
 		return fmt.Errorf("do-work-server: open stream: %w", err)
 	}
 
+	// サーバーストリームでは、直前のメッセージ(最初はストリームの開始)から受信までを 1 メッセージのレイテンシとする
+	msgs := newMessageRecorder(stream.Context(), opts.MessageSpans)
+	var result streamResult
+	defer func() {
+		opts.Out.setResult(result)
+	}()
 	recvCount := 0
 	for {
+		msgStart := time.Now()
 		resp, err := stream.Recv()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			logger.Errorw("stream recv error", "err", err)
+			msgs.record("RECEIVED", recvCount+1, 0, msgStart, err)
 			return fmt.Errorf("do-work-server: recv: %w", err)
 		}
 		recvCount++
-		fmt.Printf("server stream [%d/%d]: ok=%v error=%s\n",
+		msgs.record("RECEIVED", recvCount, proto.Size(resp), msgStart, nil)
+		result.Messages = append(result.Messages, workResult{OK: resp.GetOk(), ErrorMessage: resp.GetErrorMessage()})
+		opts.Out.printf("server stream [%d/%d]: ok=%v error=%s\n",
 			recvCount, opts.Repeat, resp.GetOk(), resp.GetErrorMessage())
 	}
 
-	latencyMs := time.Since(start).Milliseconds()
-
-	code := "OK"
-	fields := []any{
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"request_id", requestID,
-		"code", code,
-		"latency_ms", latencyMs,
-		"bytes_out", len(reqBytes),
-		"recv_count", recvCount,
-		"repeat", opts.Repeat,
-	}
-
-	logger.Infow("client request end", fields...)
-
-	return nil
+	return result.failure(opts.Mode)
 }
 
 func callDoWorkClientStreaming(conn *grpc.ClientConn, opts *options) error {
@@ -502,167 +900,127 @@ func callDoWorkClientStreaming(conn *grpc.ClientConn, opts *options) error {
 	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
 	defer cancel()
 
-	md := metadata.New(nil)
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	tracer := otel.Tracer("cno-app-client")
-	ctx, span := tracer.Start(ctx, "grpc.client/Burner.DoWorkClientStreaming")
-	defer span.End()
-
-	spanCtx := trace.SpanFromContext(ctx).SpanContext()
-	traceID := spanCtx.TraceID().String()
+	if opts.RecvDelay > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, appserver.RecvDelayMetadataKey, opts.RecvDelay.String())
+	}
+	ctx = withLogFields(ctx, "repeat", opts.Repeat, "work_mode", opts.WorkMode)
 
 	wc, err := workConfigFromOptions(opts)
 	if err != nil {
 		return err
 	}
 
-	cl := grpcburnerv1.NewBurnerClient(conn)
-	stream, err := cl.DoWorkClientStreaming(ctx)
+	var stream clientWorkStream
+	if opts.SummaryEvery > 0 {
+		stream, err = openProgressStream(ctx, conn, opts.SummaryEvery, logger, opts.Out)
+	} else {
+		stream, err = grpcburnerv1.NewBurnerClient(conn).DoWorkClientStreaming(ctx)
+	}
 	if err != nil {
-		logger.Errorw("stream open error", "err", err)
 		return fmt.Errorf("do-work-client: open stream: %w", err)
 	}
 
-	start := time.Now()
-
-	logger.Infow("client stream start",
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"repeat", opts.Repeat,
-		"work_mode", opts.WorkMode,
-	)
-
-	sent := 0
+	// クライアントストリームでは Send がブロックした時間(フロー制御で待たされた時間を含む)を 1 メッセージのレイテンシとする。
+	// その合計と最大値は interceptor が client stream end ログの send_blocked_ms / send_blocked_max_ms に出す
+	msgs := newMessageRecorder(stream.Context(), opts.MessageSpans)
 	for i := 0; i < opts.Repeat; i++ {
-		requestID := uuid.New().String()
 		req := &grpcburnerv1.DoWorkRequest{
-			RequestId: requestID,
+			RequestId: uuid.New().String(),
 			Config:    wc,
 		}
-		if err := stream.Send(req); err != nil {
-			logger.Errorw("stream send error", "err", err)
+		appserver.PadMessage(req, opts.RequestPaddingBytes)
+		msgStart := time.Now()
+		err := stream.Send(req)
+		msgs.record("SENT", i+1, proto.Size(req), msgStart, err)
+		if err == io.EOF {
+			// サーバーがストリームを終了した(上限超過など)。実際のステータスは CloseAndRecv で受け取る
+			_, err = stream.CloseAndRecv()
+		}
+		if err != nil {
 			return fmt.Errorf("do-work-client: send: %w", err)
 		}
-		sent++
 	}
 
 	summary, err := stream.CloseAndRecv()
 	if err != nil {
-		logger.Errorw("stream close/recv error", "err", err)
 		return fmt.Errorf("do-work-client: close/recv: %w", err)
 	}
 
-	latencyMs := time.Since(start).Milliseconds()
-
-	fields := []any{
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"code", "OK",
-		"latency_ms", latencyMs,
-		"sent", sent,
-		"summary_total", summary.GetTotal(),
-		"summary_success", summary.GetSuccess(),
-		"summary_failed", summary.GetFailed(),
+	opts.Out.setResult(streamResult{Summary: &summaryResult{
+		Total:   summary.GetTotal(),
+		Success: summary.GetSuccess(),
+		Failed:  summary.GetFailed(),
+	}})
+	opts.Out.printf("client stream summary: total=%d success=%d failed=%d\n", summary.GetTotal(), summary.GetSuccess(), summary.GetFailed())
+	if summary.GetFailed() > 0 {
+		return fmt.Errorf("do-work-client: %d/%d works failed", summary.GetFailed(), summary.GetTotal())
 	}
-
-	logger.Infow("client stream end", fields...)
-
-	fmt.Printf("client stream summary: total=%d success=%d failed=%d\n", summary.GetTotal(), summary.GetSuccess(), summary.GetFailed())
 	return nil
 }
 
 func callDoWorkBidiStreaming(conn *grpc.ClientConn, opts *options) error {
-	logger := observability.NewLogger()
-	defer func() {
-		_ = logger.Sync()
-	}()
-
 	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
 	defer cancel()
 
-	md := metadata.New(nil)
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	tracer := otel.Tracer("cno-app-client")
-	ctx, span := tracer.Start(ctx, "grpc.client/Burner.DoWorkBidiStreaming")
-	defer span.End()
-
-	spanCtx := trace.SpanFromContext(ctx).SpanContext()
-	traceID := spanCtx.TraceID().String()
+	ctx = withLogFields(ctx, "repeat", opts.Repeat, "work_mode", opts.WorkMode)
 
 	wc, err := workConfigFromOptions(opts)
 	if err != nil {
 		return err
 	}
 
-	cl := grpcburnerv1.NewBurnerClient(conn)
-	stream, err := cl.DoWorkBidiStreaming(ctx)
+	stream, err := grpcburnerv1.NewBurnerClient(conn).DoWorkBidiStreaming(ctx)
 	if err != nil {
-		logger.Errorw("stream open error", "err", err)
 		return fmt.Errorf("do-work-bidi: open stream: %w", err)
 	}
 
-	start := time.Now()
-
-	logger.Infow("client bidi start",
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"repeat", opts.Repeat,
-		"work_mode", opts.WorkMode,
-	)
-
-	sent := 0
+	// 双方向ストリームでは送信から対応するレスポンスの受信までを 1 メッセージのレイテンシとする
+	msgs := newMessageRecorder(stream.Context(), opts.MessageSpans)
+	var result streamResult
+	defer func() {
+		opts.Out.setResult(result)
+	}()
 	received := 0
 
 	for i := 0; i < opts.Repeat; i++ {
-		requestID := uuid.New().String()
 		req := &grpcburnerv1.DoWorkRequest{
-			RequestId: requestID,
+			RequestId: uuid.New().String(),
 			Config:    wc,
 		}
+		msgStart := time.Now()
 		if err := stream.Send(req); err != nil {
-			logger.Errorw("bidi send error", "err", err)
+			msgs.record("SENT", i+1, proto.Size(req), msgStart, err)
+			if err == io.EOF {
+				// サーバーがストリームを終了した(上限超過など)。実際のステータスは Recv で受け取る
+				_, err = stream.Recv()
+			}
 			return fmt.Errorf("do-work-bidi: send: %w", err)
 		}
-		sent++
 
 		resp, err := stream.Recv()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			logger.Errorw("bidi recv error", "err", err)
+			msgs.record("RECEIVED", i+1, 0, msgStart, err)
 			return fmt.Errorf("do-work-bidi: recv: %w", err)
 		}
 		received++
+		msgs.record("RECEIVED", received, proto.Size(resp), msgStart, nil)
+		result.Messages = append(result.Messages, workResult{OK: resp.GetOk(), ErrorMessage: resp.GetErrorMessage()})
 
-		fmt.Printf("bidi [%d/%d]: ok=%v error=%s\n", received, opts.Repeat, resp.GetOk(), resp.GetErrorMessage())
+		opts.Out.printf("bidi [%d/%d]: ok=%v error=%s\n", received, opts.Repeat, resp.GetOk(), resp.GetErrorMessage())
 	}
 
 	if err := stream.CloseSend(); err != nil {
-		logger.Errorw("bidi close send error", "err", err)
+		return fmt.Errorf("do-work-bidi: close send: %w", err)
 	}
-
-	latencyMs := time.Since(start).Milliseconds()
-
-	fields := []any{
-		"trace_id", traceID,
-		"mode", opts.Mode,
-		"addr", opts.Addr,
-		"code", "OK",
-		"latency_ms", latencyMs,
-		"sent", sent,
-		"received", received,
+	// ストリームの終了(trailer と client stream end ログ)はサーバーがストリームを閉じた後にしか分からないため、EOF まで受信しておく
+	if _, err := stream.Recv(); err != io.EOF {
+		return fmt.Errorf("do-work-bidi: recv: %w", err)
 	}
 
-	logger.Infow("client bidi end", fields...)
-
-	return nil
-
+	return result.failure(opts.Mode)
 }
 
 func workConfigFromOptions(opts *options) (*grpcburnerv1.WorkConfig, error) {
@@ -673,12 +1031,15 @@ func workConfigFromOptions(opts *options) (*grpcburnerv1.WorkConfig, error) {
 		mode = grpcburnerv1.LoadMode_LOAD_MODE_CPU
 	case "mem":
 		mode = grpcburnerv1.LoadMode_LOAD_MODE_MEM
+	case "page-fault":
+		// proto に LoadMode がないため mem として送り、x-load-mode(runMetadataDialOptions)で page-fault を指定する
+		mode = grpcburnerv1.LoadMode_LOAD_MODE_MEM
 	case "cpu-mem":
 		mode = grpcburnerv1.LoadMode_LOAD_MODE_CPU_MEM
 	case "io":
 		mode = grpcburnerv1.LoadMode_LOAD_MODE_IO
 	default:
-		return nil, fmt.Errorf("invalid work-mode %q (expected cpu|mem|cpu-mem|io)", opts.WorkMode)
+		return nil, fmt.Errorf("invalid work-mode %q (expected cpu|mem|cpu-mem|io|page-fault)", opts.WorkMode)
 	}
 
 	if opts.WorkDuration <= 0 {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+// runMetadataDialOptions は全 RPC に run_id / mode の metadata と構造化 user-agent を付与する DialOption を返す。
+// サーバーのログ/メトリクスで自分の実行分だけを絞り込めるようにするため。
+// --header-from-file / --metadata の metadata と、--work-mode=page-fault(x-load-mode)、--response-padding-bytes / --error-scope /
+// --abort-after-failures / --fail-after / --cpu-affinity / --cpu-share / --io-cache / --elevation-token の指定もここで全 RPC に付与する
+// (DoWork 系以外の RPC では無視される)
+func runMetadataDialOptions(opts *options) []grpc.DialOption {
+	pairs := []string{
+		observability.RunIDMetadataKey, opts.RunID,
+		observability.ClientModeMetadataKey, opts.Mode,
+	}
+	if opts.ResponsePaddingBytes > 0 {
+		pairs = append(pairs, appserver.ResponsePaddingMetadataKey, strconv.Itoa(opts.ResponsePaddingBytes))
+	}
+	if opts.ErrorScope != "" {
+		pairs = append(pairs, appserver.ErrorScopeMetadataKey, opts.ErrorScope)
+	}
+	if opts.AbortAfterFailures > 0 {
+		pairs = append(pairs, appserver.AbortAfterFailuresMetadataKey, strconv.Itoa(opts.AbortAfterFailures))
+	}
+	if opts.FailAfter > 0 {
+		pairs = append(pairs, appserver.FailAfterMetadataKey, strconv.FormatInt(opts.FailAfter.Milliseconds(), 10))
+	}
+	if opts.WorkMode == "page-fault" {
+		pairs = append(pairs, appserver.LoadModeMetadataKey, opts.WorkMode)
+	}
+	if opts.CPUAffinity != "" {
+		pairs = append(pairs, appserver.CPUAffinityMetadataKey, opts.CPUAffinity)
+	}
+	if opts.CPUShare > 0 {
+		pairs = append(pairs, appserver.CPUShareMetadataKey, strconv.FormatFloat(opts.CPUShare, 'f', -1, 64))
+	}
+	if opts.IOCache != "" {
+		pairs = append(pairs, appserver.IOCacheMetadataKey, opts.IOCache)
+	}
+	if opts.ElevationToken != "" {
+		pairs = append(pairs, appserver.ElevationMetadataKey, opts.ElevationToken)
+	}
+	for k, vals := range opts.Headers {
+		for _, v := range vals {
+			pairs = append(pairs, k, v)
+		}
+	}
+	return []grpc.DialOption{
+		grpc.WithUserAgent(observability.ClientUserAgent(opts.Mode, opts.RunID)),
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+			return invoker(metadata.AppendToOutgoingContext(ctx, pairs...), method, req, reply, cc, callOpts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(metadata.AppendToOutgoingContext(ctx, pairs...), desc, cc, method, callOpts...)
+		}),
+	}
+}
+
+// validateCPUAffinity は --cpu-affinity がカンマ区切りの 0 以上の整数かを確認する。
+// 実在するコアかどうかはサーバー側で確認する
+func validateCPUAffinity(v string) error {
+	if v == "" {
+		return nil
+	}
+	for _, f := range strings.Split(v, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(f)); err != nil || n < 0 {
+			return fmt.Errorf("cpu-affinity must be comma-separated core numbers >= 0, got %q", v)
+		}
+	}
+	return nil
+}
+
+// serverTiming はサーバーが trailer で返す処理時間の内訳(Server-Timing 形式)を取り出す
+func serverTiming(trailer metadata.MD) string {
+	if vals := trailer.Get(appserver.ServerTimingTrailerKey); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
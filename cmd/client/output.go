@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/status"
+)
+
+// --output に指定できる値
+const (
+	outputText = "text" // mode ごとの行を標準出力に出す
+	outputJSON = "json" // 実行の結果を 1 つの JSON ドキュメントとして標準出力に出す
+)
+
+func validateOutput(v string) error {
+	switch v {
+	case outputText, outputJSON:
+		return nil
+	default:
+		return fmt.Errorf("invalid output %q (expected text|json)", v)
+	}
+}
+
+// runResult は --output=json で標準出力に出す、1 回の実行の結果。
+// zap のログ(標準エラー出力)とは別に、jq や CI の判定でそのまま読めるようにする
+type runResult struct {
+	Mode       string  `json:"mode"`
+	Addr       string  `json:"addr"`
+	RunID      string  `json:"run_id"`
+	OK         bool    `json:"ok"`
+	Code       string  `json:"code"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	// Calls は呼び出しごとの結果。bench / loadtest などの集計を出す mode では空で、集計を Result に入れる
+	Calls []callReport `json:"calls"`
+	// Result は mode ごとの結果(DoWork の ok、ストリームの集計、bench の種類ごとの集計など)
+	Result       any                 `json:"result,omitempty"`
+	Expectations *expectationsReport `json:"expectations,omitempty"`
+	// Retries は --retries で再試行した回数の合計(bench などの集計を出す mode の呼び出しも含む)
+	Retries int `json:"retries,omitempty"`
+	// Targets は複数の接続先に振り分けた時(カンマ区切り / dns:/// の --addr、--kube-service)の接続先ごとの集計
+	Targets []targetSummary `json:"targets,omitempty"`
+}
+
+// callReport は 1 回の RPC の結果。"client request end" / "client stream end" ログと同じ値を持つ
+type callReport struct {
+	TraceID      string  `json:"trace_id"`
+	Method       string  `json:"method"`
+	RequestID    string  `json:"request_id"`
+	Code         string  `json:"code"`
+	LatencyMs    float64 `json:"latency_ms"`
+	BytesOut     int     `json:"bytes_out"`
+	BytesIn      int     `json:"bytes_in"`
+	ServerTiming string  `json:"server_timing,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	// Attempts は --retries 指定時の、再試行を含めた試行回数
+	Attempts int           `json:"attempts,omitempty"`
+	Stream   *streamReport `json:"stream,omitempty"`
+}
+
+// streamReport はストリームの送受信の集計
+type streamReport struct {
+	Kind             string  `json:"kind"`
+	Sent             int     `json:"sent"`
+	Received         int     `json:"received"`
+	FailedMessages   int     `json:"failed_messages"`
+	SendBlockedMs    float64 `json:"send_blocked_ms"`
+	SendBlockedMaxMs float64 `json:"send_blocked_max_ms"`
+}
+
+// resultWriter は mode の結果を --output に合わせて標準出力に出す。
+// text では mode の行をそのまま出し、json では行を出さずに結果を集め、finish で 1 つの JSON ドキュメントにして出す
+type resultWriter struct {
+	json bool
+	w    io.Writer
+
+	mu  sync.Mutex
+	res runResult
+}
+
+func newResultWriter(opts *options) *resultWriter {
+	return &resultWriter{
+		json: opts.Output == outputJSON,
+		w:    os.Stdout,
+		res: runResult{
+			Mode:  opts.Mode,
+			Addr:  opts.Addr,
+			RunID: opts.RunID,
+			Calls: []callReport{},
+		},
+	}
+}
+
+// printf は text の時だけ mode の行を出す
+func (o *resultWriter) printf(format string, args ...any) {
+	if o.json {
+		return
+	}
+	fmt.Fprintf(o.w, format, args...)
+}
+
+// printJSON は debug-echo / channelz のように JSON を出す mode の結果を出す。json では Result に入れる
+func (o *resultWriter) printJSON(v any) error {
+	if o.json {
+		o.setResult(v)
+		return nil
+	}
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// setResult は mode ごとの結果を設定する
+func (o *resultWriter) setResult(v any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.res.Result = v
+}
+
+func (o *resultWriter) addCall(c callReport) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.res.Calls = append(o.res.Calls, c)
+}
+
+func (o *resultWriter) addRetry() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.res.Retries++
+}
+
+func (o *resultWriter) setTargets(t []targetSummary) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.res.Targets = t
+}
+
+func (o *resultWriter) setExpectations(r expectationsReport) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.res.Expectations = &r
+}
+
+// finish は json の時に実行の結果(err を含む)を出す。終了コードを変えないよう、err はそのまま返す
+func (o *resultWriter) finish(err error, elapsed time.Duration) error {
+	if !o.json {
+		return err
+	}
+	o.mu.Lock()
+	res := o.res
+	o.mu.Unlock()
+
+	res.OK = err == nil
+	res.Code = status.Code(err).String()
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.DurationMs = durationMs(elapsed)
+	if werr := json.NewEncoder(o.w).Encode(res); werr != nil && err == nil {
+		return fmt.Errorf("write json output: %w", werr)
+	}
+	return err
+}
+
+// healthResult などは mode ごとの結果(runResult.Result)
+type healthResult struct {
+	Status string `json:"status"`
+}
+
+type pingResult struct {
+	Message string `json:"message"`
+}
+
+// workResult は DoWork 1 回分の結果。ストリームではメッセージごとに 1 つ
+type workResult struct {
+	OK           bool   `json:"ok"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// streamResult はストリーミング系の結果。server / bidi は受信したメッセージごとの結果、client は最終集計を持つ
+type streamResult struct {
+	Messages []workResult   `json:"messages,omitempty"`
+	Summary  *summaryResult `json:"summary,omitempty"`
+}
+
+// failure は ok=false のメッセージがあれば、最初の error_message を載せた workError を返す
+func (r streamResult) failure(mode string) error {
+	var err *workError
+	for _, m := range r.Messages {
+		if m.OK {
+			continue
+		}
+		if err == nil {
+			err = &workError{mode: mode, total: len(r.Messages), first: m.ErrorMessage}
+		}
+		err.failed++
+	}
+	if err == nil {
+		return nil
+	}
+	return *err
+}
+
+type summaryResult struct {
+	Total   int32 `json:"total"`
+	Success int32 `json:"success"`
+	Failed  int32 `json:"failed"`
+}
+
+type returnCodeResult struct {
+	RequestedCode string `json:"requested_code"`
+	Code          string `json:"code"`
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// precheckResult は接続先 1 つ分のヘルスチェックの結果
+type precheckResult struct {
+	Target    string  `json:"target"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func (r precheckResult) healthy() bool {
+	return r.Status == healthpb.HealthCheckResponse_SERVING.String()
+}
+
+// benchPrecheck は計測を始める前に全ての接続先へ Health Check を送り、1 つでも SERVING でなければエラーを返す。
+// 接続できない/準備できていない接続先への呼び出しが、レイテンシの統計や失敗数に混ざらないようにする。
+// --kube-service 指定時は解決した Pod ごとに直接接続し(directOpts を使う)、それ以外は bench と同じ conn で確認する
+func benchPrecheck(ctx context.Context, tracer trace.Tracer, conn *grpc.ClientConn, opts *options, directOpts []grpc.DialOption, logger *zap.SugaredLogger) (retErr error) {
+	// 合格条件(--expect-code など)の判定には含めない
+	ctx, span := tracer.Start(unmeasured(ctx), "grpc.client/Bench.precheck")
+	defer span.End()
+	start := time.Now()
+	defer func() {
+		observability.RecordSpanResult(span, retErr, time.Since(start))
+	}()
+
+	targets := opts.Targets
+	if len(targets) == 0 {
+		targets = []string{opts.Addr}
+	}
+	span.SetAttributes(attribute.Int("bench.precheck.targets", len(targets)))
+
+	results := make([]precheckResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cc := conn
+			if len(opts.Targets) > 0 {
+				direct, err := grpc.NewClient("passthrough:///"+target, directOpts...)
+				if err != nil {
+					results[i] = precheckResult{Target: target, Status: "DIAL_ERROR", Error: err.Error()}
+					return
+				}
+				defer func() {
+					_ = direct.Close()
+				}()
+				cc = direct
+			}
+			results[i] = checkTarget(ctx, cc, target, opts.Timeout)
+		}()
+	}
+	wg.Wait()
+
+	var unhealthy []string
+	for _, r := range results {
+		opts.Out.printf("bench precheck: target=%s status=%s latency=%.1fms %s\n", r.Target, r.Status, r.LatencyMs, r.Error)
+		if !r.healthy() {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (%s)", r.Target, r.Status))
+		}
+	}
+	fields := []any{
+		"trace_id", span.SpanContext().TraceID().String(),
+		"run_id", opts.RunID,
+		"targets", results,
+		"unhealthy", len(unhealthy),
+	}
+	if len(unhealthy) > 0 {
+		logger.Errorw("client bench precheck", fields...)
+		return fmt.Errorf("bench precheck: %d/%d targets unhealthy: %s", len(unhealthy), len(targets), strings.Join(unhealthy, ", "))
+	}
+	logger.Infow("client bench precheck", fields...)
+	return nil
+}
+
+func checkTarget(ctx context.Context, cc grpc.ClientConnInterface, target string, timeout time.Duration) precheckResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	// WaitForReady は付けない。接続できない接続先は timeout まで待たずに UNAVAILABLE で返す
+	resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	r := precheckResult{Target: target, LatencyMs: durationMs(time.Since(start))}
+	if err != nil {
+		r.Status = "UNREACHABLE"
+		r.Error = err.Error()
+		return r
+	}
+	r.Status = resp.GetStatus().String()
+	return r
+}
+
+// parseWarmup は --warmup を Ping の回数(整数)か、traffic mix を送る時間(例: 30s)として読む
+func parseWarmup(v string) (count int, d time.Duration, err error) {
+	if n, err := strconv.Atoi(v); err == nil {
+		if n < 0 {
+			return 0, 0, fmt.Errorf("warmup must be >= 0, got %d", n)
+		}
+		return n, 0, nil
+	}
+	d, err = time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, 0, fmt.Errorf("warmup must be a call count (e.g. 10) or a duration (e.g. 30s) >= 0, got %q", v)
+	}
+	return 0, d, nil
+}
+
+// benchWarmup は計測の前に --warmup 回の Ping を --concurrency 並列で送る。
+// コネクションの確立や TLS ハンドシェイク、round_robin の全サブコネクションの接続を済ませ、
+// 最初の数回だけ遅い呼び出しが計測に混ざらないようにする。結果は統計に含めない。
+// --warmup に時間(例: 30s)を指定した場合は benchWarmupFor で計測と同じ traffic mix を送る
+func benchWarmup(ctx context.Context, tracer trace.Tracer, conn *grpc.ClientConn, opts *options, logger *zap.SugaredLogger) (retErr error) {
+	if opts.WarmupDuration > 0 {
+		return benchWarmupFor(ctx, tracer, conn, opts, logger)
+	}
+	// 合格条件(--expect-code など)の判定には含めない
+	ctx, span := tracer.Start(unmeasured(ctx), "grpc.client/Bench.warmup")
+	defer span.End()
+	start := time.Now()
+	defer func() {
+		observability.RecordSpanResult(span, retErr, time.Since(start))
+	}()
+	span.SetAttributes(attribute.Int("bench.warmup.calls", opts.Warmup))
+
+	cl := grpcburnerv1.NewBurnerClient(conn)
+	var (
+		next   atomic.Int64
+		failed atomic.Int64
+		wg     sync.WaitGroup
+	)
+	for u := 0; u < min(opts.Concurrency, opts.Warmup); u++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(opts.Warmup) {
+				cctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+				if _, err := cl.Ping(cctx, &grpcburnerv1.PingRequest{}); err != nil {
+					failed.Add(1)
+				}
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	fields := []any{
+		"trace_id", span.SpanContext().TraceID().String(),
+		"run_id", opts.RunID,
+		"calls", opts.Warmup,
+		"failed", failed.Load(),
+		"latency_ms", time.Since(start).Milliseconds(),
+	}
+	if failed.Load() == int64(opts.Warmup) {
+		logger.Errorw("client bench warmup", fields...)
+		return errors.New("bench warmup: all calls failed")
+	}
+	if failed.Load() > 0 {
+		logger.Warnw("client bench warmup", fields...)
+	} else {
+		logger.Infow("client bench warmup", fields...)
+	}
+	return nil
+}
+
+// benchWarmupFor は計測の前に --warmup の時間だけ、計測と同じ --mix の呼び出しを --concurrency 並列で送る。
+// Ping だけでは温まらないサーバー側のキャッシュやメモリの確保、GC のペースなども済ませてから計測し、
+// 立ち上がりの遅い呼び出しで p99 が歪まないようにする。loadtest では --rps の間隔で送る(0 なら間を空けない)。結果は統計に含めない
+func benchWarmupFor(ctx context.Context, tracer trace.Tracer, conn *grpc.ClientConn, opts *options, logger *zap.SugaredLogger) (retErr error) {
+	ctx, span := tracer.Start(unmeasured(ctx), "grpc.client/Bench.warmup")
+	defer span.End()
+	start := time.Now()
+	defer func() {
+		observability.RecordSpanResult(span, retErr, time.Since(start))
+	}()
+	span.SetAttributes(attribute.String("bench.warmup.duration", opts.WarmupDuration.String()))
+
+	configs, err := mixWorkConfigs(opts)
+	if err != nil {
+		return err
+	}
+	rep32, err := mustInt32("repeat", opts.Repeat)
+	if err != nil {
+		return err
+	}
+	b := &benchCaller{
+		burner: grpcburnerv1.NewBurnerClient(conn),
+		health: healthpb.NewHealthClient(conn),
+		repeat: rep32,
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, opts.WarmupDuration)
+	defer cancel()
+	// loadtest の目標レートを超えて送らないよう、--rps の間隔で呼び出しの枠を配る
+	var pace <-chan time.Time
+	if opts.Mode == "loadtest" && opts.RPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.RPS))
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+
+	var (
+		calls  atomic.Int64
+		failed atomic.Int64
+		wg     sync.WaitGroup
+	)
+	for u := 0; u < opts.Concurrency; u++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for {
+				if pace != nil {
+					select {
+					case <-pace:
+					case <-wctx.Done():
+						return
+					}
+				}
+				if wctx.Err() != nil {
+					return
+				}
+				armIdx := opts.Mix.pick(rng)
+				// 計測と同じ timeout で呼び出し、warmup の終わりで実行中の呼び出しを打ち切らない
+				cctx, ccancel := context.WithTimeout(ctx, opts.Timeout)
+				err := b.call(cctx, opts.Mix[armIdx].Mode, configs[armIdx])
+				ccancel()
+				calls.Add(1)
+				if err != nil {
+					failed.Add(1)
+				}
+			}
+		}(rand.New(rand.NewSource(time.Now().UnixNano() + int64(u))))
+	}
+	wg.Wait()
+
+	fields := []any{
+		"trace_id", span.SpanContext().TraceID().String(),
+		"run_id", opts.RunID,
+		"duration", opts.WarmupDuration.String(),
+		"calls", calls.Load(),
+		"failed", failed.Load(),
+		"latency_ms", time.Since(start).Milliseconds(),
+	}
+	span.SetAttributes(attribute.Int64("bench.warmup.calls", calls.Load()))
+	if calls.Load() > 0 && failed.Load() == calls.Load() {
+		logger.Errorw("client bench warmup", fields...)
+		return errors.New("bench warmup: all calls failed")
+	}
+	if failed.Load() > 0 {
+		logger.Warnw("client bench warmup", fields...)
+	} else {
+		logger.Infow("client bench warmup", fields...)
+	}
+	return nil
+}
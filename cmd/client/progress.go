@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+)
+
+// clientWorkStream は do-work-client が使うストリームの操作。
+// 通常の DoWorkClientStreaming と、途中経過を返す BurnerProgress のどちらでも同じように送信できるようにする
+type clientWorkStream interface {
+	Send(*grpcburnerv1.DoWorkRequest) error
+	CloseAndRecv() (*grpcburnerv1.DoWorkSummary, error)
+	Trailer() metadata.MD
+	Context() context.Context
+}
+
+// progressStream は BurnerProgress の双方向ストリームを clientWorkStream として扱う。
+// 送信と並行して途中経過の集計を受信し、ログとストリームの span の event に記録する
+type progressStream struct {
+	grpc.BidiStreamingClient[grpcburnerv1.DoWorkRequest, grpcburnerv1.DoWorkSummary]
+
+	done chan struct{}
+	last *grpcburnerv1.DoWorkSummary
+	err  error
+}
+
+// openProgressStream は --summary-every の間隔で途中経過を返すストリームを開く
+func openProgressStream(ctx context.Context, conn *grpc.ClientConn, every int, logger *zap.SugaredLogger, out *resultWriter) (*progressStream, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, appserver.SummaryEveryMetadataKey, fmt.Sprint(every))
+	stream, err := appserver.OpenProgressStream(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	p := &progressStream{BidiStreamingClient: stream, done: make(chan struct{})}
+	go p.recvLoop(stream.Context(), logger, out)
+	return p, nil
+}
+
+// recvLoop は EOF までの集計を受信し、受信するごとにログに出す。最後に受信したものが最終集計になる。
+// 前回より failed が増えた時は warn にして、最終集計を待たずに途中の失敗に気付けるようにする
+func (p *progressStream) recvLoop(ctx context.Context, logger *zap.SugaredLogger, out *resultWriter) {
+	defer close(p.done)
+	span := trace.SpanFromContext(ctx)
+
+	for {
+		s, err := p.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			p.err = err
+			return
+		}
+		// メッセージ数が間隔の倍数なら、最後の途中経過と最終集計は同じ内容になるため 2 回は出さない
+		if p.last == nil || s.GetTotal() != p.last.GetTotal() {
+			logProgress(logger, out, span, s, p.last.GetFailed())
+		}
+		p.last = s
+	}
+}
+
+func logProgress(logger *zap.SugaredLogger, out *resultWriter, span trace.Span, s *grpcburnerv1.DoWorkSummary, prevFailed int32) {
+	span.AddEvent("stream.progress", trace.WithAttributes(
+		attribute.Int("summary.total", int(s.GetTotal())),
+		attribute.Int("summary.success", int(s.GetSuccess())),
+		attribute.Int("summary.failed", int(s.GetFailed())),
+	))
+	fields := []any{
+		"trace_id", span.SpanContext().TraceID().String(),
+		"summary_total", s.GetTotal(),
+		"summary_success", s.GetSuccess(),
+		"summary_failed", s.GetFailed(),
+		"failed_since_last", s.GetFailed() - prevFailed,
+	}
+	if s.GetFailed() > prevFailed {
+		logger.Warnw("client stream progress", fields...)
+	} else {
+		logger.Infow("client stream progress", fields...)
+	}
+	out.printf("client stream progress: total=%d success=%d failed=%d\n", s.GetTotal(), s.GetSuccess(), s.GetFailed())
+}
+
+// CloseAndRecv は送信を終え、最終集計を返す
+func (p *progressStream) CloseAndRecv() (*grpcburnerv1.DoWorkSummary, error) {
+	if err := p.CloseSend(); err != nil {
+		return nil, err
+	}
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.last == nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return p.last, nil
+}
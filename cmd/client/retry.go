@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+const (
+	defaultRetryCodes = "UNAVAILABLE,DEADLINE_EXCEEDED"
+	// retryJitter は待ち時間に掛けるばらつきの幅(±20%、gRPC の再接続のバックオフと同じ)
+	retryJitter = 0.2
+)
+
+// retryPolicy は --retries / --retry-backoff / --retry-max-backoff / --retry-codes で指定する、クライアント側の Unary のリトライ。
+// サーバーのローリングリスタート中の UNAVAILABLE のような一時的な失敗を、jitter 付きの指数バックオフで再試行する
+type retryPolicy struct {
+	// Retries は最初の呼び出しに加えて再試行する回数の上限。0 なら再試行しない
+	Retries int
+	// Backoff は 1 回目の再試行までの待ち時間。以降は 2 倍ずつ MaxBackoff まで増やす
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Codes は再試行するステータス
+	Codes []codes.Code
+}
+
+// newRetryPolicy は --retries / --retry-backoff / --retry-max-backoff / --retry-codes の値を検証する
+func newRetryPolicy(retries int, backoff, maxBackoff time.Duration, codeList string) (retryPolicy, error) {
+	switch {
+	case retries < 0:
+		return retryPolicy{}, fmt.Errorf("retries must be >= 0, got %d", retries)
+	case backoff <= 0:
+		return retryPolicy{}, fmt.Errorf("retry-backoff must be > 0, got %s", backoff)
+	case maxBackoff < backoff:
+		return retryPolicy{}, fmt.Errorf("retry-max-backoff (%s) must be >= retry-backoff (%s)", maxBackoff, backoff)
+	}
+	p := retryPolicy{Retries: retries, Backoff: backoff, MaxBackoff: maxBackoff}
+	for _, v := range strings.Split(codeList, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		c, err := appserver.ParseCode(v)
+		if err != nil {
+			return retryPolicy{}, fmt.Errorf("retry-codes: %w", err)
+		}
+		if c == codes.OK {
+			return retryPolicy{}, fmt.Errorf("retry-codes: OK is not a failure")
+		}
+		p.Codes = append(p.Codes, c)
+	}
+	if retries > 0 && len(p.Codes) == 0 {
+		return retryPolicy{}, fmt.Errorf("retry-codes must list at least one status when retries > 0")
+	}
+	return p, nil
+}
+
+func (p retryPolicy) enabled() bool {
+	return p.Retries > 0
+}
+
+func (p retryPolicy) codeNames() []string {
+	names := make([]string, len(p.Codes))
+	for i, c := range p.Codes {
+		names[i] = c.String()
+	}
+	return names
+}
+
+// retryable は err が再試行するステータスかどうかを返す
+func (p retryPolicy) retryable(err error) bool {
+	return err != nil && slices.Contains(p.Codes, status.Code(err))
+}
+
+// delay は n 回目(1 始まり)の再試行までの待ち時間。Backoff × 2^(n-1) を MaxBackoff で抑え、±retryJitter のばらつきを加える。
+// 全クライアントが同じ間隔で再試行して、再起動したサーバーに一斉に届くのを避ける
+func (p retryPolicy) delay(n int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+	return time.Duration(float64(d) * (1 + retryJitter*(rand.Float64()*2-1)))
+}
+
+// dialOptions は再試行の interceptor を返す。chain の最も内側(--expect-* の記録より内側)に置くため、
+// span・開始/終了ログ・合格条件は再試行を含めた 1 回の呼び出しとして扱い、試行ごとの結果は "client retry" ログに出す。
+// サービス設定のリトライ(--fetch-config)と重ねて試行回数が掛け算にならないよう、gRPC 本体のリトライは無効にする
+func (p retryPolicy) dialOptions(logger *zap.SugaredLogger, out *resultWriter) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithDisableRetry(),
+		grpc.WithChainUnaryInterceptor(p.unaryInterceptor(logger, out)),
+	}
+}
+
+func (p retryPolicy) unaryInterceptor(logger *zap.SugaredLogger, out *resultWriter) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		attempts := attemptCounterFromContext(ctx)
+		for attempt := 1; ; attempt++ {
+			attempts.Store(int32(attempt))
+			err := invoker(ctx, method, req, reply, cc, callOpts...)
+			// 呼び出し全体の期限が切れた後や取り消された後は、再試行しても同じ結果になる
+			if attempt > p.Retries || !p.retryable(err) || ctx.Err() != nil {
+				return err
+			}
+
+			wait := p.delay(attempt)
+			out.addRetry()
+			logger.Warnw("client retry",
+				"trace_id", trace.SpanFromContext(ctx).SpanContext().TraceID().String(),
+				"method", method,
+				"request_id", outgoingRequestID(ctx),
+				"attempt", attempt,
+				"max_attempts", p.Retries+1,
+				"code", status.Code(err).String(),
+				"error", err,
+				"backoff_ms", wait.Milliseconds(),
+			)
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+type attemptCounterKey struct{}
+
+// withAttemptCounter は ctx で行う呼び出しの試行回数を受け取るカウンターを付ける。
+// 再試行の interceptor がなければ 0 のまま
+func withAttemptCounter(ctx context.Context) (context.Context, *atomic.Int32) {
+	n := new(atomic.Int32)
+	return context.WithValue(ctx, attemptCounterKey{}, n), n
+}
+
+// attemptCounterFromContext は withAttemptCounter のカウンターを返す。なければ捨てるだけのカウンター
+func attemptCounterFromContext(ctx context.Context) *atomic.Int32 {
+	if n, ok := ctx.Value(attemptCounterKey{}).(*atomic.Int32); ok {
+		return n
+	}
+	return new(atomic.Int32)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+// callReturnCode は ReturnCode で --return-code のステータスを返してもらい、その通りのコードが返ったかを確認する。
+// アラートルールやリトライポリシーの確認に使うため、要求したコードが返れば(エラーのコードでも)成功として終了する。
+// クライアントのリトライや途中の proxy でコードが変わった場合はエラーにする
+func callReturnCode(conn *grpc.ClientConn, opts *options) error {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	ctx = withLogFields(ctx, "requested_code", opts.ReturnCode.String())
+	err := appserver.CallReturnCode(ctx, conn, appserver.ReturnCodeRequest{
+		Code:    opts.ReturnCode,
+		Delay:   opts.ReturnCodeDelay,
+		Message: opts.ReturnCodeMessage,
+	})
+
+	got := status.Code(err)
+	opts.Out.setResult(returnCodeResult{RequestedCode: opts.ReturnCode.String(), Code: got.String()})
+	if got != opts.ReturnCode {
+		return fmt.Errorf("return-code: requested %s, got %s: %w", opts.ReturnCode, got, err)
+	}
+
+	opts.Out.printf("return-code: got %s as requested\n", got)
+	return nil
+}
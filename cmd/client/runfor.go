@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// runForModes は --run-for で繰り返し呼び出せる、1 回の実行で 1 つの RPC を送る mode
+var runForModes = map[string]bool{
+	"health":         true,
+	"":               true,
+	"ping":           true,
+	"debug-echo":     true,
+	"return-code":    true,
+	"do-work-unary":  true,
+	"do-work-server": true,
+	"do-work-client": true,
+	"do-work-bidi":   true,
+}
+
+// runForResult は --run-for の時に --output=json の result に載せる集計
+type runForResult struct {
+	RunFor      string         `json:"run_for"`
+	Iterations  int            `json:"iterations"`
+	Failed      int            `json:"failed"`
+	Codes       map[string]int `json:"codes"`
+	Interrupted bool           `json:"interrupted"`
+}
+
+// callRunFor は --run-for の間、--mode の呼び出しを前の呼び出しが終わるたびに繰り返す。
+// サーバーのメモリの推移を見る soak test のためのもので、呼び出しが失敗しても止めずに続け、最後に失敗があればエラーにする。
+// Ctrl+C では実行中の呼び出しを待ってから止め、それまでの集計を出す
+func callRunFor(conn *grpc.ClientConn, opts *options, directOpts []grpc.DialOption) error {
+	logger := observability.NewLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, opts.RunFor)
+	defer cancel()
+
+	logger.Infow("client run-for start",
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"run_for", opts.RunFor.String(),
+	)
+
+	res := runForResult{RunFor: opts.RunFor.String(), Codes: map[string]int{}}
+	start := time.Now()
+	for ctx.Err() == nil {
+		err := callMode(conn, opts, directOpts)
+		res.Iterations++
+		res.Codes[status.Code(err).String()]++
+		if err != nil {
+			res.Failed++
+			logger.Warnw("client run-for call failed", "run_id", opts.RunID, "iteration", res.Iterations, "error", err)
+		}
+	}
+	res.Interrupted = ctx.Err() == context.Canceled
+
+	fields := []any{
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"run_for", opts.RunFor.String(),
+		"iterations", res.Iterations,
+		"failed", res.Failed,
+		"codes", res.Codes,
+		"interrupted", res.Interrupted,
+		"latency_ms", time.Since(start).Milliseconds(),
+	}
+	if res.Failed > 0 {
+		logger.Errorw("client run-for end", fields...)
+	} else {
+		logger.Infow("client run-for end", fields...)
+	}
+	opts.Out.setResult(res)
+	opts.Out.printf("run-for: mode=%s iterations=%d failed=%d codes=%s interrupted=%v duration=%s\n",
+		opts.Mode, res.Iterations, res.Failed, formatCodeCounts(res.Codes), res.Interrupted, time.Since(start).Round(time.Millisecond))
+
+	if res.Failed > 0 {
+		return fmt.Errorf("run-for: %d/%d calls failed", res.Failed, res.Iterations)
+	}
+	return nil
+}
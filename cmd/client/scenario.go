@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/scenario"
+)
+
+// scenarioStepResult は "client scenario step end" ログと --output=json に載せる、1 ステップの結果
+type scenarioStepResult struct {
+	Step        string         `json:"step"`
+	Mode        string         `json:"mode"`
+	Requests    int            `json:"requests"`
+	Concurrency int            `json:"concurrency"`
+	Count       int            `json:"count"`
+	Failed      int            `json:"failed"`
+	Unexpected  int            `json:"unexpected"`
+	ExpectCodes []string       `json:"expect_codes"`
+	Codes       map[string]int `json:"codes"`
+	P50Ms       float64        `json:"p50_ms"`
+	P95Ms       float64        `json:"p95_ms"`
+	P99Ms       float64        `json:"p99_ms"`
+	MaxMs       float64        `json:"max_ms"`
+	DurationMs  float64        `json:"duration_ms"`
+	Passed      bool           `json:"passed"`
+}
+
+// scenarioResult は --output=json に載せるシナリオの結果
+type scenarioResult struct {
+	Name        string               `json:"name"`
+	Steps       []scenarioStepResult `json:"steps"`
+	FailedSteps int                  `json:"failed_steps"`
+}
+
+// scenarioTimeout はシナリオの中で最も時間のかかるステップに合わせた、1 回の呼び出しのタイムアウト(bench と同じ考え方)
+func scenarioTimeout(sc *scenario.Scenario) time.Duration {
+	d := baseTimeout
+	for _, st := range sc.Steps {
+		if st.Mode != scenario.ModePing {
+			d = max(d, st.CallTimeout())
+		}
+	}
+	return d
+}
+
+// callScenario は --scenario のステップを順番に実行し、ステップごとに結果を出す。
+// 各ステップは bench と同じ closed loop で concurrency 人の仮想ユーザーが合計 requests 回呼び出し、
+// 全ての呼び出しが expect_codes のいずれかを返せば合格とする。不合格のステップがあっても残りのステップは実行し、最後にエラーにする。
+// --scenario-state を指定した時はステップが終わるごとに進み具合を書き出し、中断した実行を次のステップから再開する
+func callScenario(conn *grpc.ClientConn, opts *options) (retErr error) {
+	logger := observability.NewLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+	sc := opts.Scenario
+
+	// Ctrl+C では実行中のステップを打ち切り、完了したステップまでを状態ファイルに残して終わる
+	ctx, stop := signal.NotifyContext(withoutCallLog(context.Background()), os.Interrupt)
+	defer stop()
+
+	state := opts.Resume
+	if state == nil {
+		fp, err := sc.Fingerprint()
+		if err != nil {
+			return err
+		}
+		seed := opts.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		state = &scenarioState{Scenario: sc.Name, Fingerprint: fp, RunID: opts.RunID, Seed: seed}
+	} else if parent, ok := resumedSpanContext(state); ok {
+		// 再開した span を最初の実行の span の子にし、1 つのトレースとして Tempo で追えるようにする
+		ctx = trace.ContextWithRemoteSpanContext(ctx, parent)
+	}
+	resumed := len(state.Steps)
+
+	tracer := otel.Tracer("cno-app-client")
+	// 呼び出しごとのログは出さず、ステップごとの集計を出す
+	ctx, span := tracer.Start(ctx, "grpc.client/Scenario",
+		trace.WithAttributes(attribute.String("scenario.name", sc.Name)))
+	defer span.End()
+	spanStart := time.Now()
+	defer func() {
+		observability.RecordSpanResult(span, retErr, time.Since(spanStart))
+	}()
+	traceID := span.SpanContext().TraceID().String()
+	if state.TraceID == "" {
+		state.TraceID = traceID
+		state.SpanID = span.SpanContext().SpanID().String()
+	}
+
+	if opts.ScenarioState != "" && resumed == 0 {
+		// 最初のステップの途中で止まっても同じ run_id とトレースで再開できるよう、開始時にも書き出す
+		if err := state.save(opts.ScenarioState); err != nil {
+			return err
+		}
+	}
+
+	seed := state.Seed
+	span.SetAttributes(
+		attribute.Int64("scenario.seed", seed),
+		attribute.Int("scenario.resumed_steps", resumed),
+	)
+	rng := rand.New(rand.NewSource(seed))
+	// 完了したステップが使った分の乱数を読み飛ばし、中断しなかった場合と同じ think time にする
+	for _, st := range sc.Steps[:resumed] {
+		for range st.Concurrency {
+			rng.Int63()
+		}
+	}
+
+	logger.Infow("client scenario start",
+		"trace_id", traceID,
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"scenario", sc.Name,
+		"steps", len(sc.Steps),
+		"seed", seed,
+		"resumed_steps", resumed,
+	)
+
+	caller := &benchCaller{
+		burner: grpcburnerv1.NewBurnerClient(conn),
+		health: healthpb.NewHealthClient(conn),
+	}
+	res := scenarioResult{Name: sc.Name, Steps: make([]scenarioStepResult, 0, len(sc.Steps))}
+	for _, r := range state.Steps {
+		res.Steps = append(res.Steps, r)
+		if !r.Passed {
+			res.FailedSteps++
+		}
+		opts.Out.printf("scenario: step=%s mode=%s result=%s (completed before resume)\n", r.Step, r.Mode, stepResultName(r))
+	}
+	for i := resumed; i < len(sc.Steps); i++ {
+		st := sc.Steps[i]
+		r, err := runScenarioStep(ctx, tracer, caller, st, opts, rng)
+		if err != nil {
+			return fmt.Errorf("scenario step %q: %w", st.Name, err)
+		}
+		if ctx.Err() != nil {
+			// 打ち切ったステップの結果は残さず、再開時に最初からやり直す
+			logger.Warnw("client scenario interrupted",
+				"trace_id", traceID,
+				"run_id", opts.RunID,
+				"scenario", sc.Name,
+				"step", st.Name,
+				"completed_steps", len(state.Steps),
+				"state_file", opts.ScenarioState,
+			)
+			if opts.ScenarioState == "" {
+				return fmt.Errorf("scenario %s interrupted at step %q", sc.Name, st.Name)
+			}
+			return fmt.Errorf("scenario %s interrupted at step %q; run again with --scenario-state %s to resume", sc.Name, st.Name, opts.ScenarioState)
+		}
+		res.Steps = append(res.Steps, r)
+		if !r.Passed {
+			res.FailedSteps++
+		}
+		state.Steps = append(state.Steps, r)
+		if opts.ScenarioState != "" && i < len(sc.Steps)-1 {
+			if err := state.save(opts.ScenarioState); err != nil {
+				return err
+			}
+		}
+
+		fields := []any{
+			"trace_id", traceID,
+			"run_id", opts.RunID,
+			"scenario", sc.Name,
+			"step", r.Step,
+			"step_mode", r.Mode,
+			"requests", r.Requests,
+			"concurrency", r.Concurrency,
+			"failed", r.Failed,
+			"unexpected", r.Unexpected,
+			"expect_codes", r.ExpectCodes,
+			"codes", r.Codes,
+			"p50_ms", r.P50Ms,
+			"p95_ms", r.P95Ms,
+			"p99_ms", r.P99Ms,
+			"max_ms", r.MaxMs,
+			"latency_ms", r.DurationMs,
+			"passed", r.Passed,
+		}
+		if r.Passed {
+			logger.Infow("client scenario step end", fields...)
+		} else {
+			logger.Errorw("client scenario step end", fields...)
+		}
+		opts.Out.printf("scenario: step=%s mode=%s count=%d failed=%d unexpected=%d codes=%s p50=%.1fms p95=%.1fms p99=%.1fms max=%.1fms result=%s\n",
+			r.Step, r.Mode, r.Count, r.Failed, r.Unexpected, formatCodeCounts(r.Codes), r.P50Ms, r.P95Ms, r.P99Ms, r.MaxMs, stepResultName(r))
+
+		if st.Pause > 0 && i < len(sc.Steps)-1 {
+			select {
+			case <-time.After(time.Duration(st.Pause)):
+			case <-ctx.Done():
+			}
+		}
+	}
+	// 全てのステップが終わった実行は再開しない
+	if opts.ScenarioState != "" {
+		if err := os.Remove(opts.ScenarioState); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Warnw("failed to remove scenario state", "state_file", opts.ScenarioState, "error", err)
+		}
+	}
+
+	fields := []any{
+		"trace_id", traceID,
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"scenario", sc.Name,
+		"steps", len(sc.Steps),
+		"failed_steps", res.FailedSteps,
+		"latency_ms", time.Since(spanStart).Milliseconds(),
+	}
+	if res.FailedSteps > 0 {
+		logger.Errorw("client scenario end", fields...)
+	} else {
+		logger.Infow("client scenario end", fields...)
+	}
+	opts.Out.setResult(res)
+	opts.Out.printf("scenario: name=%s steps=%d failed_steps=%d duration=%s\n",
+		sc.Name, len(sc.Steps), res.FailedSteps, time.Since(spanStart).Round(time.Millisecond))
+
+	if res.FailedSteps > 0 {
+		return fmt.Errorf("scenario %s: %d/%d steps failed", sc.Name, res.FailedSteps, len(sc.Steps))
+	}
+	return nil
+}
+
+// runScenarioStep は 1 ステップを concurrency 人の仮想ユーザーで requests 回呼び出し、結果を集計する
+func runScenarioStep(ctx context.Context, tracer trace.Tracer, caller *benchCaller, st scenario.Step, opts *options, rng *rand.Rand) (scenarioStepResult, error) {
+	expected, err := st.ExpectedCodes()
+	if err != nil {
+		return scenarioStepResult{}, err
+	}
+	var wc *grpcburnerv1.WorkConfig
+	if st.Mode != scenario.ModePing {
+		if wc, err = st.Work.WorkConfig(); err != nil {
+			return scenarioStepResult{}, err
+		}
+	}
+	rep32, err := mustInt32("repeat", st.Repeat)
+	if err != nil {
+		return scenarioStepResult{}, err
+	}
+	b := *caller
+	b.repeat = rep32
+
+	ctx, span := tracer.Start(ctx, "grpc.client/Scenario.step", trace.WithAttributes(
+		attribute.String("scenario.step", st.Name),
+		attribute.String("scenario.step_mode", st.Mode),
+	))
+	defer span.End()
+
+	// think time は仮想ユーザーごとの乱数で取り出す(rand.Rand は goroutine 間で共有できない)
+	userRngs := make([]*rand.Rand, st.Concurrency)
+	for u := range userRngs {
+		userRngs[u] = rand.New(rand.NewSource(rng.Int63()))
+	}
+
+	stats := armStats{codes: map[string]int{}}
+	start := time.Now()
+	var (
+		mu   sync.Mutex
+		next atomic.Int64
+		wg   sync.WaitGroup
+	)
+	for u := 0; u < st.Concurrency; u++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for first := true; ctx.Err() == nil; first = false {
+				i := int(next.Add(1) - 1)
+				if i >= st.Requests {
+					return
+				}
+				if !first && st.ThinkTime.Enabled() {
+					time.Sleep(st.ThinkTime.Sample(rng))
+				}
+
+				// 呼び出しごとに別トレースとし、ステップの span へリンクする
+				ictx, ispan := observability.StartIterationSpan(ctx, tracer, "grpc.client/Scenario.iteration", i)
+				ispan.SetAttributes(attribute.String("scenario.step", st.Name))
+				cctx, cancel := context.WithTimeout(ictx, opts.Timeout)
+				istart := time.Now()
+				err := b.call(cctx, st.Mode, wc)
+				elapsed := time.Since(istart)
+				cancel()
+				observability.RecordSpanResult(ispan, err, elapsed)
+				ispan.End()
+
+				mu.Lock()
+				stats.latencies = append(stats.latencies, elapsed)
+				stats.codes[status.Code(err).String()]++
+				mu.Unlock()
+			}
+		}(userRngs[u])
+	}
+	wg.Wait()
+
+	sum := summarizeArm(st.Name, stats)
+	r := scenarioStepResult{
+		Step:        st.Name,
+		Mode:        st.Mode,
+		Requests:    st.Requests,
+		Concurrency: st.Concurrency,
+		Count:       sum.Count,
+		Failed:      sum.Failed,
+		Codes:       sum.Codes,
+		P50Ms:       sum.P50Ms,
+		P95Ms:       sum.P95Ms,
+		P99Ms:       sum.P99Ms,
+		MaxMs:       sum.MaxMs,
+		DurationMs:  durationMs(time.Since(start)),
+	}
+	for _, c := range expected {
+		r.ExpectCodes = append(r.ExpectCodes, c.String())
+	}
+	for name, n := range r.Codes {
+		if !slices.ContainsFunc(expected, func(c codes.Code) bool { return c.String() == name }) {
+			r.Unexpected += n
+		}
+	}
+	r.Passed = r.Unexpected == 0
+
+	var stepErr error
+	if !r.Passed {
+		stepErr = fmt.Errorf("%d/%d calls returned an unexpected code", r.Unexpected, r.Count)
+	}
+	span.SetAttributes(attribute.Int("scenario.unexpected", r.Unexpected))
+	observability.RecordSpanResult(span, stepErr, time.Since(start))
+	return r, nil
+}
+
+// stepResultName はステップの合否を text 出力の result に使う名前にする
+func stepResultName(r scenarioStepResult) string {
+	if r.Passed {
+		return "pass"
+	}
+	return "fail"
+}
+
+// resumedSpanContext は状態ファイルに残した最初の実行の span を、再開した span の親として使える形にする
+func resumedSpanContext(st *scenarioState) (trace.SpanContext, bool) {
+	traceID, err := trace.TraceIDFromHex(st.TraceID)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	spanID, err := trace.SpanIDFromHex(st.SpanID)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	}), true
+}
+
+// formatCodeCounts はステータスごとの件数を "OK:10,UNAVAILABLE:2" の形にする(名前順)
+func formatCodeCounts(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ":" + strconv.Itoa(counts[name])
+	}
+	return strings.Join(parts, ",")
+}
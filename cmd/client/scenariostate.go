@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/scenario"
+)
+
+// scenarioState は --scenario-state に書き出す、実行中のシナリオの進み具合。
+// 中断(Ctrl+C やクラッシュ)した実行を、完了したステップの次から同じ run_id / seed / トレースで再開するために使う
+type scenarioState struct {
+	Scenario string `json:"scenario"`
+	// Fingerprint はシナリオの内容のハッシュ。シナリオを書き換えた後に古い状態から再開しないよう確かめる
+	Fingerprint string `json:"fingerprint"`
+	RunID       string `json:"run_id"`
+	Seed        int64  `json:"seed"`
+	// TraceID / SpanID は最初の実行の grpc.client/Scenario span。再開した span をその子にして同じトレースに入れる
+	TraceID string `json:"trace_id"`
+	SpanID  string `json:"span_id"`
+	// Steps は完了したステップの結果(シナリオの先頭から順番)
+	Steps     []scenarioStepResult `json:"steps"`
+	UpdatedAt time.Time            `json:"updated_at"`
+}
+
+// loadScenarioState は path の状態ファイルを読み込む。ファイルがなければ nil を返す(最初から実行する)
+func loadScenarioState(path string, sc *scenario.Scenario) (*scenarioState, error) {
+	b, err := os.ReadFile(path) //nolint:gosec
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read scenario state: %w", err)
+	}
+	var st scenarioState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("parse scenario state %s: %w", path, err)
+	}
+	fp, err := sc.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	if st.Fingerprint != fp {
+		return nil, fmt.Errorf("scenario state %s was written for a different version of scenario %q; delete it to start over", path, st.Scenario)
+	}
+	if len(st.Steps) >= len(sc.Steps) {
+		return nil, fmt.Errorf("scenario state %s: all %d steps already completed; delete it to start over", path, len(sc.Steps))
+	}
+	return &st, nil
+}
+
+// save は状態を path へ書き出す。書き込み中に止まっても前の状態が残るよう、一時ファイルから置き換える
+func (st *scenarioState) save(path string) error {
+	st.UpdatedAt = time.Now().UTC()
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal scenario state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write scenario state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write scenario state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// soakWindow は soak の --soak-report-every ごとの集計
+type soakWindow struct {
+	// ElapsedMs はストリームを開いてからこの区間の終わりまでの時間
+	ElapsedMs float64 `json:"elapsed_ms"`
+	Sent      int     `json:"sent"`
+	Failed    int     `json:"failed"`
+	P50Ms     float64 `json:"p50_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	// Drift は最初の区間の p50 に対するこの区間の p50 の比。長時間のストリームで遅くなっていく(サーバーのメモリ増加や GC)傾向を見る
+	Drift float64 `json:"drift"`
+	// LagMs は --rps の予定時刻から実際に送った時刻までの遅れの最大値。応答が --rps の間隔より遅いと増える
+	LagMs float64 `json:"lag_ms"`
+}
+
+// soakResult は --output=json に載せる soak の結果
+type soakResult struct {
+	Duration    string       `json:"duration"`
+	Sent        int          `json:"sent"`
+	Failed      int          `json:"failed"`
+	Interrupted bool         `json:"interrupted"`
+	StreamError string       `json:"stream_error,omitempty"`
+	Windows     []soakWindow `json:"windows"`
+}
+
+// soakStats は 1 区間の途中の集計
+type soakStats struct {
+	latencies []time.Duration
+	failed    int
+	lag       time.Duration
+}
+
+func (s *soakStats) window(elapsed time.Duration) soakWindow {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	w := soakWindow{
+		ElapsedMs: durationMs(elapsed),
+		Sent:      len(s.latencies),
+		Failed:    s.failed,
+		P50Ms:     percentileMs(s.latencies, 0.50),
+		P99Ms:     percentileMs(s.latencies, 0.99),
+		LagMs:     durationMs(s.lag),
+	}
+	if n := len(s.latencies); n > 0 {
+		w.MaxMs = durationMs(s.latencies[n-1])
+	}
+	return w
+}
+
+// callSoak は 1 本の双方向ストリーム(DoWorkBidiStreaming)を --duration の間開いたままにし、
+// --rps の間隔で小さな負荷を送り続ける。長く生きるストリームでのサーバーのメモリの増加を見るためのもので、
+// --soak-report-every ごとに件数・失敗・レイテンシと最初の区間からの変化(drift)をログと標準出力に出す。
+// 1 メッセージごとの span やログは量が多くなるため作らず、区間の集計をストリームの span の event に残す。
+// ok=false の応答は数えて続けるが、ストリーム自体が切れたら(サーバーのストリーム上限を含む)そこで終える。
+// Ctrl+C では応答待ちのメッセージを受け取ってから止め、それまでの集計を出す
+func callSoak(conn *grpc.ClientConn, opts *options) error {
+	logger := observability.NewLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	wc, err := workConfigFromOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	// ストリームは Ctrl+C で切らず、送信だけを止めてから閉じる
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	ctx = withLogFields(ctx, "work_mode", opts.WorkMode, "duration", opts.Duration.String())
+
+	stream, err := grpcburnerv1.NewBurnerClient(conn).DoWorkBidiStreaming(ctx)
+	if err != nil {
+		return fmt.Errorf("soak: open stream: %w", err)
+	}
+	span := trace.SpanFromContext(stream.Context())
+
+	logger.Infow("client soak start",
+		"run_id", opts.RunID,
+		"addr", opts.Addr,
+		"trace_id", span.SpanContext().TraceID().String(),
+		"duration", opts.Duration.String(),
+		"rps", opts.RPS,
+		"report_every", opts.SoakReportEvery.String(),
+		"work_mode", opts.WorkMode,
+		"work_duration", opts.WorkDuration.String(),
+	)
+
+	var interval time.Duration
+	if opts.RPS > 0 {
+		interval = time.Duration(float64(time.Second) / opts.RPS)
+	}
+	res := soakResult{Duration: opts.Duration.String()}
+	var (
+		cur         soakStats
+		baselineP50 float64
+		streamErr   error
+	)
+	start := time.Now()
+	end := start.Add(opts.Duration)
+	nextReport := start.Add(opts.SoakReportEvery)
+	flush := func(now time.Time) {
+		w := cur.window(now.Sub(start))
+		if baselineP50 == 0 {
+			baselineP50 = w.P50Ms
+		}
+		if baselineP50 > 0 {
+			w.Drift = w.P50Ms / baselineP50
+		}
+		res.Windows = append(res.Windows, w)
+		logSoakWindow(logger, opts, span, now.Sub(start), w)
+		cur = soakStats{}
+	}
+
+	for i := 0; ; i++ {
+		due := start
+		if interval > 0 {
+			due = start.Add(time.Duration(i) * interval)
+		}
+		now := time.Now()
+		if due.After(now) {
+			// 次の送信が --duration の後になる(--rps が小さい)時は、終了時刻で起きる
+			wake := due
+			if wake.After(end) {
+				wake = end
+			}
+			timer := time.NewTimer(wake.Sub(now))
+			select {
+			case <-timer.C:
+			case <-sigCtx.Done():
+				timer.Stop()
+			}
+			now = time.Now()
+		} else if interval > 0 {
+			cur.lag = max(cur.lag, now.Sub(due))
+		}
+		for !now.Before(nextReport) {
+			flush(nextReport)
+			nextReport = nextReport.Add(opts.SoakReportEvery)
+		}
+		if sigCtx.Err() != nil || !now.Before(end) {
+			break
+		}
+
+		req := &grpcburnerv1.DoWorkRequest{RequestId: uuid.New().String(), Config: wc}
+		msgStart := time.Now()
+		if streamErr = stream.Send(req); streamErr != nil {
+			if streamErr == io.EOF {
+				// サーバーがストリームを終了した(上限超過など)。実際のステータスは Recv で受け取る
+				_, streamErr = stream.Recv()
+			}
+			break
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			streamErr = err
+			break
+		}
+		cur.latencies = append(cur.latencies, time.Since(msgStart))
+		res.Sent++
+		if !resp.GetOk() {
+			cur.failed++
+			res.Failed++
+		}
+	}
+	res.Interrupted = sigCtx.Err() != nil
+	if len(cur.latencies) > 0 || cur.failed > 0 {
+		flush(time.Now())
+	}
+
+	if streamErr == nil {
+		if err := stream.CloseSend(); err != nil {
+			streamErr = err
+		} else if _, err := stream.Recv(); err != io.EOF {
+			streamErr = err
+		}
+	}
+	if streamErr != nil {
+		res.StreamError = streamErr.Error()
+	}
+	elapsed := time.Since(start)
+
+	fields := []any{
+		"run_id", opts.RunID,
+		"addr", opts.Addr,
+		"trace_id", span.SpanContext().TraceID().String(),
+		"duration", opts.Duration.String(),
+		"sent", res.Sent,
+		"failed", res.Failed,
+		"windows", len(res.Windows),
+		"interrupted", res.Interrupted,
+		"latency_ms", elapsed.Milliseconds(),
+	}
+	if streamErr != nil {
+		logger.Errorw("client soak end", append(fields, "error", streamErr)...)
+	} else if res.Failed > 0 {
+		logger.Warnw("client soak end", fields...)
+	} else {
+		logger.Infow("client soak end", fields...)
+	}
+	opts.Out.setResult(res)
+	opts.Out.printf("soak: sent=%d failed=%d windows=%d interrupted=%v duration=%s\n",
+		res.Sent, res.Failed, len(res.Windows), res.Interrupted, elapsed.Round(time.Millisecond))
+
+	if streamErr != nil {
+		return fmt.Errorf("soak: stream ended after %s (%d messages): %w", elapsed.Round(time.Second), res.Sent, streamErr)
+	}
+	if res.Failed > 0 {
+		return fmt.Errorf("soak: %d/%d messages failed", res.Failed, res.Sent)
+	}
+	return nil
+}
+
+// logSoakWindow は 1 区間の集計をログ・標準出力・ストリームの span の event に出す。失敗があった区間は warn にする
+func logSoakWindow(logger *zap.SugaredLogger, opts *options, span trace.Span, elapsed time.Duration, w soakWindow) {
+	span.AddEvent("soak.window", trace.WithAttributes(
+		attribute.Int("soak.sent", w.Sent),
+		attribute.Int("soak.failed", w.Failed),
+		attribute.Float64("soak.p50_ms", w.P50Ms),
+		attribute.Float64("soak.p99_ms", w.P99Ms),
+		attribute.Float64("soak.drift", w.Drift),
+	))
+	fields := []any{
+		"run_id", opts.RunID,
+		"trace_id", span.SpanContext().TraceID().String(),
+		"elapsed", elapsed.Round(time.Second).String(),
+		"sent", w.Sent,
+		"failed", w.Failed,
+		"p50_ms", w.P50Ms,
+		"p99_ms", w.P99Ms,
+		"max_ms", w.MaxMs,
+		"drift", w.Drift,
+		"lag_ms", w.LagMs,
+	}
+	if w.Failed > 0 {
+		logger.Warnw("client soak window", fields...)
+	} else {
+		logger.Infow("client soak window", fields...)
+	}
+	opts.Out.printf("soak [%s]: sent=%d failed=%d p50=%.1fms p99=%.1fms drift=%.2f lag=%.1fms\n",
+		elapsed.Round(time.Second), w.Sent, w.Failed, w.P50Ms, w.P99Ms, w.Drift, w.LagMs)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// stormResult は --output=json に載せる stream-storm の結果
+type stormResult struct {
+	Streams int            `json:"streams"`
+	Failed  int            `json:"failed"`
+	Codes   map[string]int `json:"codes"`
+}
+
+// callStreamStorm は 1 本のコネクション上で DoWorkServerStreaming を --streams 本同時に開き、
+// サーバーの MaxConcurrentStreams を超えた際のストリーム枯渇(待ち/タイムアウト)を再現する
+func callStreamStorm(conn *grpc.ClientConn, opts *options) (retErr error) {
+	logger := observability.NewLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	// ストリームごとのログは出さず、最後にコードごとの件数をまとめて出す
+	ctx, cancel := context.WithTimeout(withoutCallLog(context.Background()), opts.Timeout)
+	defer cancel()
+
+	tracer := otel.Tracer("cno-app-client")
+	ctx, span := tracer.Start(ctx, "grpc.client/Burner.StreamStorm")
+	defer span.End()
+	spanStart := time.Now()
+	defer func() {
+		observability.RecordSpanResult(span, retErr, time.Since(spanStart))
+	}()
+
+	traceID := trace.SpanFromContext(ctx).SpanContext().TraceID().String()
+
+	wc, err := workConfigFromOptions(opts)
+	if err != nil {
+		return err
+	}
+	rep32, err := mustInt32("repeat", opts.Repeat)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	logger.Infow("client stream storm start",
+		"trace_id", traceID,
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"streams", opts.Streams,
+		"repeat", opts.Repeat,
+		"work_mode", opts.WorkMode,
+	)
+
+	cl := grpcburnerv1.NewBurnerClient(conn)
+
+	var (
+		mu    sync.Mutex
+		codes = map[string]int{}
+		wg    sync.WaitGroup
+	)
+
+	for i := 0; i < opts.Streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// ストリームごとに別トレースとし、run のルート span へリンクする
+			ictx, ispan := observability.StartIterationSpan(ctx, tracer, "grpc.client/Burner.StreamStorm.iteration", i)
+			defer ispan.End()
+			istart := time.Now()
+
+			ictx, requestID := withRequestID(ictx)
+			err := drainServerStream(ictx, cl, &grpcburnerv1.DoWorkServerStreamingRequest{
+				RequestId: requestID,
+				Config:    wc,
+				Repeat:    rep32,
+			})
+			observability.RecordSpanResult(ispan, err, time.Since(istart))
+
+			code := status.Code(err).String()
+			mu.Lock()
+			codes[code]++
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	latencyMs := time.Since(start).Milliseconds()
+
+	failed := opts.Streams - codes["OK"]
+	fields := []any{
+		"trace_id", traceID,
+		"run_id", opts.RunID,
+		"mode", opts.Mode,
+		"addr", opts.Addr,
+		"latency_ms", latencyMs,
+		"streams", opts.Streams,
+		"failed", failed,
+		"codes", codes,
+	}
+	if failed > 0 {
+		logger.Errorw("client stream storm end", fields...)
+	} else {
+		logger.Infow("client stream storm end", fields...)
+	}
+
+	keys := make([]string, 0, len(codes))
+	for k := range codes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	opts.Out.setResult(stormResult{Streams: opts.Streams, Failed: failed, Codes: codes})
+	for _, k := range keys {
+		opts.Out.printf("stream storm: code=%s count=%d\n", k, codes[k])
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("stream-storm: %d/%d streams failed", failed, opts.Streams)
+	}
+	return nil
+}
+
+func drainServerStream(
+	ctx context.Context,
+	cl grpcburnerv1.BurnerClient,
+	req *grpcburnerv1.DoWorkServerStreamingRequest,
+) error {
+	stream, err := cl.DoWorkServerStreaming(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
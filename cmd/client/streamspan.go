@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// --message-spans に指定できる値
+const (
+	messageSpansSpan  = "span"  // メッセージごとに子 span を作る
+	messageSpansEvent = "event" // ストリームの span にメッセージごとの span event を載せる
+	messageSpansOff   = "off"
+)
+
+func validateMessageSpans(v string) error {
+	switch v {
+	case messageSpansSpan, messageSpansEvent, messageSpansOff:
+		return nil
+	default:
+		return fmt.Errorf("invalid message-spans %q (expected span|event|off)", v)
+	}
+}
+
+// messageRecorder はストリーム内の 1 メッセージごとのレイテンシをトレースに記録する。
+// ストリーム全体の span だけでは分からない、メッセージ単位の遅延のばらつきを Tempo で分析するため
+type messageRecorder struct {
+	ctx    context.Context
+	mode   string
+	tracer trace.Tracer
+}
+
+// newMessageRecorder は ctx のストリーム span に紐づく messageRecorder を返す
+func newMessageRecorder(ctx context.Context, mode string) *messageRecorder {
+	return &messageRecorder{
+		ctx:    ctx,
+		mode:   mode,
+		tracer: otel.Tracer("cno-app-client"),
+	}
+}
+
+// record は seq 番目(1 始まり)のメッセージを start から現在までの区間として記録する。
+// msgType は OTel の rpc.message.type に合わせて SENT / RECEIVED を渡す
+func (r *messageRecorder) record(msgType string, seq, size int, start time.Time, err error) {
+	if r.mode == messageSpansOff {
+		return
+	}
+	end := time.Now()
+	attrs := []attribute.KeyValue{
+		attribute.String("rpc.message.type", msgType),
+		attribute.Int("rpc.message.id", seq),
+		attribute.Int("rpc.message.uncompressed_size", size),
+		attribute.Float64("latency_ms", durationMs(end.Sub(start))),
+	}
+
+	if r.mode == messageSpansEvent {
+		if err != nil {
+			attrs = append(attrs, attribute.String("error.message", err.Error()))
+		}
+		trace.SpanFromContext(r.ctx).AddEvent("stream.message", trace.WithAttributes(attrs...), trace.WithTimestamp(end))
+		return
+	}
+
+	_, span := r.tracer.Start(r.ctx, "grpc.client/message "+msgType,
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attrs...),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+const multiResolverScheme = "multi"
+
+// noPeerTarget は接続先が決まる前に失敗した呼び出し(UNAVAILABLE など)をまとめる名前
+const noPeerTarget = "(no peer)"
+
+// isMultiTarget は --addr が複数の接続先(カンマ区切り、または dns:/// で全てのアドレスを引くもの)を指すかどうかを返す
+func isMultiTarget(addr string) bool {
+	return strings.Contains(addr, ",") || strings.HasPrefix(addr, "dns:///")
+}
+
+// multiAddrDialOptions はカンマ区切りの --addr を、それぞれを直接の接続先にする resolver にする。
+// opts.Targets に各アドレスを入れ(bench の precheck と broadcast で使う)、opts.Addr は "multi:///<addr>,<addr>" に差し替える。
+// dns:/// は gRPC の DNS resolver がそのまま全ての A/AAAA レコードを返すため、resolver は要らない
+func multiAddrDialOptions(opts *options, logger *zap.SugaredLogger) []grpc.DialOption {
+	if !strings.Contains(opts.Addr, ",") {
+		return nil
+	}
+	addrs := parseAddrList(opts.Addr)
+	state := resolver.State{Addresses: make([]resolver.Address, 0, len(addrs))}
+	for _, a := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: a})
+	}
+	opts.Targets = addrs
+
+	r := manual.NewBuilderWithScheme(multiResolverScheme)
+	r.InitialState(state)
+	opts.Addr = fmt.Sprintf("%s:///%s", multiResolverScheme, strings.Join(addrs, ","))
+	logger.Infow("client targets configured", "addr", opts.Addr, "targets", addrs)
+
+	return []grpc.DialOption{grpc.WithResolvers(r)}
+}
+
+// targetSummary は "client target summary" ログと標準出力に出す、接続先ごとの結果
+type targetSummary struct {
+	Target string         `json:"target"`
+	Count  int            `json:"count"`
+	Failed int            `json:"failed"`
+	Codes  map[string]int `json:"codes"`
+	P50Ms  float64        `json:"p50_ms"`
+	P95Ms  float64        `json:"p95_ms"`
+	P99Ms  float64        `json:"p99_ms"`
+	MaxMs  float64        `json:"max_ms"`
+}
+
+// targetRecorder は interceptor で全ての RPC を応答した接続先(peer のアドレス)ごとに記録する。
+// round_robin で振り分けた時に、レプリカごとの件数の偏りやレイテンシの差を見るために使う。
+// 失敗の数え方は callRecorder と同じで、precheck / warmup の呼び出しは含めない
+type targetRecorder struct {
+	mu      sync.Mutex
+	targets map[string]*armStats
+}
+
+func newTargetRecorder() *targetRecorder {
+	return &targetRecorder{targets: map[string]*armStats{}}
+}
+
+func (r *targetRecorder) add(p *peer.Peer, err error, failed bool, latency time.Duration) {
+	target := noPeerTarget
+	if p.Addr != nil {
+		target = p.Addr.String()
+	}
+	code := status.Code(err)
+	if code == codes.OK && failed {
+		code = codes.Unknown
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.targets[target]
+	if !ok {
+		s = &armStats{codes: map[string]int{}}
+		r.targets[target] = s
+	}
+	s.latencies = append(s.latencies, latency)
+	s.codes[code.String()]++
+}
+
+// summaries は接続先ごとの集計をアドレス順に返す
+func (r *targetRecorder) summaries() []targetSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sums := make([]targetSummary, 0, len(r.targets))
+	for target, s := range r.targets {
+		a := summarizeArm(target, armStats{latencies: append([]time.Duration(nil), s.latencies...), codes: s.codes})
+		sums = append(sums, targetSummary{
+			Target: target,
+			Count:  a.Count,
+			Failed: a.Failed,
+			Codes:  a.Codes,
+			P50Ms:  a.P50Ms,
+			P95Ms:  a.P95Ms,
+			P99Ms:  a.P99Ms,
+			MaxMs:  a.MaxMs,
+		})
+	}
+	sort.Slice(sums, func(i, j int) bool { return sums[i].Target < sums[j].Target })
+	return sums
+}
+
+func (r *targetRecorder) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+			if isUnmeasured(ctx) {
+				return invoker(ctx, method, req, reply, cc, callOpts...)
+			}
+			p := &peer.Peer{}
+			start := time.Now()
+			err := invoker(ctx, method, req, reply, cc, append(callOpts, grpc.Peer(p))...)
+			r.add(p, err, err == nil && reportsFailure(reply), time.Since(start))
+			return err
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+			if isUnmeasured(ctx) {
+				return streamer(ctx, desc, cc, method, callOpts...)
+			}
+			p := &peer.Peer{}
+			start := time.Now()
+			cs, err := streamer(ctx, desc, cc, method, append(callOpts, grpc.Peer(p))...)
+			if err != nil {
+				r.add(p, err, false, time.Since(start))
+				return nil, err
+			}
+			return &targetStream{ClientStream: cs, r: r, peer: p, start: start, serverStreams: desc.ServerStreams}, nil
+		}),
+	}
+}
+
+// targetStream は recordedStream と同じく、ストリームの終了を受け取った時点で 1 回だけ記録する。
+// grpc.Peer の値はストリームが終わった後に入るため、それまで記録を待つ
+type targetStream struct {
+	grpc.ClientStream
+	r             *targetRecorder
+	peer          *peer.Peer
+	start         time.Time
+	serverStreams bool
+
+	failed bool
+	once   sync.Once
+}
+
+func (s *targetStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		if reportsFailure(m) {
+			s.failed = true
+		}
+		if !s.serverStreams {
+			s.finish(nil)
+		}
+	case errors.Is(err, io.EOF):
+		s.finish(nil)
+	default:
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *targetStream) finish(err error) {
+	s.once.Do(func() {
+		if s.peer.Addr == nil {
+			// grpc.Peer が入らなかった時は、確立時に割り当てられた接続先をストリームの context から引く
+			if p, ok := peer.FromContext(s.Context()); ok {
+				s.peer = p
+			}
+		}
+		s.r.add(s.peer, err, s.failed, time.Since(s.start))
+	})
+}
+
+// reportTargets は接続先ごとの集計を "client target summary" ログと標準出力、--output=json の targets に出す。
+// 失敗を含む接続先は warn にする
+func reportTargets(r *targetRecorder, opts *options, logger *zap.SugaredLogger) {
+	sums := r.summaries()
+	if len(sums) == 0 {
+		return
+	}
+	for _, s := range sums {
+		fields := []any{
+			"run_id", opts.RunID,
+			"addr", opts.Addr,
+			"target", s.Target,
+			"count", s.Count,
+			"failed", s.Failed,
+			"codes", s.Codes,
+			"p50_ms", s.P50Ms,
+			"p99_ms", s.P99Ms,
+			"max_ms", s.MaxMs,
+		}
+		if s.Failed > 0 {
+			logger.Warnw("client target summary", fields...)
+		} else {
+			logger.Infow("client target summary", fields...)
+		}
+		opts.Out.printf("target %s: count=%d failed=%d p50=%.1fms p99=%.1fms max=%.1fms\n",
+			s.Target, s.Count, s.Failed, s.P50Ms, s.P99Ms, s.MaxMs)
+	}
+	opts.Out.setTargets(sums)
+}
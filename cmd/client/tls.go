@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// validateTLSFlags は --tls / --insecure と証明書のフラグの組み合わせを確かめる。
+// tlsFlag / insecureFlag はコマンドラインで true を指定したかどうか(CNO_APP_CLIENT_INSECURE による既定値は含めない)。
+// --tls は CNO_APP_CLIENT_INSECURE=true の環境でも TLS で接続するためのもので、--insecure と同時には指定できない
+func validateTLSFlags(opts *options, tlsFlag, insecureFlag bool) error {
+	if tlsFlag && insecureFlag {
+		return errors.New("--tls and --insecure are mutually exclusive")
+	}
+	if (opts.ClientCert == "") != (opts.ClientKey == "") {
+		return errors.New("--client-cert and --client-key must be set together")
+	}
+	if opts.Insecure && (opts.CACert != "" || opts.ClientCert != "" || opts.ServerName != "") {
+		return errors.New("--ca-cert, --client-cert, --client-key and --server-name require TLS (drop --insecure or pass --tls)")
+	}
+	return nil
+}
+
+// transportCredentials は --insecure / --ca-cert / --client-cert / --client-key / --server-name に応じて
+// gRPC の TransportCredentials を返す。既定ではシステムの証明書プールを使った TLS で接続し、--insecure の場合のみ平文にする。
+// --ca-cert を指定するとシステムの証明書プールの代わりにそのファイルの CA だけを信頼し、
+// --client-cert / --client-key を指定するとクライアント証明書を提示する(mTLS)
+func transportCredentials(opts *options, logger *zap.SugaredLogger) (credentials.TransportCredentials, error) {
+	if opts.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+
+	cfg := &tls.Config{
+		ServerName: opts.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if opts.CACert != "" {
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("read ca-cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca-cert %s: no PEM certificates found", opts.CACert)
+		}
+		cfg.RootCAs = pool
+	} else {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("load system cert pool: %w", err)
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return &handshakeLoggingCreds{
+		TransportCredentials: credentials.NewTLS(cfg),
+		logger:               logger,
+		mutual:               opts.ClientCert != "",
+	}, nil
+}
+
+// handshakeLoggingCreds は TLS ハンドシェイク完了時にネゴシエートされた
+// バージョン/暗号スイートをログに出し、接続トラブルの切り分けに使う
+type handshakeLoggingCreds struct {
+	credentials.TransportCredentials
+	logger *zap.SugaredLogger
+	// mutual はクライアント証明書を提示するかどうか(ログの mtls)
+	mutual bool
+}
+
+func (c *handshakeLoggingCreds) ClientHandshake(
+	ctx context.Context,
+	authority string,
+	rawConn net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	if err != nil {
+		c.logger.Errorw("tls handshake failed", "authority", authority, "error", err)
+		return conn, info, err
+	}
+	if ti, ok := info.(credentials.TLSInfo); ok {
+		c.logger.Infow("tls handshake complete",
+			"authority", authority,
+			"server_name", ti.State.ServerName,
+			"tls_version", tls.VersionName(ti.State.Version),
+			"cipher_suite", tls.CipherSuiteName(ti.State.CipherSuite),
+			"negotiated_protocol", ti.State.NegotiatedProtocol,
+			"mtls", c.mutual,
+		)
+	}
+	return conn, info, nil
+}
+
+func (c *handshakeLoggingCreds) Clone() credentials.TransportCredentials {
+	return &handshakeLoggingCreds{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		logger:               c.logger,
+		mutual:               c.mutual,
+	}
+}
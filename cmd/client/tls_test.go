@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+// --tls と --insecure は両方 true を指定した時だけ拒否し、どちらかが false なら受け付ける。
+// 証明書のフラグは解決後の Insecure(--tls で false に畳み込んだ値)が true の時だけ拒否する
+func TestValidateTLSFlags(t *testing.T) {
+	tests := []struct {
+		name         string
+		tlsFlag      bool
+		insecureFlag bool
+		insecure     bool
+		serverName   string
+		wantErr      bool
+	}{
+		{name: "--tls --insecure", tlsFlag: true, insecureFlag: true, insecure: false, wantErr: true},
+		{name: "--tls=false --insecure", tlsFlag: false, insecureFlag: true, insecure: true},
+		{name: "--tls --insecure=false", tlsFlag: true, insecureFlag: false, insecure: false},
+		{name: "--tls with CNO_APP_CLIENT_INSECURE=true", tlsFlag: true, insecureFlag: false, insecure: false},
+		{name: "--insecure only", insecureFlag: true, insecure: true},
+		{name: "--insecure --server-name", insecureFlag: true, insecure: true, serverName: "example.com", wantErr: true},
+		{name: "CNO_APP_CLIENT_INSECURE=true --server-name", insecure: true, serverName: "example.com", wantErr: true},
+		{name: "--tls --server-name with CNO_APP_CLIENT_INSECURE=true", tlsFlag: true, insecure: false, serverName: "example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTLSFlags(&options{Insecure: tt.insecure, ServerName: tt.serverName}, tt.tlsFlag, tt.insecureFlag)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateTLSFlags = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
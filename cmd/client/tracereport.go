@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/tracereport"
+)
+
+const (
+	envTempoURL     = "CNO_APP_TEMPO_URL"
+	defaultTempoURL = "http://localhost:3200"
+)
+
+// runTraceReport は "client trace-report" サブコマンド。
+// 以前の実行のログに出た trace_id を Tempo から取得し、span の木と、クライアントの処理・ネットワーク・
+// サーバーの処理・注入した遅延に分けたレイテンシの内訳を出力する
+func runTraceReport(args []string) error {
+	fs := flag.NewFlagSet("trace-report", flag.ContinueOnError)
+
+	traceID := fs.String("trace-id", "", "trace ID to report (trace_id in the client logs)")
+	tempoURL := fs.String("tempo-url", getenvOrDefault(envTempoURL, defaultTempoURL), "Tempo HTTP API base URL (env "+envTempoURL+")")
+	wait := fs.Duration("wait", 30*time.Second, "how long to retry while the trace is not yet ingested into Tempo")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *traceID == "" {
+		return errors.New("trace-report: --trace-id is required")
+	}
+
+	logger := observability.NewLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *wait)
+	defer cancel()
+
+	// 実行直後はトレースがまだ Tempo に取り込まれていないことがあるため、見つかるまで待つ
+	client := tracereport.NewClient(*tempoURL)
+	var spans []tracereport.Span
+	for {
+		var err error
+		spans, err = client.Trace(ctx, *traceID)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, tracereport.ErrTraceNotFound) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("trace-report: %s not found in %s within %s", *traceID, *tempoURL, *wait)
+		case <-time.After(time.Second):
+		}
+	}
+
+	report := tracereport.Analyze(spans)
+	b := report.Breakdown
+	logger.Infow("client trace report",
+		"trace_id", *traceID,
+		"spans", len(spans),
+		"rpcs", b.RPCs,
+		"total_ms", durationMs(b.Total),
+		"client_overhead_ms", durationMs(b.ClientOverhead),
+		"network_ms", durationMs(b.Network),
+		"server_ms", durationMs(b.Server),
+		"injected_ms", durationMs(b.Injected),
+	)
+	return report.WriteText(os.Stdout)
+}
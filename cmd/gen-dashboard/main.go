@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/dashboard"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "gen-dashboard error:", err)
+		os.Exit(1)
+	}
+}
+
+// run は pkg/observability に定義されたメトリクスから Grafana ダッシュボード JSON を生成する
+func run() error {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	out := fs.String("out", "", "output file (default: stdout)")
+	title := fs.String("title", "", "dashboard title")
+	uid := fs.String("uid", "", "dashboard uid")
+	namespace := fs.String("runtime-namespace", os.Getenv("CNO_APP_METRICS_NAMESPACE"), "prefix of go_*/process_* metrics (CNO_APP_METRICS_NAMESPACE)")
+
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+
+	b, err := dashboard.Generate(dashboard.Options{
+		Title:            *title,
+		UID:              *uid,
+		RuntimeNamespace: *namespace,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(*out, b, 0o644)
+}
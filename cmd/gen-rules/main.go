@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/slo"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "gen-rules error:", err)
+		os.Exit(1)
+	}
+}
+
+// run は SLO 定義(YAML)から Prometheus の recording/alerting ルールを生成する
+func run() error {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	spec := fs.String("spec", "examples/slo/slo.yaml", "SLO spec file (YAML)")
+	out := fs.String("out", "", "output file (default: stdout)")
+
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+
+	s, err := slo.Load(*spec)
+	if err != nil {
+		return err
+	}
+	b, err := s.RenderRules()
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(*out, b, 0o644)
+}
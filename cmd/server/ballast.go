@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// envBallastMB は起動時に確保する memory ballast の大きさ(MiB)。0 なら確保しない
+const envBallastMB = "CNO_APP_BALLAST_MB"
+
+// maxBallastMB は ballast の上限。触らないページは RSS にならないが、heap の目標値と GOMEMLIMIT の計算には含まれる
+const maxBallastMB = 64 * 1024
+
+// ballast は GC の heap の目標値を押し上げるためだけに確保し、参照を持ち続ける領域。
+// 中身に触らないため物理メモリはほとんど使わない(GOMEMLIMIT 導入前によく使われた GC の頻度を下げる手法)
+var ballast []byte
+
+// ballastMBFromEnv は CNO_APP_BALLAST_MB を読む。--ballast-mb の既定値になる
+func ballastMBFromEnv() (int, error) {
+	v := os.Getenv(envBallastMB)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", envBallastMB, v, err)
+	}
+	return n, nil
+}
+
+func validateBallastMB(mb int) error {
+	if mb < 0 || mb > maxBallastMB {
+		return fmt.Errorf("ballast-mb must be between 0 and %d, got %d", maxBallastMB, mb)
+	}
+	return nil
+}
+
+// allocateBallast は mb MiB の ballast を確保し、cno_app_gc_ballast_bytes に記録する。
+// ballast は live heap に数えられるため、GOMEMLIMIT と併用すると制限までの余裕をその分だけ減らす。比べる時はどちらか一方にする
+func allocateBallast(mb int, logger *zap.SugaredLogger) {
+	if mb == 0 {
+		return
+	}
+	ballast = make([]byte, mb<<20)
+	observability.CNOAppGCBallastBytes.Set(float64(len(ballast)))
+
+	fields := []any{"ballast_mb", mb, "gogc", os.Getenv("GOGC"), "gomemlimit", os.Getenv("GOMEMLIMIT")}
+	// SetMemoryLimit に負の値を渡すと変更せずに現在の値を返す(未設定なら math.MaxInt64)
+	if debug.SetMemoryLimit(-1) != math.MaxInt64 {
+		logger.Warnw("memory ballast allocated with GOMEMLIMIT set; the ballast counts against the memory limit", fields...)
+		return
+	}
+	logger.Infow("memory ballast allocated", fields...)
+}
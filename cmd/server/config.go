@@ -0,0 +1,403 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+const (
+	envMaxConcurrentStreams = "CNO_APP_GRPC_MAX_CONCURRENT_STREAMS"
+
+	envKeepaliveMinTime             = "CNO_APP_GRPC_KEEPALIVE_MIN_TIME"
+	envKeepalivePermitWithoutStream = "CNO_APP_GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM"
+
+	// defaultKeepaliveMinTime は gRPC の既定の keepalive.EnforcementPolicy.MinTime
+	defaultKeepaliveMinTime = 5 * time.Minute
+
+	envPayloadSampleRate   = "CNO_APP_DEBUG_PAYLOAD_SAMPLE_RATE"
+	envPayloadMaxBytes     = "CNO_APP_DEBUG_PAYLOAD_MAX_BYTES"
+	envPayloadRedact       = "CNO_APP_DEBUG_PAYLOAD_REDACT"
+	envPayloadEmitDefaults = "CNO_APP_DEBUG_PAYLOAD_EMIT_DEFAULTS"
+	envPayloadEnums        = "CNO_APP_DEBUG_PAYLOAD_ENUMS"
+	envPayloadFieldNames   = "CNO_APP_DEBUG_PAYLOAD_FIELD_NAMES"
+
+	defaultPayloadMaxBytes = 4096
+
+	envSpanMetadataKeys = "CNO_APP_TRACE_METADATA_KEYS"
+
+	envIODir = "CNO_APP_LOAD_IO_DIR"
+
+	envGRPCAddr = "CNO_APP_GRPC_ADDR"
+
+	envNoisyNeighborRate      = "CNO_APP_NOISY_NEIGHBOR_RATE"
+	envNoisyNeighborMaxDelay  = "CNO_APP_NOISY_NEIGHBOR_MAX_DELAY"
+	envNoisyNeighborPerSecond = "CNO_APP_NOISY_NEIGHBOR_PER_SEC"
+
+	defaultNoisyNeighborMaxDelay = 200 * time.Millisecond
+
+	envStreamMaxMessages = "CNO_APP_STREAM_MAX_MESSAGES"
+	envStreamMaxWork     = "CNO_APP_STREAM_MAX_WORK"
+
+	defaultStreamMaxMessages = 10000
+	defaultStreamMaxWork     = 10 * time.Minute
+
+	envWatchdogGrace = "CNO_APP_LOAD_WATCHDOG_GRACE"
+
+	envCPUAffinity = "CNO_APP_LOAD_CPU_AFFINITY"
+
+	envCPUWorkers = "CNO_APP_LOAD_CPU_WORKERS"
+
+	envDoWorkCoalesce = "CNO_APP_DOWORK_COALESCE"
+
+	envMirrorAddr         = "CNO_APP_MIRROR_ADDR"
+	envMirrorPercent      = "CNO_APP_MIRROR_PERCENT"
+	envMirrorWorkFraction = "CNO_APP_MIRROR_WORK_FRACTION"
+	envMirrorMaxInFlight  = "CNO_APP_MIRROR_MAX_IN_FLIGHT"
+	envMirrorTimeout      = "CNO_APP_MIRROR_TIMEOUT"
+
+	defaultMirrorPercent      = 10
+	defaultMirrorWorkFraction = 0.1
+	defaultMirrorMaxInFlight  = 32
+	defaultMirrorTimeout      = 30 * time.Second
+)
+
+// maxConcurrentStreamsFromEnv は CNO_APP_GRPC_MAX_CONCURRENT_STREAMS を読み取る。
+// 未設定または 0 の場合は gRPC の既定値(無制限)のままにする
+func maxConcurrentStreamsFromEnv() (uint32, error) {
+	v := os.Getenv(envMaxConcurrentStreams)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", envMaxConcurrentStreams, v, err)
+	}
+	return uint32(n), nil
+}
+
+// keepaliveEnforcementFromEnv は CNO_APP_GRPC_KEEPALIVE_MIN_TIME / CNO_APP_GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM を読み取る。
+// クライアントの keepalive ping をどこまで許すかで、これより短い間隔の ping を受けると too_many_pings の GOAWAY で接続を切る。
+// どちらも未設定なら ok=false を返し、gRPC の既定(5 分、ストリームのない接続への ping は拒否)のままにする
+func keepaliveEnforcementFromEnv() (policy keepalive.EnforcementPolicy, ok bool, err error) {
+	policy.MinTime = defaultKeepaliveMinTime
+	if v := os.Getenv(envKeepaliveMinTime); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return policy, false, fmt.Errorf("invalid %s %q: %w", envKeepaliveMinTime, v, err)
+		}
+		if d <= 0 {
+			return policy, false, fmt.Errorf("%s must be > 0, got %q", envKeepaliveMinTime, v)
+		}
+		policy.MinTime, ok = d, true
+	}
+	if v := os.Getenv(envKeepalivePermitWithoutStream); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return policy, false, fmt.Errorf("invalid %s %q: %w", envKeepalivePermitWithoutStream, v, err)
+		}
+		policy.PermitWithoutStream, ok = b, true
+	}
+	return policy, ok, nil
+}
+
+// payloadLogConfigFromEnv はデバッグ用の payload 記録設定を環境変数から読み取る。
+// CNO_APP_DEBUG_PAYLOAD_REDACT はカンマ区切りの proto フィールド名。
+// CNO_APP_DEBUG_PAYLOAD_EMIT_DEFAULTS / ENUMS / FIELD_NAMES は JSON 表現(protojson のオプション)
+func payloadLogConfigFromEnv() (observability.PayloadLogConfig, error) {
+	cfg := observability.PayloadLogConfig{MaxBytes: defaultPayloadMaxBytes}
+
+	if v := os.Getenv(envPayloadSampleRate); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s %q: %w", envPayloadSampleRate, v, err)
+		}
+		if rate < 0 || rate > 1 {
+			return cfg, fmt.Errorf("%s must be between 0.0 and 1.0, got %v", envPayloadSampleRate, rate)
+		}
+		cfg.SampleRate = rate
+	}
+
+	if v := os.Getenv(envPayloadMaxBytes); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s %q: %w", envPayloadMaxBytes, v, err)
+		}
+		cfg.MaxBytes = n
+	}
+
+	if v := os.Getenv(envPayloadRedact); v != "" {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				cfg.RedactFields = append(cfg.RedactFields, f)
+			}
+		}
+	}
+
+	if v := os.Getenv(envPayloadEmitDefaults); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s %q: %w", envPayloadEmitDefaults, v, err)
+		}
+		cfg.EmitDefaults = b
+	}
+	cfg.EnumFormat = os.Getenv(envPayloadEnums)
+	cfg.FieldNames = os.Getenv(envPayloadFieldNames)
+	return cfg, cfg.Validate()
+}
+
+// spanMetadataKeysFromEnv は span 属性にコピーする metadata キーの許可リストを返す。
+// CNO_APP_TRACE_METADATA_KEYS はカンマ区切り。未設定なら既定値、"off" なら記録しない
+func spanMetadataKeysFromEnv() []string {
+	v, ok := os.LookupEnv(envSpanMetadataKeys)
+	if !ok {
+		return observability.DefaultSpanMetadataKeys
+	}
+	if strings.EqualFold(v, "off") {
+		return nil
+	}
+	var keys []string
+	for _, k := range strings.Split(v, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, strings.ToLower(k))
+		}
+	}
+	return keys
+}
+
+// ioDirFromEnv は I/O 負荷の一時ファイルを置くディレクトリを返す。
+// コンテナの /tmp が tmpfs(メモリ)の場合にディスクを指す emptyDir などへ逃がすために使う。
+// 未設定なら空文字(OS の既定の一時ディレクトリ)
+func ioDirFromEnv() (string, error) {
+	dir := os.Getenv(envIODir)
+	if dir == "" {
+		return "", nil
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", envIODir, err)
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("invalid %s: %s is not a directory", envIODir, dir)
+	}
+	return dir, nil
+}
+
+// noisyNeighborFromEnv は noisy neighbor(CPU steal)の模擬設定を環境変数から読み取る。
+// CNO_APP_NOISY_NEIGHBOR_RATE が未設定または 0 なら無効
+func noisyNeighborFromEnv() (appserver.NoisyNeighbor, error) {
+	n := appserver.NoisyNeighbor{MaxDelay: defaultNoisyNeighborMaxDelay}
+	if v := os.Getenv(envNoisyNeighborRate); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return n, fmt.Errorf("invalid %s %q: %w", envNoisyNeighborRate, v, err)
+		}
+		n.Rate = rate
+	}
+	if v := os.Getenv(envNoisyNeighborMaxDelay); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return n, fmt.Errorf("invalid %s %q: %w", envNoisyNeighborMaxDelay, v, err)
+		}
+		n.MaxDelay = d
+	}
+	if v := os.Getenv(envNoisyNeighborPerSecond); v != "" {
+		perSec, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return n, fmt.Errorf("invalid %s %q: %w", envNoisyNeighborPerSecond, v, err)
+		}
+		n.PerSecond = perSec
+	}
+	return n, n.Validate()
+}
+
+// streamLimitsFromEnv は 1 ストリームあたりのメッセージ数と負荷の時間の合計の上限を環境変数から読み取る。
+// 未設定なら既定値、0 なら無制限
+func streamLimitsFromEnv() (appserver.StreamLimits, error) {
+	l := appserver.StreamLimits{MaxMessages: defaultStreamMaxMessages, MaxWork: defaultStreamMaxWork}
+	if v := os.Getenv(envStreamMaxMessages); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return l, fmt.Errorf("invalid %s %q: %w", envStreamMaxMessages, v, err)
+		}
+		l.MaxMessages = n
+	}
+	if v := os.Getenv(envStreamMaxWork); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return l, fmt.Errorf("invalid %s %q: %w", envStreamMaxWork, v, err)
+		}
+		l.MaxWork = d
+	}
+	return l, l.Validate()
+}
+
+// watchdogGraceFromEnv は負荷実行の watchdog の猶予の倍率を読み取る。
+// 未設定なら load.DefaultWatchdogGrace、"off" または 0 なら無効
+func watchdogGraceFromEnv() (float64, error) {
+	v := os.Getenv(envWatchdogGrace)
+	switch {
+	case v == "":
+		return load.DefaultWatchdogGrace, nil
+	case strings.EqualFold(v, "off"):
+		return 0, nil
+	}
+	grace, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", envWatchdogGrace, v, err)
+	}
+	if math.IsNaN(grace) || grace < 0 || (grace > 0 && grace < 1) {
+		return 0, fmt.Errorf("%s must be 0 (off) or >= 1, got %q", envWatchdogGrace, v)
+	}
+	return grace, nil
+}
+
+// cpuAffinityFromEnv は CNO_APP_LOAD_CPU_AFFINITY を読み取る。未設定なら false。
+// true の場合は、この環境で実際にコアへの固定ができるか(Linux で sched_getaffinity が使えるか)も確認する
+func cpuAffinityFromEnv() (bool, error) {
+	v := os.Getenv(envCPUAffinity)
+	if v == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", envCPUAffinity, v, err)
+	}
+	if enabled {
+		if err := load.CPUAffinitySupported(); err != nil {
+			return false, fmt.Errorf("%s=true: %w", envCPUAffinity, err)
+		}
+	}
+	return enabled, nil
+}
+
+// cpuWorkersFromEnv は CNO_APP_LOAD_CPU_WORKERS(x-cpu-share で分け合う CPU ワーカー枠の大きさ)を読み取る。
+// 未設定なら 0(GOMAXPROCS を使う)
+func cpuWorkersFromEnv() (int, error) {
+	v := os.Getenv(envCPUWorkers)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", envCPUWorkers, v, err)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("%s must be > 0, got %d", envCPUWorkers, n)
+	}
+	return n, nil
+}
+
+// doWorkCoalesceFromEnv は CNO_APP_DOWORK_COALESCE(同じキーで同時に届いた Unary の DoWork を 1 回の実行にまとめるか)を読み取る。
+// 未設定なら false
+func doWorkCoalesceFromEnv() (bool, error) {
+	v := os.Getenv(envDoWorkCoalesce)
+	if v == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", envDoWorkCoalesce, v, err)
+	}
+	return enabled, nil
+}
+
+// mirrorFromEnv は DoWork を shadow サーバーへ複製する設定を環境変数から読み取る。
+// CNO_APP_MIRROR_ADDR が未設定なら無効
+func mirrorFromEnv() (appserver.Mirror, error) {
+	m := appserver.Mirror{
+		Addr:         os.Getenv(envMirrorAddr),
+		Percent:      defaultMirrorPercent,
+		WorkFraction: defaultMirrorWorkFraction,
+		MaxInFlight:  defaultMirrorMaxInFlight,
+		Timeout:      defaultMirrorTimeout,
+	}
+	if v := os.Getenv(envMirrorPercent); v != "" {
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return m, fmt.Errorf("invalid %s %q: %w", envMirrorPercent, v, err)
+		}
+		m.Percent = p
+	}
+	if v := os.Getenv(envMirrorWorkFraction); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return m, fmt.Errorf("invalid %s %q: %w", envMirrorWorkFraction, v, err)
+		}
+		m.WorkFraction = f
+	}
+	if v := os.Getenv(envMirrorMaxInFlight); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return m, fmt.Errorf("invalid %s %q: %w", envMirrorMaxInFlight, v, err)
+		}
+		m.MaxInFlight = n
+	}
+	if v := os.Getenv(envMirrorTimeout); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return m, fmt.Errorf("invalid %s %q: %w", envMirrorTimeout, v, err)
+		}
+		m.Timeout = d
+	}
+	return m, m.Validate()
+}
+
+// listenAddrs は各リスナーのバインドアドレス
+type listenAddrs struct {
+	GRPC    string
+	Metrics string
+	Admin   string // "off" なら admin リスナーを起動しない
+}
+
+// serverFlags はコマンドラインフラグで指定する起動時の設定
+type serverFlags struct {
+	Addrs      listenAddrs
+	ConfigFile string // SIGHUP で再読み込みする設定ファイル(空なら環境変数のみ)
+	// ValidateConfig が true なら設定を検証して結果を出力し、サーバーを起動せずに終了する
+	ValidateConfig bool
+	// BallastMB は起動時に確保する memory ballast の大きさ(MiB)。0 なら確保しない
+	BallastMB int
+}
+
+// parseFlags はコマンドラインフラグと環境変数から起動時の設定を決める。
+// 優先順位はフラグ > 環境変数 > 既定値。サイドカーや hostNetwork で既定ポートが使えない場合はアドレスを変える
+func parseFlags(args []string) (serverFlags, error) {
+	var f serverFlags
+	ballastDefault, err := ballastMBFromEnv()
+	if err != nil {
+		return serverFlags{}, err
+	}
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(&f.Addrs.GRPC, "grpc-addr", getenvOrDefault(envGRPCAddr, defaultGRPCAddr), "gRPC listen address (env "+envGRPCAddr+")")
+	fs.StringVar(&f.Addrs.Metrics, "metrics-addr", getenvOrDefault(envMetricsAddr, defaultMetricsAddr), "metrics/health HTTP listen address (env "+envMetricsAddr+")")
+	fs.StringVar(&f.Addrs.Admin, "admin-addr", getenvOrDefault(envAdminAddr, defaultAdminAddr), `admin HTTP listen address, "off" to disable (env `+envAdminAddr+")")
+	fs.StringVar(&f.ConfigFile, "config", os.Getenv(envConfigFile), "YAML config file reloaded on SIGHUP (env "+envConfigFile+")")
+	fs.BoolVar(&f.ValidateConfig, "validate-config", false, "validate the configuration, print a JSON report and exit (non-zero if invalid)")
+	fs.IntVar(&f.BallastMB, "ballast-mb", ballastDefault, "allocate a memory ballast of this many MiB at startup to raise the GC heap goal, for comparing with GOMEMLIMIT; 0 disables (env "+envBallastMB+")")
+	if err := fs.Parse(args); err != nil {
+		return serverFlags{}, err
+	}
+	if fs.NArg() > 0 {
+		return serverFlags{}, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if err := validateBallastMB(f.BallastMB); err != nil {
+		return serverFlags{}, err
+	}
+	for name, addr := range map[string]string{"grpc-addr": f.Addrs.GRPC, "metrics-addr": f.Addrs.Metrics} {
+		if addr == "" {
+			return serverFlags{}, fmt.Errorf("--%s must not be empty", name)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+const (
+	// envCrashReportDir はクラッシュレポートを書き出すディレクトリ。未設定なら書き出さない
+	envCrashReportDir = "CNO_APP_CRASH_REPORT_DIR"
+	// envCrashReportEvents はクラッシュレポートに含める直近のログの件数
+	envCrashReportEvents = "CNO_APP_CRASH_REPORT_EVENTS"
+
+	defaultCrashReportEvents = 200
+	maxCrashReportEvents     = 10000
+
+	// crashRuntimeLog は recover できないクラッシュ(他の goroutine の panic、fatal error)で Go ランタイムが出す内容の追記先
+	crashRuntimeLog = "crash-runtime.log"
+
+	// maxGoroutineDump は goroutine ダンプの上限。これを超えた分は切り捨てる
+	maxGoroutineDump = 64 << 20
+)
+
+// crashConfig はクラッシュレポートの設定
+type crashConfig struct {
+	Dir    string
+	Events int
+}
+
+// crashConfigFromEnv は CNO_APP_CRASH_REPORT_DIR / CNO_APP_CRASH_REPORT_EVENTS を読み取る。
+// ディレクトリはクラッシュ時に作れないと困るため、起動時に存在してディレクトリであることを確認する
+func crashConfigFromEnv() (crashConfig, error) {
+	cfg := crashConfig{Dir: os.Getenv(envCrashReportDir), Events: defaultCrashReportEvents}
+	if v := os.Getenv(envCrashReportEvents); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s %q: %w", envCrashReportEvents, v, err)
+		}
+		if n < 1 || n > maxCrashReportEvents {
+			return cfg, fmt.Errorf("%s must be between 1 and %d, got %d", envCrashReportEvents, maxCrashReportEvents, n)
+		}
+		cfg.Events = n
+	}
+	if cfg.Dir == "" {
+		return cfg, nil
+	}
+	fi, err := os.Stat(cfg.Dir)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid %s: %w", envCrashReportDir, err)
+	}
+	if !fi.IsDir() {
+		return cfg, fmt.Errorf("invalid %s: %s is not a directory", envCrashReportDir, cfg.Dir)
+	}
+	return cfg, nil
+}
+
+// crashReport はクラッシュ 1 回分のレポート(crash-<時刻>-<pid>.json)。
+// 標準出力の最後の数行だけでは分からない、直前のログ・全 goroutine のスタック・起動時の設定を 1 つのファイルにまとめる
+type crashReport struct {
+	Time time.Time `json:"time"`
+	// Kind は panic(recover した panic を再送出する前)か fatal(logger.Fatalw)
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	// Where は panic が起きた場所(gRPC のメソッド名や main)
+	Where string `json:"where,omitempty"`
+	// Fields は fatal のログのフィールド
+	Fields  map[string]any `json:"fields,omitempty"`
+	Build   buildInfo      `json:"build"`
+	Process processInfo    `json:"process"`
+	Runtime crashRuntime   `json:"runtime"`
+	// Startup は起動バナー(/startupz)の内容。起動が終わる前のクラッシュでは listeners / config が空になる
+	Startup      startupInfo                 `json:"startup"`
+	RecentEvents []observability.RecentEvent `json:"recent_events"`
+	// Goroutines は全 goroutine のスタック(runtime.Stack の形式)
+	Goroutines string `json:"goroutines"`
+}
+
+type crashRuntime struct {
+	Uptime         string `json:"uptime"`
+	NumGoroutine   int    `json:"num_goroutine"`
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+}
+
+// crashReporter は panic / fatal の時にクラッシュレポートを書き出す。最初の 1 回だけ書き、以降は無視する
+type crashReporter struct {
+	dir    string
+	events *observability.RecentEvents
+	// numEvents はレポートに含める直近のログの件数(events は /admin/logs と共有する)
+	numEvents int
+	start     time.Time
+
+	mu      sync.Mutex
+	startup startupInfo
+
+	once sync.Once
+}
+
+// installCrashReporter は cfg.Dir が設定されていれば、fatal 時にレポートを書く hook を付けたロガーと crashReporter を返す。
+// events は logger に tee 済みの直近のログで、cfg.Events 件以上を保持している必要がある。
+// recover できないクラッシュは Go ランタイムの出力を crash-runtime.log に追記する(JSON にはならない)
+func installCrashReporter(cfg crashConfig, logger *zap.SugaredLogger, events *observability.RecentEvents) (*zap.SugaredLogger, *crashReporter, error) {
+	if cfg.Dir == "" {
+		return logger, nil, nil
+	}
+	c := &crashReporter{
+		dir:       cfg.Dir,
+		events:    events,
+		numEvents: cfg.Events,
+		start:     time.Now(),
+		startup:   newStartupInfo(nil, nil, nil),
+	}
+	f, err := os.OpenFile(filepath.Join(cfg.Dir, crashRuntimeLog), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return logger, nil, fmt.Errorf("open crash runtime log: %w", err)
+	}
+	if err := debug.SetCrashOutput(f, debug.CrashOptions{}); err != nil {
+		_ = f.Close()
+		return logger, nil, fmt.Errorf("set crash output: %w", err)
+	}
+	// SetCrashOutput が複製を持つため閉じてよい
+	_ = f.Close()
+
+	return logger.WithOptions(zap.WithFatalHook(c)), c, nil
+}
+
+// setStartup は起動バナーの内容をレポートに含める
+func (c *crashReporter) setStartup(s startupInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.startup = s
+}
+
+// OnWrite は logger.Fatalw のログを出力した後に呼ばれる(zapcore.CheckWriteHook)。レポートを書いてから終了する
+func (c *crashReporter) OnWrite(ce *zapcore.CheckedEntry, fields []zapcore.Field) {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	_, _ = c.write(crashReport{Kind: "fatal", Message: ce.Message, Fields: enc.Fields})
+	os.Exit(1)
+}
+
+// write はレポートを一時ファイルに書いてから rename し、書き出したパスを返す。2 回目以降は何もしない
+func (c *crashReporter) write(r crashReport) (path string, err error) {
+	c.once.Do(func() {
+		r.Time = time.Now().UTC()
+		r.Goroutines = goroutineDump()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		r.Runtime = crashRuntime{
+			Uptime:         time.Since(c.start).Round(time.Millisecond).String(),
+			NumGoroutine:   runtime.NumGoroutine(),
+			HeapAllocBytes: ms.HeapAlloc,
+			SysBytes:       ms.Sys,
+			NumGC:          ms.NumGC,
+		}
+		c.mu.Lock()
+		r.Startup = c.startup
+		c.mu.Unlock()
+		r.Build = r.Startup.Build
+		r.Process = r.Startup.Process
+		r.RecentEvents = c.events.Query(observability.RecentEventsFilter{MinLevel: zapcore.DebugLevel, Limit: c.numEvents})
+
+		path = filepath.Join(c.dir, fmt.Sprintf("crash-%s-%d.json", r.Time.Format("20060102T150405Z"), os.Getpid()))
+		err = writeJSONFile(path, r)
+	})
+	return path, err
+}
+
+func writeJSONFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// goroutineDump は全 goroutine のスタックを返す。バッファが足りなければ maxGoroutineDump まで広げる
+func goroutineDump() string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxGoroutineDump {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// recoverPanic は defer で呼び、panic を "server panic" ログとクラッシュレポートに残してから再送出する。
+// 挙動(プロセスが落ちること)は変えない。c が nil なら何もしない
+func (c *crashReporter) recoverPanic(logger *zap.SugaredLogger, where string) {
+	if c == nil {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	path, err := c.write(crashReport{Kind: "panic", Message: fmt.Sprint(r), Where: where})
+	fields := []any{
+		"where", where,
+		"panic", fmt.Sprint(r),
+		"stack", string(debug.Stack()),
+		"crash_report", path,
+	}
+	if err != nil {
+		fields = append(fields, "crash_report_error", err)
+	}
+	logger.Errorw("server panic", fields...)
+	_ = logger.Sync()
+	panic(r)
+}
+
+// unaryInterceptor / streamInterceptor は gRPC のハンドラー(とその中で同期的に動く負荷)の panic をレポートに残す
+func (c *crashReporter) unaryInterceptor(logger *zap.SugaredLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		defer c.recoverPanic(logger, info.FullMethod)
+		return handler(ctx, req)
+	}
+}
+
+func (c *crashReporter) streamInterceptor(logger *zap.SugaredLogger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		defer c.recoverPanic(logger, info.FullMethod)
+		return handler(srv, ss)
+	}
+}
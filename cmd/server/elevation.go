@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+// envElevationSecret は elevation token の署名鍵。未設定なら token の発行も受け付けもしない
+const envElevationSecret = "CNO_APP_ELEVATION_SECRET"
+
+const defaultElevationTTL = 10 * time.Minute
+
+// elevationHandler は 1 回の RPC に限って上限を引き上げる elevation token を発行する。
+// クエリの mode / duration / alloc_mb / parallelism / io_bytes が引き上げ後の上限、ttl が有効期間(既定 10m)、reason が理由(必須)。
+// 上限を回避する手段のため、admin の認証を設定していなければ発行せず、誰がいつ何のために発行したかを監査ログ(audit=true)に残す
+func elevationHandler(auth adminAuth, elevations *appserver.Elevations, logger *zap.SugaredLogger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if elevations == nil {
+			http.Error(w, "elevation is disabled ("+envElevationSecret+" is not set)", http.StatusNotFound)
+			return
+		}
+		if !auth.enabled() {
+			http.Error(w, "elevation requires admin auth to be configured", http.StatusForbidden)
+			return
+		}
+
+		grant, ttl, err := elevationRequest(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		grant.IssuedBy = auth.principal(r)
+		token, grant, err := elevations.Issue(grant, ttl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.Warnw("limit elevation issued",
+			"audit", true,
+			"elevation_id", grant.ID,
+			"elevation_reason", grant.Reason,
+			"mode", string(grant.Mode),
+			"max_duration_ms", grant.MaxDurationMs,
+			"max_alloc_mb", grant.MaxAllocMB,
+			"max_parallelism", grant.MaxParallelism,
+			"max_io_bytes", grant.MaxIOBytes,
+			"expires_at", grant.ExpiresAt.Format(time.RFC3339),
+			"principal", grant.IssuedBy,
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Token string                   `json:"token"`
+			Grant appserver.ElevationGrant `json:"grant"`
+		}{Token: token, Grant: grant})
+	})
+}
+
+// elevationRequest は /admin/elevation のクエリを ElevationGrant と有効期間に変換する
+func elevationRequest(q url.Values) (appserver.ElevationGrant, time.Duration, error) {
+	g := appserver.ElevationGrant{Mode: load.Mode(q.Get("mode")), Reason: q.Get("reason")}
+	if g.Reason == "" {
+		return g, 0, errors.New("reason is required")
+	}
+	ttl := defaultElevationTTL
+	if v := q.Get("ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return g, 0, fmt.Errorf("invalid ttl %q: %w", v, err)
+		}
+		ttl = d
+	}
+	if v := q.Get("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return g, 0, fmt.Errorf("invalid duration %q: %w", v, err)
+		}
+		g.MaxDurationMs = d.Milliseconds()
+	}
+	for _, n := range []struct {
+		key string
+		dst *int
+	}{{"alloc_mb", &g.MaxAllocMB}, {"parallelism", &g.MaxParallelism}, {"io_bytes", &g.MaxIOBytes}} {
+		v := q.Get(n.key)
+		if v == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			return g, 0, fmt.Errorf("invalid %s %q: %w", n.key, v, err)
+		}
+		*n.dst = parsed
+	}
+	return g, ttl, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+const (
+	envMetricsAddr   = "CNO_APP_METRICS_ADDR"
+	envAdminAddr     = "CNO_APP_ADMIN_ADDR"
+	envAdminToken    = "CNO_APP_ADMIN_TOKEN"
+	envAdminUser     = "CNO_APP_ADMIN_USER"
+	envAdminPassword = "CNO_APP_ADMIN_PASSWORD"
+)
+
+// adminAuth は admin/debug リスナーに掛ける認証設定。
+// Token が設定されていれば Bearer、User/Password が設定されていれば Basic 認証を受け付ける。
+// どちらも空なら認証なし(ローカル検証用)。
+type adminAuth struct {
+	Token    string
+	User     string
+	Password string
+}
+
+// adminAuthFromEnv は環境変数から admin の認証設定を読む。
+// Basic 認証はユーザーとパスワードの両方が必要で、片方だけの設定(空のパスワードで誰でも通る状態など)はエラーにする
+func adminAuthFromEnv() (adminAuth, error) {
+	a := adminAuth{
+		Token:    os.Getenv(envAdminToken),
+		User:     os.Getenv(envAdminUser),
+		Password: os.Getenv(envAdminPassword),
+	}
+	if a.User != "" && a.Password == "" {
+		return a, fmt.Errorf("%s is set but %s is empty", envAdminUser, envAdminPassword)
+	}
+	if a.User == "" && a.Password != "" {
+		return a, fmt.Errorf("%s is set but %s is empty", envAdminPassword, envAdminUser)
+	}
+	return a, nil
+}
+
+func (a adminAuth) enabled() bool {
+	return a.Token != "" || a.User != ""
+}
+
+// mode は起動バナーに出す認証方式("bearer" / "basic" / "bearer+basic" / "none")。資格情報そのものは出さない
+func (a adminAuth) mode() string {
+	switch {
+	case a.Token != "" && a.User != "":
+		return "bearer+basic"
+	case a.Token != "":
+		return "bearer"
+	case a.User != "":
+		return "basic"
+	default:
+		return "none"
+	}
+}
+
+// authorize はリクエストが Bearer / Basic いずれかの資格情報を満たすかを判定する
+func (a adminAuth) authorize(r *http.Request) bool {
+	return a.authorizeHeader(r.Header.Get("Authorization"))
+}
+
+// authorizeHeader は Authorization ヘッダー(gRPC では metadata authorization)の値が資格情報を満たすかを判定する
+func (a adminAuth) authorizeHeader(h string) bool {
+	if !a.enabled() {
+		return true
+	}
+	if a.Token != "" && h != "" &&
+		subtle.ConstantTimeCompare([]byte(h), []byte("Bearer "+a.Token)) == 1 {
+		return true
+	}
+	if a.User != "" {
+		if u, p, ok := parseBasicAuth(h); ok &&
+			subtle.ConstantTimeCompare([]byte(u), []byte(a.User)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(p), []byte(a.Password)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// principal は監査ログに残す操作者。Basic 認証ならユーザー名、Bearer ならトークン名の代わりに "bearer"
+func (a adminAuth) principal(r *http.Request) string {
+	return a.principalOf(r.Header.Get("Authorization"))
+}
+
+func (a adminAuth) principalOf(h string) string {
+	if u, _, ok := parseBasicAuth(h); ok && a.User != "" {
+		return u
+	}
+	if a.Token != "" && h != "" {
+		return "bearer"
+	}
+	return "anonymous"
+}
+
+// authorizeRPC は gRPC の admin 系 RPC(WorkControlService)を admin リスナーと同じ資格情報で認証する
+// (appserver.WorkAuthorizer)。資格情報は metadata authorization に HTTP と同じ形式で載せる。
+// admin の資格情報が設定されていなければ全て拒否する(認証なしで開く admin リスナーとは違い fail closed)
+func (a adminAuth) authorizeRPC(ctx context.Context) (string, bool) {
+	if !a.enabled() {
+		return "", false
+	}
+	h := authorizationOf(ctx)
+	if !a.authorizeHeader(h) {
+		return "", false
+	}
+	return a.principalOf(h), true
+}
+
+// listenerPrincipal は requireAuth を通った admin リスナーのリクエスト(rpcContext)の操作者を返す appserver.WorkAuthorizer
+func (a adminAuth) listenerPrincipal(ctx context.Context) (string, bool) {
+	return a.principalOf(authorizationOf(ctx)), true
+}
+
+func authorizationOf(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("authorization"); len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	return ""
+}
+
+// parseBasicAuth は "Basic <base64(user:password)>" を分解する(http.Request.BasicAuth と同じ解釈)
+func parseBasicAuth(h string) (user, password string, ok bool) {
+	const prefix = "Basic "
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	c, err := base64.StdEncoding.DecodeString(h[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(c), ":")
+}
+
+// requireAuth は adminAuth を満たさないリクエストを 401 で拒否するミドルウェア
+func requireAuth(auth adminAuth, next http.Handler) http.Handler {
+	if !auth.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.authorize(r) {
+			if auth.User != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="cno-app-admin"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func healthzHandler(w http.ResponseWriter, _ *http.Request) {
+	// 将来的に gRPC health の状態を見に行く実装に差し替えても良い
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyHandler は gRPC health(サービス名 "")の状態を Envoy の /ready と同じ形で返す。
+// SERVING なら 200 "LIVE"、それ以外は 503 とステータス名。
+// ?verbose を付けるとステータスコードは同じまま、サブシステムごとの初期化状態を JSON で返す
+func readyHandler(hs healthpb.HealthServer, gate *appserver.ReadinessGate) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if resp, err := hs.Check(r.Context(), &healthpb.HealthCheckRequest{}); err == nil {
+			status = resp.GetStatus()
+		}
+		code := http.StatusOK
+		if status != healthpb.HealthCheckResponse_SERVING {
+			code = http.StatusServiceUnavailable
+		}
+
+		if r.URL.Query().Has("verbose") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			_ = json.NewEncoder(w).Encode(struct {
+				Status     string                      `json:"status"`
+				Subsystems []appserver.SubsystemHealth `json:"subsystems"`
+			}{Status: status.String(), Subsystems: gate.Snapshot()})
+			return
+		}
+		if code != http.StatusOK {
+			http.Error(w, status.String(), code)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("LIVE\n"))
+	})
+}
+
+// inflightHandler は実行中の RPC(メソッド/経過時間/request_id/trace_id)を経過時間の長い順に JSON で返す
+func inflightHandler(inflight *observability.InFlightRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		reqs := inflight.Snapshot()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Count    int                             `json:"count"`
+			Requests []observability.InFlightRequest `json:"requests"`
+		}{Count: len(reqs), Requests: reqs})
+	})
+}
+
+// killHandler は実行中の全ての負荷を打ち切る kill-switch。
+// 誰がいつ何件止めたかを後から追えるよう、監査ログ(audit=true)を warn で残す
+func killHandler(auth adminAuth, work *appserver.WorkRegistry, logger *zap.SugaredLogger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		killed := work.KillAll()
+		logger.Warnw("admin kill-switch triggered",
+			"audit", true,
+			"killed", killed,
+			"reason", r.URL.Query().Get("reason"),
+			"principal", auth.principal(r),
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Killed int `json:"killed"`
+		}{Killed: killed})
+	})
+}
+
+// newAdminMux は pprof や状態ダンプなど、スクレイプ対象と分離したい管理系エンドポイントを束ねる。
+// /healthz だけは認証なしで返し、admin リスナー自体の死活監視に使えるようにする
+func newAdminMux(auth adminAuth, inflight *observability.InFlightRegistry, logTail *observability.RecentEvents, work *appserver.WorkRegistry, elevations *appserver.Elevations, logger *zap.SugaredLogger) http.Handler {
+	// 認証が必要なハンドラは protected にまとめ、/debug/ と /admin/ の配下で公開する
+	protected := http.NewServeMux()
+	protected.HandleFunc("/debug/pprof/", pprof.Index)
+	protected.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	protected.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	protected.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	protected.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	protected.Handle("/admin/inflight", inflightHandler(inflight))
+	protected.Handle("/admin/logs", logTailHandler(logTail))
+	protected.Handle("/admin/kill", killHandler(auth, work, logger))
+	// /admin/jobs 系はリスナー自体の認証(requireAuth)を通ったリクエストだけが届くため、WorkControl には操作者を渡すだけにする
+	workCtl := appserver.NewWorkControl(work, auth.listenerPrincipal, logger)
+	protected.Handle("/admin/jobs", jobsHandler(workCtl))
+	protected.Handle("/admin/jobs/pause", pauseHandler(workCtl, true))
+	protected.Handle("/admin/jobs/resume", pauseHandler(workCtl, false))
+	protected.Handle("/admin/elevation", elevationHandler(auth, elevations, logger))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.Handle("/debug/", requireAuth(auth, protected))
+	mux.Handle("/admin/", requireAuth(auth, protected))
+	return mux
+}
+
+// newAdminHTTPServer は admin リスナー用の http.Server を返す。
+// pprof の profile/trace は既定で 30 秒かかるため、WriteTimeout を metrics より長めに取る
+func newAdminHTTPServer(addr string, auth adminAuth, inflight *observability.InFlightRegistry, logTail *observability.RecentEvents, work *appserver.WorkRegistry, elevations *appserver.Elevations, logger *zap.SugaredLogger) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           observability.WrapHTTPHandler(logger, "admin", newAdminMux(auth, inflight, logTail, work, elevations, logger)),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      65 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+}
+
+func getenvOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+func withAuthorization(v string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", v))
+}
+
+// gRPC の admin 系 RPC は admin の資格情報が無ければ fail closed で拒否し、設定されていれば HTTP と同じ資格情報だけを受け付ける
+func TestAdminAuth_AuthorizeRPC(t *testing.T) {
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("ops:secret"))
+	tests := []struct {
+		name          string
+		auth          adminAuth
+		ctx           context.Context
+		wantOK        bool
+		wantPrincipal string
+	}{
+		{"no auth configured", adminAuth{}, context.Background(), false, ""},
+		{"no auth configured with header", adminAuth{}, withAuthorization("Bearer t"), false, ""},
+		{"bearer", adminAuth{Token: "t"}, withAuthorization("Bearer t"), true, "bearer"},
+		{"wrong bearer", adminAuth{Token: "t"}, withAuthorization("Bearer x"), false, ""},
+		{"missing header", adminAuth{Token: "t"}, context.Background(), false, ""},
+		{"basic", adminAuth{User: "ops", Password: "secret"}, withAuthorization(basic), true, "ops"},
+		{"wrong basic", adminAuth{User: "ops", Password: "other"}, withAuthorization(basic), false, ""},
+	}
+	for _, tt := range tests {
+		p, ok := tt.auth.authorizeRPC(tt.ctx)
+		if ok != tt.wantOK || p != tt.wantPrincipal {
+			t.Fatalf("%s: authorizeRPC = (%q, %v), want (%q, %v)", tt.name, p, ok, tt.wantPrincipal, tt.wantOK)
+		}
+	}
+}
+
+// Basic 認証はユーザーとパスワードの両方が揃っている時だけ受け付け、片方だけなら起動時にエラーにする
+func TestAdminAuthFromEnv(t *testing.T) {
+	tests := []struct {
+		name, token, user, password string
+		wantErr                     bool
+	}{
+		{"none", "", "", "", false},
+		{"bearer", "t", "", "", false},
+		{"basic", "", "ops", "secret", false},
+		{"empty password", "", "ops", "", true},
+		{"empty password with bearer", "t", "ops", "", true},
+		{"password without user", "", "", "secret", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envAdminToken, tt.token)
+			t.Setenv(envAdminUser, tt.user)
+			t.Setenv(envAdminPassword, tt.password)
+			a, err := adminAuthFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("adminAuthFromEnv = %+v, %v, wantErr %v", a, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func get(t *testing.T, h http.Handler, target string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	body, _ := io.ReadAll(rec.Result().Body)
+	return rec.Code, string(body)
+}
+
+// /ready は gRPC health と共有した health.Server の状態をそのまま返す(NOT_SERVING は 503、SERVING は 200)
+func TestHTTPMux_Ready(t *testing.T) {
+	hs := health.NewServer()
+	gate := appserver.NewReadinessGate(hs)
+	gate.Register("grpc")
+	mux := newHTTPMux(prometheus.NewRegistry(), hs, gate, startupInfo{})
+
+	if code, body := get(t, mux, "/ready"); code != http.StatusServiceUnavailable || !strings.Contains(body, "NOT_SERVING") {
+		t.Fatalf("/ready while initializing = %d %q, want 503 NOT_SERVING", code, body)
+	}
+	if code, body := get(t, mux, "/ready?verbose"); code != http.StatusServiceUnavailable || !strings.Contains(body, `"name":"grpc"`) {
+		t.Fatalf("/ready?verbose = %d %q, want 503 with subsystems", code, body)
+	}
+
+	gate.SetReady("grpc")
+	if code, body := get(t, mux, "/ready"); code != http.StatusOK || body != "LIVE\n" {
+		t.Fatalf("/ready when serving = %d %q, want 200 LIVE", code, body)
+	}
+
+	// health.Server を直接切り替えても /ready に反映される
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	if code, _ := get(t, mux, "/ready"); code != http.StatusServiceUnavailable {
+		t.Fatalf("/ready after NOT_SERVING = %d, want 503", code)
+	}
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	if code, _ := get(t, mux, "/ready"); code != http.StatusOK {
+		t.Fatalf("/ready after SERVING = %d, want 200", code)
+	}
+	// 停止の開始(healthSrv.Shutdown)で 503 に戻る
+	hs.Shutdown()
+	if code, _ := get(t, mux, "/ready"); code != http.StatusServiceUnavailable {
+		t.Fatalf("/ready after Shutdown = %d, want 503", code)
+	}
+}
+
+// /stats/prometheus は /metrics と同じ gatherer のメトリクスを返す(Envoy 互換のエイリアス)
+func TestHTTPMux_StatsPrometheus(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "cno_app_test_total", Help: "test"})
+	reg.MustRegister(c)
+	c.Add(3)
+	mux := newHTTPMux(reg, health.NewServer(), nil, startupInfo{})
+
+	for _, path := range []string{"/metrics", "/stats/prometheus"} {
+		code, body := get(t, mux, path)
+		if code != http.StatusOK || !strings.Contains(body, "cno_app_test_total 3") {
+			t.Fatalf("%s = %d %q, want 200 with cno_app_test_total", path, code, body)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+// /admin/jobs 系は WorkControlService(cno.app.v1.WorkControlService)の実装を HTTP から呼ぶための薄いラッパー。
+// 認証は admin リスナー(requireAuth)で済んでおり、対象の選択と監査ログはサービス側で行う。ここではクエリと JSON の変換だけをする
+
+// jobsHandler は実行中の負荷(id / request_id / run_id / 一時停止しているか)を開始が古い順に JSON で返す(GET /admin/jobs)。
+// WorkControlService の List を呼ぶ
+func jobsHandler(ctl *appserver.WorkControl) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		out, err := ctl.List(rpcContext(r), &emptypb.Empty{})
+		writeRPCResult(w, out, err)
+	})
+}
+
+// pauseHandler は実行中の負荷を一時停止(paused=true)または再開する(POST /admin/jobs/pause, /admin/jobs/resume)。
+// クエリの id / request_id / run_id / reason をそのまま WorkControlService の Pause / Resume に渡す
+func pauseHandler(ctl *appserver.WorkControl, paused bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fields := map[string]*structpb.Value{}
+		for _, k := range []string{"id", "request_id", "run_id", "reason"} {
+			if v := r.URL.Query().Get(k); v != "" {
+				fields[k] = structpb.NewStringValue(v)
+			}
+		}
+		in := &structpb.Struct{Fields: fields}
+
+		apply := ctl.Resume
+		if paused {
+			apply = ctl.Pause
+		}
+		out, err := apply(rpcContext(r), in)
+		writeRPCResult(w, out, err)
+	})
+}
+
+// rpcContext は HTTP リクエストの資格情報(Authorization)、user-agent、送信元を gRPC の呼び出しと同じ形で ctx に載せる
+func rpcContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	if v := r.Header.Get("Authorization"); v != "" {
+		md.Set("authorization", v)
+	}
+	if v := r.UserAgent(); v != "" {
+		md.Set("user-agent", v)
+	}
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: net.TCPAddrFromAddrPort(ap)})
+	}
+	return ctx
+}
+
+// writeRPCResult は RPC の応答を JSON で、エラーはステータスコードに対応する HTTP ステータスで返す
+func writeRPCResult(w http.ResponseWriter, out *structpb.Struct, err error) {
+	if err != nil {
+		st := status.Convert(err)
+		code := http.StatusInternalServerError
+		switch st.Code() {
+		case codes.Unauthenticated:
+			code = http.StatusUnauthorized
+		case codes.InvalidArgument:
+			code = http.StatusBadRequest
+		}
+		http.Error(w, st.Message(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out.AsMap())
+}
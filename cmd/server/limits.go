@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+const envLimitProfiles = "CNO_APP_LIMIT_PROFILES"
+
+// limitSpec は 1 モード分の上限の上書き。省略した項目は元の値のまま、0 はそのモードでは制限しない
+type limitSpec struct {
+	MaxDurationMs  *int64 `json:"max_duration_ms"`
+	MaxAllocMB     *int   `json:"max_alloc_mb"`
+	MaxParallelism *int   `json:"max_parallelism"`
+	MaxIOBytes     *int   `json:"max_io_bytes"`
+}
+
+// overlayLimitProfiles は raw(例: {"io": {"max_io_bytes": 1048576}})で base のモード別上限を上書きしたコピーを返す
+func overlayLimitProfiles(base load.LimitProfiles, raw []byte) (load.LimitProfiles, error) {
+	var specs map[load.Mode]limitSpec
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&specs); err != nil {
+		return nil, err
+	}
+
+	out := make(load.LimitProfiles, len(base)+len(specs))
+	for mode, l := range base {
+		out[mode] = l
+	}
+	for mode, spec := range specs {
+		l := out.For(mode)
+		if spec.MaxDurationMs != nil {
+			l.MaxDuration = time.Duration(*spec.MaxDurationMs) * time.Millisecond
+		}
+		if spec.MaxAllocMB != nil {
+			l.MaxAllocMB = *spec.MaxAllocMB
+		}
+		if spec.MaxParallelism != nil {
+			l.MaxParallelism = *spec.MaxParallelism
+		}
+		if spec.MaxIOBytes != nil {
+			l.MaxIOBytes = *spec.MaxIOBytes
+		}
+		out[mode] = l
+	}
+	if err := out.Validate(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// limitProfilesFromEnv は CNO_APP_LIMIT_PROFILES(JSON)で既定のモード別上限を上書きした値を返す
+func limitProfilesFromEnv() (load.LimitProfiles, error) {
+	profiles := load.DefaultLimitProfiles()
+	v := os.Getenv(envLimitProfiles)
+	if v == "" {
+		return profiles, nil
+	}
+	profiles, err := overlayLimitProfiles(profiles, []byte(v))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envLimitProfiles, err)
+	}
+	return profiles, nil
+}
+
+// limitFields は上限を "limits.<mode>.<項目>" の平坦な map に追加する(再読み込み時の差分表示用)
+func limitFields(p load.LimitProfiles, out map[string]any) {
+	for mode, l := range p {
+		prefix := "limits." + string(mode) + "."
+		out[prefix+"max_duration_ms"] = l.MaxDuration.Milliseconds()
+		out[prefix+"max_alloc_mb"] = l.MaxAllocMB
+		out[prefix+"max_parallelism"] = l.MaxParallelism
+		out[prefix+"max_io_bytes"] = l.MaxIOBytes
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// envLogTailSize は /admin/logs で返せるよう、メモリに保持する直近のログの件数。0 で保持しない
+const envLogTailSize = "CNO_APP_LOG_TAIL_SIZE"
+
+const (
+	defaultLogTailSize = 1000
+	maxLogTailSize     = 100000
+
+	// defaultLogTailLimit は /admin/logs で limit を指定しない時に返す件数
+	defaultLogTailLimit = 100
+)
+
+// logTailSizeFromEnv は CNO_APP_LOG_TAIL_SIZE を読み取る
+func logTailSizeFromEnv() (int, error) {
+	v := os.Getenv(envLogTailSize)
+	if v == "" {
+		return defaultLogTailSize, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", envLogTailSize, v, err)
+	}
+	if n < 0 || n > maxLogTailSize {
+		return 0, fmt.Errorf("%s must be between 0 and %d, got %d", envLogTailSize, maxLogTailSize, n)
+	}
+	return n, nil
+}
+
+// logTailHandler は保持している直近のログを返す(GET /admin/logs)。
+// Loki などへのログの収集(shipping)が壊れていても、プロセスに直接聞いて直前の様子を確認できるようにする。
+//
+//   - level: このレベル以上に絞る(debug / info / warn / error。既定は全て)
+//   - field: "key=value" のフィールドに一致するログに絞る(繰り返し指定すると全て一致するもの)
+//   - limit: 条件に合うログのうち新しい方から返す件数(既定 100、0 で保持している全て)
+func logTailHandler(events *observability.RecentEvents) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if events == nil {
+			http.Error(w, "log tail is disabled ("+envLogTailSize+"=0)", http.StatusNotFound)
+			return
+		}
+		filter, err := parseLogTailQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got := events.Query(filter)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Count  int                         `json:"count"`
+			Events []observability.RecentEvent `json:"events"`
+		}{Count: len(got), Events: got})
+	})
+}
+
+func parseLogTailQuery(r *http.Request) (observability.RecentEventsFilter, error) {
+	q := r.URL.Query()
+	f := observability.RecentEventsFilter{MinLevel: zapcore.DebugLevel, Limit: defaultLogTailLimit}
+	if v := q.Get("level"); v != "" {
+		if err := f.MinLevel.UnmarshalText([]byte(v)); err != nil {
+			return f, fmt.Errorf("invalid level %q", v)
+		}
+	}
+	for _, v := range q["field"] {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return f, fmt.Errorf("field must be key=value, got %q", v)
+		}
+		if f.Fields == nil {
+			f.Fields = map[string]string{}
+		}
+		f.Fields[key] = value
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("limit must be an integer >= 0, got %q", v)
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
@@ -1,32 +1,45 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"flag"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
+	channelzsvc "google.golang.org/grpc/channelz/service"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
+	"github.com/shtsukada/cloudnative-observability-app/pkg/clientconfig"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/debugecho"
 	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
 	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/webhook"
 	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
 )
 
 const (
-	grpcAddr    = ":8080"
-	metricsAddr = ":9090"
+	defaultGRPCAddr    = ":8080"
+	defaultMetricsAddr = ":9090"
+	defaultAdminAddr   = ":9091"
 )
 
 // newGRPCServer は interceptor やオプションを差し込みやすいよう、
@@ -37,48 +50,144 @@ const (
 // }
 
 // registerGRPCServices は gRPC サーバーに標準サービスとアプリケーションサービスを登録する
-func registerGRPCServices(s *grpc.Server) {
+// healthServer は HTTP の /ready と共有するため呼び出し側で生成して渡す。
+// デフォルトサービス名 "" の状態は ReadinessGate が管理する
+func registerGRPCServices(s *grpc.Server, healthServer *health.Server, logger *zap.SugaredLogger, clientCfgSrv *clientconfig.Server, webhookSrv *webhook.Server, workCtl *appserver.WorkControl, burnerOpts []appserver.Option) {
 	// HealthCheck
-	healthServer := health.NewServer()
 	healthpb.RegisterHealthServer(s, healthServer)
-	// デフォルトサービス名 "" を SERVINGにしておく
-	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
 
 	// アプリケーションのgRPCサービス
-	burner := appserver.NewGrpcBurnerServer()
+	burner := appserver.NewGrpcBurnerServer(logger, burnerOpts...)
 	grpcburnerv1.RegisterBurnerServer(s, burner)
+	// Client streaming の途中経過を返す版(proto に RPC を追加するまでの手書き ServiceDesc)
+	appserver.RegisterProgressServer(s, burner)
+	// 指定したステータスコードをそのまま返す(アラートやリトライポリシーのコードごとの確認用)
+	appserver.RegisterReturnCodeServer(s, burner)
+	// 実行中の負荷の一覧と一時停止/再開(admin リスナーと同じ資格情報を metadata authorization で受け付ける)。
+	// admin の資格情報が無い、または admin リスナーが off なら登録しない
+	if workCtl != nil {
+		appserver.RegisterWorkControlServer(s, workCtl)
+	}
+
+	// クライアントへ推奨設定(タイムアウト/リトライ/メッセージサイズ)を配布する
+	clientconfig.Register(s, clientCfgSrv)
+
+	// 負荷の終了を通知する webhook の通知先を実行中に登録・削除する(WorkControlService と同じ資格情報が必要)
+	if webhookSrv != nil {
+		webhook.Register(s, webhookSrv)
+	}
+
+	// proxy / mesh 越しの metadata や trace context の伝播を確認するためのデバッグ用 RPC
+	debugecho.Register(s, debugecho.NewServer())
+
+	// channelz: コネクション/ストリーム単位の統計をトランスポート層まで確認する(client の --mode=channelz で読む)
+	channelzsvc.RegisterChannelzServiceToServer(s)
 
 	// Reflection
 	reflection.Register(s)
 }
 
-func newHTTPMux() http.Handler {
+// newHTTPMux はスクレイプ/プローブ対象となる /metrics, /healthz と、
+// その Envoy 互換のエイリアス(/stats/prometheus, /ready)、起動バナーの /startupz だけを公開する。
+// pprof などの管理系エンドポイントは newAdminMux 側に載せる
+func newHTTPMux(gatherer prometheus.Gatherer, hs healthpb.HealthServer, gate *appserver.ReadinessGate, startup startupInfo) http.Handler {
 	mux := http.NewServeMux()
 
-	// Prometheusメトリクス
-	mux.Handle("/metrics", promhttp.Handler())
+	// Prometheusメトリクス。メッシュ環境の既存スクレイプ設定向けに Envoy と同じパスでも返す
+	metrics := promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+			// run_id の exemplar を公開するため OpenMetrics でのネゴシエーションを許可する
+			EnableOpenMetrics: true,
+		}),
+	)
+	mux.Handle("/metrics", metrics)
+	mux.Handle("/stats/prometheus", metrics)
 
 	// シンプルなヘルスチェック
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		// 将来的に gRPC health の状態を見に行く実装に差し替えても良い
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ok\n"))
-	})
+	mux.HandleFunc("/healthz", healthzHandler)
+	// gRPC health の状態を反映する readiness(Envoy の /ready 互換)
+	mux.Handle("/ready", readyHandler(hs, gate))
+	// 起動時の設定/ビルド情報/リスナー(admin を無効にしていても取れるよう metrics 側に置く。秘密情報は含めない)
+	mux.Handle("/startupz", startupzHandler(startup))
 	return mux
 }
 
-func newHTTPServer(addr string) *http.Server {
+func newHTTPServer(addr string, gatherer prometheus.Gatherer, hs healthpb.HealthServer, gate *appserver.ReadinessGate, startup startupInfo, logger *zap.SugaredLogger) *http.Server {
 	return &http.Server{
 		Addr:              addr,
-		Handler:           newHTTPMux(),
+		Handler:           observability.WrapHTTPHandler(logger, "metrics", newHTTPMux(gatherer, hs, gate, startup)),
 		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
 }
 
 func main() {
-	logger := observability.NewLogger()
+	// ログレベルは設定ファイルの log_level で実行中に変更できる
+	logLevel := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	logger := observability.NewLoggerWithLevel(logLevel)
+
+	flags, err := parseFlags(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		logger.Fatalw("invalid flags", "err", err)
+	}
+	addrs := flags.Addrs
+
+	if flags.ValidateConfig {
+		report := validateConfig(flags)
+		if err := writeReport(os.Stdout, report); err != nil {
+			logger.Fatalw("failed to write config report", "err", err)
+		}
+		if !report.Valid {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// panic / fatal の時に直近のログや goroutine ダンプをまとめたクラッシュレポートを書き出す
+	crashCfg, err := crashConfigFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid crash report config", "err", err)
+	}
+	logTailSize, err := logTailSizeFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid log tail config", "err", err)
+	}
+	// /admin/logs とクラッシュレポートのために、直近のログをメモリにも保持する
+	recentSize := logTailSize
+	if crashCfg.Dir != "" {
+		recentSize = max(recentSize, crashCfg.Events)
+	}
+	var recent, logTail *observability.RecentEvents
+	if recentSize > 0 {
+		recent = observability.NewRecentEvents(recentSize)
+		logger = logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(core, recent.Core(logLevel))
+		}))
+	}
+	if logTailSize > 0 {
+		logTail = recent
+	}
+	var crash *crashReporter
+	logger, crash, err = installCrashReporter(crashCfg, logger, recent)
+	if err != nil {
+		logger.Fatalw("failed to install crash reporter", "err", err)
+	}
+	defer crash.recoverPanic(logger, "main")
+
+	settings, err := loadReloadableSettings(flags.ConfigFile)
+	if err != nil {
+		logger.Fatalw("invalid config", "config_file", flags.ConfigFile, "err", err)
+	}
+	logLevel.SetLevel(settings.LogLevel)
+	if flags.ConfigFile != "" {
+		logger.Infow("config file loaded", "config_file", flags.ConfigFile, "log_level", settings.LogLevel.String())
+	}
 
 	ctx := context.Background()
 	tracingShutdown, err := observability.InitTracerProvider(ctx)
@@ -93,54 +202,429 @@ func main() {
 		}
 	}()
 
-	metricsSrv := newHTTPServer(metricsAddr)
+	gatherer, err := observability.RegisterRuntimeCollectors(observability.RuntimeMetricsConfigFromEnv("cno-app"))
+	if err != nil {
+		logger.Fatalw("failed to register runtime collectors", "err", err)
+	}
+
+	// GC の頻度の比較用に heap の目標値を押し上げる ballast を確保する(cno_app_gc_* で GOMEMLIMIT と比べる)
+	allocateBallast(flags.BallastMB, logger)
 
-	grpcLis, err := net.Listen("tcp", grpcAddr) //nolint:gosec
+	// スクレイプに加えて remote-write で自分のメトリクスを送る(手元に Prometheus がない環境向け)
+	remoteWrite, err := observability.RemoteWriteConfigFromEnv()
 	if err != nil {
-		logger.Fatal("failed to listen", "addr", grpcAddr, "err", err)
+		logger.Fatalw("invalid remote write config", "err", err)
 	}
 
+	// コアのリクエストメトリクスを statsd / DogStatsD にも送る(Datadog 形式のエージェントとの相互運用)
+	statsdCfg, err := observability.StatsdConfigFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid statsd config", "err", err)
+	}
+	var statsd *observability.StatsdEmitter
+	if statsdCfg.Enabled() {
+		statsd, err = observability.NewStatsdEmitter(statsdCfg, logger)
+		if err != nil {
+			logger.Fatalw("failed to start statsd emitter", "err", err)
+		}
+	}
+
+	// DoWork の終了・失敗を署名付きの webhook で外部(Slack への中継や CI)に知らせる
+	webhookCfg, err := webhook.ConfigFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid webhook config", "err", err)
+	}
+	var notifier *webhook.Notifier
+	if webhookCfg.Enabled() {
+		notifier = webhook.NewNotifier(webhookCfg, logger)
+	}
+
+	// gRPC health と HTTP /ready で同じ状態を返す。
+	// バックグラウンドのサブシステムは起動前に gate へ登録し、全て ready になるまで NOT_SERVING にする
+	healthSrv := health.NewServer()
+	gate := appserver.NewReadinessGate(healthSrv)
+	gate.Register("grpc")
+
+	// リスナーは起動前にまとめて作り、ポート競合を起動時に検出する。
+	// ":0" などを指定した場合も実際にバインドされたアドレスをログに残せる
+	metricsLis := mustListen(logger, "metrics", addrs.Metrics)
+
+	// admin リスナーは --admin-addr=off (CNO_APP_ADMIN_ADDR="off") で無効化できる
+	inflight := observability.NewInFlightRegistry()
+	work := appserver.NewWorkRegistry()
+	// elevation token は admin リスナーで発行し、gRPC の DoWork で受け付ける。署名鍵が無ければどちらも無効
+	var elevations *appserver.Elevations
+	if secret := os.Getenv(envElevationSecret); secret != "" {
+		elevations = appserver.NewElevations(secret)
+	}
+	var adminSrv *http.Server
+	var adminLis net.Listener
+	auth, err := adminAuthFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid admin auth config", "err", err)
+	}
+	// 負荷の一時停止/再開を gRPC(WorkControlService)でも受け付けるのは、admin リスナーが有効で資格情報が設定されている時だけ。
+	// gRPC のポートは公開されている前提のため、kill-switch や elevation の発行と同じく認証なしでは開けない(fail closed)
+	var workCtl *appserver.WorkControl
+	if addrs.Admin != "off" {
+		adminLis = mustListen(logger, "admin", addrs.Admin)
+		if !auth.enabled() {
+			logger.Warnw("admin http has no auth configured", "addr", adminLis.Addr().String(), "disabled_grpc_service", appserver.WorkControlServiceName)
+		} else {
+			workCtl = appserver.NewWorkControl(work, auth.authorizeRPC, logger)
+		}
+		adminSrv = newAdminHTTPServer(adminLis.Addr().String(), auth, inflight, logTail, work, elevations, logger)
+	}
+	// WebhookService は一覧も含めて admin の資格情報で認証する。資格情報が無ければ全ての RPC を UNAUTHENTICATED で拒否する
+	var webhookSrv *webhook.Server
+	if notifier != nil {
+		webhookSrv = webhook.NewServer(notifier, auth.authorizeRPC)
+		if webhookCfg.AllowRPC && !auth.enabled() {
+			logger.Warnw("webhook rpc is enabled but admin auth is not configured; WebhookService rejects all calls")
+		}
+	}
+
+	grpcLis := mustListen(logger, "grpc", addrs.GRPC)
+
 	otelHandler := otelgrpc.NewServerHandler(
 		otelgrpc.WithTracerProvider(otel.GetTracerProvider()),
 	)
 
-	grpcSrv := grpc.NewServer(
+	maxStreams, err := maxConcurrentStreamsFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid grpc config", "err", err)
+	}
+	keepalivePolicy, keepaliveSet, err := keepaliveEnforcementFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid grpc config", "err", err)
+	}
+
+	payloadCfg := settings.PayloadLog
+	if payloadCfg.Enabled() {
+		logger.Warnw("grpc payload logging enabled",
+			"sample_rate", payloadCfg.SampleRate,
+			"max_bytes", payloadCfg.MaxBytes,
+			"redact_fields", payloadCfg.RedactFields,
+		)
+	}
+
+	spanMetadataKeys := spanMetadataKeysFromEnv()
+
+	ioDir, err := ioDirFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid load config", "err", err)
+	}
+	if ioDir != "" {
+		logger.Infow("io load directory", "dir", ioDir)
+	}
+
+	noisy, err := noisyNeighborFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid noisy neighbor config", "err", err)
+	}
+	if noisy.Enabled() {
+		logger.Warnw("noisy neighbor simulation enabled",
+			"rate", noisy.Rate,
+			"max_delay", noisy.MaxDelay.String(),
+			"per_second", noisy.PerSecond,
+		)
+	}
+
+	streamLimits, err := streamLimitsFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid stream limits config", "err", err)
+	}
+
+	watchdogGrace, err := watchdogGraceFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid load watchdog config", "err", err)
+	}
+
+	cpuAffinity, err := cpuAffinityFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid cpu affinity config", "err", err)
+	}
+	if cpuAffinity {
+		logger.Warnw("cpu affinity for load workers enabled", "metadata_key", appserver.CPUAffinityMetadataKey)
+	}
+
+	cpuWorkers, err := cpuWorkersFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid cpu workers config", "err", err)
+	}
+
+	coalesce, err := doWorkCoalesceFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid dowork coalesce config", "err", err)
+	}
+
+	// DoWork の一部を shadow サーバーへ複製する(traffic shadowing)。shadow は負荷を縮めて実行する
+	mirrorCfg, err := mirrorFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid mirror config", "err", err)
+	}
+	var mirror *appserver.MirrorClient
+	if mirrorCfg.Enabled() {
+		if mirror, err = appserver.NewMirrorClient(mirrorCfg, logger); err != nil {
+			logger.Fatalw("failed to create mirror client", "err", err)
+		}
+	}
+
+	payloadRC := observability.NewReloadablePayloadLogConfig(payloadCfg)
+	clientCfgSrv := clientconfig.NewServer(settings.ClientConfig)
+	limitsRC := appserver.NewReloadableLimitProfiles(settings.Limits)
+
+	serverOpts := []grpc.ServerOption{
 		grpc.StatsHandler(otelHandler),
+		grpc.StatsHandler(observability.NewConnStreamsHandler()),
 		grpc.ChainUnaryInterceptor(
+			appserver.UnaryServerTimingInterceptor,
 			grpc_prometheus.UnaryServerInterceptor,
 			observability.UnaryMetricsInterceptor,
 			observability.UnaryLoggingInterceptor(logger),
+			observability.UnarySpanResultInterceptor,
+			observability.UnarySpanMetadataInterceptor(spanMetadataKeys),
+			observability.UnaryInFlightInterceptor(inflight),
+			observability.UnaryReloadablePayloadLoggingInterceptor(logger, payloadRC),
 		),
 		grpc.ChainStreamInterceptor(
+			appserver.StreamServerTimingInterceptor,
 			grpc_prometheus.StreamServerInterceptor,
 			observability.StreamMetricsInterceptor,
+			observability.StreamLoggingInterceptor(logger),
+			observability.StreamSpanResultInterceptor,
+			observability.StreamSpanMetadataInterceptor(spanMetadataKeys),
+			observability.StreamInFlightInterceptor(inflight),
+			observability.StreamReloadablePayloadLoggingInterceptor(logger, payloadRC),
 		),
-	)
+	}
+	if statsd != nil {
+		serverOpts = append(serverOpts,
+			grpc.ChainUnaryInterceptor(observability.UnaryStatsdInterceptor(statsd)),
+			grpc.ChainStreamInterceptor(observability.StreamStatsdInterceptor(statsd)),
+		)
+	}
+	if notifier != nil {
+		serverOpts = append(serverOpts,
+			grpc.ChainUnaryInterceptor(webhook.UnaryServerInterceptor(notifier)),
+			grpc.ChainStreamInterceptor(webhook.StreamServerInterceptor(notifier)),
+		)
+	}
+	if crash != nil {
+		serverOpts = append(serverOpts,
+			grpc.ChainUnaryInterceptor(crash.unaryInterceptor(logger)),
+			grpc.ChainStreamInterceptor(crash.streamInterceptor(logger)),
+		)
+	}
+	if maxStreams > 0 {
+		// コネクションあたりの同時ストリーム数を制限し、HTTP/2 ストリーム枯渇を再現できるようにする
+		serverOpts = append(serverOpts, grpc.MaxConcurrentStreams(maxStreams))
+		logger.Infow("grpc max concurrent streams", "max_concurrent_streams", maxStreams)
+	}
+	if keepaliveSet {
+		// クライアントの --keepalive-time より長いと、ping を送ったクライアントの接続を切ってしまう
+		serverOpts = append(serverOpts, grpc.KeepaliveEnforcementPolicy(keepalivePolicy))
+		logger.Infow("grpc keepalive enforcement",
+			"min_time", keepalivePolicy.MinTime.String(),
+			"permit_without_stream", keepalivePolicy.PermitWithoutStream,
+		)
+	}
+
+	// 起動バナー: 解決済みの設定とリスナーを 1 行のログと /startupz にまとめる
+	listeners := map[string]string{"grpc": grpcLis.Addr().String(), "metrics": metricsLis.Addr().String(), "admin": "off"}
+	subsystems := []string{"grpc", "metrics_http", "tracing"}
+	if adminLis != nil {
+		listeners["admin"] = adminLis.Addr().String()
+		subsystems = append(subsystems, "admin_http")
+	}
+	if flags.ConfigFile != "" {
+		subsystems = append(subsystems, "config_reload")
+	}
+	if payloadCfg.Enabled() {
+		subsystems = append(subsystems, "payload_logging")
+	}
+	if noisy.Enabled() {
+		subsystems = append(subsystems, "noisy_neighbor")
+	}
+	if cpuAffinity {
+		subsystems = append(subsystems, "cpu_affinity")
+	}
+	if watchdogGrace > 0 {
+		subsystems = append(subsystems, "load_watchdog")
+	}
+	if coalesce {
+		subsystems = append(subsystems, "dowork_coalesce")
+	}
+	if mirror != nil {
+		subsystems = append(subsystems, "dowork_mirror")
+	}
+	if crash != nil {
+		subsystems = append(subsystems, "crash_report")
+	}
+	if remoteWrite.Enabled() {
+		subsystems = append(subsystems, "remote_write")
+	}
+	if statsdCfg.Enabled() {
+		subsystems = append(subsystems, "statsd")
+	}
+	if webhookCfg.Enabled() {
+		subsystems = append(subsystems, "webhooks")
+	}
+	spanMetrics, err := observability.SpanMetricsFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid span metrics config", "err", err)
+	}
+	if spanMetrics {
+		subsystems = append(subsystems, "span_metrics")
+	}
+	config := settings.fields()
+	config["config_file"] = flags.ConfigFile
+	config["admin.auth"] = auth.mode()
+	config["grpc.max_concurrent_streams"] = maxStreams
+	config["grpc.keepalive_min_time"] = keepalivePolicy.MinTime.String()
+	config["grpc.keepalive_permit_without_stream"] = keepalivePolicy.PermitWithoutStream
+	config["trace.metadata_keys"] = strings.Join(spanMetadataKeys, ",")
+	traceExporter, err := observability.TraceExporterFromEnv()
+	if err != nil {
+		logger.Fatalw("invalid trace exporter config", "err", err)
+	}
+	config["trace.exporter"] = traceExporter
+	config["trace.endpoint"] = observability.TraceExporterEndpoint(traceExporter)
+	config["trace.span_metrics"] = spanMetrics
+	if traceExporter == observability.TraceExporterZipkin {
+		// Zipkin は URL のため、ユーザー情報とクエリを除いて出す
+		config["trace.endpoint"] = redactURL(observability.TraceExporterEndpoint(traceExporter))
+	}
+	config["load.io_dir"] = ioDir
+	config["load.watchdog_grace"] = watchdogGrace
+	config["load.cpu_affinity"] = cpuAffinity
+	// 未設定(0)なら GOMAXPROCS を使うため、実際の枠の大きさを出す
+	config["load.cpu_workers"] = cmp.Or(cpuWorkers, runtime.GOMAXPROCS(0))
+	config["gc.ballast_mb"] = flags.BallastMB
+	config["gc.gogc"] = os.Getenv("GOGC")
+	config["gc.gomemlimit"] = os.Getenv("GOMEMLIMIT")
+	config["dowork.coalesce"] = coalesce
+	config["limits.elevation"] = elevations != nil
+	config["mirror.addr"] = mirrorCfg.Addr
+	config["mirror.percent"] = mirrorCfg.Percent
+	config["mirror.work_fraction"] = mirrorCfg.WorkFraction
+	config["noisy_neighbor.rate"] = noisy.Rate
+	config["noisy_neighbor.max_delay"] = noisy.MaxDelay.String()
+	config["noisy_neighbor.per_second"] = noisy.PerSecond
+	config["stream_limits.max_messages"] = streamLimits.MaxMessages
+	config["stream_limits.max_work"] = streamLimits.MaxWork.String()
+	config["remote_write.url"] = redactURL(remoteWrite.URL)
+	config["remote_write.interval"] = remoteWrite.Interval.String()
+	config["remote_write.auth"] = remoteWrite.AuthMode()
+	config["statsd.addr"] = statsdCfg.Addr
+	config["statsd.flavor"] = string(statsdCfg.Flavor)
+	config["webhook.targets"] = len(webhookCfg.Targets)
+	config["webhook.rpc"] = webhookCfg.AllowRPC
+	config["webhook.signed"] = webhookCfg.Secret != ""
+	config["log_tail.size"] = logTailSize
+	config["crash_report.dir"] = crashCfg.Dir
+	config["crash_report.events"] = crashCfg.Events
+	startup := newStartupInfo(listeners, subsystems, config)
+	if crash != nil {
+		crash.setStartup(startup)
+	}
+	logger.Infow("server startup", "startup", startup)
+
+	metricsSrv := newHTTPServer(metricsLis.Addr().String(), gatherer, healthSrv, gate, startup, logger)
+
+	grpcSrv := grpc.NewServer(serverOpts...)
 	grpc_prometheus.Register(grpcSrv)
-	registerGRPCServices(grpcSrv)
+	registerGRPCServices(grpcSrv, healthSrv, logger, clientCfgSrv, webhookSrv, workCtl, []appserver.Option{appserver.WithIODir(ioDir), appserver.WithWorkRegistry(work), appserver.WithNoisyNeighbor(noisy), appserver.WithLimitProfiles(limitsRC), appserver.WithStreamLimits(streamLimits), appserver.WithWatchdogGrace(watchdogGrace), appserver.WithCPUAffinity(cpuAffinity), appserver.WithCPUWorkers(cpuWorkers), appserver.WithCoalescing(coalesce), appserver.WithElevations(elevations), appserver.WithMirror(mirror)})
 
 	go func() {
-		logger.Infow("metrics http starting", "addr", metricsAddr)
-		if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Infow("metrics http starting", "addr", metricsLis.Addr().String(), "requested_addr", addrs.Metrics)
+		if err := metricsSrv.Serve(metricsLis); err != nil && err != http.ErrServerClosed {
 			logger.Error("metrics http error", "err", err)
 		}
 	}()
+	if adminSrv != nil {
+		go func() {
+			logger.Infow("admin http starting", "addr", adminLis.Addr().String(), "requested_addr", addrs.Admin)
+			if err := adminSrv.Serve(adminLis); err != nil && err != http.ErrServerClosed {
+				logger.Error("admin http error", "err", err)
+			}
+		}()
+	}
+	rwCtx, stopRemoteWrite := context.WithCancel(ctx)
+	rwDone := make(chan struct{})
+	if remoteWrite.Enabled() {
+		go func() {
+			defer close(rwDone)
+			logger.Infow("remote write starting", "url", redactURL(remoteWrite.URL), "interval", remoteWrite.Interval.String())
+			observability.NewRemoteWriter(remoteWrite, gatherer, logger).Run(rwCtx)
+		}()
+	} else {
+		close(rwDone)
+	}
 	go func() {
-		logger.Infow("grpc starting", "addr", grpcAddr)
+		logger.Infow("grpc starting", "addr", grpcLis.Addr().String(), "requested_addr", addrs.GRPC)
+		// リスナーはバインド済みなので、Serve の開始前に受け付けた接続もキューに入る
+		gate.SetReady("grpc")
 		if err := grpcSrv.Serve(grpcLis); err != nil {
 			logger.Error("grpc serve error", "err", err)
 		}
 	}()
 
+	// SIGHUP で設定ファイルを読み直し、再起動せずに変更できる項目だけを反映する
+	rl := &reloader{
+		path:      flags.ConfigFile,
+		logger:    logger,
+		level:     logLevel,
+		payload:   payloadRC,
+		clientCfg: clientCfgSrv,
+		limits:    limitsRC,
+		current:   settings,
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logger.Infow("SIGHUP received, reloading config", "config_file", flags.ConfigFile)
+			rl.reload()
+		}
+	}()
+
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	<-sig
 	logger.Info("shutting down...")
 
+	// 停止中は /ready と gRPC health を NOT_SERVING にし、新規トラフィックを外してもらう
+	healthSrv.Shutdown()
 	grpcSrv.GracefulStop()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	// 停止までに終わった負荷の通知を送り終えてから終了する
+	if notifier != nil {
+		notifier.Close(ctx)
+	}
+	if mirror != nil {
+		mirror.Close(ctx)
+	}
 	_ = metricsSrv.Shutdown(ctx)
+	if adminSrv != nil {
+		_ = adminSrv.Shutdown(ctx)
+	}
+	// 停止までのリクエストを反映した最後の値を送ってから終了する
+	stopRemoteWrite()
+	<-rwDone
+	if statsd != nil {
+		_ = statsd.Close()
+	}
 
 	logger.Info("bye")
 }
+
+// mustListen は addr で TCP リスナーを作る。失敗した場合はどのリスナーかをログに残して終了する
+func mustListen(logger *zap.SugaredLogger, name, addr string) net.Listener {
+	lis, err := net.Listen("tcp", addr) //nolint:gosec
+	if err != nil {
+		logger.Fatalw("failed to listen", "listener", name, "addr", addr, "err", err)
+	}
+	return lis
+}
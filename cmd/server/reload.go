@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/clientconfig"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+const envConfigFile = "CNO_APP_CONFIG_FILE"
+
+// configFile は --config (CNO_APP_CONFIG_FILE) で指定する YAML の設定ファイル。
+// ここに書いた項目だけが環境変数(または既定値)を上書きし、SIGHUP で再読み込みされる
+type configFile struct {
+	LogLevel   string `yaml:"log_level"`
+	PayloadLog struct {
+		SampleRate   *float64 `yaml:"sample_rate"`
+		MaxBytes     *int     `yaml:"max_bytes"`
+		RedactFields []string `yaml:"redact_fields"`
+		EmitDefaults *bool    `yaml:"emit_defaults"`
+		Enums        string   `yaml:"enums"`
+		FieldNames   string   `yaml:"field_names"`
+	} `yaml:"payload_log"`
+	// ClientConfig は CNO_APP_CLIENT_CONFIG と同じ形(clientconfig.Config の JSON フィールド名)で書く
+	ClientConfig map[string]any `yaml:"client_config"`
+	// Limits は CNO_APP_LIMIT_PROFILES と同じ形(モード名 -> max_* の上書き)で書く
+	Limits map[string]any `yaml:"limits"`
+}
+
+// reloadableSettings は再起動せずに変更できる設定。
+// リスナーのアドレスや interceptor の構成など、それ以外の設定の変更には再起動が必要
+type reloadableSettings struct {
+	LogLevel     zapcore.Level
+	PayloadLog   observability.PayloadLogConfig
+	ClientConfig clientconfig.Config
+	Limits       load.LimitProfiles
+}
+
+// loadReloadableSettings は環境変数の設定に path の設定ファイルを重ねた値を返す。path が空なら環境変数のみ
+func loadReloadableSettings(path string) (reloadableSettings, error) {
+	payloadCfg, err := payloadLogConfigFromEnv()
+	if err != nil {
+		return reloadableSettings{}, err
+	}
+	clientCfg, err := clientconfig.FromEnv()
+	if err != nil {
+		return reloadableSettings{}, err
+	}
+	limits, err := limitProfilesFromEnv()
+	if err != nil {
+		return reloadableSettings{}, err
+	}
+	s := reloadableSettings{
+		LogLevel:     zapcore.InfoLevel,
+		PayloadLog:   payloadCfg,
+		ClientConfig: clientCfg,
+		Limits:       limits,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return reloadableSettings{}, fmt.Errorf("read config file: %w", err)
+	}
+	var f configFile
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return reloadableSettings{}, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	if f.LogLevel != "" {
+		if s.LogLevel, err = zapcore.ParseLevel(f.LogLevel); err != nil {
+			return reloadableSettings{}, fmt.Errorf("invalid log_level: %w", err)
+		}
+	}
+	if v := f.PayloadLog.SampleRate; v != nil {
+		if *v < 0 || *v > 1 {
+			return reloadableSettings{}, fmt.Errorf("payload_log.sample_rate must be between 0.0 and 1.0, got %v", *v)
+		}
+		s.PayloadLog.SampleRate = *v
+	}
+	if v := f.PayloadLog.MaxBytes; v != nil {
+		if *v < 0 {
+			return reloadableSettings{}, fmt.Errorf("payload_log.max_bytes must be >= 0, got %d", *v)
+		}
+		s.PayloadLog.MaxBytes = *v
+	}
+	if f.PayloadLog.RedactFields != nil {
+		s.PayloadLog.RedactFields = f.PayloadLog.RedactFields
+	}
+	if v := f.PayloadLog.EmitDefaults; v != nil {
+		s.PayloadLog.EmitDefaults = *v
+	}
+	if f.PayloadLog.Enums != "" {
+		s.PayloadLog.EnumFormat = f.PayloadLog.Enums
+	}
+	if f.PayloadLog.FieldNames != "" {
+		s.PayloadLog.FieldNames = f.PayloadLog.FieldNames
+	}
+	if err := s.PayloadLog.Validate(); err != nil {
+		return reloadableSettings{}, fmt.Errorf("invalid payload_log: %w", err)
+	}
+	if f.ClientConfig != nil {
+		// clientconfig.Config は JSON タグしか持たないため、JSON を経由して環境変数の値に上書きする
+		b, err := json.Marshal(f.ClientConfig)
+		if err != nil {
+			return reloadableSettings{}, fmt.Errorf("invalid client_config: %w", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&s.ClientConfig); err != nil {
+			return reloadableSettings{}, fmt.Errorf("invalid client_config: %w", err)
+		}
+	}
+	if f.Limits != nil {
+		b, err := json.Marshal(f.Limits)
+		if err != nil {
+			return reloadableSettings{}, fmt.Errorf("invalid limits: %w", err)
+		}
+		if s.Limits, err = overlayLimitProfiles(s.Limits, b); err != nil {
+			return reloadableSettings{}, fmt.Errorf("invalid limits: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// fields は設定を "項目名 -> 値" の平坦な map にする。client_config はフィールド単位に展開する
+func (s reloadableSettings) fields() map[string]any {
+	m := map[string]any{
+		"log_level":                 s.LogLevel.String(),
+		"payload_log.sample_rate":   s.PayloadLog.SampleRate,
+		"payload_log.max_bytes":     s.PayloadLog.MaxBytes,
+		"payload_log.redact_fields": strings.Join(s.PayloadLog.RedactFields, ","),
+		"payload_log.emit_defaults": s.PayloadLog.EmitDefaults,
+		"payload_log.enums":         s.PayloadLog.EnumFormat,
+		"payload_log.field_names":   s.PayloadLog.FieldNames,
+	}
+	var cc map[string]any
+	if b, err := json.Marshal(s.ClientConfig); err == nil && json.Unmarshal(b, &cc) == nil {
+		flattenFields("client_config", cc, m)
+	}
+	limitFields(s.Limits, m)
+	return m
+}
+
+func flattenFields(prefix string, v map[string]any, out map[string]any) {
+	for k, val := range v {
+		key := prefix + "." + k
+		if nested, ok := val.(map[string]any); ok {
+			flattenFields(key, nested, out)
+			continue
+		}
+		out[key] = val
+	}
+}
+
+// settingChange は 1 項目の変更前後の値
+type settingChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// diffSettings は old から next で変わった項目を項目名順に返す
+func diffSettings(old, next reloadableSettings) []settingChange {
+	before, after := old.fields(), next.fields()
+	keys := make(map[string]struct{}, len(after))
+	for k := range before {
+		keys[k] = struct{}{}
+	}
+	for k := range after {
+		keys[k] = struct{}{}
+	}
+
+	var changes []settingChange
+	for k := range keys {
+		if !reflect.DeepEqual(before[k], after[k]) {
+			changes = append(changes, settingChange{Field: k, Old: before[k], New: after[k]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// reloader は SIGHUP を受けた時に設定を読み直し、稼働中のコンポーネントへ反映する
+type reloader struct {
+	path      string
+	logger    *zap.SugaredLogger
+	level     zap.AtomicLevel
+	payload   *observability.ReloadablePayloadLogConfig
+	clientCfg *clientconfig.Server
+	limits    *appserver.ReloadableLimitProfiles
+
+	mu      sync.Mutex
+	current reloadableSettings
+}
+
+// reload は設定を読み直して差分をログに残す。読み込みや検証に失敗した場合は現在の設定を維持する
+func (r *reloader) reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := loadReloadableSettings(r.path)
+	if err != nil {
+		r.logger.Errorw("config reload failed, keeping current settings", "config_file", r.path, "err", err)
+		return
+	}
+
+	changes := diffSettings(r.current, next)
+	r.level.SetLevel(next.LogLevel)
+	r.payload.Store(next.PayloadLog)
+	r.clientCfg.SetConfig(next.ClientConfig)
+	r.limits.Store(next.Limits)
+	r.current = next
+
+	if len(changes) == 0 {
+		r.logger.Infow("config reloaded, no changes", "config_file", r.path)
+		return
+	}
+	r.logger.Infow("config reloaded", "config_file", r.path, "changed", len(changes), "changes", changes)
+	if next.PayloadLog.Enabled() {
+		r.logger.Warnw("grpc payload logging enabled",
+			"sample_rate", next.PayloadLog.SampleRate,
+			"max_bytes", next.PayloadLog.MaxBytes,
+			"redact_fields", next.PayloadLog.RedactFields,
+		)
+	}
+}
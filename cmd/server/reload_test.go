@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/clientconfig"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+// writeConfigFile は YAML の設定ファイルを書いてパスを返す。同じパスに書き直すと SIGHUP の再読み込みと同じになる
+func writeConfigFile(t *testing.T, path, body string) string {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "config.yaml")
+	}
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mustLoadSettings(t *testing.T, body string) reloadableSettings {
+	t.Helper()
+	s, err := loadReloadableSettings(writeConfigFile(t, "", body))
+	if err != nil {
+		t.Fatalf("loadReloadableSettings: %v", err)
+	}
+	return s
+}
+
+// diffSettings は変わった再読み込み可能な項目だけを項目名順に返す
+func TestDiffSettings(t *testing.T) {
+	base := mustLoadSettings(t, "")
+	tests := []struct {
+		name string
+		next string
+		want []settingChange
+	}{
+		{"unchanged", "", nil},
+		{"same values written explicitly", "log_level: info\npayload_log:\n  sample_rate: 0\n", nil},
+		{"log level", "log_level: debug\n", []settingChange{{Field: "log_level", Old: "info", New: "debug"}}},
+		{"payload and client config", "payload_log:\n  sample_rate: 0.5\nclient_config:\n  timeout_ms: 1234\n", []settingChange{
+			{Field: "client_config.timeout_ms", Old: float64(clientconfig.Default().TimeoutMs), New: float64(1234)},
+			{Field: "payload_log.sample_rate", Old: 0.0, New: 0.5},
+		}},
+		{"limits", "limits:\n  cpu:\n    max_parallelism: 3\n", []settingChange{
+			{Field: "limits.cpu.max_parallelism", Old: load.DefaultLimitProfiles().For(load.ModeCPU).MaxParallelism, New: 3},
+		}},
+	}
+	for _, tt := range tests {
+		got := diffSettings(base, mustLoadSettings(t, tt.next))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: diffSettings = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// 再起動が必要な設定は設定ファイルに書けず、環境変数を変えても差分に出ない
+func TestDiffSettings_NonReloadable(t *testing.T) {
+	for _, body := range []string{"grpc_addr: \":9000\"\n", "payload_log:\n  unknown: 1\n"} {
+		if _, err := loadReloadableSettings(writeConfigFile(t, "", body)); err == nil {
+			t.Fatalf("config file %q accepted", body)
+		}
+	}
+
+	before := mustLoadSettings(t, "")
+	t.Setenv(envAdminToken, "changed")
+	t.Setenv(envConfigFile, "/other/config.yaml")
+	if got := diffSettings(before, mustLoadSettings(t, "")); got != nil {
+		t.Fatalf("diffSettings = %+v, want none", got)
+	}
+}
+
+func newTestReloader(t *testing.T, path string) (*reloader, *observer.ObservedLogs) {
+	t.Helper()
+	settings, err := loadReloadableSettings(path)
+	if err != nil {
+		t.Fatalf("loadReloadableSettings: %v", err)
+	}
+	core, logs := observer.New(zapcore.DebugLevel)
+	return &reloader{
+		path:      path,
+		logger:    zap.New(core).Sugar(),
+		level:     zap.NewAtomicLevelAt(settings.LogLevel),
+		payload:   observability.NewReloadablePayloadLogConfig(settings.PayloadLog),
+		clientCfg: clientconfig.NewServer(settings.ClientConfig),
+		limits:    appserver.NewReloadableLimitProfiles(settings.Limits),
+		current:   settings,
+	}, logs
+}
+
+// 読み直した設定を稼働中のコンポーネントへ反映し、差分をログに残す
+func TestReloader_Applies(t *testing.T) {
+	path := writeConfigFile(t, "", "log_level: info\n")
+	r, logs := newTestReloader(t, path)
+
+	writeConfigFile(t, path, "log_level: debug\npayload_log:\n  max_bytes: 64\nclient_config:\n  timeout_ms: 1234\n")
+	r.reload()
+	if got := r.level.Level(); got != zapcore.DebugLevel {
+		t.Fatalf("level = %s, want debug", got)
+	}
+	if got := r.payload.Load().MaxBytes; got != 64 {
+		t.Fatalf("payload max_bytes = %d, want 64", got)
+	}
+	if got := r.clientCfg.Config().TimeoutMs; got != 1234 {
+		t.Fatalf("client config timeout_ms = %d, want 1234", got)
+	}
+	entries := logs.FilterMessage("config reloaded").All()
+	if len(entries) != 1 || entries[0].ContextMap()["changed"] != int64(3) {
+		t.Fatalf("reload logs = %+v, want one entry with 3 changes", logs.All())
+	}
+
+	r.reload()
+	if logs.FilterMessage("config reloaded, no changes").Len() != 1 {
+		t.Fatalf("second reload logs = %+v, want no changes", logs.All())
+	}
+}
+
+// 環境変数や設定ファイルが不正なら、エラーをログに残して直前の設定をそのまま使い続ける
+func TestReloader_InvalidKeepsCurrent(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		after string
+	}{
+		{"invalid env", map[string]string{envPayloadSampleRate: "2"}, "log_level: warn\n"},
+		{"invalid env limits", map[string]string{envLimitProfiles: "{not json"}, "log_level: warn\n"},
+		{"invalid file", nil, "log_level: loud\n"},
+		{"unreadable file", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, "", "log_level: debug\npayload_log:\n  sample_rate: 0.25\n")
+			r, logs := newTestReloader(t, path)
+			before := r.current
+
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if tt.after != "" {
+				writeConfigFile(t, path, tt.after)
+			} else if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+			r.reload()
+
+			if !reflect.DeepEqual(r.current, before) {
+				t.Fatalf("current = %+v, want the previous settings", r.current)
+			}
+			if got := r.level.Level(); got != zapcore.DebugLevel {
+				t.Fatalf("level = %s, want debug", got)
+			}
+			if got := r.payload.Load().SampleRate; got != 0.25 {
+				t.Fatalf("payload sample_rate = %v, want 0.25", got)
+			}
+			failed := logs.FilterMessage("config reload failed, keeping current settings").All()
+			if len(failed) != 1 || failed[0].Level != zapcore.ErrorLevel || failed[0].ContextMap()["err"] == nil {
+				t.Fatalf("logs = %+v, want one error entry with err", logs.All())
+			}
+			if logs.FilterMessage("config reloaded").Len() != 0 {
+				t.Fatal("reload was applied")
+			}
+		})
+	}
+}
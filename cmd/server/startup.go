@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// startupInfo は起動時に 1 回だけ出す起動バナー(ログ "server started" と /startupz)の内容。
+// サポートが「このインスタンスがどう起動されたか」を 1 つの成果物で確認できるよう、
+// 解決済みの設定、ビルド情報、有効なサブシステム、実際にバインドしたリスナーのアドレスをまとめる。
+// 設定は起動時点の値で、SIGHUP による再読み込みは反映しない(変更は "config reloaded" ログに出る)
+type startupInfo struct {
+	StartedAt  time.Time         `json:"started_at"`
+	Build      buildInfo         `json:"build"`
+	Process    processInfo       `json:"process"`
+	Listeners  map[string]string `json:"listeners"`
+	Subsystems []string          `json:"subsystems"`
+	// Config は "項目名 -> 値" の平坦な map(再読み込みできる項目は config reloaded ログの field と同じ名前)。秘密情報は含めない
+	Config map[string]any `json:"config"`
+}
+
+// buildInfo はバイナリのビルド情報。VCS の情報は go build がモジュールのリポジトリ内で埋め込んだ場合だけ入る
+type buildInfo struct {
+	Version      string `json:"version"`
+	GoVersion    string `json:"go_version"`
+	Revision     string `json:"vcs_revision,omitempty"`
+	RevisionTime string `json:"vcs_time,omitempty"`
+	Modified     bool   `json:"vcs_modified,omitempty"`
+}
+
+type processInfo struct {
+	PID        int    `json:"pid"`
+	Hostname   string `json:"hostname"`
+	Instance   string `json:"instance"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+	NumCPU     int    `json:"num_cpu"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+}
+
+// newStartupInfo は実行中のプロセスとビルドの情報に、呼び出し側で解決したリスナー/サブシステム/設定を合わせる
+func newStartupInfo(listeners map[string]string, subsystems []string, config map[string]any) startupInfo {
+	host, _ := os.Hostname()
+	return startupInfo{
+		StartedAt: time.Now().UTC(),
+		Build:     readBuildInfo(),
+		Process: processInfo{
+			PID:        os.Getpid(),
+			Hostname:   host,
+			Instance:   observability.ServiceInstanceID(),
+			OS:         runtime.GOOS,
+			Arch:       runtime.GOARCH,
+			NumCPU:     runtime.NumCPU(),
+			GOMAXPROCS: runtime.GOMAXPROCS(0),
+		},
+		Listeners:  listeners,
+		Subsystems: subsystems,
+		Config:     config,
+	}
+}
+
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: observability.ServiceVersion(), GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.time":
+			b.RevisionTime = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+// startupzHandler は起動バナーと同じ内容を JSON で返す
+func startupzHandler(info startupInfo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(info)
+	})
+}
+
+// redactURL は起動バナーやログに出す URL から、資格情報を含みうるユーザー情報とクエリを落とす
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || raw == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
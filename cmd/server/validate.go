@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/webhook"
+)
+
+// configCheck は --validate-config の 1 項目の検証結果
+type configCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// configReport は --validate-config の結果。GitOps のパイプラインで読みやすいよう JSON で出力する
+type configReport struct {
+	Valid      bool          `json:"valid"`
+	ConfigFile string        `json:"config_file,omitempty"`
+	Checks     []configCheck `json:"checks"`
+}
+
+func (r *configReport) add(name string, err error) {
+	c := configCheck{Name: name, OK: err == nil}
+	if err != nil {
+		c.Error = err.Error()
+		r.Valid = false
+	}
+	r.Checks = append(r.Checks, c)
+}
+
+// validateConfig は起動時に読み込む設定をすべて検証する。リスナーのバインドやサーバーの起動はしない。
+// 新しい設定項目を追加した場合は、起動時と同じ読み込み関数をここにも追加する
+func validateConfig(flags serverFlags) configReport {
+	r := configReport{Valid: true, ConfigFile: flags.ConfigFile}
+
+	r.add("listen_addrs", validateListenAddrs(flags.Addrs))
+
+	// log_level / payload_log / client_config / limits(環境変数と設定ファイルを重ねた値)
+	_, err := loadReloadableSettings(flags.ConfigFile)
+	r.add("reloadable_settings", err)
+
+	_, err = maxConcurrentStreamsFromEnv()
+	r.add("grpc_max_concurrent_streams", err)
+
+	_, _, err = keepaliveEnforcementFromEnv()
+	r.add("grpc_keepalive", err)
+
+	_, err = ioDirFromEnv()
+	r.add("load_io_dir", err)
+
+	_, err = noisyNeighborFromEnv()
+	r.add("noisy_neighbor", err)
+
+	_, err = streamLimitsFromEnv()
+	r.add("stream_limits", err)
+
+	_, err = watchdogGraceFromEnv()
+	r.add("load_watchdog", err)
+
+	_, err = cpuAffinityFromEnv()
+	r.add("load_cpu_affinity", err)
+
+	_, err = cpuWorkersFromEnv()
+	r.add("load_cpu_workers", err)
+
+	_, err = doWorkCoalesceFromEnv()
+	r.add("dowork_coalesce", err)
+
+	_, err = crashConfigFromEnv()
+	r.add("crash_report", err)
+
+	_, err = logTailSizeFromEnv()
+	r.add("log_tail", err)
+
+	_, err = mirrorFromEnv()
+	r.add("dowork_mirror", err)
+
+	_, err = observability.RemoteWriteConfigFromEnv()
+	r.add("remote_write", err)
+
+	_, err = observability.StatsdConfigFromEnv()
+	r.add("statsd", err)
+
+	_, err = webhook.ConfigFromEnv()
+	r.add("webhook", err)
+
+	r.add("logger", observability.ValidateLoggerEnv())
+
+	r.add("tracer", observability.ValidateTracerEnv())
+
+	return r
+}
+
+// validateListenAddrs はアドレスの形式だけを確認する。ポートが使用中かどうかは起動してみないと分からない
+func validateListenAddrs(addrs listenAddrs) error {
+	check := func(name, addr string) error {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", name, addr, err)
+		}
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return fmt.Errorf("%s %q: %w", name, addr, err)
+		}
+		return nil
+	}
+	if err := check("grpc-addr", addrs.GRPC); err != nil {
+		return err
+	}
+	if err := check("metrics-addr", addrs.Metrics); err != nil {
+		return err
+	}
+	if addrs.Admin != "off" {
+		return check("admin-addr", addrs.Admin)
+	}
+	return nil
+}
+
+// writeReport は結果を JSON で w に書き出す
+func writeReport(w io.Writer, r configReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
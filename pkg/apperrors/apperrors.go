@@ -0,0 +1,170 @@
+// Package apperrors はサーバーとクライアントで共有するエラーの分類を定義する。
+//
+// エラーを validation / limit / injected / canceled / internal のカテゴリに分け、
+// gRPC ステータスコードとログのフィールドへの対応を 1 か所にまとめる。
+// サーバーは *Error を返すだけで適切なコードと ErrorInfo 付きのステータスになり、
+// クライアントは CategoryOf でステータスから同じカテゴリを取り出せる
+package apperrors
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Category はエラーの分類
+type Category string
+
+const (
+	// Validation はリクエストの値が不正(モード不明、必須項目なし、範囲外など)
+	Validation Category = "validation"
+	// Limit はリクエスト自体は正しいが、サーバーの安全上限を超えている
+	Limit Category = "limit"
+	// Injected は error_rate や依存先の遅延など、障害訓練のために意図的に起こしたエラー
+	Injected Category = "injected"
+	// Canceled は呼び出し元のキャンセル、タイムアウト、kill-switch による中断
+	Canceled Category = "canceled"
+	// Internal はそれ以外の想定外のエラー
+	Internal Category = "internal"
+)
+
+// Domain は ErrorInfo の domain。カテゴリを gRPC ステータス経由でクライアントに渡すために使う
+const Domain = "cno-app"
+
+// Error はカテゴリ付きのエラー
+type Error struct {
+	Category Category
+	// Reason は細分類(例: alloc_too_large)。空ならカテゴリ名を使う
+	Reason string
+	// Code は gRPC ステータスコードの上書き。codes.OK ならカテゴリの既定のコード
+	Code codes.Code
+	Err  error
+}
+
+// New は err にカテゴリを付ける。err が nil なら nil を返す
+func New(category Category, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Category: category, Err: err}
+}
+
+// WithReason は err にカテゴリと細分類を付ける。err が nil なら nil を返す
+func WithReason(category Category, reason string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Category: category, Reason: reason, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// GRPCCode はこのエラーを返す時の gRPC ステータスコード
+func (e *Error) GRPCCode() codes.Code {
+	if e.Code != codes.OK {
+		return e.Code
+	}
+	if e.Category == Canceled && errors.Is(e.Err, context.DeadlineExceeded) {
+		return codes.DeadlineExceeded
+	}
+	return categoryCodes[e.Category]
+}
+
+func (e *Error) reason() string {
+	if e.Reason != "" {
+		return e.Reason
+	}
+	return string(e.Category)
+}
+
+// GRPCStatus は status.FromError / status.Code から呼ばれ、カテゴリを ErrorInfo に載せたステータスを返す
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.GRPCCode(), e.Error())
+	if withInfo, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   e.reason(),
+		Domain:   Domain,
+		Metadata: map[string]string{"category": string(e.Category)},
+	}); err == nil {
+		return withInfo
+	}
+	return st
+}
+
+// categoryCodes はカテゴリごとの既定の gRPC ステータスコード。
+// injected は Internal にし、UNAVAILABLE を対象にしたクライアントのリトライで隠れないようにしている
+var categoryCodes = map[Category]codes.Code{
+	Validation: codes.InvalidArgument,
+	Limit:      codes.ResourceExhausted,
+	Injected:   codes.Internal,
+	Canceled:   codes.Canceled,
+	Internal:   codes.Internal,
+}
+
+// CategoryOf は err のカテゴリを返す。err が nil なら空文字列。
+// *Error、サーバーが返した ErrorInfo 付きのステータス、context のエラー、ステータスコードの順に判定する
+func CategoryOf(err error) Category {
+	category, _ := classify(err)
+	return category
+}
+
+// ReasonOf は err の細分類を返す。分からなければカテゴリ名
+func ReasonOf(err error) string {
+	_, reason := classify(err)
+	return reason
+}
+
+func classify(err error) (Category, string) {
+	if err == nil {
+		return "", ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Category, e.reason()
+	}
+	if st, ok := status.FromError(err); ok {
+		for _, d := range st.Details() {
+			if info, ok := d.(*errdetails.ErrorInfo); ok && info.GetDomain() == Domain {
+				if c := Category(info.GetMetadata()["category"]); c != "" {
+					return c, info.GetReason()
+				}
+			}
+		}
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return Canceled, string(Canceled)
+	}
+	c := categoryFromCode(status.Code(err))
+	return c, string(c)
+}
+
+// categoryFromCode は ErrorInfo を持たないステータス(古いサーバーやプロキシが返したもの)のカテゴリを推定する
+func categoryFromCode(code codes.Code) Category {
+	switch code {
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return Validation
+	case codes.ResourceExhausted:
+		return Limit
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted:
+		return Canceled
+	default:
+		return Internal
+	}
+}
+
+// LogFields は zap の SugaredLogger に渡すエラーのフィールド(error_category / error_reason)を返す。err が nil なら nil
+func LogFields(err error) []any {
+	if err == nil {
+		return nil
+	}
+	category, reason := classify(err)
+	return []any{"error_category", string(category), "error_reason", reason}
+}
@@ -0,0 +1,98 @@
+package apperrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestError_GRPCCode(t *testing.T) {
+	base := errors.New("boom")
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"validation", New(Validation, base), codes.InvalidArgument},
+		{"limit", New(Limit, base), codes.ResourceExhausted},
+		{"injected", New(Injected, base), codes.Internal},
+		{"canceled", New(Canceled, context.Canceled), codes.Canceled},
+		{"canceled deadline", New(Canceled, context.DeadlineExceeded), codes.DeadlineExceeded},
+		{"internal", New(Internal, base), codes.Internal},
+		{"override", &Error{Category: Canceled, Code: codes.Aborted, Err: base}, codes.Aborted},
+		{"wrapped", fmt.Errorf("invalid config: %w", New(Limit, base)), codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(tt.err); got != tt.want {
+				t.Fatalf("code = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestError_KeepsMessageAndCause(t *testing.T) {
+	base := errors.New("alloc_mb too large")
+	err := WithReason(Limit, "alloc_too_large", base)
+
+	if err.Error() != base.Error() {
+		t.Fatalf("Error() = %q, want %q", err.Error(), base.Error())
+	}
+	if !errors.Is(err, base) {
+		t.Fatal("errors.Is(err, base) = false")
+	}
+	if New(Validation, nil) != nil || WithReason(Limit, "x", nil) != nil {
+		t.Fatal("nil error must stay nil")
+	}
+}
+
+// サーバーが返したステータスをクライアント側で受け取った時と同じく、
+// *Error を失った状態でも ErrorInfo からカテゴリと細分類を取り出せること
+func TestCategoryOf_FromStatus(t *testing.T) {
+	sent := WithReason(Limit, "alloc_too_large", errors.New("too large"))
+	st, _ := status.FromError(sent)
+	received := status.ErrorProto(st.Proto())
+
+	if got := CategoryOf(received); got != Limit {
+		t.Fatalf("category = %q, want %q", got, Limit)
+	}
+	if got := ReasonOf(received); got != "alloc_too_large" {
+		t.Fatalf("reason = %q, want alloc_too_large", got)
+	}
+}
+
+func TestCategoryOf_Fallback(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Category
+	}{
+		{nil, ""},
+		{context.Canceled, Canceled},
+		{fmt.Errorf("recv: %w", context.DeadlineExceeded), Canceled},
+		{status.Error(codes.InvalidArgument, "bad"), Validation},
+		{status.Error(codes.ResourceExhausted, "full"), Limit},
+		{status.Error(codes.Aborted, "killed"), Canceled},
+		{status.Error(codes.Unavailable, "down"), Internal},
+		{errors.New("plain"), Internal},
+	}
+	for _, tt := range tests {
+		if got := CategoryOf(tt.err); got != tt.want {
+			t.Errorf("CategoryOf(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	if LogFields(nil) != nil {
+		t.Fatal("LogFields(nil) must be nil")
+	}
+	got := LogFields(New(Injected, errors.New("injected")))
+	want := []any{"error_category", "injected", "error_reason", "injected"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("LogFields = %v, want %v", got, want)
+	}
+}
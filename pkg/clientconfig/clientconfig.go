@@ -0,0 +1,239 @@
+// Package clientconfig はサーバーが推奨するクライアント設定(タイムアウト/リトライ/メッセージサイズ)を
+// gRPC で配布するための ClientConfigService を提供する。
+//
+// proto リポジトリに RPC を追加するまでの間、リクエストは google.protobuf.Empty、
+// レスポンスは google.protobuf.Struct で受け渡す手書きの ServiceDesc として実装している
+package clientconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// ServiceName は ClientConfigService のサービス名
+	ServiceName = "cno.app.v1.ClientConfigService"
+	// GetClientConfigFullMethodName は GetClientConfig のフルメソッド名
+	GetClientConfigFullMethodName = "/" + ServiceName + "/GetClientConfig"
+
+	// burnerServiceName はリトライ/タイムアウトを適用する対象サービス
+	burnerServiceName = "observability.grpcburner.v1.Burner"
+
+	envClientConfig = "CNO_APP_CLIENT_CONFIG"
+)
+
+// RetryPolicy は gRPC service config の retryPolicy に対応する
+type RetryPolicy struct {
+	MaxAttempts          int      `json:"max_attempts"`
+	InitialBackoffMs     int64    `json:"initial_backoff_ms"`
+	MaxBackoffMs         int64    `json:"max_backoff_ms"`
+	BackoffMultiplier    float64  `json:"backoff_multiplier"`
+	RetryableStatusCodes []string `json:"retryable_status_codes"`
+}
+
+// Config はサーバーがクライアントに推奨する設定
+type Config struct {
+	TimeoutMs      int64       `json:"timeout_ms"`
+	MaxRecvMsgSize int         `json:"max_recv_msg_size"`
+	MaxSendMsgSize int         `json:"max_send_msg_size"`
+	RetryPolicy    RetryPolicy `json:"retry_policy"`
+	// LoadBalancingPolicy は gRPC の LB ポリシー名(例: round_robin)。
+	// 接続先が複数アドレスに解決される場合にクライアント側で設定する。空なら gRPC 既定の pick_first
+	LoadBalancingPolicy string `json:"load_balancing_policy,omitempty"`
+}
+
+// Default は既定の推奨設定を返す。
+// タイムアウトは load の MaxDuration(60s)に余裕を持たせた値にしている
+func Default() Config {
+	return Config{
+		TimeoutMs:      90_000,
+		MaxRecvMsgSize: 4 * 1024 * 1024,
+		MaxSendMsgSize: 4 * 1024 * 1024,
+		RetryPolicy: RetryPolicy{
+			MaxAttempts:          3,
+			InitialBackoffMs:     100,
+			MaxBackoffMs:         1000,
+			BackoffMultiplier:    2,
+			RetryableStatusCodes: []string{"UNAVAILABLE"},
+		},
+	}
+}
+
+// FromEnv は CNO_APP_CLIENT_CONFIG(JSON)で Default を上書きした設定を返す
+func FromEnv() (Config, error) {
+	cfg := Default()
+	if v := os.Getenv(envClientConfig); v != "" {
+		if err := json.Unmarshal([]byte(v), &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid %s: %w", envClientConfig, err)
+		}
+	}
+	return cfg, nil
+}
+
+// ServiceConfigJSON は Config を gRPC の service config(JSON)に変換する。
+// grpc.WithDefaultServiceConfig に渡して Burner サービスの全メソッドに適用する
+func (c Config) ServiceConfigJSON() (string, error) {
+	mc := map[string]any{
+		"name": []map[string]string{{"service": burnerServiceName}},
+	}
+	if c.TimeoutMs > 0 {
+		mc["timeout"] = durationString(c.TimeoutMs)
+	}
+	if c.RetryPolicy.MaxAttempts > 1 {
+		mc["retryPolicy"] = map[string]any{
+			"maxAttempts":          c.RetryPolicy.MaxAttempts,
+			"initialBackoff":       durationString(c.RetryPolicy.InitialBackoffMs),
+			"maxBackoff":           durationString(c.RetryPolicy.MaxBackoffMs),
+			"backoffMultiplier":    c.RetryPolicy.BackoffMultiplier,
+			"retryableStatusCodes": c.RetryPolicy.RetryableStatusCodes,
+		}
+	}
+	sc := map[string]any{"methodConfig": []any{mc}}
+	if c.LoadBalancingPolicy != "" {
+		sc["loadBalancingConfig"] = []any{map[string]any{c.LoadBalancingPolicy: map[string]any{}}}
+	}
+	b, err := json.Marshal(sc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// DialOptions は Config をクライアントの DialOption に変換する
+func (c Config) DialOptions() ([]grpc.DialOption, error) {
+	sc, err := c.ServiceConfigJSON()
+	if err != nil {
+		return nil, err
+	}
+	opts := []grpc.DialOption{grpc.WithDefaultServiceConfig(sc)}
+
+	var callOpts []grpc.CallOption
+	if c.MaxRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(c.MaxRecvMsgSize))
+	}
+	if c.MaxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(c.MaxSendMsgSize))
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+	return opts, nil
+}
+
+// service config の duration は "0.100s" のような秒単位の文字列
+func durationString(ms int64) string {
+	return fmt.Sprintf("%.3fs", (time.Duration(ms) * time.Millisecond).Seconds())
+}
+
+func (c Config) toStruct() (*structpb.Struct, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+func fromStruct(s *structpb.Struct) (Config, error) {
+	var c Config
+	b, err := s.MarshalJSON()
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// ClientConfigServer は ClientConfigService のサーバー実装が満たすインターフェース
+type ClientConfigServer interface {
+	GetClientConfig(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+}
+
+// Server は Config を返す ClientConfigServer。Config は SetConfig で実行中に差し替えられる
+type Server struct {
+	cfg atomic.Pointer[Config]
+}
+
+// NewServer は cfg を返す ClientConfigService の実装を返す
+func NewServer(cfg Config) *Server {
+	s := &Server{}
+	s.SetConfig(cfg)
+	return s
+}
+
+// Config は現在配布している設定を返す
+func (s *Server) Config() Config {
+	return *s.cfg.Load()
+}
+
+// SetConfig は配布する設定を差し替える。既に接続済みのクライアントには再取得するまで反映されない
+func (s *Server) SetConfig(cfg Config) {
+	s.cfg.Store(&cfg)
+}
+
+// GetClientConfig は推奨クライアント設定を返す
+func (s *Server) GetClientConfig(_ context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	return s.Config().toStruct()
+}
+
+// Register は ClientConfigService を gRPC サーバーに登録する
+func Register(s grpc.ServiceRegistrar, srv ClientConfigServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ClientConfigServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetClientConfig",
+			Handler:    getClientConfigHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func getClientConfigHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientConfigServer).GetClientConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GetClientConfigFullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientConfigServer).GetClientConfig(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Fetch はサーバーから推奨クライアント設定を取得する
+func Fetch(ctx context.Context, cc grpc.ClientConnInterface) (Config, error) {
+	out := new(structpb.Struct)
+	if err := cc.Invoke(ctx, GetClientConfigFullMethodName, &emptypb.Empty{}, out); err != nil {
+		return Config{}, err
+	}
+	return fromStruct(out)
+}
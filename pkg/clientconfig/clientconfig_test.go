@@ -0,0 +1,79 @@
+package clientconfig
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// サーバーに登録した設定をクライアントが Fetch で取得し、DialOption として適用できることを確認
+func TestFetch_RoundTripAndApply(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+
+	want := Default()
+	want.TimeoutMs = 1234
+	want.RetryPolicy.MaxAttempts = 4
+	Register(srv, NewServer(want))
+
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	dialer := grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	})
+	conn, err := grpc.NewClient("passthrough:///bufnet", dialer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	got, err := Fetch(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if got.TimeoutMs != want.TimeoutMs || got.RetryPolicy.MaxAttempts != want.RetryPolicy.MaxAttempts {
+		t.Fatalf("Fetch returned %+v, want %+v", got, want)
+	}
+
+	// 不正な service config は NewClient でエラーになるため、適用できることの確認を兼ねる
+	opts, err := got.DialOptions()
+	if err != nil {
+		t.Fatalf("DialOptions: %v", err)
+	}
+	opts = append(opts, dialer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	applied, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatalf("NewClient with fetched config: %v", err)
+	}
+	_ = applied.Close()
+}
+
+// SetConfig で差し替えた設定が次の GetClientConfig から返ることを確認
+func TestServer_SetConfig(t *testing.T) {
+	s := NewServer(Default())
+
+	next := Default()
+	next.TimeoutMs = 5000
+	s.SetConfig(next)
+
+	st, err := s.GetClientConfig(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("GetClientConfig: %v", err)
+	}
+	got, err := fromStruct(st)
+	if err != nil {
+		t.Fatalf("fromStruct: %v", err)
+	}
+	if got.TimeoutMs != next.TimeoutMs {
+		t.Fatalf("TimeoutMs = %d, want %d", got.TimeoutMs, next.TimeoutMs)
+	}
+}
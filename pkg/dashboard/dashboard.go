@@ -0,0 +1,297 @@
+// Package dashboard は pkg/observability のメトリクス定義から Grafana ダッシュボード JSON を生成する
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+const (
+	panelWidth  = 12
+	panelHeight = 8
+	rateWindow  = "$__rate_interval"
+)
+
+// Options はダッシュボード生成時の設定
+type Options struct {
+	Title string
+	UID   string
+	// RuntimeNamespace は go_* / process_* に付けたプレフィックス(CNO_APP_METRICS_NAMESPACE と同じ値)
+	RuntimeNamespace string
+}
+
+type dashboard struct {
+	Title         string     `json:"title"`
+	UID           string     `json:"uid"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Editable      bool       `json:"editable"`
+	Tags          []string   `json:"tags"`
+	Time          timeRange  `json:"time"`
+	Refresh       string     `json:"refresh"`
+	Templating    templating `json:"templating"`
+	Panels        []panel    `json:"panels"`
+}
+
+type timeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type variable struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+type datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type target struct {
+	Expr         string     `json:"expr"`
+	LegendFormat string     `json:"legendFormat"`
+	RefID        string     `json:"refId"`
+	Datasource   datasource `json:"datasource"`
+}
+
+type fieldConfig struct {
+	Defaults struct {
+		Unit string `json:"unit,omitempty"`
+	} `json:"defaults"`
+}
+
+type panel struct {
+	ID          int          `json:"id"`
+	Type        string       `json:"type"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	GridPos     gridPos      `json:"gridPos"`
+	Datasource  *datasource  `json:"datasource,omitempty"`
+	Targets     []target     `json:"targets,omitempty"`
+	FieldConfig *fieldConfig `json:"fieldConfig,omitempty"`
+	Collapsed   *bool        `json:"collapsed,omitempty"`
+	Panels      []panel      `json:"panels,omitempty"`
+}
+
+var promDS = datasource{Type: "prometheus", UID: "${datasource}"}
+
+// builder はパネルを 2 列で左上から順に配置する
+type builder struct {
+	panels []panel
+	nextID int
+	y      int
+	col    int
+}
+
+func (b *builder) row(title string) {
+	if b.col != 0 {
+		b.y += panelHeight
+		b.col = 0
+	}
+	b.nextID++
+	collapsed := false
+	b.panels = append(b.panels, panel{
+		ID:        b.nextID,
+		Type:      "row",
+		Title:     title,
+		GridPos:   gridPos{H: 1, W: 2 * panelWidth, X: 0, Y: b.y},
+		Collapsed: &collapsed,
+	})
+	b.y++
+}
+
+func (b *builder) timeseries(title, description, unit string, exprs ...[2]string) {
+	b.nextID++
+	ds := promDS
+	p := panel{
+		ID:          b.nextID,
+		Type:        "timeseries",
+		Title:       title,
+		Description: description,
+		GridPos:     gridPos{H: panelHeight, W: panelWidth, X: b.col * panelWidth, Y: b.y},
+		Datasource:  &ds,
+	}
+	if unit != "" {
+		p.FieldConfig = &fieldConfig{}
+		p.FieldConfig.Defaults.Unit = unit
+	}
+	for i, e := range exprs {
+		p.Targets = append(p.Targets, target{
+			Expr:         e[0],
+			LegendFormat: e[1],
+			RefID:        string(rune('A' + i)),
+			Datasource:   promDS,
+		})
+	}
+	b.panels = append(b.panels, p)
+
+	b.col++
+	if b.col == 2 {
+		b.col = 0
+		b.y += panelHeight
+	}
+}
+
+// Generate はメトリクスカタログから RED/USE ダッシュボードを生成する。
+//
+//   - RED: code ラベルを持つ *_requests_total ごとに Rate / Errors、対応する *_latency_seconds から Duration
+//   - Saturation: 上記以外の gauge / histogram(in-flight、コネクションあたりのストリーム数など)
+//   - USE: Go ランタイム/プロセスのメトリクス
+func Generate(opts Options) ([]byte, error) {
+	if opts.Title == "" {
+		opts.Title = "cno-app RED/USE"
+	}
+	if opts.UID == "" {
+		opts.UID = "cno-app-red-use"
+	}
+
+	metrics := observability.Catalog()
+	used := map[string]bool{}
+	b := &builder{}
+
+	b.row("RED")
+	for _, m := range metrics {
+		if m.Type != observability.MetricCounter || !strings.HasSuffix(m.Name, "_requests_total") || !hasLabel(m, "code") {
+			continue
+		}
+		used[m.Name] = true
+		by := strings.Join(without(m.Labels, "code"), ", ")
+		legend := legendFor(without(m.Labels, "code"))
+
+		b.timeseries("Rate: "+m.Name, m.Help, "reqps", [2]string{
+			fmt.Sprintf("sum by (%s) (rate(%s[%s]))", by, m.Name, rateWindow), legend,
+		})
+		b.timeseries("Errors: "+m.Name, m.Help, "percentunit", [2]string{
+			fmt.Sprintf(`sum by (%s) (rate(%s{code!~"OK|[23].."}[%s])) / sum by (%s) (rate(%s[%s]))`,
+				by, m.Name, rateWindow, by, m.Name, rateWindow), legend,
+		})
+
+		latency := strings.TrimSuffix(m.Name, "_requests_total") + "_request_latency_seconds"
+		if h, ok := observability.LookupMetric(latency); ok && h.Type == observability.MetricHistogram {
+			used[h.Name] = true
+			b.timeseries("Duration: "+h.Name, h.Help, "s", quantiles(h)...)
+		}
+	}
+
+	b.row("Saturation")
+	for _, m := range metrics {
+		if used[m.Name] {
+			continue
+		}
+		switch m.Type {
+		case observability.MetricGauge:
+			expr := m.Name
+			if len(m.Labels) > 0 {
+				expr = fmt.Sprintf("sum by (%s) (%s)", strings.Join(m.Labels, ", "), m.Name)
+			}
+			b.timeseries(m.Name, m.Help, "", [2]string{expr, legendFor(m.Labels)})
+		case observability.MetricHistogram:
+			b.timeseries(m.Name, m.Help, "", quantiles(m)...)
+		case observability.MetricCounter:
+			b.timeseries(m.Name, m.Help, "", [2]string{
+				fmt.Sprintf("sum by (%s) (rate(%s[%s]))", strings.Join(m.Labels, ", "), m.Name, rateWindow), legendFor(m.Labels),
+			})
+		}
+	}
+
+	ns := ""
+	if opts.RuntimeNamespace != "" {
+		ns = opts.RuntimeNamespace + "_"
+	}
+	b.row("USE (process / Go runtime)")
+	b.timeseries("CPU usage", "process_cpu_seconds_total", "percentunit", [2]string{
+		fmt.Sprintf("sum by (service_instance) (rate(%sprocess_cpu_seconds_total[%s]))", ns, rateWindow), "{{service_instance}}",
+	})
+	b.timeseries("Resident memory", "process_resident_memory_bytes", "bytes", [2]string{
+		fmt.Sprintf("sum by (service_instance) (%sprocess_resident_memory_bytes)", ns), "{{service_instance}}",
+	})
+	b.timeseries("Heap in use", "go_memstats_heap_inuse_bytes", "bytes", [2]string{
+		fmt.Sprintf("sum by (service_instance) (%sgo_memstats_heap_inuse_bytes)", ns), "{{service_instance}}",
+	})
+	b.timeseries("Goroutines / open fds", "go_goroutines, process_open_fds", "", [2]string{
+		fmt.Sprintf("sum by (service_instance) (%sgo_goroutines)", ns), "goroutines {{service_instance}}",
+	}, [2]string{
+		fmt.Sprintf("sum by (service_instance) (%sprocess_open_fds)", ns), "fds {{service_instance}}",
+	})
+
+	d := dashboard{
+		Title:         opts.Title,
+		UID:           opts.UID,
+		SchemaVersion: 39,
+		Editable:      true,
+		Tags:          []string{"cno-app", "generated"},
+		Time:          timeRange{From: "now-1h", To: "now"},
+		Refresh:       "30s",
+		Templating: templating{List: []variable{{
+			Name:  "datasource",
+			Label: "Prometheus",
+			Type:  "datasource",
+			Query: "prometheus",
+		}}},
+		Panels: b.panels,
+	}
+	out, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func quantiles(h observability.MetricInfo) [][2]string {
+	by := strings.Join(append([]string{"le"}, h.Labels...), ", ")
+	legend := legendFor(h.Labels)
+	var out [][2]string
+	for _, q := range []string{"0.5", "0.95", "0.99"} {
+		l := "p" + strings.TrimPrefix(q, "0.")
+		if legend != "" {
+			l += " " + legend
+		}
+		out = append(out, [2]string{
+			fmt.Sprintf("histogram_quantile(%s, sum by (%s) (rate(%s_bucket[%s])))", q, by, h.Name, rateWindow), l,
+		})
+	}
+	return out
+}
+
+func hasLabel(m observability.MetricInfo, label string) bool {
+	for _, l := range m.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func without(labels []string, drop string) []string {
+	out := make([]string, 0, len(labels))
+	for _, l := range labels {
+		if l != drop {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func legendFor(labels []string) string {
+	parts := make([]string, 0, len(labels))
+	for _, l := range labels {
+		parts = append(parts, "{{"+l+"}}")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,37 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// 生成したダッシュボードが pkg/observability の全メトリクスを参照していることを確認
+// (メトリクスを追加/改名した時にダッシュボードが追従しているかのガード)
+func TestGenerate_CoversAllMetrics(t *testing.T) {
+	b, err := Generate(Options{})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	var d dashboard
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatalf("generated dashboard is not valid JSON: %v", err)
+	}
+
+	var exprs strings.Builder
+	for _, p := range d.Panels {
+		for _, tg := range p.Targets {
+			exprs.WriteString(tg.Expr)
+			exprs.WriteString("\n")
+		}
+	}
+
+	for _, m := range observability.Catalog() {
+		if !strings.Contains(exprs.String(), m.Name) {
+			t.Errorf("dashboard does not reference metric %s", m.Name)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// Package debugecho は受け取った metadata / peer / trace context をそのまま返す DebugEchoService を提供する。
+// proxy やサービスメッシュを経由した時に、ヘッダーや traceparent がどこで落ちているかを調べるために使う。
+//
+// clientconfig と同様に、proto リポジトリに RPC を追加するまでの間はリクエストを google.protobuf.Empty、
+// レスポンスを google.protobuf.Struct で受け渡す手書きの ServiceDesc として実装している
+package debugecho
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// ServiceName は DebugEchoService のサービス名
+	ServiceName = "cno.app.v1.DebugEchoService"
+	// EchoFullMethodName は Echo のフルメソッド名
+	EchoFullMethodName = "/" + ServiceName + "/Echo"
+
+	redactedValue = "[REDACTED]"
+)
+
+// redactedKeys は値を返さずに存在だけを示す metadata のキー。
+// 呼び出し元が送ったものに加え、途中の proxy が付けた認証情報も含まれうるため
+var redactedKeys = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+}
+
+// Peer は接続元の情報
+type Peer struct {
+	Addr      string `json:"addr,omitempty"`
+	LocalAddr string `json:"local_addr,omitempty"`
+	// AuthType は "tls" など。サーバーが平文で待ち受けている場合は空。TLS の場合は以下も埋める
+	AuthType    string   `json:"auth_type,omitempty"`
+	TLSVersion  string   `json:"tls_version,omitempty"`
+	TLSServer   string   `json:"tls_server_name,omitempty"`
+	ClientCerts []string `json:"client_cert_subjects,omitempty"`
+}
+
+// TraceContext はトレースの伝播状態
+type TraceContext struct {
+	// Incoming は metadata から取り出した呼び出し元の span。Valid が false なら途中で traceparent が落ちている
+	Incoming SpanInfo `json:"incoming"`
+	// Server はこの RPC を処理しているサーバー側の span
+	Server SpanInfo `json:"server"`
+	// Baggage は W3C baggage のメンバー
+	Baggage map[string]string `json:"baggage,omitempty"`
+}
+
+// SpanInfo は 1 つの span context
+type SpanInfo struct {
+	Valid      bool   `json:"valid"`
+	TraceID    string `json:"trace_id,omitempty"`
+	SpanID     string `json:"span_id,omitempty"`
+	Sampled    bool   `json:"sampled"`
+	Remote     bool   `json:"remote"`
+	TraceState string `json:"trace_state,omitempty"`
+}
+
+// Echo は DebugEcho の応答
+type Echo struct {
+	Method   string              `json:"method"`
+	Metadata map[string][]string `json:"metadata"`
+	Peer     Peer                `json:"peer"`
+	Trace    TraceContext        `json:"trace"`
+	// DeadlineMs はサーバーが受け取った時点の残りタイムアウト。deadline が無ければ 0
+	DeadlineMs int64 `json:"deadline_remaining_ms,omitempty"`
+}
+
+// FromContext は受信した RPC の ctx から Echo を組み立てる
+func FromContext(ctx context.Context) Echo {
+	e := Echo{Metadata: map[string][]string{}}
+	e.Method, _ = grpc.Method(ctx)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, vals := range md {
+		if redactedKeys[k] {
+			vals = []string{redactedValue}
+		}
+		e.Metadata[k] = append([]string(nil), vals...)
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		e.Peer = peerInfo(p)
+	}
+
+	// 呼び出し元の span はサーバー側の span の親として上書きされているため、metadata から改めて取り出す
+	incoming := otel.GetTextMapPropagator().Extract(context.Background(), metadataCarrier(md))
+	e.Trace.Incoming = spanInfo(trace.SpanContextFromContext(incoming))
+	e.Trace.Server = spanInfo(trace.SpanContextFromContext(ctx))
+	if members := baggage.FromContext(incoming).Members(); len(members) > 0 {
+		e.Trace.Baggage = make(map[string]string, len(members))
+		for _, m := range members {
+			e.Trace.Baggage[m.Key()] = m.Value()
+		}
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		e.DeadlineMs = time.Until(dl).Milliseconds()
+	}
+	return e
+}
+
+func peerInfo(p *peer.Peer) Peer {
+	out := Peer{}
+	if p.Addr != nil {
+		out.Addr = p.Addr.String()
+	}
+	if p.LocalAddr != nil {
+		out.LocalAddr = p.LocalAddr.String()
+	}
+	if p.AuthInfo == nil {
+		return out
+	}
+	out.AuthType = p.AuthInfo.AuthType()
+	if ti, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		out.TLSVersion = tls.VersionName(ti.State.Version)
+		out.TLSServer = ti.State.ServerName
+		for _, c := range ti.State.PeerCertificates {
+			out.ClientCerts = append(out.ClientCerts, c.Subject.String())
+		}
+	}
+	return out
+}
+
+func spanInfo(sc trace.SpanContext) SpanInfo {
+	if !sc.IsValid() {
+		return SpanInfo{}
+	}
+	return SpanInfo{
+		Valid:      true,
+		TraceID:    sc.TraceID().String(),
+		SpanID:     sc.SpanID().String(),
+		Sampled:    sc.IsSampled(),
+		Remote:     sc.IsRemote(),
+		TraceState: sc.TraceState().String(),
+	}
+}
+
+// metadataCarrier は incoming metadata を propagation.TextMapCarrier として読む
+type metadataCarrier metadata.MD
+
+var _ propagation.TextMapCarrier = metadataCarrier(nil)
+
+func (c metadataCarrier) Get(key string) string {
+	if vals := metadata.MD(c).Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (e Echo) toStruct() (*structpb.Struct, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+func fromStruct(s *structpb.Struct) (Echo, error) {
+	var e Echo
+	b, err := s.MarshalJSON()
+	if err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// DebugEchoServer は DebugEchoService のサーバー実装が満たすインターフェース
+type DebugEchoServer interface {
+	Echo(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+}
+
+// Server は受け取った RPC の情報をそのまま返す DebugEchoServer
+type Server struct{}
+
+// NewServer は DebugEchoService の実装を返す
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Echo は受信した metadata / peer / trace context を返す
+func (s *Server) Echo(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	return FromContext(ctx).toStruct()
+}
+
+// Register は DebugEchoService を gRPC サーバーに登録する
+func Register(s grpc.ServiceRegistrar, srv DebugEchoServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*DebugEchoServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Echo",
+			Handler:    echoHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func echoHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DebugEchoServer).Echo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoFullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DebugEchoServer).Echo(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Call は DebugEcho を呼び出し、サーバーが受け取った内容を返す
+func Call(ctx context.Context, cc grpc.ClientConnInterface, opts ...grpc.CallOption) (Echo, error) {
+	out := new(structpb.Struct)
+	if err := cc.Invoke(ctx, EchoFullMethodName, &emptypb.Empty{}, out, opts...); err != nil {
+		return Echo{}, err
+	}
+	return fromStruct(out)
+}
@@ -0,0 +1,74 @@
+package debugecho
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// 送った metadata と traceparent がそのまま返り、認証ヘッダーの値は伏せられることを確認
+func TestCall_EchoesMetadataAndTraceContext(t *testing.T) {
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	defer otel.SetTextMapPropagator(prev)
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	Register(srv, NewServer())
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"traceparent", "00-"+traceID+"-00f067aa0ba902b7-01",
+		"baggage", "tenant=a",
+		"x-mesh-route", "canary",
+		"authorization", "Bearer secret",
+	)
+	got, err := Call(ctx, conn)
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+
+	if got.Method != EchoFullMethodName {
+		t.Errorf("Method = %q, want %q", got.Method, EchoFullMethodName)
+	}
+	if v := got.Metadata["x-mesh-route"]; len(v) != 1 || v[0] != "canary" {
+		t.Errorf("x-mesh-route = %v, want [canary]", v)
+	}
+	if v := got.Metadata["authorization"]; len(v) != 1 || v[0] != redactedValue {
+		t.Errorf("authorization = %v, want [%s]", v, redactedValue)
+	}
+	in := got.Trace.Incoming
+	if !in.Valid || in.TraceID != traceID || !in.Sampled || !in.Remote {
+		t.Errorf("Trace.Incoming = %+v, want sampled remote span of trace %s", in, traceID)
+	}
+	if got.Trace.Baggage["tenant"] != "a" {
+		t.Errorf("Trace.Baggage = %v, want tenant=a", got.Trace.Baggage)
+	}
+	if got.Peer.Addr == "" {
+		t.Errorf("Peer = %+v, want peer address", got.Peer)
+	}
+}
@@ -0,0 +1,426 @@
+// Package kube は Kubernetes API から Service の背後にある Pod のアドレスを引くための最小限のクライアント。
+//
+// client-go を依存に入れるほどの機能は不要なため、in-cluster の ServiceAccount か kubeconfig の
+// 認証情報を使って EndpointSlice を REST で取得するだけに留めている
+package kube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	envServiceHost = "KUBERNETES_SERVICE_HOST"
+	envServicePort = "KUBERNETES_SERVICE_PORT"
+	envKubeconfig  = "KUBECONFIG"
+
+	defaultNamespace = "default"
+	requestTimeout   = 10 * time.Second
+)
+
+// Config は API サーバーへの接続情報
+type Config struct {
+	Host      string
+	Token     string
+	Namespace string
+	TLS       *tls.Config
+}
+
+// LoadConfig は接続情報を解決する。
+// kubeconfig が指定されていればそれを使い、未指定なら in-cluster → $KUBECONFIG → ~/.kube/config の順に試す
+func LoadConfig(kubeconfig string) (Config, error) {
+	if kubeconfig != "" {
+		return KubeconfigConfig(kubeconfig)
+	}
+	if os.Getenv(envServiceHost) != "" {
+		return InClusterConfig()
+	}
+	if p := os.Getenv(envKubeconfig); p != "" {
+		// KUBECONFIG は複数パスを取り得るが、ここでは先頭のみを見る
+		return KubeconfigConfig(filepath.SplitList(p)[0])
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return Config{}, fmt.Errorf("kube: no in-cluster environment and cannot locate kubeconfig: %w", err)
+	}
+	return KubeconfigConfig(filepath.Join(home, ".kube", "config"))
+}
+
+// InClusterConfig は Pod 内にマウントされた ServiceAccount の資格情報から接続情報を作る
+func InClusterConfig() (Config, error) {
+	host, port := os.Getenv(envServiceHost), os.Getenv(envServicePort)
+	if host == "" || port == "" {
+		return Config{}, errors.New("kube: not running in a cluster (KUBERNETES_SERVICE_HOST/PORT unset)")
+	}
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return Config{}, fmt.Errorf("kube: read service account token: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return Config{}, fmt.Errorf("kube: read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return Config{}, errors.New("kube: service account CA contains no certificates")
+	}
+
+	ns := defaultNamespace
+	if b, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil {
+		if s := strings.TrimSpace(string(b)); s != "" {
+			ns = s
+		}
+	}
+
+	return Config{
+		Host:      "https://" + net.JoinHostPort(host, port),
+		Token:     strings.TrimSpace(string(token)),
+		Namespace: ns,
+		TLS:       &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+	}, nil
+}
+
+// kubeconfig のうち必要なフィールドだけを読む
+type kubeconfigFile struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			TLSServerName            string `yaml:"tls-server-name"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string    `yaml:"token"`
+			TokenFile             string    `yaml:"tokenFile"`
+			ClientCertificate     string    `yaml:"client-certificate"`
+			ClientCertificateData string    `yaml:"client-certificate-data"`
+			ClientKey             string    `yaml:"client-key"`
+			ClientKeyData         string    `yaml:"client-key-data"`
+			Exec                  yaml.Node `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// KubeconfigConfig は kubeconfig の current-context から接続情報を作る。
+// exec/auth-provider プラグインによる認証には対応しない
+func KubeconfigConfig(path string) (Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("kube: read kubeconfig: %w", err)
+	}
+	var kc kubeconfigFile
+	if err := yaml.Unmarshal(b, &kc); err != nil {
+		return Config{}, fmt.Errorf("kube: parse kubeconfig %s: %w", path, err)
+	}
+	base := filepath.Dir(path)
+
+	ctxIdx := -1
+	for i, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			ctxIdx = i
+			break
+		}
+	}
+	if ctxIdx < 0 {
+		return Config{}, fmt.Errorf("kube: current-context %q not found in %s", kc.CurrentContext, path)
+	}
+	kctx := kc.Contexts[ctxIdx].Context
+
+	cfg := Config{Namespace: kctx.Namespace, TLS: &tls.Config{MinVersion: tls.VersionTLS12}}
+	if cfg.Namespace == "" {
+		cfg.Namespace = defaultNamespace
+	}
+
+	found := false
+	for _, c := range kc.Clusters {
+		if c.Name != kctx.Cluster {
+			continue
+		}
+		found = true
+		cfg.Host = strings.TrimSuffix(c.Cluster.Server, "/")
+		cfg.TLS.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		cfg.TLS.ServerName = c.Cluster.TLSServerName
+		ca, err := dataOrFile(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority, base)
+		if err != nil {
+			return Config{}, fmt.Errorf("kube: cluster %q CA: %w", c.Name, err)
+		}
+		if len(ca) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return Config{}, fmt.Errorf("kube: cluster %q CA contains no certificates", c.Name)
+			}
+			cfg.TLS.RootCAs = pool
+		}
+	}
+	if !found {
+		return Config{}, fmt.Errorf("kube: cluster %q not found in %s", kctx.Cluster, path)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != kctx.User {
+			continue
+		}
+		if !u.User.Exec.IsZero() {
+			return Config{}, fmt.Errorf("kube: user %q uses an exec credential plugin, which is not supported", u.Name)
+		}
+		cfg.Token = u.User.Token
+		if cfg.Token == "" && u.User.TokenFile != "" {
+			t, err := os.ReadFile(resolvePath(u.User.TokenFile, base))
+			if err != nil {
+				return Config{}, fmt.Errorf("kube: user %q token file: %w", u.Name, err)
+			}
+			cfg.Token = strings.TrimSpace(string(t))
+		}
+		cert, err := dataOrFile(u.User.ClientCertificateData, u.User.ClientCertificate, base)
+		if err != nil {
+			return Config{}, fmt.Errorf("kube: user %q client certificate: %w", u.Name, err)
+		}
+		key, err := dataOrFile(u.User.ClientKeyData, u.User.ClientKey, base)
+		if err != nil {
+			return Config{}, fmt.Errorf("kube: user %q client key: %w", u.Name, err)
+		}
+		if len(cert) > 0 {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return Config{}, fmt.Errorf("kube: user %q client key pair: %w", u.Name, err)
+			}
+			cfg.TLS.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	return cfg, nil
+}
+
+// dataOrFile は kubeconfig の *-data(base64) を優先し、なければファイルパスから読む
+func dataOrFile(data, file, base string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return os.ReadFile(resolvePath(file, base))
+	}
+	return nil, nil
+}
+
+// kubeconfig 内の相対パスは kubeconfig ファイルのディレクトリ基準
+func resolvePath(p, base string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(base, p)
+}
+
+// ServiceRef は --kube-service で指定する "[namespace/]name[:port]" 形式の参照
+type ServiceRef struct {
+	Namespace string
+	Name      string
+	// Port は Service のポート名またはコンテナのポート番号。空なら Service のポートが 1 つの場合のみ自動選択
+	Port string
+}
+
+// ParseServiceRef は "[namespace/]name[:port]" を解析する
+func ParseServiceRef(s string) (ServiceRef, error) {
+	var ref ServiceRef
+	rest := s
+	if i := strings.Index(rest, "/"); i >= 0 {
+		ref.Namespace, rest = rest[:i], rest[i+1:]
+		if ref.Namespace == "" {
+			return ServiceRef{}, fmt.Errorf("kube: empty namespace in service %q", s)
+		}
+	}
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		ref.Port, rest = rest[i+1:], rest[:i]
+		if ref.Port == "" {
+			return ServiceRef{}, fmt.Errorf("kube: empty port in service %q", s)
+		}
+	}
+	if rest == "" {
+		return ServiceRef{}, fmt.Errorf("kube: empty service name in %q", s)
+	}
+	ref.Name = rest
+	return ref, nil
+}
+
+func (r ServiceRef) String() string {
+	s := r.Namespace + "/" + r.Name
+	if r.Port != "" {
+		s += ":" + r.Port
+	}
+	return s
+}
+
+// Endpoint は Service の背後にある 1 つの Pod のアドレス
+type Endpoint struct {
+	// Addr は "ip:port"
+	Addr string
+	// Pod は targetRef の Pod 名(取得できない場合は空)
+	Pod string
+}
+
+// Client は API サーバーへの最小限の REST クライアント
+type Client struct {
+	cfg  Config
+	http *http.Client
+}
+
+// NewClient は Config から Client を作る
+func NewClient(cfg Config) *Client {
+	return &Client{
+		cfg: cfg,
+		http: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: &http.Transport{TLSClientConfig: cfg.TLS, Proxy: http.ProxyFromEnvironment},
+		},
+	}
+}
+
+// EndpointSlice のうち必要なフィールドだけを読む
+type endpointSliceList struct {
+	Items []struct {
+		AddressType string `json:"addressType"`
+		Endpoints   []struct {
+			Addresses  []string `json:"addresses"`
+			Conditions struct {
+				Ready *bool `json:"ready"`
+			} `json:"conditions"`
+			TargetRef *struct {
+				Kind string `json:"kind"`
+				Name string `json:"name"`
+			} `json:"targetRef"`
+		} `json:"endpoints"`
+		Ports []endpointPort `json:"ports"`
+	} `json:"items"`
+}
+
+type endpointPort struct {
+	Name string `json:"name"`
+	Port int32  `json:"port"`
+}
+
+// ServiceEndpoints は Service に紐づく EndpointSlice から Ready な Pod のアドレスを返す。
+// ref.Namespace が空なら Config の Namespace を使う
+func (c *Client) ServiceEndpoints(ctx context.Context, ref ServiceRef) ([]Endpoint, error) {
+	ns := ref.Namespace
+	if ns == "" {
+		ns = c.cfg.Namespace
+	}
+	u := fmt.Sprintf("%s/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices?labelSelector=%s",
+		c.cfg.Host, url.PathEscape(ns), url.QueryEscape("kubernetes.io/service-name="+ref.Name))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kube: list endpointslices: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("kube: list endpointslices for %s/%s: %s: %s",
+			ns, ref.Name, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var list endpointSliceList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("kube: decode endpointslices: %w", err)
+	}
+
+	var out []Endpoint
+	seen := make(map[string]bool)
+	for _, item := range list.Items {
+		if item.AddressType != "IPv4" && item.AddressType != "IPv6" {
+			continue
+		}
+		port, err := selectPort(ref.Port, item.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("kube: service %s/%s: %w", ns, ref.Name, err)
+		}
+		for _, ep := range item.Endpoints {
+			// ready が未設定の場合は Ready 扱い(EndpointSlice の仕様)
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			pod := ""
+			if ep.TargetRef != nil && ep.TargetRef.Kind == "Pod" {
+				pod = ep.TargetRef.Name
+			}
+			for _, a := range ep.Addresses {
+				addr := net.JoinHostPort(a, strconv.Itoa(int(port)))
+				if seen[addr] {
+					continue
+				}
+				seen[addr] = true
+				out = append(out, Endpoint{Addr: addr, Pod: pod})
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("kube: service %s/%s has no ready endpoints", ns, ref.Name)
+	}
+	return out, nil
+}
+
+// selectPort は EndpointSlice のポート一覧から接続先ポートを決める
+func selectPort(want string, ports []endpointPort) (int32, error) {
+	if want == "" {
+		if len(ports) == 1 {
+			return ports[0].Port, nil
+		}
+		for _, p := range ports {
+			if p.Name == "grpc" {
+				return p.Port, nil
+			}
+		}
+		return 0, fmt.Errorf("service exposes %d ports; specify one as name:port", len(ports))
+	}
+	for _, p := range ports {
+		if p.Name == want {
+			return p.Port, nil
+		}
+	}
+	if n, err := strconv.ParseInt(want, 10, 32); err == nil && n > 0 {
+		return int32(n), nil
+	}
+	return 0, fmt.Errorf("port %q not found", want)
+}
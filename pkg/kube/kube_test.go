@@ -0,0 +1,93 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseServiceRef(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ServiceRef
+		wantErr bool
+	}{
+		{in: "cno-app", want: ServiceRef{Name: "cno-app"}},
+		{in: "demo/cno-app", want: ServiceRef{Namespace: "demo", Name: "cno-app"}},
+		{in: "demo/cno-app:grpc", want: ServiceRef{Namespace: "demo", Name: "cno-app", Port: "grpc"}},
+		{in: "cno-app:8080", want: ServiceRef{Name: "cno-app", Port: "8080"}},
+		{in: "/cno-app", wantErr: true},
+		{in: "demo/", wantErr: true},
+		{in: "cno-app:", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseServiceRef(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseServiceRef(%q) = %+v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseServiceRef(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// Ready でない Pod と FQDN の EndpointSlice を除外し、名前付きポートで解決できることを確認
+func TestServiceEndpoints(t *testing.T) {
+	const body = `{"items":[
+	  {"addressType":"IPv4",
+	   "ports":[{"name":"metrics","port":9090},{"name":"grpc","port":8080}],
+	   "endpoints":[
+	     {"addresses":["10.0.0.1"],"conditions":{"ready":true},"targetRef":{"kind":"Pod","name":"cno-app-a"}},
+	     {"addresses":["10.0.0.2"],"conditions":{"ready":false},"targetRef":{"kind":"Pod","name":"cno-app-b"}},
+	     {"addresses":["10.0.0.3"],"conditions":{},"targetRef":{"kind":"Pod","name":"cno-app-c"}}
+	   ]},
+	  {"addressType":"FQDN","ports":[{"name":"grpc","port":8080}],
+	   "endpoints":[{"addresses":["example.com"]}]}
+	]}`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/demo/endpointslices" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.URL.Query().Get("labelSelector"); got != "kubernetes.io/service-name=cno-app" {
+			t.Errorf("labelSelector = %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	c := NewClient(Config{Host: srv.URL, Token: "secret", Namespace: "demo"})
+
+	got, err := c.ServiceEndpoints(context.Background(), ServiceRef{Name: "cno-app", Port: "grpc"})
+	if err != nil {
+		t.Fatalf("ServiceEndpoints: %v", err)
+	}
+	want := []Endpoint{
+		{Addr: "10.0.0.1:8080", Pod: "cno-app-a"},
+		{Addr: "10.0.0.3:8080", Pod: "cno-app-c"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("endpoint[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// 複数ポートでも "grpc" という名前があれば省略できる
+	if _, err := c.ServiceEndpoints(context.Background(), ServiceRef{Name: "cno-app"}); err != nil {
+		t.Errorf("ServiceEndpoints without port: %v", err)
+	}
+	if _, err := c.ServiceEndpoints(context.Background(), ServiceRef{Name: "cno-app", Port: "http"}); err == nil {
+		t.Error("expected error for unknown port name")
+	}
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+package load
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// CPUAffinitySupported はこの環境で CPU ワーカーをコアに固定できるか(sched_getaffinity / sched_setaffinity を使えるか)を確認する
+func CPUAffinitySupported() error {
+	var allowed unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &allowed); err != nil {
+		return fmt.Errorf("%w: sched_getaffinity: %v", ErrAffinityUnsupported, err)
+	}
+	return nil
+}
+
+// checkCPUAffinity は cpus がすべてこのプロセスに割り当てられたコア(cgroup の cpuset や taskset で制限された範囲)に含まれるかを確認する
+func checkCPUAffinity(cpus []int) error {
+	var allowed unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &allowed); err != nil {
+		return fmt.Errorf("%w: sched_getaffinity: %v", ErrAffinityUnsupported, err)
+	}
+	for _, cpu := range cpus {
+		if !allowed.IsSet(cpu) {
+			return fmt.Errorf("load: cpu_affinity: cpu %d is not available to this process", cpu)
+		}
+	}
+	return nil
+}
+
+// pinCurrentThread は呼び出したゴルーチンを OS スレッドに固定し、そのスレッドを cpu のコアだけで動くようにする。
+// 固定したスレッドは他のゴルーチンに使い回されないよう UnlockOSThread せず、ゴルーチンの終了とともに破棄させる
+func pinCurrentThread(cpu int) error {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(0, &set)
+}
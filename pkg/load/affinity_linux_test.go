@@ -0,0 +1,75 @@
+//go:build linux
+
+package load
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// firstAllowedCPU はこのプロセスが使えるコアのうち最小の番号を返す
+func firstAllowedCPU(t *testing.T) int {
+	t.Helper()
+	var allowed unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &allowed); err != nil {
+		t.Skipf("sched_getaffinity not available: %v", err)
+	}
+	for cpu := 0; cpu < 1024; cpu++ {
+		if allowed.IsSet(cpu) {
+			return cpu
+		}
+	}
+	t.Fatal("no cpu available")
+	return -1
+}
+
+// 固定したスレッドのアフィニティが指定したコアだけになることを確認
+func TestPinCurrentThread(t *testing.T) {
+	cpu := firstAllowedCPU(t)
+
+	errc := make(chan error, 1)
+	got := make(chan unix.CPUSet, 1)
+	go func() {
+		// pinCurrentThread は UnlockOSThread しないため、専用のゴルーチンで呼ぶ
+		if err := pinCurrentThread(cpu); err != nil {
+			errc <- err
+			return
+		}
+		var set unix.CPUSet
+		errc <- unix.SchedGetaffinity(0, &set)
+		got <- set
+	}()
+	if err := <-errc; err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	set := <-got
+	if set.Count() != 1 || !set.IsSet(cpu) {
+		t.Fatalf("affinity after pin = %d cpus (cpu %d set: %v), want only cpu %d", set.Count(), cpu, set.IsSet(cpu), cpu)
+	}
+}
+
+func TestRunWithResult_CPUAffinity(t *testing.T) {
+	cpu := firstAllowedCPU(t)
+
+	res, err := RunWithResult(context.Background(), Config{Mode: ModeCPU, Duration: 50 * time.Millisecond, Parallelism: 2, CPUAffinity: []int{cpu}})
+	if err != nil {
+		t.Fatalf("RunWithResult returned error: %v", err)
+	}
+	if w := res.Sum(WorkerCPU); w.Iterations == 0 {
+		t.Fatalf("expected pinned cpu workers to run, got %+v", res.Workers)
+	}
+
+	invalid := []Config{
+		{Mode: ModeCPU, Duration: time.Second, Parallelism: 1, CPUAffinity: []int{-1}},
+		{Mode: ModeCPU, Duration: time.Second, Parallelism: 1, CPUAffinity: []int{4096}},
+		{Mode: ModeMem, Duration: time.Second, AllocMB: 1, CPUAffinity: []int{cpu}},
+	}
+	for _, cfg := range invalid {
+		if err := Validate(cfg); err == nil {
+			t.Fatalf("expected error for mode=%s cpu_affinity=%v, got nil", cfg.Mode, cfg.CPUAffinity)
+		}
+	}
+}
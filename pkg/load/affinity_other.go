@@ -0,0 +1,17 @@
+//go:build !linux
+
+package load
+
+// CPUAffinitySupported は Linux 以外ではコアへの固定に対応していないため常にエラーを返す
+func CPUAffinitySupported() error {
+	return ErrAffinityUnsupported
+}
+
+// checkCPUAffinity は Linux 以外ではコアへの固定に対応していないため常にエラーを返す
+func checkCPUAffinity([]int) error {
+	return ErrAffinityUnsupported
+}
+
+func pinCurrentThread(int) error {
+	return ErrAffinityUnsupported
+}
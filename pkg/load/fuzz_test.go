@@ -0,0 +1,73 @@
+package load
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// validateConfig が通した設定は、どんな入力でもノードの資源を守る上限内に収まっていることを確認する。
+// go test ./pkg/load -run '^$' -fuzz FuzzValidateConfig で継続的に探索できる
+func FuzzValidateConfig(f *testing.F) {
+	f.Add("cpu", int64(time.Second), 0, 1, 0, int64(0), 0.0)
+	f.Add("mem", int64(time.Second), 32, 0, 0, int64(0), 0.5)
+	f.Add("io", int64(time.Second), 0, 0, 64*1024, int64(time.Millisecond), 1.0)
+	f.Add("cpu-mem", int64(-1), -1, -1, -1, int64(-1), -1.0)
+	f.Add("cpu", int64(math.MaxInt64), math.MaxInt32, math.MaxInt32, math.MaxInt32, int64(math.MaxInt64), math.NaN())
+	f.Add("unknown", int64(time.Second), 1, 1, 1, int64(0), math.Inf(1))
+
+	f.Fuzz(func(t *testing.T, mode string, duration int64, allocMB, parallelism, ioBytes int, latency int64, errorRate float64) {
+		cfg := Config{
+			Mode:        Mode(mode),
+			Duration:    time.Duration(duration),
+			AllocMB:     allocMB,
+			Parallelism: parallelism,
+			IOBytes:     ioBytes,
+			Latency:     time.Duration(latency),
+			ErrorRate:   errorRate,
+		}
+		limits := DefaultLimitProfiles().For(cfg.Mode)
+		if err := validateConfig(cfg, limits); err != nil {
+			return
+		}
+		assertWithinLimits(t, cfg, limits)
+	})
+}
+
+// assertWithinLimits は検証済みの設定がモードの上限を超えていないことを確認する(0 の上限は制限なし)
+func assertWithinLimits(t *testing.T, cfg Config, limits Limits) {
+	t.Helper()
+
+	switch cfg.Mode {
+	case ModeCPU, ModeMem, ModeCPUMem, ModeIO:
+	default:
+		t.Fatalf("accepted invalid mode %q", cfg.Mode)
+	}
+	if cfg.Duration <= 0 || cfg.Duration > limits.MaxDuration {
+		t.Fatalf("accepted duration %s outside (0, %s]", cfg.Duration, limits.MaxDuration)
+	}
+	if cfg.Latency < 0 {
+		t.Fatalf("accepted negative latency %s", cfg.Latency)
+	}
+	if math.IsNaN(cfg.ErrorRate) || cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+		t.Fatalf("accepted error_rate %v", cfg.ErrorRate)
+	}
+	if limits.MaxAllocMB > 0 && cfg.AllocMB > limits.MaxAllocMB {
+		t.Fatalf("accepted alloc_mb %d > %d", cfg.AllocMB, limits.MaxAllocMB)
+	}
+	if limits.MaxParallelism > 0 && cfg.Parallelism > limits.MaxParallelism {
+		t.Fatalf("accepted parallelism %d > %d", cfg.Parallelism, limits.MaxParallelism)
+	}
+	if limits.MaxIOBytes > 0 && cfg.IOBytes > limits.MaxIOBytes {
+		t.Fatalf("accepted io_bytes %d > %d", cfg.IOBytes, limits.MaxIOBytes)
+	}
+	if (cfg.Mode == ModeMem || cfg.Mode == ModeCPUMem) && cfg.AllocMB <= 0 {
+		t.Fatalf("accepted alloc_mb %d for %s mode", cfg.AllocMB, cfg.Mode)
+	}
+	if (cfg.Mode == ModeCPU || cfg.Mode == ModeCPUMem) && cfg.Parallelism <= 0 {
+		t.Fatalf("accepted parallelism %d for %s mode", cfg.Parallelism, cfg.Mode)
+	}
+	if cfg.Mode == ModeIO && cfg.IOBytes <= 0 {
+		t.Fatalf("accepted io_bytes %d for io mode", cfg.IOBytes)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !windows
+
+package load
+
+import "os"
+
+// syncFile は書き込んだ内容をストレージへ同期する(fsync)
+func syncFile(f *os.File) error {
+	return f.Sync()
+}
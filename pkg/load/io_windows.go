@@ -0,0 +1,10 @@
+//go:build windows
+
+package load
+
+import "os"
+
+// syncFile は書き込んだ内容をストレージへ同期する(FlushFileBuffers)
+func syncFile(f *os.File) error {
+	return f.Sync()
+}
@@ -0,0 +1,18 @@
+//go:build darwin
+
+package load
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const ioColdCacheSupported = true
+
+// dropPageCache は f の以降の読み書きでページキャッシュを使わないようにする(F_NOCACHE)。
+// macOS には範囲を指定して追い出す手段がないため、ファイル単位でキャッシュを切る
+func dropPageCache(f *os.File, _, _ int64) error {
+	_, err := unix.FcntlInt(f.Fd(), unix.F_NOCACHE, 1)
+	return err
+}
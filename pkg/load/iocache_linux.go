@@ -0,0 +1,17 @@
+//go:build linux
+
+package load
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const ioColdCacheSupported = true
+
+// dropPageCache は f の [off, off+n) をページキャッシュから追い出す(posix_fadvise の DONTNEED)。
+// 書き込んだ内容は同期済みである必要がある(dirty なページは追い出されない)
+func dropPageCache(f *os.File, off, n int64) error {
+	return unix.Fadvise(int(f.Fd()), off, n, unix.FADV_DONTNEED)
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package load
+
+import "os"
+
+const ioColdCacheSupported = false
+
+func dropPageCache(*os.File, int64, int64) error {
+	return ErrIOColdCacheUnsupported
+}
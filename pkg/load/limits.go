@@ -0,0 +1,98 @@
+package load
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// Limits defines safety upper bounds. 0 の項目は制限しない
+type Limits struct {
+	MaxDuration    time.Duration
+	MaxAllocMB     int
+	MaxParallelism int
+	MaxIOBytes     int
+}
+
+// LimitProfiles はモードごとの Limits。
+// モードによって使う資源が違うため、I/O はバイト数、mem は MB、CPU は並列数のように、
+// そのモードが実際に使う資源の上限だけを持たせる(使わないパラメータは負荷に影響しないので制限しない)
+type LimitProfiles map[Mode]Limits
+
+const defaultMaxDuration = 60 * time.Second
+
+var limitModes = []Mode{ModeCPU, ModeMem, ModeCPUMem, ModeIO, ModePageFault}
+
+// defaultLimits is a conservative default safety guard.
+// 書き換えられるパッケージ変数にはせず、呼び出しごとに値を返す(上書きは LimitProfiles を Run に渡して行う)
+func defaultLimits(mode Mode) (Limits, bool) {
+	switch mode {
+	case ModeCPU:
+		return Limits{MaxDuration: defaultMaxDuration, MaxParallelism: runtime.NumCPU() * 4}, true
+	case ModeMem:
+		return Limits{MaxDuration: defaultMaxDuration, MaxAllocMB: 512}, true
+	case ModeCPUMem:
+		return Limits{MaxDuration: defaultMaxDuration, MaxAllocMB: 512, MaxParallelism: runtime.NumCPU() * 4}, true
+	case ModeIO:
+		return Limits{MaxDuration: defaultMaxDuration, MaxIOBytes: 64 * 1024 * 1024}, true
+	case ModePageFault:
+		// RAM より大きい領域でページアウト/インを見せる場合は、swap を用意したノードで上限を引き上げる
+		return Limits{MaxDuration: defaultMaxDuration, MaxAllocMB: 2048}, true
+	}
+	return Limits{}, false
+}
+
+// DefaultLimitProfiles は既定のモード別上限を返す。上書きする場合はこれを元にする
+func DefaultLimitProfiles() LimitProfiles {
+	p := make(LimitProfiles, len(limitModes))
+	for _, mode := range limitModes {
+		p[mode], _ = defaultLimits(mode)
+	}
+	return p
+}
+
+// For は mode の上限を返す。p に mode が無い場合は既定のプロファイルを使う
+func (p LimitProfiles) For(mode Mode) Limits {
+	if l, ok := p[mode]; ok {
+		return l
+	}
+	l, _ := defaultLimits(mode)
+	return l
+}
+
+type limitProfilesKey struct{}
+
+// WithLimitProfiles は p を ctx に載せる。Run は Config.Limits が nil の時、ctx の p から Mode の上限を選んで検証する。
+// サーバーの設定の上限とは別に、テナントなどリクエストごとの上限のポリシーをインターセプタから差し込むために使う
+func WithLimitProfiles(ctx context.Context, p LimitProfiles) context.Context {
+	return context.WithValue(ctx, limitProfilesKey{}, p)
+}
+
+// LimitProfilesFromContext は WithLimitProfiles で ctx に載せた上限を返す
+func LimitProfilesFromContext(ctx context.Context) (LimitProfiles, bool) {
+	p, ok := ctx.Value(limitProfilesKey{}).(LimitProfiles)
+	return p, ok
+}
+
+// Validate は未知のモードや負の上限が含まれていないかを検証する
+func (p LimitProfiles) Validate() error {
+	modes := make([]string, 0, len(p))
+	for mode := range p {
+		modes = append(modes, string(mode))
+	}
+	sort.Strings(modes)
+
+	for _, m := range modes {
+		mode := Mode(m)
+		if _, ok := defaultLimits(mode); !ok {
+			return fmt.Errorf("%w: %q", ErrInvalidMode, mode)
+		}
+		l := p[mode]
+		if l.MaxDuration < 0 || l.MaxAllocMB < 0 || l.MaxParallelism < 0 || l.MaxIOBytes < 0 {
+			return fmt.Errorf("load: limits for %s mode must be >= 0", mode)
+		}
+	}
+	return nil
+}
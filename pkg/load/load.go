@@ -4,11 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
+	"math"
 	"math/rand"
-	"os"
-	"runtime"
-	"sync"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,8 +20,25 @@ const (
 	ModeMem    Mode = "mem"
 	ModeCPUMem Mode = "cpu-mem"
 	ModeIO     Mode = "io"
+	// ModePageFault は AllocMB の領域のページを飛び飛びに書き換え続け、ページフォルト(swap があればページアウト/イン)を起こす(Linux のみ)。
+	// RAM より大きい領域も指定できるが、RAM と swap の空きに収まらない場合は拒否する
+	ModePageFault Mode = "page-fault"
 )
 
+// IOCache は io モードで読み書きする領域をページキャッシュに載せたままにするかどうか
+type IOCache string
+
+const (
+	// IOCacheWarm は毎回ファイルの同じ領域を書き込んで読み戻す。読み込みはページキャッシュに当たる(既定)
+	IOCacheWarm IOCache = "warm"
+	// IOCacheCold はファイル内の ioColdRegions 個の領域を順に使い、書き込んで同期した領域をページキャッシュから追い出してから読み戻す。
+	// 読み込みが毎回デバイスまで届くため、warm との差でページキャッシュの効果を見られる
+	IOCacheCold IOCache = "cold"
+)
+
+// ioColdRegions は IOCacheCold で順に使う領域の数。一時ファイルは IOBytes × ioColdRegions バイトになる
+const ioColdRegions = 4
+
 type Random interface {
 	Float64() float64
 }
@@ -33,23 +50,24 @@ type Config struct {
 	AllocMB     int           // total memory to allocate in MB
 	Parallelism int           // number of CPU worker goroutines
 	IOBytes     int           // I/O負荷(ModeIOの時有効、1ループあたりに読み書きするバイト数)
+	IODir       string        // I/O負荷の一時ファイルを置くディレクトリ(空なら OS の既定の一時ディレクトリ)
 	Latency     time.Duration // 固定遅延(全モード共通)、Run開始時にLatency分だけスリープする
 	ErrorRate   float64       // 確率的エラー(全モード共通)、0.0~0.1の範囲でエラー発生確率を指定
+	FailAfter   time.Duration // 途中での失敗(全モード共通)、Run開始からFailAfter経過した時点で負荷を止めてErrInjectedMidRunを返す。0なら無効
 	Rand        Random        // エラー注入用の乱数源(テストで差し替え可能)
-}
+	Limits      *Limits       // 検証に使う上限。nil なら Run の ctx の LimitProfiles(WithLimitProfiles)、それも無ければ既定の Mode の上限
+	RequestID   string        // ワーカーの pprof ラベルと I/O 負荷の一時ファイル名に含める(空なら付けない)
 
-// Limits defines safety upper bounds..
-type Limits struct {
-	MaxDuration    time.Duration
-	MaxAllocMB     int
-	MaxParallelism int
-}
+	// WatchdogGrace は watchdog の猶予の倍率。Duration×WatchdogGrace(最低でも Duration+1s)を過ぎてもワーカーが終わらなければ
+	// 待つのをやめて ErrWatchdogKilled を返す。0 なら無効、指定するなら 1 以上
+	WatchdogGrace float64
 
-// DefaultLimits is a conservative default safety guard.
-var DefaultLimits = Limits{
-	MaxDuration:    60 * time.Second,
-	MaxAllocMB:     512,
-	MaxParallelism: runtime.NumCPU() * 4,
+	// CPUAffinity は cpu / cpu-mem モードの CPU ワーカーを固定するコアの番号(Linux のみ)。
+	// i 番目のワーカーを CPUAffinity[i % len(CPUAffinity)] に固定する。空なら固定しない
+	CPUAffinity []int
+
+	// IOCache は io モードでページキャッシュに当てる(warm)か外す(cold)か。空なら warm
+	IOCache IOCache
 }
 
 var (
@@ -57,51 +75,162 @@ var (
 	ErrDurationTooLarge   = errors.New("load:duration exceeds max ")
 	ErrAllocTooLarge      = errors.New("load: alloc_mb exceeds max")
 	ErrParallelismTooHigh = errors.New("load: parallelism exceeds max")
+	ErrIOBytesTooLarge    = errors.New("load: io_bytes exceeds max")
 	ErrInjected           = errors.New("load: injected error")
+	// ErrInjectedMidRun は FailAfter による途中での失敗。errors.Is(err, ErrInjected) も true になる
+	ErrInjectedMidRun = fmt.Errorf("%w after fail_after", ErrInjected)
+	// ErrWatchdogKilled は Duration を猶予以上に超えたワーカーを watchdog が見限ったことを表す
+	ErrWatchdogKilled = errors.New("load: killed by watchdog")
+	// ErrAffinityUnsupported は CPUAffinity を指定したが、この OS/環境ではコアへの固定ができないことを表す
+	ErrAffinityUnsupported = errors.New("load: cpu_affinity is not supported on this platform")
+	// ErrIOColdCacheUnsupported は IOCacheCold を指定したが、この OS ではページキャッシュから追い出せないことを表す
+	ErrIOColdCacheUnsupported = errors.New("load: io_cache=cold is not supported on this platform")
+	// ErrPageFaultUnsupported は page-fault モードをこの OS/環境で実行できないことを表す
+	ErrPageFaultUnsupported = errors.New("load: page-fault mode is not supported on this platform")
+)
+
+// StopReason は負荷実行が終了した理由
+type StopReason string
+
+const (
+	// StopCompleted は Duration を使い切って正常に終了したことを表す
+	StopCompleted StopReason = "completed"
+	// StopCanceled は呼び出し元の context がキャンセルされて途中終了したことを表す
+	StopCanceled StopReason = "canceled"
+	// StopDeadlineExceeded は呼び出し元の deadline(RPC タイムアウトなど)が Duration より先に来て途中終了したことを表す
+	StopDeadlineExceeded StopReason = "deadline_exceeded"
+	// StopFailed は負荷の途中で失敗した(FailAfter による注入、またはワーカーのエラー)ことを表す
+	StopFailed StopReason = "failed"
+	// StopWatchdogKilled はワーカーが Duration の猶予を過ぎても終わらず、watchdog が打ち切ったことを表す
+	StopWatchdogKilled StopReason = "watchdog_killed"
 )
 
+// RunResult は 1 回の負荷実行の結果
+type RunResult struct {
+	Reason  StopReason
+	Elapsed time.Duration
+	// Workers はワーカーごとの結果(ID 順)。watchdog が打ち切った場合は、終了していたワーカーの分だけを含む
+	Workers []WorkerResult
+	// Paused は WithPause のゲートで一時停止していた時間の合計(Elapsed に含まれる)
+	Paused time.Duration
+}
+
+// Interrupted は負荷実行が Duration を使い切る前に中断されたかどうかを返す
+func (r RunResult) Interrupted() bool {
+	return r.Reason != StopCompleted
+}
+
 // Validation errors are returned immediately. Contextキャンセルやタイムアウトは
 // 「想定された終了」とみなし、エラーは返さない
 func Run(ctx context.Context, cfg Config) error {
+	_, err := RunWithResult(ctx, cfg)
+	return err
+}
+
+// RunWithResult は Run と同じ負荷実行を行い、終了理由(完了/キャンセル/タイムアウト)とワーカーごとの結果を RunResult で返す。
+// Run と同様、キャンセルやタイムアウトはエラーにはしない。
+// ctx に WithPause のゲートがあれば、一時停止していた時間は Duration / FailAfter / watchdog の期限に数えない
+func RunWithResult(ctx context.Context, cfg Config) (RunResult, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	if err := validateConfig(cfg, DefaultLimits); err != nil {
-		return err
+	if cfg.Limits == nil {
+		if p, ok := LimitProfilesFromContext(ctx); ok {
+			l := p.For(cfg.Mode)
+			cfg.Limits = &l
+		}
+	}
+	if err := Validate(cfg); err != nil {
+		return RunResult{}, err
 	}
 
-	// Durationで自動終了するコンテキストに包む
-	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	start := time.Now()
+	parent := ctx
+	gate := pauseGateFromContext(ctx)
+	pausedBefore := gate.PausedFor()
+
+	// Durationで自動終了するコンテキストに包む。一時停止できる時は止まっていた分だけ終了を遅らせる
+	var cancel context.CancelFunc
+	if gate == nil {
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+		defer gate.afterActive(start.Add(cfg.Duration), cancel)()
+	}
 	defer cancel()
 
+	// 途中での失敗注入。即座に拒否するのではなく、FailAfter まではリソースを使ってから失敗させ、
+	// 本番の障害に近い「途中まで処理してからエラー」のトレースやメトリクスを作る
+	var failed atomic.Bool
+	if cfg.FailAfter > 0 {
+		defer gate.afterActive(start.Add(cfg.FailAfter), func() {
+			failed.Store(true)
+			cancel()
+		})()
+	}
+
 	// リクエスト単位の固定遅延を先頭で挿入
 	maybeSleep(ctx, cfg.Latency)
 
 	// 確率的エラー注入。trueの場合は負荷をかけずに即座に終了
 	if shouldError(cfg) {
-		return ErrInjected
+		return RunResult{Reason: stopReason(parent), Elapsed: time.Since(start)}, ErrInjected
 	}
 
-	var wg sync.WaitGroup
+	res, err := runWorkers(ctx, cfg, start)
+	res.Elapsed = time.Since(start)
+	res.Paused = gate.PausedFor() - pausedBefore
+	switch {
+	case errors.Is(err, ErrWatchdogKilled):
+		res.Reason = StopWatchdogKilled
+		return res, err
+	case err != nil:
+		res.Reason = StopFailed
+		return res, err
+	case failed.Load() && parent.Err() == nil:
+		res.Reason = StopFailed
+		return res, ErrInjectedMidRun
+	}
+	res.Reason = stopReason(parent)
+	return res, nil
+}
 
-	switch cfg.Mode {
-	case ModeCPU:
-		startCPULoad(ctx, &wg, cfg.Parallelism)
-	case ModeMem:
-		startMemLoad(ctx, &wg, cfg.AllocMB)
-	case ModeCPUMem:
-		startCPULoad(ctx, &wg, cfg.Parallelism)
-		startMemLoad(ctx, &wg, cfg.AllocMB)
-	case ModeIO:
-		startIOLoad(ctx, &wg, cfg.IOBytes)
-	default:
-		return fmt.Errorf("%w: %q", ErrInvalidMode, cfg.Mode)
+// DefaultWatchdogGrace は watchdog の猶予の倍率の既定値
+const DefaultWatchdogGrace = 2.0
+
+// minWatchdogSlack は watchdog が Duration を超えて待つ最短の時間。
+// 短い Duration で 1 回の書き込みや fsync が少し遅れただけで打ち切らないようにする
+const minWatchdogSlack = time.Second
+
+// watchdogDeadline は Run を start に開始した時に watchdog が打ち切る時刻を返す。無効ならゼロ値
+func (c Config) watchdogDeadline(start time.Time) time.Time {
+	if c.WatchdogGrace <= 0 {
+		return time.Time{}
 	}
+	return start.Add(max(time.Duration(float64(c.Duration)*c.WatchdogGrace), c.Duration+minWatchdogSlack))
+}
 
-	// 全ワーカー終了を待つ
-	wg.Wait()
+// stopReason は呼び出し元 context の状態から終了理由を判定する。
+// 呼び出し元がまだ有効なら、Duration による自動終了(完了)とみなす
+func stopReason(parent context.Context) StopReason {
+	switch {
+	case errors.Is(parent.Err(), context.Canceled):
+		return StopCanceled
+	case errors.Is(parent.Err(), context.DeadlineExceeded):
+		return StopDeadlineExceeded
+	default:
+		return StopCompleted
+	}
+}
 
-	return nil
+// Validate は cfg を cfg.Limits(nil なら Mode の既定の上限)で検証する。
+// Run の前に呼び出し側で拒否理由を記録したい場合に使う
+func Validate(cfg Config) error {
+	if cfg.Limits != nil {
+		return validateConfig(cfg, *cfg.Limits)
+	}
+	l, _ := defaultLimits(cfg.Mode)
+	return validateConfig(cfg, l)
 }
 
 func validateConfig(cfg Config, limits Limits) error {
@@ -116,7 +245,14 @@ func validateConfig(cfg Config, limits Limits) error {
 	if cfg.Latency < 0 {
 		return errors.New("load: latency must be >= 0")
 	}
-	if cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+	if math.IsNaN(cfg.WatchdogGrace) || cfg.WatchdogGrace < 0 || (cfg.WatchdogGrace > 0 && cfg.WatchdogGrace < 1) {
+		return errors.New("load: watchdog_grace must be 0 (disabled) or >= 1")
+	}
+	if cfg.FailAfter < 0 || cfg.FailAfter >= cfg.Duration {
+		return errors.New("load: fail_after must be >= 0 and < duration")
+	}
+	// NaN は比較が常に false になり範囲チェックをすり抜けるため明示的に弾く
+	if math.IsNaN(cfg.ErrorRate) || cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
 		return errors.New("load: error_rate must be between 0 and 1")
 	}
 
@@ -141,10 +277,41 @@ func validateConfig(cfg Config, limits Limits) error {
 		if cfg.IOBytes <= 0 {
 			return errors.New("load: io_bytes must be > 0 for io mode")
 		}
+	case ModePageFault:
+		if cfg.AllocMB <= 0 {
+			return errors.New("load: alloc_mb must be > 0 for page-fault mode")
+		}
 	default:
 		return fmt.Errorf("%w: %q", ErrInvalidMode, cfg.Mode)
 	}
 
+	switch cfg.IOCache {
+	case "", IOCacheWarm:
+	case IOCacheCold:
+		if cfg.Mode != ModeIO {
+			return fmt.Errorf("load: io_cache is only valid for io mode, got %s", cfg.Mode)
+		}
+		if !ioColdCacheSupported {
+			return ErrIOColdCacheUnsupported
+		}
+	default:
+		return fmt.Errorf("load: io_cache must be %s or %s, got %q", IOCacheWarm, IOCacheCold, cfg.IOCache)
+	}
+
+	if len(cfg.CPUAffinity) > 0 {
+		if cfg.Mode != ModeCPU && cfg.Mode != ModeCPUMem {
+			return fmt.Errorf("load: cpu_affinity is only valid for cpu and cpu-mem mode, got %s", cfg.Mode)
+		}
+		for _, cpu := range cfg.CPUAffinity {
+			if cpu < 0 {
+				return fmt.Errorf("load: cpu_affinity must be >= 0, got %d", cpu)
+			}
+		}
+		if err := checkCPUAffinity(cfg.CPUAffinity); err != nil {
+			return err
+		}
+	}
+
 	// 上限ガード
 	if limits.MaxAllocMB > 0 && cfg.AllocMB > limits.MaxAllocMB {
 		return ErrAllocTooLarge
@@ -152,9 +319,50 @@ func validateConfig(cfg Config, limits Limits) error {
 	if limits.MaxParallelism > 0 && cfg.Parallelism > limits.MaxParallelism {
 		return ErrParallelismTooHigh
 	}
+	if limits.MaxIOBytes > 0 && cfg.IOBytes > limits.MaxIOBytes {
+		return ErrIOBytesTooLarge
+	}
+
+	// page-fault モードは上限に加えて、実行時点の RAM と swap の空きに収まるかを確認する(OOM を避けるため)
+	if cfg.Mode == ModePageFault {
+		if err := checkPageFaultBudget(cfg.AllocMB); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (c Config) pprofLabels() pprof.LabelSet {
+	if c.RequestID == "" {
+		return pprof.Labels("mode", string(c.Mode))
+	}
+	return pprof.Labels("mode", string(c.Mode), "request_id", c.RequestID)
+}
+
+// maxTempFileRequestID は一時ファイル名に含める request_id の最大長
+const maxTempFileRequestID = 64
+
+// tempFilePattern は I/O 負荷の一時ファイル名のパターンを返す。
+// lsof などで開いているファイルからリクエストを特定できるよう request_id を含める。
+// request_id はクライアントが自由に指定できるため、英数字と "-" / "_" 以外は "_" に置き換える
+func tempFilePattern(requestID string) string {
+	if requestID == "" {
+		return "cno-io-*"
+	}
+	if len(requestID) > maxTempFileRequestID {
+		requestID = requestID[:maxTempFileRequestID]
+	}
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, requestID)
+	return "cno-io-" + safe + "-*"
+}
+
 func (c Config) rand() Random {
 	if c.Rand != nil {
 		return c.Rand
@@ -190,127 +398,3 @@ func maybeSleep(ctx context.Context, d time.Duration) {
 		return
 	}
 }
-
-func startCPULoad(ctx context.Context, wg *sync.WaitGroup, n int) {
-	if n <= 0 {
-		return
-	}
-
-	for i := 0; i < n; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-
-			// 適度にレジスタ/キャッシュを使う軽い計算
-			var x float64
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					x += 1.0
-					if x > 1e9 {
-						x = 0
-					}
-				}
-			}
-		}()
-	}
-}
-
-func startMemLoad(ctx context.Context, wg *sync.WaitGroup, allocMB int) {
-	if allocMB <= 0 {
-		return
-	}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		// allocMB MBをチャンクに分けて確保する
-		totalBytes := allocMB * 1024 * 1024
-		const chunk = 1 * 1024 * 1024
-		numChunks := (totalBytes + chunk - 1) / chunk
-
-		bufs := make([][]byte, 0, numChunks)
-		remaining := totalBytes
-
-		for i := 0; i < numChunks; i++ {
-			size := chunk
-			if remaining < chunk {
-				size = remaining
-			}
-
-			b := make([]byte, size)
-
-			for j := 0; j < len(b); j += 4096 {
-				b[j] = byte(j)
-			}
-
-			bufs = append(bufs, b)
-			remaining -= size
-		}
-		<-ctx.Done()
-
-		_ = bufs
-	}()
-}
-
-// I/O負荷:一時ファイルに対してioBytesバイトの書き込みをDuration中ひたすら繰り返す。
-func startIOLoad(ctx context.Context, wg *sync.WaitGroup, ioBytes int) {
-	if ioBytes <= 0 {
-		return
-	}
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		f, err := os.CreateTemp("", "cno-io-*")
-		if err != nil {
-			return
-		}
-		name := f.Name()
-		defer func() {
-			_ = f.Close()
-			_ = os.Remove(name)
-		}()
-
-		const chunkSize = 32 * 1024
-		buf := make([]byte, chunkSize)
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-
-			// ファイル先頭からioBytes分だけ書き込む
-			if _, err := f.Seek(0, io.SeekStart); err != nil {
-				return
-			}
-
-			remaining := ioBytes
-			for remaining > 0 {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-				}
-
-				n := remaining
-				if n > len(buf) {
-					n = len(buf)
-				}
-				if _, err := f.Write(buf[:n]); err != nil {
-					return
-				}
-				remaining -= n
-			}
-
-			if err := f.Sync(); err != nil {
-				return
-			}
-		}
-	}()
-}
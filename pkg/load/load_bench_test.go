@@ -0,0 +1,85 @@
+package load
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// ベンチマークは benchstat で比較できるよう、サブベンチマーク名を mode/workers 形式で固定している。
+// make bench BENCH_OUT=old.txt → 変更 → make bench BENCH_OUT=new.txt → benchstat old.txt new.txt
+
+// benchWorkers は並列数を変えて計測する値。cpu モードの MaxParallelism を超えるものは除外する
+func benchWorkers() []int {
+	var out []int
+	for _, n := range []int{1, 4, 16} {
+		if n <= DefaultLimitProfiles().For(ModeCPU).MaxParallelism {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+type benchCase struct {
+	name string
+	cfg  Config
+}
+
+// benchCases は各モードの計測ケースを Duration=d で返す
+func benchCases(d time.Duration) []benchCase {
+	var cases []benchCase
+	for _, n := range benchWorkers() {
+		cases = append(cases,
+			benchCase{fmt.Sprintf("cpu/workers=%d", n), Config{Mode: ModeCPU, Parallelism: n}},
+			benchCase{fmt.Sprintf("cpu-mem/workers=%d", n), Config{Mode: ModeCPUMem, Parallelism: n, AllocMB: 8}},
+		)
+	}
+	cases = append(cases,
+		benchCase{"mem/alloc_mb=8", Config{Mode: ModeMem, AllocMB: 8}},
+		benchCase{"io/io_bytes=65536", Config{Mode: ModeIO, IOBytes: 64 * 1024}},
+	)
+	for i := range cases {
+		cases[i].cfg.Duration = d
+	}
+	return cases
+}
+
+// BenchmarkRunOverhead は呼び出し元が既にキャンセル済みの状態で RunWithResult を呼び、
+// ワーカーの起動から終了待ちまでのオーケストレーションのコストだけを計測する
+func BenchmarkRunOverhead(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, bc := range benchCases(time.Second) {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := RunWithResult(ctx, bc.cfg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRunShort は短い Duration で負荷を実際に走らせ、確保パターン(B/op, allocs/op)と
+// Duration 経過後に全ワーカーが止まるまでの遅れ(overhead-ns/op)を計測する
+func BenchmarkRunShort(b *testing.B) {
+	const d = 5 * time.Millisecond
+
+	for _, bc := range benchCases(d) {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var overhead time.Duration
+			for i := 0; i < b.N; i++ {
+				res, err := RunWithResult(context.Background(), bc.cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				overhead += res.Elapsed - d
+			}
+			b.ReportMetric(float64(overhead.Nanoseconds())/float64(b.N), "overhead-ns/op")
+		})
+	}
+}
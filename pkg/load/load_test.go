@@ -1,10 +1,18 @@
 package load
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 func TestRun_CPULoadAndMemLoad_DoNotError(t *testing.T) {
@@ -42,7 +50,7 @@ func TestRun_ExceedsDurationLimitReturnsError(t *testing.T) {
 
 	cfg := Config{
 		Mode:        ModeCPU,
-		Duration:    DefaultLimits.MaxDuration + time.Second,
+		Duration:    DefaultLimitProfiles().For(ModeCPU).MaxDuration + time.Second,
 		Parallelism: 1,
 	}
 
@@ -75,6 +83,28 @@ func TestRun_IOLoad_DoesNotError(t *testing.T) {
 	}
 }
 
+// IODir を指定した場合はそのディレクトリに一時ファイルを作り、終了時に削除することの確認
+func TestRun_IOLoad_UsesIODirAndCleansUp(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		Mode:     ModeIO,
+		Duration: 50 * time.Millisecond,
+		IOBytes:  4 * 1024,
+		IODir:    dir,
+	}
+
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run(%+v) returned error: %v", cfg, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected temp files to be removed, found %d entries", len(entries))
+	}
+}
+
 // error_rate=1.0の時、必ずErrInjectedが返ることの確認
 func TestRun_ErrorRateAlwaysOne_ReturnsInjectedError(t *testing.T) {
 	ctx := context.Background()
@@ -127,6 +157,177 @@ func TestRun_ErrorRateIntermediate_UsesRand(t *testing.T) {
 	})
 }
 
+// FailAfter を指定した時、その時点まで負荷をかけてから ErrInjectedMidRun で失敗することを確認
+func TestRunWithResult_FailAfter(t *testing.T) {
+	cfg := Config{
+		Mode:        ModeCPU,
+		Duration:    5 * time.Second,
+		Parallelism: 1,
+		FailAfter:   100 * time.Millisecond,
+	}
+
+	res, err := RunWithResult(context.Background(), cfg)
+	if !errors.Is(err, ErrInjectedMidRun) || !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected ErrInjectedMidRun wrapping ErrInjected, got %v", err)
+	}
+	if res.Reason != StopFailed || !res.Interrupted() {
+		t.Fatalf("expected failed, got %+v", res)
+	}
+	if res.Elapsed < cfg.FailAfter || res.Elapsed > time.Second {
+		t.Fatalf("expected to fail shortly after %s, took %s", cfg.FailAfter, res.Elapsed)
+	}
+
+	t.Run("caller cancellation before fail_after is not a failure", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		res, err := RunWithResult(ctx, cfg)
+		if err != nil {
+			t.Fatalf("RunWithResult returned error: %v", err)
+		}
+		if res.Reason != StopCanceled {
+			t.Fatalf("expected canceled, got %+v", res)
+		}
+	})
+
+	t.Run("fail_after must be < duration", func(t *testing.T) {
+		for _, d := range []time.Duration{-time.Millisecond, cfg.Duration, cfg.Duration + time.Second} {
+			c := cfg
+			c.FailAfter = d
+			if err := Validate(c); err == nil {
+				t.Fatalf("expected error for fail_after=%s, got nil", d)
+			}
+		}
+	})
+}
+
+// watchdog は猶予を過ぎても終わらないワーカーを待たずに戻り、猶予は Duration の倍率と最短の猶予の大きい方になることを確認
+func TestWatchdog(t *testing.T) {
+	t.Run("waitWorkers gives up after deadline", func(t *testing.T) {
+		var g errgroup.Group
+		block := make(chan struct{})
+		defer close(block)
+		g.Go(func() error { <-block; return nil }) // 終わらないワーカー
+
+		start := time.Now()
+		if done, _ := waitWorkers(&g, start.Add(50*time.Millisecond), nil); done {
+			t.Fatalf("expected waitWorkers to give up")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("expected to give up shortly after the deadline, took %s", elapsed)
+		}
+	})
+
+	t.Run("waitWorkers returns when workers finish", func(t *testing.T) {
+		var g errgroup.Group
+		g.Go(func() error { return errors.New("boom") })
+		if done, err := waitWorkers(&g, time.Now().Add(time.Second), nil); !done || err == nil {
+			t.Fatalf("expected the worker error, got done=%v err=%v", done, err)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		start := time.Now()
+		tests := []struct {
+			duration time.Duration
+			grace    float64
+			want     time.Duration
+		}{
+			{duration: 10 * time.Second, grace: 2, want: 20 * time.Second},
+			{duration: 100 * time.Millisecond, grace: 2, want: 100*time.Millisecond + minWatchdogSlack},
+		}
+		for _, tt := range tests {
+			got := Config{Duration: tt.duration, WatchdogGrace: tt.grace}.watchdogDeadline(start)
+			if got.Sub(start) != tt.want {
+				t.Fatalf("duration=%s grace=%v: got %s, want %s", tt.duration, tt.grace, got.Sub(start), tt.want)
+			}
+		}
+		if got := (Config{Duration: time.Second}).watchdogDeadline(start); !got.IsZero() {
+			t.Fatalf("expected no deadline when disabled, got %s", got)
+		}
+	})
+
+	t.Run("grace must be 0 or >= 1", func(t *testing.T) {
+		for _, g := range []float64{-1, 0.5, math.NaN()} {
+			cfg := Config{Mode: ModeCPU, Duration: time.Second, Parallelism: 1, WatchdogGrace: g}
+			if err := Validate(cfg); err == nil {
+				t.Fatalf("expected error for watchdog_grace=%v, got nil", g)
+			}
+		}
+	})
+
+	t.Run("normal run is not killed", func(t *testing.T) {
+		cfg := Config{Mode: ModeCPU, Duration: 50 * time.Millisecond, Parallelism: 1, WatchdogGrace: DefaultWatchdogGrace}
+		res, err := RunWithResult(context.Background(), cfg)
+		if err != nil || res.Reason != StopCompleted {
+			t.Fatalf("expected completed, got %+v, %v", res, err)
+		}
+	})
+}
+
+// ワーカーごとの結果(回数・バイト数・エラー)が RunResult に集まることを確認
+func TestRunWithResult_WorkerResults(t *testing.T) {
+	t.Run("cpu-mem", func(t *testing.T) {
+		res, err := RunWithResult(context.Background(), Config{Mode: ModeCPUMem, Duration: 50 * time.Millisecond, Parallelism: 2, AllocMB: 3})
+		if err != nil {
+			t.Fatalf("RunWithResult returned error: %v", err)
+		}
+		if len(res.Workers) != 3 {
+			t.Fatalf("expected 3 workers, got %+v", res.Workers)
+		}
+		for i, w := range res.Workers {
+			if w.ID != i {
+				t.Fatalf("workers not in ID order: %+v", res.Workers)
+			}
+		}
+		if cpu := res.Sum(WorkerCPU); cpu.Iterations == 0 || cpu.Bytes != 0 {
+			t.Fatalf("unexpected cpu totals: %+v", cpu)
+		}
+		if mem := res.Sum(WorkerMem); mem.Iterations != 3 || mem.Bytes != 3<<20 {
+			t.Fatalf("unexpected mem totals: %+v", mem)
+		}
+	})
+
+	t.Run("io", func(t *testing.T) {
+		res, err := RunWithResult(context.Background(), Config{Mode: ModeIO, Duration: 50 * time.Millisecond, IOBytes: 4096, IODir: t.TempDir()})
+		if err != nil {
+			t.Fatalf("RunWithResult returned error: %v", err)
+		}
+		// 最後の 1 回は書き込みの途中で終わることがあるため、バイト数は回数 × io_bytes 以上
+		if w := res.Sum(WorkerIO); w.Iterations == 0 || w.Bytes < w.Iterations*4096 {
+			t.Fatalf("unexpected io totals: %+v", w)
+		}
+	})
+
+	// cold は領域を替えながら同期した領域を読み戻す。読み戻しは書き込みを終えた回数分以上
+	t.Run("io cold", func(t *testing.T) {
+		if !ioColdCacheSupported {
+			t.Skip("io_cache=cold is not supported on this platform")
+		}
+		res, err := RunWithResult(context.Background(), Config{Mode: ModeIO, Duration: 50 * time.Millisecond, IOBytes: 4096, IODir: t.TempDir(), IOCache: IOCacheCold})
+		if err != nil {
+			t.Fatalf("RunWithResult returned error: %v", err)
+		}
+		if w := res.Sum(WorkerIO); w.Iterations == 0 || w.ReadBytes < w.Iterations*4096 {
+			t.Fatalf("unexpected io totals: %+v", w)
+		}
+	})
+
+	t.Run("worker error fails the run", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "missing")
+		res, err := RunWithResult(context.Background(), Config{Mode: ModeIO, Duration: 5 * time.Second, IOBytes: 4096, IODir: dir})
+		if err == nil || res.Reason != StopFailed {
+			t.Fatalf("expected failed with error, got %+v, %v", res, err)
+		}
+		if len(res.Workers) != 1 || res.Workers[0].Err == nil {
+			t.Fatalf("expected the worker error in results, got %+v", res.Workers)
+		}
+		if res.Elapsed > time.Second {
+			t.Fatalf("expected prompt return on worker error, took %s", res.Elapsed)
+		}
+	})
+}
+
 // Latency,ErrorRateのバリデーションとModeIOの必須パラメータを確認
 func TestValidateConfig_InvalidLatencyAndErrorRateAndIOMode(t *testing.T) {
 	t.Run("negative latency is invalid", func(t *testing.T) {
@@ -136,7 +337,7 @@ func TestValidateConfig_InvalidLatencyAndErrorRateAndIOMode(t *testing.T) {
 			Parallelism: 1,
 			Latency:     -1 * time.Millisecond,
 		}
-		if err := validateConfig(cfg, DefaultLimits); err == nil {
+		if err := validateConfig(cfg, DefaultLimitProfiles().For(cfg.Mode)); err == nil {
 			t.Fatalf("expected error for negative latency, got nil")
 		}
 	})
@@ -148,7 +349,7 @@ func TestValidateConfig_InvalidLatencyAndErrorRateAndIOMode(t *testing.T) {
 			Parallelism: 1,
 			ErrorRate:   -0.1,
 		}
-		if err := validateConfig(cfg, DefaultLimits); err == nil {
+		if err := validateConfig(cfg, DefaultLimitProfiles().For(cfg.Mode)); err == nil {
 			t.Fatalf("expected error for error_rate < 0, got nil")
 		}
 	})
@@ -160,7 +361,7 @@ func TestValidateConfig_InvalidLatencyAndErrorRateAndIOMode(t *testing.T) {
 			Parallelism: 1,
 			ErrorRate:   1.1,
 		}
-		if err := validateConfig(cfg, DefaultLimits); err == nil {
+		if err := validateConfig(cfg, DefaultLimitProfiles().For(cfg.Mode)); err == nil {
 			t.Fatalf("expected error for error_rate > 1, got nil")
 		}
 	})
@@ -171,8 +372,193 @@ func TestValidateConfig_InvalidLatencyAndErrorRateAndIOMode(t *testing.T) {
 			Duration: time.Second,
 			IOBytes:  0,
 		}
-		if err := validateConfig(cfg, DefaultLimits); err == nil {
+		if err := validateConfig(cfg, DefaultLimitProfiles().For(cfg.Mode)); err == nil {
 			t.Fatalf("expected error for io_bytes <= 0 in io mode, got nil")
 		}
 	})
 }
+
+// Durationを使い切った場合はcompleted、呼び出し元のキャンセルではcanceledになることを確認
+func TestRunWithResult_StopReason(t *testing.T) {
+	cfg := Config{
+		Mode:     ModeMem,
+		Duration: 50 * time.Millisecond,
+		AllocMB:  1,
+	}
+
+	t.Run("completed", func(t *testing.T) {
+		res, err := RunWithResult(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("RunWithResult returned error: %v", err)
+		}
+		if res.Reason != StopCompleted || res.Interrupted() {
+			t.Fatalf("expected completed, got %+v", res)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		long := cfg
+		long.Duration = 5 * time.Second
+
+		res, err := RunWithResult(ctx, long)
+		if err != nil {
+			t.Fatalf("RunWithResult returned error: %v", err)
+		}
+		if res.Reason != StopCanceled || !res.Interrupted() {
+			t.Fatalf("expected canceled, got %+v", res)
+		}
+		if res.Elapsed > time.Second {
+			t.Fatalf("expected prompt return on cancellation, took %s", res.Elapsed)
+		}
+	})
+}
+
+// モードごとに関係する上限だけが効き、Config.Limits で上書きできることを確認
+func TestValidate_LimitProfiles(t *testing.T) {
+	mem := DefaultLimitProfiles().For(ModeMem)
+
+	// cpu モードは alloc_mb を使わないため、mem の上限を超えていても拒否しない
+	cpu := Config{Mode: ModeCPU, Duration: time.Second, Parallelism: 1, AllocMB: mem.MaxAllocMB + 1}
+	if err := Validate(cpu); err != nil {
+		t.Fatalf("cpu mode with large alloc_mb: %v", err)
+	}
+
+	big := Config{Mode: ModeMem, Duration: time.Second, AllocMB: mem.MaxAllocMB + 1}
+	if err := Validate(big); !errors.Is(err, ErrAllocTooLarge) {
+		t.Fatalf("mem mode over default cap: err = %v, want ErrAllocTooLarge", err)
+	}
+
+	raised := mem
+	raised.MaxAllocMB = mem.MaxAllocMB * 2
+	big.Limits = &raised
+	if err := Validate(big); err != nil {
+		t.Fatalf("mem mode with raised cap: %v", err)
+	}
+}
+
+// Config.Limits が nil の時は ctx の LimitProfiles で検証し、Config.Limits があればそちらを優先することを確認
+func TestRunWithResult_LimitProfilesFromContext(t *testing.T) {
+	profiles := DefaultLimitProfiles()
+	cpu := profiles.For(ModeCPU)
+	cpu.MaxDuration = 10 * time.Millisecond
+	profiles[ModeCPU] = cpu
+	ctx := WithLimitProfiles(context.Background(), profiles)
+
+	cfg := Config{Mode: ModeCPU, Duration: 50 * time.Millisecond, Parallelism: 1}
+	if _, err := RunWithResult(ctx, cfg); !errors.Is(err, ErrDurationTooLarge) {
+		t.Fatalf("context limits: err = %v, want ErrDurationTooLarge", err)
+	}
+
+	explicit := DefaultLimitProfiles().For(ModeCPU)
+	cfg.Limits = &explicit
+	if _, err := RunWithResult(ctx, cfg); err != nil {
+		t.Fatalf("explicit limits should take precedence: %v", err)
+	}
+}
+
+func TestLimitProfiles_Validate(t *testing.T) {
+	if err := DefaultLimitProfiles().Validate(); err != nil {
+		t.Fatalf("default profiles: %v", err)
+	}
+	if err := (LimitProfiles{"gpu": {}}).Validate(); !errors.Is(err, ErrInvalidMode) {
+		t.Errorf("unknown mode: err = %v, want ErrInvalidMode", err)
+	}
+	if err := (LimitProfiles{ModeIO: {MaxIOBytes: -1}}).Validate(); err == nil {
+		t.Error("negative limit: want error")
+	}
+}
+
+// ワーカーの goroutine に request_id / mode の pprof ラベルが付くことを確認
+func TestRunWithResult_PprofLabels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = RunWithResult(ctx, Config{Mode: ModeCPU, Duration: 5 * time.Second, Parallelism: 1, RequestID: "req-label"})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		var buf bytes.Buffer
+		if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+			t.Fatalf("goroutine profile: %v", err)
+		}
+		if strings.Contains(buf.String(), `"request_id":"req-label"`) && strings.Contains(buf.String(), `"mode":"cpu"`) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no goroutine labeled with request_id=req-label and mode=cpu")
+}
+
+// I/O 負荷の一時ファイル名に request_id が含まれ、パス区切りなどは置き換えられることを確認
+func TestRunWithResult_IOTempFileIncludesRequestID(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = RunWithResult(ctx, Config{Mode: ModeIO, Duration: 5 * time.Second, IOBytes: 4096, IODir: dir, RequestID: "../req/1"})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		if len(entries) > 0 {
+			if name := entries[0].Name(); !strings.HasPrefix(name, "cno-io-___req_1-") {
+				t.Fatalf("temp file name = %q, want prefix cno-io-___req_1-", name)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("io temp file was not created")
+}
+
+// io_cache は io モードでだけ warm / cold を受け付ける
+func TestValidate_IOCache(t *testing.T) {
+	base := Config{Mode: ModeIO, Duration: time.Second, IOBytes: 4096}
+
+	for _, c := range []IOCache{"", IOCacheWarm} {
+		cfg := base
+		cfg.IOCache = c
+		if err := Validate(cfg); err != nil {
+			t.Fatalf("io_cache %q: unexpected error %v", c, err)
+		}
+	}
+
+	cfg := base
+	cfg.IOCache = "hot"
+	if err := Validate(cfg); err == nil {
+		t.Fatalf("expected error for unknown io_cache")
+	}
+
+	cfg = Config{Mode: ModeCPU, Duration: time.Second, Parallelism: 1, IOCache: IOCacheCold}
+	if err := Validate(cfg); err == nil {
+		t.Fatalf("expected error for io_cache=cold with cpu mode")
+	}
+
+	cfg = base
+	cfg.IOCache = IOCacheCold
+	if err := Validate(cfg); ioColdCacheSupported != (err == nil) {
+		t.Fatalf("io_cache=cold: err = %v, supported = %v", err, ioColdCacheSupported)
+	}
+}
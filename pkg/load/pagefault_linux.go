@@ -0,0 +1,203 @@
+//go:build linux
+
+package load
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// メモリの空き容量を読むファイル(テストで差し替える)
+var (
+	procMeminfoPath = "/proc/meminfo"
+	cgroupRoot      = "/sys/fs/cgroup"
+)
+
+// pageFaultReserveBytes はページフォルト負荷の後もノード(または cgroup)に残しておくメモリと swap の量。
+// 領域が RAM と swap の空きを使い切ると OOM killer が動くため、その手前で拒否する
+const pageFaultReserveBytes = 256 << 20
+
+// checkPageFaultBudget は allocMB MiB の領域を RAM と swap の空きで賄えるかを確認する。
+// RAM より大きい領域は swap があって初めてページアウト/イン(major fault)になり、swap がなければ OOM になるため
+func checkPageFaultBudget(allocMB int) error {
+	ram, swap, err := memoryBudget()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPageFaultUnsupported, err)
+	}
+	need := int64(allocMB) << 20
+	if need > ram+swap-pageFaultReserveBytes {
+		return fmt.Errorf("load: alloc_mb %d does not fit in available memory %dMiB + swap %dMiB (reserve %dMiB)",
+			allocMB, ram>>20, swap>>20, pageFaultReserveBytes>>20)
+	}
+	return nil
+}
+
+// memoryBudget は使える RAM と swap の空き(バイト)を返す。
+// /proc/meminfo の MemAvailable / SwapFree を、cgroup v2 の memory.max / memory.swap.max があればその残りで制限する
+func memoryBudget() (ram, swap int64, err error) {
+	info, err := readMeminfo(procMeminfoPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	ram, ok := info["MemAvailable"]
+	if !ok {
+		return 0, 0, fmt.Errorf("%s: MemAvailable not found", procMeminfoPath)
+	}
+	swap = info["SwapFree"]
+
+	if left, ok := cgroupRemaining("memory.max", "memory.current"); ok {
+		ram = min(ram, left)
+	}
+	if left, ok := cgroupRemaining("memory.swap.max", "memory.swap.current"); ok {
+		swap = min(swap, left)
+	}
+	return ram, swap, nil
+}
+
+// readMeminfo は /proc/meminfo の "Key: 123 kB" をバイトに変換して返す
+func readMeminfo(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	out := make(map[string]int64)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, rest, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			n <<= 10
+		}
+		out[key] = n
+	}
+	return out, sc.Err()
+}
+
+// cgroupRemaining は cgroup v2 の上限(maxFile)から現在の使用量(currentFile)を引いた残りを返す。
+// cgroup v2 でない、または上限が "max"(無制限)なら ok=false
+func cgroupRemaining(maxFile, currentFile string) (int64, bool) {
+	limit, ok := readCgroupValue(maxFile)
+	if !ok {
+		return 0, false
+	}
+	current, _ := readCgroupValue(currentFile)
+	return max(limit-current, 0), true
+}
+
+func readCgroupValue(name string) (int64, bool) {
+	b, err := os.ReadFile(filepath.Join(cgroupRoot, name))
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// pageFaultWorker は allocMB MiB の匿名領域を確保し、ページをランダムな順に書き換え続けてページフォルトを起こす。
+// 領域は MAP_NORESERVE で確保してコミットせず、触れたページだけが実メモリになる。
+// 1 周するごとに MADV_PAGEOUT で領域を swap に追い出し(swap がなければ MADV_DONTNEED で捨て)、
+// 次の周で再びフォルトさせる。RAM より大きい領域ではカーネルの回収も加わり、swap のページアウト/インが続く。
+// 一時停止中は領域を確保したまま書き換えを止める。
+// フォルトの回数は return 後の defer で埋めるため、結果は名前付きの戻り値にする
+func pageFaultWorker(ctx context.Context, allocMB int) (r WorkerResult) {
+	size := allocMB << 20
+	region, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_NORESERVE)
+	if err != nil {
+		r.Err = fmt.Errorf("load: page-fault worker: mmap: %w", err)
+		return r
+	}
+	defer func() {
+		_ = unix.Munmap(region)
+	}()
+	// 先読みでまとめてフォルトが解消されないよう、ランダムアクセスであることを伝える
+	_ = unix.Madvise(region, unix.MADV_RANDOM)
+
+	// フォルトの回数はスレッド単位で数えるため、計測中は OS スレッドを固定する
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var before unix.Rusage
+	_ = unix.Getrusage(unix.RUSAGE_THREAD, &before)
+	defer func() {
+		var after unix.Rusage
+		if unix.Getrusage(unix.RUSAGE_THREAD, &after) == nil {
+			r.MinorFaults = int64(after.Minflt - before.Minflt)
+			r.MajorFaults = int64(after.Majflt - before.Majflt)
+		}
+	}()
+
+	// swap がなければ MADV_PAGEOUT は何もしないため、捨てて次の周で zero-fill のフォルトを起こす
+	evict := unix.MADV_DONTNEED
+	if info, err := readMeminfo(procMeminfoPath); err == nil && info["SwapTotal"] > 0 {
+		evict = unix.MADV_PAGEOUT
+	}
+
+	pageSize := os.Getpagesize()
+	pages := size / pageSize
+	stride := coprimeStride(pages)
+	r.Bytes = int64(size)
+	gate := pauseGateFromContext(ctx)
+
+	for pass := 0; ; pass++ {
+		idx := 0
+		for i := 0; i < pages; i++ {
+			if i%1024 == 0 {
+				gate.wait(ctx)
+				if ctx.Err() != nil {
+					return r
+				}
+			}
+			// 書き込んで dirty にし、回収時に捨てられず swap に書き出されるようにする
+			region[idx*pageSize] = byte(pass + 1)
+			idx = (idx + stride) % pages
+			r.Iterations++
+		}
+		if unix.Madvise(region, evict) != nil {
+			_ = unix.Madvise(region, unix.MADV_DONTNEED)
+		}
+	}
+}
+
+// coprimeStride は n と互いに素な、n の黄金比付近の歩幅を返す。(i*stride) % n で全ページを 1 回ずつ飛び飛びに巡回するため
+func coprimeStride(n int) int {
+	if n <= 2 {
+		return 1
+	}
+	s := int(float64(n)/math.Phi) | 1
+	for gcd(s, n) != 1 {
+		s += 2
+	}
+	return s
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
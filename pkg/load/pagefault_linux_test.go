@@ -0,0 +1,96 @@
+//go:build linux
+
+package load
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeMemory は /proc/meminfo と cgroup のファイルを一時ディレクトリのものに差し替える
+func fakeMemory(t *testing.T, meminfo string, cgroup map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "meminfo")
+	if err := os.WriteFile(path, []byte(meminfo), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range cgroup {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(v+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldPath, oldRoot := procMeminfoPath, cgroupRoot
+	procMeminfoPath, cgroupRoot = path, dir
+	t.Cleanup(func() { procMeminfoPath, cgroupRoot = oldPath, oldRoot })
+}
+
+// RAM と swap の空き(cgroup の上限があればその残り)に収まらない領域を拒否することを確認
+func TestCheckPageFaultBudget(t *testing.T) {
+	const meminfo = "MemTotal:  8388608 kB\nMemAvailable:  4194304 kB\nSwapTotal:  2097152 kB\nSwapFree:  2097152 kB\n"
+
+	tests := []struct {
+		name    string
+		cgroup  map[string]string
+		allocMB int
+		wantErr bool
+	}{
+		{name: "fits in ram", allocMB: 1024},
+		{name: "larger than ram fits with swap", allocMB: 5120},
+		{name: "exceeds ram and swap", allocMB: 6 * 1024, wantErr: true},
+		{
+			name:    "cgroup memory limit",
+			cgroup:  map[string]string{"memory.max": "1073741824", "memory.current": "536870912", "memory.swap.max": "0"},
+			allocMB: 512,
+			wantErr: true,
+		},
+		{
+			name:    "unlimited cgroup",
+			cgroup:  map[string]string{"memory.max": "max", "memory.swap.max": "max"},
+			allocMB: 5120,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeMemory(t, meminfo, tt.cgroup)
+			err := checkPageFaultBudget(tt.allocMB)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkPageFaultBudget(%d) = %v, wantErr %v", tt.allocMB, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 領域の全ページを巡回してフォルトを起こし、1 周ごとに追い出して再びフォルトさせることを確認
+func TestRunWithResult_PageFault(t *testing.T) {
+	res, err := RunWithResult(context.Background(), Config{Mode: ModePageFault, Duration: 200 * time.Millisecond, AllocMB: 4})
+	if err != nil {
+		t.Fatalf("RunWithResult returned error: %v", err)
+	}
+	w := res.Sum(WorkerPageFault)
+	pages := int64(4<<20) / int64(os.Getpagesize())
+	if w.Iterations <= pages {
+		t.Fatalf("expected more than one pass over %d pages, got %+v", pages, w)
+	}
+	if w.MinorFaults+w.MajorFaults <= pages {
+		t.Fatalf("expected pages to fault again after eviction, got %+v", w)
+	}
+}
+
+func TestCoprimeStride(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 1024, 1000, 262144} {
+		s := coprimeStride(n)
+		seen := make(map[int]bool, n)
+		idx := 0
+		for range n {
+			seen[idx] = true
+			idx = (idx + s) % n
+		}
+		if len(seen) != n {
+			t.Fatalf("n=%d stride=%d visited %d pages", n, s, len(seen))
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package load
+
+import "context"
+
+// checkPageFaultBudget は Linux 以外ではページフォルト負荷に対応していないため常にエラーを返す
+func checkPageFaultBudget(int) error {
+	return ErrPageFaultUnsupported
+}
+
+func pageFaultWorker(context.Context, int) WorkerResult {
+	return WorkerResult{Err: ErrPageFaultUnsupported}
+}
@@ -0,0 +1,160 @@
+package load
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PauseGate は実行中の負荷を一時停止/再開するゲート。WithPause で Run の ctx に載せる。
+// 一時停止中のワーカーは確保したメモリや一時ファイルを持ったまま止まるため、
+// 「メモリは確保されているが CPU は使っていない」状態を負荷の完了と区別して作れる。
+// 止まっていた時間は Duration / FailAfter / watchdog の期限に数えない(再開すると残りの時間だけ負荷をかける)
+type PauseGate struct {
+	// paused はワーカーのループで毎回見るため、ロックを取らずに読めるようにする
+	paused atomic.Bool
+
+	mu sync.Mutex
+	// since は今回一時停止した時刻
+	since time.Time
+	// total はこれまでに一時停止していた時間の合計(今回の停止は含まない)
+	total time.Duration
+	// changed は Pause / Resume のたびに close して作り直す。待っている側を起こすために使う
+	changed chan struct{}
+}
+
+// NewPauseGate は再開した状態の PauseGate を返す
+func NewPauseGate() *PauseGate {
+	return &PauseGate{changed: make(chan struct{})}
+}
+
+// Pause は負荷を一時停止する。既に一時停止していれば何もせず false を返す
+func (g *PauseGate) Pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused.Load() {
+		return false
+	}
+	g.since = time.Now()
+	g.paused.Store(true)
+	g.notifyLocked()
+	return true
+}
+
+// Resume は一時停止した負荷を再開する。一時停止していなければ何もせず false を返す
+func (g *PauseGate) Resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused.Load() {
+		return false
+	}
+	g.total += time.Since(g.since)
+	g.paused.Store(false)
+	g.notifyLocked()
+	return true
+}
+
+func (g *PauseGate) notifyLocked() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// Paused は一時停止しているかどうかを返す
+func (g *PauseGate) Paused() bool {
+	return g != nil && g.paused.Load()
+}
+
+// PausedFor はこれまでに一時停止していた時間の合計(一時停止中なら今回の分も含む)を返す
+func (g *PauseGate) PausedFor() time.Duration {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.pausedForLocked(time.Now())
+}
+
+func (g *PauseGate) pausedForLocked(now time.Time) time.Duration {
+	if g.paused.Load() {
+		return g.total + now.Sub(g.since)
+	}
+	return g.total
+}
+
+// wait は一時停止している間、再開されるか ctx が終了するまでブロックする。g が nil なら何もしない。
+// cpu ワーカーの計算ループで毎回呼ぶため、一時停止していない時はインライン展開されるチェックだけで戻る
+func (g *PauseGate) wait(ctx context.Context) {
+	if g.Paused() {
+		g.waitResume(ctx)
+	}
+}
+
+func (g *PauseGate) waitResume(ctx context.Context) {
+	for {
+		g.mu.Lock()
+		paused, changed := g.paused.Load(), g.changed
+		g.mu.Unlock()
+		if !paused {
+			return
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// afterActive は deadline に f を呼ぶタイマーを作り、止める関数を返す。
+// 呼び出した後に一時停止していた時間だけ deadline を後ろにずらし、一時停止中は期限が来ても呼ばない。g が nil なら time.AfterFunc と同じ
+func (g *PauseGate) afterActive(deadline time.Time, f func()) (stop func()) {
+	if g == nil {
+		t := time.AfterFunc(time.Until(deadline), f)
+		return func() { t.Stop() }
+	}
+	g.mu.Lock()
+	base := g.pausedForLocked(time.Now())
+	g.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			g.mu.Lock()
+			now := time.Now()
+			paused, changed := g.paused.Load(), g.changed
+			remaining := deadline.Add(g.pausedForLocked(now) - base).Sub(now)
+			g.mu.Unlock()
+
+			if !paused && remaining <= 0 {
+				f()
+				return
+			}
+			// 一時停止中はタイマーを張らず、再開(changed)を待って残りの時間を計算し直す
+			var expired <-chan time.Time
+			if !paused {
+				expired = time.After(remaining)
+			}
+			select {
+			case <-expired:
+			case <-changed:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return sync.OnceFunc(func() { close(done) })
+}
+
+type pauseGateKey struct{}
+
+// WithPause は g を ctx に載せる。Run は ctx の g で一時停止/再開できる
+func WithPause(ctx context.Context, g *PauseGate) context.Context {
+	return context.WithValue(ctx, pauseGateKey{}, g)
+}
+
+// pauseGateFromContext は WithPause で ctx に載せた PauseGate を返す。無ければ nil
+func pauseGateFromContext(ctx context.Context) *PauseGate {
+	g, _ := ctx.Value(pauseGateKey{}).(*PauseGate)
+	return g
+}
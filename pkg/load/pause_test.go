@@ -0,0 +1,82 @@
+package load
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// 一時停止中は Duration を使い切っても終わらず、再開後に残りの時間だけ負荷をかけて完了することを確認
+func TestRunWithResult_PauseExtendsDuration(t *testing.T) {
+	gate := NewPauseGate()
+	ctx := WithPause(context.Background(), gate)
+
+	type result struct {
+		res RunResult
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := RunWithResult(ctx, Config{Mode: ModeCPUMem, Duration: 200 * time.Millisecond, Parallelism: 1, AllocMB: 1, WatchdogGrace: 1})
+		done <- result{res, err}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if !gate.Pause() || gate.Pause() {
+		t.Fatal("Pause should succeed only once")
+	}
+	select {
+	case r := <-done:
+		t.Fatalf("run finished while paused: %+v", r.res)
+	case <-time.After(400 * time.Millisecond):
+	}
+	if !gate.Resume() || gate.Resume() {
+		t.Fatal("Resume should succeed only once")
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil || r.res.Reason != StopCompleted {
+			t.Fatalf("RunWithResult = %+v, %v, want completed", r.res, r.err)
+		}
+		if r.res.Paused < 400*time.Millisecond || r.res.Elapsed < 600*time.Millisecond {
+			t.Fatalf("paused = %s, elapsed = %s, want paused >= 400ms and elapsed >= duration+paused", r.res.Paused, r.res.Elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not finish after Resume")
+	}
+}
+
+// 一時停止したまま開始した負荷は FailAfter も数えず、キャンセルされれば一時停止中でも終わることを確認
+func TestRunWithResult_PausedRunStopsOnCancel(t *testing.T) {
+	gate := NewPauseGate()
+	gate.Pause()
+	ctx, cancel := context.WithCancel(WithPause(context.Background(), gate))
+
+	done := make(chan error, 1)
+	var res RunResult
+	go func() {
+		var err error
+		res, err = RunWithResult(ctx, Config{Mode: ModeCPU, Duration: 100 * time.Millisecond, Parallelism: 1, FailAfter: 10 * time.Millisecond})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("run finished while paused: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if errors.Is(err, ErrInjected) || res.Reason != StopCanceled {
+			t.Fatalf("RunWithResult = %+v, %v, want canceled without fail_after", res, err)
+		}
+		if w := res.Sum(WorkerCPU); w.Iterations != 0 {
+			t.Fatalf("cpu iterations = %d, want 0 while paused", w.Iterations)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("paused run did not stop on cancel")
+	}
+}
@@ -0,0 +1,340 @@
+package load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/pprof"
+	"slices"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// WorkerKind はワーカーが使う資源の種類
+type WorkerKind string
+
+const (
+	WorkerCPU WorkerKind = "cpu"
+	WorkerMem WorkerKind = "mem"
+	WorkerIO  WorkerKind = "io"
+	// WorkerPageFault は page-fault モードのワーカー
+	WorkerPageFault WorkerKind = "page-fault"
+)
+
+// WorkerResult は 1 ワーカーの結果
+type WorkerResult struct {
+	ID   int // 起動順の番号
+	Kind WorkerKind
+	// Iterations は cpu: 計算ループの回数、mem: 確保したチャンクの数、io: io_bytes の書き込みと同期を終えた回数、page-fault: 書き換えたページ数
+	Iterations int64
+	// Bytes は mem: 確保したバイト数、io: 書き込んだバイト数、page-fault: 領域のサイズ。cpu は 0
+	Bytes int64
+	// ReadBytes は io: 書き込んだ領域を読み戻したバイト数。他のモードは 0
+	ReadBytes int64
+	// MinorFaults / MajorFaults はワーカーのスレッドで起きたページフォルトの回数(page-fault のみ)
+	MinorFaults int64
+	MajorFaults int64
+	// Err はワーカーが途中で失敗した理由(I/O 負荷の一時ファイルが作れないなど)。キャンセルによる終了はエラーにしない
+	Err error
+}
+
+// Sum は kind のワーカーの結果を合計する。ID は -1、Err は各ワーカーのエラーを errors.Join でまとめたもの
+func (r RunResult) Sum(kind WorkerKind) WorkerResult {
+	sum := WorkerResult{ID: -1, Kind: kind}
+	var errs []error
+	for _, w := range r.Workers {
+		if w.Kind != kind {
+			continue
+		}
+		sum.Iterations += w.Iterations
+		sum.Bytes += w.Bytes
+		sum.ReadBytes += w.ReadBytes
+		sum.MinorFaults += w.MinorFaults
+		sum.MajorFaults += w.MajorFaults
+		if w.Err != nil {
+			errs = append(errs, w.Err)
+		}
+	}
+	sum.Err = errors.Join(errs...)
+	return sum
+}
+
+// worker は ctx が終了するまで負荷をかけ、結果を返す。ID と Kind は runWorkers が埋める
+type worker struct {
+	kind WorkerKind
+	run  func(ctx context.Context) WorkerResult
+}
+
+// workers は cfg.Mode の負荷をかけるワーカーの一覧を返す
+func (c Config) workers() []worker {
+	var ws []worker
+	cpu := func() {
+		for i := range c.Parallelism {
+			run := cpuWorker
+			if len(c.CPUAffinity) > 0 {
+				run = pinnedWorker(c.CPUAffinity[i%len(c.CPUAffinity)], cpuWorker)
+			}
+			ws = append(ws, worker{kind: WorkerCPU, run: run})
+		}
+	}
+	mem := func() {
+		ws = append(ws, worker{kind: WorkerMem, run: func(ctx context.Context) WorkerResult {
+			return memWorker(ctx, c.AllocMB)
+		}})
+	}
+	switch c.Mode {
+	case ModeCPU:
+		cpu()
+	case ModeMem:
+		mem()
+	case ModeCPUMem:
+		cpu()
+		mem()
+	case ModeIO:
+		ws = append(ws, worker{kind: WorkerIO, run: func(ctx context.Context) WorkerResult {
+			return ioWorker(ctx, c.IODir, tempFilePattern(c.RequestID), c.IOBytes, c.IOCache)
+		}})
+	case ModePageFault:
+		ws = append(ws, worker{kind: WorkerPageFault, run: func(ctx context.Context) WorkerResult {
+			return pageFaultWorker(ctx, c.AllocMB)
+		}})
+	}
+	return ws
+}
+
+// runWorkers は cfg のワーカーを errgroup で起動し、全ワーカーの終了(または watchdog の期限)を待って結果を集める。
+// どれかのワーカーがエラーを返すと、errgroup が残りのワーカーの context をキャンセルする
+func runWorkers(ctx context.Context, cfg Config, start time.Time) (RunResult, error) {
+	ws := cfg.workers()
+	// 結果はワーカーが終了時に送る。watchdog が見限ったワーカーが後から送ってもブロックしないよう、全員分のバッファを持つ
+	results := make(chan WorkerResult, len(ws))
+
+	var g *errgroup.Group
+	// ワーカーは pprof.Do の中で起動し、ラベル(request_id, mode)を引き継がせる。
+	// 障害調査中に取ったプロファイルを、どのリクエストの負荷かで絞り込めるようにするため
+	pprof.Do(ctx, cfg.pprofLabels(), func(ctx context.Context) {
+		g, ctx = errgroup.WithContext(ctx)
+		for i, w := range ws {
+			g.Go(func() error {
+				r := w.run(ctx)
+				r.ID, r.Kind = i, w.kind
+				results <- r
+				return r.Err
+			})
+		}
+	})
+
+	// 遅いディスクで fsync が返らないなどで猶予を過ぎたら watchdog が打ち切る
+	done, err := waitWorkers(g, cfg.watchdogDeadline(start), pauseGateFromContext(ctx))
+
+	var res RunResult
+	for len(results) > 0 {
+		res.Workers = append(res.Workers, <-results)
+	}
+	slices.SortFunc(res.Workers, func(a, b WorkerResult) int { return a.ID - b.ID })
+
+	if !done {
+		return res, ErrWatchdogKilled
+	}
+	return res, err
+}
+
+// waitWorkers は g の完了を待ってそのエラーを返す。deadline を過ぎたら待つのをやめて done=false を返す。deadline がゼロ値なら無期限に待つ。
+// gate で一時停止していた時間だけ deadline を遅らせる(一時停止中のワーカーを見限らない)。
+// Go ではゴルーチンを外から止められないため、見限ったワーカーはブロックしている処理から戻った時点で
+// (context は既に終了しているので)自分で終了する。それまでの間も Run の呼び出し元は約束した時間で解放される
+func waitWorkers(g *errgroup.Group, deadline time.Time, gate *PauseGate) (done bool, err error) {
+	if deadline.IsZero() {
+		return true, g.Wait()
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- g.Wait()
+	}()
+	expired := make(chan struct{})
+	defer gate.afterActive(deadline, func() { close(expired) })()
+	select {
+	case err := <-errc:
+		return true, err
+	case <-expired:
+		return false, nil
+	}
+}
+
+// pinnedWorker は run を cpu のコアに固定した OS スレッドで実行する。
+// コアごとの使用率のダッシュボードや CPU Manager のデモで、特定のコアだけに負荷が偏る様子を見せるために使う
+func pinnedWorker(cpu int, run func(ctx context.Context) WorkerResult) func(ctx context.Context) WorkerResult {
+	return func(ctx context.Context) WorkerResult {
+		if err := pinCurrentThread(cpu); err != nil {
+			return WorkerResult{Err: fmt.Errorf("load: pin worker to cpu %d: %w", cpu, err)}
+		}
+		return run(ctx)
+	}
+}
+
+// cpuWorker は適度にレジスタ/キャッシュを使う軽い計算を ctx が終了するまで繰り返す。一時停止中は計算を止める
+func cpuWorker(ctx context.Context) WorkerResult {
+	var r WorkerResult
+	var x float64
+	gate := pauseGateFromContext(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return r
+		default:
+			if gate.Paused() {
+				gate.wait(ctx)
+				continue
+			}
+			x += 1.0
+			if x > 1e9 {
+				x = 0
+			}
+			r.Iterations++
+		}
+	}
+}
+
+// memWorker は allocMB MB をチャンクに分けて確保し、ctx が終了するまで保持する。
+// 確保の途中で一時停止したら、そこまで確保した分を保持したまま止まる
+func memWorker(ctx context.Context, allocMB int) WorkerResult {
+	var r WorkerResult
+	gate := pauseGateFromContext(ctx)
+
+	totalBytes := allocMB * 1024 * 1024
+	const chunk = 1 * 1024 * 1024
+	numChunks := (totalBytes + chunk - 1) / chunk
+
+	bufs := make([][]byte, 0, numChunks)
+	remaining := totalBytes
+
+	// 終了時は参照を明示的に外し、GC がすぐに回収できるようにする
+	defer func() {
+		clear(bufs)
+		bufs = nil
+	}()
+
+	for i := 0; i < numChunks; i++ {
+		gate.wait(ctx)
+		// 確保途中でキャンセルされた場合は残りを確保せずに終了する
+		if ctx.Err() != nil {
+			return r
+		}
+
+		size := chunk
+		if remaining < chunk {
+			size = remaining
+		}
+
+		b := make([]byte, size)
+
+		for j := 0; j < len(b); j += 4096 {
+			b[j] = byte(j)
+		}
+
+		bufs = append(bufs, b)
+		remaining -= size
+		r.Iterations++
+		r.Bytes += int64(size)
+	}
+	<-ctx.Done()
+	return r
+}
+
+// ioWorker は一時ファイルに対して ioBytes バイトの書き込み、同期、読み戻しを ctx が終了するまでひたすら繰り返す。
+// cache が IOCacheCold なら ioColdRegions 個の領域を順に使い、同期した領域をページキャッシュから追い出してから読み戻す。
+// 書き込み後の同期方法は OS ごとに io_*.go で Linux の fsync に近い挙動へ揃えている。
+// 一時停止中は一時ファイルを開いたまま、次の書き込みの前で止まる
+func ioWorker(ctx context.Context, dir, pattern string, ioBytes int, cache IOCache) WorkerResult {
+	var r WorkerResult
+
+	// dir が空なら OS の既定の一時ディレクトリ($TMPDIR, %TMP% など)
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		r.Err = fmt.Errorf("load: io worker: %w", err)
+		return r
+	}
+	name := f.Name()
+	// Windows では開いたままのファイルを削除できないため、必ず Close してから Remove する
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(name)
+	}()
+
+	const chunkSize = 32 * 1024
+	buf := make([]byte, chunkSize)
+
+	regions := int64(1)
+	if cache == IOCacheCold {
+		regions = ioColdRegions
+	}
+	gate := pauseGateFromContext(ctx)
+
+	for {
+		gate.wait(ctx)
+		select {
+		case <-ctx.Done():
+			return r
+		default:
+		}
+
+		// warm は毎回ファイル先頭、cold は領域を順に替えて ioBytes 分だけ書き込む
+		off := r.Iterations % regions * int64(ioBytes)
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			r.Err = fmt.Errorf("load: io worker: %w", err)
+			return r
+		}
+
+		remaining := ioBytes
+		for remaining > 0 {
+			select {
+			case <-ctx.Done():
+				return r
+			default:
+			}
+
+			n := remaining
+			if n > len(buf) {
+				n = len(buf)
+			}
+			if _, err := f.Write(buf[:n]); err != nil {
+				r.Err = fmt.Errorf("load: io worker: %w", err)
+				return r
+			}
+			remaining -= n
+			r.Bytes += int64(n)
+		}
+
+		if err := syncFile(f); err != nil {
+			r.Err = fmt.Errorf("load: io worker: %w", err)
+			return r
+		}
+		if cache == IOCacheCold {
+			if err := dropPageCache(f, off, int64(ioBytes)); err != nil {
+				r.Err = fmt.Errorf("load: io worker: drop page cache: %w", err)
+				return r
+			}
+		}
+
+		// 書き込んだ領域を読み戻す。warm ではページキャッシュに当たり、cold ではデバイスから読む
+		for read := 0; read < ioBytes; {
+			select {
+			case <-ctx.Done():
+				return r
+			default:
+			}
+
+			n, err := f.ReadAt(buf[:min(len(buf), ioBytes-read)], off+int64(read))
+			if err != nil {
+				r.Err = fmt.Errorf("load: io worker: %w", err)
+				return r
+			}
+			read += n
+			r.ReadBytes += int64(n)
+		}
+		r.Iterations++
+	}
+}
@@ -0,0 +1,164 @@
+// Package logquery は Loki の HTTP API から、request_id / trace_id などのフィールドが一致するログ行を取得する。
+// CLI の実行結果(ログに出る request_id / trace_id)から、ログ基盤に集めたサーバー/クライアントのログへ辿るために使う
+package logquery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry は Loki から取得した 1 行
+type Entry struct {
+	Time   time.Time
+	Labels map[string]string // stream のラベル
+	Line   string
+	Fields map[string]any // Line を JSON として解釈したもの。JSON でない行は nil
+}
+
+// Source はログの出所を表すラベルの値(service_name / app / container / job の順で最初に見つかったもの)
+func (e Entry) Source() string {
+	for _, k := range []string{"service_name", "app", "container", "job"} {
+		if v := e.Labels[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Query は 1 回の検索の条件
+type Query struct {
+	// Selector は stream selector(例: {service_name="cno-app"})。Loki は少なくとも 1 つの空でない matcher を要求する
+	Selector string
+	// Field / Value は JSON ログのフィールド名と一致させる値(例: request_id / 7c9e...)
+	Field string
+	Value string
+
+	Start time.Time
+	End   time.Time
+	Limit int
+}
+
+// LogQL は q を LogQL に変換する。値を含む行だけを Loki 側で絞り込み、フィールドの一致は Filter で確認する。
+// json パーサを使わないのは、JSON でない行(panic のスタックトレースなど)で __error__ が付いて落ちるのを避けるため
+func (q Query) LogQL() string {
+	return fmt.Sprintf("%s |= %s", q.Selector, strconv.Quote(q.Value))
+}
+
+// Filter は entries のうち、JSON のフィールド q.Field が q.Value と一致する行だけを返す。
+// 値が別のフィールド(parent_request_id やメッセージ本文など)に含まれているだけの行を除く
+func (q Query) Filter(entries []Entry) []Entry {
+	out := entries[:0:0]
+	for _, e := range entries {
+		if v, ok := e.Fields[q.Field]; ok && fmt.Sprint(v) == q.Value {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Client は Loki の HTTP API クライアント
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient は baseURL(例: http://localhost:3100)の Loki に問い合わせる Client を返す
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Search は GET /loki/api/v1/query_range で q に一致するログ行を取得し、時刻順に返す
+func (c *Client) Search(ctx context.Context, q Query) ([]Entry, error) {
+	if q.Selector == "" || q.Field == "" || q.Value == "" {
+		return nil, errors.New("loki: selector, field and value are required")
+	}
+	v := url.Values{}
+	v.Set("query", q.LogQL())
+	v.Set("start", strconv.FormatInt(q.Start.UnixNano(), 10))
+	v.Set("end", strconv.FormatInt(q.End.UnixNano(), 10))
+	v.Set("direction", "forward")
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/loki/api/v1/query_range?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("loki: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("loki: GET /loki/api/v1/query_range: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	entries, err := ParseQueryRange(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return q.Filter(entries), nil
+}
+
+// Loki の query_range が返す JSON(resultType=streams のみ扱う)
+type queryRangeResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][]string        `json:"values"` // [<unix ns の文字列>, <行>]
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// ParseQueryRange は query_range のレスポンスを、全 stream をまとめて時刻順に並べた Entry の一覧に変換する
+func ParseQueryRange(r io.Reader) ([]Entry, error) {
+	var resp queryRangeResponse
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("loki: decode response: %w", err)
+	}
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("loki: query status %q", resp.Status)
+	}
+	if resp.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("loki: unexpected resultType %q (want streams)", resp.Data.ResultType)
+	}
+
+	var entries []Entry
+	for _, s := range resp.Data.Result {
+		for _, v := range s.Values {
+			if len(v) < 2 {
+				continue
+			}
+			ns, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("loki: invalid timestamp %q: %w", v[0], err)
+			}
+			e := Entry{Time: time.Unix(0, ns), Labels: s.Stream, Line: v[1]}
+			var fields map[string]any
+			if json.Unmarshal([]byte(v[1]), &fields) == nil {
+				e.Fields = fields
+			}
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
@@ -0,0 +1,98 @@
+package logquery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseQueryRange(t *testing.T) {
+	f, err := os.Open("testdata/query_range.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	entries, err := ParseQueryRange(f)
+	if err != nil {
+		t.Fatalf("ParseQueryRange: %v", err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("got %d entries, want 5", len(entries))
+	}
+	// stream をまたいで時刻順に並ぶ
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Time.Before(entries[i-1].Time) {
+			t.Fatalf("entries not sorted: %s before %s", entries[i].Time, entries[i-1].Time)
+		}
+	}
+	if entries[0].Fields["msg"] != "request start" || entries[0].Source() != "cno-app" {
+		t.Fatalf("entries[0] = %+v", entries[0])
+	}
+	if entries[2].Source() != "cno-client" {
+		t.Fatalf("entries[2].Source() = %q", entries[2].Source())
+	}
+	if entries[4].Fields != nil {
+		t.Fatalf("non-JSON line parsed as fields: %v", entries[4].Fields)
+	}
+}
+
+func TestQuery_LogQL(t *testing.T) {
+	q := Query{Selector: `{service_name=~".+"}`, Field: "request_id", Value: `a"b`}
+	if got, want := q.LogQL(), `{service_name=~".+"} |= "a\"b"`; got != want {
+		t.Fatalf("LogQL() = %s, want %s", got, want)
+	}
+}
+
+// 値を含むだけの行(parent_request_id、JSON でない行)は除き、フィールドが一致する行だけを返す
+func TestClient_Search(t *testing.T) {
+	body, err := os.ReadFile("testdata/query_range.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/query_range" {
+			http.NotFound(w, r)
+			return
+		}
+		gotQuery = r.URL.Query().Get("query")
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	q := Query{Selector: `{service_name=~".+"}`, Field: "request_id", Value: "req-1", Start: now.Add(-time.Hour), End: now, Limit: 100}
+	entries, err := NewClient(srv.URL+"/").Search(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if gotQuery != q.LogQL() {
+		t.Fatalf("query = %s, want %s", gotQuery, q.LogQL())
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3: %+v", len(entries), entries)
+	}
+	for _, e := range entries {
+		if e.Fields["request_id"] != "req-1" {
+			t.Fatalf("unexpected entry %s", e.Line)
+		}
+	}
+}
+
+func TestClient_SearchError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "parse error", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	_, err := NewClient(srv.URL).Search(context.Background(), Query{Selector: "{", Field: "trace_id", Value: "x", Start: now, End: now})
+	if err == nil {
+		t.Fatal("Search: want error for 400 response")
+	}
+}
@@ -0,0 +1,84 @@
+package observability
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricType は MetricInfo の種類
+type MetricType string
+
+const (
+	MetricCounter   MetricType = "counter"
+	MetricGauge     MetricType = "gauge"
+	MetricHistogram MetricType = "histogram"
+)
+
+// MetricInfo はアプリが公開するメトリクスの名前/種類/ラベル。
+// ダッシュボードやアラートルールの生成に使い、コードとの乖離を防ぐ
+type MetricInfo struct {
+	Name    string
+	Help    string
+	Type    MetricType
+	Labels  []string
+	Buckets []float64 // histogram のみ
+}
+
+// catalog は newCounterVec などで定義したメトリクスの一覧
+var catalog []MetricInfo
+
+// Catalog は pkg/observability で定義しているメトリクスの一覧を名前順で返す
+func Catalog() []MetricInfo {
+	out := make([]MetricInfo, len(catalog))
+	copy(out, catalog)
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// LookupMetric は名前からメトリクス定義を引く
+func LookupMetric(name string) (MetricInfo, bool) {
+	for _, m := range catalog {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return MetricInfo{}, false
+}
+
+func addToCatalog(name, help string, typ MetricType, labels []string, buckets []float64) {
+	catalog = append(catalog, MetricInfo{Name: name, Help: help, Type: typ, Labels: labels, Buckets: buckets})
+}
+
+func newCounterVec(opts prometheus.CounterOpts, labels []string) *prometheus.CounterVec {
+	addToCatalog(opts.Name, opts.Help, MetricCounter, labels, nil)
+	return addCollector(prometheus.NewCounterVec(opts, labels))
+}
+
+func newGaugeVec(opts prometheus.GaugeOpts, labels []string) *prometheus.GaugeVec {
+	addToCatalog(opts.Name, opts.Help, MetricGauge, labels, nil)
+	return addCollector(prometheus.NewGaugeVec(opts, labels))
+}
+
+func newGauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	addToCatalog(opts.Name, opts.Help, MetricGauge, nil, nil)
+	return addCollector(prometheus.NewGauge(opts))
+}
+
+func newHistogramVec(opts prometheus.HistogramOpts, labels []string) *prometheus.HistogramVec {
+	addToCatalog(opts.Name, opts.Help, MetricHistogram, labels, histogramBuckets(opts.Buckets))
+	return addCollector(prometheus.NewHistogramVec(opts, labels))
+}
+
+func newHistogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	addToCatalog(opts.Name, opts.Help, MetricHistogram, nil, histogramBuckets(opts.Buckets))
+	return addCollector(prometheus.NewHistogram(opts))
+}
+
+// histogramBuckets は Buckets 未指定時に client_golang が使う DefBuckets を補う
+func histogramBuckets(b []float64) []float64 {
+	if len(b) == 0 {
+		return prometheus.DefBuckets
+	}
+	return b
+}
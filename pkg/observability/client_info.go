@@ -0,0 +1,67 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// RunIDMetadataKey はクライアント 1 回の実行を識別する run_id を運ぶ metadata キー
+	RunIDMetadataKey = "x-run-id"
+	// ClientModeMetadataKey はクライアントの実行モード(ping, do-work-unary など)を運ぶ metadata キー
+	ClientModeMetadataKey = "x-client-mode"
+
+	otherMode = "other"
+)
+
+// metricModes はメトリクスの mode ラベルとして受け入れる値(cmd/client の --mode)。
+// x-client-mode はクライアントが任意の文字列を送れるため、形式が正しくても一覧に無い値は "other" にまとめてカーディナリティを抑える。
+// cmd/client に mode を追加したらここにも追加する
+var metricModes = map[string]bool{
+	"health":         true,
+	"ping":           true,
+	"debug-echo":     true,
+	"return-code":    true,
+	"channelz":       true,
+	"do-work-unary":  true,
+	"do-work-server": true,
+	"do-work-client": true,
+	"do-work-bidi":   true,
+	"stream-storm":   true,
+	"broadcast":      true,
+	"bench":          true,
+	"loadtest":       true,
+	"soak":           true,
+	"scenario":       true,
+}
+
+// ClientUserAgent はクライアントが送る構造化 user-agent を返す。
+// gRPC は末尾に grpc-go/<version> を付け足す
+func ClientUserAgent(mode, runID string) string {
+	return fmt.Sprintf("cno-app-client/%s (mode=%s; run_id=%s)", ServiceVersion(), mode, runID)
+}
+
+// ClientInfo は incoming metadata から run_id とクライアントの mode を取り出す
+func ClientInfo(ctx context.Context) (runID, mode string) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", ""
+	}
+	if v := md.Get(RunIDMetadataKey); len(v) > 0 {
+		runID = v[0]
+	}
+	if v := md.Get(ClientModeMetadataKey); len(v) > 0 {
+		mode = v[0]
+	}
+	return runID, mode
+}
+
+// metricMode は mode をメトリクスのラベル値に正規化する
+func metricMode(mode string) string {
+	if mode == "" || metricModes[mode] {
+		return mode
+	}
+	return otherMode
+}
@@ -0,0 +1,43 @@
+package observability
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestClientInfo(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		RunIDMetadataKey, "run-1",
+		ClientModeMetadataKey, "do-work-unary",
+	))
+	runID, mode := ClientInfo(ctx)
+	if runID != "run-1" || mode != "do-work-unary" {
+		t.Fatalf("ClientInfo = (%q, %q)", runID, mode)
+	}
+
+	if runID, mode := ClientInfo(context.Background()); runID != "" || mode != "" {
+		t.Fatalf("ClientInfo without metadata = (%q, %q)", runID, mode)
+	}
+}
+
+// cmd/client の mode 以外は形式が正しくても other にまとめ、メトリクスのカーディナリティを抑えることを確認
+func TestMetricMode(t *testing.T) {
+	tests := map[string]string{
+		"":                                   "",
+		"ping":                               "ping",
+		"stream-storm":                       "stream-storm",
+		"do-work-unary":                      "do-work-unary",
+		"unary":                              otherMode,
+		"ping-0001":                          otherMode,
+		"Ping":                               otherMode,
+		"mode with spaces":                   otherMode,
+		"a-very-long-mode-name-exceeding-32": otherMode,
+	}
+	for in, want := range tests {
+		if got := metricMode(in); got != want {
+			t.Errorf("metricMode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package observability
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/stats"
+)
+
+var (
+	CNOAppGRPCConnections = newGauge(
+		prometheus.GaugeOpts{
+			Name: "cno_app_grpc_connections",
+			Help: "Number of open gRPC (HTTP/2) connections.",
+		},
+	)
+
+	CNOAppGRPCStreamsPerConnection = newHistogram(
+		prometheus.HistogramOpts{
+			Name:    "cno_app_grpc_streams_per_connection",
+			Help:    "Number of concurrent streams on the connection, observed when a new stream (RPC) starts.",
+			Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+		},
+	)
+)
+
+type connStreamsKey struct{}
+
+// ConnStreamsHandler はコネクションごとの同時ストリーム数を数える stats.Handler。
+// MaxConcurrentStreams による HTTP/2 ストリーム枯渇を可視化するために使う
+type ConnStreamsHandler struct{}
+
+// NewConnStreamsHandler は grpc.StatsHandler に渡す ConnStreamsHandler を返す
+func NewConnStreamsHandler() *ConnStreamsHandler {
+	return &ConnStreamsHandler{}
+}
+
+// TagConn はコネクション単位のストリームカウンタを context に埋め込む
+func (h *ConnStreamsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return context.WithValue(ctx, connStreamsKey{}, new(atomic.Int64))
+}
+
+// HandleConn はコネクションの開始/終了でコネクション数を増減する
+func (h *ConnStreamsHandler) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		CNOAppGRPCConnections.Inc()
+	case *stats.ConnEnd:
+		CNOAppGRPCConnections.Dec()
+	}
+}
+
+// TagRPC は何もしない(RPC の context は TagConn で埋め込んだ値を引き継ぐ)
+func (h *ConnStreamsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC は RPC の開始/終了でコネクションのストリーム数を増減し、開始時点の値を観測する
+func (h *ConnStreamsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	counter, ok := ctx.Value(connStreamsKey{}).(*atomic.Int64)
+	if !ok {
+		return
+	}
+	switch s.(type) {
+	case *stats.Begin:
+		n := counter.Add(1)
+		CNOAppGRPCStreamsPerConnection.Observe(float64(n))
+	case *stats.End:
+		counter.Add(-1)
+	}
+}
@@ -0,0 +1,118 @@
+package observability
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// ConnectivityTransition は gRPC コネクションの状態遷移 1 回分
+type ConnectivityTransition struct {
+	From connectivity.State
+	To   connectivity.State
+	At   time.Time
+	// InState は From の状態に留まっていた時間
+	InState time.Duration
+}
+
+// ConnectivityWatcher はクライアントの gRPC コネクションの状態遷移(IDLE / CONNECTING / READY / TRANSIENT_FAILURE)を
+// 監視し、遷移ごとに構造化ログと span event を出す。
+// デモ中にネットワークが不安定になった時、単なるタイムアウトではなく再接続の様子として見えるようにするため
+type ConnectivityWatcher struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	span   trace.Span
+
+	mu          sync.Mutex
+	transitions []ConnectivityTransition
+}
+
+// WatchConnectivity は conn の状態遷移の監視を goroutine で始める。
+// 遷移は "grpc.client/connectivity" span の event としても記録し、Stop で span を閉じる。
+// 最初の状態は呼び出し元で読んでから goroutine に渡す。直後の conn.Connect() と競合して IDLE -> CONNECTING を取りこぼさないため
+func WatchConnectivity(conn *grpc.ClientConn, logger *zap.SugaredLogger) *ConnectivityWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	_, span := otel.Tracer("cno-app-client").Start(ctx, "grpc.client/connectivity",
+		trace.WithAttributes(attribute.String("grpc.target", conn.Target())),
+	)
+	w := &ConnectivityWatcher{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		span:   span,
+	}
+	go w.run(ctx, conn, conn.GetState(), logger)
+	return w
+}
+
+func (w *ConnectivityWatcher) run(ctx context.Context, conn *grpc.ClientConn, state connectivity.State, logger *zap.SugaredLogger) {
+	defer close(w.done)
+
+	since := time.Now()
+	for state != connectivity.Shutdown {
+		if !conn.WaitForStateChange(ctx, state) {
+			return
+		}
+		next := conn.GetState()
+		now := time.Now()
+		t := ConnectivityTransition{From: state, To: next, At: now, InState: now.Sub(since)}
+		w.record(t)
+
+		fields := []any{
+			"target", conn.Target(),
+			"from", t.From.String(),
+			"to", t.To.String(),
+			"in_state_ms", t.InState.Milliseconds(),
+		}
+		if next == connectivity.TransientFailure {
+			logger.Warnw("grpc connectivity state changed", fields...)
+		} else {
+			logger.Infow("grpc connectivity state changed", fields...)
+		}
+		w.span.AddEvent("connectivity state changed", trace.WithTimestamp(now), trace.WithAttributes(
+			attribute.String("grpc.connectivity.from", t.From.String()),
+			attribute.String("grpc.connectivity.to", t.To.String()),
+			attribute.Int64("grpc.connectivity.in_state_ms", t.InState.Milliseconds()),
+		))
+
+		state, since = next, now
+	}
+}
+
+func (w *ConnectivityWatcher) record(t ConnectivityTransition) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.transitions = append(w.transitions, t)
+}
+
+// Transitions はこれまでに観測した状態遷移を返す
+func (w *ConnectivityWatcher) Transitions() []ConnectivityTransition {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]ConnectivityTransition(nil), w.transitions...)
+}
+
+// Stop は監視を終えて span を閉じる。conn を Close する前に呼ぶと SHUTDOWN への遷移は記録しない
+func (w *ConnectivityWatcher) Stop() {
+	w.cancel()
+	<-w.done
+
+	transitions := w.Transitions()
+	failures := 0
+	for _, t := range transitions {
+		if t.To == connectivity.TransientFailure {
+			failures++
+		}
+	}
+	w.span.SetAttributes(
+		attribute.Int("grpc.connectivity.transitions", len(transitions)),
+		attribute.Int("grpc.connectivity.transient_failures", failures),
+	)
+	w.span.End()
+}
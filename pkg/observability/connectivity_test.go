@@ -0,0 +1,94 @@
+package observability
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func waitForTransition(t *testing.T, w *ConnectivityWatcher, to connectivity.State) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, tr := range w.Transitions() {
+			if tr.To == to {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no transition to %s, got %+v", to, w.Transitions())
+}
+
+// 接続に成功すると IDLE -> CONNECTING -> READY の遷移がログに出る
+func TestWatchConnectivity_Ready(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var buf bytes.Buffer
+	w := WatchConnectivity(conn, newLogger(zapcore.AddSync(&buf)))
+	conn.Connect()
+	waitForTransition(t, w, connectivity.Ready)
+	w.Stop()
+
+	got := w.Transitions()
+	if got[0].From != connectivity.Idle || got[0].To != connectivity.Connecting {
+		t.Errorf("first transition = %s -> %s, want IDLE -> CONNECTING", got[0].From, got[0].To)
+	}
+	if !strings.Contains(buf.String(), `"to":"READY"`) {
+		t.Errorf("log does not contain READY transition: %s", buf.String())
+	}
+}
+
+// 接続に失敗すると TRANSIENT_FAILURE への遷移を warn で出す
+func TestWatchConnectivity_TransientFailure(t *testing.T) {
+	conn, err := grpc.NewClient("passthrough:///unreachable",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var buf bytes.Buffer
+	w := WatchConnectivity(conn, newLogger(zapcore.AddSync(&buf)))
+	conn.Connect()
+	waitForTransition(t, w, connectivity.TransientFailure)
+	w.Stop()
+
+	if !strings.Contains(buf.String(), `"level":"warn"`) || !strings.Contains(buf.String(), `"to":"TRANSIENT_FAILURE"`) {
+		t.Errorf("log does not contain warn TRANSIENT_FAILURE transition: %s", buf.String())
+	}
+}
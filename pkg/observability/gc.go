@@ -0,0 +1,95 @@
+package observability
+
+import (
+	"runtime/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gcSamples は gcCollector が読む runtime/metrics の名前
+var gcSamples = []string{
+	"/gc/cycles/automatic:gc-cycles",
+	"/gc/cycles/forced:gc-cycles",
+	"/gc/heap/goal:bytes",
+	"/gc/heap/live:bytes",
+	"/gc/gogc:percent",
+	"/gc/gomemlimit:bytes",
+}
+
+// gcCollector は GC の回数・heap の目標値と、その目標値を決める GOGC / GOMEMLIMIT をスクレイプ時に runtime/metrics から読む。
+// ballast(cno_app_gc_ballast_bytes)と GOMEMLIMIT で GC の頻度がどう変わるかを、同じダッシュボードで比べるために使う。
+// go_* のランタイムコレクタを無効にしていても出す
+type gcCollector struct {
+	cycles      *prometheus.Desc
+	heapGoal    *prometheus.Desc
+	heapLive    *prometheus.Desc
+	gogc        *prometheus.Desc
+	memoryLimit *prometheus.Desc
+}
+
+var _ = newGCCollector()
+
+func newGCCollector() *gcCollector {
+	desc := func(name, help string, typ MetricType, labels []string) *prometheus.Desc {
+		addToCatalog(name, help, typ, labels, nil)
+		return prometheus.NewDesc(name, help, labels, nil)
+	}
+	return addCollector(&gcCollector{
+		cycles: desc("cno_app_gc_cycles_total",
+			"Total number of completed GC cycles, by trigger (automatic from the heap goal or memory limit, or forced by runtime.GC).",
+			MetricCounter, []string{"trigger"}),
+		heapGoal: desc("cno_app_gc_heap_goal_bytes",
+			"Heap size at which the next GC cycle starts, derived from GOGC, GOMEMLIMIT and the live heap (including any ballast).",
+			MetricGauge, nil),
+		heapLive: desc("cno_app_gc_heap_live_bytes",
+			"Heap memory marked live by the previous GC cycle, including any ballast.",
+			MetricGauge, nil),
+		gogc: desc("cno_app_gc_percent",
+			"Current GOGC value; -1 when the proportional GC trigger is off (GOGC=off).",
+			MetricGauge, nil),
+		memoryLimit: desc("cno_app_gc_memory_limit_bytes",
+			"Current Go runtime soft memory limit (GOMEMLIMIT); math.MaxInt64 when unset.",
+			MetricGauge, nil),
+	})
+}
+
+func (c *gcCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cycles
+	ch <- c.heapGoal
+	ch <- c.heapLive
+	ch <- c.gogc
+	ch <- c.memoryLimit
+}
+
+func (c *gcCollector) Collect(ch chan<- prometheus.Metric) {
+	samples := make([]metrics.Sample, len(gcSamples))
+	for i, name := range gcSamples {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+
+	values := make(map[string]float64, len(samples))
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			values[s.Name] = float64(s.Value.Uint64())
+			if s.Name == "/gc/gogc:percent" {
+				// GOGC=off は uint64 の -1 として返る
+				values[s.Name] = float64(int64(s.Value.Uint64()))
+			}
+		case metrics.KindFloat64:
+			values[s.Name] = s.Value.Float64()
+		}
+	}
+	emit := func(desc *prometheus.Desc, typ prometheus.ValueType, name string, labels ...string) {
+		if v, ok := values[name]; ok {
+			ch <- prometheus.MustNewConstMetric(desc, typ, v, labels...)
+		}
+	}
+	emit(c.cycles, prometheus.CounterValue, "/gc/cycles/automatic:gc-cycles", "automatic")
+	emit(c.cycles, prometheus.CounterValue, "/gc/cycles/forced:gc-cycles", "forced")
+	emit(c.heapGoal, prometheus.GaugeValue, "/gc/heap/goal:bytes")
+	emit(c.heapLive, prometheus.GaugeValue, "/gc/heap/live:bytes")
+	emit(c.gogc, prometheus.GaugeValue, "/gc/gogc:percent")
+	emit(c.memoryLimit, prometheus.GaugeValue, "/gc/gomemlimit:bytes")
+}
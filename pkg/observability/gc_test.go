@@ -0,0 +1,37 @@
+package observability
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// runtime.GC は forced として数え、GOGC / GOMEMLIMIT はスクレイプ時の値を出すことを確認
+func TestGCCollector(t *testing.T) {
+	reg := newTestRegistry(t)
+	forced := prometheus.Labels{"trigger": "forced"}
+
+	before, ok, err := reg.Value("cno_app_gc_cycles_total", forced)
+	if err != nil || !ok {
+		t.Fatalf("cno_app_gc_cycles_total: ok=%v err=%v", ok, err)
+	}
+	runtime.GC()
+	after, _, _ := reg.Value("cno_app_gc_cycles_total", forced)
+	if after < before+1 {
+		t.Fatalf("forced gc cycles = %v after runtime.GC, want >= %v", after, before+1)
+	}
+
+	defer debug.SetGCPercent(debug.SetGCPercent(50))
+	defer debug.SetMemoryLimit(debug.SetMemoryLimit(256 << 20))
+	assertValue(t, reg, "cno_app_gc_percent", nil, 50)
+	assertValue(t, reg, "cno_app_gc_memory_limit_bytes", nil, 256<<20)
+
+	debug.SetGCPercent(-1)
+	assertValue(t, reg, "cno_app_gc_percent", nil, -1)
+
+	if goal, ok, _ := reg.Value("cno_app_gc_heap_goal_bytes", nil); !ok || goal <= 0 {
+		t.Fatalf("cno_app_gc_heap_goal_bytes = %v (ok=%v), want > 0", goal, ok)
+	}
+}
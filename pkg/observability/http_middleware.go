@@ -0,0 +1,144 @@
+package observability
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var (
+	CNOAppHTTPRequestsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "cno_app_http_requests_total",
+			Help: "Total number of HTTP requests handled by the application.",
+		},
+		[]string{"server", "method", "route", "code"},
+	)
+
+	CNOAppHTTPRequestLatency = newHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "cno_app_http_request_latency_seconds",
+			Help:    "Latency of HTTP requests.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"server", "method", "route", "code"},
+	)
+
+	CNOAppHTTPRequestsInFlight = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cno_app_http_requests_in_flight",
+			Help: "Number of in-flight HTTP requests being handled.",
+		},
+		[]string{"server"},
+	)
+)
+
+// quietHTTPPaths はスクレイプやプローブで頻繁に叩かれるパス。
+// トレースは作らず、アクセスログも Debug レベルに落とす
+var quietHTTPPaths = map[string]bool{
+	"/metrics":          true,
+	"/healthz":          true,
+	"/stats/prometheus": true,
+	"/ready":            true,
+}
+
+// WrapHTTPHandler は HTTP ハンドラ(ServeMux)を otelhttp / Prometheus メトリクス / 構造化アクセスログで包む。
+// server はリスナーを区別するラベル(metrics, admin など)
+//
+// route ラベルには ServeMux がマッチしたパターン(r.Pattern)を使い、パス由来のカーディナリティ増加を避ける
+func WrapHTTPHandler(logger *zap.SugaredLogger, server string, next http.Handler) http.Handler {
+	instrumented := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		inFlight := CNOAppHTTPRequestsInFlight.WithLabelValues(server)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		latency := time.Since(start)
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		code := strconv.Itoa(rec.status)
+
+		CNOAppHTTPRequestsTotal.WithLabelValues(server, r.Method, route, code).Inc()
+		CNOAppHTTPRequestLatency.WithLabelValues(server, r.Method, route, code).Observe(latency.Seconds())
+
+		traceID := ""
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			traceID = sc.TraceID().String()
+		}
+
+		fields := []any{
+			"server", server,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", route,
+			"code", rec.status,
+			"bytes_out", rec.bytes,
+			"latency_ms", latency.Milliseconds(),
+			"remote_addr", r.RemoteAddr,
+			"trace_id", traceID,
+		}
+		switch {
+		case rec.status >= http.StatusInternalServerError:
+			logger.Errorw("http server request", fields...)
+		case quietHTTPPaths[r.URL.Path]:
+			logger.Debugw("http server request", fields...)
+		default:
+			logger.Infow("http server request", fields...)
+		}
+	})
+
+	return otelhttp.NewHandler(instrumented, "http."+server,
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !quietHTTPPaths[r.URL.Path]
+		}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "http." + server + " " + r.Method + " " + r.URL.Path
+		}),
+	)
+}
+
+// statusRecorder はレスポンスのステータスコードと書き込みバイト数を記録する
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Flush は pprof など Flusher を必要とするハンドラ向けに委譲する
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap は http.ResponseController から元の ResponseWriter を辿れるようにする
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,99 @@
+package observability
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// InFlightRequest は実行中の RPC 1 件分の情報
+type InFlightRequest struct {
+	Method    string    `json:"method"`
+	Kind      string    `json:"kind"` // unary / stream
+	StartedAt time.Time `json:"started_at"`
+	ElapsedMs int64     `json:"elapsed_ms"`
+	RequestID string    `json:"request_id,omitempty"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	Mode      string    `json:"mode,omitempty"`
+	RunID     string    `json:"run_id,omitempty"`
+}
+
+// InFlightRegistry は interceptor が登録/削除する実行中 RPC の一覧。
+// 詰まっているリクエストを admin エンドポイントから特定し、trace_id でトレースへ辿れるようにする
+type InFlightRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	reqs   map[uint64]InFlightRequest
+}
+
+// NewInFlightRegistry は空の InFlightRegistry を返す
+func NewInFlightRegistry() *InFlightRegistry {
+	return &InFlightRegistry{reqs: make(map[uint64]InFlightRequest)}
+}
+
+// track は ctx の RPC を登録し、終了時に呼ぶ削除関数を返す
+func (r *InFlightRegistry) track(ctx context.Context, method, kind string) func() {
+	req := InFlightRequest{
+		Method:    method,
+		Kind:      kind,
+		StartedAt: time.Now(),
+		TraceID:   traceIDFromContext(ctx),
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("x-request-id"); len(vals) > 0 {
+			req.RequestID = vals[0]
+		}
+	}
+	req.RunID, req.Mode = ClientInfo(ctx)
+
+	r.mu.Lock()
+	r.nextID++
+	id := r.nextID
+	r.reqs[id] = req
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		delete(r.reqs, id)
+		r.mu.Unlock()
+	}
+}
+
+// Snapshot は実行中の RPC を経過時間の長い順に返す
+func (r *InFlightRegistry) Snapshot() []InFlightRequest {
+	now := time.Now()
+
+	r.mu.Lock()
+	out := make([]InFlightRequest, 0, len(r.reqs))
+	for _, req := range r.reqs {
+		req.ElapsedMs = now.Sub(req.StartedAt).Milliseconds()
+		out = append(out, req)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+// UnaryInFlightInterceptor は Unary RPC を実行中の間 r に登録する。
+// request_id を拾えるよう UnaryLoggingInterceptor より後ろに置く
+func UnaryInFlightInterceptor(r *InFlightRegistry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		done := r.track(ctx, info.FullMethod, "unary")
+		defer done()
+		return handler(ctx, req)
+	}
+}
+
+// StreamInFlightInterceptor は Streaming RPC を実行中の間 r に登録する
+func StreamInFlightInterceptor(r *InFlightRegistry) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done := r.track(ss.Context(), info.FullMethod, "stream")
+		defer done()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,37 @@
+package observability
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// 実行中の RPC だけが request_id / run_id 付きで一覧に載り、終了すると消えることを確認
+func TestInFlightRegistry_TracksUntilHandlerReturns(t *testing.T) {
+	r := NewInFlightRegistry()
+	i := UnaryInFlightInterceptor(r)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"x-request-id", "req-1",
+		RunIDMetadataKey, "run-1",
+	))
+	info := &grpc.UnaryServerInfo{FullMethod: "/observability.grpcburner.v1.Burner/DoWork"}
+
+	var during []InFlightRequest
+	_, _ = i(ctx, nil, info, func(context.Context, any) (any, error) {
+		during = r.Snapshot()
+		return nil, nil
+	})
+
+	if len(during) != 1 {
+		t.Fatalf("in-flight during handler = %d, want 1", len(during))
+	}
+	got := during[0]
+	if got.Method != info.FullMethod || got.Kind != "unary" || got.RequestID != "req-1" || got.RunID != "run-1" {
+		t.Fatalf("in-flight entry = %+v", got)
+	}
+	if after := r.Snapshot(); len(after) != 0 {
+		t.Fatalf("in-flight after handler = %+v, want empty", after)
+	}
+}
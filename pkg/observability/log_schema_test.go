@@ -0,0 +1,178 @@
+package observability
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// go test ./pkg/observability -run TestLogSchema -update でゴールデンファイルを更新する
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// ログ基盤のパーサーが依存する JSON ログのフィールド名と型を、ゴールデンファイルで固定する。
+// 値(時刻やレイテンシ)は実行ごとに変わるため、キーと JSON の型だけを比較する
+func TestLogSchema(t *testing.T) {
+	unaryInfo := &grpc.UnaryServerInfo{FullMethod: "/observability.grpcburner.v1.Burner/DoWork"}
+	streamInfo := &grpc.StreamServerInfo{FullMethod: "/observability.grpcburner.v1.Burner/DoWorkBidiStreaming"}
+	req := &grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: &grpcburnerv1.WorkConfig{DurationMs: 100}}
+	resp := &grpcburnerv1.DoWorkResponse{RequestId: "req-1", Ok: true}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"x-request-id", "req-1",
+		RunIDMetadataKey, "run-1",
+		ClientModeMetadataKey, "do-work-unary",
+		"user-agent", "cno-app-client/dev grpc-go/1.77.0",
+		":authority", "cno-app:8080",
+	))
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 54321}})
+	payloadCfg := PayloadLogConfig{SampleRate: 1, MaxBytes: 4096}
+
+	tests := []struct {
+		name string
+		emit func(buf *bytes.Buffer)
+	}{
+		{
+			name: "unary_ok",
+			emit: func(buf *bytes.Buffer) {
+				i := UnaryLoggingInterceptor(newLogger(zapcore.AddSync(buf)))
+				_, _ = i(ctx, req, unaryInfo, func(context.Context, any) (any, error) { return resp, nil })
+			},
+		},
+		{
+			name: "unary_error",
+			emit: func(buf *bytes.Buffer) {
+				i := UnaryLoggingInterceptor(newLogger(zapcore.AddSync(buf)))
+				_, _ = i(ctx, req, unaryInfo, func(context.Context, any) (any, error) {
+					return nil, status.Error(codes.Internal, "boom")
+				})
+			},
+		},
+		{
+			name: "stream_ok",
+			emit: func(buf *bytes.Buffer) {
+				i := StreamLoggingInterceptor(newLogger(zapcore.AddSync(buf)))
+				_ = i(nil, &fakeServerStream{ctx: ctx}, streamInfo, func(_ any, ss grpc.ServerStream) error {
+					return ss.SendMsg(resp)
+				})
+			},
+		},
+		{
+			name: "stream_error",
+			emit: func(buf *bytes.Buffer) {
+				i := StreamLoggingInterceptor(newLogger(zapcore.AddSync(buf)))
+				_ = i(nil, &fakeServerStream{ctx: ctx}, streamInfo, func(any, grpc.ServerStream) error {
+					return status.Error(codes.ResourceExhausted, "limit")
+				})
+			},
+		},
+		{
+			name: "payload_unary",
+			emit: func(buf *bytes.Buffer) {
+				i := UnaryPayloadLoggingInterceptor(newLogger(zapcore.AddSync(buf)), payloadCfg)
+				_, _ = i(ctx, req, unaryInfo, func(context.Context, any) (any, error) { return resp, nil })
+			},
+		},
+		{
+			name: "payload_stream",
+			emit: func(buf *bytes.Buffer) {
+				i := StreamPayloadLoggingInterceptor(newLogger(zapcore.AddSync(buf)), payloadCfg)
+				_ = i(nil, &fakeServerStream{ctx: ctx}, streamInfo, func(_ any, ss grpc.ServerStream) error {
+					return ss.SendMsg(resp)
+				})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.emit(&buf)
+
+			got := logSchema(t, buf.Bytes())
+			path := filepath.Join("testdata", "log_schema", tt.name+".golden")
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden (run with -update to create): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("log schema changed; if intended, run with -update.\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// logSchema は 1 行の JSON ログを「キー: JSON の型」の一覧に変換する
+func logSchema(t *testing.T, line []byte) []byte {
+	t.Helper()
+
+	lines := bytes.Split(bytes.TrimSpace(line), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("expected exactly one log line, got %d:\n%s", len(lines), line)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(lines[0], &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v\n%s", err, line)
+	}
+
+	keys := make([]string, 0, len(entry))
+	for k := range entry {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var out bytes.Buffer
+	for _, k := range keys {
+		out.WriteString(k + ": " + jsonType(entry[k]) + "\n")
+	}
+	return out.Bytes()
+}
+
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// fakeServerStream はストリーム系 interceptor のテスト用の最小限の grpc.ServerStream
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+func (s *fakeServerStream) SendMsg(any) error        { return nil }
+func (s *fakeServerStream) RecvMsg(any) error        { return nil }
@@ -2,6 +2,10 @@ package observability
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,14 +15,67 @@ import (
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
 )
 
+// envLogClockSkew はログのタイムスタンプだけをずらすデバッグ用の環境変数(例: "-3s", "2m")。
+// ノード間の時計のずれ(clock skew)でログとトレースの相関が崩れる様子を再現するためのもので、
+// トレース(span)の時刻は変えない
+const envLogClockSkew = "CNO_APP_DEBUG_LOG_CLOCK_SKEW"
+
+// maxLogClockSkew は CNO_APP_DEBUG_LOG_CLOCK_SKEW に指定できるずれの上限(絶対値)
+const maxLogClockSkew = 24 * time.Hour
+
+var warnClockSkewOnce sync.Once
+
+// ValidateLoggerEnv はロガーが読む環境変数(CNO_APP_DEBUG_LOG_CLOCK_SKEW)を検証する。
+// ロガーは不正な値を warn ログに出して無視するため、起動前に検出したい場合に使う
+func ValidateLoggerEnv() error {
+	_, err := logClockSkewFromEnv()
+	return err
+}
+
+// logClockSkewFromEnv は CNO_APP_DEBUG_LOG_CLOCK_SKEW を読み取る。未設定なら 0
+func logClockSkewFromEnv() (time.Duration, error) {
+	v := os.Getenv(envLogClockSkew)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", envLogClockSkew, v, err)
+	}
+	if d < -maxLogClockSkew || d > maxLogClockSkew {
+		return 0, fmt.Errorf("%s must be within ±%s, got %s", envLogClockSkew, maxLogClockSkew, d)
+	}
+	return d, nil
+}
+
 // NewLoggerはサーバー/クライアント共通で利用するJSON形式のzapロガーを返す。
 // 戻り値はSugaredLoggerにしておき、呼び出し側はInfow/Errorwなどで利用する想定
 func NewLogger() *zap.SugaredLogger {
+	return newLogger(nil)
+}
+
+// NewLoggerWithLevel は NewLogger と同じ設定で、出力レベルを level で制御するロガーを返す。
+// level.SetLevel で実行中にレベルを変更できる(SIGHUP による設定の再読み込み用)
+func NewLoggerWithLevel(level zap.AtomicLevel) *zap.SugaredLogger {
+	return newLoggerWithLevel(nil, level)
+}
+
+// newLogger は NewLogger と同じ設定のロガーを返す。
+// w を指定した場合は出力先だけを差し替える(ログスキーマのテスト用)
+func newLogger(w zapcore.WriteSyncer) *zap.SugaredLogger {
+	return newLoggerWithLevel(w, zap.NewAtomicLevelAt(zapcore.InfoLevel))
+}
+
+func newLoggerWithLevel(w zapcore.WriteSyncer, level zap.AtomicLevel) *zap.SugaredLogger {
 	cfg := zap.NewProductionConfig()
+	cfg.Level = level
 	cfg.Encoding = "json"
 	cfg.EncoderConfig.TimeKey = "ts"
 	cfg.EncoderConfig.MessageKey = "msg"
@@ -26,11 +83,36 @@ func NewLogger() *zap.SugaredLogger {
 	cfg.EncoderConfig.CallerKey = "caller"
 	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 
-	base, err := cfg.Build()
+	skew, skewErr := logClockSkewFromEnv()
+	if skew != 0 {
+		cfg.EncoderConfig.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+			zapcore.ISO8601TimeEncoder(t.Add(skew), enc)
+		}
+	}
+
+	var opts []zap.Option
+	if w != nil {
+		opts = append(opts, zap.WrapCore(func(zapcore.Core) zapcore.Core {
+			return zapcore.NewCore(zapcore.NewJSONEncoder(cfg.EncoderConfig), w, cfg.Level)
+		}))
+	}
+
+	base, err := cfg.Build(opts...)
 	if err != nil {
 		panic(err)
 	}
-	return base.Sugar()
+	logger := base.Sugar()
+
+	// 起動直後に 1 回だけ知らせる。このログ自体の ts もずれている
+	warnClockSkewOnce.Do(func() {
+		switch {
+		case skewErr != nil:
+			logger.Warnw("ignoring log clock skew setting", "err", skewErr)
+		case skew != 0:
+			logger.Warnw("log timestamps are skewed for debugging", "clock_skew", skew.String())
+		}
+	})
+	return logger
 }
 
 // UnaryLoggingInterceptorはgRPC Unary RPCのログを出力するインターセプター
@@ -39,7 +121,9 @@ func NewLogger() *zap.SugaredLogger {
 //   - grpc_method : フルメソッド名(/package/Service/Method)
 //   - trace_id : TODO(Otel導入時に span context から取得)
 //   - request_id : metadata x-request-id から取得。なければ生成してcontextに埋め込む
-//   - mode : TODO(metadata や RPCリクエストから取得予定、今は空文字)
+//   - mode : クライアントの実行モード(metadata x-client-mode)
+//   - run_id : クライアント 1 回の実行の ID(metadata x-run-id)
+//   - peer_addr / user_agent / authority : 接続元の IP:port、user-agent、:authority(peerFields を参照)
 //   - bytes_in : リクエストメッセージのバイトサイズ
 //   - bytes_out : レスポンスメッセージのバイトサイズ
 //   - latency_ms : 処理時間(ミリ秒)
@@ -55,23 +139,9 @@ func UnaryLoggingInterceptor(logger *zap.SugaredLogger) grpc.UnaryServerIntercep
 		start := time.Now()
 
 		// request_idの取得/生成
-		md, _ := metadata.FromIncomingContext(ctx)
-		requestID := ""
-		if md != nil {
-			if vals := md.Get("x-request-id"); len(vals) > 0 && vals[0] != "" {
-				requestID = vals[0]
-			}
-		}
-		if requestID == "" {
-			requestID = uuid.New().String()
-			if md == nil {
-				md = metadata.New(nil)
-			}
-			md.Set("x-request-id", requestID)
-			ctx = metadata.NewIncomingContext(ctx, md)
-		}
-		// TODO: modeはmetadataやリクエストメッセージから取得する(後続ブランチ)
-		mode := ""
+		ctx, requestID := ensureRequestID(ctx)
+		// クライアントが付与する run_id / mode。同時に複数人が叩く環境で自分のトラフィックを絞り込めるようにする
+		runID, mode := ClientInfo(ctx)
 
 		// bytes_in:リクエストサイズ
 		bytesIn := 0
@@ -101,34 +171,26 @@ func UnaryLoggingInterceptor(logger *zap.SugaredLogger) grpc.UnaryServerIntercep
 		}
 
 		// Otel span から trace_id を取得し、request_id/mode を attributeに載せる
-		span := trace.SpanFromContext(ctx)
-		traceID := ""
-		if span != nil {
-			sc := span.SpanContext()
-			if sc.IsValid() {
-				traceID = sc.TraceID().String()
-			}
-			if span.IsRecording() {
-				span.SetAttributes(
-					attribute.String("request_id", requestID),
-					attribute.String("mode", mode),
-				)
-			}
-		}
+		traceID := annotateServerSpan(ctx, requestID, mode, runID)
 
 		fields := []any{
 			"grpc_method", info.FullMethod,
 			"trace_id", traceID,
 			"request_id", requestID,
 			"mode", mode,
+			"run_id", runID,
+		}
+		fields = append(fields, peerFields(ctx)...)
+		fields = append(fields,
 			"bytes_in", bytesIn,
 			"bytes_out", bytesOut,
 			"latency_ms", latencyMs,
 			"code", code,
-		}
+		)
 
 		if err != nil {
 			fields = append(fields, "error", err)
+			fields = append(fields, apperrors.LogFields(err)...)
 			logger.Errorw("grpc server unary", fields...)
 		} else {
 			logger.Infow("grpc server unary", fields...)
@@ -136,3 +198,136 @@ func UnaryLoggingInterceptor(logger *zap.SugaredLogger) grpc.UnaryServerIntercep
 		return resp, err
 	}
 }
+
+// StreamLoggingInterceptor は gRPC Streaming RPC のアクセスログ(ストリームの終了時に 1 行)を出力するインターセプター。
+// フィールドは UnaryLoggingInterceptor と同じで、bytes_in / bytes_out の代わりに
+// 受信/送信したメッセージ数 msgs_in / msgs_out を出力する
+func StreamLoggingInterceptor(logger *zap.SugaredLogger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+
+		ctx, requestID := ensureRequestID(ss.Context())
+		runID, mode := ClientInfo(ctx)
+
+		ls := &accessLogStream{ServerStream: ss, ctx: ctx}
+		err := handler(srv, ls)
+
+		st, _ := status.FromError(err)
+		code := "OK"
+		if st != nil {
+			code = st.Code().String()
+		}
+
+		traceID := annotateServerSpan(ctx, requestID, mode, runID)
+
+		fields := []any{
+			"grpc_method", info.FullMethod,
+			"trace_id", traceID,
+			"request_id", requestID,
+			"mode", mode,
+			"run_id", runID,
+		}
+		fields = append(fields, peerFields(ctx)...)
+		fields = append(fields,
+			"msgs_in", ls.recv.Load(),
+			"msgs_out", ls.sent.Load(),
+			"latency_ms", time.Since(start).Milliseconds(),
+			"code", code,
+		)
+
+		if err != nil {
+			fields = append(fields, "error", err)
+			fields = append(fields, apperrors.LogFields(err)...)
+			logger.Errorw("grpc server stream", fields...)
+		} else {
+			logger.Infow("grpc server stream", fields...)
+		}
+		return err
+	}
+}
+
+// accessLogStream は送受信したメッセージ数を数え、request_id を埋め込んだ context を handler に渡す
+type accessLogStream struct {
+	grpc.ServerStream
+	ctx        context.Context
+	recv, sent atomic.Int64
+}
+
+func (s *accessLogStream) Context() context.Context { return s.ctx }
+
+func (s *accessLogStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.recv.Add(1)
+	}
+	return err
+}
+
+func (s *accessLogStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent.Add(1)
+	}
+	return err
+}
+
+// ensureRequestID は metadata x-request-id を返す。なければ生成して incoming metadata に埋め込んだ context を返す
+func ensureRequestID(ctx context.Context) (context.Context, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if md != nil {
+		if vals := md.Get("x-request-id"); len(vals) > 0 && vals[0] != "" {
+			return ctx, vals[0]
+		}
+	}
+	requestID := uuid.New().String()
+	if md == nil {
+		md = metadata.New(nil)
+	} else {
+		md = md.Copy()
+	}
+	md.Set("x-request-id", requestID)
+	return metadata.NewIncomingContext(ctx, md), requestID
+}
+
+// annotateServerSpan はサーバーの span に request_id / mode / run_id を載せ、trace_id を返す
+func annotateServerSpan(ctx context.Context, requestID, mode, runID string) string {
+	span := trace.SpanFromContext(ctx)
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String("request_id", requestID),
+			attribute.String("mode", mode),
+		)
+		if runID != "" {
+			span.SetAttributes(RunIDKey.String(runID))
+		}
+	}
+	if sc := span.SpanContext(); sc.IsValid() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// peerFields はアクセスログの接続元のフィールド。
+//   - peer_addr : 接続元の IP:port(プロキシや Service mesh を経由する場合はその手前のアドレス)
+//   - user_agent : metadata user-agent(gRPC ライブラリが付ける grpc-go/<version> などを含む)
+//   - authority : HTTP/2 の :authority(クライアントが接続先として指定したホスト名)
+//
+// 取得できない場合も空文字で出力し、ログのスキーマを RPC によらず揃える
+func peerFields(ctx context.Context) []any {
+	peerAddr := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		peerAddr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if vals := md.Get(key); len(vals) > 0 {
+			return vals[0]
+		}
+		return ""
+	}
+	return []any{
+		"peer_addr", peerAddr,
+		"user_agent", first("user-agent"),
+		"authority", first(":authority"),
+	}
+}
@@ -0,0 +1,99 @@
+package observability
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// CNO_APP_DEBUG_LOG_CLOCK_SKEW を指定するとログの ts だけがずれる
+func TestNewLogger_ClockSkew(t *testing.T) {
+	t.Setenv(envLogClockSkew, "-1h")
+
+	var buf bytes.Buffer
+	logger := newLogger(zapcore.AddSync(&buf))
+	before := time.Now()
+	logger.Infow("hello")
+	_ = logger.Sync()
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	var entry struct {
+		Ts  string `json:"ts"`
+		Msg string `json:"msg"`
+	}
+	if err := json.Unmarshal(lines[len(lines)-1], &entry); err != nil {
+		t.Fatalf("unmarshal log: %v", err)
+	}
+	ts, err := time.Parse("2006-01-02T15:04:05.000Z0700", entry.Ts)
+	if err != nil {
+		t.Fatalf("parse ts %q: %v", entry.Ts, err)
+	}
+	if skew := ts.Sub(before); skew > -59*time.Minute || skew < -61*time.Minute {
+		t.Fatalf("ts skew = %s, want about -1h", skew)
+	}
+}
+
+func TestLogClockSkewFromEnv_Invalid(t *testing.T) {
+	for _, v := range []string{"soon", "48h", "-25h"} {
+		t.Setenv(envLogClockSkew, v)
+		if _, err := logClockSkewFromEnv(); err == nil {
+			t.Errorf("%s=%q: want error", envLogClockSkew, v)
+		}
+	}
+}
+
+// ストリームのアクセスログに接続元の情報と送受信したメッセージ数が載り、
+// x-request-id がない場合は生成した request_id が handler の context にも入る
+func TestStreamLoggingInterceptor(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"user-agent", "cno-app-client/dev grpc-go/1.77.0",
+		":authority", "cno-app.demo.svc:8080",
+	))
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 54321}})
+
+	var buf bytes.Buffer
+	i := StreamLoggingInterceptor(newLogger(zapcore.AddSync(&buf)))
+	var handlerRequestID string
+	err := i(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/cno.Burner/DoWorkBidiStreaming"}, func(_ any, ss grpc.ServerStream) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		handlerRequestID = md.Get("x-request-id")[0]
+		for range 3 {
+			_ = ss.RecvMsg(nil)
+		}
+		return ss.SendMsg(nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		PeerAddr  string `json:"peer_addr"`
+		UserAgent string `json:"user_agent"`
+		Authority string `json:"authority"`
+		MsgsIn    int    `json:"msgs_in"`
+		MsgsOut   int    `json:"msgs_out"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &entry); err != nil {
+		t.Fatalf("unmarshal log: %v\n%s", err, buf.String())
+	}
+	if entry.Msg != "grpc server stream" || entry.PeerAddr != "10.0.0.1:54321" ||
+		entry.UserAgent != "cno-app-client/dev grpc-go/1.77.0" || entry.Authority != "cno-app.demo.svc:8080" {
+		t.Fatalf("entry = %+v", entry)
+	}
+	if entry.MsgsIn != 3 || entry.MsgsOut != 1 {
+		t.Fatalf("msgs_in/msgs_out = %d/%d, want 3/1", entry.MsgsIn, entry.MsgsOut)
+	}
+	if entry.RequestID == "" || entry.RequestID != handlerRequestID {
+		t.Fatalf("request_id = %q, handler saw %q", entry.RequestID, handlerRequestID)
+	}
+}
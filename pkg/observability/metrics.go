@@ -1,11 +1,13 @@
 package observability
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
-	CNOAppRequestsTotal = prometheus.NewCounterVec(
+	CNOAppRequestsTotal = newCounterVec(
 		prometheus.CounterOpts{
 			Name: "cno_app_requests_total",
 			Help: "Total number of gRPC requests handled by the application.",
@@ -13,7 +15,7 @@ var (
 		[]string{"mode", "endpoint", "code"},
 	)
 
-	CNOAppRequestLatency = prometheus.NewHistogramVec(
+	CNOAppRequestLatency = newHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "cno_app_request_latency_seconds",
 			Help:    "Latency of gRPC requests.",
@@ -22,17 +24,176 @@ var (
 		[]string{"mode", "endpoint", "code"},
 	)
 
-	CNOAppRequestsInFlight = prometheus.NewGaugeVec(
+	CNOAppRequestsInFlight = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "cno_app_requests_in_flight",
 			Help: "Number of in-flight gRPC requests being handled.",
 		},
 		[]string{"mode", "endpoint"},
 	)
+
+	CNOAppWorkDuration = newHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "cno_app_work_duration_seconds",
+			Help:    "Actual duration of each load run, labeled by load mode and the coarse bucket of its intended duration (objective).",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+		},
+		[]string{"mode", "objective"},
+	)
+
+	CNOAppWorkTargetDuration = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cno_app_work_target_duration_seconds",
+			Help: "Intended duration of the most recent load run per load mode.",
+		},
+		[]string{"mode"},
+	)
+
+	CNOAppWorkTargetLatency = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cno_app_work_target_latency_seconds",
+			Help: "Injected latency configured for the most recent load run per load mode.",
+		},
+		[]string{"mode"},
+	)
+
+	CNOAppNoisyNeighborDelay = newHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "cno_app_noisy_neighbor_delay_seconds",
+			Help:    "Scheduling delay injected into requests to simulate noisy-neighbor CPU steal.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 10),
+		},
+		[]string{"endpoint"},
+	)
+
+	CNOAppRejectedRequestsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "cno_app_rejected_requests_total",
+			Help: "Total number of work requests rejected by config validation or safety limits.",
+		},
+		[]string{"reason"},
+	)
+
+	CNOAppWatchdogKillsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "cno_app_watchdog_kills_total",
+			Help: "Total number of load runs abandoned by the watchdog after exceeding their duration by the grace factor.",
+		},
+		[]string{"mode"},
+	)
+
+	CNOAppCPUWorkersInUse = newGauge(
+		prometheus.GaugeOpts{
+			Name: "cno_app_cpu_workers_in_use",
+			Help: "Number of CPU load workers currently reserved across concurrent requests.",
+		},
+	)
+
+	CNOAppCPUWorkersCapacity = newGauge(
+		prometheus.GaugeOpts{
+			Name: "cno_app_cpu_workers_capacity",
+			Help: "Number of CPU load workers the server arbitrates among requests that ask for a share (x-cpu-share).",
+		},
+	)
+
+	CNOAppWorkPaused = newGauge(
+		prometheus.GaugeOpts{
+			Name: "cno_app_work_paused",
+			Help: "Number of in-flight load runs currently paused by the admin pause endpoint (workers idle but still holding their memory).",
+		},
+	)
+
+	CNOAppWorkCoalesceRequestsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "cno_app_work_coalesce_requests_total",
+			Help: "Total number of DoWork requests handled by request coalescing, by role (leader ran the work, follower shared its result).",
+		},
+		[]string{"role"},
+	)
+
+	CNOAppWorkCoalesceGroupSize = newHistogram(
+		prometheus.HistogramOpts{
+			Name:    "cno_app_work_coalesce_group_size",
+			Help:    "Number of DoWork requests that shared a single coalesced execution (leader included).",
+			Buckets: []float64{1, 2, 4, 8, 16, 32, 64, 128, 256},
+		},
+	)
+
+	CNOAppMirrorRequestsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "cno_app_mirror_requests_total",
+			Help: "Total number of DoWork requests mirrored to the shadow server, by result (success, error, or dropped at the in-flight limit).",
+		},
+		[]string{"result"},
+	)
+
+	CNOAppMirrorLatency = newHistogram(
+		prometheus.HistogramOpts{
+			Name:    "cno_app_mirror_latency_seconds",
+			Help:    "Latency of DoWork calls mirrored to the shadow server, measured off the request path.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	CNOAppRemoteWriteRequestsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "cno_app_remote_write_requests_total",
+			Help: "Total number of remote-write pushes of the application's own metrics, by result (success or failure).",
+		},
+		[]string{"result"},
+	)
+
+	CNOAppRemoteWriteLastSuccess = newGauge(
+		prometheus.GaugeOpts{
+			Name: "cno_app_remote_write_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful remote-write push of the application's own metrics.",
+		},
+	)
+
+	CNOAppStatsdDroppedTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "cno_app_statsd_dropped_total",
+			Help: "Total number of statsd lines that were not delivered, by reason (queue_full or write_error).",
+		},
+		[]string{"reason"},
+	)
+
+	CNOAppWebhookDeliveriesTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "cno_app_webhook_deliveries_total",
+			Help: "Total number of work result webhook deliveries, by event and result (success, failure after retries, or dropped).",
+		},
+		[]string{"event", "result"},
+	)
+
+	CNOAppWebhookAttemptsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "cno_app_webhook_attempts_total",
+			Help: "Total number of webhook HTTP attempts including retries, by response status class (2xx, 4xx, 5xx) or error.",
+		},
+		[]string{"status_class"},
+	)
+
+	CNOAppGCBallastBytes = newGauge(
+		prometheus.GaugeOpts{
+			Name: "cno_app_gc_ballast_bytes",
+			Help: "Size of the memory ballast allocated at startup to raise the GC heap goal (0 when disabled).",
+		},
+	)
+
+	CNOAppWebhookTargets = newGauge(
+		prometheus.GaugeOpts{
+			Name: "cno_app_webhook_targets",
+			Help: "Number of registered work result webhook targets.",
+		},
+	)
 )
 
-func init() {
-	prometheus.MustRegister(CNOAppRequestsTotal)
-	prometheus.MustRegister(CNOAppRequestLatency)
-	prometheus.MustRegister(CNOAppRequestsInFlight)
+// ObserveWork は 1 回の負荷実行について、意図した時間(target)と実際の時間(actual)を記録する。
+// objective ラベルは target を LatencyBucket で丸めた値にし、ヒートマップで
+// 「意図したレイテンシ帯ごとの実測分布」を描けるようにする
+func ObserveWork(mode string, target, injectedLatency, actual time.Duration) {
+	CNOAppWorkDuration.WithLabelValues(mode, LatencyBucket(target)).Observe(actual.Seconds())
+	CNOAppWorkTargetDuration.WithLabelValues(mode).Set(target.Seconds())
+	CNOAppWorkTargetLatency.WithLabelValues(mode).Set(injectedLatency.Seconds())
 }
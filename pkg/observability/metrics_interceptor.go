@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/status"
 )
@@ -17,7 +18,8 @@ func UnaryMetricsInterceptor(
 
 	start := time.Now()
 
-	mode := ""
+	runID, mode := ClientInfo(ctx)
+	mode = metricMode(mode)
 	endpoint := info.FullMethod
 
 	// in_flight++
@@ -38,8 +40,7 @@ func UnaryMetricsInterceptor(
 	CNOAppRequestsTotal.WithLabelValues(mode, endpoint, code).Inc()
 
 	// Histogram
-	latency := time.Since(start).Seconds()
-	CNOAppRequestLatency.WithLabelValues(mode, endpoint, code).Observe(latency)
+	observeRequestLatency(mode, endpoint, code, runID, time.Since(start))
 
 	return resp, err
 }
@@ -52,7 +53,8 @@ func StreamMetricsInterceptor(
 ) error {
 	start := time.Now()
 
-	mode := ""
+	runID, mode := ClientInfo(ss.Context())
+	mode = metricMode(mode)
 	endpoint := info.FullMethod
 
 	CNOAppRequestsInFlight.WithLabelValues(mode, endpoint).Inc()
@@ -68,8 +70,22 @@ func StreamMetricsInterceptor(
 
 	CNOAppRequestsTotal.WithLabelValues(mode, endpoint, code).Inc()
 
-	latency := time.Since(start).Seconds()
-	CNOAppRequestLatency.WithLabelValues(mode, endpoint, code).Observe(latency)
+	observeRequestLatency(mode, endpoint, code, runID, time.Since(start))
 
 	return err
 }
+
+// maxExemplarRunIDLen は exemplar に載せる run_id の上限長。
+// exemplar のラベルは合計 128 文字までで、超えると ObserveWithExemplar が panic するため
+const maxExemplarRunIDLen = 64
+
+// observeRequestLatency はレイテンシを記録する。run_id はカーディナリティが際限なく増えるためラベルにはせず、
+// exemplar として載せる(OpenMetrics 形式でスクレイプした場合のみ公開される)
+func observeRequestLatency(mode, endpoint, code, runID string, latency time.Duration) {
+	obs := CNOAppRequestLatency.WithLabelValues(mode, endpoint, code)
+	if eo, ok := obs.(prometheus.ExemplarObserver); ok && runID != "" && len(runID) <= maxExemplarRunIDLen {
+		eo.ObserveWithExemplar(latency.Seconds(), prometheus.Labels{"run_id": runID})
+		return
+	}
+	obs.Observe(latency.Seconds())
+}
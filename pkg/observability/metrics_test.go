@@ -0,0 +1,155 @@
+package observability
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newTestRegistry(t *testing.T) *TestRegistry {
+	t.Helper()
+	reg, err := NewTestRegistry()
+	if err != nil {
+		t.Fatalf("NewTestRegistry: %v", err)
+	}
+	return reg
+}
+
+func assertValue(t *testing.T, reg *TestRegistry, name string, labels prometheus.Labels, want float64) {
+	t.Helper()
+	got, ok, err := reg.Value(name, labels)
+	if err != nil {
+		t.Fatalf("Value(%s): %v", name, err)
+	}
+	if !ok {
+		series, _ := reg.Series(name)
+		t.Fatalf("%s%v: no such series (have %v)", name, labels, series)
+	}
+	if got != want {
+		t.Fatalf("%s%v = %v, want %v", name, labels, got, want)
+	}
+}
+
+func clientContext(runID, mode string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(RunIDMetadataKey, runID, ClientModeMetadataKey, mode))
+}
+
+// コードごとに requests_total / latency の系列が増え、in_flight は 0 に戻り、run_id は exemplar にだけ載る
+func TestUnaryMetricsInterceptor(t *testing.T) {
+	reg := newTestRegistry(t)
+	info := &grpc.UnaryServerInfo{FullMethod: "/cno.Burner/Ping"}
+	ctx := clientContext("run-1", "ping")
+
+	ok := func(context.Context, any) (any, error) { return "ok", nil }
+	fail := func(context.Context, any) (any, error) { return nil, status.Error(codes.ResourceExhausted, "limit") }
+	for _, h := range []grpc.UnaryHandler{ok, ok, fail} {
+		_, _ = UnaryMetricsInterceptor(ctx, nil, info, h)
+	}
+
+	assertValue(t, reg, "cno_app_requests_total", prometheus.Labels{"mode": "ping", "endpoint": info.FullMethod, "code": "OK"}, 2)
+	assertValue(t, reg, "cno_app_requests_total", prometheus.Labels{"mode": "ping", "endpoint": info.FullMethod, "code": "ResourceExhausted"}, 1)
+	assertValue(t, reg, "cno_app_request_latency_seconds", prometheus.Labels{"mode": "ping", "endpoint": info.FullMethod, "code": "OK"}, 2)
+	assertValue(t, reg, "cno_app_requests_in_flight", prometheus.Labels{"mode": "ping", "endpoint": info.FullMethod}, 0)
+
+	ex, err := reg.Exemplars("cno_app_request_latency_seconds", prometheus.Labels{"mode": "ping", "endpoint": info.FullMethod, "code": "OK"})
+	if err != nil || len(ex) == 0 || ex[0]["run_id"] != "run-1" {
+		t.Fatalf("exemplars = %v, %v; want run_id=run-1", ex, err)
+	}
+	series, err := reg.Series("cno_app_requests_total")
+	if err != nil || len(series) != 2 {
+		t.Fatalf("series = %v, %v; want 2 (run_id must not be a label)", series, err)
+	}
+}
+
+// 許可しない mode は other に丸め、長すぎる run_id は exemplar に載せない
+func TestUnaryMetricsInterceptor_Normalizes(t *testing.T) {
+	reg := newTestRegistry(t)
+	info := &grpc.UnaryServerInfo{FullMethod: "/cno.Burner/Ping"}
+	long := string(make([]byte, maxExemplarRunIDLen+1))
+	ctx := clientContext(long, "Not A Mode")
+
+	_, _ = UnaryMetricsInterceptor(ctx, nil, info, func(context.Context, any) (any, error) { return nil, nil })
+
+	labels := prometheus.Labels{"mode": otherMode, "endpoint": info.FullMethod, "code": "OK"}
+	assertValue(t, reg, "cno_app_requests_total", labels, 1)
+	if ex, err := reg.Exemplars("cno_app_request_latency_seconds", labels); err != nil || len(ex) != 0 {
+		t.Fatalf("exemplars = %v, %v; want none", ex, err)
+	}
+}
+
+func TestStreamMetricsInterceptor(t *testing.T) {
+	reg := newTestRegistry(t)
+	info := &grpc.StreamServerInfo{FullMethod: "/cno.Burner/DoWorkServerStreaming", IsServerStream: true}
+	ss := &fakeServerStream{ctx: clientContext("run-2", "do-work-server")}
+
+	var inFlight float64
+	_ = StreamMetricsInterceptor(nil, ss, info, func(any, grpc.ServerStream) error {
+		inFlight, _, _ = reg.Value("cno_app_requests_in_flight", prometheus.Labels{"mode": "do-work-server", "endpoint": info.FullMethod})
+		return status.Error(codes.Canceled, "client gone")
+	})
+
+	if inFlight != 1 {
+		t.Fatalf("in_flight during the stream = %v, want 1", inFlight)
+	}
+	assertValue(t, reg, "cno_app_requests_total", prometheus.Labels{"mode": "do-work-server", "endpoint": info.FullMethod, "code": "Canceled"}, 1)
+	assertValue(t, reg, "cno_app_requests_in_flight", prometheus.Labels{"mode": "do-work-server", "endpoint": info.FullMethod}, 0)
+}
+
+func TestObserveWork(t *testing.T) {
+	reg := newTestRegistry(t)
+
+	ObserveWork("cpu", 300*time.Millisecond, 50*time.Millisecond, 320*time.Millisecond)
+
+	assertValue(t, reg, "cno_app_work_duration_seconds", prometheus.Labels{"mode": "cpu", "objective": "lt_500ms"}, 1)
+	assertValue(t, reg, "cno_app_work_target_duration_seconds", prometheus.Labels{"mode": "cpu"}, 0.3)
+	assertValue(t, reg, "cno_app_work_target_latency_seconds", prometheus.Labels{"mode": "cpu"}, 0.05)
+}
+
+// route ラベルはパスではなく ServeMux のパターン。マッチしないパスは unmatched にまとめる
+func TestWrapHTTPHandler_Metrics(t *testing.T) {
+	reg := newTestRegistry(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	h := WrapHTTPHandler(zap.NewNop().Sugar(), "admin", mux)
+
+	for _, path := range []string{"/items/1", "/items/2", "/nope"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assertValue(t, reg, "cno_app_http_requests_total", prometheus.Labels{"server": "admin", "method": "GET", "route": "GET /items/{id}", "code": "200"}, 2)
+	assertValue(t, reg, "cno_app_http_requests_total", prometheus.Labels{"server": "admin", "method": "GET", "route": "unmatched", "code": "404"}, 1)
+}
+
+// カタログに載っているメトリクスはすべてレジストリに登録されている
+func TestRegisterMetrics_MatchesCatalog(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if err := RegisterMetrics(reg); err != nil {
+		t.Fatalf("RegisterMetrics: %v", err)
+	}
+	descs := make(chan *prometheus.Desc, 64)
+	go func() {
+		for _, c := range metricCollectors {
+			c.Describe(descs)
+		}
+		close(descs)
+	}()
+	n := 0
+	for range descs {
+		n++
+	}
+	if n != len(Catalog()) {
+		t.Fatalf("registered %d metrics, catalog has %d", n, len(Catalog()))
+	}
+	if _, _, err := (&TestRegistry{reg: reg}).Value("cno_app_unknown_total", nil); err == nil {
+		t.Fatal("Value: want error for a metric not in the catalog")
+	}
+}
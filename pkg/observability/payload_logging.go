@@ -0,0 +1,261 @@
+package observability
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const redactedValue = "[REDACTED]"
+
+// PayloadLogConfig はリクエスト/レスポンスの proto をそのままログに出すデバッグ用設定。
+// ワークショップで「実際に何が送受信されたか」を見せるためのもので、通常は無効(SampleRate=0)にしておく
+type PayloadLogConfig struct {
+	SampleRate   float64  // 記録する RPC の割合(0.0~1.0)
+	MaxBytes     int      // JSON 化した payload の上限バイト数(超過分は切り詰める)
+	RedactFields []string // 値を [REDACTED] に置き換えるフィールド名(proto のフィールド名)
+
+	// 以下は proto → JSON 変換(protojson)の表現。ログ基盤のパーサーに合わせて固定できるようにする
+	EmitDefaults bool   // ゼロ値のフィールドも出力する(未設定のフィールドがキーごと消えないようにする)
+	EnumFormat   string // enum の表現: "string"(名前、既定) / "int"(番号)
+	FieldNames   string // フィールド名: "proto"(snake_case、既定) / "json"(lowerCamelCase)
+}
+
+// PayloadLogConfig.EnumFormat / FieldNames に指定できる値
+const (
+	PayloadEnumString  = "string"
+	PayloadEnumInt     = "int"
+	PayloadFieldsProto = "proto"
+	PayloadFieldsJSON  = "json"
+)
+
+// Validate は JSON 表現の設定値を検証する。空文字は既定値として扱う
+func (c PayloadLogConfig) Validate() error {
+	switch c.EnumFormat {
+	case "", PayloadEnumString, PayloadEnumInt:
+	default:
+		return fmt.Errorf("payload enum format must be %q or %q, got %q", PayloadEnumString, PayloadEnumInt, c.EnumFormat)
+	}
+	switch c.FieldNames {
+	case "", PayloadFieldsProto, PayloadFieldsJSON:
+	default:
+		return fmt.Errorf("payload field names must be %q or %q, got %q", PayloadFieldsProto, PayloadFieldsJSON, c.FieldNames)
+	}
+	return nil
+}
+
+func (c PayloadLogConfig) marshalOptions() protojson.MarshalOptions {
+	return protojson.MarshalOptions{
+		UseProtoNames:   c.FieldNames != PayloadFieldsJSON,
+		UseEnumNumbers:  c.EnumFormat == PayloadEnumInt,
+		EmitUnpopulated: c.EmitDefaults,
+	}
+}
+
+// Enabled は payload 記録が有効かどうかを返す
+func (c PayloadLogConfig) Enabled() bool {
+	return c.SampleRate > 0
+}
+
+func (c PayloadLogConfig) sampled() bool {
+	if c.SampleRate >= 1 {
+		return true
+	}
+	return rand.Float64() < c.SampleRate
+}
+
+// FormatPayload は proto メッセージを JSON に変換し、redaction と サイズ上限を適用した文字列を返す
+func (c PayloadLogConfig) FormatPayload(msg any) string {
+	m, ok := msg.(proto.Message)
+	if !ok || m == nil {
+		return ""
+	}
+	b, err := c.marshalOptions().Marshal(m)
+	if err != nil {
+		return ""
+	}
+	// protojson は出力が安定しないよう意図的に空白をランダムに入れるため、詰めて表現を固定する
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, b); err == nil {
+		b = compact.Bytes()
+	}
+
+	if len(c.RedactFields) > 0 {
+		var v any
+		if err := json.Unmarshal(b, &v); err == nil {
+			redact(v, c.RedactFields)
+			if rb, err := json.Marshal(v); err == nil {
+				b = rb
+			}
+		}
+	}
+
+	if c.MaxBytes > 0 && len(b) > c.MaxBytes {
+		// マルチバイト文字の途中で切ると不正な UTF-8 がログに残るため、文字の先頭まで戻して切る
+		n := c.MaxBytes
+		for n > 0 && !utf8.RuneStart(b[n]) {
+			n--
+		}
+		return string(b[:n]) + "...(truncated)"
+	}
+	return string(b)
+}
+
+// redact は JSON 値を再帰的に辿り、fields に一致するキーの値を置き換える
+func redact(v any, fields []string) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if matchesField(k, fields) {
+				t[k] = redactedValue
+				continue
+			}
+			redact(child, fields)
+		}
+	case []any:
+		for _, child := range t {
+			redact(child, fields)
+		}
+	}
+}
+
+// matchesField は proto 名(request_id)でも JSON 名(requestId)でも一致するよう、"_" を除いて大文字小文字を無視して比較する
+func matchesField(key string, fields []string) bool {
+	key = strings.ReplaceAll(key, "_", "")
+	for _, f := range fields {
+		if strings.EqualFold(key, strings.ReplaceAll(f, "_", "")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReloadablePayloadLogConfig は実行中に差し替えられる PayloadLogConfig(SIGHUP による設定の再読み込み用)
+type ReloadablePayloadLogConfig struct {
+	v atomic.Pointer[PayloadLogConfig]
+}
+
+// NewReloadablePayloadLogConfig は cfg を初期値とする ReloadablePayloadLogConfig を返す
+func NewReloadablePayloadLogConfig(cfg PayloadLogConfig) *ReloadablePayloadLogConfig {
+	r := &ReloadablePayloadLogConfig{}
+	r.Store(cfg)
+	return r
+}
+
+// Load は現在の設定を返す
+func (r *ReloadablePayloadLogConfig) Load() PayloadLogConfig {
+	return *r.v.Load()
+}
+
+// Store は設定を差し替える。既に始まっているストリームには適用されない
+func (r *ReloadablePayloadLogConfig) Store(cfg PayloadLogConfig) {
+	r.v.Store(&cfg)
+}
+
+// UnaryPayloadLoggingInterceptor はサンプリングされた Unary RPC のリクエスト/レスポンスを JSON でログ出力する
+func UnaryPayloadLoggingInterceptor(logger *zap.SugaredLogger, cfg PayloadLogConfig) grpc.UnaryServerInterceptor {
+	return UnaryReloadablePayloadLoggingInterceptor(logger, NewReloadablePayloadLogConfig(cfg))
+}
+
+// UnaryReloadablePayloadLoggingInterceptor は UnaryPayloadLoggingInterceptor と同じだが、RPC ごとに rc の現在の設定を使う
+func UnaryReloadablePayloadLoggingInterceptor(logger *zap.SugaredLogger, rc *ReloadablePayloadLogConfig) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		cfg := rc.Load()
+		if !cfg.Enabled() || !cfg.sampled() {
+			return handler(ctx, req)
+		}
+
+		resp, err := handler(ctx, req)
+
+		fields := []any{
+			"grpc_method", info.FullMethod,
+			"trace_id", traceIDFromContext(ctx),
+			"request_payload", cfg.FormatPayload(req),
+			"response_payload", cfg.FormatPayload(resp),
+		}
+		if err != nil {
+			fields = append(fields, "error", err)
+		}
+		logger.Infow("grpc payload", fields...)
+		return resp, err
+	}
+}
+
+// StreamPayloadLoggingInterceptor はサンプリングされた Streaming RPC の送受信メッセージを 1 件ずつログ出力する
+func StreamPayloadLoggingInterceptor(logger *zap.SugaredLogger, cfg PayloadLogConfig) grpc.StreamServerInterceptor {
+	return StreamReloadablePayloadLoggingInterceptor(logger, NewReloadablePayloadLogConfig(cfg))
+}
+
+// StreamReloadablePayloadLoggingInterceptor は StreamPayloadLoggingInterceptor と同じだが、
+// ストリーム開始時点の rc の設定をそのストリームの間使い続ける
+func StreamReloadablePayloadLoggingInterceptor(logger *zap.SugaredLogger, rc *ReloadablePayloadLogConfig) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		cfg := rc.Load()
+		if !cfg.Enabled() || !cfg.sampled() {
+			return handler(srv, ss)
+		}
+		return handler(srv, &payloadLoggingStream{
+			ServerStream: ss,
+			logger:       logger,
+			cfg:          cfg,
+			method:       info.FullMethod,
+		})
+	}
+}
+
+type payloadLoggingStream struct {
+	grpc.ServerStream
+	logger *zap.SugaredLogger
+	cfg    PayloadLogConfig
+	method string
+}
+
+func (s *payloadLoggingStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.log("recv", m)
+	}
+	return err
+}
+
+func (s *payloadLoggingStream) SendMsg(m any) error {
+	s.log("send", m)
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *payloadLoggingStream) log(direction string, m any) {
+	s.logger.Infow("grpc stream payload",
+		"grpc_method", s.method,
+		"trace_id", traceIDFromContext(s.Context()),
+		"direction", direction,
+		"payload", s.cfg.FormatPayload(m),
+	)
+}
+
+func traceIDFromContext(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package observability
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+)
+
+func TestPayloadLogConfig_FormatPayload_Redacts(t *testing.T) {
+	cfg := PayloadLogConfig{RedactFields: []string{"request_id"}}
+	msg := &grpcburnerv1.DoWorkRequest{
+		RequestId: "secret-id",
+		Config:    &grpcburnerv1.WorkConfig{DurationMs: 100},
+	}
+
+	got := cfg.FormatPayload(msg)
+	if strings.Contains(got, "secret-id") {
+		t.Fatalf("payload should not contain redacted value: %s", got)
+	}
+	if !strings.Contains(got, redactedValue) {
+		t.Fatalf("payload should contain %q: %s", redactedValue, got)
+	}
+	if !strings.Contains(got, "duration_ms") {
+		t.Fatalf("payload should keep non-redacted fields: %s", got)
+	}
+}
+
+func TestPayloadLogConfig_FormatPayload_Truncates(t *testing.T) {
+	cfg := PayloadLogConfig{MaxBytes: 10}
+	msg := &grpcburnerv1.DoWorkRequest{RequestId: strings.Repeat("x", 100)}
+
+	got := cfg.FormatPayload(msg)
+	if !strings.HasSuffix(got, "...(truncated)") {
+		t.Fatalf("expected truncated payload, got %s", got)
+	}
+	if len(got) != 10+len("...(truncated)") {
+		t.Fatalf("unexpected payload length %d: %s", len(got), got)
+	}
+}
+
+// 上限がマルチバイト文字の途中に当たったら、その文字の前で切って不正な UTF-8 を残さない
+func TestPayloadLogConfig_FormatPayload_TruncatesAtRuneBoundary(t *testing.T) {
+	msg := &grpcburnerv1.DoWorkRequest{RequestId: strings.Repeat("負荷", 10)}
+	prefix := len(`{"request_id":"`)
+	for _, max := range []int{prefix + 1, prefix + 2, prefix + 3, prefix + 4} {
+		got := PayloadLogConfig{MaxBytes: max}.FormatPayload(msg)
+		body := strings.TrimSuffix(got, "...(truncated)")
+		if !utf8.ValidString(got) || body == got || len(body) > max || len(body) <= max-utf8.UTFMax {
+			t.Fatalf("MaxBytes=%d: payload = %q", max, got)
+		}
+	}
+}
+
+// JSON 表現のオプション(ゼロ値の出力/enum の番号/JSON 名)が反映され、redaction は JSON 名でも効く
+func TestPayloadLogConfig_FormatPayload_JSONOptions(t *testing.T) {
+	msg := &grpcburnerv1.DoWorkRequest{
+		RequestId: "secret-id",
+		Config:    &grpcburnerv1.WorkConfig{Mode: grpcburnerv1.LoadMode_LOAD_MODE_CPU},
+	}
+
+	def := PayloadLogConfig{}.FormatPayload(msg)
+	if !strings.Contains(def, `"mode":"LOAD_MODE_CPU"`) || strings.Contains(def, "duration_ms") {
+		t.Fatalf("default representation = %s", def)
+	}
+
+	cfg := PayloadLogConfig{
+		EmitDefaults: true,
+		EnumFormat:   PayloadEnumInt,
+		FieldNames:   PayloadFieldsJSON,
+		RedactFields: []string{"request_id"},
+	}
+	got := cfg.FormatPayload(msg)
+	for _, want := range []string{`"mode":1`, `"durationMs":"0"`, `"requestId":"[REDACTED]"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("payload %s does not contain %s", got, want)
+		}
+	}
+	if strings.Contains(got, "secret-id") {
+		t.Errorf("payload should not contain redacted value: %s", got)
+	}
+}
+
+func TestPayloadLogConfig_Validate(t *testing.T) {
+	if err := (PayloadLogConfig{EnumFormat: "name"}).Validate(); err == nil {
+		t.Error("EnumFormat=name: want error")
+	}
+	if err := (PayloadLogConfig{FieldNames: "camel"}).Validate(); err == nil {
+		t.Error("FieldNames=camel: want error")
+	}
+}
@@ -0,0 +1,144 @@
+package observability
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// RecentEvent は RecentEvents が保持する 1 件の構造化ログ
+type RecentEvent struct {
+	Time    time.Time      `json:"ts"`
+	Level   string         `json:"level"`
+	Message string         `json:"msg"`
+	Caller  string         `json:"caller,omitempty"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+// RecentEvents は直近 size 件の構造化ログをメモリに保持するリングバッファ。
+// Core をロガーに tee して使い、ログの収集(shipping)が壊れている時やクラッシュレポートなど、
+// 標準出力のログが届かない/失われる場面で直前の様子を残す
+type RecentEvents struct {
+	mu   sync.Mutex
+	buf  []RecentEvent
+	next int
+	full bool
+}
+
+// NewRecentEvents は size 件を保持する RecentEvents を返す。size が 1 未満なら 1 にする
+func NewRecentEvents(size int) *RecentEvents {
+	return &RecentEvents{buf: make([]RecentEvent, max(size, 1))}
+}
+
+func (r *RecentEvents) add(e RecentEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf[r.next] = e
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Snapshot は保持しているログを古い順に返す
+func (r *RecentEvents) Snapshot() []RecentEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]RecentEvent(nil), r.buf[:r.next]...)
+	}
+	out := make([]RecentEvent, 0, len(r.buf))
+	out = append(out, r.buf[r.next:]...)
+	return append(out, r.buf[:r.next]...)
+}
+
+// RecentEventsFilter は RecentEvents.Query の条件
+type RecentEventsFilter struct {
+	// MinLevel はこのレベル以上のログに絞る(ゼロ値は info。debug も含める時は zapcore.DebugLevel)
+	MinLevel zapcore.Level
+	// Fields は全て一致するログに絞る。値は文字列にして比較する(数値や bool も "200" / "true" で指定する)
+	Fields map[string]string
+	// Limit は条件に合うログのうち新しい方から返す件数。0 なら全て
+	Limit int
+}
+
+// Query は f に合うログを古い順に返す
+func (r *RecentEvents) Query(f RecentEventsFilter) []RecentEvent {
+	var out []RecentEvent
+	for _, e := range r.Snapshot() {
+		if f.matches(e) {
+			out = append(out, e)
+		}
+	}
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[len(out)-f.Limit:]
+	}
+	return out
+}
+
+func (f RecentEventsFilter) matches(e RecentEvent) bool {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(e.Level)); err == nil && level < f.MinLevel {
+		return false
+	}
+	for k, want := range f.Fields {
+		v, ok := e.Fields[k]
+		if !ok || fmt.Sprint(v) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Core は level 以上のログを r に記録する zapcore.Core を返す。zapcore.NewTee で既存のロガーの core と並べる
+func (r *RecentEvents) Core(level zapcore.LevelEnabler) zapcore.Core {
+	return &recentEventsCore{LevelEnabler: level, events: r}
+}
+
+type recentEventsCore struct {
+	zapcore.LevelEnabler
+	events *RecentEvents
+	fields []zapcore.Field
+}
+
+func (c *recentEventsCore) With(fields []zapcore.Field) zapcore.Core {
+	return &recentEventsCore{
+		LevelEnabler: c.LevelEnabler,
+		events:       c.events,
+		fields:       append(c.fields[:len(c.fields):len(c.fields)], fields...),
+	}
+}
+
+func (c *recentEventsCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *recentEventsCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	e := RecentEvent{
+		Time:    ent.Time,
+		Level:   ent.Level.String(),
+		Message: ent.Message,
+	}
+	if ent.Caller.Defined {
+		e.Caller = ent.Caller.TrimmedPath()
+	}
+	if len(enc.Fields) > 0 {
+		e.Fields = enc.Fields
+	}
+	c.events.add(e)
+	return nil
+}
+
+func (c *recentEventsCore) Sync() error { return nil }
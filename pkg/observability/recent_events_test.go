@@ -0,0 +1,47 @@
+package observability
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 古いログから捨て、With で付けたフィールドと呼び出しごとのフィールドを両方残すことを確認
+func TestRecentEvents_KeepsLastEntries(t *testing.T) {
+	events := NewRecentEvents(2)
+	logger := zap.New(events.Core(zapcore.InfoLevel)).Sugar().With("subsystem", "grpc")
+
+	logger.Infow("first", "n", 1)
+	logger.Debugw("ignored")
+	logger.Warnw("second", "n", 2)
+	logger.Errorw("third", "n", 3)
+
+	got := events.Snapshot()
+	if len(got) != 2 || got[0].Message != "second" || got[1].Message != "third" {
+		t.Fatalf("snapshot = %+v, want [second third]", got)
+	}
+	if got[0].Level != "warn" || got[0].Fields["subsystem"] != "grpc" || got[0].Fields["n"] != int64(2) {
+		t.Fatalf("entry = %+v", got[0])
+	}
+}
+
+// レベルとフィールドの両方に合うログだけを、新しい方から Limit 件返すことを確認
+func TestRecentEvents_Query(t *testing.T) {
+	events := NewRecentEvents(10)
+	logger := zap.New(events.Core(zapcore.DebugLevel)).Sugar()
+
+	logger.Infow("ok", "run_id", "run-1", "code", 200)
+	logger.Warnw("slow", "run_id", "run-1", "code", 200)
+	logger.Errorw("failed", "run_id", "run-2", "code", 500)
+	logger.Errorw("failed", "run_id", "run-1", "code", 500)
+
+	got := events.Query(RecentEventsFilter{MinLevel: zapcore.WarnLevel, Fields: map[string]string{"run_id": "run-1"}})
+	if len(got) != 2 || got[0].Message != "slow" || got[1].Message != "failed" {
+		t.Fatalf("query = %+v, want [slow failed(run-1)]", got)
+	}
+	got = events.Query(RecentEventsFilter{Fields: map[string]string{"code": "500"}, Limit: 1})
+	if len(got) != 1 || got[0].Fields["run_id"] != "run-1" {
+		t.Fatalf("query with limit = %+v, want the last failed(run-1)", got)
+	}
+}
@@ -0,0 +1,154 @@
+package observability
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricCollectors は newCounterVec などで定義したメトリクスの実体。init で既定のレジストリに登録する
+var metricCollectors []prometheus.Collector
+
+func addCollector[C prometheus.Collector](c C) C {
+	metricCollectors = append(metricCollectors, c)
+	return c
+}
+
+func init() {
+	if err := RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterMetrics は pkg/observability で定義しているメトリクスをすべて reg に登録する。
+// 既定では prometheus.DefaultRegisterer に登録済み。独自のレジストリで公開する場合やテスト(NewTestRegistry)で使う
+func RegisterMetrics(reg prometheus.Registerer) error {
+	for _, c := range metricCollectors {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestRegistry は pkg/observability のメトリクスだけを登録した独立したレジストリ。
+// インターセプタや負荷のフックがどの系列(ラベルの組)を出したかを、/metrics をスクレイプせずにテストで確認するために使う。
+// メトリクスの実体はパッケージ変数で共有しているため、並列に実行するテストでは使わない
+type TestRegistry struct {
+	reg *prometheus.Registry
+}
+
+// NewTestRegistry はメトリクスの値をリセットし、それらを登録した TestRegistry を返す。
+// 値をリセットできないラベルなしのヒストグラム(cno_app_grpc_streams_per_connection)は前のテストの観測が残る
+func NewTestRegistry() (*TestRegistry, error) {
+	for _, c := range metricCollectors {
+		switch m := c.(type) {
+		case interface{ Reset() }:
+			m.Reset()
+		case prometheus.Gauge:
+			m.Set(0)
+		}
+	}
+	// pedantic: 登録したメトリクスの説明と、実際に出力した系列の矛盾(ラベル名の不一致など)もエラーにする
+	reg := prometheus.NewPedanticRegistry()
+	if err := RegisterMetrics(reg); err != nil {
+		return nil, err
+	}
+	return &TestRegistry{reg: reg}, nil
+}
+
+// Gather は登録したメトリクスの現在値を返す
+func (r *TestRegistry) Gather() ([]*dto.MetricFamily, error) {
+	return r.reg.Gather()
+}
+
+// Series は name のメトリクスが出している系列のラベルの組を返す(ラベルの値の辞書順)
+func (r *TestRegistry) Series(name string) ([]prometheus.Labels, error) {
+	ms, err := r.metrics(name)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]prometheus.Labels, 0, len(ms))
+	for _, m := range ms {
+		out = append(out, labelsOf(m))
+	}
+	sort.Slice(out, func(i, j int) bool { return fmt.Sprint(out[i]) < fmt.Sprint(out[j]) })
+	return out, nil
+}
+
+// Value はラベルの組が labels と完全に一致する系列の値を返す。
+// counter / gauge は値、histogram は観測回数。系列がなければ ok=false
+func (r *TestRegistry) Value(name string, labels prometheus.Labels) (v float64, ok bool, err error) {
+	m, ok, err := r.find(name, labels)
+	if !ok || err != nil {
+		return 0, ok, err
+	}
+	switch {
+	case m.GetCounter() != nil:
+		return m.GetCounter().GetValue(), true, nil
+	case m.GetGauge() != nil:
+		return m.GetGauge().GetValue(), true, nil
+	case m.GetHistogram() != nil:
+		return float64(m.GetHistogram().GetSampleCount()), true, nil
+	}
+	return 0, false, fmt.Errorf("%s: unsupported metric type", name)
+}
+
+// Exemplars は histogram の系列の各バケットに載っている exemplar のラベルを返す
+func (r *TestRegistry) Exemplars(name string, labels prometheus.Labels) ([]prometheus.Labels, error) {
+	m, ok, err := r.find(name, labels)
+	if !ok || err != nil {
+		return nil, err
+	}
+	var out []prometheus.Labels
+	for _, b := range m.GetHistogram().GetBucket() {
+		if e := b.GetExemplar(); e != nil {
+			out = append(out, labelPairs(e.GetLabel()))
+		}
+	}
+	return out, nil
+}
+
+func (r *TestRegistry) find(name string, labels prometheus.Labels) (*dto.Metric, bool, error) {
+	ms, err := r.metrics(name)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, m := range ms {
+		if maps.Equal(labelsOf(m), labels) {
+			return m, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (r *TestRegistry) metrics(name string) ([]*dto.Metric, error) {
+	if _, ok := LookupMetric(name); !ok {
+		return nil, fmt.Errorf("unknown metric %q", name)
+	}
+	mfs, err := r.reg.Gather()
+	if err != nil {
+		return nil, err
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric(), nil
+		}
+	}
+	return nil, nil
+}
+
+func labelsOf(m *dto.Metric) prometheus.Labels {
+	return labelPairs(m.GetLabel())
+}
+
+func labelPairs(pairs []*dto.LabelPair) prometheus.Labels {
+	out := make(prometheus.Labels, len(pairs))
+	for _, p := range pairs {
+		out[p.GetName()] = p.GetValue()
+	}
+	return out
+}
@@ -0,0 +1,328 @@
+package observability
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	envRemoteWriteURL         = "CNO_APP_REMOTE_WRITE_URL"
+	envRemoteWriteInterval    = "CNO_APP_REMOTE_WRITE_INTERVAL"
+	envRemoteWriteTimeout     = "CNO_APP_REMOTE_WRITE_TIMEOUT"
+	envRemoteWriteUsername    = "CNO_APP_REMOTE_WRITE_USERNAME"
+	envRemoteWritePassword    = "CNO_APP_REMOTE_WRITE_PASSWORD"
+	envRemoteWriteBearerToken = "CNO_APP_REMOTE_WRITE_BEARER_TOKEN"
+	envRemoteWriteLabels      = "CNO_APP_REMOTE_WRITE_LABELS"
+
+	defaultRemoteWriteInterval = 15 * time.Second
+	defaultRemoteWriteTimeout  = 10 * time.Second
+)
+
+// RemoteWriteConfig は自分のメトリクスを Prometheus remote-write で送る設定。
+// スクレイプに加えて送るため、手元に Prometheus がない環境(Grafana Cloud だけ、など)でもデモアプリのデータを受け取れる。
+//
+//   - CNO_APP_REMOTE_WRITE_URL : 送信先(例: https://prometheus-prod-01.grafana.net/api/prom/push)。空なら無効
+//   - CNO_APP_REMOTE_WRITE_INTERVAL : 送信間隔(既定 15s)
+//   - CNO_APP_REMOTE_WRITE_TIMEOUT : 1 回の送信のタイムアウト(既定 10s、間隔以下)
+//   - CNO_APP_REMOTE_WRITE_USERNAME / CNO_APP_REMOTE_WRITE_PASSWORD : Basic 認証(Grafana Cloud ではインスタンス ID と API トークン)
+//   - CNO_APP_REMOTE_WRITE_BEARER_TOKEN : Bearer 認証
+//   - CNO_APP_REMOTE_WRITE_LABELS : 全ての系列に付けるラベル("cluster=demo,env=dev")。
+//     スクレイプで付く job / instance の代わりに、既定で job="cno-app" と instance=<service.instance.id> を付ける
+type RemoteWriteConfig struct {
+	URL         string
+	Interval    time.Duration
+	Timeout     time.Duration
+	Username    string
+	Password    string
+	BearerToken string
+	Labels      map[string]string
+}
+
+// RemoteWriteConfigFromEnv は環境変数から remote-write の設定を読み取って検証する
+func RemoteWriteConfigFromEnv() (RemoteWriteConfig, error) {
+	cfg := RemoteWriteConfig{
+		URL:         os.Getenv(envRemoteWriteURL),
+		Interval:    defaultRemoteWriteInterval,
+		Timeout:     defaultRemoteWriteTimeout,
+		Username:    os.Getenv(envRemoteWriteUsername),
+		Password:    os.Getenv(envRemoteWritePassword),
+		BearerToken: os.Getenv(envRemoteWriteBearerToken),
+		Labels: map[string]string{
+			"job":      "cno-app",
+			"instance": ServiceInstanceID(),
+		},
+	}
+	if !cfg.Enabled() {
+		return cfg, nil
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return cfg, fmt.Errorf("invalid %s %q: want an http(s) URL", envRemoteWriteURL, cfg.URL)
+	}
+	for _, d := range []struct {
+		env string
+		dst *time.Duration
+	}{{envRemoteWriteInterval, &cfg.Interval}, {envRemoteWriteTimeout, &cfg.Timeout}} {
+		v := os.Getenv(d.env)
+		if v == "" {
+			continue
+		}
+		dur, err := time.ParseDuration(v)
+		if err != nil || dur <= 0 {
+			return cfg, fmt.Errorf("invalid %s %q: want a positive duration", d.env, v)
+		}
+		*d.dst = dur
+	}
+	cfg.Timeout = min(cfg.Timeout, cfg.Interval)
+	if cfg.Username != "" && cfg.BearerToken != "" {
+		return cfg, fmt.Errorf("%s and %s are mutually exclusive", envRemoteWriteUsername, envRemoteWriteBearerToken)
+	}
+	if v := os.Getenv(envRemoteWriteLabels); v != "" {
+		for _, kv := range strings.Split(v, ",") {
+			k, val, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok || k == "" || strings.HasPrefix(k, "__") {
+				return cfg, fmt.Errorf("invalid %s entry %q: want name=value", envRemoteWriteLabels, kv)
+			}
+			cfg.Labels[k] = val
+		}
+	}
+	return cfg, nil
+}
+
+// Enabled は送信先が設定されているかどうかを返す
+func (c RemoteWriteConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// AuthMode は起動バナーに出す認証方式("basic" / "bearer" / "none")。資格情報そのものは出さない
+func (c RemoteWriteConfig) AuthMode() string {
+	switch {
+	case c.Username != "":
+		return "basic"
+	case c.BearerToken != "":
+		return "bearer"
+	default:
+		return "none"
+	}
+}
+
+// RemoteWriter は gatherer のメトリクスを一定間隔で remote-write(protobuf + snappy、v1)で送る。
+// 送信に失敗したサンプルは再送しない(counter は累積値のため、次の送信で追いつく)
+type RemoteWriter struct {
+	cfg      RemoteWriteConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	logger   *zap.SugaredLogger
+}
+
+// NewRemoteWriter は cfg の送信先へ gatherer のメトリクスを送る RemoteWriter を返す
+func NewRemoteWriter(cfg RemoteWriteConfig, gatherer prometheus.Gatherer, logger *zap.SugaredLogger) *RemoteWriter {
+	return &RemoteWriter{
+		cfg:      cfg,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		logger:   logger,
+	}
+}
+
+// Run は ctx が終わるまで Interval ごとに送信し、終了時に最後の値を 1 回送る
+func (w *RemoteWriter) Run(ctx context.Context) {
+	t := time.NewTicker(w.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.pushAndLog(ctx)
+		case <-ctx.Done():
+			// 停止直前の値を取りこぼさないよう、キャンセルされていない ctx で送る
+			final, cancel := context.WithTimeout(context.WithoutCancel(ctx), w.cfg.Timeout)
+			w.pushAndLog(final)
+			cancel()
+			return
+		}
+	}
+}
+
+func (w *RemoteWriter) pushAndLog(ctx context.Context) {
+	n, err := w.Push(ctx)
+	if err != nil {
+		CNOAppRemoteWriteRequestsTotal.WithLabelValues("failure").Inc()
+		if w.logger != nil {
+			w.logger.Warnw("remote write failed", "url", w.cfg.URL, "error", err)
+		}
+		return
+	}
+	CNOAppRemoteWriteRequestsTotal.WithLabelValues("success").Inc()
+	CNOAppRemoteWriteLastSuccess.SetToCurrentTime()
+	if w.logger != nil {
+		w.logger.Debugw("remote write pushed", "url", w.cfg.URL, "samples", n)
+	}
+}
+
+// Push は現在のメトリクスを 1 回送り、送ったサンプル数を返す
+func (w *RemoteWriter) Push(ctx context.Context) (int, error) {
+	mfs, err := w.gatherer.Gather()
+	if err != nil && len(mfs) == 0 {
+		return 0, fmt.Errorf("gather: %w", err)
+	}
+	body, n := encodeWriteRequest(mfs, w.cfg.Labels, time.Now().UnixMilli())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "cno-app/"+ServiceVersion())
+	switch {
+	case w.cfg.Username != "":
+		req.SetBasicAuth(w.cfg.Username, w.cfg.Password)
+	case w.cfg.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+w.cfg.BearerToken)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("remote write returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return n, nil
+}
+
+// remoteSeries は remote-write の TimeSeries 1 本分(サンプルは 1 つ)
+type remoteSeries struct {
+	labels []*dto.LabelPair
+	value  float64
+}
+
+// encodeWriteRequest は MetricFamily を prometheus.WriteRequest(prompb)の protobuf に変換し、サンプル数とともに返す。
+// histogram は _bucket(le)/ _sum / _count、summary は quantile / _sum / _count の系列に展開する。
+// extra は系列に同名のラベルがない場合だけ付ける(Prometheus の external_labels と同じ)
+func encodeWriteRequest(mfs []*dto.MetricFamily, extra map[string]string, tsMs int64) ([]byte, int) {
+	var buf []byte
+	n := 0
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, s := range expandMetric(mf.GetName(), mf.GetType(), m) {
+				buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+				buf = protowire.AppendBytes(buf, encodeTimeSeries(s, extra, tsMs))
+				n++
+			}
+		}
+	}
+	return buf, n
+}
+
+func expandMetric(name string, typ dto.MetricType, m *dto.Metric) []remoteSeries {
+	base := m.GetLabel()
+	with := func(suffix string, v float64, extra ...*dto.LabelPair) remoteSeries {
+		labels := make([]*dto.LabelPair, 0, len(base)+len(extra)+1)
+		labels = append(labels, labelPair("__name__", name+suffix))
+		labels = append(labels, base...)
+		labels = append(labels, extra...)
+		return remoteSeries{labels: labels, value: v}
+	}
+
+	switch typ {
+	case dto.MetricType_COUNTER:
+		return []remoteSeries{with("", m.GetCounter().GetValue())}
+	case dto.MetricType_GAUGE:
+		return []remoteSeries{with("", m.GetGauge().GetValue())}
+	case dto.MetricType_UNTYPED:
+		return []remoteSeries{with("", m.GetUntyped().GetValue())}
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		h := m.GetHistogram()
+		out := make([]remoteSeries, 0, len(h.GetBucket())+3)
+		hasInf := false
+		for _, b := range h.GetBucket() {
+			if math.IsInf(b.GetUpperBound(), +1) {
+				hasInf = true
+			}
+			out = append(out, with("_bucket", float64(b.GetCumulativeCount()), labelPair("le", formatFloat(b.GetUpperBound()))))
+		}
+		if !hasInf {
+			out = append(out, with("_bucket", float64(h.GetSampleCount()), labelPair("le", "+Inf")))
+		}
+		return append(out, with("_sum", h.GetSampleSum()), with("_count", float64(h.GetSampleCount())))
+	case dto.MetricType_SUMMARY:
+		sm := m.GetSummary()
+		out := make([]remoteSeries, 0, len(sm.GetQuantile())+2)
+		for _, q := range sm.GetQuantile() {
+			out = append(out, with("", q.GetValue(), labelPair("quantile", formatFloat(q.GetQuantile()))))
+		}
+		return append(out, with("_sum", sm.GetSampleSum()), with("_count", float64(sm.GetSampleCount())))
+	default:
+		return nil
+	}
+}
+
+// encodeTimeSeries は TimeSeries{labels = 1, samples = 2} を符号化する。ラベルは名前順でなければならない
+func encodeTimeSeries(s remoteSeries, extra map[string]string, tsMs int64) []byte {
+	labels := make(map[string]string, len(s.labels)+len(extra))
+	for k, v := range extra {
+		labels[k] = v
+	}
+	for _, l := range s.labels {
+		labels[l.GetName()] = l.GetValue()
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var buf []byte
+	for _, k := range names {
+		var l []byte
+		l = protowire.AppendTag(l, 1, protowire.BytesType)
+		l = protowire.AppendString(l, k)
+		l = protowire.AppendTag(l, 2, protowire.BytesType)
+		l = protowire.AppendString(l, labels[k])
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, l)
+	}
+
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(tsMs))
+	buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+	return protowire.AppendBytes(buf, sample)
+}
+
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// formatFloat は le / quantile ラベルの値を Prometheus のテキスト形式と同じ表記にする
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
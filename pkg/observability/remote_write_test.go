@@ -0,0 +1,207 @@
+package observability
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodedSeries は WriteRequest を読み戻した系列(ラベルを "name=value" の並びにしたもの)と値
+type decodedSeries struct {
+	labels []string
+	value  float64
+	tsMs   int64
+}
+
+// decodeWriteRequest はテスト用に prompb.WriteRequest の timeseries だけを読み戻す
+func decodeWriteRequest(t *testing.T, b []byte) []decodedSeries {
+	t.Helper()
+	var out []decodedSeries
+	for len(b) > 0 {
+		num, ts := consumeField(t, &b)
+		if num != 1 {
+			continue
+		}
+		var s decodedSeries
+		for len(ts) > 0 {
+			num, v := consumeField(t, &ts)
+			switch num {
+			case 1:
+				_, name := consumeField(t, &v)
+				_, value := consumeField(t, &v)
+				s.labels = append(s.labels, string(name)+"="+string(value))
+			case 2:
+				for len(v) > 0 {
+					_, typ, n := protowire.ConsumeTag(v)
+					v = v[n:]
+					switch typ {
+					case protowire.Fixed64Type:
+						bits, m := protowire.ConsumeFixed64(v)
+						s.value = math.Float64frombits(bits)
+						v = v[m:]
+					case protowire.VarintType:
+						ms, m := protowire.ConsumeVarint(v)
+						s.tsMs = int64(ms)
+						v = v[m:]
+					default:
+						t.Fatalf("unexpected sample field type %d", typ)
+					}
+				}
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// consumeField は length-delimited のフィールドを 1 つ読み、番号と中身を返す
+func consumeField(t *testing.T, b *[]byte) (protowire.Number, []byte) {
+	t.Helper()
+	num, typ, n := protowire.ConsumeTag(*b)
+	if n < 0 || typ != protowire.BytesType {
+		t.Fatalf("unexpected field (num %d, type %d)", num, typ)
+	}
+	v, m := protowire.ConsumeBytes((*b)[n:])
+	if m < 0 {
+		t.Fatalf("truncated field %d", num)
+	}
+	*b = (*b)[n+m:]
+	return num, v
+}
+
+func findSeries(series []decodedSeries, labels ...string) (decodedSeries, bool) {
+	for _, s := range series {
+		if strings.Join(s.labels, ",") == strings.Join(labels, ",") {
+			return s, true
+		}
+	}
+	return decodedSeries{}, false
+}
+
+// counter / histogram を remote-write の系列に展開し、ラベルは名前順で、付加ラベルは系列のラベルを上書きしない
+func TestEncodeWriteRequest(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "demo_total", Help: "h"}, []string{"job"})
+	c.WithLabelValues("own").Add(3)
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "demo_seconds", Help: "h", Buckets: []float64{0.5}})
+	h.Observe(0.1)
+	h.Observe(2)
+	reg.MustRegister(c, h)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	body, n := encodeWriteRequest(mfs, map[string]string{"job": "cno-app", "cluster": "demo"}, 1000)
+	series := decodeWriteRequest(t, body)
+	if n != 5 || len(series) != 5 {
+		t.Fatalf("samples = %d, decoded %d series, want 5 (counter + 2 buckets + sum + count)", n, len(series))
+	}
+
+	for _, want := range []struct {
+		labels []string
+		value  float64
+	}{
+		{[]string{"__name__=demo_total", "cluster=demo", "job=own"}, 3},
+		{[]string{"__name__=demo_seconds_bucket", "cluster=demo", "job=cno-app", "le=0.5"}, 1},
+		{[]string{"__name__=demo_seconds_bucket", "cluster=demo", "job=cno-app", "le=+Inf"}, 2},
+		{[]string{"__name__=demo_seconds_sum", "cluster=demo", "job=cno-app"}, 2.1},
+		{[]string{"__name__=demo_seconds_count", "cluster=demo", "job=cno-app"}, 2},
+	} {
+		s, ok := findSeries(series, want.labels...)
+		if !ok {
+			t.Fatalf("series %v not found in %v", want.labels, series)
+		}
+		if s.value != want.value || s.tsMs != 1000 {
+			t.Fatalf("series %v = (%v @ %d), want (%v @ 1000)", want.labels, s.value, s.tsMs, want.value)
+		}
+	}
+}
+
+// snappy で圧縮した protobuf を remote-write v1 のヘッダーと認証付きで送る。2xx 以外はエラーにする
+func TestRemoteWriter_Push(t *testing.T) {
+	var got []decodedSeries
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "123" || pass != "secret" {
+			t.Errorf("basic auth = (%q, %q, %v)", user, pass, ok)
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("snappy decode: %v", err)
+		}
+		got = decodeWriteRequest(t, body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "demo_gauge", Help: "h"})
+	g.Set(7)
+	reg.MustRegister(g)
+
+	cfg := RemoteWriteConfig{URL: srv.URL, Timeout: time.Second, Username: "123", Password: "secret", Labels: map[string]string{"job": "cno-app"}}
+	w := NewRemoteWriter(cfg, reg, nil)
+	n, err := w.Push(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("Push = (%d, %v), want 1 sample", n, err)
+	}
+	if s, ok := findSeries(got, "__name__=demo_gauge", "job=cno-app"); !ok || s.value != 7 {
+		t.Fatalf("received %v", got)
+	}
+
+	status = http.StatusUnauthorized
+	if _, err := w.Push(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Push with 401: err = %v", err)
+	}
+}
+
+func TestRemoteWriteConfigFromEnv(t *testing.T) {
+	t.Setenv(envRemoteWriteURL, "")
+	if cfg, err := RemoteWriteConfigFromEnv(); err != nil || cfg.Enabled() {
+		t.Fatalf("unset: got (%+v, %v), want disabled", cfg, err)
+	}
+
+	t.Setenv(envRemoteWriteURL, "https://example.com/api/prom/push")
+	t.Setenv(envRemoteWriteInterval, "30s")
+	t.Setenv(envRemoteWriteTimeout, "1m")
+	t.Setenv(envRemoteWriteLabels, "cluster=demo, job=demo-app")
+	cfg, err := RemoteWriteConfigFromEnv()
+	if err != nil {
+		t.Fatalf("RemoteWriteConfigFromEnv: %v", err)
+	}
+	if cfg.Interval != 30*time.Second || cfg.Timeout != 30*time.Second {
+		t.Fatalf("interval/timeout = %s/%s, want 30s/30s (timeout capped by interval)", cfg.Interval, cfg.Timeout)
+	}
+	if cfg.Labels["cluster"] != "demo" || cfg.Labels["job"] != "demo-app" || cfg.Labels["instance"] == "" {
+		t.Fatalf("labels = %v", cfg.Labels)
+	}
+
+	for env, v := range map[string]string{
+		envRemoteWriteURL:         "ftp://example.com",
+		envRemoteWriteInterval:    "0s",
+		envRemoteWriteLabels:      "__name__=x",
+		envRemoteWriteBearerToken: "token", // USERNAME と同時には指定できない
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(envRemoteWriteUsername, "user")
+			t.Setenv(env, v)
+			if _, err := RemoteWriteConfigFromEnv(); err == nil {
+				t.Fatalf("expected error for %s=%q", env, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package observability
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// envResourceDetectors は TracerProvider の Resource に追加する detector をカンマ区切りで指定する環境変数
+// (例: "container,host,ec2")。未設定なら service.* と OTEL_RESOURCE_ATTRIBUTES だけを付ける。
+// Kubernetes では k8s.* を Collector の k8sattributes で付けるが、それ以外の環境でも出所が分かる属性を載せるため
+const envResourceDetectors = "CNO_APP_RESOURCE_DETECTORS"
+
+// CNO_APP_RESOURCE_DETECTORS に指定できる detector
+const (
+	DetectorContainer = "container" // container.id(cgroup から取得)
+	DetectorHost      = "host"      // host.name / host.id
+	DetectorOS        = "os"        // os.type / os.description
+	DetectorProcess   = "process"   // process.pid / process.executable.name / process.runtime.*(コマンドライン引数は含めない)
+	DetectorEC2       = "ec2"       // cloud.* / host.*(EC2 のインスタンスメタデータ IMDSv2)
+	DetectorGCP       = "gcp"       // cloud.* / host.*(GCE のメタデータサーバー)
+)
+
+var resourceDetectorNames = []string{DetectorContainer, DetectorHost, DetectorOS, DetectorProcess, DetectorEC2, DetectorGCP}
+
+// cloudMetadataTimeout はクラウドのメタデータサーバーへの問い合わせのタイムアウト。
+// 該当しない環境では接続できないため、起動を遅らせないよう短くする
+const cloudMetadataTimeout = time.Second
+
+// ValidateTracerEnv は TracerProvider が読む環境変数(CNO_APP_RESOURCE_DETECTORS、CNO_APP_TRACE_SCRUB_*、CNO_APP_SPAN_METRICS、OTEL_TRACES_EXPORTER)を検証する
+func ValidateTracerEnv() error {
+	if _, err := resourceDetectorsFromEnv(); err != nil {
+		return err
+	}
+	if _, err := scrubberFromEnv(); err != nil {
+		return err
+	}
+	if _, err := SpanMetricsFromEnv(); err != nil {
+		return err
+	}
+	return validateTraceExporterEnv()
+}
+
+// resourceDetectorsFromEnv は CNO_APP_RESOURCE_DETECTORS を読み取る。未設定なら nil
+func resourceDetectorsFromEnv() ([]string, error) {
+	v := os.Getenv(envResourceDetectors)
+	if v == "" {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(resourceDetectorNames, name) {
+			return nil, fmt.Errorf("invalid %s: unknown detector %q (valid: %s)", envResourceDetectors, name, strings.Join(resourceDetectorNames, ", "))
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// resourceDetectorOptions は detector 名を resource.New のオプションに変換する。
+// クラウドの detector は host.id をインスタンス ID で上書きするよう最後に並べる
+func resourceDetectorOptions(names []string) []resource.Option {
+	var opts []resource.Option
+	var cloud []resource.Detector
+	for _, name := range names {
+		switch name {
+		case DetectorContainer:
+			opts = append(opts, resource.WithContainer())
+		case DetectorHost:
+			opts = append(opts, resource.WithHost(), resource.WithHostID())
+		case DetectorOS:
+			opts = append(opts, resource.WithOS())
+		case DetectorProcess:
+			opts = append(opts,
+				resource.WithProcessPID(),
+				resource.WithProcessExecutableName(),
+				resource.WithProcessRuntimeName(),
+				resource.WithProcessRuntimeVersion(),
+			)
+		case DetectorEC2:
+			cloud = append(cloud, ec2Detector{endpoint: "http://169.254.169.254"})
+		case DetectorGCP:
+			cloud = append(cloud, gcpDetector{endpoint: "http://metadata.google.internal"})
+		}
+	}
+	if len(cloud) > 0 {
+		opts = append(opts, resource.WithDetectors(cloud...))
+	}
+	return opts
+}
+
+// ec2Detector は EC2 のインスタンスメタデータ(IMDSv2)から cloud.* / host.* を取得する。
+// メタデータに接続できない(EC2 ではない)場合は空の Resource を返す
+type ec2Detector struct {
+	endpoint string
+}
+
+func (d ec2Detector) Detect(ctx context.Context) (*resource.Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, cloudMetadataTimeout)
+	defer cancel()
+
+	// IMDSv2: PUT でセッショントークンを取得してから読む
+	token, err := metadataGet(ctx, http.MethodPut, d.endpoint+"/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return resource.Empty(), nil
+	}
+	body, err := metadataGet(ctx, http.MethodGet, d.endpoint+"/latest/dynamic/instance-identity/document",
+		map[string]string{"X-aws-ec2-metadata-token": string(token)})
+	if err != nil {
+		return resource.Empty(), nil
+	}
+
+	var doc struct {
+		AccountID        string `json:"accountId"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+		InstanceID       string `json:"instanceId"`
+		InstanceType     string `json:"instanceType"`
+		ImageID          string `json:"imageId"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("ec2 detector: decode instance identity document: %w", err)
+	}
+	return resource.NewSchemaless(
+		attribute.String("cloud.provider", "aws"),
+		attribute.String("cloud.platform", "aws_ec2"),
+		attribute.String("cloud.account.id", doc.AccountID),
+		attribute.String("cloud.region", doc.Region),
+		attribute.String("cloud.availability_zone", doc.AvailabilityZone),
+		attribute.String("host.id", doc.InstanceID),
+		attribute.String("host.type", doc.InstanceType),
+		attribute.String("host.image.id", doc.ImageID),
+	), nil
+}
+
+// gcpDetector は GCE のメタデータサーバーから cloud.* / host.* を取得する。
+// メタデータサーバーに接続できない(GCE ではない)場合は空の Resource を返す
+type gcpDetector struct {
+	endpoint string
+}
+
+func (d gcpDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, cloudMetadataTimeout)
+	defer cancel()
+
+	body, err := metadataGet(ctx, http.MethodGet, d.endpoint+"/computeMetadata/v1/?recursive=true",
+		map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return resource.Empty(), nil
+	}
+
+	var md struct {
+		Instance struct {
+			ID          json.Number `json:"id"`
+			Name        string      `json:"name"`
+			Zone        string      `json:"zone"`        // projects/<番号>/zones/<zone>
+			MachineType string      `json:"machineType"` // projects/<番号>/machineTypes/<type>
+		} `json:"instance"`
+		Project struct {
+			ProjectID string `json:"projectId"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &md); err != nil {
+		return nil, fmt.Errorf("gcp detector: decode metadata: %w", err)
+	}
+	zone := lastPathElem(md.Instance.Zone)
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+	return resource.NewSchemaless(
+		attribute.String("cloud.provider", "gcp"),
+		attribute.String("cloud.platform", "gcp_compute_engine"),
+		attribute.String("cloud.account.id", md.Project.ProjectID),
+		attribute.String("cloud.region", region),
+		attribute.String("cloud.availability_zone", zone),
+		attribute.String("host.id", md.Instance.ID.String()),
+		attribute.String("host.name", md.Instance.Name),
+		attribute.String("host.type", lastPathElem(md.Instance.MachineType)),
+	), nil
+}
+
+// metadataGet はメタデータサーバーに method でリクエストし、200 以外はエラーにする
+func metadataGet(ctx context.Context, method, url string, header map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
+
+func lastPathElem(s string) string {
+	return s[strings.LastIndex(s, "/")+1:]
+}
@@ -0,0 +1,117 @@
+package observability
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+func TestResourceDetectorsFromEnv(t *testing.T) {
+	t.Setenv(envResourceDetectors, " Container, host,,ec2,host ")
+	got, err := resourceDetectorsFromEnv()
+	if err != nil {
+		t.Fatalf("resourceDetectorsFromEnv: %v", err)
+	}
+	if want := []string{"container", "host", "ec2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	t.Setenv(envResourceDetectors, "host,azure")
+	if err := ValidateTracerEnv(); err == nil {
+		t.Fatal("ValidateTracerEnv: want error for unknown detector")
+	}
+}
+
+// 組み込みの detector とクラウドの detector を重ねても schema URL の衝突などでエラーにならない
+func TestResourceDetectorOptions(t *testing.T) {
+	opts := resourceDetectorOptions([]string{DetectorContainer, DetectorHost, DetectorOS, DetectorProcess})
+	opts = append(opts, resource.WithAttributes(attribute.String("service.name", "cno-app")))
+	res, err := resource.New(context.Background(), opts...)
+	if err != nil && res == nil {
+		t.Fatalf("resource.New: %v", err)
+	}
+	if v, ok := res.Set().Value("os.type"); !ok || v.AsString() == "" {
+		t.Fatalf("os.type not detected: %v", res)
+	}
+	if _, ok := res.Set().Value("process.pid"); !ok {
+		t.Fatalf("process.pid not detected: %v", res)
+	}
+}
+
+func TestEC2Detector(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			_, _ = w.Write([]byte("tok"))
+		case r.URL.Path == "/latest/dynamic/instance-identity/document" && r.Header.Get("X-aws-ec2-metadata-token") == "tok":
+			_, _ = w.Write([]byte(`{"accountId":"123456789012","region":"ap-northeast-1","availabilityZone":"ap-northeast-1a","instanceId":"i-0abc","instanceType":"m5.large","imageId":"ami-1"}`))
+		default:
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	res, err := ec2Detector{endpoint: srv.URL}.Detect(context.Background())
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	assertResource(t, res, map[string]string{
+		"cloud.provider":          "aws",
+		"cloud.region":            "ap-northeast-1",
+		"cloud.availability_zone": "ap-northeast-1a",
+		"host.id":                 "i-0abc",
+		"host.type":               "m5.large",
+	})
+}
+
+func TestGCPDetector(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"instance":{"id":4520031799277581759,"name":"vm-1","zone":"projects/123/zones/asia-northeast1-b","machineType":"projects/123/machineTypes/e2-standard-4"},"project":{"projectId":"demo-project"}}`))
+	}))
+	defer srv.Close()
+
+	res, err := gcpDetector{endpoint: srv.URL}.Detect(context.Background())
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	assertResource(t, res, map[string]string{
+		"cloud.provider":          "gcp",
+		"cloud.account.id":        "demo-project",
+		"cloud.region":            "asia-northeast1",
+		"cloud.availability_zone": "asia-northeast1-b",
+		"host.id":                 "4520031799277581759",
+		"host.type":               "e2-standard-4",
+	})
+}
+
+// メタデータサーバーに接続できない環境では、エラーにせず空の Resource を返す
+func TestCloudDetectors_Unreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	for _, d := range []resource.Detector{ec2Detector{endpoint: srv.URL}, gcpDetector{endpoint: srv.URL}} {
+		res, err := d.Detect(context.Background())
+		if err != nil || res.Len() != 0 {
+			t.Fatalf("%T.Detect = %v, %v; want empty resource", d, res, err)
+		}
+	}
+}
+
+func assertResource(t *testing.T, res *resource.Resource, want map[string]string) {
+	t.Helper()
+	for k, v := range want {
+		got, ok := res.Set().Value(attribute.Key(k))
+		if !ok || got.AsString() != v {
+			t.Errorf("%s = %q, want %q", k, got.AsString(), v)
+		}
+	}
+}
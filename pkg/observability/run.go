@@ -0,0 +1,43 @@
+package observability
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RunIDKey は 1 回のクライアント実行(run)に属する全 span に付与する属性キー
+const RunIDKey = attribute.Key("run_id")
+
+// runIDProcessor は開始される全 span に run_id 属性を付与する SpanProcessor
+type runIDProcessor struct {
+	runID string
+}
+
+func (p runIDProcessor) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	s.SetAttributes(RunIDKey.String(p.runID))
+}
+
+func (runIDProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (runIDProcessor) Shutdown(context.Context) error   { return nil }
+func (runIDProcessor) ForceFlush(context.Context) error { return nil }
+
+// StartIterationSpan は繰り返し実行の 1 イテレーション分の span を新しいトレースとして開始し、
+// ctx に含まれる run のルート span へ span link を張る。
+// イテレーションごとにトレースを分けつつ、Tempo ではリンク/run_id から同じ run のトレースを辿れる
+func StartIterationSpan(ctx context.Context, tracer trace.Tracer, name string, iteration int) (context.Context, trace.Span) {
+	root := trace.SpanContextFromContext(ctx)
+	opts := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithAttributes(attribute.Int("iteration", iteration)),
+	}
+	if root.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{
+			SpanContext: root,
+			Attributes:  []attribute.KeyValue{attribute.String("link.type", "run_root")},
+		}))
+	}
+	return tracer.Start(ctx, name, opts...)
+}
@@ -0,0 +1,79 @@
+package observability
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// RuntimeMetricsConfig は Go ランタイム/プロセスのコレクタ(go_*, process_*)の登録方法
+type RuntimeMetricsConfig struct {
+	Enabled   bool              // false の場合は go_* / process_* を公開しない
+	Namespace string            // メトリクス名のプレフィックス(例: "cno" → cno_go_goroutines)
+	Labels    prometheus.Labels // 全ランタイムメトリクスに付与する const label
+}
+
+// RuntimeMetricsConfigFromEnv は環境変数から RuntimeMetricsConfig を組み立てる。
+// const label は OTel の resource 属性(service.name / service.instance.id / service.version)と揃える。
+// Prometheus のターゲットラベル instance と衝突しないよう、ラベル名は service_instance にしている
+//
+//   - CNO_APP_METRICS_RUNTIME_COLLECTORS : "false" で go_* / process_* を無効化
+//   - CNO_APP_METRICS_NAMESPACE : ランタイムメトリクス名のプレフィックス
+func RuntimeMetricsConfigFromEnv(service string) RuntimeMetricsConfig {
+	return RuntimeMetricsConfig{
+		Enabled:   !strings.EqualFold(os.Getenv("CNO_APP_METRICS_RUNTIME_COLLECTORS"), "false"),
+		Namespace: os.Getenv("CNO_APP_METRICS_NAMESPACE"),
+		Labels: prometheus.Labels{
+			"service":          service,
+			"service_instance": ServiceInstanceID(),
+			"service_version":  ServiceVersion(),
+		},
+	}
+}
+
+// RegisterRuntimeCollectors は client_golang が既定で登録する Go/プロセスコレクタを外し、
+// cfg の namespace / const label を付けて別の Registry に登録し直す。
+// 戻り値は /metrics で公開する Gatherer(既定 Registry + ランタイム用 Registry)。
+//
+// 既定 Registry は Unregister 後も同名メトリクスのラベル構成を覚えているため、
+// const label を付けたコレクタは同じ Registry に登録し直せない
+func RegisterRuntimeCollectors(cfg RuntimeMetricsConfig) (prometheus.Gatherer, error) {
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	if !cfg.Enabled {
+		return prometheus.DefaultGatherer, nil
+	}
+
+	runtimeReg := prometheus.NewRegistry()
+	var reg prometheus.Registerer = runtimeReg
+	if len(cfg.Labels) > 0 {
+		reg = prometheus.WrapRegistererWith(cfg.Labels, reg)
+	}
+	if cfg.Namespace != "" {
+		reg = prometheus.WrapRegistererWithPrefix(cfg.Namespace+"_", reg)
+	}
+
+	if err := reg.Register(collectors.NewGoCollector()); err != nil {
+		return nil, fmt.Errorf("register go collector: %w", err)
+	}
+	if err := reg.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
+		return nil, fmt.Errorf("register process collector: %w", err)
+	}
+	return prometheus.Gatherers{prometheus.DefaultGatherer, runtimeReg}, nil
+}
+
+// ServiceInstanceID はインスタンスを識別する ID を返す。
+// CNO_APP_INSTANCE があればそれを、なければホスト名(Kubernetes では Pod 名)を使う
+func ServiceInstanceID() string {
+	if v := os.Getenv("CNO_APP_INSTANCE"); v != "" {
+		return v
+	}
+	if h, err := os.Hostname(); err == nil {
+		return h
+	}
+	return "unknown"
+}
@@ -0,0 +1,182 @@
+package observability
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// 属性のスクラブ(エクスポート前の削除/ハッシュ化)の設定。値はカンマ区切りの属性キーのパターン(path.Match 形式、大文字小文字を区別しない)。
+// "off" で無効。両方に一致する属性は削除する
+const (
+	envTraceScrubRemove = "CNO_APP_TRACE_SCRUB_REMOVE"
+	envTraceScrubHash   = "CNO_APP_TRACE_SCRUB_HASH"
+)
+
+// DefaultScrubRemovePatterns は CNO_APP_TRACE_SCRUB_REMOVE が未設定のときに削除する属性キーのパターン。
+// 認証情報が metadata の許可リストなどから誤って span に載っても、Collector に送らないようにする
+var DefaultScrubRemovePatterns = []string{"*authorization*", "*cookie*", "*token*", "*password*", "*secret*", "*api-key*", "*api_key*"}
+
+// scrubHashPrefix はハッシュ化した値の接頭辞。元の値と区別できるようにする
+const scrubHashPrefix = "sha256:"
+
+// AttributeScrubber は deny-list のパターンに一致する属性を削除またはハッシュ化する。
+// ハッシュ化した値は同じ値どうしで一致するため、テナントごとの集計や検索には使えるが元の値は載らない
+// (値の種類が少ない場合は総当たりで戻せるため、秘密情報には Remove を使う)
+type AttributeScrubber struct {
+	Remove []string
+	Hash   []string
+}
+
+// Enabled はパターンが 1 つでもあるかどうか
+func (s AttributeScrubber) Enabled() bool {
+	return len(s.Remove) > 0 || len(s.Hash) > 0
+}
+
+// Validate はパターンの構文を検証する
+func (s AttributeScrubber) Validate() error {
+	for _, p := range slices.Concat(s.Remove, s.Hash) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid scrub pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Scrub は kvs のうちパターンに一致する属性を削除/ハッシュ化した結果と、変更があったかどうかを返す。
+// 変更がなければ kvs をそのまま返す
+func (s AttributeScrubber) Scrub(kvs []attribute.KeyValue) ([]attribute.KeyValue, bool) {
+	changed := false
+	out := make([]attribute.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		key := strings.ToLower(string(kv.Key))
+		switch {
+		case matchAny(s.Remove, key):
+			changed = true
+		case matchAny(s.Hash, key):
+			out = append(out, hashAttribute(kv))
+			changed = true
+		default:
+			out = append(out, kv)
+		}
+	}
+	if !changed {
+		return kvs, false
+	}
+	return out, true
+}
+
+func matchAny(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAttribute は値を sha256 の先頭 16 桁に置き換える。文字列配列は要素ごとに、それ以外の型は文字列にしてからハッシュ化する
+func hashAttribute(kv attribute.KeyValue) attribute.KeyValue {
+	if kv.Value.Type() == attribute.STRINGSLICE {
+		vals := kv.Value.AsStringSlice()
+		hashed := make([]string, len(vals))
+		for i, v := range vals {
+			hashed[i] = hashValue(v)
+		}
+		return kv.Key.StringSlice(hashed)
+	}
+	return kv.Key.String(hashValue(kv.Value.Emit()))
+}
+
+func hashValue(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return scrubHashPrefix + hex.EncodeToString(sum[:8])
+}
+
+// scrubberFromEnv は CNO_APP_TRACE_SCRUB_REMOVE / CNO_APP_TRACE_SCRUB_HASH を読み取る
+func scrubberFromEnv() (AttributeScrubber, error) {
+	s := AttributeScrubber{
+		Remove: scrubPatternsFromEnv(envTraceScrubRemove, DefaultScrubRemovePatterns),
+		Hash:   scrubPatternsFromEnv(envTraceScrubHash, nil),
+	}
+	if err := s.Validate(); err != nil {
+		return AttributeScrubber{}, fmt.Errorf("invalid %s/%s: %w", envTraceScrubRemove, envTraceScrubHash, err)
+	}
+	return s, nil
+}
+
+func scrubPatternsFromEnv(key string, def []string) []string {
+	v := strings.TrimSpace(os.Getenv(key))
+	switch {
+	case v == "":
+		return def
+	case strings.EqualFold(v, "off"):
+		return nil
+	}
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// scrubProcessor は次の SpanProcessor(エクスポート用の BatchSpanProcessor)に渡す前に、
+// span と event の属性をスクラブする SpanProcessor。
+// OnEnd の span は読み取り専用のため、属性だけを差し替えた ReadOnlySpan で包んで渡す
+type scrubProcessor struct {
+	next     sdktrace.SpanProcessor
+	scrubber AttributeScrubber
+}
+
+func (p scrubProcessor) OnStart(ctx context.Context, s sdktrace.ReadWriteSpan) {
+	p.next.OnStart(ctx, s)
+}
+
+func (p scrubProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	attrs, _ := p.scrubber.Scrub(s.Attributes())
+	p.next.OnEnd(scrubbedSpan{
+		ReadOnlySpan: s,
+		attrs:        attrs,
+		events:       p.scrubEvents(s.Events()),
+	})
+}
+
+func (p scrubProcessor) scrubEvents(events []sdktrace.Event) []sdktrace.Event {
+	var out []sdktrace.Event
+	for i, e := range events {
+		attrs, changed := p.scrubber.Scrub(e.Attributes)
+		if !changed {
+			continue
+		}
+		if out == nil {
+			out = slices.Clone(events)
+		}
+		out[i].Attributes = attrs
+	}
+	if out == nil {
+		return events
+	}
+	return out
+}
+
+func (p scrubProcessor) Shutdown(ctx context.Context) error   { return p.next.Shutdown(ctx) }
+func (p scrubProcessor) ForceFlush(ctx context.Context) error { return p.next.ForceFlush(ctx) }
+
+type scrubbedSpan struct {
+	sdktrace.ReadOnlySpan
+	attrs  []attribute.KeyValue
+	events []sdktrace.Event
+}
+
+func (s scrubbedSpan) Attributes() []attribute.KeyValue { return s.attrs }
+func (s scrubbedSpan) Events() []sdktrace.Event         { return s.events }
@@ -0,0 +1,109 @@
+package observability
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAttributeScrubber_Scrub(t *testing.T) {
+	s := AttributeScrubber{
+		Remove: []string{"*token*", "*tenant*"},
+		Hash:   []string{"rpc.grpc.request.metadata.x-tenant", "user.id"},
+	}
+	in := []attribute.KeyValue{
+		attribute.String("request_id", "req-1"),
+		attribute.String("Auth.Token", "secret"),
+		attribute.StringSlice("rpc.grpc.request.metadata.x-tenant", []string{"acme"}),
+		attribute.Int("user.id", 42),
+	}
+	got, changed := s.Scrub(in)
+	if !changed {
+		t.Fatal("Scrub: changed = false")
+	}
+	m := attribute.NewSet(got...)
+	if _, ok := m.Value("auth.token"); ok || m.HasValue("Auth.Token") {
+		t.Fatalf("token attribute not removed: %v", got)
+	}
+	// Remove と Hash の両方に一致する場合は削除する
+	if m.HasValue("rpc.grpc.request.metadata.x-tenant") {
+		t.Fatalf("tenant attribute not removed: %v", got)
+	}
+	if v, _ := m.Value("user.id"); v.AsString() != hashValue("42") {
+		t.Fatalf("user.id = %v, want hashed", v.Emit())
+	}
+	if v, _ := m.Value("request_id"); v.AsString() != "req-1" {
+		t.Fatalf("request_id = %v, want unchanged", v.Emit())
+	}
+
+	keep := []attribute.KeyValue{attribute.String("request_id", "req-1")}
+	if out, changed := s.Scrub(keep); changed || &out[0] != &keep[0] {
+		t.Fatal("Scrub: want the input slice when nothing matches")
+	}
+}
+
+func TestHashAttribute_StringSlice(t *testing.T) {
+	kv := hashAttribute(attribute.StringSlice("x-tenant", []string{"acme", "globex"}))
+	vals := kv.Value.AsStringSlice()
+	if len(vals) != 2 || vals[0] != hashValue("acme") || vals[1] != hashValue("globex") {
+		t.Fatalf("hashed = %v", vals)
+	}
+	if hashValue("acme") == hashValue("globex") || len(hashValue("acme")) != len(scrubHashPrefix)+16 {
+		t.Fatalf("hashValue = %q", hashValue("acme"))
+	}
+}
+
+// 次の SpanProcessor には span と event の属性をスクラブした span が渡り、元の span は変更しない
+func TestScrubProcessor(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(scrubProcessor{
+		next:     rec,
+		scrubber: AttributeScrubber{Remove: []string{"*token*"}, Hash: []string{"tenant"}},
+	}))
+	_, span := tp.Tracer("test").Start(context.Background(), "op",
+		trace.WithAttributes(attribute.String("tenant", "acme"), attribute.String("api.token", "t")))
+	span.AddEvent("retry", trace.WithAttributes(attribute.String("refresh_token", "t"), attribute.Int("attempt", 2)))
+	span.End()
+
+	ended := rec.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("ended = %d spans", len(ended))
+	}
+	attrs := attribute.NewSet(ended[0].Attributes()...)
+	if attrs.HasValue("api.token") {
+		t.Fatalf("attributes = %v, want api.token removed", ended[0].Attributes())
+	}
+	if v, _ := attrs.Value("tenant"); v.AsString() != hashValue("acme") {
+		t.Fatalf("tenant = %v, want hashed", v.Emit())
+	}
+	ev := ended[0].Events()
+	if len(ev) != 1 || len(ev[0].Attributes) != 1 || ev[0].Attributes[0].Key != "attempt" {
+		t.Fatalf("events = %+v, want refresh_token removed", ev)
+	}
+	if name := ended[0].Name(); name != "op" {
+		t.Fatalf("name = %q, want the wrapped span's fields", name)
+	}
+}
+
+func TestScrubberFromEnv(t *testing.T) {
+	s, err := scrubberFromEnv()
+	if err != nil || len(s.Remove) != len(DefaultScrubRemovePatterns) || len(s.Hash) != 0 {
+		t.Fatalf("default = %+v, %v", s, err)
+	}
+
+	t.Setenv(envTraceScrubRemove, "off")
+	t.Setenv(envTraceScrubHash, " X-Tenant , ")
+	s, err = scrubberFromEnv()
+	if err != nil || len(s.Remove) != 0 || len(s.Hash) != 1 || s.Hash[0] != "x-tenant" {
+		t.Fatalf("scrubberFromEnv = %+v, %v", s, err)
+	}
+
+	t.Setenv(envTraceScrubHash, "[tenant")
+	if err := ValidateTracerEnv(); err == nil {
+		t.Fatal("ValidateTracerEnv: want error for bad pattern")
+	}
+}
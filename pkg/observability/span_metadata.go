@@ -0,0 +1,63 @@
+package observability
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultSpanMetadataKeys は span 属性にコピーする incoming metadata の既定の許可リスト
+var DefaultSpanMetadataKeys = []string{"x-request-id", "x-tenant", "user-agent"}
+
+// MetadataAttributes は incoming metadata のうち keys に含まれるものを span 属性に変換する。
+// 属性名は OTel semconv の rpc.grpc.request.metadata.<key>(値は文字列配列)。
+// x-request-id は Tempo で検索しやすいよう request_id としても載せる
+func MetadataAttributes(md metadata.MD, keys []string) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, k := range keys {
+		k = strings.ToLower(k)
+		vals := md.Get(k)
+		if len(vals) == 0 {
+			continue
+		}
+		attrs = append(attrs, attribute.StringSlice("rpc.grpc.request.metadata."+k, vals))
+		if k == "x-request-id" {
+			attrs = append(attrs, attribute.String("request_id", vals[0]))
+		}
+	}
+	return attrs
+}
+
+func recordMetadata(ctx context.Context, keys []string) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return
+	}
+	if attrs := MetadataAttributes(md, keys); len(attrs) > 0 {
+		span.SetAttributes(attrs...)
+	}
+}
+
+// UnarySpanMetadataInterceptor は許可リストの metadata をサーバー側 Unary RPC の span に記録する
+func UnarySpanMetadataInterceptor(keys []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		recordMetadata(ctx, keys)
+		return handler(ctx, req)
+	}
+}
+
+// StreamSpanMetadataInterceptor は許可リストの metadata をサーバー側 Streaming RPC の span に記録する
+func StreamSpanMetadataInterceptor(keys []string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		recordMetadata(ss.Context(), keys)
+		return handler(srv, ss)
+	}
+}
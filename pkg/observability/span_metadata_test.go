@@ -0,0 +1,35 @@
+package observability
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// 許可リストにないキーは載せず、x-request-id は request_id にも複製されることを確認
+func TestMetadataAttributes(t *testing.T) {
+	md := metadata.Pairs(
+		"x-request-id", "req-1",
+		"x-tenant", "team-a",
+		"authorization", "Bearer secret",
+	)
+
+	got := map[string]string{}
+	for _, kv := range MetadataAttributes(md, []string{"X-Request-Id", "x-tenant", "user-agent"}) {
+		got[string(kv.Key)] = kv.Value.Emit()
+	}
+
+	want := map[string]string{
+		"rpc.grpc.request.metadata.x-request-id": `["req-1"]`,
+		"rpc.grpc.request.metadata.x-tenant":     `["team-a"]`,
+		"request_id":                             "req-1",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
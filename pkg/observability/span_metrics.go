@@ -0,0 +1,65 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const envSpanMetrics = "CNO_APP_SPAN_METRICS"
+
+var (
+	CNOAppSpanCallsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "cno_app_span_calls_total",
+			Help: "Total number of finished spans, derived in-process from traces (span metrics). Compare with cno_app_requests_total from the interceptors.",
+		},
+		[]string{"span_name", "span_kind", "status_code"},
+	)
+
+	CNOAppSpanDuration = newHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "cno_app_span_duration_seconds",
+			Help:    "Duration of finished spans, derived in-process from traces (span metrics). Compare with cno_app_request_latency_seconds from the interceptors.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"span_name", "span_kind", "status_code"},
+	)
+)
+
+// SpanMetricsFromEnv は終了した span からメトリクスを作るかどうか(CNO_APP_SPAN_METRICS、既定 false)を読み取る
+func SpanMetricsFromEnv() (bool, error) {
+	v := os.Getenv(envSpanMetrics)
+	if v == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", envSpanMetrics, v, err)
+	}
+	return enabled, nil
+}
+
+// spanMetricsProcessor は終了した span から RED(件数/エラー/所要時間)のメトリクスを作る SpanProcessor。
+// Collector の spanmetrics connector と同じ考え方(ラベルは span_name / span_kind / status_code)をプロセス内で行い、
+// インターセプターで直接記録する cno_app_requests_total / cno_app_request_latency_seconds と同じアプリの中で比べられるようにする。
+// サンプリングで落ちた span は OnEnd に来ないため、OTEL_TRACES_SAMPLER で間引くと件数も減る(インターセプターの値は減らない)
+type spanMetricsProcessor struct{}
+
+func (spanMetricsProcessor) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (spanMetricsProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	// 値の形は spanmetrics connector に揃える(SPAN_KIND_SERVER / STATUS_CODE_ERROR)
+	kind := "SPAN_KIND_" + strings.ToUpper(s.SpanKind().String())
+	code := "STATUS_CODE_" + strings.ToUpper(s.Status().Code.String())
+	CNOAppSpanCallsTotal.WithLabelValues(s.Name(), kind, code).Inc()
+	CNOAppSpanDuration.WithLabelValues(s.Name(), kind, code).Observe(s.EndTime().Sub(s.StartTime()).Seconds())
+}
+
+func (spanMetricsProcessor) Shutdown(context.Context) error   { return nil }
+func (spanMetricsProcessor) ForceFlush(context.Context) error { return nil }
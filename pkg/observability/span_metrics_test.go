@@ -0,0 +1,60 @@
+package observability
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 終了した span を span_name / span_kind / status_code ごとに数え、所要時間を記録する
+func TestSpanMetricsProcessor(t *testing.T) {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanMetricsProcessor{}))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	tracer := tp.Tracer("test")
+
+	const name = "test.span_metrics/Call"
+	ok := CNOAppSpanCallsTotal.WithLabelValues(name, "SPAN_KIND_SERVER", "STATUS_CODE_OK")
+	failed := CNOAppSpanCallsTotal.WithLabelValues(name, "SPAN_KIND_SERVER", "STATUS_CODE_ERROR")
+	beforeOK, beforeFailed := testutil.ToFloat64(ok), testutil.ToFloat64(failed)
+
+	for _, err := range []bool{false, false, true} {
+		_, span := tracer.Start(context.Background(), name, trace.WithSpanKind(trace.SpanKindServer))
+		if err {
+			span.SetStatus(codes.Error, "boom")
+		} else {
+			span.SetStatus(codes.Ok, "")
+		}
+		span.End()
+	}
+
+	if got := testutil.ToFloat64(ok) - beforeOK; got != 2 {
+		t.Fatalf("ok calls = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(failed) - beforeFailed; got != 1 {
+		t.Fatalf("error calls = %v, want 1", got)
+	}
+	if n := testutil.CollectAndCount(CNOAppSpanDuration, "cno_app_span_duration_seconds"); n < 2 {
+		t.Fatalf("duration series = %d, want at least 2", n)
+	}
+}
+
+func TestSpanMetricsFromEnv(t *testing.T) {
+	t.Setenv(envSpanMetrics, "")
+	if on, err := SpanMetricsFromEnv(); on || err != nil {
+		t.Fatalf("unset: got (%v, %v), want disabled", on, err)
+	}
+	t.Setenv(envSpanMetrics, "true")
+	if on, err := SpanMetricsFromEnv(); !on || err != nil {
+		t.Fatalf("true: got (%v, %v), want enabled", on, err)
+	}
+	t.Setenv(envSpanMetrics, "yes")
+	if _, err := SpanMetricsFromEnv(); err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+}
@@ -0,0 +1,235 @@
+package observability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+)
+
+// latencyBuckets は latency_bucket 属性の境界。Collector の tail_sampling で
+// 「遅いトレースだけ残す」ポリシーを書きやすいよう、粗めの固定バケットにしている。
+// 負荷 1 回の duration の既定の上限(load の MaxDuration、60s)まで区別できるよう、秒単位の上側も刻む
+var latencyBuckets = []struct {
+	upper time.Duration
+	label string
+}{
+	{100 * time.Millisecond, "lt_100ms"},
+	{500 * time.Millisecond, "lt_500ms"},
+	{time.Second, "lt_1s"},
+	{5 * time.Second, "lt_5s"},
+	{10 * time.Second, "lt_10s"},
+	{30 * time.Second, "lt_30s"},
+	{60 * time.Second, "lt_60s"},
+}
+
+// latencyOverflow は latencyBuckets の最後の境界以上の latency_bucket
+const latencyOverflow = "ge_60s"
+
+// LatencyBucket は latency を latency_bucket 属性の値に変換する
+func LatencyBucket(latency time.Duration) string {
+	for _, b := range latencyBuckets {
+		if latency < b.upper {
+			return b.label
+		}
+	}
+	return latencyOverflow
+}
+
+// RecordSpanResult は RPC の結果(ステータス/エラー/レイテンシ)を span に記録する。
+// クライアント/サーバー双方で同じ属性を載せ、tail sampling のポリシーを共通化できるようにする
+//
+//   - rpc.grpc.status_code : gRPC ステータスコード(数値)
+//   - grpc.code : gRPC ステータスコード(文字列)
+//   - latency_ms / latency_bucket : 処理時間とそのバケット
+//   - error / error.message / error.category : エラー時のみ(error.category は apperrors のカテゴリ)
+func RecordSpanResult(span trace.Span, err error, latency time.Duration) {
+	RecordSpanResponse(span, err, nil, latency)
+}
+
+// RecordSpanResponse は RecordSpanResult に加え、ステータスは OK だが応答が失敗を示している(ResponseFailure)RPC を
+// span のエラーとして記録する。rpc.grpc.status_code / grpc.code は OK のまま、status を Error にして
+// error / error.message / error.category を載せ、tail sampling の「エラーのトレースを残す」ポリシーに掛かるようにする。
+// SDK は一度 Ok にした status を Error に戻さないため、RecordSpanResult の後から上書きはできない
+func RecordSpanResponse(span trace.Span, err, failure error, latency time.Duration) {
+	if span == nil || !span.IsRecording() {
+		return
+	}
+
+	st, _ := status.FromError(err)
+	span.SetAttributes(
+		attribute.Int("rpc.grpc.status_code", int(st.Code())),
+		attribute.String("grpc.code", st.Code().String()),
+		attribute.Int64("latency_ms", latency.Milliseconds()),
+		attribute.String("latency_bucket", LatencyBucket(latency)),
+	)
+
+	if err == nil && failure != nil {
+		err = failure
+		st = status.New(st.Code(), failure.Error())
+	}
+	if err != nil {
+		span.SetAttributes(
+			attribute.Bool("error", true),
+			attribute.String("error.message", err.Error()),
+			attribute.String("error.category", string(apperrors.CategoryOf(err))),
+		)
+		span.SetStatus(codes.Error, st.Message())
+		return
+	}
+	span.SetStatus(codes.Ok, "")
+}
+
+// ResponseCategoryTrailerKey は ok=false(集計では failed > 0)で返した最初の失敗の apperrors カテゴリを載せる trailer。
+// クライアントはこれを見て、ステータスが OK の RPC の span にもサーバーと同じ error.category を付ける
+const ResponseCategoryTrailerKey = "x-error-category"
+
+// ResponseFailure は応答のメッセージが(gRPC のステータスとは別に)失敗を示していれば、その失敗を error で返す。
+// DoWork 系は負荷の失敗(error_rate の注入、kill-switch、watchdog など)を ok=false と error_message で、
+// 集計(DoWorkSummary)は failed > 0 で返す。error_message は表示用の文言のため、カテゴリは応答を作った側から
+// category(サーバーは SetResponseCategory、クライアントは ResponseCategoryTrailerKey)で受け取る。空なら internal
+func ResponseFailure(resp any, category apperrors.Category) error {
+	if category == "" {
+		category = apperrors.Internal
+	}
+	if r, ok := resp.(interface{ GetOk() bool }); ok && !r.GetOk() {
+		msg := "response reported ok=false"
+		if m, ok := resp.(interface{ GetErrorMessage() string }); ok && m.GetErrorMessage() != "" {
+			msg = m.GetErrorMessage()
+		}
+		return apperrors.New(category, errors.New(msg))
+	}
+	if r, ok := resp.(interface{ GetFailed() int32 }); ok && r.GetFailed() > 0 {
+		return apperrors.New(category, fmt.Errorf("%d messages failed", r.GetFailed()))
+	}
+	return nil
+}
+
+// ResponseCategoryFromTrailer は ResponseCategoryTrailerKey のカテゴリを返す。無ければ空
+func ResponseCategoryFromTrailer(md metadata.MD) apperrors.Category {
+	if vals := md.Get(ResponseCategoryTrailerKey); len(vals) > 0 {
+		return apperrors.Category(vals[0])
+	}
+	return ""
+}
+
+type responseCategoryKey struct{}
+
+// pendingCategory は SetResponseCategory で受け取り、まだ応答に結び付けていないカテゴリ
+type pendingCategory struct {
+	mu       sync.Mutex
+	category apperrors.Category
+}
+
+// take は受け取ったカテゴリを返して空にする
+func (p *pendingCategory) take() apperrors.Category {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.category
+	p.category = ""
+	return c
+}
+
+func withPendingCategory(ctx context.Context) (context.Context, *pendingCategory) {
+	p := new(pendingCategory)
+	return context.WithValue(ctx, responseCategoryKey{}, p), p
+}
+
+// SetResponseCategory は ok=false(集計では failed > 0)で返す失敗の apperrors カテゴリを、
+// 次に送る応答の span(UnarySpanResultInterceptor / StreamSpanResultInterceptor)と trailer に渡す。
+// 応答を送る前に呼ぶ。集計のように複数の失敗を 1 つの応答で返す場合は、応答を送るまでの最初の失敗のカテゴリを使う
+func SetResponseCategory(ctx context.Context, category apperrors.Category) {
+	p, ok := ctx.Value(responseCategoryKey{}).(*pendingCategory)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	if p.category == "" {
+		p.category = category
+	}
+	p.mu.Unlock()
+}
+
+// UnarySpanResultInterceptor はサーバー側 Unary RPC の結果を otelgrpc が作成した span に記録する。
+// ステータスが OK でも応答が ok=false なら span をエラーにし、カテゴリを trailer で返す
+func UnarySpanResultInterceptor(
+	ctx context.Context,
+	req interface{},
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	start := time.Now()
+	ctx, pending := withPendingCategory(ctx)
+	resp, err := handler(ctx, req)
+	var failure error
+	if err == nil {
+		failure = ResponseFailure(resp, pending.take())
+	}
+	if failure != nil {
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(ResponseCategoryTrailerKey, string(apperrors.CategoryOf(failure))))
+	}
+	RecordSpanResponse(trace.SpanFromContext(ctx), err, failure, time.Since(start))
+	return resp, err
+}
+
+// StreamSpanResultInterceptor はサーバー側 Streaming RPC の結果を span に記録する。
+// ステータスが OK でも失敗を示すメッセージを送っていれば、最初の失敗で span をエラーにし、そのカテゴリを trailer で返す
+func StreamSpanResultInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	_ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	start := time.Now()
+	ctx, pending := withPendingCategory(ss.Context())
+	fs := &failureRecordingStream{ServerStream: ss, ctx: ctx, pending: pending}
+	err := handler(srv, fs)
+	failure := fs.firstFailure()
+	if failure != nil {
+		ss.SetTrailer(metadata.Pairs(ResponseCategoryTrailerKey, string(apperrors.CategoryOf(failure))))
+	}
+	RecordSpanResponse(trace.SpanFromContext(ctx), err, failure, time.Since(start))
+	return err
+}
+
+// failureRecordingStream は送信したメッセージのうち、最初に失敗を示したもの(ResponseFailure)を覚えておく。
+// Context はハンドラが SetResponseCategory でカテゴリを渡せるよう pending を載せたものを返す
+type failureRecordingStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	pending *pendingCategory
+
+	mu    sync.Mutex
+	first error
+}
+
+func (s *failureRecordingStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *failureRecordingStream) SendMsg(m any) error {
+	if f := ResponseFailure(m, s.pending.take()); f != nil {
+		s.mu.Lock()
+		if s.first == nil {
+			s.first = f
+		}
+		s.mu.Unlock()
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *failureRecordingStream) firstFailure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.first
+}
@@ -0,0 +1,97 @@
+package observability
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+func TestLatencyBucket(t *testing.T) {
+	tests := []struct {
+		latency time.Duration
+		want    string
+	}{
+		{0, "lt_100ms"},
+		{99 * time.Millisecond, "lt_100ms"},
+		{100 * time.Millisecond, "lt_500ms"},
+		{999 * time.Millisecond, "lt_1s"},
+		{3 * time.Second, "lt_5s"},
+		{5 * time.Second, "lt_10s"},
+		{20 * time.Second, "lt_30s"},
+		{59 * time.Second, "lt_60s"},
+		{time.Minute, "ge_60s"},
+		{time.Hour, "ge_60s"},
+	}
+
+	for _, tt := range tests {
+		if got := LatencyBucket(tt.latency); got != tt.want {
+			t.Fatalf("LatencyBucket(%s) = %q, want %q", tt.latency, got, tt.want)
+		}
+	}
+}
+
+// 既定の上限までの duration は、どのモードでも上限なしのバケット(ge_*)に潰れずに区別できる
+func TestLatencyBucket_CoversMaxDuration(t *testing.T) {
+	for mode, l := range load.DefaultLimitProfiles() {
+		if got := LatencyBucket(l.MaxDuration - time.Millisecond); got == latencyOverflow {
+			t.Fatalf("mode %s: max duration %s falls into %q", mode, l.MaxDuration, got)
+		}
+	}
+}
+
+// ok=false や failed > 0 の応答は渡されたカテゴリ(無ければ internal)の失敗にし、成功した応答は失敗にしない。
+// カテゴリは error_message の文言には依らない
+func TestResponseFailure(t *testing.T) {
+	tests := []struct {
+		resp     any
+		category apperrors.Category
+		want     apperrors.Category
+	}{
+		{&grpcburnerv1.DoWorkResponse{Ok: false, ErrorMessage: "load: injected error (error_rate=1)"}, apperrors.Injected, apperrors.Injected},
+		{&grpcburnerv1.DoWorkResponse{Ok: false, ErrorMessage: "invalid config: load: alloc_mb exceeds max"}, apperrors.Limit, apperrors.Limit},
+		{&grpcburnerv1.DoWorkResponse{Ok: false, ErrorMessage: "load: injected error (error_rate=1)"}, "", apperrors.Internal},
+		{&grpcburnerv1.DoWorkResponse{Ok: false}, "", apperrors.Internal},
+		{&grpcburnerv1.DoWorkSummary{Total: 3, Failed: 1}, apperrors.Canceled, apperrors.Canceled},
+		{&grpcburnerv1.DoWorkSummary{Total: 3, Failed: 1}, "", apperrors.Internal},
+	}
+	for _, tt := range tests {
+		err := ResponseFailure(tt.resp, tt.category)
+		if got := apperrors.CategoryOf(err); err == nil || got != tt.want {
+			t.Fatalf("ResponseFailure(%v, %q) = %v (%s), want %s", tt.resp, tt.category, err, got, tt.want)
+		}
+	}
+
+	for _, ok := range []any{&grpcburnerv1.DoWorkResponse{Ok: true}, &grpcburnerv1.DoWorkSummary{Total: 3}, &grpcburnerv1.PingReply{}} {
+		if err := ResponseFailure(ok, apperrors.Injected); err != nil {
+			t.Fatalf("ResponseFailure(%v) = %v, want nil", ok, err)
+		}
+	}
+}
+
+// SetResponseCategory は応答を送るまでの最初のカテゴリを残し、インターセプタを通らない ctx では何もしない
+func TestSetResponseCategory(t *testing.T) {
+	SetResponseCategory(context.Background(), apperrors.Injected)
+
+	ctx, pending := withPendingCategory(context.Background())
+	SetResponseCategory(ctx, apperrors.Limit)
+	SetResponseCategory(ctx, apperrors.Injected)
+	if got := pending.take(); got != apperrors.Limit {
+		t.Fatalf("category = %q, want the first one (limit)", got)
+	}
+	if got := pending.take(); got != "" {
+		t.Fatalf("category after take = %q, want empty", got)
+	}
+
+	if got := ResponseCategoryFromTrailer(metadata.Pairs(ResponseCategoryTrailerKey, "injected")); got != apperrors.Injected {
+		t.Fatalf("ResponseCategoryFromTrailer = %q, want injected", got)
+	}
+	if got := ResponseCategoryFromTrailer(nil); got != "" {
+		t.Fatalf("ResponseCategoryFromTrailer(nil) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,298 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	envStatsdAddr   = "CNO_APP_STATSD_ADDR"
+	envStatsdFlavor = "CNO_APP_STATSD_FLAVOR"
+	envStatsdPrefix = "CNO_APP_STATSD_PREFIX"
+	envStatsdTags   = "CNO_APP_STATSD_TAGS"
+
+	defaultStatsdPrefix = "cno_app."
+
+	// statsdMaxPacket は 1 つの UDP パケットにまとめる上限(一般的な MTU 1500 から IP/UDP ヘッダーを引いた値)
+	statsdMaxPacket = 1432
+	// statsdFlushInterval はパケットが埋まらなくても送る間隔
+	statsdFlushInterval = 100 * time.Millisecond
+	// statsdQueueSize は送信待ちの行の上限。埋まっている間の行は捨てる(リクエストの処理を待たせない)
+	statsdQueueSize = 4096
+)
+
+// StatsdFlavor は送る行の形式
+type StatsdFlavor string
+
+const (
+	// StatsdFlavorDogStatsD はタグを "|#key:value,..." で付ける DogStatsD 形式
+	StatsdFlavorDogStatsD StatsdFlavor = "dogstatsd"
+	// StatsdFlavorPlain はタグを持てない素の statsd 向けに、タグの値をメトリクス名の末尾に "." 区切りで連ねる
+	StatsdFlavorPlain StatsdFlavor = "statsd"
+)
+
+// StatsdConfig はコアのリクエストメトリクスを statsd / DogStatsD でも送る設定。
+// Prometheus と並べて Datadog 形式のエージェントにも同じ値を送り、複数のメトリクス基盤での相互運用を見せるために使う。
+//
+//   - CNO_APP_STATSD_ADDR : 送信先の UDP アドレス(例: 127.0.0.1:8125)。空なら無効
+//   - CNO_APP_STATSD_FLAVOR : "dogstatsd"(既定)または "statsd"
+//   - CNO_APP_STATSD_PREFIX : メトリクス名のプレフィックス(既定 "cno_app.")
+//   - CNO_APP_STATSD_TAGS : 全ての行に付けるタグ("env:demo,team:sre")。既定で service / version を付ける
+type StatsdConfig struct {
+	Addr   string
+	Flavor StatsdFlavor
+	Prefix string
+	Tags   []string
+}
+
+// StatsdConfigFromEnv は環境変数から statsd の設定を読み取って検証する
+func StatsdConfigFromEnv() (StatsdConfig, error) {
+	cfg := StatsdConfig{
+		Addr:   os.Getenv(envStatsdAddr),
+		Flavor: StatsdFlavor(os.Getenv(envStatsdFlavor)),
+		Prefix: defaultStatsdPrefix,
+		Tags:   []string{"service:cno-app", "version:" + ServiceVersion()},
+	}
+	if v, ok := os.LookupEnv(envStatsdPrefix); ok {
+		cfg.Prefix = v
+	}
+	switch cfg.Flavor {
+	case "":
+		cfg.Flavor = StatsdFlavorDogStatsD
+	case StatsdFlavorDogStatsD, StatsdFlavorPlain:
+	default:
+		return cfg, fmt.Errorf("%s must be %s or %s, got %q", envStatsdFlavor, StatsdFlavorDogStatsD, StatsdFlavorPlain, cfg.Flavor)
+	}
+	if !cfg.Enabled() {
+		return cfg, nil
+	}
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		return cfg, fmt.Errorf("invalid %s %q: %w", envStatsdAddr, cfg.Addr, err)
+	}
+	if v := os.Getenv(envStatsdTags); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" || strings.ContainsAny(tag, "|#\n") {
+				return cfg, fmt.Errorf("invalid %s entry %q", envStatsdTags, tag)
+			}
+			cfg.Tags = append(cfg.Tags, tag)
+		}
+	}
+	return cfg, nil
+}
+
+// Enabled は送信先が設定されているかどうかを返す
+func (c StatsdConfig) Enabled() bool {
+	return c.Addr != ""
+}
+
+// StatsdEmitter は statsd の行をバッファし、パケットが埋まるか statsdFlushInterval ごとに UDP で送る。
+// 送信はバックグラウンドで行い、キューが埋まっている間の行は捨てて cno_app_statsd_dropped_total に数える
+type StatsdEmitter struct {
+	cfg    StatsdConfig
+	conn   net.Conn
+	logger *zap.SugaredLogger
+
+	lines chan string
+	done  chan struct{}
+
+	mu       sync.Mutex
+	inFlight map[string]int64
+}
+
+// NewStatsdEmitter は cfg.Addr への UDP の送信を始める。Close で残りを送って止める
+func NewStatsdEmitter(cfg StatsdConfig, logger *zap.SugaredLogger) (*StatsdEmitter, error) {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial statsd %s: %w", cfg.Addr, err)
+	}
+	e := &StatsdEmitter{
+		cfg:      cfg,
+		conn:     conn,
+		logger:   logger,
+		lines:    make(chan string, statsdQueueSize),
+		done:     make(chan struct{}),
+		inFlight: make(map[string]int64),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Close はキューに残った行を送ってから接続を閉じる
+func (e *StatsdEmitter) Close() error {
+	close(e.lines)
+	<-e.done
+	return e.conn.Close()
+}
+
+func (e *StatsdEmitter) run() {
+	defer close(e.done)
+	t := time.NewTicker(statsdFlushInterval)
+	defer t.Stop()
+
+	var buf []byte
+	flush := func() {
+		if len(buf) == 0 {
+			return
+		}
+		if _, err := e.conn.Write(buf); err != nil {
+			CNOAppStatsdDroppedTotal.WithLabelValues("write_error").Add(float64(strings.Count(string(buf), "\n") + 1))
+			if e.logger != nil {
+				e.logger.Debugw("statsd write failed", "addr", e.cfg.Addr, "error", err)
+			}
+		}
+		buf = buf[:0]
+	}
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				flush()
+				return
+			}
+			if len(buf) > 0 && len(buf)+1+len(line) > statsdMaxPacket {
+				flush()
+			}
+			if len(buf) > 0 {
+				buf = append(buf, '\n')
+			}
+			buf = append(buf, line...)
+		case <-t.C:
+			flush()
+		}
+	}
+}
+
+// Count / Timing / Gauge は 1 行をキューに入れる。tags は "key:value" の形
+func (e *StatsdEmitter) Count(name string, v int64, tags ...string) {
+	e.enqueue(name, strconv.FormatInt(v, 10), "c", tags)
+}
+
+func (e *StatsdEmitter) Timing(name string, d time.Duration, tags ...string) {
+	e.enqueue(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms", tags)
+}
+
+func (e *StatsdEmitter) Gauge(name string, v float64, tags ...string) {
+	e.enqueue(name, strconv.FormatFloat(v, 'f', -1, 64), "g", tags)
+}
+
+func (e *StatsdEmitter) enqueue(name, value, typ string, tags []string) {
+	select {
+	case e.lines <- e.format(name, value, typ, tags):
+	default:
+		CNOAppStatsdDroppedTotal.WithLabelValues("queue_full").Inc()
+	}
+}
+
+// format は DogStatsD なら "prefix.name:value|type|#tag,...", 素の statsd ならタグの値を名前に連ねた
+// "prefix.name.value1.value2:value|type" を返す(全体のタグは素の statsd では付けない)。
+// 値が空のタグ(mode 未指定など)は DogStatsD では付けず、素の statsd では名前の段数を揃えるため "none" にする
+func (e *StatsdEmitter) format(name, value, typ string, tags []string) string {
+	var b strings.Builder
+	b.WriteString(e.cfg.Prefix)
+	b.WriteString(name)
+	if e.cfg.Flavor == StatsdFlavorPlain {
+		for _, t := range tags {
+			_, v, _ := strings.Cut(t, ":")
+			if v = statsdSegment(v); v == "" {
+				v = "none"
+			}
+			b.WriteByte('.')
+			b.WriteString(v)
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(typ)
+	if e.cfg.Flavor == StatsdFlavorDogStatsD {
+		all := make([]string, 0, len(tags)+len(e.cfg.Tags))
+		for _, t := range tags {
+			if !strings.HasSuffix(t, ":") {
+				all = append(all, t)
+			}
+		}
+		all = append(all, e.cfg.Tags...)
+		if len(all) > 0 {
+			sort.Strings(all)
+			b.WriteString("|#")
+			b.WriteString(strings.Join(all, ","))
+		}
+	}
+	return b.String()
+}
+
+// statsdSegment は名前の 1 区切りに使えない文字("/" や "." など)を "_" に置き換える
+func statsdSegment(v string) string {
+	out := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, v)
+	return strings.Trim(out, "_")
+}
+
+// addInFlight は mode / endpoint ごとの処理中の数を増減し、その時点の値を gauge で送る。
+// DogStatsD は相対値("+1|g")を扱えないため、絶対値を送る
+func (e *StatsdEmitter) addInFlight(mode, endpoint string, delta int64) {
+	key := mode + " " + endpoint
+	e.mu.Lock()
+	e.inFlight[key] += delta
+	n := e.inFlight[key]
+	e.mu.Unlock()
+	e.Gauge("requests_in_flight", float64(n), "mode:"+mode, "endpoint:"+endpoint)
+}
+
+// observeRequest は 1 RPC 分の requests(count)と request_latency(timing)を送る。
+// タグは Prometheus の cno_app_requests_total / cno_app_request_latency_seconds のラベルと同じ
+func (e *StatsdEmitter) observeRequest(mode, endpoint string, err error, latency time.Duration) {
+	tags := []string{"mode:" + mode, "endpoint:" + endpoint, "code:" + status.Code(err).String()}
+	e.Count("requests", 1, tags...)
+	e.Timing("request_latency", latency, tags...)
+}
+
+// UnaryStatsdInterceptor は UnaryMetricsInterceptor と同じコアのリクエストメトリクスを statsd でも送る
+func UnaryStatsdInterceptor(e *StatsdEmitter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		_, mode := ClientInfo(ctx)
+		mode = metricMode(mode)
+
+		e.addInFlight(mode, info.FullMethod, 1)
+		defer e.addInFlight(mode, info.FullMethod, -1)
+
+		resp, err := handler(ctx, req)
+		e.observeRequest(mode, info.FullMethod, err, time.Since(start))
+		return resp, err
+	}
+}
+
+// StreamStatsdInterceptor は StreamMetricsInterceptor と同じコアのリクエストメトリクスを statsd でも送る
+func StreamStatsdInterceptor(e *StatsdEmitter) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		_, mode := ClientInfo(ss.Context())
+		mode = metricMode(mode)
+
+		e.addInFlight(mode, info.FullMethod, 1)
+		defer e.addInFlight(mode, info.FullMethod, -1)
+
+		err := handler(srv, ss)
+		e.observeRequest(mode, info.FullMethod, err, time.Since(start))
+		return err
+	}
+}
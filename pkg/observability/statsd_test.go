@@ -0,0 +1,125 @@
+package observability
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// readStatsdLines は UDP で受けた行を want 行になるまで読む
+func readStatsdLines(t *testing.T, pc net.PacketConn, want int) []string {
+	t.Helper()
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var lines []string
+	buf := make([]byte, statsdMaxPacket)
+	for len(lines) < want {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read statsd packet (got %d lines %v): %v", len(lines), lines, err)
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+	return lines
+}
+
+// interceptor は 1 RPC あたり in-flight の増減 2 行と requests / request_latency の 2 行を送る
+func TestUnaryStatsdInterceptor_DogStatsD(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() {
+		_ = pc.Close()
+	}()
+
+	cfg := StatsdConfig{Addr: pc.LocalAddr().String(), Flavor: StatsdFlavorDogStatsD, Prefix: "cno_app.", Tags: []string{"service:cno-app"}}
+	e, err := NewStatsdEmitter(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewStatsdEmitter: %v", err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ClientModeMetadataKey, "do-work-unary"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/demo.v1.Demo/Call"}
+	_, _ = UnaryStatsdInterceptor(e)(ctx, nil, info, func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.Unavailable, "down")
+	})
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	lines := readStatsdLines(t, pc, 4)
+	for _, want := range []string{
+		"cno_app.requests_in_flight:1|g|#endpoint:/demo.v1.Demo/Call,mode:do-work-unary,service:cno-app",
+		"cno_app.requests:1|c|#code:Unavailable,endpoint:/demo.v1.Demo/Call,mode:do-work-unary,service:cno-app",
+		"cno_app.requests_in_flight:0|g|#endpoint:/demo.v1.Demo/Call,mode:do-work-unary,service:cno-app",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Fatalf("line %q not found in %q", want, lines)
+		}
+	}
+	if !slices.ContainsFunc(lines, func(l string) bool {
+		return strings.HasPrefix(l, "cno_app.request_latency:") && strings.HasSuffix(l, "|ms|#code:Unavailable,endpoint:/demo.v1.Demo/Call,mode:do-work-unary,service:cno-app")
+	}) {
+		t.Fatalf("request_latency timing not found in %q", lines)
+	}
+}
+
+// 素の statsd ではタグの値を名前に連ね、全体のタグは付けない。値が空のタグは "none"(DogStatsD では省く)
+func TestStatsdEmitter_PlainFormat(t *testing.T) {
+	e := &StatsdEmitter{cfg: StatsdConfig{Flavor: StatsdFlavorPlain, Prefix: "cno_app.", Tags: []string{"service:cno-app"}}}
+	got := e.format("requests", "1", "c", []string{"mode:unary", "endpoint:/demo.v1.Demo/Call", "code:OK"})
+	if want := "cno_app.requests.unary.demo_v1_Demo_Call.OK:1|c"; got != want {
+		t.Fatalf("format = %q, want %q", got, want)
+	}
+	got = e.format("requests_in_flight", "0", "g", []string{"mode:", "endpoint:/demo.v1.Demo/Call"})
+	if want := "cno_app.requests_in_flight.none.demo_v1_Demo_Call:0|g"; got != want {
+		t.Fatalf("format with empty mode = %q, want %q", got, want)
+	}
+
+	e.cfg.Flavor = StatsdFlavorDogStatsD
+	got = e.format("requests_in_flight", "0", "g", []string{"mode:", "endpoint:/demo.v1.Demo/Call"})
+	if want := "cno_app.requests_in_flight:0|g|#endpoint:/demo.v1.Demo/Call,service:cno-app"; got != want {
+		t.Fatalf("dogstatsd format with empty mode = %q, want %q", got, want)
+	}
+}
+
+func TestStatsdConfigFromEnv(t *testing.T) {
+	t.Setenv(envStatsdAddr, "")
+	if cfg, err := StatsdConfigFromEnv(); err != nil || cfg.Enabled() {
+		t.Fatalf("unset: got (%+v, %v), want disabled", cfg, err)
+	}
+
+	t.Setenv(envStatsdAddr, "127.0.0.1:8125")
+	t.Setenv(envStatsdTags, "env:demo, team:sre")
+	cfg, err := StatsdConfigFromEnv()
+	if err != nil {
+		t.Fatalf("StatsdConfigFromEnv: %v", err)
+	}
+	if cfg.Flavor != StatsdFlavorDogStatsD || cfg.Prefix != defaultStatsdPrefix {
+		t.Fatalf("flavor/prefix = %q/%q", cfg.Flavor, cfg.Prefix)
+	}
+	if !slices.Contains(cfg.Tags, "service:cno-app") || !slices.Contains(cfg.Tags, "team:sre") {
+		t.Fatalf("tags = %v", cfg.Tags)
+	}
+
+	for env, v := range map[string]string{
+		envStatsdAddr:   "localhost",
+		envStatsdFlavor: "influx",
+		envStatsdTags:   "env:demo|x",
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, v)
+			if _, err := StatsdConfigFromEnv(); err == nil {
+				t.Fatalf("expected error for %s=%q", env, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/zipkin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	envTracesExporter = "OTEL_TRACES_EXPORTER"
+	envJaegerEndpoint = "OTEL_EXPORTER_JAEGER_ENDPOINT"
+	envZipkinEndpoint = "OTEL_EXPORTER_ZIPKIN_ENDPOINT"
+
+	defaultJaegerEndpoint = "localhost:4317"
+	defaultZipkinEndpoint = "http://localhost:9411/api/v2/spans"
+)
+
+// OTEL_TRACES_EXPORTER で選べるトレースの送信先
+const (
+	// TraceExporterOTLP は OTLP/gRPC で Collector(Tempo)に送る(既定)
+	TraceExporterOTLP = "otlp"
+	// TraceExporterJaeger は Jaeger の OTLP 受信口(v1.35 以降の collector / all-in-one)に直接送る。
+	// OTel Go の Jaeger(thrift)exporter は削除済みのため、Jaeger 自身が受け付ける OTLP を使う
+	TraceExporterJaeger = "jaeger"
+	// TraceExporterZipkin は Zipkin の v2 API(JSON)に直接送る
+	TraceExporterZipkin = "zipkin"
+	// TraceExporterNone はトレースを送らない(span は作るため trace_id のログ連携は残る)
+	TraceExporterNone = "none"
+)
+
+var traceExporterNames = []string{TraceExporterOTLP, TraceExporterJaeger, TraceExporterZipkin, TraceExporterNone}
+
+// TraceExporterFromEnv は OTEL_TRACES_EXPORTER を読み取る。未設定なら otlp。
+// Collector がない環境(Jaeger / Zipkin だけが立っているなど)でも、設定を変えるだけでトレースを送れるようにする。
+// OTel の仕様どおりカンマ区切りで複数指定できるが、このアプリでは 1 つだけを受け付ける
+func TraceExporterFromEnv() (string, error) {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv(envTracesExporter)))
+	if v == "" {
+		return TraceExporterOTLP, nil
+	}
+	for _, name := range traceExporterNames {
+		if v == name {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid %s %q (valid: %s)", envTracesExporter, v, strings.Join(traceExporterNames, ", "))
+}
+
+// TraceExporterEndpoint は起動バナーに出す送信先。none なら空
+func TraceExporterEndpoint(kind string) string {
+	switch kind {
+	case TraceExporterOTLP:
+		endpoint, _ := otlpEndpointFromEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "localhost:4317")
+		return endpoint
+	case TraceExporterJaeger:
+		endpoint, _ := otlpEndpointFromEnv(envJaegerEndpoint, defaultJaegerEndpoint)
+		return endpoint
+	case TraceExporterZipkin:
+		return zipkinEndpointFromEnv()
+	}
+	return ""
+}
+
+// newSpanExporter は kind に応じた SpanExporter を作る。none なら nil を返す
+func newSpanExporter(ctx context.Context, kind string) (sdktrace.SpanExporter, error) {
+	switch kind {
+	case TraceExporterOTLP, TraceExporterJaeger:
+		// OTEL_EXPORTER_OTLP_ENDPOINT が未設定ならローカルCollectorを前提にする
+		endpoint, insecure := otlpEndpointFromEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "localhost:4317")
+		if kind == TraceExporterJaeger {
+			endpoint, insecure = otlpEndpointFromEnv(envJaegerEndpoint, defaultJaegerEndpoint)
+		}
+
+		// Exporter生成時はタイムタウト付きコンテキストを使う
+		dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(endpoint),
+		}
+		if insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exp, err := otlptracegrpc.New(dialCtx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("create %s trace exporter: %w", kind, err)
+		}
+		return exp, nil
+	case TraceExporterZipkin:
+		exp, err := zipkin.New(zipkinEndpointFromEnv())
+		if err != nil {
+			return nil, fmt.Errorf("create zipkin trace exporter: %w", err)
+		}
+		return exp, nil
+	case TraceExporterNone:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", kind)
+}
+
+// otlpEndpointFromEnv は key の値(未設定なら def)を otlptracegrpc の host:port に変換し、TLS を使わないかどうかを返す。
+// "http://localhost:4317" 形式でも動くように scheme を取り除く
+func otlpEndpointFromEnv(key, def string) (endpoint string, insecure bool) {
+	endpoint = os.Getenv(key)
+	if endpoint == "" {
+		endpoint = def
+	}
+	endpoint = strings.TrimPrefix(endpoint, "http://")
+	endpoint = strings.TrimPrefix(endpoint, "https://")
+
+	// insecure フラグ(デフォルト true)
+	insecure = true
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_INSECURE"); v != "" {
+		insecure = strings.EqualFold(v, "true")
+	}
+	return endpoint, insecure
+}
+
+// zipkinEndpointFromEnv は Zipkin の span を受け付ける URL を返す
+func zipkinEndpointFromEnv() string {
+	if v := os.Getenv(envZipkinEndpoint); v != "" {
+		return v
+	}
+	return defaultZipkinEndpoint
+}
+
+// validateTraceExporterEnv は exporter の種類と送信先の形式を確認する
+func validateTraceExporterEnv() error {
+	kind, err := TraceExporterFromEnv()
+	if err != nil {
+		return err
+	}
+	if kind == TraceExporterZipkin {
+		u, err := url.Parse(zipkinEndpointFromEnv())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid %s %q: want http(s)://host:port/api/v2/spans", envZipkinEndpoint, zipkinEndpointFromEnv())
+		}
+	}
+	return nil
+}
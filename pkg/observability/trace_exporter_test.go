@@ -0,0 +1,99 @@
+package observability
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestTraceExporterFromEnv(t *testing.T) {
+	for v, want := range map[string]string{
+		"":         TraceExporterOTLP,
+		"otlp":     TraceExporterOTLP,
+		" Zipkin ": TraceExporterZipkin,
+		"jaeger":   TraceExporterJaeger,
+		"none":     TraceExporterNone,
+	} {
+		t.Setenv(envTracesExporter, v)
+		got, err := TraceExporterFromEnv()
+		if err != nil || got != want {
+			t.Fatalf("%s=%q: got (%q, %v), want %q", envTracesExporter, v, got, err, want)
+		}
+	}
+
+	for _, v := range []string{"console", "otlp,zipkin"} {
+		t.Setenv(envTracesExporter, v)
+		if _, err := TraceExporterFromEnv(); err == nil {
+			t.Fatalf("expected error for %s=%q", envTracesExporter, v)
+		}
+	}
+}
+
+// Jaeger は OTLP の送信先だけを OTEL_EXPORTER_JAEGER_ENDPOINT に切り替える
+func TestTraceExporterEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4317")
+	t.Setenv(envJaegerEndpoint, "http://jaeger:4317")
+	t.Setenv(envZipkinEndpoint, "")
+	for kind, want := range map[string]string{
+		TraceExporterOTLP:   "collector:4317",
+		TraceExporterJaeger: "jaeger:4317",
+		TraceExporterZipkin: defaultZipkinEndpoint,
+		TraceExporterNone:   "",
+	} {
+		if got := TraceExporterEndpoint(kind); got != want {
+			t.Fatalf("TraceExporterEndpoint(%q) = %q, want %q", kind, got, want)
+		}
+	}
+}
+
+// zipkin では OTEL_EXPORTER_ZIPKIN_ENDPOINT へ v2 の JSON で span を送る
+func TestNewSpanExporter_Zipkin(t *testing.T) {
+	var got []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode zipkin spans: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	t.Setenv(envZipkinEndpoint, srv.URL+"/api/v2/spans")
+	if err := validateTraceExporterEnv(); err != nil {
+		t.Fatalf("validateTraceExporterEnv: %v", err)
+	}
+	exp, err := newSpanExporter(context.Background(), TraceExporterZipkin)
+	if err != nil {
+		t.Fatalf("newSpanExporter: %v", err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	_, span := tp.Tracer("test").Start(context.Background(), "grpc.server/DoWork")
+	span.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	// Zipkin の span 名は小文字になる
+	if len(got) != 1 || got[0]["name"] != "grpc.server/dowork" {
+		t.Fatalf("zipkin received %v", got)
+	}
+}
+
+func TestValidateTraceExporterEnv(t *testing.T) {
+	t.Setenv(envTracesExporter, TraceExporterZipkin)
+	t.Setenv(envZipkinEndpoint, "localhost:9411")
+	if err := validateTraceExporterEnv(); err == nil {
+		t.Fatal("expected error for zipkin endpoint without scheme")
+	}
+
+	t.Setenv(envTracesExporter, TraceExporterNone)
+	if err := validateTraceExporterEnv(); err != nil {
+		t.Fatalf("none: %v", err)
+	}
+	if exp, err := newSpanExporter(context.Background(), TraceExporterNone); exp != nil || err != nil {
+		t.Fatalf("newSpanExporter(none) = (%v, %v), want (nil, nil)", exp, err)
+	}
+}
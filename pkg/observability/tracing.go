@@ -2,87 +2,115 @@ package observability
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
-	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
+// TracerOption は TracerProvider 初期化時の追加設定
+type TracerOption func(*tracerConfig)
+
+type tracerConfig struct {
+	runID string
+}
+
+// WithRunID は全 span に run_id 属性を付与する。クライアントの 1 回の実行を識別するために使う
+func WithRunID(runID string) TracerOption {
+	return func(c *tracerConfig) {
+		c.runID = runID
+	}
+}
+
 // InitTracerProvider は OpenTelemetry TracerProviderを初期化し、
 // gRPCサーバーのトレースが Collector(Tempo) に送信されるように設定する。
 // 戻り値の shutdown はアプリ終了時に呼び出す。
-func InitTracerProvider(ctx context.Context) (func(context.Context) error, error) {
+func InitTracerProvider(ctx context.Context, opts ...TracerOption) (func(context.Context) error, error) {
 	// サーバ側用: service.name = "cno-app"
-	return initTracerProvider(ctx, "cno-app")
+	return initTracerProvider(ctx, "cno-app", opts...)
 }
 
 // InitClientTracerProvider は gRPCクライアント用のTracerProviderを初期化する。
 // 基本設定はサーバー側と揃えつつ、service.Name だけ "cno-app-client"に変える。
-func InitClientTracerProvider(ctx context.Context) (func(context.Context) error, error) {
-	return initTracerProvider(ctx, "cno-app-client")
+func InitClientTracerProvider(ctx context.Context, opts ...TracerOption) (func(context.Context) error, error) {
+	return initTracerProvider(ctx, "cno-app-client", opts...)
 }
 
 // initTracerProviderは service.Nameだけを引数で切り替える共通実装。
-func initTracerProvider(ctx context.Context, serviceName string) (func(context.Context) error, error) {
-	// OTEL_EXPORTER_OTLP_ENDPOINT が未設定ならローカルCollectorを前提にする
-	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
-	if endpoint == "" {
-		endpoint = "localhost:4317"
+func initTracerProvider(ctx context.Context, serviceName string, tracerOpts ...TracerOption) (func(context.Context) error, error) {
+	var tc tracerConfig
+	for _, o := range tracerOpts {
+		o(&tc)
 	}
 
-	// "http://localhost:4317" 形式でも動くように scheme を取り除く
-	endpoint = strings.TrimPrefix(endpoint, "http://")
-	endpoint = strings.TrimPrefix(endpoint, "https://")
-
-	// insecure フラグ(デフォルト true)
-	insecure := true
-	if v := os.Getenv("OTEL_EXPORTER_OTLP_INSECURE"); v != "" {
-		insecure = strings.EqualFold(v, "true")
+	// OTEL_TRACES_EXPORTER で Collector(otlp)のほか、Jaeger / Zipkin へ直接送る構成を選べる
+	kind, err := TraceExporterFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	exp, err := newSpanExporter(ctx, kind)
+	if err != nil {
+		return nil, err
 	}
 
-	// Exporter生成時はタイムタウト付きコンテキストを使う
-	dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
-	opts := []otlptracegrpc.Option{
-		otlptracegrpc.WithEndpoint(endpoint),
+	detectors, err := resourceDetectorsFromEnv()
+	if err != nil {
+		return nil, err
 	}
-	if insecure {
-		opts = append(opts, otlptracegrpc.WithInsecure())
+	scrubber, err := scrubberFromEnv()
+	if err != nil {
+		return nil, err
 	}
-
-	exp, err := otlptracegrpc.New(dialCtx, opts...)
+	spanMetrics, err := SpanMetricsFromEnv()
 	if err != nil {
-		return nil, fmt.Errorf("create otlp trace exporter: %w", err)
+		return nil, err
 	}
 
-	// Resource: service.* を明示的に設定
-	res, err := resource.New(
-		ctx,
+	// Resource: CNO_APP_RESOURCE_DETECTORS で選んだ detector の属性に、service.* を明示的に重ねる
+	resOpts := append(resourceDetectorOptions(detectors),
 		resource.WithFromEnv(),
 		resource.WithAttributes(
 			attribute.String("service.name", serviceName),
 			attribute.String("service.namespace", "grpc"),
-			attribute.String("service.version", serviceVersion()),
+			attribute.String("service.version", ServiceVersion()),
+			attribute.String("service.instance.id", ServiceInstanceID()),
 		),
 	)
+	res, err := resource.New(ctx, resOpts...)
+	if errors.Is(err, resource.ErrPartialResource) {
+		// 一部の属性が取れない detector(コンテナ外での container など)があっても、取れた分で続ける
+		err = nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("create resource: %w", err)
 	}
 
-	tp := sdktrace.NewTracerProvider(
+	tpOpts := []sdktrace.TracerProviderOption{
 		sdktrace.WithResource(res),
-		sdktrace.WithSpanProcessor(
-			sdktrace.NewBatchSpanProcessor(exp),
-		),
-	)
+	}
+	if tc.runID != "" {
+		// span 開始時に run_id 属性を付与する
+		tpOpts = append(tpOpts, sdktrace.WithSpanProcessor(runIDProcessor{runID: tc.runID}))
+	}
+	if spanMetrics {
+		// エクスポートとは独立に、終了した span から RED のメトリクスを作る(exporter が none でも動く)
+		tpOpts = append(tpOpts, sdktrace.WithSpanProcessor(spanMetricsProcessor{}))
+	}
+	if exp != nil {
+		var export sdktrace.SpanProcessor = sdktrace.NewBatchSpanProcessor(exp)
+		if scrubber.Enabled() {
+			// エクスポートの直前で deny-list の属性を削除/ハッシュ化する
+			export = scrubProcessor{next: export, scrubber: scrubber}
+		}
+		tpOpts = append(tpOpts, sdktrace.WithSpanProcessor(export))
+	}
+
+	tp := sdktrace.NewTracerProvider(tpOpts...)
 
 	otel.SetTracerProvider(tp)
 	otel.SetTextMapPropagator(
@@ -98,8 +126,8 @@ func initTracerProvider(ctx context.Context, serviceName string) (func(context.C
 	}, nil
 }
 
-// serviceVersion 環境変数からバージョンを取得し、なければ "dev" を返す。
-func serviceVersion() string {
+// ServiceVersion は環境変数 CNO_APP_VERSION からバージョンを取得し、なければ "dev" を返す。
+func ServiceVersion() string {
 	if v := os.Getenv("CNO_APP_VERSION"); v != "" {
 		return v
 	}
@@ -0,0 +1,229 @@
+package scenario
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// ExportOptions は ghz / k6 向けの出力に埋め込む接続設定
+type ExportOptions struct {
+	Addr     string // シナリオに addr がない場合の接続先
+	Insecure bool   // 平文で接続するか
+}
+
+func (s *Scenario) addr(opts ExportOptions) string {
+	if s.Addr != "" {
+		return s.Addr
+	}
+	return opts.Addr
+}
+
+// RequestMessage はステップ 1 回分のリクエストメッセージを返す。
+// クライアントストリーミング/双方向ストリーミングでは、このメッセージを repeat 回送る
+func (st Step) RequestMessage() (proto.Message, error) {
+	if st.Mode == ModePing {
+		return &grpcburnerv1.PingRequest{}, nil
+	}
+	wc, err := st.Work.WorkConfig()
+	if err != nil {
+		return nil, err
+	}
+	if st.Mode == ModeDoWorkServer {
+		return &grpcburnerv1.DoWorkServerStreamingRequest{
+			Config: wc,
+			Repeat: int32(st.Repeat), //nolint:gosec
+		}, nil
+	}
+	return &grpcburnerv1.DoWorkRequest{Config: wc}, nil
+}
+
+// requestData はリクエストメッセージを ghz / k6 がそのまま受け取れる JSON 値に変換する
+func (st Step) requestData() (any, error) {
+	msg, err := st.RequestMessage()
+	if err != nil {
+		return nil, err
+	}
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+
+	if st.Mode == ModeDoWorkClient || st.Mode == ModeDoWorkBidi {
+		msgs := make([]any, st.Repeat)
+		for i := range msgs {
+			msgs[i] = v
+		}
+		return msgs, nil
+	}
+	return v, nil
+}
+
+// ghzConfig は ghz の設定ファイル(JSON)のうち、本リポジトリで使う項目
+type ghzConfig struct {
+	Call        string `json:"call"`
+	Host        string `json:"host"`
+	Insecure    bool   `json:"insecure"`
+	Total       int    `json:"total"`
+	Concurrency int    `json:"concurrency"`
+	Timeout     string `json:"timeout"`
+	Data        any    `json:"data"`
+	Name        string `json:"name"`
+}
+
+// ExportGhz はステップごとに ghz の設定(JSON)を生成する。
+// ghz は 1 設定 1 メソッドのため、戻り値はステップ名をキーにしたマップになる。
+// proto はサーバーの reflection から取得する前提で、proto/import パスは出力しない。
+// ghz には仮想ユーザーごとの待ち時間の設定がないため、think_time のあるステップはエラーにする
+func (s *Scenario) ExportGhz(opts ExportOptions) (map[string][]byte, error) {
+	out := make(map[string][]byte, len(s.Steps))
+	for _, st := range s.Steps {
+		if st.ThinkTime.Enabled() {
+			return nil, fmt.Errorf("step %q: ghz export does not support think_time", st.Name)
+		}
+		data, err := st.requestData()
+		if err != nil {
+			return nil, fmt.Errorf("step %q: %w", st.Name, err)
+		}
+		cfg := ghzConfig{
+			Call:        strings.TrimPrefix(st.FullMethod(), "/"),
+			Host:        s.addr(opts),
+			Insecure:    opts.Insecure,
+			Total:       st.Requests,
+			Concurrency: st.Concurrency,
+			Timeout:     st.CallTimeout().String(),
+			Data:        data,
+			Name:        s.Name + "/" + st.Name,
+		}
+		b, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		out[st.Name] = append(b, '\n')
+	}
+	return out, nil
+}
+
+// CallTimeout は 1 RPC あたりのタイムアウト(処理時間の見積もり + 余裕)
+func (st Step) CallTimeout() time.Duration {
+	perRPC := time.Duration(st.Repeat) * (time.Duration(st.Work.Duration) + time.Duration(st.Work.Latency))
+	return perRPC + 2*time.Second
+}
+
+type k6Step struct {
+	Name       string
+	Exec       string
+	Method     string
+	Data       string
+	VUs        int
+	Iterations int
+	StartTime  string
+	Timeout    string
+	Sleep      string // think time(秒)を生成する式。空なら sleep しない
+}
+
+// k6Template はシナリオ名・ステップ名・接続先などの文字列を js(JSON の文字列リテラル)で埋め込む。
+// シナリオファイルの値に引用符や改行が含まれていてもスクリプトの構文を壊さない
+var k6Template = template.Must(template.New("k6").Funcs(template.FuncMap{"js": jsString}).Parse(`// Code generated by "client export --format=k6"; DO NOT EDIT.
+// scenario: {{ js .Name }}
+import grpc from 'k6/net/grpc';
+import { check, sleep } from 'k6';
+
+const client = new grpc.Client();
+
+export const options = {
+  scenarios: {
+{{- range .Steps }}
+    {{ js .Name }}: {
+      executor: 'shared-iterations',
+      vus: {{ .VUs }},
+      iterations: {{ .Iterations }},
+      startTime: {{ js .StartTime }},
+      exec: {{ js .Exec }},
+    },
+{{- end }}
+  },
+};
+
+function connect() {
+  if (__ITER === 0) {
+    client.connect({{ js .Addr }}, { plaintext: {{ .Insecure }}, reflect: true });
+  }
+}
+{{ range .Steps }}
+export function {{ .Exec }}() {
+  connect();
+  const res = client.invoke({{ js .Method }}, {{ .Data }}, { timeout: {{ js .Timeout }} });
+  check(res, { {{ js (printf "%s status is OK" .Name) }}: (r) => r && r.status === grpc.StatusOK });
+{{- if .Sleep }}
+  sleep({{ .Sleep }});
+{{- end }}
+}
+{{ end -}}
+`))
+
+// jsString は s を JavaScript の文字列リテラルとして書ける JSON 文字列にする。
+// json.Marshal は制御文字に加えて U+2028 / U+2029 もエスケープする
+func jsString(s string) (string, error) {
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+// ExportK6 はシナリオを k6 スクリプト(k6/net/grpc)に変換する。
+// ステップは k6 の scenarios として表現し、前のステップの見積もり時間を startTime に積み上げて順番に実行する。
+// k6 の grpc.Client.invoke は Unary のみ対応のため、ストリーミングのステップはエラーにする
+func (s *Scenario) ExportK6(opts ExportOptions) ([]byte, error) {
+	steps := make([]k6Step, 0, len(s.Steps))
+	var start time.Duration
+	for i, st := range s.Steps {
+		if st.Mode != ModePing && st.Mode != ModeDoWorkUnary {
+			return nil, fmt.Errorf("step %q: k6 export supports unary modes only (ping, do-work-unary), got %q", st.Name, st.Mode)
+		}
+		data, err := st.requestData()
+		if err != nil {
+			return nil, fmt.Errorf("step %q: %w", st.Name, err)
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		step := k6Step{
+			Name:       st.Name,
+			Exec:       fmt.Sprintf("step%d", i+1),
+			Method:     strings.TrimPrefix(st.FullMethod(), "/"),
+			Data:       string(b),
+			VUs:        st.Concurrency,
+			Iterations: st.Requests,
+			StartTime:  start.String(),
+			Timeout:    st.CallTimeout().String(),
+		}
+		if st.ThinkTime.Enabled() {
+			step.Sleep = st.ThinkTime.k6Expr()
+		}
+		steps = append(steps, step)
+		start += st.EstimatedDuration() + time.Duration(st.Pause)
+	}
+
+	var buf bytes.Buffer
+	err := k6Template.Execute(&buf, map[string]any{
+		"Name":     s.Name,
+		"Addr":     s.addr(opts),
+		"Insecure": opts.Insecure,
+		"Steps":    steps,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
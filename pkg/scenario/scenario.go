@@ -0,0 +1,269 @@
+// Package scenario は複数ステップの負荷シナリオ(JSON / YAML)の読み込みと、
+// 各ステップを gRPC リクエストへ変換する処理を提供する
+package scenario
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v3"
+
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+)
+
+// Step の mode として指定できる値(クライアントの --mode と同じ名前)
+const (
+	ModePing         = "ping"
+	ModeDoWorkUnary  = "do-work-unary"
+	ModeDoWorkServer = "do-work-server"
+	ModeDoWorkClient = "do-work-client"
+	ModeDoWorkBidi   = "do-work-bidi"
+)
+
+// Duration は JSON 上で "3s" のような文字列として表現する time.Duration
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"3s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Scenario は順番に実行するステップの集合
+type Scenario struct {
+	Name  string `json:"name"`
+	Addr  string `json:"addr,omitempty"` // 省略時はクライアントの --addr を使う
+	Steps []Step `json:"steps"`
+}
+
+// Step は 1 種類の RPC を requests 回、concurrency 並列で実行する単位
+type Step struct {
+	Name        string   `json:"name"`
+	Mode        string   `json:"mode"`
+	Requests    int      `json:"requests"`
+	Concurrency int      `json:"concurrency"`
+	Repeat      int      `json:"repeat,omitempty"` // ストリーミング系の 1 RPC あたりのメッセージ数
+	Work        WorkSpec `json:"work"`
+	// ThinkTime は仮想ユーザー(concurrency の 1 本)が次の RPC を送るまでの待ち時間の分布
+	ThinkTime ThinkTime `json:"think_time,omitzero"`
+	// Pause はステップの終了後、次のステップを始めるまで待つ時間
+	Pause Duration `json:"pause,omitempty"`
+	// ExpectCodes はこのステップの RPC が返してよいステータス(名前か番号)。省略時は OK のみ。
+	// DoWork の ok=false やストリームの失敗は UNKNOWN として数える
+	ExpectCodes []string `json:"expect_codes,omitempty"`
+}
+
+// WorkSpec は WorkConfig の JSON 表現
+type WorkSpec struct {
+	Mode        string   `json:"mode"`
+	Duration    Duration `json:"duration"`
+	AllocMB     int32    `json:"alloc_mb,omitempty"`
+	Parallelism int32    `json:"parallelism,omitempty"`
+	IOBytes     int32    `json:"io_bytes,omitempty"`
+	Latency     Duration `json:"latency,omitempty"`
+	ErrorRate   float64  `json:"error_rate,omitempty"`
+}
+
+// Load はシナリオファイルを読み込み、検証済みの Scenario を返す。
+// 拡張子が .yaml / .yml なら YAML として読み、それ以外は JSON として読む(キーはどちらも同じ)
+func Load(path string) (*Scenario, error) {
+	b, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("read scenario: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if b, err = yamlToJSON(b); err != nil {
+			return nil, fmt.Errorf("parse scenario %s: %w", path, err)
+		}
+	}
+	var s Scenario
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parse scenario %s: %w", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// yamlToJSON は YAML を JSON に変換する。
+// Duration や ThinkTime の解釈を JSON 側の 1 か所にまとめるため、構造体に yaml のタグは付けない
+func yamlToJSON(b []byte) ([]byte, error) {
+	var v any
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// Validate はシナリオの必須項目と値の範囲を検証し、省略された値に既定値を入れる
+func (s *Scenario) Validate() error {
+	if len(s.Steps) == 0 {
+		return errors.New("scenario: at least one step is required")
+	}
+	seen := map[string]bool{}
+	for i := range s.Steps {
+		st := &s.Steps[i]
+		if st.Name == "" {
+			st.Name = fmt.Sprintf("step-%d", i+1)
+		}
+		if seen[st.Name] {
+			return fmt.Errorf("scenario: duplicate step name %q", st.Name)
+		}
+		seen[st.Name] = true
+
+		if err := st.validate(); err != nil {
+			return fmt.Errorf("scenario: step %q: %w", st.Name, err)
+		}
+	}
+	return nil
+}
+
+// Fingerprint は検証済みのシナリオの内容から求めたハッシュを返す。
+// 中断した実行の状態ファイルが同じシナリオのものかを確かめるために使う(ファイルの書式や YAML/JSON の違いは含まない)
+func (s *Scenario) Fingerprint() (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("scenario: fingerprint: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (st *Step) validate() error {
+	switch st.Mode {
+	case ModePing, ModeDoWorkUnary, ModeDoWorkServer, ModeDoWorkClient, ModeDoWorkBidi:
+	default:
+		return fmt.Errorf("unsupported mode %q", st.Mode)
+	}
+	if st.Requests <= 0 {
+		return fmt.Errorf("requests must be > 0, got %d", st.Requests)
+	}
+	if st.Concurrency <= 0 {
+		st.Concurrency = 1
+	}
+	if st.Repeat <= 0 {
+		st.Repeat = 1
+	}
+	if err := st.ThinkTime.Validate(); err != nil {
+		return err
+	}
+	if st.Pause < 0 {
+		return errors.New("pause must be >= 0")
+	}
+	if _, err := st.ExpectedCodes(); err != nil {
+		return err
+	}
+	if st.Mode == ModePing {
+		return nil
+	}
+	if _, err := st.Work.LoadMode(); err != nil {
+		return err
+	}
+	if st.Work.Duration <= 0 {
+		return errors.New("work.duration must be > 0")
+	}
+	if st.Work.ErrorRate < 0 || st.Work.ErrorRate > 1 {
+		return errors.New("work.error_rate must be between 0.0 and 1.0")
+	}
+	return nil
+}
+
+// LoadMode は work.mode を proto の LoadMode に変換する
+func (w WorkSpec) LoadMode() (grpcburnerv1.LoadMode, error) {
+	switch w.Mode {
+	case "cpu":
+		return grpcburnerv1.LoadMode_LOAD_MODE_CPU, nil
+	case "mem":
+		return grpcburnerv1.LoadMode_LOAD_MODE_MEM, nil
+	case "cpu-mem":
+		return grpcburnerv1.LoadMode_LOAD_MODE_CPU_MEM, nil
+	case "io":
+		return grpcburnerv1.LoadMode_LOAD_MODE_IO, nil
+	default:
+		return grpcburnerv1.LoadMode_LOAD_MODE_UNSPECIFIED, fmt.Errorf("invalid work.mode %q (expected cpu|mem|cpu-mem|io)", w.Mode)
+	}
+}
+
+// WorkConfig は WorkSpec を proto の WorkConfig に変換する
+func (w WorkSpec) WorkConfig() (*grpcburnerv1.WorkConfig, error) {
+	mode, err := w.LoadMode()
+	if err != nil {
+		return nil, err
+	}
+	return &grpcburnerv1.WorkConfig{
+		Mode:        mode,
+		DurationMs:  time.Duration(w.Duration).Milliseconds(),
+		AllocMb:     w.AllocMB,
+		Parallelism: w.Parallelism,
+		IoBytes:     w.IOBytes,
+		LatencyMs:   time.Duration(w.Latency).Milliseconds(),
+		ErrorRate:   w.ErrorRate,
+	}, nil
+}
+
+// ExpectedCodes は expect_codes を gRPC のステータスに変換する。省略時は OK のみ
+func (st Step) ExpectedCodes() ([]codes.Code, error) {
+	if len(st.ExpectCodes) == 0 {
+		return []codes.Code{codes.OK}, nil
+	}
+	out := make([]codes.Code, 0, len(st.ExpectCodes))
+	for _, v := range st.ExpectCodes {
+		c, err := appserver.ParseCode(v)
+		if err != nil {
+			return nil, fmt.Errorf("expect_codes: %w", err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// FullMethod はステップの mode に対応する gRPC のフルメソッド名を返す
+func (st Step) FullMethod() string {
+	switch st.Mode {
+	case ModePing:
+		return grpcburnerv1.Burner_Ping_FullMethodName
+	case ModeDoWorkUnary:
+		return grpcburnerv1.Burner_DoWork_FullMethodName
+	case ModeDoWorkServer:
+		return grpcburnerv1.Burner_DoWorkServerStreaming_FullMethodName
+	case ModeDoWorkClient:
+		return grpcburnerv1.Burner_DoWorkClientStreaming_FullMethodName
+	case ModeDoWorkBidi:
+		return grpcburnerv1.Burner_DoWorkBidiStreaming_FullMethodName
+	default:
+		return ""
+	}
+}
+
+// EstimatedDuration はステップ全体の所要時間の目安
+// (1 RPC あたり repeat × (duration + latency) + think time の平均 を requests/concurrency 回)を返す
+func (st Step) EstimatedDuration() time.Duration {
+	perRPC := time.Duration(st.ThinkTime.Mean)
+	if st.Mode != ModePing {
+		perRPC += time.Duration(st.Repeat) * (time.Duration(st.Work.Duration) + time.Duration(st.Work.Latency))
+	}
+	rounds := (st.Requests + st.Concurrency - 1) / st.Concurrency
+	return time.Duration(rounds) * perRPC
+}
@@ -0,0 +1,183 @@
+package scenario
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+const testScenario = `{
+  "name": "demo",
+  "steps": [
+    {"mode": "ping", "requests": 2},
+    {"name": "cpu", "mode": "do-work-unary", "requests": 4, "concurrency": 2,
+     "work": {"mode": "cpu", "duration": "1s", "parallelism": 1}}
+  ]
+}`
+
+func parse(t *testing.T, src string) *Scenario {
+	t.Helper()
+	var s Scenario
+	if err := json.Unmarshal([]byte(src), &s); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	return &s
+}
+
+func TestValidate_FillsDefaults(t *testing.T) {
+	s := parse(t, testScenario)
+
+	if got := s.Steps[0].Name; got != "step-1" {
+		t.Fatalf("default step name = %q, want step-1", got)
+	}
+	if got := s.Steps[0].Concurrency; got != 1 {
+		t.Fatalf("default concurrency = %d, want 1", got)
+	}
+	if got := time.Duration(s.Steps[1].Work.Duration); got != time.Second {
+		t.Fatalf("work.duration = %s, want 1s", got)
+	}
+	if got := s.Steps[1].EstimatedDuration(); got != 2*time.Second {
+		t.Fatalf("EstimatedDuration = %s, want 2s", got)
+	}
+}
+
+func TestValidate_RejectsInvalidWorkMode(t *testing.T) {
+	s := Scenario{Steps: []Step{{Mode: ModeDoWorkUnary, Requests: 1, Work: WorkSpec{Mode: "gpu", Duration: Duration(time.Second)}}}}
+	if err := s.Validate(); err == nil {
+		t.Fatalf("expected error for invalid work.mode, got nil")
+	}
+}
+
+func TestExport(t *testing.T) {
+	s := parse(t, testScenario)
+	opts := ExportOptions{Addr: "localhost:8080", Insecure: true}
+
+	files, err := s.ExportGhz(opts)
+	if err != nil {
+		t.Fatalf("ExportGhz: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 ghz configs, got %d", len(files))
+	}
+	var cfg ghzConfig
+	if err := json.Unmarshal(files["cpu"], &cfg); err != nil {
+		t.Fatalf("unmarshal ghz config: %v", err)
+	}
+	if cfg.Call != "observability.grpcburner.v1.Burner/DoWork" || cfg.Total != 4 || cfg.Concurrency != 2 {
+		t.Fatalf("unexpected ghz config: %+v", cfg)
+	}
+
+	script, err := s.ExportK6(opts)
+	if err != nil {
+		t.Fatalf("ExportK6: %v", err)
+	}
+	for _, want := range []string{
+		`"observability.grpcburner.v1.Burner/Ping"`,
+		`"observability.grpcburner.v1.Burner/DoWork"`,
+		`startTime: "0s"`,
+		"plaintext: true",
+	} {
+		if !strings.Contains(string(script), want) {
+			t.Fatalf("k6 script should contain %s:\n%s", want, script)
+		}
+	}
+}
+
+// ステップ名やシナリオ名の引用符・改行は JS の文字列リテラルとしてエスケープし、スクリプトの構文を壊さない
+func TestExportK6_EscapesNames(t *testing.T) {
+	s := Scenario{
+		Name: "smoke\n}; evil(); //",
+		Steps: []Step{{Name: "it's \"quoted\"\u2028", Mode: ModePing, Requests: 1, Concurrency: 1}},
+	}
+	script, err := s.ExportK6(ExportOptions{Addr: "localhost:8080'"})
+	if err != nil {
+		t.Fatalf("ExportK6: %v", err)
+	}
+	for _, want := range []string{
+		`// scenario: "smoke\n}; evil(); //"`,
+		`"it's \"quoted\"\u2028": {`,
+		`"it's \"quoted\"\u2028 status is OK": (r)`,
+		`client.connect("localhost:8080'", {`,
+	} {
+		if !strings.Contains(string(script), want) {
+			t.Fatalf("k6 script should contain %s:\n%s", want, script)
+		}
+	}
+	if strings.Contains(string(script), "\n}; evil()") || strings.ContainsRune(string(script), '\u2028') {
+		t.Fatalf("k6 script has unescaped names:\n%s", script)
+	}
+}
+
+// .yaml は JSON と同じキーで読み、pause / expect_codes を解釈する
+func TestLoad_YAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smoke.yaml")
+	src := `name: smoke
+steps:
+  - name: ping
+    mode: ping
+    requests: 2
+    pause: 500ms
+  - name: flaky
+    mode: do-work-unary
+    requests: 4
+    expect_codes: [OK, unknown]
+    think_time: {distribution: fixed, mean: 10ms}
+    work: {mode: cpu, duration: 100ms}
+`
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := time.Duration(s.Steps[0].Pause); got != 500*time.Millisecond {
+		t.Fatalf("pause = %s, want 500ms", got)
+	}
+	got, err := s.Steps[1].ExpectedCodes()
+	if err != nil || len(got) != 2 || got[0] != codes.OK || got[1] != codes.Unknown {
+		t.Fatalf("ExpectedCodes = (%v, %v), want [OK Unknown]", got, err)
+	}
+	if def, _ := s.Steps[0].ExpectedCodes(); len(def) != 1 || def[0] != codes.OK {
+		t.Fatalf("default ExpectedCodes = %v, want [OK]", def)
+	}
+	if time.Duration(s.Steps[1].ThinkTime.Mean) != 10*time.Millisecond {
+		t.Fatalf("think_time.mean = %s", time.Duration(s.Steps[1].ThinkTime.Mean))
+	}
+}
+
+func TestValidate_RejectsInvalidExpectCode(t *testing.T) {
+	s := Scenario{Steps: []Step{{Mode: ModePing, Requests: 1, ExpectCodes: []string{"MAYBE"}}}}
+	if err := s.Validate(); err == nil {
+		t.Fatal("expected error for invalid expect_codes")
+	}
+	s = Scenario{Steps: []Step{{Mode: ModePing, Requests: 1, Pause: Duration(-time.Second)}}}
+	if err := s.Validate(); err == nil {
+		t.Fatal("expected error for negative pause")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a, err := parse(t, testScenario).Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint: %v", err)
+	}
+	b, _ := parse(t, testScenario).Fingerprint()
+	if a != b {
+		t.Fatalf("Fingerprint differs for the same scenario: %s != %s", a, b)
+	}
+
+	changed := parse(t, testScenario)
+	changed.Steps[1].Requests++
+	if c, _ := changed.Fingerprint(); c == a {
+		t.Fatal("Fingerprint did not change after editing a step")
+	}
+}
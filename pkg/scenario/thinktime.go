@@ -0,0 +1,142 @@
+package scenario
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// ThinkTime の distribution として指定できる値
+const (
+	ThinkFixed       = "fixed"
+	ThinkExponential = "exponential"
+	ThinkNormal      = "normal"
+)
+
+// maxThinkFactor は指数分布の think time を平均の何倍で打ち切るか。
+// まれに極端に長い待ちが出て実行が終わらなくなるのを防ぐ(打ち切られる確率は約 0.005%)
+const maxThinkFactor = 10
+
+// ThinkTime は仮想ユーザーが 1 回のリクエストを終えてから次を送るまでの待ち時間の分布。
+// 固定間隔ではなく人の操作に近いばらつきを持たせ、closed loop の負荷をダッシュボードのデモで自然に見せるために使う
+type ThinkTime struct {
+	Distribution string   `json:"distribution"`
+	Mean         Duration `json:"mean"`
+	StdDev       Duration `json:"stddev,omitempty"` // normal のみ
+}
+
+// ParseThinkTime はフラグ用の表記("200ms" / "fixed:200ms" / "exp:200ms" / "normal:500ms:100ms")を解析する。
+// 空文字列は think time なし
+func ParseThinkTime(s string) (ThinkTime, error) {
+	if s == "" {
+		return ThinkTime{}, nil
+	}
+	parts := strings.Split(s, ":")
+	dist := ThinkFixed
+	if len(parts) > 1 {
+		dist, parts = parts[0], parts[1:]
+	}
+	switch dist {
+	case "exp":
+		dist = ThinkExponential
+	case "norm":
+		dist = ThinkNormal
+	}
+
+	t := ThinkTime{Distribution: dist}
+	mean, err := time.ParseDuration(parts[0])
+	if err != nil {
+		return ThinkTime{}, fmt.Errorf("invalid think time %q: %w", s, err)
+	}
+	t.Mean = Duration(mean)
+	if len(parts) > 2 || (len(parts) == 2 && dist != ThinkNormal) {
+		return ThinkTime{}, fmt.Errorf("invalid think time %q: want [fixed|exp:]mean or normal:mean:stddev", s)
+	}
+	if len(parts) == 2 {
+		sd, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return ThinkTime{}, fmt.Errorf("invalid think time %q: %w", s, err)
+		}
+		t.StdDev = Duration(sd)
+	}
+	if err := t.Validate(); err != nil {
+		return ThinkTime{}, err
+	}
+	return t, nil
+}
+
+// Enabled は think time を入れるかどうかを返す
+func (t ThinkTime) Enabled() bool {
+	return t.Mean > 0
+}
+
+// Validate は分布の種類と値の範囲を検証する
+func (t ThinkTime) Validate() error {
+	switch t.Distribution {
+	case ThinkFixed, ThinkExponential, ThinkNormal:
+	case "":
+		if t.Mean != 0 || t.StdDev != 0 {
+			return errors.New("think_time.distribution is required")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported think_time.distribution %q (expected fixed|exponential|normal)", t.Distribution)
+	}
+	if t.Mean < 0 {
+		return errors.New("think_time.mean must be >= 0")
+	}
+	if t.StdDev < 0 {
+		return errors.New("think_time.stddev must be >= 0")
+	}
+	if t.StdDev != 0 && t.Distribution != ThinkNormal {
+		return errors.New("think_time.stddev is only valid for the normal distribution")
+	}
+	return nil
+}
+
+// Sample は分布から待ち時間を 1 つ取り出す。負の値は 0 に切り上げる
+func (t ThinkTime) Sample(rng *rand.Rand) time.Duration {
+	mean := float64(t.Mean)
+	var v float64
+	switch t.Distribution {
+	case ThinkExponential:
+		v = math.Min(rng.ExpFloat64()*mean, maxThinkFactor*mean)
+	case ThinkNormal:
+		v = rng.NormFloat64()*float64(t.StdDev) + mean
+	default:
+		v = mean
+	}
+	return time.Duration(math.Max(v, 0))
+}
+
+// String はフラグと同じ表記で返す
+func (t ThinkTime) String() string {
+	if !t.Enabled() {
+		return ""
+	}
+	switch t.Distribution {
+	case ThinkExponential:
+		return "exp:" + time.Duration(t.Mean).String()
+	case ThinkNormal:
+		return fmt.Sprintf("normal:%s:%s", time.Duration(t.Mean), time.Duration(t.StdDev))
+	default:
+		return time.Duration(t.Mean).String()
+	}
+}
+
+// k6Expr は k6 の sleep() に渡す秒数を Sample と同じ分布で生成する JavaScript の式を返す
+func (t ThinkTime) k6Expr() string {
+	mean := time.Duration(t.Mean).Seconds()
+	switch t.Distribution {
+	case ThinkExponential:
+		return fmt.Sprintf("Math.min(-Math.log(1 - Math.random()) * %g, %g)", mean, maxThinkFactor*mean)
+	case ThinkNormal:
+		sd := time.Duration(t.StdDev).Seconds()
+		return fmt.Sprintf("Math.max(0, %g + %g * Math.sqrt(-2 * Math.log(1 - Math.random())) * Math.cos(2 * Math.PI * Math.random()))", mean, sd)
+	default:
+		return fmt.Sprintf("%g", mean)
+	}
+}
@@ -0,0 +1,87 @@
+package scenario
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseThinkTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ThinkTime
+	}{
+		{"", ThinkTime{}},
+		{"200ms", ThinkTime{Distribution: ThinkFixed, Mean: Duration(200 * time.Millisecond)}},
+		{"exp:1s", ThinkTime{Distribution: ThinkExponential, Mean: Duration(time.Second)}},
+		{"normal:500ms:100ms", ThinkTime{Distribution: ThinkNormal, Mean: Duration(500 * time.Millisecond), StdDev: Duration(100 * time.Millisecond)}},
+	}
+	for _, tt := range tests {
+		got, err := ParseThinkTime(tt.in)
+		if err != nil {
+			t.Fatalf("ParseThinkTime(%q): %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Fatalf("ParseThinkTime(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if got.String() != strings.TrimPrefix(tt.in, "fixed:") {
+			t.Fatalf("String() = %q, want %q", got.String(), tt.in)
+		}
+	}
+
+	for _, bad := range []string{"soon", "exp:1s:1s", "uniform:1s", "normal:1s:-1s", "-1s"} {
+		if _, err := ParseThinkTime(bad); err == nil {
+			t.Fatalf("ParseThinkTime(%q): expected error", bad)
+		}
+	}
+}
+
+// 分布ごとの標本平均が指定した平均に近く、負の値や打ち切りの上限を超える値が出ないことを確認
+func TestThinkTime_Sample(t *testing.T) {
+	mean := 100 * time.Millisecond
+	for _, tt := range []ThinkTime{
+		{Distribution: ThinkFixed, Mean: Duration(mean)},
+		{Distribution: ThinkExponential, Mean: Duration(mean)},
+		{Distribution: ThinkNormal, Mean: Duration(mean), StdDev: Duration(20 * time.Millisecond)},
+	} {
+		rng := rand.New(rand.NewSource(1))
+		const n = 20000
+		var sum time.Duration
+		for i := 0; i < n; i++ {
+			d := tt.Sample(rng)
+			if d < 0 || d > maxThinkFactor*mean {
+				t.Fatalf("%s: sample %s out of range", tt.Distribution, d)
+			}
+			sum += d
+		}
+		if avg := sum / n; avg < 95*time.Millisecond || avg > 105*time.Millisecond {
+			t.Fatalf("%s: sample mean = %s, want about %s", tt.Distribution, avg, mean)
+		}
+	}
+}
+
+func TestExport_ThinkTime(t *testing.T) {
+	s := parse(t, `{
+  "name": "think",
+  "steps": [
+    {"mode": "ping", "requests": 4, "concurrency": 2,
+     "think_time": {"distribution": "exponential", "mean": "500ms"}}
+  ]
+}`)
+	if got := s.Steps[0].EstimatedDuration(); got != time.Second {
+		t.Fatalf("EstimatedDuration = %s, want 1s", got)
+	}
+
+	script, err := s.ExportK6(ExportOptions{Addr: "localhost:8080"})
+	if err != nil {
+		t.Fatalf("ExportK6: %v", err)
+	}
+	if !strings.Contains(string(script), "sleep(Math.min(-Math.log(1 - Math.random()) * 0.5, 5));") {
+		t.Fatalf("k6 script should sleep with an exponential think time:\n%s", script)
+	}
+
+	if _, err := s.ExportGhz(ExportOptions{Addr: "localhost:8080"}); err == nil {
+		t.Fatal("ExportGhz: expected error for think_time")
+	}
+}
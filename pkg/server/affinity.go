@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// CPUAffinityMetadataKey は cpu / cpu-mem モードの CPU ワーカーを固定するコアの番号をカンマ区切りで指定する metadata キー(例: "0,2")。
+// i 番目のワーカーを i % コア数 番目のコアに固定する。Linux のサーバーで、WithCPUAffinity で許可した場合だけ受け付ける
+const CPUAffinityMetadataKey = "x-cpu-affinity"
+
+// WithCPUAffinity は x-cpu-affinity によるコアへの固定を許可するかどうかを指定する。既定は許可しない。
+// 固定したワーカーは同じコアの他の Pod やプロセスと競合するため、デモ用の環境でだけ有効にする
+func WithCPUAffinity(enabled bool) Option {
+	return func(s *GrpcBurnerServer) {
+		s.cpuAffinity = enabled
+	}
+}
+
+// cpuAffinityFromContext は incoming metadata から CPU ワーカーを固定するコアの番号を取得する。未指定なら nil
+func (s *GrpcBurnerServer) cpuAffinityFromContext(ctx context.Context) ([]int, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := firstMetadata(md, CPUAffinityMetadataKey)
+	if v == "" {
+		return nil, nil
+	}
+	if !s.cpuAffinity {
+		return nil, fmt.Errorf("%s is not allowed on this server", CPUAffinityMetadataKey)
+	}
+	var cpus []int
+	for _, f := range strings.Split(v, ",") {
+		cpu, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", CPUAffinityMetadataKey, v, err)
+		}
+		cpus = append(cpus, cpu)
+	}
+	return cpus, nil
+}
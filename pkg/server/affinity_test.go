@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+// x-cpu-affinity はサーバーで許可した場合だけ受け付けることを確認
+func TestCPUAffinityFromContext(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(CPUAffinityMetadataKey, "0, 2"))
+
+	disabled := NewGrpcBurnerServer(zap.NewNop().Sugar())
+	if _, err := disabled.cpuAffinityFromContext(ctx); err == nil {
+		t.Fatalf("expected error when cpu affinity is not allowed")
+	}
+
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar(), WithCPUAffinity(true))
+	got, err := s.cpuAffinityFromContext(ctx)
+	if err != nil || !slices.Equal(got, []int{0, 2}) {
+		t.Fatalf("got (%v, %v), want [0 2]", got, err)
+	}
+
+	if got, err := s.cpuAffinityFromContext(context.Background()); err != nil || got != nil {
+		t.Fatalf("unset: got (%v, %v), want nil", got, err)
+	}
+
+	bad := metadata.NewIncomingContext(context.Background(), metadata.Pairs(CPUAffinityMetadataKey, "0,x"))
+	if _, err := s.cpuAffinityFromContext(bad); err == nil {
+		t.Fatalf("expected error for invalid value")
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+const (
+	// IdempotencyKeyMetadataKey は Unary の DoWork で同じ処理とみなすキーを指定する metadata キー。
+	// 合流(WithCoalescing)が有効な時、同じキーで同時に実行中の DoWork があれば、新たに実行せずその結果を共有する。
+	// 未指定なら解決済みの config・x-instances・x-cpu-share のハッシュをキーにする
+	IdempotencyKeyMetadataKey = "x-idempotency-key"
+
+	// CoalescedTrailerKey は合流した DoWork の役割と、同じ実行を共有したリクエスト数を返す trailer のキー
+	// (例: "role=follower;group=12")。合流が無効なら付けない
+	CoalescedTrailerKey = "x-dowork-coalesced"
+)
+
+// 合流した DoWork の役割(cno_app_work_coalesce_requests_total の role)
+const (
+	coalesceRoleLeader   = "leader"   // 実際に負荷を実行した
+	coalesceRoleFollower = "follower" // 実行中の負荷の結果を共有した
+)
+
+// WithCoalescing は同じキーで同時に届いた Unary の DoWork を 1 回の実行にまとめる(singleflight)。
+// thundering herd の負荷で、まとめた数がメトリクスとログにどう出るかを見るためのもの
+func WithCoalescing(enabled bool) Option {
+	return func(s *GrpcBurnerServer) {
+		if enabled {
+			s.coalescer = newWorkCoalescer()
+		}
+	}
+}
+
+// workCoalescer は実行中の DoWork をキーごとに持ち、同じキーのリクエストを 1 回の実行に合流させる
+type workCoalescer struct {
+	mu      sync.Mutex
+	flights map[string]*workFlight
+}
+
+// workFlight は 1 回の実行と、その結果を待つリクエスト
+type workFlight struct {
+	done chan struct{}
+	err  error
+
+	// group は合流したリクエストの数(leader を含む)、waiting はまだ結果を待っている数。
+	// waiting が 0 になれば(全員が切断・タイムアウトしたら)実行を止める
+	group   int
+	waiting int
+	cancel  context.CancelFunc
+}
+
+func newWorkCoalescer() *workCoalescer {
+	return &workCoalescer{flights: make(map[string]*workFlight)}
+}
+
+// do は key の実行がなければ fn を実行し(leader)、あれば終わるまで待って同じ結果を返す(follower)。
+// fn には最初のリクエストの ctx からキャンセルを外した ctx を渡すため、leader が先に切断しても
+// 待っている follower がいる間は実行を続ける。返す group は実行を共有したリクエスト数
+func (c *workCoalescer) do(ctx context.Context, key string, fn func(context.Context) error) (err error, leader bool, group int) {
+	c.mu.Lock()
+	f, ok := c.flights[key]
+	if ok {
+		f.group++
+		f.waiting++
+		c.mu.Unlock()
+		return c.wait(ctx, f), false, c.groupSize(f)
+	}
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	f = &workFlight{done: make(chan struct{}), group: 1, waiting: 1, cancel: cancel}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		ferr := fn(runCtx)
+		c.mu.Lock()
+		// 終了後に届いたリクエストは新しい実行にする
+		delete(c.flights, key)
+		f.err = ferr
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	return c.wait(ctx, f), true, c.groupSize(f)
+}
+
+// wait は実行の終了か ctx の終了を待つ。ctx が先に終わり、待っている人がいなくなれば実行を止める
+func (c *workCoalescer) wait(ctx context.Context, f *workFlight) error {
+	select {
+	case <-f.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		f.waiting--
+		return f.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		f.waiting--
+		if f.waiting == 0 {
+			f.cancel()
+		}
+		return ctx.Err()
+	}
+}
+
+func (c *workCoalescer) groupSize(f *workFlight) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return f.group
+}
+
+// coalesceKey は合流に使うキーを返す。x-idempotency-key があればそれを、なければ結果に効く設定のハッシュを使う。
+// RequestID / Rand / Limits はリクエストごとに違っても同じ負荷になるため含めない
+func coalesceKey(ctx context.Context, cfg load.Config, instances int) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := firstMetadata(md, IdempotencyKeyMetadataKey); v != "" {
+		return "key:" + v
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "mode=%s;duration=%s;alloc_mb=%d;parallelism=%d;io_bytes=%d;io_dir=%s;latency=%s;error_rate=%g;fail_after=%s;watchdog=%g;affinity=%v;io_cache=%s;instances=%d;cpu_share=%s",
+		cfg.Mode, cfg.Duration, cfg.AllocMB, cfg.Parallelism, cfg.IOBytes, cfg.IODir, cfg.Latency, cfg.ErrorRate,
+		cfg.FailAfter, cfg.WatchdogGrace, cfg.CPUAffinity, cfg.IOCache, instances, firstMetadata(md, CPUShareMetadataKey))
+	return "config:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// runWorkCoalesced は合流が有効なら同じキーの実行中の DoWork に合流し、無効なら runWork をそのまま実行する
+func (s *GrpcBurnerServer) runWorkCoalesced(ctx context.Context, cfg load.Config, instances int) error {
+	if s.coalescer == nil {
+		return s.runWork(ctx, cfg, instances)
+	}
+	key := coalesceKey(ctx, cfg, instances)
+	err, leader, group := s.coalescer.do(ctx, key, func(runCtx context.Context) error {
+		return s.runWork(runCtx, cfg, instances)
+	})
+
+	role := coalesceRoleFollower
+	if leader {
+		role = coalesceRoleLeader
+		// leader の終了時点の数(leader の後に届いた follower の分も含む)
+		observability.CNOAppWorkCoalesceGroupSize.Observe(float64(group))
+	}
+	observability.CNOAppWorkCoalesceRequestsTotal.WithLabelValues(role).Inc()
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(CoalescedTrailerKey, "role="+role+";group="+strconv.Itoa(group)))
+	if s.logger != nil {
+		s.logger.Debugw("work coalesced",
+			"request_id", cfg.RequestID,
+			"role", role,
+			"group", group,
+			"mode", string(cfg.Mode),
+		)
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+// 同じキーで同時に届いたリクエストは 1 回の実行にまとまり、全員が同じ結果を受け取る
+func TestWorkCoalescer_SharesExecution(t *testing.T) {
+	c := newWorkCoalescer()
+	const n = 8
+	var runs atomic.Int32
+	release := make(chan struct{})
+	boom := errors.New("boom")
+
+	var wg sync.WaitGroup
+	var leaders atomic.Int32
+	errs := make([]error, n)
+	groups := make([]int, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err, leader, group := c.do(context.Background(), "k", func(context.Context) error {
+				runs.Add(1)
+				<-release
+				return boom
+			})
+			if leader {
+				leaders.Add(1)
+			}
+			errs[i], groups[i] = err, group
+		}()
+	}
+	// 全員が合流するまで待ってから実行を終わらせる
+	waitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		f := c.flights["k"]
+		return f != nil && f.group == n
+	})
+	close(release)
+	wg.Wait()
+
+	if got := runs.Load(); got != 1 {
+		t.Fatalf("runs = %d, want 1", got)
+	}
+	if got := leaders.Load(); got != 1 {
+		t.Fatalf("leaders = %d, want 1", got)
+	}
+	for i := range n {
+		if !errors.Is(errs[i], boom) || groups[i] != n {
+			t.Fatalf("request %d: got (%v, group %d), want (boom, group %d)", i, errs[i], groups[i], n)
+		}
+	}
+	if len(c.flights) != 0 {
+		t.Fatalf("flights left: %d", len(c.flights))
+	}
+}
+
+// 待っている全員がいなくなれば実行を止める。leader だけが抜けても follower がいれば続ける
+func TestWorkCoalescer_CancelWhenAllLeave(t *testing.T) {
+	c := newWorkCoalescer()
+	stopped := make(chan struct{})
+	fn := func(ctx context.Context) error {
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	followerCtx, cancelFollower := context.WithCancel(context.Background())
+	leaderDone := make(chan error, 1)
+	go func() {
+		err, _, _ := c.do(leaderCtx, "k", fn)
+		leaderDone <- err
+	}()
+	waitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.flights["k"] != nil
+	})
+	followerDone := make(chan error, 1)
+	go func() {
+		err, _, _ := c.do(followerCtx, "k", fn)
+		followerDone <- err
+	}()
+	waitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.flights["k"].waiting == 2
+	})
+
+	cancelLeader()
+	if err := <-leaderDone; !errors.Is(err, context.Canceled) {
+		t.Fatalf("leader err = %v, want canceled", err)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("work stopped while a follower was still waiting")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancelFollower()
+	<-followerDone
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("work not stopped after every request left")
+	}
+}
+
+// RequestID が違っても同じ config なら同じキー、x-idempotency-key があればそれを優先する
+func TestCoalesceKey(t *testing.T) {
+	cfg := load.Config{Mode: load.ModeCPU, Duration: 10 * time.Millisecond, Parallelism: 1, RequestID: "a"}
+	other := cfg
+	other.RequestID = "b"
+	ctx := context.Background()
+	if coalesceKey(ctx, cfg, 1) != coalesceKey(ctx, other, 1) {
+		t.Fatal("request_id changed the key")
+	}
+	if coalesceKey(ctx, cfg, 1) == coalesceKey(ctx, cfg, 2) {
+		t.Fatal("instances did not change the key")
+	}
+	other.Parallelism = 2
+	if coalesceKey(ctx, cfg, 1) == coalesceKey(ctx, other, 1) {
+		t.Fatal("parallelism did not change the key")
+	}
+
+	keyed := metadata.NewIncomingContext(ctx, metadata.Pairs(IdempotencyKeyMetadataKey, "order-1"))
+	if coalesceKey(keyed, cfg, 1) != coalesceKey(keyed, other, 1) {
+		t.Fatal("idempotency key should override the config hash")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
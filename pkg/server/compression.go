@@ -0,0 +1,92 @@
+package server
+
+import (
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+	// gzip の Compressor を登録する(サーバーは登録された方式で圧縮されたリクエストを受け付け、同じ方式で応答を圧縮する)
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+// CompressionZstd は zstd の Compressor の名前(grpc-encoding)。gzip(google.golang.org/grpc/encoding/gzip)と並べて
+// クライアントの --compression で選べるよう、このパッケージを import したプロセスに登録する
+const CompressionZstd = "zstd"
+
+// zstdMaxMemory は 1 メッセージの展開に使うメモリ(ウィンドウサイズ)の上限。
+// 展開後のサイズは gRPC の受信メッセージの上限で別に抑えるため、巨大なウィンドウを宣言したフレームでメモリを確保させないためのもの
+const zstdMaxMemory = 64 << 20
+
+func init() {
+	encoding.RegisterCompressor(&zstdCompressor{})
+}
+
+// zstdCompressor は klauspost/compress の zstd で gRPC のメッセージを圧縮/展開する。
+// Encoder / Decoder は作るコストが大きいため、メッセージごとに sync.Pool から使い回す
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+func (c *zstdCompressor) Name() string { return CompressionZstd }
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	enc, ok := c.encoders.Get().(*zstd.Encoder)
+	if !ok {
+		var err error
+		// 1 メッセージずつ同期的に圧縮する(ゴルーチンを持たないため、プールに残っても後始末が要らない)
+		enc, err = zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		enc.Reset(w)
+	}
+	return &zstdWriter{Encoder: enc, pool: &c.encoders}, nil
+}
+
+// zstdWriter は Close でフレームを書き終えてから Encoder をプールに戻す
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	w.pool.Put(w.Encoder)
+	return err
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	dec, ok := c.decoders.Get().(*zstd.Decoder)
+	if !ok {
+		var err error
+		dec, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(zstdMaxMemory))
+		if err != nil {
+			return nil, err
+		}
+	} else if err := dec.Reset(r); err != nil {
+		c.decoders.Put(dec)
+		return nil, err
+	}
+	return &zstdReader{dec: dec, pool: &c.decoders}, nil
+}
+
+// zstdReader は最後まで読み終えた(io.EOF)時点で Decoder をプールに戻す。途中でエラーになった Decoder は戻さない
+type zstdReader struct {
+	dec  *zstd.Decoder
+	pool *sync.Pool
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.dec == nil {
+		return 0, io.EOF
+	}
+	n, err := r.dec.Read(p)
+	if err == io.EOF {
+		r.pool.Put(r.dec)
+		r.dec = nil
+	}
+	return n, err
+}
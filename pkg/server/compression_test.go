@@ -0,0 +1,54 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// zstd の Compressor は登録されていて、プールから使い回した Encoder / Decoder でも元のデータに戻ることを確認
+func TestZstdCompressor_RoundTrip(t *testing.T) {
+	c := encoding.GetCompressor(CompressionZstd)
+	if c == nil {
+		t.Fatal("zstd compressor is not registered")
+	}
+	for _, want := range []string{strings.Repeat("burner ", 1000), "small", ""} {
+		var buf bytes.Buffer
+		w, err := c.Compress(&buf)
+		if err != nil {
+			t.Fatalf("Compress: %v", err)
+		}
+		if _, err := io.WriteString(w, want); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		r, err := c.Decompress(&buf)
+		if err != nil {
+			t.Fatalf("Decompress: %v", err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || string(got) != want {
+			t.Fatalf("round trip = %q (%v), want %q", got, err, want)
+		}
+	}
+}
+
+// gzip / zstd で圧縮した DoWork を受け付けて応答できることを確認
+func TestDoWork_Compression(t *testing.T) {
+	client, _ := startBurner(t)
+	for _, name := range []string{"gzip", CompressionZstd} {
+		resp, err := client.DoWork(context.Background(), &grpcburnerv1.DoWorkRequest{RequestId: name, Config: cpuConfig(10 * time.Millisecond)}, grpc.UseCompressor(name))
+		if err != nil || !resp.GetOk() {
+			t.Fatalf("DoWork with %s = %+v, %v", name, resp, err)
+		}
+	}
+}
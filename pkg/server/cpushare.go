@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+const (
+	// CPUShareMetadataKey は cpu / cpu-mem モードの CPU ワーカー数を、parallelism の代わりに
+	// サーバーの CPU ワーカー枠(既定は GOMAXPROCS)に対する割合(0 < share <= 1、例: "0.5")で指定する metadata キー。
+	// 同時に実行中の負荷が使っている分を除いた空きの範囲でだけワーカーを割り当てるため、
+	// 1 つの巨大なリクエストが同時に走る他のリクエストの結果を崩さない。
+	// proto に cpu_share フィールドが追加されるまでは metadata で受け渡す。指定した場合 parallelism は無視する
+	CPUShareMetadataKey = "x-cpu-share"
+
+	// CPUWorkersGrantedTrailerKey は x-cpu-share の負荷に実際に割り当てた CPU ワーカー数を返す trailer のキー。
+	// 値は "granted=N;requested=M"(ストリームでは負荷 1 回ごとに 1 つ)
+	CPUWorkersGrantedTrailerKey = "x-cpu-workers-granted"
+)
+
+// rejectCPUWorkersExhausted は CPU ワーカー枠に空きがなく x-cpu-share の負荷を拒否した場合の reason
+const rejectCPUWorkersExhausted = "cpu_workers_exhausted"
+
+// WithCPUWorkers は x-cpu-share で分け合う CPU ワーカー枠の大きさを指定する。0 以下なら GOMAXPROCS
+func WithCPUWorkers(n int) Option {
+	return func(s *GrpcBurnerServer) {
+		if n > 0 {
+			s.cpuWorkers = newCPUWorkerPool(n)
+		}
+	}
+}
+
+// cpuShareFromContext は incoming metadata から CPU ワーカー枠の割合を取得する。未指定なら 0
+func cpuShareFromContext(ctx context.Context) (float64, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := firstMetadata(md, CPUShareMetadataKey)
+	if v == "" {
+		return 0, nil
+	}
+	share, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", CPUShareMetadataKey, v, err)
+	}
+	if math.IsNaN(share) || share <= 0 || share > 1 {
+		return 0, fmt.Errorf("%s must be > 0 and <= 1, got %q", CPUShareMetadataKey, v)
+	}
+	return share, nil
+}
+
+// applyCPUShare は x-cpu-share が指定されていれば、cfg.Parallelism を枠の割合に相当するワーカー数(割り当ての上限)に置き換える。
+// 実際に割り当てる数は runWork が実行時の空きで決める
+func (s *GrpcBurnerServer) applyCPUShare(ctx context.Context, cfg *load.Config) error {
+	share, err := cpuShareFromContext(ctx)
+	if err != nil || share == 0 {
+		return err
+	}
+	if !usesCPUWorkers(cfg.Mode) {
+		return fmt.Errorf("%s is only valid for cpu and cpu-mem mode, got %s", CPUShareMetadataKey, cfg.Mode)
+	}
+	cfg.Parallelism = s.cpuWorkers.want(share)
+	return nil
+}
+
+// usesCPUWorkers は mode が CPU ワーカー(Parallelism 個)を起動するかどうかを返す
+func usesCPUWorkers(mode load.Mode) bool {
+	return mode == load.ModeCPU || mode == load.ModeCPUMem
+}
+
+// cpuWorkerPool は同時に実行中の負荷の CPU ワーカー数を数え、x-cpu-share の負荷に空きの範囲でワーカーを割り当てる。
+// parallelism を直接指定した負荷は空きに関係なく実行する(従来どおり)が、使っている数は枠に数える
+type cpuWorkerPool struct {
+	capacity int
+
+	mu    sync.Mutex
+	inUse int
+}
+
+func newCPUWorkerPool(capacity int) *cpuWorkerPool {
+	observability.CNOAppCPUWorkersCapacity.Set(float64(capacity))
+	return &cpuWorkerPool{capacity: capacity}
+}
+
+func defaultCPUWorkerPool() *cpuWorkerPool {
+	return newCPUWorkerPool(runtime.GOMAXPROCS(0))
+}
+
+// want は枠の share に相当するワーカー数を返す(最低 1)
+func (p *cpuWorkerPool) want(share float64) int {
+	return max(1, int(math.Ceil(share*float64(p.capacity))))
+}
+
+// reserve は n 個のワーカーを空きに関係なく枠に数える。負荷の終了時に release を呼ぶ
+func (p *cpuWorkerPool) reserve(n int) (release func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.add(n)
+	return p.releaseFunc(n)
+}
+
+// acquire は空きの範囲で n 個までのワーカーを step の倍数で割り当て、割り当てた数を返す。
+// 空きが step 未満なら何も割り当てずに 0 を返す
+func (p *cpuWorkerPool) acquire(n, step int) (granted int, release func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	granted = min(n, max(p.capacity-p.inUse, 0)) / step * step
+	if granted == 0 {
+		return 0, func() {}
+	}
+	p.add(granted)
+	return granted, p.releaseFunc(granted)
+}
+
+// add は p.mu を保持して呼ぶ
+func (p *cpuWorkerPool) add(n int) {
+	p.inUse += n
+	observability.CNOAppCPUWorkersInUse.Add(float64(n))
+}
+
+func (p *cpuWorkerPool) releaseFunc(n int) func() {
+	return sync.OnceFunc(func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.add(-n)
+	})
+}
+
+// workRejected は runWork が負荷を実行せずに拒否した(CPU ワーカー枠に空きがない)エラーかどうかを返す。
+// 拒否は負荷の失敗(ok=false)ではなく RPC のステータス(RESOURCE_EXHAUSTED)で返し、ストリームはその時点で終了する
+func workRejected(err error) bool {
+	return apperrors.CategoryOf(err) == apperrors.Limit
+}
+
+// runWork は CPU ワーカー枠から cfg の CPU ワーカーを確保して runInstances を実行し、終了後に枠を返す。
+// x-cpu-share の負荷は空きの範囲で parallelism を決め直し(インスタンスごとに最低 1)、
+// 空きがなければ実行せずに RESOURCE_EXHAUSTED(reason cpu_workers_exhausted)で失敗させる
+func (s *GrpcBurnerServer) runWork(ctx context.Context, cfg load.Config, n int) error {
+	if !usesCPUWorkers(cfg.Mode) {
+		return runInstances(ctx, cfg, n)
+	}
+	// checkConfig で検証済み
+	share, _ := cpuShareFromContext(ctx)
+	if share == 0 {
+		defer s.cpuWorkers.reserve(cfg.Parallelism * max(n, 1))()
+		return runInstances(ctx, cfg, n)
+	}
+
+	n = max(n, 1)
+	requested := cfg.Parallelism * n
+	granted, release := s.cpuWorkers.acquire(requested, n)
+	defer release()
+	if granted == 0 {
+		observability.CNOAppRejectedRequestsTotal.WithLabelValues(rejectCPUWorkersExhausted).Inc()
+		if s.logger != nil {
+			s.logger.Warnw("work request rejected",
+				"request_id", cfg.RequestID,
+				"reason", rejectCPUWorkersExhausted,
+				"cpu_share", share,
+				"instances", n,
+				"cpu_workers_capacity", s.cpuWorkers.capacity,
+			)
+		}
+		return apperrors.WithReason(apperrors.Limit, rejectCPUWorkersExhausted,
+			fmt.Errorf("no free cpu workers for %s %v (capacity %d)", CPUShareMetadataKey, share, s.cpuWorkers.capacity))
+	}
+	cfg.Parallelism = granted / n
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(CPUWorkersGrantedTrailerKey, fmt.Sprintf("granted=%d;requested=%d", granted, requested)))
+	return runInstances(ctx, cfg, n)
+}
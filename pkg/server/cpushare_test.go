@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+)
+
+func withCPUShare(v string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(CPUShareMetadataKey, v))
+}
+
+func TestCPUShareFromContext(t *testing.T) {
+	if got, err := cpuShareFromContext(context.Background()); err != nil || got != 0 {
+		t.Fatalf("unset: got (%v, %v), want 0", got, err)
+	}
+	if got, err := cpuShareFromContext(withCPUShare("0.5")); err != nil || got != 0.5 {
+		t.Fatalf("0.5: got (%v, %v)", got, err)
+	}
+	for _, v := range []string{"0", "-0.1", "1.5", "NaN", "half"} {
+		if _, err := cpuShareFromContext(withCPUShare(v)); err == nil {
+			t.Fatalf("expected error for %q", v)
+		}
+	}
+}
+
+// x-cpu-share は枠の割合に相当するワーカー数を parallelism の代わりに使い、cpu 系以外のモードでは拒否する
+func TestCheckConfig_CPUShare(t *testing.T) {
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar(), WithCPUWorkers(8))
+
+	cfg, err := s.checkConfig(withCPUShare("0.3"), "req-1", &grpcburnerv1.WorkConfig{
+		Mode:        grpcburnerv1.LoadMode_LOAD_MODE_CPU,
+		DurationMs:  10,
+		Parallelism: 1,
+	})
+	if err != nil {
+		t.Fatalf("checkConfig: %v", err)
+	}
+	if cfg.Parallelism != 3 {
+		t.Fatalf("parallelism = %d, want 3 (ceil(0.3 * 8))", cfg.Parallelism)
+	}
+
+	if _, err := s.checkConfig(withCPUShare("0.3"), "req-2", &grpcburnerv1.WorkConfig{
+		Mode:       grpcburnerv1.LoadMode_LOAD_MODE_IO,
+		DurationMs: 10,
+		IoBytes:    4096,
+	}); err == nil {
+		t.Fatalf("expected error for x-cpu-share with io mode")
+	}
+}
+
+// 空きの範囲で step の倍数だけ割り当て、parallelism 指定の負荷も枠に数えることを確認
+func TestCPUWorkerPool(t *testing.T) {
+	p := newCPUWorkerPool(4)
+
+	releaseFixed := p.reserve(3)
+	if got, release := p.acquire(4, 1); got != 1 {
+		t.Fatalf("acquire with 1 free = %d, want 1", got)
+	} else {
+		release()
+	}
+	if got, _ := p.acquire(4, 2); got != 0 {
+		t.Fatalf("acquire with 1 free and step 2 = %d, want 0", got)
+	}
+
+	// 枠を超えて reserve しても空きは負にならない
+	releaseOver := p.reserve(2)
+	if got, _ := p.acquire(1, 1); got != 0 {
+		t.Fatalf("acquire over capacity = %d, want 0", got)
+	}
+	releaseOver()
+	releaseFixed()
+	releaseFixed() // 2 回呼んでも 1 回分だけ返す
+
+	got, release := p.acquire(6, 2)
+	if got != 4 {
+		t.Fatalf("acquire on empty pool = %d, want 4", got)
+	}
+	release()
+	if p.inUse != 0 {
+		t.Fatalf("inUse = %d after releasing all, want 0", p.inUse)
+	}
+}
+
+// 空きがなければ x-cpu-share の負荷は実行せずに RESOURCE_EXHAUSTED で失敗し、空きがあれば割り当てた数で実行する
+func TestRunWork_CPUShare(t *testing.T) {
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar(), WithCPUWorkers(2))
+	cfg := load.Config{Mode: load.ModeCPU, Duration: 20 * time.Millisecond, Parallelism: 2}
+	ctx := withCPUShare("1")
+
+	release := s.cpuWorkers.reserve(2)
+	err := s.runWork(ctx, cfg, 1)
+	if apperrors.CategoryOf(err) != apperrors.Limit || apperrors.ReasonOf(err) != rejectCPUWorkersExhausted {
+		t.Fatalf("exhausted: got %v (category %q, reason %q)", err, apperrors.CategoryOf(err), apperrors.ReasonOf(err))
+	}
+	release()
+
+	if err := s.runWork(ctx, cfg, 1); err != nil {
+		t.Fatalf("runWork with free workers: %v", err)
+	}
+	if s.cpuWorkers.inUse != 0 {
+		t.Fatalf("inUse = %d after the run, want 0", s.cpuWorkers.inUse)
+	}
+}
+
+// CPU ワーカー枠が埋まっている間の x-cpu-share の負荷は、ok=false の応答ではなく RESOURCE_EXHAUSTED のステータスで拒否される
+func TestCPUShare_RejectedAsStatus(t *testing.T) {
+	client, _ := startBurner(t, WithCPUWorkers(1))
+
+	// parallelism 指定の負荷で枠を埋めておく
+	bctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_, _ = client.DoWork(bctx, &grpcburnerv1.DoWorkRequest{RequestId: "busy", Config: cpuConfig(10 * time.Second)})
+	}()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), CPUShareMetadataKey, "1")
+	calls := []struct {
+		name string
+		call func() error
+	}{
+		{"unary", func() error {
+			resp, err := client.DoWork(ctx, &grpcburnerv1.DoWorkRequest{RequestId: "share", Config: cpuConfig(10 * time.Millisecond)})
+			if err == nil && !resp.GetOk() {
+				t.Fatalf("DoWork = ok=false (%s), want a status error", resp.GetErrorMessage())
+			}
+			return err
+		}},
+		{"server streaming", func() error {
+			stream, err := client.DoWorkServerStreaming(ctx, &grpcburnerv1.DoWorkServerStreamingRequest{RequestId: "share", Repeat: 1, Config: cpuConfig(10 * time.Millisecond)})
+			if err != nil {
+				return err
+			}
+			for {
+				if _, err := stream.Recv(); err != nil {
+					return ignoreEOF(err)
+				}
+			}
+		}},
+		{"bidi streaming", func() error {
+			stream, err := client.DoWorkBidiStreaming(ctx)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&grpcburnerv1.DoWorkRequest{RequestId: "share", Config: cpuConfig(10 * time.Millisecond)}); err != nil {
+				return err
+			}
+			_ = stream.CloseSend()
+			for {
+				if _, err := stream.Recv(); err != nil {
+					return ignoreEOF(err)
+				}
+			}
+		}},
+	}
+	for _, c := range calls {
+		// 枠を埋める負荷が走り始めるまでは拒否されずに実行される
+		deadline := time.Now().Add(5 * time.Second)
+		var err error
+		for err = c.call(); err == nil && time.Now().Before(deadline); err = c.call() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if status.Code(err) != codes.ResourceExhausted || apperrors.ReasonOf(err) != rejectCPUWorkersExhausted {
+			t.Fatalf("%s: got %v (reason %q), want RESOURCE_EXHAUSTED with reason %s", c.name, err, apperrors.ReasonOf(err), rejectCPUWorkersExhausted)
+		}
+	}
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
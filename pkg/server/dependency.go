@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+const (
+	// DependencyLatencyMetadataKey は疑似 downstream の応答にかかる時間(例: "2s")を指定する metadata キー。
+	// 指定した場合のみ、負荷の前に疑似 downstream の呼び出しを行う
+	DependencyLatencyMetadataKey = "x-dependency-latency"
+	// DependencyTimeoutMetadataKey は疑似 downstream の応答を待つ上限(例: "500ms")。未指定なら DefaultDependencyTimeout
+	DependencyTimeoutMetadataKey = "x-dependency-timeout"
+	// DependencyOnTimeoutMetadataKey はタイムアウト時の振る舞い(fail / continue)。未指定なら fail
+	DependencyOnTimeoutMetadataKey = "x-dependency-on-timeout"
+
+	// DefaultDependencyTimeout は x-dependency-timeout 未指定時の待ち時間
+	DefaultDependencyTimeout = time.Second
+	// MaxDependencyLatency は疑似 downstream の応答時間/タイムアウトに指定できる上限
+	MaxDependencyLatency = 60 * time.Second
+
+	dependencyName = "fake-downstream"
+)
+
+// DependencyOnTimeout は疑似 downstream がタイムアウトした時の振る舞い
+type DependencyOnTimeout string
+
+const (
+	// DependencyFail はタイムアウトをそのまま DEADLINE_EXCEEDED として返す
+	DependencyFail DependencyOnTimeout = "fail"
+	// DependencyContinue はタイムアウトを warn ログに残し、負荷の実行を続ける(縮退動作)
+	DependencyContinue DependencyOnTimeout = "continue"
+)
+
+// dependencySpec は metadata で指定された疑似 downstream の設定
+type dependencySpec struct {
+	Latency   time.Duration
+	Timeout   time.Duration
+	OnTimeout DependencyOnTimeout
+}
+
+// dependencyFromContext は incoming metadata から疑似 downstream の設定を取得する。
+// x-dependency-latency が未指定なら ok=false
+func dependencyFromContext(ctx context.Context) (spec dependencySpec, ok bool, err error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	latency := firstMetadata(md, DependencyLatencyMetadataKey)
+	if latency == "" {
+		return dependencySpec{}, false, nil
+	}
+
+	spec = dependencySpec{Timeout: DefaultDependencyTimeout, OnTimeout: DependencyFail}
+	if spec.Latency, err = parseDependencyDuration(DependencyLatencyMetadataKey, latency); err != nil {
+		return dependencySpec{}, false, err
+	}
+	if v := firstMetadata(md, DependencyTimeoutMetadataKey); v != "" {
+		if spec.Timeout, err = parseDependencyDuration(DependencyTimeoutMetadataKey, v); err != nil {
+			return dependencySpec{}, false, err
+		}
+		if spec.Timeout == 0 {
+			return dependencySpec{}, false, fmt.Errorf("%s must be > 0", DependencyTimeoutMetadataKey)
+		}
+	}
+	switch v := DependencyOnTimeout(firstMetadata(md, DependencyOnTimeoutMetadataKey)); v {
+	case "":
+	case DependencyFail, DependencyContinue:
+		spec.OnTimeout = v
+	default:
+		return dependencySpec{}, false, fmt.Errorf("%s must be %q or %q, got %q",
+			DependencyOnTimeoutMetadataKey, DependencyFail, DependencyContinue, v)
+	}
+	return spec, true, nil
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if vals := md.Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func parseDependencyDuration(key, v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, v, err)
+	}
+	if d < 0 || d > MaxDependencyLatency {
+		return 0, fmt.Errorf("%s must be between 0 and %s, got %s", key, MaxDependencyLatency, d)
+	}
+	return d, nil
+}
+
+// callDependency は疑似 downstream の呼び出しをシミュレートする。
+// 子 span "dependency fake-downstream" を作り、Latency の経過を Timeout まで待つ。
+// タイムアウトした場合は DEADLINE_EXCEEDED を返し、OnTimeout=continue なら呼び出し側で握りつぶせるよう
+// degraded=true を返す
+func (s *GrpcBurnerServer) callDependency(ctx context.Context, requestID string, spec dependencySpec) (degraded bool, err error) {
+	defer timingFromContext(ctx).since(TimingDependency, time.Now())
+
+	tracer := otel.Tracer("cno-app-server")
+	ctx, span := tracer.Start(ctx, "dependency "+dependencyName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("peer.service", dependencyName),
+			attribute.Int64("dependency.latency_ms", spec.Latency.Milliseconds()),
+			attribute.Int64("dependency.timeout_ms", spec.Timeout.Milliseconds()),
+			attribute.String("dependency.on_timeout", string(spec.OnTimeout)),
+		),
+	)
+	start := time.Now()
+	defer func() {
+		observability.RecordSpanResult(span, err, time.Since(start))
+		span.End()
+	}()
+
+	depCtx, cancel := context.WithTimeout(ctx, spec.Timeout)
+	defer cancel()
+
+	t := time.NewTimer(spec.Latency)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return false, nil
+	case <-depCtx.Done():
+	}
+
+	// 呼び出し元(クライアント)のキャンセル/期限切れは縮退の対象外
+	if ctx.Err() != nil {
+		return false, apperrors.New(apperrors.Canceled, ctx.Err())
+	}
+
+	err = &apperrors.Error{
+		Category: apperrors.Injected,
+		Reason:   "dependency_timeout",
+		Code:     codes.DeadlineExceeded,
+		Err:      fmt.Errorf("dependency %s timed out after %s", dependencyName, spec.Timeout),
+	}
+	if s.logger != nil {
+		s.logger.Warnw("dependency call timed out",
+			"request_id", requestID,
+			"dependency", dependencyName,
+			"timeout_ms", spec.Timeout.Milliseconds(),
+			"latency_ms", spec.Latency.Milliseconds(),
+			"on_timeout", spec.OnTimeout,
+		)
+	}
+	return spec.OnTimeout == DependencyContinue, err
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestDependencyFromContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		pairs   []string
+		want    dependencySpec
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: "unset", pairs: nil},
+		{
+			name:   "defaults",
+			pairs:  []string{DependencyLatencyMetadataKey, "2s"},
+			want:   dependencySpec{Latency: 2 * time.Second, Timeout: DefaultDependencyTimeout, OnTimeout: DependencyFail},
+			wantOK: true,
+		},
+		{
+			name: "all set",
+			pairs: []string{
+				DependencyLatencyMetadataKey, "300ms",
+				DependencyTimeoutMetadataKey, "100ms",
+				DependencyOnTimeoutMetadataKey, "continue",
+			},
+			want:   dependencySpec{Latency: 300 * time.Millisecond, Timeout: 100 * time.Millisecond, OnTimeout: DependencyContinue},
+			wantOK: true,
+		},
+		{name: "invalid latency", pairs: []string{DependencyLatencyMetadataKey, "soon"}, wantErr: true},
+		{name: "latency too large", pairs: []string{DependencyLatencyMetadataKey, "2h"}, wantErr: true},
+		{name: "zero timeout", pairs: []string{DependencyLatencyMetadataKey, "1s", DependencyTimeoutMetadataKey, "0s"}, wantErr: true},
+		{name: "unknown behavior", pairs: []string{DependencyLatencyMetadataKey, "1s", DependencyOnTimeoutMetadataKey, "retry"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.pairs != nil {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(tt.pairs...))
+			}
+			got, ok, err := dependencyFromContext(ctx)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tt.wantOK || got != tt.want {
+				t.Fatalf("got (%+v, %v), want (%+v, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// downstream がタイムアウトより遅ければ DEADLINE_EXCEEDED、continue なら縮退扱いになることを確認
+func TestCallDependency(t *testing.T) {
+	s := NewGrpcBurnerServer(nil)
+	ctx := context.Background()
+
+	if _, err := s.callDependency(ctx, "req", dependencySpec{Latency: time.Millisecond, Timeout: time.Second, OnTimeout: DependencyFail}); err != nil {
+		t.Fatalf("fast dependency: unexpected error: %v", err)
+	}
+
+	degraded, err := s.callDependency(ctx, "req", dependencySpec{Latency: time.Second, Timeout: 10 * time.Millisecond, OnTimeout: DependencyFail})
+	if status.Code(err) != codes.DeadlineExceeded || degraded {
+		t.Fatalf("fail on timeout: got (degraded=%v, %v)", degraded, err)
+	}
+
+	degraded, err = s.callDependency(ctx, "req", dependencySpec{Latency: time.Second, Timeout: 10 * time.Millisecond, OnTimeout: DependencyContinue})
+	if status.Code(err) != codes.DeadlineExceeded || !degraded {
+		t.Fatalf("continue on timeout: got (degraded=%v, %v)", degraded, err)
+	}
+
+	// クライアント側のキャンセルは continue でも縮退させない
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	degraded, err = s.callDependency(canceled, "req", dependencySpec{Latency: time.Second, Timeout: time.Second, OnTimeout: DependencyContinue})
+	if status.Code(err) != codes.Canceled || degraded {
+		t.Fatalf("canceled: got (degraded=%v, %v)", degraded, err)
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+// ElevationMetadataKey は一時的に上限を引き上げる elevation token を載せる metadata キー。
+// 「1 回だけ大きな負荷をかけたい」場合に、サーバーの上限を恒久的に上げずに済むようにする
+const ElevationMetadataKey = "x-elevation-token"
+
+// MaxElevationTTL は elevation token の有効期間の上限
+const MaxElevationTTL = time.Hour
+
+// ErrElevationInvalid は elevation token が不正(署名不一致、期限切れ、使用済み、モード違い)であることを表す
+var ErrElevationInvalid = errors.New("invalid elevation token")
+
+// ElevationGrant は elevation token に署名して載せる、引き上げの内容。
+// Max* はそのリクエストで使える上限で、0 の項目や通常の上限より小さい項目は通常の上限のままにする(上限を外すことはできない)
+type ElevationGrant struct {
+	ID string `json:"id"`
+	// Mode は対象の負荷モード。空ならどのモードにも使える
+	Mode           load.Mode `json:"mode,omitempty"`
+	MaxDurationMs  int64     `json:"max_duration_ms,omitempty"`
+	MaxAllocMB     int       `json:"max_alloc_mb,omitempty"`
+	MaxParallelism int       `json:"max_parallelism,omitempty"`
+	MaxIOBytes     int       `json:"max_io_bytes,omitempty"`
+	// Reason / IssuedBy は発行時に監査ログへ残した理由と発行者。使用時のログにも出す
+	Reason    string    `json:"reason,omitempty"`
+	IssuedBy  string    `json:"issued_by,omitempty"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// raise は通常の上限 l を grant の範囲で引き上げた上限を返す
+func (g ElevationGrant) raise(l load.Limits) load.Limits {
+	if d := time.Duration(g.MaxDurationMs) * time.Millisecond; l.MaxDuration > 0 && d > l.MaxDuration {
+		l.MaxDuration = d
+	}
+	if l.MaxAllocMB > 0 && g.MaxAllocMB > l.MaxAllocMB {
+		l.MaxAllocMB = g.MaxAllocMB
+	}
+	if l.MaxParallelism > 0 && g.MaxParallelism > l.MaxParallelism {
+		l.MaxParallelism = g.MaxParallelism
+	}
+	if l.MaxIOBytes > 0 && g.MaxIOBytes > l.MaxIOBytes {
+		l.MaxIOBytes = g.MaxIOBytes
+	}
+	return l
+}
+
+// Elevations は elevation token の発行と検証を行う。
+// token は "<base64url(JSON の ElevationGrant)>.<base64url(HMAC-SHA256)>" の形で、1 つの RPC でだけ使える
+// (ストリームでは同じストリームのメッセージ全てに使える)。使用済みの ID は有効期限まで覚えておく
+type Elevations struct {
+	secret []byte
+	now    func() time.Time
+
+	mu sync.Mutex
+	// used は使用済みの token ID と、その token を使った RPC(ストリーム)と有効期限
+	used map[string]elevationUse
+}
+
+type elevationUse struct {
+	rpc       any
+	expiresAt time.Time
+}
+
+// NewElevations は secret で署名/検証する Elevations を返す
+func NewElevations(secret string) *Elevations {
+	return &Elevations{secret: []byte(secret), now: time.Now, used: map[string]elevationUse{}}
+}
+
+// WithElevations は x-elevation-token による上限の一時的な引き上げを有効にする。未指定なら token は無視する
+func WithElevations(e *Elevations) Option {
+	return func(s *GrpcBurnerServer) {
+		s.elevations = e
+	}
+}
+
+// Issue は g に ID と有効期限を付けて署名した token を返す。ttl は 0 より大きく MaxElevationTTL 以下
+func (e *Elevations) Issue(g ElevationGrant, ttl time.Duration) (string, ElevationGrant, error) {
+	if ttl <= 0 || ttl > MaxElevationTTL {
+		return "", ElevationGrant{}, fmt.Errorf("elevation ttl must be between 0 and %s, got %s", MaxElevationTTL, ttl)
+	}
+	if g.MaxDurationMs < 0 || g.MaxAllocMB < 0 || g.MaxParallelism < 0 || g.MaxIOBytes < 0 {
+		return "", ElevationGrant{}, errors.New("elevation limits must be >= 0")
+	}
+	if g.MaxDurationMs == 0 && g.MaxAllocMB == 0 && g.MaxParallelism == 0 && g.MaxIOBytes == 0 {
+		return "", ElevationGrant{}, errors.New("elevation must raise at least one limit")
+	}
+	if g.Mode != "" {
+		if err := (load.LimitProfiles{g.Mode: {}}).Validate(); err != nil {
+			return "", ElevationGrant{}, err
+		}
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", ElevationGrant{}, fmt.Errorf("generate elevation id: %w", err)
+	}
+	g.ID = hex.EncodeToString(id)
+	g.IssuedAt = e.now().UTC()
+	g.ExpiresAt = g.IssuedAt.Add(ttl)
+
+	payload, err := json.Marshal(g)
+	if err != nil {
+		return "", ElevationGrant{}, fmt.Errorf("marshal elevation: %w", err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString(payload)
+	return enc + "." + base64.RawURLEncoding.EncodeToString(e.sign(enc)), g, nil
+}
+
+func (e *Elevations) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, e.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verify は token の署名と有効期限を確かめ、載っている ElevationGrant を返す
+func (e *Elevations) verify(token string) (ElevationGrant, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ElevationGrant{}, fmt.Errorf("%w: malformed", ErrElevationInvalid)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, e.sign(payload)) {
+		return ElevationGrant{}, fmt.Errorf("%w: bad signature", ErrElevationInvalid)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ElevationGrant{}, fmt.Errorf("%w: malformed", ErrElevationInvalid)
+	}
+	var g ElevationGrant
+	if err := json.Unmarshal(b, &g); err != nil {
+		return ElevationGrant{}, fmt.Errorf("%w: malformed", ErrElevationInvalid)
+	}
+	if !e.now().Before(g.ExpiresAt) {
+		return ElevationGrant{}, fmt.Errorf("%w: expired at %s", ErrElevationInvalid, g.ExpiresAt.Format(time.RFC3339))
+	}
+	return g, nil
+}
+
+// consume は grant を rpc の使用として記録する。別の RPC で使用済みなら false を返す。
+// rpc が nil(RPC を識別できない)の場合は毎回別の RPC として扱う
+func (e *Elevations) consume(g ElevationGrant, rpc any) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	for id, u := range e.used {
+		if !now.Before(u.expiresAt) {
+			delete(e.used, id)
+		}
+	}
+	if u, ok := e.used[g.ID]; ok {
+		return rpc != nil && u.rpc == rpc
+	}
+	e.used[g.ID] = elevationUse{rpc: rpc, expiresAt: g.ExpiresAt}
+	return true
+}
+
+// elevationFromContext は incoming metadata の elevation token を検証し、mode に使える ElevationGrant を返す。
+// token が無い、または引き上げが無効なら ok=false
+func (s *GrpcBurnerServer) elevationFromContext(ctx context.Context, mode load.Mode) (g ElevationGrant, ok bool, err error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := firstMetadata(md, ElevationMetadataKey)
+	if token == "" || s.elevations == nil {
+		return ElevationGrant{}, false, nil
+	}
+	if g, err = s.elevations.verify(token); err != nil {
+		return ElevationGrant{}, false, err
+	}
+	if g.Mode != "" && g.Mode != mode {
+		return ElevationGrant{}, false, fmt.Errorf("%w: issued for %s mode, got %s", ErrElevationInvalid, g.Mode, mode)
+	}
+	return g, true, nil
+}
+
+// consumeElevation は検証を通ったリクエストで grant を使用済みにする。
+// 同じストリームのメッセージは同じ RPC として扱うため、grpc.ServerTransportStream で RPC を識別する
+func (s *GrpcBurnerServer) consumeElevation(ctx context.Context, g ElevationGrant) error {
+	var rpc any
+	if st := grpc.ServerTransportStreamFromContext(ctx); st != nil {
+		rpc = st
+	}
+	if !s.elevations.consume(g, rpc) {
+		return fmt.Errorf("%w: %s was already used", ErrElevationInvalid, g.ID)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+func withElevation(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(ElevationMetadataKey, token))
+}
+
+// 有効な token では 1 回だけ通常の上限を超えるリクエストを受け付け、2 回目は拒否することを確認
+func TestCheckConfig_ElevationTokenRaisesLimitsOnce(t *testing.T) {
+	e := NewElevations("secret")
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar(), WithElevations(e))
+	normal := load.DefaultLimitProfiles().For(load.ModeMem)
+	pc := &grpcburnerv1.WorkConfig{
+		Mode:       grpcburnerv1.LoadMode_LOAD_MODE_MEM,
+		DurationMs: 1000,
+		AllocMb:    int32(normal.MaxAllocMB * 2),
+	}
+
+	if _, err := s.checkConfig(context.Background(), "req-0", pc); rejectReason(err) != rejectAllocTooLarge {
+		t.Fatalf("without token: err = %v, want %s", err, rejectAllocTooLarge)
+	}
+
+	token, grant, err := e.Issue(ElevationGrant{Mode: load.ModeMem, MaxAllocMB: normal.MaxAllocMB * 4, Reason: "big burn"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	cfg, err := s.checkConfig(withElevation(token), "req-1", pc)
+	if err != nil {
+		t.Fatalf("with token: %v", err)
+	}
+	if cfg.Limits.MaxAllocMB != grant.MaxAllocMB || cfg.Limits.MaxDuration != normal.MaxDuration {
+		t.Fatalf("Config.Limits = %+v, want alloc %d and the normal duration", cfg.Limits, grant.MaxAllocMB)
+	}
+
+	_, err = s.checkConfig(withElevation(token), "req-2", pc)
+	if rejectReason(err) != rejectElevationInvalid {
+		t.Fatalf("reused token: err = %v, want %s", err, rejectElevationInvalid)
+	}
+	if got := status.Code(err); got != codes.PermissionDenied {
+		t.Fatalf("reused token: code = %s, want PermissionDenied", got)
+	}
+}
+
+func TestElevations_RejectsTamperedExpiredAndOtherMode(t *testing.T) {
+	e := NewElevations("secret")
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar(), WithElevations(e))
+
+	token, _, err := e.Issue(ElevationGrant{Mode: load.ModeMem, MaxAllocMB: 4096}, time.Minute)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	if _, err := NewElevations("other").verify(token); !errors.Is(err, ErrElevationInvalid) {
+		t.Fatalf("other secret: err = %v, want ErrElevationInvalid", err)
+	}
+	if _, err := e.verify(token[:len(token)-2] + "xx"); !errors.Is(err, ErrElevationInvalid) {
+		t.Fatalf("tampered signature: err = %v, want ErrElevationInvalid", err)
+	}
+	if _, _, err := s.elevationFromContext(withElevation(token), load.ModeCPU); !errors.Is(err, ErrElevationInvalid) {
+		t.Fatalf("other mode: err = %v, want ErrElevationInvalid", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := e.verify(token); !errors.Is(err, ErrElevationInvalid) {
+		t.Fatalf("expired: err = %v, want ErrElevationInvalid", err)
+	}
+}
+
+func TestElevations_IssueValidatesGrant(t *testing.T) {
+	e := NewElevations("secret")
+	if _, _, err := e.Issue(ElevationGrant{MaxAllocMB: 1024}, 2*MaxElevationTTL); err == nil {
+		t.Fatal("expected error for ttl over MaxElevationTTL")
+	}
+	if _, _, err := e.Issue(ElevationGrant{}, time.Minute); err == nil {
+		t.Fatal("expected error for a grant that raises nothing")
+	}
+	if _, _, err := e.Issue(ElevationGrant{Mode: "gpu", MaxAllocMB: 1024}, time.Minute); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
+
+// token は通常の上限を外せない(上限 0 = 無制限の項目は引き上げない)ことを確認
+func TestElevationGrant_RaiseKeepsNormalLimits(t *testing.T) {
+	normal := load.Limits{MaxDuration: time.Minute, MaxAllocMB: 512}
+	got := ElevationGrant{MaxDurationMs: 1000, MaxAllocMB: 2048, MaxParallelism: 64}.raise(normal)
+	want := load.Limits{MaxDuration: time.Minute, MaxAllocMB: 2048}
+	if got != want {
+		t.Fatalf("raise = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+const (
+	// ErrorScopeMetadataKey はストリーミング RPC で error_rate をどの単位で適用するかを指定する metadata キー。
+	//   - message(既定): メッセージ(server streaming では repeat の 1 回)ごとに独立に抽選する
+	//   - stream: 最初のメッセージで 1 回だけ抽選し、当たればそのストリームの全メッセージを失敗させる
+	ErrorScopeMetadataKey = "x-error-scope"
+
+	// AbortAfterFailuresMetadataKey はストリーム内で失敗したメッセージがこの数に達したら、
+	// 残りを処理せずにストリーム全体をエラーで終了させる metadata キー。0 または未指定なら打ち切らない
+	AbortAfterFailuresMetadataKey = "x-abort-after-failures"
+
+	// AbortAfterFailuresTrailerKey は失敗数でストリームを打ち切った時に返す trailer のキー。
+	// 値は "failures=<失敗数>;total=<処理したメッセージ数>"
+	AbortAfterFailuresTrailerKey = "x-aborted-after-failures"
+)
+
+// ErrorScope の値
+const (
+	ErrorScopeMessage = "message"
+	ErrorScopeStream  = "stream"
+)
+
+// rejectAbortAfterFailures は失敗数で打ち切った時のエラーの細分類(error_reason)
+const rejectAbortAfterFailures = "abort_after_failures"
+
+// streamFailures はストリーム 1 本分の error_rate の適用単位と失敗数を管理する
+type streamFailures struct {
+	scope      string
+	abortAfter int
+
+	decided    bool // scope=stream の抽選を済ませたか
+	failStream bool // scope=stream で当たったか
+	failed     int
+	total      int
+}
+
+// streamFailuresFromContext は incoming metadata からストリームの失敗の扱いを取得する
+func streamFailuresFromContext(ctx context.Context) (*streamFailures, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f := &streamFailures{scope: ErrorScopeMessage}
+
+	switch v := firstMetadata(md, ErrorScopeMetadataKey); v {
+	case "", ErrorScopeMessage:
+	case ErrorScopeStream:
+		f.scope = ErrorScopeStream
+	default:
+		return nil, fmt.Errorf("invalid %s %q: must be %s or %s", ErrorScopeMetadataKey, v, ErrorScopeMessage, ErrorScopeStream)
+	}
+
+	if v := firstMetadata(md, AbortAfterFailuresMetadataKey); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q: must be an integer >= 0", AbortAfterFailuresMetadataKey, v)
+		}
+		f.abortAfter = n
+	}
+	return f, nil
+}
+
+// apply は scope に応じて 1 メッセージ分の負荷の設定の error_rate を決める。
+// scope=stream では error_rate を持つ最初のメッセージで抽選し、以降のメッセージの error_rate を 1 か 0 に固定する
+func (f *streamFailures) apply(cfg load.Config) load.Config {
+	if f.scope != ErrorScopeStream {
+		return cfg
+	}
+	if !f.decided && cfg.ErrorRate > 0 {
+		f.decided = true
+		f.failStream = rand.Float64() < cfg.ErrorRate
+	}
+	cfg.ErrorRate = 0
+	if f.failStream {
+		cfg.ErrorRate = 1
+	}
+	return cfg
+}
+
+// record は 1 メッセージの結果を記録し、失敗数が abortAfter に達したらストリームを終了させるエラーを返す
+func (f *streamFailures) record(ctx context.Context, failed bool) error {
+	f.total++
+	if !failed {
+		return nil
+	}
+	f.failed++
+	if f.abortAfter <= 0 || f.failed < f.abortAfter {
+		return nil
+	}
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(AbortAfterFailuresTrailerKey, fmt.Sprintf("failures=%d;total=%d", f.failed, f.total)))
+	return apperrors.WithReason(apperrors.Injected, rejectAbortAfterFailures,
+		fmt.Errorf("stream aborted after %d failed messages (of %d)", f.failed, f.total))
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+func failingConfig(rate float64) *grpcburnerv1.WorkConfig {
+	c := cpuConfig(time.Millisecond)
+	c.ErrorRate = rate
+	return c
+}
+
+func TestStreamFailuresFromContext(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		ErrorScopeMetadataKey, "stream",
+		AbortAfterFailuresMetadataKey, "3",
+	))
+	f, err := streamFailuresFromContext(ctx)
+	if err != nil || f.scope != ErrorScopeStream || f.abortAfter != 3 {
+		t.Fatalf("streamFailuresFromContext = %+v, %v", f, err)
+	}
+
+	for _, pairs := range [][]string{
+		{ErrorScopeMetadataKey, "run"},
+		{AbortAfterFailuresMetadataKey, "-1"},
+		{AbortAfterFailuresMetadataKey, "many"},
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+		if _, err := streamFailuresFromContext(ctx); err == nil {
+			t.Errorf("%v: want error", pairs)
+		}
+	}
+}
+
+// scope=stream では最初に error_rate を持つメッセージで 1 回だけ抽選し、以降は同じ結果になる
+func TestStreamFailures_ApplyStreamScope(t *testing.T) {
+	for _, want := range []float64{0, 1} {
+		f := &streamFailures{scope: ErrorScopeStream}
+		// error_rate=1 / 0 なら抽選の結果が決まる
+		first := f.apply(load.Config{ErrorRate: want})
+		if first.ErrorRate != want {
+			t.Fatalf("first message error_rate = %v, want %v", first.ErrorRate, want)
+		}
+		if want == 0 {
+			// error_rate=0 のメッセージでは抽選しない
+			if f.decided {
+				t.Fatal("decided on a message without error_rate")
+			}
+			continue
+		}
+		for i := 0; i < 5; i++ {
+			if got := f.apply(load.Config{ErrorRate: 0.01}).ErrorRate; got != 1 {
+				t.Fatalf("message %d error_rate = %v, want 1 (the whole stream fails)", i+2, got)
+			}
+		}
+	}
+
+	f := &streamFailures{scope: ErrorScopeMessage}
+	if got := f.apply(load.Config{ErrorRate: 0.3}).ErrorRate; got != 0.3 {
+		t.Fatalf("scope=message error_rate = %v, want unchanged", got)
+	}
+}
+
+// N 回目の失敗のメッセージまでは返し、その後 INTERNAL と trailer でストリームを終了する
+func TestServerStreaming_AbortAfterFailures(t *testing.T) {
+	client, _ := startBurner(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AbortAfterFailuresMetadataKey, "2")
+
+	var trailer metadata.MD
+	stream, err := client.DoWorkServerStreaming(ctx, &grpcburnerv1.DoWorkServerStreamingRequest{
+		RequestId: "req-1",
+		Config:    failingConfig(1),
+		Repeat:    5,
+	}, grpc.Trailer(&trailer))
+	if err != nil {
+		t.Fatalf("DoWorkServerStreaming: %v", err)
+	}
+
+	received := 0
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if status.Code(err) != codes.Internal {
+				t.Fatalf("Recv: err = %v, want Internal", err)
+			}
+			break
+		}
+		if resp.GetOk() {
+			t.Fatalf("response %d ok, want injected failure", received)
+		}
+		received++
+	}
+	if received != 2 {
+		t.Fatalf("received %d responses, want 2", received)
+	}
+	if got := trailer.Get(AbortAfterFailuresTrailerKey); len(got) != 1 || got[0] != "failures=2;total=2" {
+		t.Fatalf("trailer %s = %v", AbortAfterFailuresTrailerKey, got)
+	}
+}
+
+// Client streaming では不正な設定のメッセージも失敗に数える
+func TestClientStreaming_AbortAfterFailures(t *testing.T) {
+	client, _ := startBurner(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AbortAfterFailuresMetadataKey, "2")
+
+	stream, err := client.DoWorkClientStreaming(ctx)
+	if err != nil {
+		t.Fatalf("DoWorkClientStreaming: %v", err)
+	}
+	reqs := []*grpcburnerv1.DoWorkRequest{
+		{RequestId: "req-1", Config: cpuConfig(time.Millisecond)},
+		{RequestId: "req-1"}, // config なし
+		{RequestId: "req-1", Config: cpuConfig(time.Millisecond)},
+		{RequestId: "req-1", Config: failingConfig(1)},
+		{RequestId: "req-1", Config: cpuConfig(time.Millisecond)},
+	}
+	for _, r := range reqs {
+		if err := stream.Send(r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("CloseAndRecv: err = %v, want Internal", err)
+	}
+	if got := stream.Trailer().Get(AbortAfterFailuresTrailerKey); len(got) != 1 || got[0] != "failures=2;total=4" {
+		t.Fatalf("trailer %s = %v", AbortAfterFailuresTrailerKey, got)
+	}
+}
+
+// scope=stream で当たったストリームは、error_rate を持たない後続のメッセージも失敗する
+func TestBidiStreaming_StreamScope(t *testing.T) {
+	client, _ := startBurner(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), ErrorScopeMetadataKey, ErrorScopeStream)
+
+	stream, err := client.DoWorkBidiStreaming(ctx)
+	if err != nil {
+		t.Fatalf("DoWorkBidiStreaming: %v", err)
+	}
+	for i, cfg := range []*grpcburnerv1.WorkConfig{failingConfig(1), cpuConfig(time.Millisecond), cpuConfig(time.Millisecond)} {
+		if err := stream.Send(&grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: cfg}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if resp.GetOk() {
+			t.Fatalf("response %d ok, want failure for the whole stream", i)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("Recv after CloseSend: %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// FailAfterMetadataKey は負荷を開始してから失敗させるまでの時間(ミリ秒、例: "1500")を指定する metadata キー。
+// error_rate による注入は負荷をかける前に即座に失敗するが、こちらは途中までリソースを使ってから失敗するため、
+// 本番の障害に近いトレース(処理の途中でエラーになった span)を作れる。
+// proto に fail_after_ms フィールドが追加されるまでは metadata で受け渡す。値は duration_ms 未満でなければならない
+const FailAfterMetadataKey = "x-fail-after-ms"
+
+// failAfterFromContext は incoming metadata から途中で失敗させるまでの時間を取得する。未指定なら 0(無効)
+func failAfterFromContext(ctx context.Context) (time.Duration, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := firstMetadata(md, FailAfterMetadataKey)
+	if v == "" {
+		return 0, nil
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", FailAfterMetadataKey, v, err)
+	}
+	if err := checkMillis(FailAfterMetadataKey, ms); err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
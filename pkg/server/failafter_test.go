@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+)
+
+// fail_after を指定した負荷が途中まで実行されてから injected(reason fail_after)で失敗し、
+// duration 以上の値は検証で拒否されることを確認
+func TestFailAfter(t *testing.T) {
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar())
+	pc := &grpcburnerv1.WorkConfig{
+		Mode:        grpcburnerv1.LoadMode_LOAD_MODE_CPU,
+		DurationMs:  2000,
+		Parallelism: 1,
+	}
+	withFailAfter := func(v string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(FailAfterMetadataKey, v))
+	}
+
+	ctx := withFailAfter("100")
+	cfg, err := s.checkConfig(ctx, "req-1", pc)
+	if err != nil {
+		t.Fatalf("checkConfig: %v", err)
+	}
+	if cfg.FailAfter != 100*time.Millisecond {
+		t.Fatalf("FailAfter = %s, want 100ms", cfg.FailAfter)
+	}
+	res, err := execLoad(ctx, cfg)
+	if apperrors.CategoryOf(err) != apperrors.Injected || apperrors.ReasonOf(err) != "fail_after" {
+		t.Fatalf("expected injected/fail_after, got %v (category=%s reason=%s)", err, apperrors.CategoryOf(err), apperrors.ReasonOf(err))
+	}
+	if res.Elapsed < 100*time.Millisecond || res.Elapsed > time.Second {
+		t.Fatalf("expected to fail shortly after 100ms, took %s", res.Elapsed)
+	}
+
+	for _, v := range []string{"2000", "-1", "soon"} {
+		if _, err := s.checkConfig(withFailAfter(v), "req-2", pc); apperrors.CategoryOf(err) != apperrors.Validation {
+			t.Fatalf("%s=%q: expected validation error, got %v", FailAfterMetadataKey, v, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+// 信頼できないクライアントの WorkConfig を load.Config に変換・検証する経路を探索する。
+// パニックしないこと、検証を通った設定が proto の値を正確に反映し上限内に収まることを確認する。
+// go test ./pkg/server -run '^$' -fuzz FuzzWorkConfigFromProto で継続的に探索できる
+func FuzzWorkConfigFromProto(f *testing.F) {
+	f.Add(int32(grpcburnerv1.LoadMode_LOAD_MODE_CPU), int64(1000), int32(0), int32(1), int32(0), int64(0), 0.0)
+	f.Add(int32(grpcburnerv1.LoadMode_LOAD_MODE_IO), int64(1000), int32(0), int32(0), int32(64*1024), int64(10), 1.0)
+	f.Add(int32(grpcburnerv1.LoadMode_LOAD_MODE_MEM), int64(-1), int32(-1), int32(-1), int32(-1), int64(-1), -1.0)
+	// 9223372036855 ms は Duration への変換でオーバーフローして小さな正の値になる
+	f.Add(int32(grpcburnerv1.LoadMode_LOAD_MODE_CPU), int64(9223372036855), int32(1), int32(1), int32(1), int64(math.MaxInt64), math.NaN())
+	f.Add(int32(99), int64(1000), int32(1), int32(1), int32(1), int64(0), 0.0)
+
+	f.Fuzz(func(t *testing.T, mode int32, durationMs int64, allocMB, parallelism, ioBytes int32, latencyMs int64, errorRate float64) {
+		pc := &grpcburnerv1.WorkConfig{
+			Mode:        grpcburnerv1.LoadMode(mode),
+			DurationMs:  durationMs,
+			AllocMb:     allocMB,
+			Parallelism: parallelism,
+			IoBytes:     ioBytes,
+			LatencyMs:   latencyMs,
+			ErrorRate:   errorRate,
+		}
+		cfg, err := workConfigFromProto(pc)
+		if err != nil {
+			return
+		}
+		if err := load.Validate(cfg); err != nil {
+			return
+		}
+
+		if cfg.Duration.Milliseconds() != durationMs || cfg.Latency.Milliseconds() != latencyMs {
+			t.Fatalf("conversion changed values: duration_ms=%d -> %s, latency_ms=%d -> %s",
+				durationMs, cfg.Duration, latencyMs, cfg.Latency)
+		}
+		limits := load.DefaultLimitProfiles().For(cfg.Mode)
+		if cfg.Duration <= 0 || cfg.Duration > limits.MaxDuration {
+			t.Fatalf("accepted duration %s", cfg.Duration)
+		}
+		if overLimit(cfg.AllocMB, limits.MaxAllocMB) || overLimit(cfg.Parallelism, limits.MaxParallelism) || overLimit(cfg.IOBytes, limits.MaxIOBytes) {
+			t.Fatalf("accepted config over limits: %+v", cfg)
+		}
+		if math.IsNaN(cfg.ErrorRate) || cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+			t.Fatalf("accepted error_rate %v", cfg.ErrorRate)
+		}
+		if cfg.Latency < 0 || cfg.Latency > time.Duration(maxMillis)*time.Millisecond {
+			t.Fatalf("accepted latency %s", cfg.Latency)
+		}
+	})
+}
+
+// overLimit は v が上限を超えているかを返す。0 の上限はそのモードでは制限しない項目
+func overLimit(v, limit int) bool {
+	return limit > 0 && v > limit
+}
@@ -6,21 +6,77 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"time"
 
+	"go.uber.org/zap"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
 	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
 	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
 )
 
 // GrpcBurnerServer は grpcburnerv1.GrpcBurnerServerを実装する
 type GrpcBurnerServer struct {
 	grpcburnerv1.UnimplementedBurnerServer
+
+	logger *zap.SugaredLogger
+	ioDir  string
+	work   *WorkRegistry
+	noisy  *noisyNeighbor
+	limits *ReloadableLimitProfiles
+
+	// watchdogGrace は load.Config.WatchdogGrace に渡す watchdog の猶予の倍率。0 なら無効
+	watchdogGrace float64
+	// cpuAffinity が true なら x-cpu-affinity によるコアへの固定を受け付ける
+	cpuAffinity bool
+	// cpuWorkers は同時に実行中の負荷の CPU ワーカー数を数え、x-cpu-share の負荷に空きを割り当てる
+	cpuWorkers *cpuWorkerPool
+	// elevations が nil でなければ、x-elevation-token で 1 回の RPC に限って上限を引き上げる
+	elevations *Elevations
+	// mirror が nil でなければ、Unary の DoWork の一部を shadow サーバーへ非同期に複製する
+	mirror *MirrorClient
+	// coalescer が nil でなければ、同じキーで同時に届いた Unary の DoWork を 1 回の実行にまとめる
+	coalescer *workCoalescer
+
+	streamLimits StreamLimits
+}
+
+// Option は GrpcBurnerServer の任意設定
+type Option func(*GrpcBurnerServer)
+
+// WithIODir は I/O 負荷の一時ファイルを置くディレクトリを指定する。空なら OS の既定
+func WithIODir(dir string) Option {
+	return func(s *GrpcBurnerServer) {
+		s.ioDir = dir
+	}
+}
+
+// WithWatchdogGrace は負荷実行の watchdog の猶予の倍率を指定する。0 なら無効。未指定なら load.DefaultWatchdogGrace
+func WithWatchdogGrace(grace float64) Option {
+	return func(s *GrpcBurnerServer) {
+		s.watchdogGrace = grace
+	}
 }
 
 // NewGrpcBurnerServer はアプリの gRPCサーバー実装を返す
 // 後続ブランチで logger や設定を差し込む際に拡張しやすいよう、コンストラクタ関数とする
-func NewGrpcBurnerServer() *GrpcBurnerServer {
-	return &GrpcBurnerServer{}
+func NewGrpcBurnerServer(logger *zap.SugaredLogger, opts ...Option) *GrpcBurnerServer {
+	s := &GrpcBurnerServer{logger: logger, watchdogGrace: load.DefaultWatchdogGrace}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.work == nil {
+		s.work = NewWorkRegistry()
+	}
+	if s.limits == nil {
+		s.limits = NewReloadableLimitProfiles(load.DefaultLimitProfiles())
+	}
+	if s.cpuWorkers == nil {
+		s.cpuWorkers = defaultCPUWorkerPool()
+	}
+	return s
 }
 
 // Pingは軽量な到達確認用 RPC
@@ -33,16 +89,30 @@ func (s *GrpcBurnerServer) Ping(
 }
 
 // DoWorkは Unary 型の負荷実行 RPC
+// metadata x-instances が指定された場合は、同じ config で load.Run を並列に複数実行し、結果を 1 レスポンスにまとめる。
+// metadata x-dependency-latency が指定された場合は、負荷の前に疑似 downstream を呼び出し、
+// タイムアウト時は DEADLINE_EXCEEDED を返す(x-dependency-on-timeout=continue なら負荷を続行する)。
+// metadata x-response-padding-bytes が指定された場合は、負荷の結果のレスポンスをそのサイズまで水増しする。
+// metadata x-cpu-share が指定された場合は、CPU ワーカー枠の空きの範囲で parallelism を割り当てる。
+// metadata x-start-at が指定された場合は、その時刻まで待ってから負荷を開始する(レプリカ間での開始の同期)。
+// 合流(WithCoalescing)が有効なら、同じキー(x-idempotency-key か config のハッシュ)で実行中の負荷の結果を共有する。
+// ミラー(WithMirror)が有効なら、検証を通ったリクエストの一部を shadow サーバーへ非同期に複製する
 func (s *GrpcBurnerServer) DoWork(
 	ctx context.Context,
 	req *grpcburnerv1.DoWorkRequest,
 ) (*grpcburnerv1.DoWorkResponse, error) {
 	if req == nil {
-		return nil, fmt.Errorf("request is nil")
+		return nil, apperrors.New(apperrors.Validation, fmt.Errorf("request is nil"))
 	}
 
-	cfg, err := workConfigFromProto(req.GetConfig())
+	ctx, done := s.work.track(ctx)
+	defer done()
+	timingFromContext(ctx).begin()
+	s.stealCPU(ctx)
+
+	cfg, err := s.checkConfig(ctx, req.GetRequestId(), req.GetConfig())
 	if err != nil {
+		observability.SetResponseCategory(ctx, apperrors.CategoryOf(err))
 		return &grpcburnerv1.DoWorkResponse{
 			RequestId:    req.GetRequestId(),
 			Ok:           false,
@@ -50,18 +120,74 @@ func (s *GrpcBurnerServer) DoWork(
 		}, nil
 	}
 
-	if err := load.Run(ctx, cfg); err != nil {
+	instances, err := instancesFromContext(ctx)
+	if err != nil {
+		observability.SetResponseCategory(ctx, apperrors.Validation)
+		return &grpcburnerv1.DoWorkResponse{
+			RequestId:    req.GetRequestId(),
+			Ok:           false,
+			ErrorMessage: fmt.Sprintf("invalid instances: %v", err),
+		}, nil
+	}
+
+	padding, err := responsePaddingFromContext(ctx)
+	if err != nil {
+		observability.SetResponseCategory(ctx, apperrors.Validation)
 		return &grpcburnerv1.DoWorkResponse{
 			RequestId:    req.GetRequestId(),
 			Ok:           false,
-			ErrorMessage: err.Error(),
+			ErrorMessage: fmt.Sprintf("invalid response padding: %v", err),
 		}, nil
 	}
 
-	return &grpcburnerv1.DoWorkResponse{
+	dep, hasDep, err := dependencyFromContext(ctx)
+	if err != nil {
+		observability.SetResponseCategory(ctx, apperrors.Validation)
+		return &grpcburnerv1.DoWorkResponse{
+			RequestId:    req.GetRequestId(),
+			Ok:           false,
+			ErrorMessage: fmt.Sprintf("invalid dependency: %v", err),
+		}, nil
+	}
+	if hasDep {
+		degraded, err := s.callDependency(ctx, req.GetRequestId(), dep)
+		if err != nil && !degraded {
+			return nil, err
+		}
+	}
+
+	startAt, err := startAtFromContext(ctx)
+	if err != nil {
+		observability.SetResponseCategory(ctx, apperrors.Validation)
+		return &grpcburnerv1.DoWorkResponse{
+			RequestId:    req.GetRequestId(),
+			Ok:           false,
+			ErrorMessage: fmt.Sprintf("invalid start-at: %v", err),
+		}, nil
+	}
+	if !startAt.IsZero() {
+		if err := s.waitStartAt(ctx, req.GetRequestId(), startAt); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.mirror != nil && !isShadow(ctx) {
+		s.mirror.send(ctx, req)
+	}
+
+	resp := &grpcburnerv1.DoWorkResponse{
 		RequestId: req.GetRequestId(),
 		Ok:        true,
-	}, nil
+	}
+	if err := s.runWorkCoalesced(ctx, cfg, instances); workRejected(err) {
+		return nil, err
+	} else if err != nil {
+		observability.SetResponseCategory(ctx, apperrors.CategoryOf(err))
+		resp.Ok = false
+		resp.ErrorMessage = err.Error()
+	}
+	PadMessage(resp, padding)
+	return resp, nil
 }
 
 // DoWorkServerStreaming は同じ config を repeat回実行し、その結果をストリームで返す
@@ -70,36 +196,62 @@ func (s *GrpcBurnerServer) DoWorkServerStreaming(
 	stream grpcburnerv1.Burner_DoWorkServerStreamingServer,
 ) error {
 	if req == nil {
-		return fmt.Errorf("request is nil")
+		return apperrors.New(apperrors.Validation, fmt.Errorf("request is nil"))
 	}
 	if req.GetRepeat() <= 0 {
-		return fmt.Errorf("repeat must be > 0")
+		return apperrors.New(apperrors.Validation, fmt.Errorf("repeat must be > 0"))
 	}
 
-	cfg, err := workConfigFromProto(req.GetConfig())
+	ctx, done := s.work.track(stream.Context())
+	defer done()
+	timingFromContext(ctx).begin()
+
+	cfg, err := s.checkConfig(ctx, req.GetRequestId(), req.GetConfig())
 	if err != nil {
 		return fmt.Errorf("invalid config: %w", err)
 	}
-
-	ctx := stream.Context()
+	if err := s.admitRepeat(ctx, req.GetRequestId(), cfg, int(req.GetRepeat())); err != nil {
+		return err
+	}
+	padding, err := responsePaddingFromContext(ctx)
+	if err != nil {
+		return apperrors.New(apperrors.Validation, err)
+	}
+	failures, err := streamFailuresFromContext(ctx)
+	if err != nil {
+		return apperrors.New(apperrors.Validation, err)
+	}
 
 	for i := int32(0); i < req.GetRepeat(); i++ {
+		// kill-switch の場合は残りの repeat を実行せずに打ち切る
+		if killed(ctx) {
+			return killedError()
+		}
 		if err := ctx.Err(); err != nil {
-			return err
+			return apperrors.New(apperrors.Canceled, err)
 		}
 
-		runErr := load.Run(ctx, cfg)
+		s.stealCPU(ctx)
+		runErr := s.runWork(ctx, failures.apply(cfg), 1)
+		if workRejected(runErr) {
+			return runErr
+		}
 		resp := &grpcburnerv1.DoWorkResponse{
 			RequestId: req.GetRequestId(),
 			Ok:        runErr == nil,
 		}
 		if runErr != nil {
+			observability.SetResponseCategory(ctx, apperrors.CategoryOf(runErr))
 			resp.ErrorMessage = runErr.Error()
 		}
+		PadMessage(resp, padding)
 
 		if err := stream.Send(resp); err != nil {
 			return err
 		}
+		if err := failures.record(ctx, runErr != nil); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -108,56 +260,124 @@ func (s *GrpcBurnerServer) DoWorkServerStreaming(
 func (s *GrpcBurnerServer) DoWorkClientStreaming(
 	stream grpcburnerv1.Burner_DoWorkClientStreamingServer,
 ) error {
+	ctx, done := s.work.track(stream.Context())
+	defer done()
+	timingFromContext(ctx).begin()
+
+	padding, err := responsePaddingFromContext(ctx)
+	if err != nil {
+		return apperrors.New(apperrors.Validation, err)
+	}
+
+	summary, err := s.consumeWork(ctx, stream.Recv, 0, nil)
+	if err != nil {
+		return err
+	}
+	PadMessage(summary, padding)
+	return stream.SendAndClose(summary)
+}
+
+// consumeWork は recv が io.EOF を返すまで DoWorkRequest を受信して負荷を実行し、集計を返す。
+// every > 0 なら every メッセージごとに途中までの集計で flush を呼ぶ
+func (s *GrpcBurnerServer) consumeWork(
+	ctx context.Context,
+	recv func() (*grpcburnerv1.DoWorkRequest, error),
+	every int,
+	flush func(*grpcburnerv1.DoWorkSummary) error,
+) (*grpcburnerv1.DoWorkSummary, error) {
 	var (
 		total      int32
 		success    int32
 		failed     int32
 		summaryReq string
 	)
+	summary := func() *grpcburnerv1.DoWorkSummary {
+		return &grpcburnerv1.DoWorkSummary{
+			RequestId: summaryReq,
+			Total:     total,
+			Success:   success,
+			Failed:    failed,
+		}
+	}
 
-	ctx := stream.Context()
+	recvDelay, err := recvDelayFromContext(ctx)
+	if err != nil {
+		return nil, apperrors.New(apperrors.Validation, err)
+	}
+	failures, err := streamFailuresFromContext(ctx)
+	if err != nil {
+		return nil, apperrors.New(apperrors.Validation, err)
+	}
+	budget := s.newStreamBudget()
 
 	for {
-		req, err := stream.Recv()
+		req, err := recv()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return err
+			return nil, err
 		}
+		delayRecv(ctx, recvDelay)
 
 		total++
 		if summaryReq == "" {
 			summaryReq = req.GetRequestId()
 		}
 
-		cfg, cfgErr := workConfigFromProto(req.GetConfig())
-		if cfgErr != nil {
-			failed++
-			continue
+		cfg, cfgErr := s.checkConfig(ctx, req.GetRequestId(), req.GetConfig())
+		if err := s.admit(ctx, budget, req.GetRequestId(), cfg); err != nil {
+			return nil, err
 		}
-
-		if err := load.Run(ctx, cfg); err != nil {
-			failed++
-		} else {
+		failErr := cfgErr
+		if cfgErr == nil {
+			s.stealCPU(ctx)
+			runErr := s.runWork(ctx, failures.apply(cfg), 1)
+			if workRejected(runErr) {
+				return nil, runErr
+			}
+			failErr = runErr
+			if killed(ctx) {
+				return nil, killedError()
+			}
+		}
+		ok := failErr == nil
+		if ok {
 			success++
+		} else {
+			observability.SetResponseCategory(ctx, apperrors.CategoryOf(failErr))
+			failed++
 		}
-	}
 
-	summary := &grpcburnerv1.DoWorkSummary{
-		RequestId: summaryReq,
-		Total:     total,
-		Success:   success,
-		Failed:    failed,
+		if every > 0 && int(total)%every == 0 {
+			if err := flush(summary()); err != nil {
+				return nil, err
+			}
+		}
+		if err := failures.record(ctx, !ok); err != nil {
+			return nil, err
+		}
 	}
-	return stream.SendAndClose(summary)
+	return summary(), nil
 }
 
 // DoWorkBidiStreamingはリクエストごとにDoWorkを実行し、その結果を逐次返す。
 func (s *GrpcBurnerServer) DoWorkBidiStreaming(
 	stream grpcburnerv1.Burner_DoWorkBidiStreamingServer,
 ) error {
-	ctx := stream.Context()
+	ctx, done := s.work.track(stream.Context())
+	defer done()
+	timingFromContext(ctx).begin()
+
+	padding, err := responsePaddingFromContext(ctx)
+	if err != nil {
+		return apperrors.New(apperrors.Validation, err)
+	}
+	failures, err := streamFailuresFromContext(ctx)
+	if err != nil {
+		return apperrors.New(apperrors.Validation, err)
+	}
+	budget := s.newStreamBudget()
 
 	for {
 		req, err := stream.Recv()
@@ -168,23 +388,38 @@ func (s *GrpcBurnerServer) DoWorkBidiStreaming(
 			return err
 		}
 
-		cfg, cfgErr := workConfigFromProto(req.GetConfig())
+		cfg, cfgErr := s.checkConfig(ctx, req.GetRequestId(), req.GetConfig())
+		if err := s.admit(ctx, budget, req.GetRequestId(), cfg); err != nil {
+			return err
+		}
 		resp := &grpcburnerv1.DoWorkResponse{
 			RequestId: req.GetRequestId(),
 			Ok:        cfgErr == nil,
 		}
 
 		if cfgErr == nil {
-			if err := load.Run(ctx, cfg); err != nil {
+			s.stealCPU(ctx)
+			if err := s.runWork(ctx, failures.apply(cfg), 1); workRejected(err) {
+				return err
+			} else if err != nil {
+				observability.SetResponseCategory(ctx, apperrors.CategoryOf(err))
 				resp.Ok = false
 				resp.ErrorMessage = err.Error()
 			}
 		} else {
+			observability.SetResponseCategory(ctx, apperrors.CategoryOf(cfgErr))
 			resp.ErrorMessage = cfgErr.Error()
 		}
+		PadMessage(resp, padding)
 		if err := stream.Send(resp); err != nil {
 			return err
 		}
+		if killed(ctx) {
+			return killedError()
+		}
+		if err := failures.record(ctx, !resp.Ok); err != nil {
+			return err
+		}
 	}
 }
 
@@ -207,6 +442,15 @@ func workConfigFromProto(pc *grpcburnerv1.WorkConfig) (load.Config, error) {
 		return load.Config{}, fmt.Errorf("unsupported mode: %v", pc.GetMode())
 	}
 
+	// ミリ秒 → Duration の乗算で int64 がオーバーフローすると、巨大な値が上限内の値に化けて
+	// 検証をすり抜けるため、変換前に範囲を確認する
+	if err := checkMillis("duration_ms", pc.GetDurationMs()); err != nil {
+		return load.Config{}, err
+	}
+	if err := checkMillis("latency_ms", pc.GetLatencyMs()); err != nil {
+		return load.Config{}, err
+	}
+
 	cfg := load.Config{
 		Mode:        mode,
 		Duration:    time.Duration(pc.GetDurationMs()) * time.Millisecond,
@@ -219,3 +463,13 @@ func workConfigFromProto(pc *grpcburnerv1.WorkConfig) (load.Config, error) {
 
 	return cfg, nil
 }
+
+// maxMillis は time.Duration に変換してもオーバーフローしないミリ秒の上限
+const maxMillis = math.MaxInt64 / int64(time.Millisecond)
+
+func checkMillis(field string, ms int64) error {
+	if ms < 0 || ms > maxMillis {
+		return fmt.Errorf("%s out of range: %d", field, ms)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+const (
+	// InstancesMetadataKey は 1 回の DoWork で並列実行する load.Run の数を指定する metadata キー。
+	// proto に instances フィールドが追加されるまでは metadata で受け渡す
+	InstancesMetadataKey = "x-instances"
+
+	// MaxInstances は 1 リクエストで起動できる並列 load.Run 数の上限
+	MaxInstances = 64
+)
+
+// instancesFromContext は incoming metadata から instances を取得する。未指定なら 1
+func instancesFromContext(ctx context.Context) (int, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 1, nil
+	}
+	vals := md.Get(InstancesMetadataKey)
+	if len(vals) == 0 || vals[0] == "" {
+		return 1, nil
+	}
+
+	n, err := strconv.Atoi(vals[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", InstancesMetadataKey, vals[0], err)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("%s must be > 0, got %d", InstancesMetadataKey, n)
+	}
+	if n > MaxInstances {
+		return 0, fmt.Errorf("%s exceeds max %d, got %d", InstancesMetadataKey, MaxInstances, n)
+	}
+	return n, nil
+}
+
+// runInstances は同じ cfg で load.Run を n 個並列に実行し、全ての完了を待つ。
+// 失敗したインスタンスのエラーは "instance N: ..." の形でまとめて返す。
+// server-timing には並列実行のうち最も長くかかったインスタンスの時間を記録する
+func runInstances(ctx context.Context, cfg load.Config, n int) error {
+	if n <= 1 {
+		return runLoad(ctx, cfg)
+	}
+
+	errs := make([]error, n)
+	results := make([]load.RunResult, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if results[i], err = execLoad(ctx, cfg); err != nil {
+				errs[i] = fmt.Errorf("instance %d: %w", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	slowest := results[0]
+	for _, res := range results[1:] {
+		if res.Elapsed > slowest.Elapsed {
+			slowest = res
+		}
+	}
+	timingFromContext(ctx).addLoad(cfg, slowest)
+
+	return errors.Join(errs...)
+}
+
+// runLoad は execLoad で負荷を 1 回実行し、その時間を server-timing に加算する
+func runLoad(ctx context.Context, cfg load.Config) error {
+	res, err := execLoad(ctx, cfg)
+	timingFromContext(ctx).addLoad(cfg, res)
+	return err
+}
+
+// execLoad は load.RunWithResult を実行し、途中で中断された場合は中断理由をエラーとして返す。
+// 完了したジョブと中断されたジョブをレスポンス上で区別できるようにするため。
+// 返すエラーには apperrors のカテゴリ(injected / canceled / internal)を付ける
+func execLoad(ctx context.Context, cfg load.Config) (load.RunResult, error) {
+	res, err := load.RunWithResult(ctx, cfg)
+	if res.Reason != "" {
+		// 一時停止していた時間は cno_app_work_paused で見えるため、目標との比較を崩さないよう実行時間からは除く
+		observability.ObserveWork(string(cfg.Mode), cfg.Duration, cfg.Latency, res.Elapsed-res.Paused)
+	}
+	if errors.Is(err, load.ErrWatchdogKilled) {
+		observability.CNOAppWatchdogKillsTotal.WithLabelValues(string(cfg.Mode)).Inc()
+		return res, &apperrors.Error{
+			Category: apperrors.Internal,
+			Reason:   string(load.StopWatchdogKilled),
+			Code:     codes.Aborted,
+			Err:      fmt.Errorf("%w after %s (duration %s)", err, res.Elapsed.Round(time.Millisecond), cfg.Duration),
+		}
+	}
+	if errors.Is(err, load.ErrInjectedMidRun) {
+		return res, apperrors.WithReason(apperrors.Injected, "fail_after", err)
+	}
+	if errors.Is(err, load.ErrInjected) {
+		return res, apperrors.New(apperrors.Injected, err)
+	}
+	if err != nil {
+		return res, apperrors.New(apperrors.Internal, err)
+	}
+	if res.Interrupted() && killed(ctx) {
+		return res, &apperrors.Error{
+			Category: apperrors.Canceled,
+			Reason:   "killed",
+			Code:     codes.Aborted,
+			Err:      fmt.Errorf("interrupted: %w after %s", ErrKilled, res.Elapsed.Round(time.Millisecond)),
+		}
+	}
+	if res.Interrupted() {
+		e := &apperrors.Error{
+			Category: apperrors.Canceled,
+			Reason:   string(res.Reason),
+			Err:      fmt.Errorf("interrupted: %s after %s", res.Reason, res.Elapsed.Round(time.Millisecond)),
+		}
+		if res.Reason == load.StopDeadlineExceeded {
+			e.Code = codes.DeadlineExceeded
+		}
+		return res, e
+	}
+	return res, nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+func TestInstancesFromContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{name: "unset defaults to 1", value: "", want: 1},
+		{name: "valid", value: "4", want: 4},
+		{name: "zero is invalid", value: "0", wantErr: true},
+		{name: "not a number", value: "abc", wantErr: true},
+		{name: "exceeds max", value: "1000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.value != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(InstancesMetadataKey, tt.value))
+			}
+
+			got, err := instancesFromContext(ctx)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// 全インスタンスがエラーになった場合、インスタンスごとのエラーがまとめて返ることを確認
+func TestRunInstances_AggregatesErrors(t *testing.T) {
+	cfg := load.Config{
+		Mode:        load.ModeCPU,
+		Duration:    50 * time.Millisecond,
+		Parallelism: 1,
+		ErrorRate:   1.0,
+	}
+
+	err := runInstances(context.Background(), cfg, 3)
+	if !errors.Is(err, load.ErrInjected) {
+		t.Fatalf("expected ErrInjected, got %v", err)
+	}
+	if got := strings.Count(err.Error(), "instance "); got != 3 {
+		t.Fatalf("expected 3 instance errors, got %d: %v", got, err)
+	}
+}
+
+// 並列実行ではインスタンスごとに負荷の実行時間が記録される(objective は意図した時間の区分)
+func TestRunInstances_ObservesWorkPerInstance(t *testing.T) {
+	reg, err := observability.NewTestRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := load.Config{Mode: load.ModeCPU, Duration: 20 * time.Millisecond, Parallelism: 1}
+
+	if err := runInstances(context.Background(), cfg, 3); err != nil {
+		t.Fatalf("runInstances: %v", err)
+	}
+
+	got, ok, err := reg.Value("cno_app_work_duration_seconds", prometheus.Labels{"mode": "cpu", "objective": "lt_100ms"})
+	if err != nil || !ok || got != 3 {
+		series, _ := reg.Series("cno_app_work_duration_seconds")
+		t.Fatalf("work duration observations = %v (ok=%v, err=%v), want 3; series %v", got, ok, err, series)
+	}
+}
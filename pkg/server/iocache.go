@@ -0,0 +1,20 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+// IOCacheMetadataKey は io モードでページキャッシュに当てる("warm")か外す("cold")かを指定する metadata キー。
+// キャッシュが効く I/O と効かない I/O の違い(レイテンシ、デバイスの読み込み量)を見せるために使う。
+// proto に io_cache フィールドが追加されるまでは metadata で受け渡す。値の検証は load.Validate で行う
+const IOCacheMetadataKey = "x-io-cache"
+
+// ioCacheFromContext は incoming metadata から io モードのキャッシュの使い方を取得する。未指定なら空(warm)
+func ioCacheFromContext(ctx context.Context) load.IOCache {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return load.IOCache(firstMetadata(md, IOCacheMetadataKey))
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+)
+
+// x-io-cache を load.Config.IOCache に渡し、不正な値は invalid_config で拒否することを確認
+func TestCheckConfig_IOCache(t *testing.T) {
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar())
+	pc := &grpcburnerv1.WorkConfig{Mode: grpcburnerv1.LoadMode_LOAD_MODE_IO, DurationMs: 10, IoBytes: 4096}
+	withCache := func(v string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(IOCacheMetadataKey, v))
+	}
+
+	cfg, err := s.checkConfig(withCache("warm"), "req-1", pc)
+	if err != nil || cfg.IOCache != load.IOCacheWarm {
+		t.Fatalf("warm: got (%q, %v)", cfg.IOCache, err)
+	}
+	if cfg, err := s.checkConfig(context.Background(), "req-2", pc); err != nil || cfg.IOCache != "" {
+		t.Fatalf("unset: got (%q, %v)", cfg.IOCache, err)
+	}
+	if _, err := s.checkConfig(withCache("hot"), "req-3", pc); rejectReason(err) != rejectInvalidConfig {
+		t.Fatalf("hot: expected invalid_config, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// ErrKilled は admin の kill-switch で負荷実行が打ち切られたことを表す context の cause
+var ErrKilled = errors.New("work killed by admin kill-switch")
+
+// WorkRegistry は負荷を実行中の RPC ごとのキャンセル関数と一時停止のゲートを保持する。
+// ワークショップで誤って破壊的な量の負荷を投げた時に KillAll で一括停止したり、
+// Pause / Resume で負荷を(確保したメモリを持ったまま)止めて再開したりするために使う
+type WorkRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	jobs   map[uint64]*workEntry
+}
+
+// workEntry は登録された負荷 1 件
+type workEntry struct {
+	job    WorkJob
+	cancel context.CancelCauseFunc
+	gate   *load.PauseGate
+}
+
+// WorkJob は実行中の負荷 1 件の情報。ID は Pause / Resume の対象の指定に使う
+type WorkJob struct {
+	ID        uint64    `json:"id"`
+	RequestID string    `json:"request_id,omitempty"`
+	RunID     string    `json:"run_id,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Paused    bool      `json:"paused"`
+	// PausedMs はこれまでに一時停止していた時間の合計(一時停止中なら今回の分も含む)
+	PausedMs int64 `json:"paused_ms"`
+}
+
+// WorkSelector は Pause / Resume の対象を選ぶ。指定したフィールドが全て一致する負荷が対象で、全て空なら実行中の全ての負荷
+type WorkSelector struct {
+	ID        uint64
+	RequestID string
+	RunID     string
+}
+
+func (sel WorkSelector) matches(j WorkJob) bool {
+	return (sel.ID == 0 || sel.ID == j.ID) &&
+		(sel.RequestID == "" || sel.RequestID == j.RequestID) &&
+		(sel.RunID == "" || sel.RunID == j.RunID)
+}
+
+// NewWorkRegistry は空の WorkRegistry を返す
+func NewWorkRegistry() *WorkRegistry {
+	return &WorkRegistry{jobs: make(map[uint64]*workEntry)}
+}
+
+// WithWorkRegistry は kill-switch から参照する WorkRegistry を指定する。未指定ならサーバーごとに作る
+func WithWorkRegistry(r *WorkRegistry) Option {
+	return func(s *GrpcBurnerServer) {
+		s.work = r
+	}
+}
+
+// track は ctx を kill-switch でキャンセルでき、Pause で一時停止できる context で包んで登録する。RPC の終了時に done を呼ぶ。
+// request_id / run_id は metadata(x-request-id / x-run-id)から取る
+func (r *WorkRegistry) track(ctx context.Context) (_ context.Context, done func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	e := &workEntry{cancel: cancel, gate: load.NewPauseGate()}
+	e.job.StartedAt = time.Now()
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("x-request-id"); len(vals) > 0 {
+			e.job.RequestID = vals[0]
+		}
+	}
+	e.job.RunID, _ = observability.ClientInfo(ctx)
+
+	r.mu.Lock()
+	r.nextID++
+	e.job.ID = r.nextID
+	r.jobs[e.job.ID] = e
+	r.mu.Unlock()
+
+	return load.WithPause(ctx, e.gate), func() {
+		r.mu.Lock()
+		delete(r.jobs, e.job.ID)
+		// 一時停止したままクライアントが切断した、kill-switch で打ち切ったなどで終わった分
+		if e.gate.Paused() {
+			observability.CNOAppWorkPaused.Dec()
+		}
+		r.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// KillAll は実行中の全ての負荷を ErrKilled でキャンセルし、対象になった RPC の数を返す。
+// 実行中の load.Run は即座に終了し(一時停止中も含む)、ストリームの残りの repeat や後続メッセージも実行されずに打ち切られる
+func (r *WorkRegistry) KillAll() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.jobs {
+		e.cancel(ErrKilled)
+	}
+	return len(r.jobs)
+}
+
+// Pause は sel に一致する負荷を一時停止し、対象になった負荷と新たに一時停止した数を返す。
+// 一時停止中のワーカーは確保したメモリや一時ファイルを持ったまま止まり、止まっている間は負荷の duration に数えない。
+// ストリームでは実行中のメッセージ(repeat)の負荷が止まり、次のメッセージは再開するまで始まらない
+func (r *WorkRegistry) Pause(sel WorkSelector) (jobs []WorkJob, changed int) {
+	return r.setPaused(sel, true)
+}
+
+// Resume は sel に一致する負荷を再開し、対象になった負荷と新たに再開した数を返す
+func (r *WorkRegistry) Resume(sel WorkSelector) (jobs []WorkJob, changed int) {
+	return r.setPaused(sel, false)
+}
+
+func (r *WorkRegistry) setPaused(sel WorkSelector, paused bool) (jobs []WorkJob, changed int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.jobs {
+		if !sel.matches(e.job) {
+			continue
+		}
+		switch {
+		case paused && e.gate.Pause():
+			observability.CNOAppWorkPaused.Inc()
+			changed++
+		case !paused && e.gate.Resume():
+			observability.CNOAppWorkPaused.Dec()
+			changed++
+		}
+		jobs = append(jobs, e.snapshot())
+	}
+	sortJobs(jobs)
+	return jobs, changed
+}
+
+// Jobs は実行中の負荷を開始が古い順に返す
+func (r *WorkRegistry) Jobs() []WorkJob {
+	r.mu.Lock()
+	jobs := make([]WorkJob, 0, len(r.jobs))
+	for _, e := range r.jobs {
+		jobs = append(jobs, e.snapshot())
+	}
+	r.mu.Unlock()
+	sortJobs(jobs)
+	return jobs
+}
+
+func (e *workEntry) snapshot() WorkJob {
+	j := e.job
+	j.Paused = e.gate.Paused()
+	j.PausedMs = e.gate.PausedFor().Milliseconds()
+	return j
+}
+
+func sortJobs(jobs []WorkJob) {
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+}
+
+// Active は負荷を実行中の RPC の数を返す
+func (r *WorkRegistry) Active() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.jobs)
+}
+
+// killed は ctx が kill-switch でキャンセルされたかどうかを返す
+func killed(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrKilled)
+}
+
+// killedError は kill-switch で打ち切ったストリームを終了させる時のエラー(canceled / ABORTED)
+func killedError() error {
+	return &apperrors.Error{Category: apperrors.Canceled, Reason: "killed", Code: codes.Aborted, Err: ErrKilled}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// kill-switch で実行中の Unary は中断理由付きで失敗し、サーバーストリーミングは残りの repeat を実行せずに Aborted で終わる
+func TestWorkRegistry_KillAll(t *testing.T) {
+	work := NewWorkRegistry()
+	client, handlerErrs := startBurner(t, WithWorkRegistry(work))
+
+	unaryDone := make(chan *grpcburnerv1.DoWorkResponse, 1)
+	go func() {
+		resp, _ := client.DoWork(context.Background(), &grpcburnerv1.DoWorkRequest{RequestId: "unary", Config: cpuConfig(10 * time.Second)})
+		unaryDone <- resp
+	}()
+	stream, err := client.DoWorkServerStreaming(context.Background(), &grpcburnerv1.DoWorkServerStreamingRequest{
+		RequestId: "stream",
+		Repeat:    100,
+		Config:    cpuConfig(20 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatalf("DoWorkServerStreaming: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("first Recv: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for work.Active() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("active = %d, want 2", work.Active())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := work.KillAll(); n != 2 {
+		t.Fatalf("KillAll = %d, want 2", n)
+	}
+
+	if err := waitHandlerErr(t, handlerErrs); status.Code(err) != codes.Aborted {
+		t.Fatalf("stream handler error = %v, want Aborted", err)
+	}
+	select {
+	case resp := <-unaryDone:
+		if resp.GetOk() || !strings.Contains(resp.GetErrorMessage(), ErrKilled.Error()) {
+			t.Fatalf("unary response = %+v, want killed", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("unary DoWork did not return after KillAll")
+	}
+	if n := work.Active(); n != 0 {
+		t.Fatalf("active after kill = %d, want 0", n)
+	}
+}
+
+// request_id を指定した Pause はその負荷だけを止め、止めている間は duration を過ぎても返らず、Resume 後に完了する
+func TestWorkRegistry_PauseResume(t *testing.T) {
+	work := NewWorkRegistry()
+	client, _ := startBurner(t, WithWorkRegistry(work))
+
+	done := map[string]chan *grpcburnerv1.DoWorkResponse{}
+	for _, id := range []string{"paused", "running"} {
+		done[id] = make(chan *grpcburnerv1.DoWorkResponse, 1)
+		go func() {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", id)
+			resp, _ := client.DoWork(ctx, &grpcburnerv1.DoWorkRequest{RequestId: id, Config: cpuConfig(300 * time.Millisecond)})
+			done[id] <- resp
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for work.Active() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("active = %d, want 2", work.Active())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	jobs, changed := work.Pause(WorkSelector{RequestID: "paused"})
+	if len(jobs) != 1 || changed != 1 || !jobs[0].Paused || jobs[0].RequestID != "paused" {
+		t.Fatalf("Pause = %+v, %d, want the paused job only", jobs, changed)
+	}
+	if _, changed := work.Pause(WorkSelector{ID: jobs[0].ID}); changed != 0 {
+		t.Fatalf("second Pause changed = %d, want 0", changed)
+	}
+
+	select {
+	case resp := <-done["running"]:
+		if !resp.GetOk() {
+			t.Fatalf("running job = %+v, want ok", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job that was not paused did not finish")
+	}
+	select {
+	case resp := <-done["paused"]:
+		t.Fatalf("paused job finished: %+v", resp)
+	case <-time.After(500 * time.Millisecond):
+	}
+	if got := work.Jobs(); len(got) != 1 || !got[0].Paused || got[0].PausedMs < 500 {
+		t.Fatalf("Jobs = %+v, want one paused job", got)
+	}
+
+	if _, changed := work.Resume(WorkSelector{}); changed != 1 {
+		t.Fatalf("Resume changed = %d, want 1", changed)
+	}
+	select {
+	case resp := <-done["paused"]:
+		if !resp.GetOk() {
+			t.Fatalf("resumed job = %+v, want ok", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("paused job did not finish after Resume")
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+// ReloadableLimitProfiles は実行中に差し替えられるモード別の負荷上限(SIGHUP による設定の再読み込み用)
+type ReloadableLimitProfiles struct {
+	v atomic.Pointer[load.LimitProfiles]
+}
+
+// NewReloadableLimitProfiles は p を初期値とする ReloadableLimitProfiles を返す
+func NewReloadableLimitProfiles(p load.LimitProfiles) *ReloadableLimitProfiles {
+	r := &ReloadableLimitProfiles{}
+	r.Store(p)
+	return r
+}
+
+// Load は現在の上限を返す
+func (r *ReloadableLimitProfiles) Load() load.LimitProfiles {
+	return *r.v.Load()
+}
+
+// Store は上限を差し替える。実行中の負荷には適用されず、次に検証するリクエストから使われる
+func (r *ReloadableLimitProfiles) Store(p load.LimitProfiles) {
+	r.v.Store(&p)
+}
+
+// WithLimitProfiles は WorkConfig の検証に r の現在のモード別上限を使う。指定しなければ load の既定の上限
+func WithLimitProfiles(r *ReloadableLimitProfiles) Option {
+	return func(s *GrpcBurnerServer) {
+		s.limits = r
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+// LoadModeMetadataKey は proto の LoadMode にない負荷のモードを指定する metadata キー(例: "page-fault")。
+// proto に対応する LoadMode が追加されるまでは metadata で受け渡す。
+// WorkConfig の mode はこのキーを知らないサーバーでも近い負荷になるよう、代わりのモード(page-fault なら LOAD_MODE_MEM)を指定する
+const LoadModeMetadataKey = "x-load-mode"
+
+// extraLoadModes は x-load-mode で指定できるモードと、WorkConfig で代わりに指定するモード
+var extraLoadModes = map[load.Mode]load.Mode{
+	load.ModePageFault: load.ModeMem,
+}
+
+// loadModeFromContext は incoming metadata の x-load-mode で proto のモード(mode)を差し替える。未指定なら mode のまま
+func loadModeFromContext(ctx context.Context, mode load.Mode) (load.Mode, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := firstMetadata(md, LoadModeMetadataKey)
+	if v == "" {
+		return mode, nil
+	}
+	fallback, ok := extraLoadModes[load.Mode(v)]
+	if !ok {
+		return "", fmt.Errorf("invalid %s %q: must be %s", LoadModeMetadataKey, v, load.ModePageFault)
+	}
+	if mode != fallback {
+		return "", fmt.Errorf("%s %q requires config mode %s, got %s", LoadModeMetadataKey, v, fallback, mode)
+	}
+	return load.Mode(v), nil
+}
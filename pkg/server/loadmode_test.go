@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+func TestLoadModeFromContext(t *testing.T) {
+	withMode := func(v string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(LoadModeMetadataKey, v))
+	}
+
+	if got, err := loadModeFromContext(context.Background(), load.ModeCPU); err != nil || got != load.ModeCPU {
+		t.Fatalf("unset: got (%s, %v), want cpu", got, err)
+	}
+	if got, err := loadModeFromContext(withMode("page-fault"), load.ModeMem); err != nil || got != load.ModePageFault {
+		t.Fatalf("page-fault: got (%s, %v), want page-fault", got, err)
+	}
+	// 代わりのモードが mem でなければ、古いサーバーとの挙動が食い違うため拒否する
+	if _, err := loadModeFromContext(withMode("page-fault"), load.ModeCPU); err == nil {
+		t.Fatalf("expected error for page-fault with cpu config")
+	}
+	if _, err := loadModeFromContext(withMode("swap"), load.ModeMem); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// ShadowMetadataKey はミラーした DoWork に付ける metadata キー。値は shadow で実行する負荷の割合(0 より大きく 1 以下)で、
+// 受け取ったサーバーは duration / alloc_mb / io_bytes をその割合に縮めて実行し、さらにミラーはしない
+const ShadowMetadataKey = "x-shadow-work-fraction"
+
+// ミラーの結果(cno_app_mirror_requests_total の result)
+const (
+	mirrorResultSuccess = "success" // shadow が応答した(ok=false を含む)
+	mirrorResultError   = "error"   // shadow への呼び出しが失敗した
+	mirrorResultDropped = "dropped" // 同時に送れる上限に達していたため送らなかった
+)
+
+// Mirror は DoWork の一部を shadow サーバーへ非同期に複製する(traffic shadowing)設定
+type Mirror struct {
+	// Addr は shadow サーバーの gRPC アドレス。空なら無効
+	Addr string
+	// Percent はミラーするリクエストの割合(0~100)
+	Percent float64
+	// WorkFraction は shadow で実行させる負荷の割合(ShadowMetadataKey の値)
+	WorkFraction float64
+	// MaxInFlight は同時に shadow へ送る呼び出しの上限。超えた分は送らずに dropped として数える
+	MaxInFlight int
+	// Timeout は shadow への 1 回の呼び出しのタイムアウト
+	Timeout time.Duration
+}
+
+// Enabled はミラーが有効かどうかを返す
+func (m Mirror) Enabled() bool {
+	return m.Addr != "" && m.Percent > 0
+}
+
+// Validate は設定値の範囲を検証する
+func (m Mirror) Validate() error {
+	if math.IsNaN(m.Percent) || m.Percent < 0 || m.Percent > 100 {
+		return errors.New("mirror percent must be between 0 and 100")
+	}
+	if err := checkShadowFraction(m.WorkFraction); err != nil {
+		return err
+	}
+	if m.MaxInFlight <= 0 {
+		return errors.New("mirror max in-flight must be > 0")
+	}
+	if m.Timeout <= 0 {
+		return errors.New("mirror timeout must be > 0")
+	}
+	return nil
+}
+
+func checkShadowFraction(f float64) error {
+	if math.IsNaN(f) || f <= 0 || f > 1 {
+		return fmt.Errorf("shadow work fraction must be > 0 and <= 1, got %v", f)
+	}
+	return nil
+}
+
+// mirrorForwardKeys はミラーした呼び出しにそのまま引き継ぐ metadata キー。
+// 負荷の内容を変えるものと、shadow 側のログで元の実行を辿るための run_id / mode に限る
+// (x-elevation-token のような 1 回だけ使える値や、trace context は引き継がない)
+var mirrorForwardKeys = []string{
+	observability.RunIDMetadataKey,
+	observability.ClientModeMetadataKey,
+	LoadModeMetadataKey,
+	InstancesMetadataKey,
+	FailAfterMetadataKey,
+	IOCacheMetadataKey,
+}
+
+// MirrorClient は Mirror の設定で shadow サーバーへ DoWork を複製する
+type MirrorClient struct {
+	cfg    Mirror
+	conn   *grpc.ClientConn
+	client grpcburnerv1.BurnerClient
+	logger *zap.SugaredLogger
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewMirrorClient は cfg.Addr への接続を作る。接続は最初の呼び出しまで確立しない
+func NewMirrorClient(cfg Mirror, logger *zap.SugaredLogger) (*MirrorClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(cfg.Addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("mirror: dial %s: %w", cfg.Addr, err)
+	}
+	return &MirrorClient{
+		cfg:    cfg,
+		conn:   conn,
+		client: grpcburnerv1.NewBurnerClient(conn),
+		logger: logger,
+		sem:    make(chan struct{}, cfg.MaxInFlight),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// WithMirror は DoWork(Unary)の一部を m の shadow サーバーへ非同期に複製する。nil なら複製しない
+func WithMirror(m *MirrorClient) Option {
+	return func(s *GrpcBurnerServer) {
+		s.mirror = m
+	}
+}
+
+// Close は送信中のミラーの完了を ctx の期限まで待ち、接続を閉じる
+func (m *MirrorClient) Close(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	_ = m.conn.Close()
+}
+
+func (m *MirrorClient) sampled() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rand.Float64()*100 < m.cfg.Percent
+}
+
+// send は req を shadow へ非同期に送る。元のリクエストの応答は待たせず、shadow の遅延やエラーも返さない。
+// shadow への呼び出しは元のトレースに含めず(元の RPC の所要時間に見えないよう)別のトレースとし、元の span へリンクする
+func (m *MirrorClient) send(ctx context.Context, req *grpcburnerv1.DoWorkRequest) {
+	if !m.sampled() {
+		return
+	}
+	select {
+	case m.sem <- struct{}{}:
+	default:
+		observability.CNOAppMirrorRequestsTotal.WithLabelValues(mirrorResultDropped).Inc()
+		return
+	}
+
+	in, _ := metadata.FromIncomingContext(ctx)
+	out := metadata.Pairs(ShadowMetadataKey, strconv.FormatFloat(m.cfg.WorkFraction, 'f', -1, 64))
+	for _, k := range mirrorForwardKeys {
+		if v := in.Get(k); len(v) > 0 {
+			out.Set(k, v...)
+		}
+	}
+	link := trace.LinkFromContext(ctx)
+	req = proto.Clone(req).(*grpcburnerv1.DoWorkRequest)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() { <-m.sem }()
+
+		mctx, cancel := context.WithTimeout(context.Background(), m.cfg.Timeout)
+		defer cancel()
+		mctx, span := otel.Tracer("cno-app-server").Start(mctx, "grpc.server/Mirror",
+			trace.WithNewRoot(),
+			trace.WithLinks(link),
+			trace.WithAttributes(
+				attribute.String("mirror.addr", m.cfg.Addr),
+				attribute.Float64("mirror.work_fraction", m.cfg.WorkFraction),
+				attribute.String("request_id", req.GetRequestId()),
+			))
+		defer span.End()
+
+		start := time.Now()
+		resp, err := m.client.DoWork(metadata.NewOutgoingContext(mctx, out), req)
+		elapsed := time.Since(start)
+		observability.RecordSpanResult(span, err, elapsed)
+		observability.CNOAppMirrorLatency.Observe(elapsed.Seconds())
+		if err != nil {
+			observability.CNOAppMirrorRequestsTotal.WithLabelValues(mirrorResultError).Inc()
+			if m.logger != nil {
+				m.logger.Warnw("dowork mirror failed",
+					"request_id", req.GetRequestId(),
+					"mirror_addr", m.cfg.Addr,
+					"trace_id", span.SpanContext().TraceID().String(),
+					"latency_ms", elapsed.Milliseconds(),
+					"error", err,
+				)
+			}
+			return
+		}
+		observability.CNOAppMirrorRequestsTotal.WithLabelValues(mirrorResultSuccess).Inc()
+		span.SetAttributes(attribute.Bool("mirror.ok", resp.GetOk()))
+	}()
+}
+
+// isShadow はミラーされたリクエストかどうかを返す。shadow はさらにミラーしない
+func isShadow(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return firstMetadata(md, ShadowMetadataKey) != ""
+}
+
+// shadowFractionFromContext は incoming metadata の ShadowMetadataKey を読む。ミラーされたリクエストでなければ 0
+func shadowFractionFromContext(ctx context.Context) (float64, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := firstMetadata(md, ShadowMetadataKey)
+	if v == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", ShadowMetadataKey, v, err)
+	}
+	if err := checkShadowFraction(f); err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", ShadowMetadataKey, err)
+	}
+	return f, nil
+}
+
+// scaleShadowWork はミラーされたリクエストの負荷を fraction の割合に縮める。
+// 縮めた結果が 0 にならないよう、duration は 1ms、alloc_mb / io_bytes は 1 を下限にする。
+// x-fail-after-ms は duration 未満でなければならないため、duration と同じ割合で縮める
+func scaleShadowWork(cfg *load.Config, fraction float64) {
+	if cfg.Duration > 0 {
+		cfg.Duration = max(time.Millisecond, time.Duration(float64(cfg.Duration)*fraction))
+	}
+	if cfg.FailAfter > 0 {
+		cfg.FailAfter = min(cfg.Duration-1, time.Duration(float64(cfg.FailAfter)*fraction))
+	}
+	if cfg.AllocMB > 0 {
+		cfg.AllocMB = max(1, int(float64(cfg.AllocMB)*fraction))
+	}
+	if cfg.IOBytes > 0 {
+		cfg.IOBytes = max(1, int(float64(cfg.IOBytes)*fraction))
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// ミラーされたリクエストは x-shadow-work-fraction の割合に負荷を縮めて実行することを確認
+func TestCheckConfig_ScalesShadowWork(t *testing.T) {
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar())
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		ShadowMetadataKey, "0.1",
+		FailAfterMetadataKey, "500",
+	))
+	cfg, err := s.checkConfig(ctx, "req-1", &grpcburnerv1.WorkConfig{
+		Mode:       grpcburnerv1.LoadMode_LOAD_MODE_MEM,
+		DurationMs: 1000,
+		AllocMb:    100,
+	})
+	if err != nil {
+		t.Fatalf("checkConfig: %v", err)
+	}
+	if cfg.Duration != 100*time.Millisecond || cfg.AllocMB != 10 || cfg.FailAfter != 50*time.Millisecond {
+		t.Fatalf("scaled config = duration %s alloc %d fail_after %s, want 100ms / 10 / 50ms", cfg.Duration, cfg.AllocMB, cfg.FailAfter)
+	}
+
+	for _, v := range []string{"0", "1.5", "half"} {
+		bad := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ShadowMetadataKey, v))
+		if _, err := s.checkConfig(bad, "req-2", &grpcburnerv1.WorkConfig{
+			Mode: grpcburnerv1.LoadMode_LOAD_MODE_CPU, DurationMs: 100, Parallelism: 1,
+		}); rejectReason(err) != rejectInvalidConfig {
+			t.Fatalf("%s=%q: err = %v, want %s", ShadowMetadataKey, v, err, rejectInvalidConfig)
+		}
+	}
+}
+
+// shadowBurner は受け取った DoWork の metadata を記録する shadow サーバー
+type shadowBurner struct {
+	grpcburnerv1.UnimplementedBurnerServer
+	got chan metadata.MD
+}
+
+func (b *shadowBurner) DoWork(ctx context.Context, req *grpcburnerv1.DoWorkRequest) (*grpcburnerv1.DoWorkResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	b.got <- md
+	return &grpcburnerv1.DoWorkResponse{RequestId: req.GetRequestId(), Ok: true}, nil
+}
+
+func TestMirror_SendsShadowRequest(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shadow := &shadowBurner{got: make(chan metadata.MD, 1)}
+	srv := grpc.NewServer()
+	grpcburnerv1.RegisterBurnerServer(srv, shadow)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+
+	mc, err := NewMirrorClient(Mirror{Addr: lis.Addr().String(), Percent: 100, WorkFraction: 0.25, MaxInFlight: 4, Timeout: 5 * time.Second}, nil)
+	if err != nil {
+		t.Fatalf("NewMirrorClient: %v", err)
+	}
+	s := NewGrpcBurnerServer(nil, WithMirror(mc))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		observability.RunIDMetadataKey, "run-1",
+		ElevationMetadataKey, "single-use",
+	))
+	resp, err := s.DoWork(ctx, &grpcburnerv1.DoWorkRequest{
+		RequestId: "req-1",
+		Config:    &grpcburnerv1.WorkConfig{Mode: grpcburnerv1.LoadMode_LOAD_MODE_CPU, DurationMs: 10, Parallelism: 1},
+	})
+	if err != nil || !resp.GetOk() {
+		t.Fatalf("DoWork = (%v, %v), want ok", resp, err)
+	}
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mc.Close(closeCtx)
+
+	select {
+	case md := <-shadow.got:
+		if got := md.Get(ShadowMetadataKey); len(got) != 1 || got[0] != "0.25" {
+			t.Fatalf("%s = %v, want [0.25]", ShadowMetadataKey, got)
+		}
+		if got := md.Get(observability.RunIDMetadataKey); len(got) != 1 || got[0] != "run-1" {
+			t.Fatalf("%s = %v, want [run-1]", observability.RunIDMetadataKey, got)
+		}
+		if got := md.Get(ElevationMetadataKey); len(got) != 0 {
+			t.Fatalf("%s was forwarded to the shadow: %v", ElevationMetadataKey, got)
+		}
+	default:
+		t.Fatal("shadow server did not receive the mirrored request")
+	}
+}
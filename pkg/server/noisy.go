@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// MaxNoisyNeighborDelay は noisy neighbor の 1 回の遅延に指定できる上限
+const MaxNoisyNeighborDelay = 10 * time.Second
+
+// NoisyNeighbor は同じノードに同居するワークロードによる CPU steal を模した遅延注入の設定。
+// コードを変えていないのに時々遅くなる、という障害訓練を再現するために使う
+type NoisyNeighbor struct {
+	Rate      float64       // 遅延を入れるリクエストの割合(0.0~1.0)
+	MaxDelay  time.Duration // 1 回の遅延の上限。実際の遅延は MaxDelay/2~MaxDelay の一様分布
+	PerSecond float64       // 遅延を入れる回数の上限(トークンバケットの毎秒の補充量)。0 なら無制限
+}
+
+// Enabled は遅延注入が有効かどうかを返す
+func (n NoisyNeighbor) Enabled() bool {
+	return n.Rate > 0 && n.MaxDelay > 0
+}
+
+// Validate は設定値の範囲を検証する
+func (n NoisyNeighbor) Validate() error {
+	if math.IsNaN(n.Rate) || n.Rate < 0 || n.Rate > 1 {
+		return errors.New("noisy neighbor rate must be between 0.0 and 1.0")
+	}
+	if n.MaxDelay < 0 || n.MaxDelay > MaxNoisyNeighborDelay {
+		return fmt.Errorf("noisy neighbor max delay must be between 0 and %s", MaxNoisyNeighborDelay)
+	}
+	if n.PerSecond < 0 {
+		return errors.New("noisy neighbor per-second limit must be >= 0")
+	}
+	return nil
+}
+
+// WithNoisyNeighbor は n に従ってリクエストにランダムな遅延を入れる
+func WithNoisyNeighbor(n NoisyNeighbor) Option {
+	return func(s *GrpcBurnerServer) {
+		if n.Enabled() {
+			s.noisy = newNoisyNeighbor(n)
+		}
+	}
+}
+
+// noisyNeighbor は確率とトークンバケットで遅延を入れるかどうかを決める
+type noisyNeighbor struct {
+	cfg NoisyNeighbor
+
+	mu     sync.Mutex
+	rand   *rand.Rand
+	tokens float64
+	last   time.Time
+}
+
+func newNoisyNeighbor(cfg NoisyNeighbor) *noisyNeighbor {
+	return &noisyNeighbor{
+		cfg:    cfg,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		tokens: burst(cfg.PerSecond),
+		last:   time.Now(),
+	}
+}
+
+func burst(perSecond float64) float64 {
+	return max(1, perSecond)
+}
+
+// next は今回のリクエストに入れる遅延を返す。遅延を入れない場合は 0
+func (n *noisyNeighbor) next(now time.Time) time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.rand.Float64() >= n.cfg.Rate {
+		return 0
+	}
+	if n.cfg.PerSecond > 0 {
+		n.tokens = min(burst(n.cfg.PerSecond), n.tokens+now.Sub(n.last).Seconds()*n.cfg.PerSecond)
+		n.last = now
+		if n.tokens < 1 {
+			return 0
+		}
+		n.tokens--
+	}
+	half := n.cfg.MaxDelay / 2
+	return half + time.Duration(n.rand.Int63n(int64(n.cfg.MaxDelay-half)+1))
+}
+
+// stealCPU は noisy neighbor が有効なら確率的に遅延を入れ、span / メトリクス / server-timing に記録する。
+// 遅延中に ctx が終わった場合はそこで打ち切る
+func (s *GrpcBurnerServer) stealCPU(ctx context.Context) {
+	if s.noisy == nil {
+		return
+	}
+	d := s.noisy.next(time.Now())
+	if d <= 0 {
+		return
+	}
+
+	start := time.Now()
+	t := time.NewTimer(d)
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		t.Stop()
+	}
+	stolen := time.Since(start)
+
+	endpoint, _ := grpc.Method(ctx)
+	observability.CNOAppNoisyNeighborDelay.WithLabelValues(endpoint).Observe(stolen.Seconds())
+	timingFromContext(ctx).add(TimingSteal, stolen)
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Bool("noisy_neighbor", true))
+		span.AddEvent("noisy neighbor cpu steal", trace.WithAttributes(
+			attribute.Int64("noisy_neighbor.delay_ms", stolen.Milliseconds()),
+		))
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// rate=1 でも PerSecond のトークンを使い切ると遅延は入らず、時間経過で補充される
+func TestNoisyNeighbor_TokenBucketLimitsInjections(t *testing.T) {
+	n := newNoisyNeighbor(NoisyNeighbor{Rate: 1, MaxDelay: 100 * time.Millisecond, PerSecond: 2})
+	now := n.last
+
+	for i := 0; i < 2; i++ {
+		d := n.next(now)
+		if d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Fatalf("delay #%d = %s, want between 50ms and 100ms", i, d)
+		}
+	}
+	if d := n.next(now); d != 0 {
+		t.Fatalf("delay after bucket is empty = %s, want 0", d)
+	}
+	if d := n.next(now.Add(500 * time.Millisecond)); d == 0 {
+		t.Fatal("no delay after refill, want one")
+	}
+}
+
+func TestNoisyNeighbor_Validate(t *testing.T) {
+	for _, n := range []NoisyNeighbor{
+		{Rate: -0.1},
+		{Rate: 1.1},
+		{Rate: math.NaN()},
+		{Rate: 0.5, MaxDelay: time.Minute},
+		{Rate: 0.5, PerSecond: -1},
+	} {
+		if err := n.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, want error", n)
+		}
+	}
+	if err := (NoisyNeighbor{Rate: 0.1, MaxDelay: time.Second, PerSecond: 5}).Validate(); err != nil {
+		t.Errorf("Validate(valid) = %v", err)
+	}
+}
+
+// methodStream は grpc.Method(ctx) が返す RPC 名だけを持つ grpc.ServerTransportStream
+type methodStream struct {
+	grpc.ServerTransportStream
+	method string
+}
+
+func (s methodStream) Method() string { return s.method }
+
+// 遅延を入れた RPC の endpoint ごとに遅延のヒストグラムに記録される
+func TestStealCPU_RecordsDelayPerEndpoint(t *testing.T) {
+	reg, err := observability.NewTestRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar(), WithNoisyNeighbor(NoisyNeighbor{Rate: 1, MaxDelay: 2 * time.Millisecond}))
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), methodStream{method: "/cno.Burner/Ping"})
+
+	s.stealCPU(ctx)
+	s.stealCPU(ctx)
+
+	got, ok, err := reg.Value("cno_app_noisy_neighbor_delay_seconds", prometheus.Labels{"endpoint": "/cno.Burner/Ping"})
+	if err != nil || !ok || got != 2 {
+		t.Fatalf("noisy neighbor observations = %v (ok=%v, err=%v), want 2", got, ok, err)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ResponsePaddingMetadataKey は DoWork 系のレスポンス 1 メッセージを水増しする目標サイズ(バイト)を指定する metadata キー。
+	// proto に response_padding_bytes フィールドが追加されるまでは metadata で受け渡す
+	ResponsePaddingMetadataKey = "x-response-padding-bytes"
+
+	// MaxResponsePadding はレスポンスの目標サイズの上限。gRPC クライアントの既定の最大受信サイズ(4MiB)に合わせる
+	MaxResponsePadding = 4 << 20
+
+	// ResponsePaddingFieldNumber は水増しに使うフィールド番号。
+	// proto に定義のない番号の bytes フィールドとして載せるため、クライアントは unknown field として読み飛ばす
+	ResponsePaddingFieldNumber protowire.Number = 1000
+)
+
+// responsePaddingFromContext は incoming metadata からレスポンスの目標サイズを取得する。未指定なら 0(水増ししない)
+func responsePaddingFromContext(ctx context.Context) (int, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+	vals := md.Get(ResponsePaddingMetadataKey)
+	if len(vals) == 0 || vals[0] == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(vals[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", ResponsePaddingMetadataKey, vals[0], err)
+	}
+	if n < 0 || n > MaxResponsePadding {
+		return 0, fmt.Errorf("%s must be between 0 and %d, got %d", ResponsePaddingMetadataKey, MaxResponsePadding, n)
+	}
+	return n, nil
+}
+
+// PadMessage は msg のシリアライズ後のサイズが target バイトになるよう、unknown field を追加する。
+// 送信量(egress)やメッセージサイズのメトリクスを、新しい RPC を追加せずに実験するため。
+// サーバーのレスポンスのほか、クライアントがリクエストを大きくする(--request-padding-bytes)のにも使う。
+// 長さの varint の桁が変わる境界では 1 バイト超えることがある。既に target 以上ならそのまま返す
+func PadMessage(msg proto.Message, target int) {
+	need := target - proto.Size(msg)
+	tag := protowire.SizeTag(ResponsePaddingFieldNumber)
+	if need <= tag {
+		return
+	}
+	n := max(need-tag-protowire.SizeVarint(uint64(need)), 0)
+	for tag+protowire.SizeBytes(n) < need {
+		n++
+	}
+	b := protowire.AppendTag(nil, ResponsePaddingFieldNumber, protowire.BytesType)
+	b = protowire.AppendBytes(b, make([]byte, n))
+	m := msg.ProtoReflect()
+	m.SetUnknown(append(m.GetUnknown(), b...))
+}
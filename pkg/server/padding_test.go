@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestResponsePaddingFromContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{name: "unset", value: "", want: 0},
+		{name: "valid", value: "1024", want: 1024},
+		{name: "negative", value: "-1", wantErr: true},
+		{name: "not a number", value: "1k", wantErr: true},
+		{name: "exceeds max", value: "100000000", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.value != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(ResponsePaddingMetadataKey, tt.value))
+			}
+			got, err := responsePaddingFromContext(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// varint の桁が変わる境界を含め、目標サイズちょうど(境界では +1 バイトまで)になり、元のフィールドが読めることを確認
+func TestPadMessage(t *testing.T) {
+	for _, target := range []int{0, 10, 64, 130, 131, 132, 200, 16386, 16387, 1 << 20} {
+		resp := &grpcburnerv1.DoWorkResponse{RequestId: "req-1", Ok: true}
+		base := proto.Size(resp)
+		PadMessage(resp, target)
+
+		got := proto.Size(resp)
+		if target-base <= 2 {
+			// unknown field のタグ(2 バイト)すら入らない差なら水増ししない
+			if got != base {
+				t.Fatalf("target %d: size = %d, want unchanged %d", target, got, base)
+			}
+		} else if got != target && got != target+1 {
+			t.Fatalf("target %d: size = %d", target, got)
+		}
+
+		b, err := proto.Marshal(resp)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		var decoded grpcburnerv1.DoWorkResponse
+		if err := proto.Unmarshal(b, &decoded); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if decoded.GetRequestId() != "req-1" || !decoded.GetOk() {
+			t.Fatalf("target %d: decoded = %+v", target, &decoded)
+		}
+	}
+}
+
+func TestDoWork_ResponsePadding(t *testing.T) {
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar())
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ResponsePaddingMetadataKey, "4096"))
+
+	resp, err := s.DoWork(ctx, &grpcburnerv1.DoWorkRequest{
+		RequestId: "req-1",
+		Config: &grpcburnerv1.WorkConfig{
+			Mode:        grpcburnerv1.LoadMode_LOAD_MODE_CPU,
+			DurationMs:  1,
+			Parallelism: 1,
+		},
+	})
+	if err != nil || !resp.GetOk() {
+		t.Fatalf("DoWork: resp = %+v, err = %v", resp, err)
+	}
+	if got := proto.Size(resp); got != 4096 {
+		t.Fatalf("response size = %d, want 4096", got)
+	}
+}
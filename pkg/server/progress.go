@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+)
+
+// BurnerProgress は DoWorkClientStreaming の途中経過を返す版を提供する。
+// Client streaming では最後に 1 回しか集計を返せず、数千メッセージ送った後の集計だけでは途中の失敗が埋もれるため、
+// proto リポジトリに RPC を追加するまでの間、既存の DoWorkRequest / DoWorkSummary を使う手書きの ServiceDesc
+// (双方向ストリーミング)として実装している
+const (
+	// ProgressServiceName は BurnerProgress のサービス名
+	ProgressServiceName = "cno.app.v1.BurnerProgress"
+	// ProgressDoWorkClientStreamingFullMethodName は途中経過を返す DoWorkClientStreaming のフルメソッド名
+	ProgressDoWorkClientStreamingFullMethodName = "/" + ProgressServiceName + "/DoWorkClientStreaming"
+
+	// SummaryEveryMetadataKey は途中経過の集計を返す間隔(受信メッセージ数)を指定する metadata キー
+	SummaryEveryMetadataKey = "x-summary-every"
+	// DefaultSummaryEvery は x-summary-every 未指定時の間隔
+	DefaultSummaryEvery = 100
+)
+
+// ProgressServer は BurnerProgress のサーバー実装が満たすインターフェース
+type ProgressServer interface {
+	DoWorkClientStreamingProgress(grpc.BidiStreamingServer[grpcburnerv1.DoWorkRequest, grpcburnerv1.DoWorkSummary]) error
+}
+
+var _ ProgressServer = (*GrpcBurnerServer)(nil)
+
+// DoWorkClientStreamingProgress は DoWorkClientStreaming と同じく DoWorkRequest を受信して負荷を実行し、
+// x-summary-every メッセージごとにその時点までの累計を DoWorkSummary として返す。
+// クライアントが送信を終えたら最終的な集計を返してストリームを閉じる(最後に受信した集計が最終結果)
+func (s *GrpcBurnerServer) DoWorkClientStreamingProgress(
+	stream grpc.BidiStreamingServer[grpcburnerv1.DoWorkRequest, grpcburnerv1.DoWorkSummary],
+) error {
+	ctx, done := s.work.track(stream.Context())
+	defer done()
+	timingFromContext(ctx).begin()
+
+	padding, err := responsePaddingFromContext(ctx)
+	if err != nil {
+		return apperrors.New(apperrors.Validation, err)
+	}
+	every, err := summaryEveryFromContext(ctx)
+	if err != nil {
+		return apperrors.New(apperrors.Validation, err)
+	}
+
+	send := func(summary *grpcburnerv1.DoWorkSummary) error {
+		PadMessage(summary, padding)
+		return stream.Send(summary)
+	}
+	summary, err := s.consumeWork(ctx, stream.Recv, every, send)
+	if err != nil {
+		return err
+	}
+	return send(summary)
+}
+
+// summaryEveryFromContext は incoming metadata から途中経過を返す間隔を取得する。未指定なら DefaultSummaryEvery
+func summaryEveryFromContext(ctx context.Context) (int, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := firstMetadata(md, SummaryEveryMetadataKey)
+	if v == "" {
+		return DefaultSummaryEvery, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", SummaryEveryMetadataKey, v, err)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("%s must be > 0, got %d", SummaryEveryMetadataKey, n)
+	}
+	return n, nil
+}
+
+// RegisterProgressServer は BurnerProgress を gRPC サーバーに登録する。
+// kill switch や実行中の負荷の追跡を共有するため、Burner と同じ GrpcBurnerServer を渡す
+func RegisterProgressServer(s grpc.ServiceRegistrar, srv ProgressServer) {
+	s.RegisterService(&progressServiceDesc, srv)
+}
+
+var progressServiceDesc = grpc.ServiceDesc{
+	ServiceName: ProgressServiceName,
+	HandlerType: (*ProgressServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "DoWorkClientStreaming",
+			Handler:       progressDoWorkClientStreamingHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+func progressDoWorkClientStreamingHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ProgressServer).DoWorkClientStreamingProgress(
+		&grpc.GenericServerStream[grpcburnerv1.DoWorkRequest, grpcburnerv1.DoWorkSummary]{ServerStream: stream},
+	)
+}
+
+// OpenProgressStream は途中経過を返す DoWorkClientStreaming のストリームを開く
+func OpenProgressStream(
+	ctx context.Context,
+	cc grpc.ClientConnInterface,
+	opts ...grpc.CallOption,
+) (grpc.BidiStreamingClient[grpcburnerv1.DoWorkRequest, grpcburnerv1.DoWorkSummary], error) {
+	stream, err := cc.NewStream(ctx, &progressServiceDesc.Streams[0], ProgressDoWorkClientStreamingFullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpc.GenericClientStream[grpcburnerv1.DoWorkRequest, grpcburnerv1.DoWorkSummary]{ClientStream: stream}, nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// x-summary-every ごとに途中までの累計が届き、最後に全体の集計が届く。
+// 途中の失敗(不正な WorkConfig)は最終集計を待たずに途中経過の failed に現れる
+func TestProgress_InterimSummaries(t *testing.T) {
+	conn, _ := startTestServer(t)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), SummaryEveryMetadataKey, "2")
+	stream, err := OpenProgressStream(ctx, conn)
+	if err != nil {
+		t.Fatalf("OpenProgressStream: %v", err)
+	}
+
+	configs := []*grpcburnerv1.WorkConfig{
+		cpuConfig(time.Millisecond),
+		{}, // mode 未指定で失敗する
+		cpuConfig(time.Millisecond),
+		cpuConfig(time.Millisecond),
+		cpuConfig(time.Millisecond),
+	}
+	for _, c := range configs {
+		if err := stream.Send(&grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: c}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend: %v", err)
+	}
+
+	var got [][2]int32
+	for {
+		s, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		got = append(got, [2]int32{s.GetTotal(), s.GetFailed()})
+	}
+	want := [][2]int32{{2, 1}, {4, 1}, {5, 1}}
+	if len(got) != len(want) {
+		t.Fatalf("summaries (total, failed) = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("summaries (total, failed) = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestProgress_InvalidSummaryEvery(t *testing.T) {
+	conn, _ := startTestServer(t)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), SummaryEveryMetadataKey, "0")
+	stream, err := OpenProgressStream(ctx, conn)
+	if err != nil {
+		t.Fatalf("OpenProgressStream: %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Recv: err = %v, want InvalidArgument", err)
+	}
+}
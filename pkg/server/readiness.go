@@ -0,0 +1,127 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// SubsystemHealthPrefix はサブシステムごとの gRPC health のサービス名の接頭辞(例: cno.app.subsystem.grpc)
+const SubsystemHealthPrefix = "cno.app.subsystem."
+
+// SubsystemState はバックグラウンドのサブシステムの初期化状態
+type SubsystemState string
+
+const (
+	SubsystemInitializing SubsystemState = "initializing"
+	SubsystemReady        SubsystemState = "ready"
+	SubsystemFailed       SubsystemState = "failed"
+)
+
+// SubsystemHealth は readiness に載せるサブシステム 1 つ分の状態
+type SubsystemHealth struct {
+	Name  string         `json:"name"`
+	State SubsystemState `json:"state"`
+	Error string         `json:"error,omitempty"`
+	Since time.Time      `json:"since"`
+}
+
+// ReadinessGate は有効になっているバックグラウンドのサブシステムの初期化状態をまとめ、
+// 全てが ready になるまで gRPC health(サービス名 "")を NOT_SERVING に保つ。
+// 初期化途中の Pod にトラフィックが流れないよう、サブシステムは起動処理の前に Register し、完了したら SetReady する
+type ReadinessGate struct {
+	hs *health.Server
+
+	mu         sync.Mutex
+	subsystems map[string]*SubsystemHealth
+}
+
+// NewReadinessGate は hs の状態を管理する ReadinessGate を返す。サブシステムが 1 つもなければ SERVING
+func NewReadinessGate(hs *health.Server) *ReadinessGate {
+	g := &ReadinessGate{hs: hs, subsystems: make(map[string]*SubsystemHealth)}
+	g.mu.Lock()
+	g.updateLocked()
+	g.mu.Unlock()
+	return g
+}
+
+// Register はサブシステムを initializing として登録する。SetReady されるまで readiness は NOT_SERVING になる
+func (g *ReadinessGate) Register(name string) {
+	g.set(name, SubsystemInitializing, nil)
+}
+
+// SetReady はサブシステムの初期化が完了したことを記録する
+func (g *ReadinessGate) SetReady(name string) {
+	g.set(name, SubsystemReady, nil)
+}
+
+// SetFailed はサブシステムの初期化(または実行中の接続など)に失敗したことを記録する。readiness は NOT_SERVING になる
+func (g *ReadinessGate) SetFailed(name string, err error) {
+	g.set(name, SubsystemFailed, err)
+}
+
+func (g *ReadinessGate) set(name string, state SubsystemState, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sub, ok := g.subsystems[name]
+	if !ok {
+		sub = &SubsystemHealth{Name: name}
+		g.subsystems[name] = sub
+	}
+	if !ok || sub.State != state {
+		sub.Since = time.Now()
+	}
+	sub.State = state
+	sub.Error = ""
+	if err != nil {
+		sub.Error = err.Error()
+	}
+	g.updateLocked()
+}
+
+// updateLocked はサブシステムごとの health と、全体(サービス名 "")の health を更新する。
+// health.Server の Shutdown 後は SetServingStatus が無視されるため、停止中に SERVING へ戻ることはない
+func (g *ReadinessGate) updateLocked() {
+	ready := true
+	for name, sub := range g.subsystems {
+		status := healthpb.HealthCheckResponse_SERVING
+		if sub.State != SubsystemReady {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			ready = false
+		}
+		g.hs.SetServingStatus(SubsystemHealthPrefix+name, status)
+	}
+	if ready {
+		g.hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	} else {
+		g.hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// Ready は全てのサブシステムが ready かどうかを返す
+func (g *ReadinessGate) Ready() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, sub := range g.subsystems {
+		if sub.State != SubsystemReady {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot はサブシステムの状態を名前順で返す
+func (g *ReadinessGate) Snapshot() []SubsystemHealth {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]SubsystemHealth, 0, len(g.subsystems))
+	for _, sub := range g.subsystems {
+		out = append(out, *sub)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func healthStatus(t *testing.T, hs *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("health check %q: %v", service, err)
+	}
+	return resp.GetStatus()
+}
+
+// 登録したサブシステムが全て ready になるまで全体の readiness が NOT_SERVING のままであることを確認
+func TestReadinessGate_WaitsForAllSubsystems(t *testing.T) {
+	hs := health.NewServer()
+	g := NewReadinessGate(hs)
+	if got := healthStatus(t, hs, ""); got != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("no subsystems: status = %s, want SERVING", got)
+	}
+
+	g.Register("grpc")
+	g.Register("exporter")
+	if got := healthStatus(t, hs, ""); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("initializing: status = %s, want NOT_SERVING", got)
+	}
+
+	g.SetReady("grpc")
+	if g.Ready() {
+		t.Fatal("Ready() = true with exporter still initializing")
+	}
+	if got := healthStatus(t, hs, SubsystemHealthPrefix+"grpc"); got != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("grpc subsystem: status = %s, want SERVING", got)
+	}
+
+	g.SetReady("exporter")
+	if got := healthStatus(t, hs, ""); got != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("all ready: status = %s, want SERVING", got)
+	}
+
+	g.SetFailed("exporter", errors.New("connection refused"))
+	if got := healthStatus(t, hs, ""); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("failed: status = %s, want NOT_SERVING", got)
+	}
+	snap := g.Snapshot()
+	if len(snap) != 2 || snap[0].Name != "exporter" || snap[0].State != SubsystemFailed || snap[0].Error != "connection refused" {
+		t.Fatalf("snapshot = %+v", snap)
+	}
+}
+
+// 停止処理で health.Server を Shutdown した後は、サブシステムが ready になっても SERVING に戻らないことを確認
+func TestReadinessGate_StaysNotServingAfterShutdown(t *testing.T) {
+	hs := health.NewServer()
+	g := NewReadinessGate(hs)
+	g.Register("grpc")
+
+	hs.Shutdown()
+	g.SetReady("grpc")
+	if got := healthStatus(t, hs, ""); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("after shutdown: status = %s, want NOT_SERVING", got)
+	}
+}
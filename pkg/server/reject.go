@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// 拒否理由(cno_app_rejected_requests_total の reason ラベル)
+const (
+	rejectDurationTooLarge   = "duration_too_large"
+	rejectAllocTooLarge      = "alloc_too_large"
+	rejectParallelismTooHigh = "parallelism_too_high"
+	rejectIOBytesTooLarge    = "io_bytes_too_large"
+	rejectInvalidConfig      = "invalid_config"
+	rejectElevationInvalid   = "elevation_invalid"
+)
+
+// rejectReason は検証エラーを reason ラベルの値に変換する
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, load.ErrDurationTooLarge):
+		return rejectDurationTooLarge
+	case errors.Is(err, load.ErrAllocTooLarge):
+		return rejectAllocTooLarge
+	case errors.Is(err, load.ErrParallelismTooHigh):
+		return rejectParallelismTooHigh
+	case errors.Is(err, load.ErrIOBytesTooLarge):
+		return rejectIOBytesTooLarge
+	case errors.Is(err, ErrElevationInvalid):
+		return rejectElevationInvalid
+	default:
+		return rejectInvalidConfig
+	}
+}
+
+// rejectError は検証エラーに apperrors のカテゴリを付ける。
+// 上限超過は limit(RESOURCE_EXHAUSTED)、elevation token の不正は validation(PERMISSION_DENIED)、
+// それ以外は validation(INVALID_ARGUMENT)
+func rejectError(reason string, err error) error {
+	switch reason {
+	case rejectInvalidConfig:
+		return apperrors.WithReason(apperrors.Validation, reason, err)
+	case rejectElevationInvalid:
+		return &apperrors.Error{Category: apperrors.Validation, Reason: reason, Code: codes.PermissionDenied, Err: err}
+	}
+	return apperrors.WithReason(apperrors.Limit, reason, err)
+}
+
+// checkConfig は proto の WorkConfig を load.Config に変換して検証する。
+// 上限は ctx の load.LimitProfiles(load.WithLimitProfiles)、無ければサーバーの設定(WithLimitProfiles)のモード別上限を使い、
+// 検証した上限を load.Config.Limits に載せて Run に渡す。
+// x-elevation-token が有効なら、その token の範囲で上限を引き上げて検証し、使用を監査ログ(audit=true)に残す。
+// 拒否した場合は cno_app_rejected_requests_total{reason} を加算し、
+// 要求値と送信元を warn ログに出して「誰が上限超過の負荷を要求しているか」を追えるようにする
+func (s *GrpcBurnerServer) checkConfig(
+	ctx context.Context,
+	requestID string,
+	pc *grpcburnerv1.WorkConfig,
+) (load.Config, error) {
+	defer timingFromContext(ctx).since(TimingValidate, time.Now())
+
+	cfg, err := workConfigFromProto(pc)
+	if err == nil {
+		cfg.Mode, err = loadModeFromContext(ctx, cfg.Mode)
+	}
+	profiles := s.limits.Load()
+	// インターセプタがリクエストごとの上限(テナント別のポリシーなど)を載せていれば、サーバーの設定の上限より優先する
+	if p, ok := load.LimitProfilesFromContext(ctx); ok {
+		profiles = p
+	}
+	limits := profiles.For(cfg.Mode)
+	var (
+		grant    ElevationGrant
+		elevated bool
+	)
+	if err == nil {
+		if grant, elevated, err = s.elevationFromContext(ctx, cfg.Mode); elevated {
+			limits = grant.raise(limits)
+		}
+	}
+	if err == nil {
+		cfg.FailAfter, err = failAfterFromContext(ctx)
+	}
+	if err == nil {
+		// ミラーされたリクエストは shadow として負荷を縮めて実行する
+		var fraction float64
+		if fraction, err = shadowFractionFromContext(ctx); fraction > 0 {
+			scaleShadowWork(&cfg, fraction)
+		}
+	}
+	if err == nil {
+		cfg.CPUAffinity, err = s.cpuAffinityFromContext(ctx)
+	}
+	if err == nil {
+		err = s.applyCPUShare(ctx, &cfg)
+	}
+	if err == nil {
+		cfg.IOCache = ioCacheFromContext(ctx)
+	}
+	if err == nil {
+		cfg.WatchdogGrace = s.watchdogGrace
+	}
+	if err == nil {
+		cfg.Limits = &limits
+		err = load.Validate(cfg)
+	}
+	if err == nil && elevated {
+		err = s.consumeElevation(ctx, grant)
+	}
+	if err == nil {
+		if elevated {
+			s.logElevationUse(ctx, requestID, grant, pc)
+		}
+		cfg.IODir = s.ioDir
+		cfg.RequestID = requestID
+		return cfg, nil
+	}
+
+	reason := rejectReason(err)
+	observability.CNOAppRejectedRequestsTotal.WithLabelValues(reason).Inc()
+
+	if s.logger != nil {
+		fields := []any{
+			"request_id", requestID,
+			"reason", reason,
+			"error", err,
+			"mode", pc.GetMode().String(),
+			"duration_ms", pc.GetDurationMs(),
+			"alloc_mb", pc.GetAllocMb(),
+			"parallelism", pc.GetParallelism(),
+			"io_bytes", pc.GetIoBytes(),
+			"fail_after_ms", cfg.FailAfter.Milliseconds(),
+			"max_duration_ms", limits.MaxDuration.Milliseconds(),
+			"max_alloc_mb", limits.MaxAllocMB,
+			"max_parallelism", limits.MaxParallelism,
+			"max_io_bytes", limits.MaxIOBytes,
+		}
+		if elevated {
+			fields = append(fields, "elevation_id", grant.ID)
+		}
+		s.logger.Warnw("work request rejected", append(fields, callerFields(ctx)...)...)
+	}
+	return load.Config{}, rejectError(reason, err)
+}
+
+// logElevationUse は elevation token で引き上げた上限を使ったリクエストを監査ログ(audit=true)に残す
+func (s *GrpcBurnerServer) logElevationUse(ctx context.Context, requestID string, g ElevationGrant, pc *grpcburnerv1.WorkConfig) {
+	if s.logger == nil {
+		return
+	}
+	fields := []any{
+		"audit", true,
+		"request_id", requestID,
+		"elevation_id", g.ID,
+		"elevation_reason", g.Reason,
+		"issued_by", g.IssuedBy,
+		"expires_at", g.ExpiresAt.Format(time.RFC3339),
+		"mode", pc.GetMode().String(),
+		"duration_ms", pc.GetDurationMs(),
+		"alloc_mb", pc.GetAllocMb(),
+		"parallelism", pc.GetParallelism(),
+		"io_bytes", pc.GetIoBytes(),
+	}
+	s.logger.Warnw("limit elevation used", append(fields, callerFields(ctx)...)...)
+}
+
+// callerFields は拒否や監査のログに出す送信元(peer / user_agent)
+func callerFields(ctx context.Context) []any {
+	var fields []any
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, "peer", p.Addr.String())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			fields = append(fields, "user_agent", ua[0])
+		}
+	}
+	return fields
+}
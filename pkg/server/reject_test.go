@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// 上限超過のリクエストが reason 付きで拒否カウンタに記録されることを確認
+func TestCheckConfig_RecordsRejectionReason(t *testing.T) {
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar())
+
+	counter := observability.CNOAppRejectedRequestsTotal.WithLabelValues(rejectDurationTooLarge)
+	before := testutil.ToFloat64(counter)
+
+	_, err := s.checkConfig(context.Background(), "req-1", &grpcburnerv1.WorkConfig{
+		Mode:        grpcburnerv1.LoadMode_LOAD_MODE_CPU,
+		DurationMs:  (load.DefaultLimitProfiles().For(load.ModeCPU).MaxDuration.Milliseconds()) + 1000,
+		Parallelism: 1,
+	})
+	if err == nil {
+		t.Fatalf("expected rejection, got nil")
+	}
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Fatalf("expected rejection counter to increase by 1, got %v", got)
+	}
+}
+
+// 差し替えたモード別上限が次のリクエストの検証から使われることを確認
+func TestCheckConfig_UsesReloadedLimitProfiles(t *testing.T) {
+	profiles := load.DefaultLimitProfiles()
+	rl := NewReloadableLimitProfiles(profiles)
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar(), WithLimitProfiles(rl))
+
+	pc := &grpcburnerv1.WorkConfig{
+		Mode:       grpcburnerv1.LoadMode_LOAD_MODE_IO,
+		DurationMs: 1000,
+		IoBytes:    1024 * 1024,
+	}
+	if _, err := s.checkConfig(context.Background(), "req-1", pc); err != nil {
+		t.Fatalf("default limits: %v", err)
+	}
+
+	io := profiles.For(load.ModeIO)
+	io.MaxIOBytes = 64 * 1024
+	profiles[load.ModeIO] = io
+	rl.Store(profiles)
+
+	if _, err := s.checkConfig(context.Background(), "req-2", pc); rejectReason(err) != rejectIOBytesTooLarge {
+		t.Fatalf("lowered io cap: err = %v, want %s", err, rejectIOBytesTooLarge)
+	}
+}
+
+// ctx に載ったリクエストごとの上限がサーバーの設定の上限より優先され、Run に渡す Config.Limits にも載ることを確認
+func TestCheckConfig_UsesLimitProfilesFromContext(t *testing.T) {
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar())
+	pc := &grpcburnerv1.WorkConfig{
+		Mode:       grpcburnerv1.LoadMode_LOAD_MODE_IO,
+		DurationMs: 1000,
+		IoBytes:    1024 * 1024,
+	}
+
+	tenant := load.DefaultLimitProfiles()
+	io := tenant.For(load.ModeIO)
+	io.MaxIOBytes = 64 * 1024
+	tenant[load.ModeIO] = io
+	ctx := load.WithLimitProfiles(context.Background(), tenant)
+
+	if _, err := s.checkConfig(ctx, "req-1", pc); rejectReason(err) != rejectIOBytesTooLarge {
+		t.Fatalf("tenant io cap: err = %v, want %s", err, rejectIOBytesTooLarge)
+	}
+
+	pc.IoBytes = 1024
+	cfg, err := s.checkConfig(ctx, "req-2", pc)
+	if err != nil {
+		t.Fatalf("within tenant cap: %v", err)
+	}
+	if cfg.Limits == nil || *cfg.Limits != io {
+		t.Fatalf("Config.Limits = %+v, want %+v", cfg.Limits, io)
+	}
+}
+
+// 上限超過は limit / RESOURCE_EXHAUSTED、値の不正は validation / INVALID_ARGUMENT として返すことを確認
+func TestCheckConfig_ErrorCategory(t *testing.T) {
+	s := NewGrpcBurnerServer(zap.NewNop().Sugar())
+
+	_, err := s.checkConfig(context.Background(), "req-1", &grpcburnerv1.WorkConfig{
+		Mode:        grpcburnerv1.LoadMode_LOAD_MODE_CPU,
+		DurationMs:  1000,
+		Parallelism: int32(load.DefaultLimitProfiles().For(load.ModeCPU).MaxParallelism) + 1,
+	})
+	if apperrors.CategoryOf(err) != apperrors.Limit || status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("over limit: category = %q, code = %s", apperrors.CategoryOf(err), status.Code(err))
+	}
+	if apperrors.ReasonOf(err) != rejectParallelismTooHigh {
+		t.Fatalf("over limit: reason = %q, want %s", apperrors.ReasonOf(err), rejectParallelismTooHigh)
+	}
+
+	_, err = s.checkConfig(context.Background(), "req-2", nil)
+	if apperrors.CategoryOf(err) != apperrors.Validation || status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("missing config: category = %q, code = %s", apperrors.CategoryOf(err), status.Code(err))
+	}
+}
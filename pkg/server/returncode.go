@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+)
+
+// ReturnCodeService は呼び出し元が指定した gRPC ステータスコードをそのまま返す RPC を提供する。
+// アラートルール、リトライポリシー、ダッシュボードを、負荷の設定を工夫せずにコードごとに確かめるために使う。
+// proto リポジトリに RPC を追加するまでの間、リクエストを google.protobuf.Struct、
+// レスポンスを google.protobuf.Empty で受け渡す手書きの ServiceDesc として実装している
+const (
+	// ReturnCodeServiceName は ReturnCodeService のサービス名
+	ReturnCodeServiceName = "cno.app.v1.ReturnCodeService"
+	// ReturnCodeFullMethodName は ReturnCode のフルメソッド名
+	ReturnCodeFullMethodName = "/" + ReturnCodeServiceName + "/ReturnCode"
+
+	// MaxReturnCodeDelay は ReturnCode で応答前に待てる時間の上限
+	MaxReturnCodeDelay = time.Minute
+)
+
+// ReturnCodeRequest は ReturnCode のリクエスト。
+// Struct では {"code": "UNAVAILABLE" または 14, "delay_ms": 200, "message": "..."} の形で送る
+type ReturnCodeRequest struct {
+	Code codes.Code
+	// Delay は応答(エラー)を返すまでに待つ時間。RPC の deadline が先に来れば DEADLINE_EXCEEDED になる
+	Delay time.Duration
+	// Message はステータスのメッセージ。空なら "requested status <Code>"
+	Message string
+}
+
+// ParseCode は "UNAVAILABLE" / "unavailable" / "DeadlineExceeded" / "14" の形のステータスコードを返す
+func ParseCode(v string) (codes.Code, error) {
+	if n, err := strconv.ParseUint(v, 10, 32); err == nil && n <= uint64(codes.Unauthenticated) {
+		return codes.Code(n), nil
+	}
+	name := strings.ReplaceAll(v, "_", "")
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.EqualFold(c.String(), name) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid status code %q", v)
+}
+
+func (r ReturnCodeRequest) toStruct() (*structpb.Struct, error) {
+	return structpb.NewStruct(map[string]any{
+		"code":     float64(r.Code),
+		"delay_ms": r.Delay.Milliseconds(),
+		"message":  r.Message,
+	})
+}
+
+// returnCodeRequestFromStruct は Struct のリクエストを検証して ReturnCodeRequest にする
+func returnCodeRequestFromStruct(in *structpb.Struct) (ReturnCodeRequest, error) {
+	var r ReturnCodeRequest
+	for k, v := range in.GetFields() {
+		switch k {
+		case "code":
+			switch v.GetKind().(type) {
+			case *structpb.Value_StringValue:
+				c, err := ParseCode(v.GetStringValue())
+				if err != nil {
+					return r, err
+				}
+				r.Code = c
+			case *structpb.Value_NumberValue:
+				c, err := ParseCode(strconv.FormatFloat(v.GetNumberValue(), 'f', -1, 64))
+				if err != nil {
+					return r, err
+				}
+				r.Code = c
+			default:
+				return r, fmt.Errorf("code must be a string or number")
+			}
+		case "delay_ms":
+			ms := v.GetNumberValue()
+			if _, ok := v.GetKind().(*structpb.Value_NumberValue); !ok || math.IsNaN(ms) || ms < 0 || ms != math.Trunc(ms) {
+				return r, fmt.Errorf("delay_ms must be an integer >= 0")
+			}
+			if ms > float64(MaxReturnCodeDelay.Milliseconds()) {
+				return r, fmt.Errorf("delay_ms exceeds max %d", MaxReturnCodeDelay.Milliseconds())
+			}
+			r.Delay = time.Duration(ms) * time.Millisecond
+		case "message":
+			r.Message = v.GetStringValue()
+		default:
+			return r, fmt.Errorf("unknown field %q", k)
+		}
+	}
+	return r, nil
+}
+
+// ReturnCodeServer は ReturnCodeService のサーバー実装が満たすインターフェース
+type ReturnCodeServer interface {
+	ReturnCode(context.Context, *structpb.Struct) (*emptypb.Empty, error)
+}
+
+var _ ReturnCodeServer = (*GrpcBurnerServer)(nil)
+
+// ReturnCode は delay_ms だけ待ってから code のステータスを返す(OK なら空のレスポンス)。
+// エラーは injected(reason return_code)として ErrorInfo を付けるため、ログやメトリクスで意図したエラーと区別できる。
+// 待っている間も kill-switch で打ち切れる
+func (s *GrpcBurnerServer) ReturnCode(ctx context.Context, in *structpb.Struct) (*emptypb.Empty, error) {
+	req, err := returnCodeRequestFromStruct(in)
+	if err != nil {
+		return nil, apperrors.New(apperrors.Validation, err)
+	}
+
+	ctx, done := s.work.track(ctx)
+	defer done()
+
+	if req.Delay > 0 {
+		t := time.NewTimer(req.Delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			if killed(ctx) {
+				return nil, killedError()
+			}
+			return nil, apperrors.New(apperrors.Canceled, ctx.Err())
+		}
+	}
+
+	if req.Code == codes.OK {
+		return &emptypb.Empty{}, nil
+	}
+	msg := req.Message
+	if msg == "" {
+		msg = "requested status " + req.Code.String()
+	}
+	return nil, &apperrors.Error{
+		Category: apperrors.Injected,
+		Reason:   "return_code",
+		Code:     req.Code,
+		Err:      errors.New(msg),
+	}
+}
+
+// RegisterReturnCodeServer は ReturnCodeService を gRPC サーバーに登録する
+func RegisterReturnCodeServer(s grpc.ServiceRegistrar, srv ReturnCodeServer) {
+	s.RegisterService(&returnCodeServiceDesc, srv)
+}
+
+var returnCodeServiceDesc = grpc.ServiceDesc{
+	ServiceName: ReturnCodeServiceName,
+	HandlerType: (*ReturnCodeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReturnCode",
+			Handler:    returnCodeHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func returnCodeHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReturnCodeServer).ReturnCode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReturnCodeFullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReturnCodeServer).ReturnCode(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CallReturnCode は ReturnCode を呼び出す。サーバーが返したステータスをそのままエラーとして返す(OK なら nil)
+func CallReturnCode(ctx context.Context, cc grpc.ClientConnInterface, req ReturnCodeRequest, opts ...grpc.CallOption) error {
+	in, err := req.toStruct()
+	if err != nil {
+		return err
+	}
+	return cc.Invoke(ctx, ReturnCodeFullMethodName, in, new(emptypb.Empty), opts...)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+)
+
+func TestParseCode(t *testing.T) {
+	for in, want := range map[string]codes.Code{
+		"OK":                codes.OK,
+		"14":                codes.Unavailable,
+		"UNAVAILABLE":       codes.Unavailable,
+		"unavailable":       codes.Unavailable,
+		"DEADLINE_EXCEEDED": codes.DeadlineExceeded,
+		"DeadlineExceeded":  codes.DeadlineExceeded,
+		"16":                codes.Unauthenticated,
+	} {
+		if got, err := ParseCode(in); err != nil || got != want {
+			t.Fatalf("ParseCode(%q) = (%v, %v), want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "17", "-1", "NOT_A_CODE"} {
+		if _, err := ParseCode(in); err == nil {
+			t.Fatalf("ParseCode(%q): expected error", in)
+		}
+	}
+}
+
+func TestReturnCodeRequestFromStruct(t *testing.T) {
+	in, _ := structpb.NewStruct(map[string]any{"code": "not_found", "delay_ms": 250, "message": "gone"})
+	got, err := returnCodeRequestFromStruct(in)
+	if err != nil {
+		t.Fatalf("returnCodeRequestFromStruct: %v", err)
+	}
+	want := ReturnCodeRequest{Code: codes.NotFound, Delay: 250 * time.Millisecond, Message: "gone"}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for name, fields := range map[string]map[string]any{
+		"unknown field":  {"code": 14, "status": 14},
+		"bad code":       {"code": 99},
+		"bool code":      {"code": true},
+		"negative delay": {"delay_ms": -1},
+		"fraction delay": {"delay_ms": 1.5},
+		"string delay":   {"delay_ms": "100"},
+		"too long delay": {"delay_ms": MaxReturnCodeDelay.Milliseconds() + 1},
+	} {
+		in, _ := structpb.NewStruct(fields)
+		if _, err := returnCodeRequestFromStruct(in); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+// 要求したコードがそのまま(injected / return_code の ErrorInfo 付きで)クライアントに返り、OK なら成功する
+func TestReturnCode(t *testing.T) {
+	conn, _ := startTestServer(t)
+	ctx := context.Background()
+
+	if err := CallReturnCode(ctx, conn, ReturnCodeRequest{Code: codes.OK}); err != nil {
+		t.Fatalf("OK: %v", err)
+	}
+
+	for c := codes.Canceled; c <= codes.Unauthenticated; c++ {
+		err := CallReturnCode(ctx, conn, ReturnCodeRequest{Code: c})
+		if status.Code(err) != c {
+			t.Fatalf("requested %s, got %v", c, err)
+		}
+		if apperrors.CategoryOf(err) != apperrors.Injected || apperrors.ReasonOf(err) != "return_code" {
+			t.Fatalf("%s: category %q, reason %q", c, apperrors.CategoryOf(err), apperrors.ReasonOf(err))
+		}
+	}
+
+	err := CallReturnCode(ctx, conn, ReturnCodeRequest{Code: codes.Aborted, Message: "custom"})
+	if st, _ := status.FromError(err); st.Message() != "custom" {
+		t.Fatalf("message = %q, want custom", st.Message())
+	}
+
+	bad, _ := structpb.NewStruct(map[string]any{"code": "nope"})
+	if _, err := NewGrpcBurnerServer(nil).ReturnCode(ctx, bad); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("invalid request: got %v, want InvalidArgument", err)
+	}
+}
+
+// 遅延の途中で deadline が来れば、要求したコードではなく DEADLINE_EXCEEDED になる
+func TestReturnCode_DelayDeadline(t *testing.T) {
+	conn, _ := startTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := CallReturnCode(ctx, conn, ReturnCodeRequest{Code: codes.Unavailable, Delay: 10 * time.Second})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("returned after %s, want the deadline to cut the delay short", elapsed)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// RecvDelayMetadataKey は DoWorkClientStreaming でサーバーが 1 メッセージを受信するごとに、処理と次の受信の前に待つ時間(例: "100ms")を指定する metadata キー。
+	// 遅い consumer を模して受信を遅らせ、クライアントの Send がフロー制御で待たされる様子を観察するために使う
+	RecvDelayMetadataKey = "x-recv-delay"
+
+	// MaxRecvDelay は 1 メッセージあたりの受信遅延に指定できる上限
+	MaxRecvDelay = 10 * time.Second
+)
+
+// recvDelayFromContext は incoming metadata から 1 メッセージあたりの受信遅延を取得する。未指定なら 0
+func recvDelayFromContext(ctx context.Context) (time.Duration, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := firstMetadata(md, RecvDelayMetadataKey)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", RecvDelayMetadataKey, v, err)
+	}
+	if d < 0 || d > MaxRecvDelay {
+		return 0, fmt.Errorf("%s must be between 0 and %s, got %s", RecvDelayMetadataKey, MaxRecvDelay, d)
+	}
+	return d, nil
+}
+
+// delayRecv は受信したメッセージの処理と次の Recv の前に d だけ待つ。待っている間はトランスポートからメッセージを読み出さないため、
+// HTTP/2 のフロー制御ウィンドウが埋まるとクライアントの Send がブロックする。
+// 待った時間は server-timing の consume に記録する。ctx が終了したら待たずに戻る
+func delayRecv(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	defer timingFromContext(ctx).since(TimingConsume, time.Now())
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecvDelayFromContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "unset", value: "", want: 0},
+		{name: "valid", value: "100ms", want: 100 * time.Millisecond},
+		{name: "negative", value: "-1s", wantErr: true},
+		{name: "not a duration", value: "100", wantErr: true},
+		{name: "exceeds max", value: "1m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.value != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RecvDelayMetadataKey, tt.value))
+			}
+			got, err := recvDelayFromContext(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// x-recv-delay を指定すると、サーバーはメッセージごとに受信を遅らせ、その合計を consume として返す
+func TestClientStreaming_RecvDelay(t *testing.T) {
+	client, _ := startBurner(t)
+
+	const (
+		messages = 3
+		delay    = 50 * time.Millisecond
+	)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), RecvDelayMetadataKey, delay.String())
+	stream, err := client.DoWorkClientStreaming(ctx)
+	if err != nil {
+		t.Fatalf("DoWorkClientStreaming: %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < messages; i++ {
+		if err := stream.Send(&grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: cpuConfig(time.Millisecond)}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	summary, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if summary.GetSuccess() != messages {
+		t.Fatalf("summary = %+v", summary)
+	}
+	if elapsed := time.Since(start); elapsed < messages*delay {
+		t.Fatalf("elapsed = %s, want >= %s", elapsed, messages*delay)
+	}
+}
+
+func TestClientStreaming_InvalidRecvDelay(t *testing.T) {
+	client, _ := startBurner(t)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), RecvDelayMetadataKey, "soon")
+	stream, err := client.DoWorkClientStreaming(ctx)
+	if err != nil {
+		t.Fatalf("DoWorkClientStreaming: %v", err)
+	}
+	_, err = stream.CloseAndRecv()
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("CloseAndRecv: err = %v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// startTracedBurner は span に RPC の結果を記録する Burner を起動し、記録した span を返す
+func startTracedBurner(t *testing.T) (grpcburnerv1.BurnerClient, *tracetest.SpanRecorder) {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	conn, _ := startTestServer(t, withTracing(rec))
+	return grpcburnerv1.NewBurnerClient(conn), rec
+}
+
+// waitEndedSpan はサーバー側の span が終わるのを待って返す。span はクライアントが応答を受け取った後に終わることがある
+func waitEndedSpan(t *testing.T, rec *tracetest.SpanRecorder) sdktrace.ReadOnlySpan {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if ended := rec.Ended(); len(ended) > 0 {
+			if len(ended) != 1 {
+				t.Fatalf("ended spans = %d, want 1", len(ended))
+			}
+			return ended[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server span did not end")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// error_rate で注入した失敗はステータス OK の ok=false で返るが、span はエラー(category=injected)として記録され、
+// カテゴリは trailer でクライアントにも返る
+func TestSpanResult_InjectedFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(grpcburnerv1.BurnerClient) (metadata.MD, error)
+	}{
+		{"unary", func(c grpcburnerv1.BurnerClient) (metadata.MD, error) {
+			var trailer metadata.MD
+			resp, err := c.DoWork(context.Background(), &grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: failingConfig(1)}, grpc.Trailer(&trailer))
+			if err == nil && resp.GetOk() {
+				t.Fatalf("DoWork = %+v, want ok=false", resp)
+			}
+			return trailer, err
+		}},
+		{"server streaming", func(c grpcburnerv1.BurnerClient) (metadata.MD, error) {
+			stream, err := c.DoWorkServerStreaming(context.Background(), &grpcburnerv1.DoWorkServerStreamingRequest{RequestId: "req-1", Repeat: 2, Config: failingConfig(1)})
+			if err != nil {
+				return nil, err
+			}
+			for {
+				if _, err := stream.Recv(); err == io.EOF {
+					return stream.Trailer(), nil
+				} else if err != nil {
+					return nil, err
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, rec := startTracedBurner(t)
+			trailer, err := tt.call(client)
+			if err != nil {
+				t.Fatalf("call: %v", err)
+			}
+			if got := observability.ResponseCategoryFromTrailer(trailer); got != apperrors.Injected {
+				t.Fatalf("trailer category = %q, want injected", got)
+			}
+
+			span := waitEndedSpan(t, rec)
+			if span.Status().Code != otelcodes.Error {
+				t.Fatalf("span status = %+v, want Error", span.Status())
+			}
+			if got := spanAttr(span, "grpc.code").AsString(); got != "OK" {
+				t.Fatalf("grpc.code = %q, want OK", got)
+			}
+			if got := spanAttr(span, "error.category").AsString(); got != "injected" {
+				t.Fatalf("error.category = %q, want injected", got)
+			}
+			if !spanAttr(span, "error").AsBool() {
+				t.Fatal("error attribute is not set")
+			}
+		})
+	}
+}
+
+// 成功した RPC の span は Ok のまま
+func TestSpanResult_Success(t *testing.T) {
+	client, rec := startTracedBurner(t)
+	resp, err := client.DoWork(context.Background(), &grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: failingConfig(0)})
+	if err != nil || !resp.GetOk() {
+		t.Fatalf("DoWork = %+v, %v", resp, err)
+	}
+	if span := waitEndedSpan(t, rec); span.Status().Code != otelcodes.Ok {
+		t.Fatalf("span status = %+v, want Ok", span.Status())
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+)
+
+const (
+	// StartAtMetadataKey は Unary の DoWork で負荷を開始する時刻を Unix 時刻のミリ秒(例: "1760500000000")で指定する metadata キー。
+	// コーディネーター(client の broadcast モード)が全レプリカに同じ時刻を送ることで、Pod ごとにずれた小さな山ではなく、
+	// クラスタ全体で同時に負荷がかかる状況を作る。時刻を過ぎていればすぐに開始する。
+	// 時刻の比較はサーバーの時計で行うため、精度はノード間の時計のずれ(NTP の同期)に左右される
+	StartAtMetadataKey = "x-start-at"
+
+	// StartSkewTrailerKey は実際に負荷を開始した時刻と x-start-at の差(ミリ秒、遅れが正)を返す trailer のキー
+	StartSkewTrailerKey = "x-start-skew-ms"
+
+	// MaxStartAtLead は x-start-at に指定できる、現在時刻からの先の上限
+	MaxStartAtLead = 5 * time.Minute
+)
+
+// startAtFromContext は incoming metadata から負荷を開始する時刻を取得する。未指定ならゼロ値
+func startAtFromContext(ctx context.Context) (time.Time, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := firstMetadata(md, StartAtMetadataKey)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}, fmt.Errorf("invalid %s %q: want unix time in milliseconds", StartAtMetadataKey, v)
+	}
+	at := time.UnixMilli(ms)
+	if lead := time.Until(at); lead > MaxStartAtLead {
+		return time.Time{}, fmt.Errorf("%s is %s ahead, must be within %s", StartAtMetadataKey, lead.Round(time.Millisecond), MaxStartAtLead)
+	}
+	return at, nil
+}
+
+// waitStartAt は at まで待ち、開始の遅れを x-start-skew-ms trailer で返す。
+// 待っている間のキャンセルや kill-switch では負荷を始めずに終わる
+func (s *GrpcBurnerServer) waitStartAt(ctx context.Context, requestID string, at time.Time) error {
+	defer timingFromContext(ctx).since(TimingStartAt, time.Now())
+
+	if wait := time.Until(at); wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			if killed(ctx) {
+				return killedError()
+			}
+			return apperrors.New(apperrors.Canceled, ctx.Err())
+		}
+	}
+
+	skew := time.Since(at)
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(StartSkewTrailerKey, strconv.FormatInt(skew.Milliseconds(), 10)))
+	if s.logger != nil {
+		s.logger.Infow("synchronized work start",
+			"request_id", requestID,
+			"start_at", at.UTC().Format(time.RFC3339Nano),
+			"start_skew_ms", skew.Milliseconds(),
+		)
+	}
+	return nil
+}
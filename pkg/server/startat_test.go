@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+)
+
+func withStartAt(v string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(StartAtMetadataKey, v))
+}
+
+func TestStartAtFromContext(t *testing.T) {
+	if got, err := startAtFromContext(context.Background()); err != nil || !got.IsZero() {
+		t.Fatalf("unset: got (%v, %v), want zero", got, err)
+	}
+	at := time.Now().Add(time.Second).Truncate(time.Millisecond)
+	if got, err := startAtFromContext(withStartAt(strconv.FormatInt(at.UnixMilli(), 10))); err != nil || !got.Equal(at) {
+		t.Fatalf("valid: got (%v, %v), want %v", got, err, at)
+	}
+	// 過ぎた時刻は受け付けて、すぐに開始する
+	if _, err := startAtFromContext(withStartAt(strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10))); err != nil {
+		t.Fatalf("past: %v", err)
+	}
+	tooFar := strconv.FormatInt(time.Now().Add(MaxStartAtLead+time.Minute).UnixMilli(), 10)
+	for _, v := range []string{"soon", "0", "-1", tooFar} {
+		if _, err := startAtFromContext(withStartAt(v)); err == nil {
+			t.Fatalf("expected error for %q", v)
+		}
+	}
+}
+
+// x-start-at の時刻まで負荷を始めず、開始の遅れを trailer で返す
+func TestDoWork_StartAt(t *testing.T) {
+	client, _ := startBurner(t)
+
+	at := time.Now().Add(200 * time.Millisecond)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), StartAtMetadataKey, strconv.FormatInt(at.UnixMilli(), 10))
+	var trailer metadata.MD
+	resp, err := client.DoWork(ctx, &grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: cpuConfig(10 * time.Millisecond)}, grpc.Trailer(&trailer))
+	if err != nil || !resp.GetOk() {
+		t.Fatalf("DoWork: resp %v, err %v", resp, err)
+	}
+	if now := time.Now(); now.Before(at.Truncate(time.Millisecond)) {
+		t.Fatalf("DoWork returned at %v, before start-at %v", now, at)
+	}
+	v := trailer.Get(StartSkewTrailerKey)
+	if len(v) != 1 {
+		t.Fatalf("trailer %s = %v, want one value", StartSkewTrailerKey, v)
+	}
+	if skew, err := strconv.ParseInt(v[0], 10, 64); err != nil || skew < 0 {
+		t.Fatalf("start skew = %q, want a non-negative number of milliseconds", v[0])
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// testServerConfig は startTestServer で起動するサーバーの構成
+type testServerConfig struct {
+	burnerOpts []Option
+	serverOpts []grpc.ServerOption
+	unary      []grpc.UnaryServerInterceptor
+	stream     []grpc.StreamServerInterceptor
+	register   []func(*grpc.Server)
+}
+
+// testServerOption は startTestServer で起動するサーバーの構成を変える
+type testServerOption func(*testServerConfig)
+
+// withBurnerOptions は GrpcBurnerServer に opts を渡す
+func withBurnerOptions(opts ...Option) testServerOption {
+	return func(c *testServerConfig) {
+		c.burnerOpts = append(c.burnerOpts, opts...)
+	}
+}
+
+// withTracing は cmd/server と同じく otelgrpc の span に RPC の結果(observability の SpanResult インターセプタ)を記録し、
+// 終わった span を rec に残す
+func withTracing(rec *tracetest.SpanRecorder) testServerOption {
+	return func(c *testServerConfig) {
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+		c.serverOpts = append(c.serverOpts, grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithTracerProvider(tp))))
+		c.unary = append(c.unary, observability.UnarySpanResultInterceptor)
+		c.stream = append(c.stream, observability.StreamSpanResultInterceptor)
+	}
+}
+
+// withWorkControl は Burner と work を共有する WorkControlService を、authorize で認証して登録する
+func withWorkControl(work *WorkRegistry, authorize WorkAuthorizer) testServerOption {
+	return func(c *testServerConfig) {
+		c.burnerOpts = append(c.burnerOpts, WithWorkRegistry(work))
+		c.register = append(c.register, func(s *grpc.Server) {
+			RegisterWorkControlServer(s, NewWorkControl(work, authorize, nil))
+		})
+	}
+}
+
+// startTestServer は bufconn 上で Burner(BurnerProgress と ReturnCodeService も登録する)を起動し、
+// コネクションとサーバー側のストリームのハンドラが返したエラーを受け取るチャネルを返す
+func startTestServer(t *testing.T, opts ...testServerOption) (*grpc.ClientConn, <-chan error) {
+	t.Helper()
+
+	var cfg testServerConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+	handlerErrs := make(chan error, 8)
+	recordErr := func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		select {
+		case handlerErrs <- err:
+		default:
+		}
+		return err
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(append(cfg.serverOpts,
+		grpc.ChainUnaryInterceptor(cfg.unary...),
+		grpc.ChainStreamInterceptor(append([]grpc.StreamServerInterceptor{recordErr}, cfg.stream...)...),
+	)...)
+	burner := NewGrpcBurnerServer(nil, cfg.burnerOpts...)
+	grpcburnerv1.RegisterBurnerServer(srv, burner)
+	RegisterProgressServer(srv, burner)
+	RegisterReturnCodeServer(srv, burner)
+	for _, register := range cfg.register {
+		register(srv)
+	}
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn, handlerErrs
+}
+
+// startBurner は startTestServer で opts を渡した Burner を起動し、クライアントと
+// サーバー側ハンドラが返したエラーを受け取るチャネルを返す
+func startBurner(t *testing.T, opts ...Option) (grpcburnerv1.BurnerClient, <-chan error) {
+	t.Helper()
+	conn, handlerErrs := startTestServer(t, withBurnerOptions(opts...))
+	return grpcburnerv1.NewBurnerClient(conn), handlerErrs
+}
+
+// waitHandlerErr はハンドラのエラーを受け取る。ハンドラが ctx.Err() をそのまま返した場合も
+// gRPC がステータスに変換するのと同じく status エラーに揃える
+func waitHandlerErr(t *testing.T, errs <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errs:
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.FromContextError(err).Err()
+	case <-time.After(5 * time.Second):
+		t.Fatal("server handler did not return")
+		return nil
+	}
+}
+
+func cpuConfig(d time.Duration) *grpcburnerv1.WorkConfig {
+	return &grpcburnerv1.WorkConfig{
+		Mode:        grpcburnerv1.LoadMode_LOAD_MODE_CPU,
+		DurationMs:  d.Milliseconds(),
+		Parallelism: 1,
+	}
+}
+
+// サーバーストリーミングの途中でクライアントがキャンセルすると、ハンドラは残りの repeat を打ち切って終了する
+func TestServerStreaming_ClientCancelMidStream(t *testing.T) {
+	client, handlerErrs := startBurner(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.DoWorkServerStreaming(ctx, &grpcburnerv1.DoWorkServerStreamingRequest{
+		RequestId: "req-1",
+		Repeat:    100,
+		Config:    cpuConfig(20 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatalf("DoWorkServerStreaming: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("first Recv: %v", err)
+	}
+	cancel()
+
+	if err := waitHandlerErr(t, handlerErrs); status.Code(err) != codes.Canceled {
+		t.Fatalf("handler error = %v, want Canceled", err)
+	}
+}
+
+// 双方向ストリーミングでは 1 件の不正な設定でストリームを止めず、そのメッセージだけを失敗として返す
+func TestBidiStreaming_InvalidConfigMidStream(t *testing.T) {
+	client, handlerErrs := startBurner(t)
+
+	stream, err := client.DoWorkBidiStreaming(context.Background())
+	if err != nil {
+		t.Fatalf("DoWorkBidiStreaming: %v", err)
+	}
+
+	reqs := []*grpcburnerv1.DoWorkRequest{
+		{RequestId: "ok-1", Config: cpuConfig(10 * time.Millisecond)},
+		{RequestId: "bad", Config: &grpcburnerv1.WorkConfig{Mode: grpcburnerv1.LoadMode_LOAD_MODE_CPU, DurationMs: 10, Parallelism: 100000}},
+		{RequestId: "ok-2", Config: cpuConfig(10 * time.Millisecond)},
+	}
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Send %s: %v", req.GetRequestId(), err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv %s: %v", req.GetRequestId(), err)
+		}
+		wantOK := req.GetRequestId() != "bad"
+		if resp.GetOk() != wantOK || resp.GetRequestId() != req.GetRequestId() {
+			t.Fatalf("response for %s = %+v, want ok=%v", req.GetRequestId(), resp, wantOK)
+		}
+		if !wantOK && resp.GetErrorMessage() == "" {
+			t.Fatalf("failed response for %s has no error message", req.GetRequestId())
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend: %v", err)
+	}
+
+	if err := waitHandlerErr(t, handlerErrs); err != nil {
+		t.Fatalf("handler error = %v, want nil", err)
+	}
+}
+
+// クライアントストリーミングの途中でキャンセルすると、サマリーを返さずにハンドラが終了する
+func TestClientStreaming_ClientCancelMidStream(t *testing.T) {
+	client, handlerErrs := startBurner(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.DoWorkClientStreaming(ctx)
+	if err != nil {
+		t.Fatalf("DoWorkClientStreaming: %v", err)
+	}
+	if err := stream.Send(&grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: cpuConfig(10 * time.Millisecond)}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	cancel()
+
+	if err := waitHandlerErr(t, handlerErrs); status.Code(err) != codes.Canceled {
+		t.Fatalf("handler error = %v, want Canceled", err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.Canceled {
+		t.Fatalf("CloseAndRecv error = %v, want Canceled", err)
+	}
+}
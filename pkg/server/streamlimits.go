@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// StreamLimitTrailerKey はストリームの上限で打ち切った時に返す trailer のキー。
+// 値は "max_messages;limit=1000" や "max_work;limit=1m0s;requested=1m0.5s" の形式
+const StreamLimitTrailerKey = "x-stream-limit-exceeded"
+
+// ストリームの上限超過の理由(cno_app_rejected_requests_total の reason ラベル)
+const (
+	rejectStreamMaxMessages = "stream_max_messages"
+	rejectStreamMaxWork     = "stream_max_work"
+)
+
+// StreamLimits は 1 ストリームあたりの上限。
+// バグのあるクライアントがストリームを開いたまま際限なく負荷を要求し続けられないようにする
+type StreamLimits struct {
+	MaxMessages int           // 1 ストリームで処理するメッセージ数の上限(Server streaming では repeat)。0 なら無制限
+	MaxWork     time.Duration // 1 ストリームで要求された負荷の時間(duration + latency)の合計の上限。0 なら無制限
+}
+
+// Validate は設定値の範囲を検証する
+func (l StreamLimits) Validate() error {
+	if l.MaxMessages < 0 {
+		return errors.New("stream max messages must be >= 0")
+	}
+	if l.MaxWork < 0 {
+		return errors.New("stream max work must be >= 0")
+	}
+	return nil
+}
+
+// WithStreamLimits はストリーミング系 RPC に l の上限を適用する
+func WithStreamLimits(l StreamLimits) Option {
+	return func(s *GrpcBurnerServer) {
+		s.streamLimits = l
+	}
+}
+
+// streamBudget は 1 ストリームで消費したメッセージ数と負荷の時間を数える
+type streamBudget struct {
+	limits   StreamLimits
+	messages int
+	work     time.Duration
+}
+
+func (s *GrpcBurnerServer) newStreamBudget() *streamBudget {
+	return &streamBudget{limits: s.streamLimits}
+}
+
+// admit は cfg の負荷を 1 メッセージとして実行してよいかを判定し、よければ消費量に加える。
+// 上限を超える場合は RESOURCE_EXHAUSTED のエラーを返し、理由を trailer と warn ログに残す
+func (s *GrpcBurnerServer) admit(ctx context.Context, b *streamBudget, requestID string, cfg load.Config) error {
+	b.messages++
+	if b.limits.MaxMessages > 0 && b.messages > b.limits.MaxMessages {
+		return s.streamLimitExceeded(ctx, requestID, rejectStreamMaxMessages,
+			fmt.Sprintf("max_messages;limit=%d", b.limits.MaxMessages),
+			fmt.Errorf("stream exceeded max messages (%d)", b.limits.MaxMessages))
+	}
+
+	work := b.work + cfg.Duration + cfg.Latency
+	if b.limits.MaxWork > 0 && work > b.limits.MaxWork {
+		return s.streamLimitExceeded(ctx, requestID, rejectStreamMaxWork,
+			fmt.Sprintf("max_work;limit=%s;requested=%s", b.limits.MaxWork, work),
+			fmt.Errorf("stream exceeded max cumulative work (%s, requested %s)", b.limits.MaxWork, work))
+	}
+	b.work = work
+	return nil
+}
+
+// admitRepeat は Server streaming の repeat 回分をまとめて判定する。途中まで実行してから打ち切らないよう、開始前に確認する
+func (s *GrpcBurnerServer) admitRepeat(ctx context.Context, requestID string, cfg load.Config, repeat int) error {
+	b := s.newStreamBudget()
+	if b.limits.MaxMessages > 0 && repeat > b.limits.MaxMessages {
+		return s.streamLimitExceeded(ctx, requestID, rejectStreamMaxMessages,
+			fmt.Sprintf("max_messages;limit=%d;requested=%d", b.limits.MaxMessages, repeat),
+			fmt.Errorf("repeat %d exceeds stream max messages (%d)", repeat, b.limits.MaxMessages))
+	}
+	work := time.Duration(repeat) * (cfg.Duration + cfg.Latency)
+	if b.limits.MaxWork > 0 && work > b.limits.MaxWork {
+		return s.streamLimitExceeded(ctx, requestID, rejectStreamMaxWork,
+			fmt.Sprintf("max_work;limit=%s;requested=%s", b.limits.MaxWork, work),
+			fmt.Errorf("repeat %d requests %s of work, exceeds stream max work (%s)", repeat, work, b.limits.MaxWork))
+	}
+	return nil
+}
+
+func (s *GrpcBurnerServer) streamLimitExceeded(ctx context.Context, requestID, reason, trailer string, err error) error {
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(StreamLimitTrailerKey, trailer))
+	observability.CNOAppRejectedRequestsTotal.WithLabelValues(reason).Inc()
+	if s.logger != nil {
+		s.logger.Warnw("stream limit exceeded",
+			"request_id", requestID,
+			"reason", reason,
+			"limit", trailer,
+		)
+	}
+	return apperrors.WithReason(apperrors.Limit, reason, err)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 上限までのメッセージは処理され、上限を超えたメッセージで RESOURCE_EXHAUSTED と理由の trailer が返る
+func TestBidiStreaming_MaxMessages(t *testing.T) {
+	client, _ := startBurner(t, WithStreamLimits(StreamLimits{MaxMessages: 2}))
+
+	stream, err := client.DoWorkBidiStreaming(context.Background())
+	if err != nil {
+		t.Fatalf("DoWorkBidiStreaming: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: cpuConfig(time.Millisecond)}); err != nil {
+			break // 打ち切られた後の Send は io.EOF になる。実際のエラーは Recv で受け取る
+		}
+	}
+
+	received := 0
+	for {
+		_, err = stream.Recv()
+		if err != nil {
+			break
+		}
+		received++
+	}
+	if received != 2 {
+		t.Fatalf("received %d responses, want 2", received)
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Recv: err = %v, want ResourceExhausted", err)
+	}
+	if got := stream.Trailer().Get(StreamLimitTrailerKey); len(got) != 1 || got[0] != "max_messages;limit=2" {
+		t.Fatalf("trailer %s = %v", StreamLimitTrailerKey, got)
+	}
+}
+
+// repeat が上限を超える Server streaming は、負荷を 1 回も実行せずに拒否する
+func TestServerStreaming_MaxWork(t *testing.T) {
+	client, _ := startBurner(t, WithStreamLimits(StreamLimits{MaxWork: 50 * time.Millisecond}))
+
+	var trailer metadata.MD
+	stream, err := client.DoWorkServerStreaming(context.Background(), &grpcburnerv1.DoWorkServerStreamingRequest{
+		RequestId: "req-1",
+		Config:    cpuConfig(20 * time.Millisecond),
+		Repeat:    3,
+	}, grpc.Trailer(&trailer))
+	if err != nil {
+		t.Fatalf("DoWorkServerStreaming: %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Recv: err = %v, want ResourceExhausted", err)
+	}
+	if got := trailer.Get(StreamLimitTrailerKey); len(got) != 1 || !strings.HasPrefix(got[0], "max_work;limit=50ms;requested=60ms") {
+		t.Fatalf("trailer %s = %v", StreamLimitTrailerKey, got)
+	}
+}
+
+func TestClientStreaming_WithinLimits(t *testing.T) {
+	client, _ := startBurner(t, WithStreamLimits(StreamLimits{MaxMessages: 3, MaxWork: time.Second}))
+
+	stream, err := client.DoWorkClientStreaming(context.Background())
+	if err != nil {
+		t.Fatalf("DoWorkClientStreaming: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: cpuConfig(time.Millisecond)}); err != nil && err != io.EOF {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	summary, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if summary.GetSuccess() != 3 {
+		t.Fatalf("summary = %+v", summary)
+	}
+}
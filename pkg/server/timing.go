@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+// ServerTimingTrailerKey はサーバー側の処理時間の内訳を返す trailer のキー。
+// 値は HTTP の Server-Timing ヘッダと同じ形式で、dur はミリ秒
+// (例: "queue;dur=0.05, validate;dur=0.01, latency;dur=100.00, load;dur=900.12, total;dur=1000.30")
+const ServerTimingTrailerKey = "server-timing"
+
+// SpanAttributePrefix はサーバーの span に載せる各区間の属性名の接頭辞(例: server_timing.load_ms)
+const SpanAttributePrefix = "server_timing."
+
+// Server-Timing の各区間。ストリームでは全メッセージ分を合計する
+const (
+	// TimingQueue は RPC の受信から handler が処理を始めるまで(interceptor の処理を含む)
+	TimingQueue = "queue"
+	// TimingSteal は noisy neighbor(CPU steal)の模擬で入れた遅延
+	TimingSteal = "steal"
+	// TimingConsume は遅い consumer の模擬(x-recv-delay)で受信を遅らせた時間
+	TimingConsume = "consume"
+	// TimingValidate は WorkConfig の変換と検証
+	TimingValidate = "validate"
+	// TimingDependency は疑似 downstream の呼び出し
+	TimingDependency = "dependency"
+	// TimingStartAt は x-start-at の時刻まで負荷の開始を待った時間
+	TimingStartAt = "start_at"
+	// TimingLatency は load.Config.Latency による固定遅延
+	TimingLatency = "latency"
+	// TimingLoad は負荷の実行(固定遅延を除く)
+	TimingLoad = "load"
+	// TimingTotal は RPC 全体
+	TimingTotal = "total"
+)
+
+var timingOrder = []string{TimingQueue, TimingSteal, TimingConsume, TimingValidate, TimingDependency, TimingStartAt, TimingLatency, TimingLoad}
+
+type serverTimingKey struct{}
+
+// serverTiming は 1 RPC 分の処理時間の内訳
+type serverTiming struct {
+	arrived time.Time
+
+	mu      sync.Mutex
+	started bool
+	durs    map[string]time.Duration
+}
+
+func timingFromContext(ctx context.Context) *serverTiming {
+	t, _ := ctx.Value(serverTimingKey{}).(*serverTiming)
+	return t
+}
+
+// begin は handler が処理を始めた時に呼び、受信からの待ち時間を queue として記録する。
+// ストリームでは最初の呼び出しだけが有効
+func (t *serverTiming) begin() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.started {
+		t.started = true
+		t.durs[TimingQueue] = time.Since(t.arrived)
+	}
+}
+
+// add は区間 name に d を加算する
+func (t *serverTiming) add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.durs[name] += d
+	t.mu.Unlock()
+}
+
+// since は start からの経過時間を区間 name に加算する。defer t.since(name, time.Now()) の形で使う
+func (t *serverTiming) since(name string, start time.Time) {
+	t.add(name, time.Since(start))
+}
+
+// addLoad は負荷 1 回分の経過時間を、固定遅延(latency)と負荷本体(load)に分けて加算する
+func (t *serverTiming) addLoad(cfg load.Config, res load.RunResult) {
+	latency := min(cfg.Latency, res.Elapsed)
+	t.add(TimingLatency, latency)
+	t.add(TimingLoad, res.Elapsed-latency)
+}
+
+// header は記録した区間を Server-Timing 形式に整形する
+func (t *serverTiming) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parts := make([]string, 0, len(timingOrder)+1)
+	for _, name := range timingOrder {
+		if d, ok := t.durs[name]; ok {
+			parts = append(parts, formatTiming(name, d))
+		}
+	}
+	parts = append(parts, formatTiming(TimingTotal, time.Since(t.arrived)))
+	return strings.Join(parts, ", ")
+}
+
+// annotate は記録した区間を span 属性 server_timing.<区間>_ms(ミリ秒)に載せる。
+// trailer を受け取れないトレースのバックエンド側でも、サーバーの処理時間の内訳(client の trace-report)を出せるようにする
+func (t *serverTiming) annotate(span trace.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	attrs := make([]attribute.KeyValue, 0, len(timingOrder))
+	for _, name := range timingOrder {
+		if d, ok := t.durs[name]; ok {
+			attrs = append(attrs, attribute.Float64(SpanAttributePrefix+name+"_ms", float64(d)/float64(time.Millisecond)))
+		}
+	}
+	span.SetAttributes(attrs...)
+}
+
+func formatTiming(name string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.2f", name, float64(d)/float64(time.Millisecond))
+}
+
+func withServerTiming(ctx context.Context) (context.Context, *serverTiming) {
+	t := &serverTiming{arrived: time.Now(), durs: make(map[string]time.Duration)}
+	return context.WithValue(ctx, serverTimingKey{}, t), t
+}
+
+// UnaryServerTimingInterceptor は Unary RPC に server-timing trailer を付ける。
+// queue に interceptor の処理時間を含めるため、チェーンの先頭に置く
+func UnaryServerTimingInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, t := withServerTiming(ctx)
+	resp, err := handler(ctx, req)
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(ServerTimingTrailerKey, t.header()))
+	t.annotate(trace.SpanFromContext(ctx))
+	return resp, err
+}
+
+// StreamServerTimingInterceptor は Streaming RPC に server-timing trailer を付ける
+func StreamServerTimingInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, t := withServerTiming(ss.Context())
+	err := handler(srv, &timingServerStream{ServerStream: ss, ctx: ctx})
+	ss.SetTrailer(metadata.Pairs(ServerTimingTrailerKey, t.header()))
+	t.annotate(trace.SpanFromContext(ctx))
+	return err
+}
+
+type timingServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *timingServerStream) Context() context.Context { return s.ctx }
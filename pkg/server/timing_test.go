@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/load"
+)
+
+// 固定遅延は latency、残りは load に振り分けられ、記録した区間だけが決まった順で並ぶ
+func TestServerTiming_Header(t *testing.T) {
+	_, st := withServerTiming(context.Background())
+	st.begin()
+	st.addLoad(load.Config{Latency: 100 * time.Millisecond}, load.RunResult{Elapsed: 250 * time.Millisecond})
+	st.addLoad(load.Config{Latency: 100 * time.Millisecond}, load.RunResult{Elapsed: 50 * time.Millisecond})
+
+	h := st.header()
+	for _, want := range []string{"latency;dur=150.00", "load;dur=150.00"} {
+		if !strings.Contains(h, want) {
+			t.Errorf("header %q does not contain %q", h, want)
+		}
+	}
+
+	var names []string
+	for _, part := range strings.Split(h, ", ") {
+		names = append(names, strings.SplitN(part, ";", 2)[0])
+	}
+	if got, want := strings.Join(names, ","), "queue,latency,load,total"; got != want {
+		t.Errorf("segments = %s, want %s", got, want)
+	}
+}
+
+// interceptor を通さない呼び出し(テストなど)でも記録処理が panic しない
+func TestServerTiming_NilSafe(t *testing.T) {
+	st := timingFromContext(context.Background())
+	st.begin()
+	st.add(TimingLoad, time.Second)
+	st.addLoad(load.Config{}, load.RunResult{Elapsed: time.Second})
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+)
+
+// WorkControlService は実行中の負荷(WorkRegistry)を一覧し、一時停止・再開する RPC を提供する。
+// admin リスナーの /admin/jobs 系はこの実装を呼ぶだけの薄いラッパーで、対象の選択と監査ログはこちらで行う。
+// gRPC のポートに登録するのは admin の資格情報が設定されている時だけで、資格情報の無い呼び出しは UNAUTHENTICATED で拒否する。
+// proto リポジトリに RPC を追加するまでの間、ReturnCodeService と同じく Struct / Empty で受け渡す手書きの ServiceDesc にしている
+//
+//   - Pause / Resume : {"id": 3, "request_id": "...", "run_id": "...", "reason": "..."}(全て省略可。省略したフィールドは条件にしない)
+//     → {"matched": N, "changed": N, "jobs": [...]}
+//   - List : {"count": N, "jobs": [{"id", "request_id", "run_id", "started_at", "paused", "paused_ms"}]}
+const (
+	// WorkControlServiceName は WorkControlService のサービス名
+	WorkControlServiceName = "cno.app.v1.WorkControlService"
+
+	WorkPauseFullMethodName  = "/" + WorkControlServiceName + "/Pause"
+	WorkResumeFullMethodName = "/" + WorkControlServiceName + "/Resume"
+	WorkListFullMethodName   = "/" + WorkControlServiceName + "/List"
+)
+
+// WorkControlServer は WorkControlService のサーバー実装が満たすインターフェース
+type WorkControlServer interface {
+	Pause(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	Resume(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	List(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+}
+
+// WorkAuthorizer は RPC の呼び出し元を認証し、監査ログに残す操作者を返す。
+// cmd/server は admin リスナーと同じ資格情報(metadata authorization の Bearer / Basic)で判定する
+type WorkAuthorizer func(ctx context.Context) (principal string, ok bool)
+
+// WorkControl は WorkRegistry を操作する WorkControlServer
+type WorkControl struct {
+	work      *WorkRegistry
+	authorize WorkAuthorizer
+	logger    *zap.SugaredLogger
+}
+
+// NewWorkControl は work を操作する WorkControlService の実装を返す。authorize が nil なら全ての呼び出しを拒否する
+func NewWorkControl(work *WorkRegistry, authorize WorkAuthorizer, logger *zap.SugaredLogger) *WorkControl {
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+	return &WorkControl{work: work, authorize: authorize, logger: logger}
+}
+
+// errWorkUnauthenticated は WorkControlService の資格情報が無い/一致しない時のエラー
+var errWorkUnauthenticated = &apperrors.Error{
+	Category: apperrors.Validation,
+	Reason:   "admin_unauthenticated",
+	Code:     codes.Unauthenticated,
+	Err:      errors.New("admin credentials are required"),
+}
+
+func (c *WorkControl) principal(ctx context.Context) (string, error) {
+	if c.authorize == nil {
+		return "", errWorkUnauthenticated
+	}
+	p, ok := c.authorize(ctx)
+	if !ok {
+		return "", errWorkUnauthenticated
+	}
+	return p, nil
+}
+
+// Pause は指定した負荷を一時停止する。一時停止中のワーカーは確保したメモリを持ったまま止まる
+func (c *WorkControl) Pause(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return c.setPaused(ctx, in, true)
+}
+
+// Resume は指定した負荷を再開する
+func (c *WorkControl) Resume(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return c.setPaused(ctx, in, false)
+}
+
+// setPaused は kill-switch と同じく、誰がいつ何件止めた/再開したかを監査ログ(audit=true)に残す
+func (c *WorkControl) setPaused(ctx context.Context, in *structpb.Struct, paused bool) (*structpb.Struct, error) {
+	principal, err := c.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sel, err := workSelectorFromStruct(in)
+	if err != nil {
+		return nil, apperrors.WithReason(apperrors.Validation, "invalid_work_selector", err)
+	}
+
+	action, apply := "resume", c.work.Resume
+	if paused {
+		action, apply = "pause", c.work.Pause
+	}
+	jobs, changed := apply(sel)
+	remoteAddr, userAgent := callerOf(ctx)
+	c.logger.Warnw("admin work "+action,
+		"audit", true,
+		"matched", len(jobs),
+		"changed", changed,
+		"job_id", sel.ID,
+		"request_id", sel.RequestID,
+		"run_id", sel.RunID,
+		"reason", in.GetFields()["reason"].GetStringValue(),
+		"principal", principal,
+		"remote_addr", remoteAddr,
+		"user_agent", userAgent,
+	)
+	return structpb.NewStruct(map[string]any{
+		"matched": len(jobs),
+		"changed": changed,
+		"jobs":    workJobsToList(jobs),
+	})
+}
+
+// List は実行中の負荷を開始が古い順に返す
+func (c *WorkControl) List(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	if _, err := c.principal(ctx); err != nil {
+		return nil, err
+	}
+	jobs := c.work.Jobs()
+	return structpb.NewStruct(map[string]any{
+		"count": len(jobs),
+		"jobs":  workJobsToList(jobs),
+	})
+}
+
+// workSelectorFromStruct は Pause / Resume のリクエストから対象を読む。id は数値でも文字列でもよい
+func workSelectorFromStruct(in *structpb.Struct) (WorkSelector, error) {
+	f := in.GetFields()
+	for k := range f {
+		switch k {
+		case "id", "request_id", "run_id", "reason":
+		default:
+			return WorkSelector{}, fmt.Errorf("unknown field %q", k)
+		}
+	}
+	sel := WorkSelector{RequestID: f["request_id"].GetStringValue(), RunID: f["run_id"].GetStringValue()}
+	switch v := f["id"].GetKind().(type) {
+	case nil, *structpb.Value_NullValue:
+	case *structpb.Value_NumberValue:
+		if v.NumberValue < 1 || v.NumberValue != math.Trunc(v.NumberValue) || v.NumberValue > 1<<53 {
+			return sel, fmt.Errorf("id must be a job id > 0, got %v", v.NumberValue)
+		}
+		sel.ID = uint64(v.NumberValue)
+	case *structpb.Value_StringValue:
+		if v.StringValue == "" {
+			break
+		}
+		id, err := strconv.ParseUint(v.StringValue, 10, 64)
+		if err != nil || id == 0 {
+			return sel, fmt.Errorf("id must be a job id > 0, got %q", v.StringValue)
+		}
+		sel.ID = id
+	default:
+		return sel, fmt.Errorf("id must be a number or a string")
+	}
+	return sel, nil
+}
+
+// workJobsToList は WorkJob を JSON(/admin/jobs)と同じフィールド名の Struct の値に変換する
+func workJobsToList(jobs []WorkJob) []any {
+	list := make([]any, len(jobs))
+	for i, j := range jobs {
+		m := map[string]any{
+			"id":         float64(j.ID),
+			"started_at": j.StartedAt.Format(time.RFC3339Nano),
+			"paused":     j.Paused,
+			"paused_ms":  float64(j.PausedMs),
+		}
+		if j.RequestID != "" {
+			m["request_id"] = j.RequestID
+		}
+		if j.RunID != "" {
+			m["run_id"] = j.RunID
+		}
+		list[i] = m
+	}
+	return list
+}
+
+// callerOf は監査ログに残す呼び出し元のアドレスと user-agent を返す
+func callerOf(ctx context.Context) (remoteAddr, userAgent string) {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("user-agent"); len(vals) > 0 {
+			userAgent = vals[0]
+		}
+	}
+	return remoteAddr, userAgent
+}
+
+// RegisterWorkControlServer は WorkControlService を gRPC サーバーに登録する
+func RegisterWorkControlServer(s grpc.ServiceRegistrar, srv WorkControlServer) {
+	s.RegisterService(&workControlServiceDesc, srv)
+}
+
+var workControlServiceDesc = grpc.ServiceDesc{
+	ServiceName: WorkControlServiceName,
+	HandlerType: (*WorkControlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Pause",
+			Handler:    workPauseHandler,
+		},
+		{
+			MethodName: "Resume",
+			Handler:    workResumeHandler,
+		},
+		{
+			MethodName: "List",
+			Handler:    workListHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func workPauseHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkControlServer).Pause(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkPauseFullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkControlServer).Pause(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func workResumeHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkControlServer).Resume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkResumeFullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkControlServer).Resume(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func workListHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkControlServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkListFullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkControlServer).List(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
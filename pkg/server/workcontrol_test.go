@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// startWorkControl は Burner と WorkControlService を同じ WorkRegistry で起動する。
+// WorkControlService は metadata authorization が "Bearer t" の呼び出しだけを受け付ける
+func startWorkControl(t *testing.T, work *WorkRegistry) *grpc.ClientConn {
+	t.Helper()
+	conn, _ := startTestServer(t, withWorkControl(work, func(ctx context.Context) (string, bool) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		vals := md.Get("authorization")
+		return "bearer", len(vals) == 1 && vals[0] == "Bearer t"
+	}))
+	return conn
+}
+
+// RPC で一時停止した負荷は List で paused になり、Resume するまで終わらない
+func TestWorkControl_PauseResume(t *testing.T) {
+	work := NewWorkRegistry()
+	conn := startWorkControl(t, work)
+	authed := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer t")
+
+	done := make(chan *grpcburnerv1.DoWorkResponse, 1)
+	go func() {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-1")
+		resp, _ := grpcburnerv1.NewBurnerClient(conn).DoWork(ctx, &grpcburnerv1.DoWorkRequest{RequestId: "req-1", Config: cpuConfig(200 * time.Millisecond)})
+		done <- resp
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for work.Active() < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("work did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	in, _ := structpb.NewStruct(map[string]any{"request_id": "req-1", "reason": "test"})
+	out := new(structpb.Struct)
+	if err := conn.Invoke(authed, WorkPauseFullMethodName, in, out); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if m := out.AsMap(); m["matched"] != 1.0 || m["changed"] != 1.0 {
+		t.Fatalf("Pause = %v, want matched=1 changed=1", m)
+	}
+
+	list := new(structpb.Struct)
+	if err := conn.Invoke(authed, WorkListFullMethodName, &emptypb.Empty{}, list); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	jobs := list.GetFields()["jobs"].GetListValue().GetValues()
+	if len(jobs) != 1 || !jobs[0].GetStructValue().GetFields()["paused"].GetBoolValue() ||
+		jobs[0].GetStructValue().GetFields()["request_id"].GetStringValue() != "req-1" {
+		t.Fatalf("List = %v, want the paused job", list.AsMap())
+	}
+
+	select {
+	case resp := <-done:
+		t.Fatalf("paused job finished: %+v", resp)
+	case <-time.After(400 * time.Millisecond):
+	}
+
+	id := jobs[0].GetStructValue().GetFields()["id"].GetNumberValue()
+	in, _ = structpb.NewStruct(map[string]any{"id": id})
+	if err := conn.Invoke(authed, WorkResumeFullMethodName, in, out); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	select {
+	case resp := <-done:
+		if !resp.GetOk() {
+			t.Fatalf("resumed job = %+v, want ok", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not finish after Resume")
+	}
+}
+
+// 資格情報が無ければ UNAUTHENTICATED、対象の指定が不正なら INVALID_ARGUMENT で、どちらも負荷には触れない
+func TestWorkControl_Errors(t *testing.T) {
+	conn := startWorkControl(t, NewWorkRegistry())
+	authed := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer t")
+	wrong := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer x")
+
+	for _, ctx := range []context.Context{context.Background(), wrong} {
+		for _, method := range []string{WorkPauseFullMethodName, WorkResumeFullMethodName} {
+			if err := conn.Invoke(ctx, method, &structpb.Struct{}, new(structpb.Struct)); status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("%s without credentials: %v, want Unauthenticated", method, err)
+			}
+		}
+		if err := conn.Invoke(ctx, WorkListFullMethodName, &emptypb.Empty{}, new(structpb.Struct)); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("List without credentials: %v, want Unauthenticated", err)
+		}
+	}
+
+	for name, fields := range map[string]map[string]any{
+		"zero id":       {"id": 0},
+		"fraction id":   {"id": 1.5},
+		"string id":     {"id": "one"},
+		"bool id":       {"id": true},
+		"unknown field": {"job": 1},
+	} {
+		in, _ := structpb.NewStruct(fields)
+		if err := conn.Invoke(authed, WorkPauseFullMethodName, in, new(structpb.Struct)); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("%s: %v, want InvalidArgument", name, err)
+		}
+	}
+}
+
+// authorize を渡さない WorkControl は fail closed で全ての呼び出しを拒否する
+func TestWorkControl_NilAuthorizerRejects(t *testing.T) {
+	c := NewWorkControl(NewWorkRegistry(), nil, nil)
+	if _, err := c.Pause(context.Background(), &structpb.Struct{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Pause = %v, want Unauthenticated", err)
+	}
+	if _, err := c.List(context.Background(), &emptypb.Empty{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("List = %v, want Unauthenticated", err)
+	}
+}
@@ -0,0 +1,120 @@
+package slo
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// burnRateAlert は multi-window multi-burn-rate アラート 1 本分の設定
+// (Google SRE Workbook "Alerting on SLOs" の推奨値)
+type burnRateAlert struct {
+	long     string
+	short    string
+	factor   float64
+	severity string
+	forDur   string
+}
+
+var burnRateAlerts = []burnRateAlert{
+	{long: "1h", short: "5m", factor: 14.4, severity: "page", forDur: "2m"},
+	{long: "6h", short: "30m", factor: 6, severity: "page", forDur: "15m"},
+	{long: "3d", short: "6h", factor: 1, severity: "ticket", forDur: "1h"},
+}
+
+// recordingWindows は recording rule を作るエラー率の集計ウィンドウ
+var recordingWindows = []string{"5m", "30m", "1h", "6h", "3d"}
+
+// RuleFile は Prometheus のルールファイル
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+// RuleGroup は Prometheus のルールグループ
+type RuleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule は recording rule または alerting rule
+type Rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// recordName は SLO のエラー率 recording rule 名(level:metric:operations 形式)。
+// メトリクス名に使えない文字("-" など)は "_" に置き換える
+func (o SLO) recordName(window string) string {
+	return fmt.Sprintf("slo:%s:error_ratio_rate%s", invalidMetricChars.ReplaceAllString(o.Name, "_"), window)
+}
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Rules は SLO ごとに、ウィンドウ別エラー率の recording rule と burn rate の alerting rule を生成する
+func (s *Spec) Rules() RuleFile {
+	var rf RuleFile
+	for _, o := range s.SLOs {
+		labels := map[string]string{
+			"service": s.Service,
+			"slo":     o.Name,
+		}
+
+		rec := RuleGroup{Name: fmt.Sprintf("slo-%s-recording", o.Name)}
+		for _, w := range recordingWindows {
+			rec.Rules = append(rec.Rules, Rule{
+				Record: o.recordName(w),
+				Expr:   o.errorRatioExpr(w),
+				Labels: labels,
+			})
+		}
+
+		budget := 1 - o.Objective
+		alerts := RuleGroup{Name: fmt.Sprintf("slo-%s-alerts", o.Name)}
+		for _, a := range burnRateAlerts {
+			threshold := strconv.FormatFloat(a.factor*budget, 'g', 6, 64)
+			alertLabels := map[string]string{
+				"service":  s.Service,
+				"slo":      o.Name,
+				"severity": a.severity,
+			}
+			alerts.Rules = append(alerts.Rules, Rule{
+				Alert: fmt.Sprintf("SLOBurnRate_%s_%s", o.Name, a.long),
+				Expr: fmt.Sprintf("%s > %s and %s > %s",
+					o.recordName(a.long), threshold, o.recordName(a.short), threshold),
+				For:    a.forDur,
+				Labels: alertLabels,
+				Annotations: map[string]string{
+					"summary": fmt.Sprintf("%s: error budget burn rate > %gx over %s (and %s)", o.Name, a.factor, a.long, a.short),
+					"description": fmt.Sprintf("SLO %s (objective %g over %s, sli=%s on %s) is consuming its error budget %gx faster than allowed.",
+						o.Name, o.Objective, o.Window, o.SLI.Type, o.SLI.Metric, a.factor),
+				},
+			})
+		}
+
+		rf.Groups = append(rf.Groups, rec, alerts)
+	}
+	return rf
+}
+
+// RenderRules は Rules の結果を Prometheus ルールファイル(YAML)として出力する
+func (s *Spec) RenderRules() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("# Code generated by gen-rules; DO NOT EDIT.\n")
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(s.Rules()); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,169 @@
+// Package slo は SLO 定義(YAML)から cno_app_* メトリクス向けの
+// Prometheus recording/alerting ルールを生成する
+package slo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// SLI の種類
+const (
+	SLIAvailability = "availability" // code ラベルを持つ *_total カウンタのエラー率
+	SLILatency      = "latency"      // histogram の threshold 超過率
+)
+
+// Spec は SLO 定義ファイルのルート
+type Spec struct {
+	Service string `yaml:"service"`
+	SLOs    []SLO  `yaml:"slos"`
+}
+
+// SLO は 1 つのサービスレベル目標
+type SLO struct {
+	Name      string  `yaml:"name"`
+	Objective float64 `yaml:"objective"` // 例: 0.99
+	Window    string  `yaml:"window"`    // 例: 30d(ドキュメント/ラベル用)
+	SLI       SLI     `yaml:"sli"`
+}
+
+// SLI は SLO の計測方法
+type SLI struct {
+	Type     string `yaml:"type"`
+	Metric   string `yaml:"metric"`
+	Selector string `yaml:"selector,omitempty"` // 例: endpoint="/observability.grpcburner.v1.Burner/DoWork"
+	// ErrorCodes は availability でエラーとみなす code の正規表現。
+	// 先頭に "!" を付けると「一致しないものをエラー」とする(既定: "!OK|[23].." = OK/2xx/3xx 以外)
+	ErrorCodes string `yaml:"error_codes,omitempty"`
+	// Threshold は latency で「速い」とみなす上限秒数(histogram のバケット境界と一致させる)
+	Threshold float64 `yaml:"threshold,omitempty"`
+}
+
+// Load は SLO 定義ファイルを読み込んで検証する
+func Load(path string) (*Spec, error) {
+	b, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("read slo spec: %w", err)
+	}
+	var s Spec
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parse slo spec %s: %w", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Validate は SLO 定義が pkg/observability のメトリクス定義と整合しているかを検証する
+func (s *Spec) Validate() error {
+	if s.Service == "" {
+		s.Service = "cno-app"
+	}
+	if len(s.SLOs) == 0 {
+		return errors.New("slo: at least one slo is required")
+	}
+	for i := range s.SLOs {
+		if err := s.SLOs[i].validate(); err != nil {
+			return fmt.Errorf("slo %q: %w", s.SLOs[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func (o *SLO) validate() error {
+	if o.Name == "" {
+		return errors.New("name is required")
+	}
+	if o.Objective <= 0 || o.Objective >= 1 {
+		return fmt.Errorf("objective must be between 0 and 1 (exclusive), got %v", o.Objective)
+	}
+	if o.Window == "" {
+		o.Window = "30d"
+	}
+
+	m, ok := observability.LookupMetric(o.SLI.Metric)
+	if !ok {
+		return fmt.Errorf("unknown metric %q (not defined in pkg/observability)", o.SLI.Metric)
+	}
+
+	switch o.SLI.Type {
+	case SLIAvailability:
+		if m.Type != observability.MetricCounter || !contains(m.Labels, "code") {
+			return fmt.Errorf("availability sli requires a counter with a code label, %s is a %s with labels %v", m.Name, m.Type, m.Labels)
+		}
+		if o.SLI.ErrorCodes == "" {
+			o.SLI.ErrorCodes = "!OK|[23].."
+		}
+	case SLILatency:
+		if m.Type != observability.MetricHistogram {
+			return fmt.Errorf("latency sli requires a histogram, %s is a %s", m.Name, m.Type)
+		}
+		if !containsFloat(m.Buckets, o.SLI.Threshold) {
+			return fmt.Errorf("latency threshold %v is not a bucket boundary of %s %v", o.SLI.Threshold, m.Name, m.Buckets)
+		}
+	default:
+		return fmt.Errorf("unsupported sli type %q (expected availability|latency)", o.SLI.Type)
+	}
+	return nil
+}
+
+// errorMatcher は ErrorCodes を code ラベルのマッチャに変換する。
+// 先頭が "!" の場合は「一致しないものがエラー」を表す
+func (o SLO) errorMatcher() string {
+	if strings.HasPrefix(o.SLI.ErrorCodes, "!") {
+		return fmt.Sprintf(`code!~"%s"`, strings.TrimPrefix(o.SLI.ErrorCodes, "!"))
+	}
+	return fmt.Sprintf(`code=~"%s"`, o.SLI.ErrorCodes)
+}
+
+func joinSelector(parts ...string) string {
+	var nonEmpty []string
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return "{" + strings.Join(nonEmpty, ", ") + "}"
+}
+
+// errorRatioExpr は window における SLI のエラー率(0~1)を求める PromQL を返す
+func (o SLO) errorRatioExpr(window string) string {
+	switch o.SLI.Type {
+	case SLILatency:
+		bucket := o.SLI.Metric + "_bucket"
+		le := `le="` + strconv.FormatFloat(o.SLI.Threshold, 'g', -1, 64) + `"`
+		return fmt.Sprintf("1 - (sum(rate(%s%s[%s])) / sum(rate(%s_count%s[%s])))",
+			bucket, joinSelector(o.SLI.Selector, le), window,
+			o.SLI.Metric, joinSelector(o.SLI.Selector), window)
+	default:
+		return fmt.Sprintf("sum(rate(%s%s[%s])) / sum(rate(%s%s[%s]))",
+			o.SLI.Metric, joinSelector(o.SLI.Selector, o.errorMatcher()), window,
+			o.SLI.Metric, joinSelector(o.SLI.Selector), window)
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFloat(fs []float64, f float64) bool {
+	for _, v := range fs {
+		if v == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package slo
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidate_RejectsMismatchedMetrics(t *testing.T) {
+	tests := []struct {
+		name string
+		sli  SLI
+	}{
+		{name: "unknown metric", sli: SLI{Type: SLIAvailability, Metric: "cno_app_unknown_total"}},
+		{name: "availability on histogram", sli: SLI{Type: SLIAvailability, Metric: "cno_app_request_latency_seconds"}},
+		{name: "latency on counter", sli: SLI{Type: SLILatency, Metric: "cno_app_requests_total", Threshold: 1}},
+		{name: "threshold is not a bucket", sli: SLI{Type: SLILatency, Metric: "cno_app_request_latency_seconds", Threshold: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Spec{SLOs: []SLO{{Name: "x", Objective: 0.99, SLI: tt.sli}}}
+			if err := s.Validate(); err == nil {
+				t.Fatalf("expected validation error, got nil")
+			}
+		})
+	}
+}
+
+func TestRenderRules(t *testing.T) {
+	s := Spec{SLOs: []SLO{
+		{Name: "dowork-availability", Objective: 0.99, SLI: SLI{Type: SLIAvailability, Metric: "cno_app_requests_total"}},
+		{Name: "dowork-latency", Objective: 0.95, SLI: SLI{Type: SLILatency, Metric: "cno_app_request_latency_seconds", Threshold: 5}},
+	}}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("Validate returned error: %v", err)
+	}
+
+	b, err := s.RenderRules()
+	if err != nil {
+		t.Fatalf("RenderRules returned error: %v", err)
+	}
+
+	var rf RuleFile
+	if err := yaml.Unmarshal(b, &rf); err != nil {
+		t.Fatalf("rendered rules are not valid YAML: %v", err)
+	}
+	if len(rf.Groups) != 4 {
+		t.Fatalf("expected 4 groups (recording+alerts per slo), got %d", len(rf.Groups))
+	}
+
+	out := string(b)
+	for _, want := range []string{
+		"slo:dowork_availability:error_ratio_rate1h > 0.144",
+		`code!~"OK|[23].."`,
+		`cno_app_request_latency_seconds_bucket{le="5"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("rendered rules should contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package tracereport
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrTraceNotFound は Tempo にトレースが(まだ)無いことを表す。取り込みの遅延で直後は見つからないことがある
+var ErrTraceNotFound = errors.New("trace not found")
+
+// Client は Tempo の HTTP API クライアント
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient は baseURL(例: http://localhost:3200)の Tempo に問い合わせる Client を返す
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Trace は GET /api/traces/<traceID> でトレースを取得し、span の一覧を返す
+func (c *Client) Trace(ctx context.Context, traceID string) ([]Span, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/traces/"+traceID, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("tempo: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("tempo: %s: %w", traceID, ErrTraceNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("tempo: GET /api/traces/%s: %s: %s", traceID, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return ParseTempoTrace(resp.Body)
+}
+
+// Tempo の /api/traces が返す OTLP の JSON 表現。
+// バージョンによって batches / resourceSpans、scopeSpans / instrumentationLibrarySpans のどちらかで返る
+type tempoTrace struct {
+	Batches       []tempoResourceSpans `json:"batches"`
+	ResourceSpans []tempoResourceSpans `json:"resourceSpans"`
+}
+
+type tempoResourceSpans struct {
+	Resource struct {
+		Attributes []tempoKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans                  []tempoScopeSpans `json:"scopeSpans"`
+	InstrumentationLibrarySpans []tempoScopeSpans `json:"instrumentationLibrarySpans"`
+}
+
+type tempoScopeSpans struct {
+	Spans []tempoSpan `json:"spans"`
+}
+
+type tempoSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId"`
+	Name              string          `json:"name"`
+	Kind              string          `json:"kind"`
+	StartTimeUnixNano json.RawMessage `json:"startTimeUnixNano"`
+	EndTimeUnixNano   json.RawMessage `json:"endTimeUnixNano"`
+	Attributes        []tempoKeyValue `json:"attributes"`
+}
+
+type tempoKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string         `json:"stringValue"`
+		IntValue    json.RawMessage `json:"intValue"`
+		DoubleValue *float64        `json:"doubleValue"`
+		BoolValue   *bool           `json:"boolValue"`
+	} `json:"value"`
+}
+
+// ParseTempoTrace は Tempo の /api/traces のレスポンスを span の一覧に変換する
+func ParseTempoTrace(r io.Reader) ([]Span, error) {
+	var t tempoTrace
+	if err := json.NewDecoder(r).Decode(&t); err != nil {
+		return nil, fmt.Errorf("tempo: decode trace: %w", err)
+	}
+
+	var spans []Span
+	for _, rs := range append(t.Batches, t.ResourceSpans...) {
+		service, _ := attributes(rs.Resource.Attributes)["service.name"].(string)
+		for _, ss := range append(rs.ScopeSpans, rs.InstrumentationLibrarySpans...) {
+			for _, ts := range ss.Spans {
+				s, err := ts.span(service)
+				if err != nil {
+					return nil, err
+				}
+				spans = append(spans, s)
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return nil, ErrTraceNotFound
+	}
+	return spans, nil
+}
+
+func (ts tempoSpan) span(service string) (Span, error) {
+	start, err := unixNano(ts.StartTimeUnixNano)
+	if err != nil {
+		return Span{}, fmt.Errorf("tempo: span %q: startTimeUnixNano: %w", ts.Name, err)
+	}
+	end, err := unixNano(ts.EndTimeUnixNano)
+	if err != nil {
+		return Span{}, fmt.Errorf("tempo: span %q: endTimeUnixNano: %w", ts.Name, err)
+	}
+	return Span{
+		TraceID:      spanID(ts.TraceID),
+		SpanID:       spanID(ts.SpanID),
+		ParentSpanID: spanID(ts.ParentSpanID),
+		Name:         ts.Name,
+		Service:      service,
+		Kind:         spanKind(ts.Kind),
+		Start:        start,
+		End:          end,
+		Attributes:   attributes(ts.Attributes),
+	}, nil
+}
+
+// spanID は Tempo の JSON の ID(protobuf の bytes なので base64)を 16 進表記にそろえる。既に 16 進ならそのまま返す
+func spanID(s string) string {
+	if s == "" {
+		return ""
+	}
+	if _, err := hex.DecodeString(s); err == nil && (len(s) == 16 || len(s) == 32) {
+		return s
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return hex.EncodeToString(b)
+	}
+	return s
+}
+
+// spanKind は "SPAN_KIND_SERVER" や数値の 2 を "server" にそろえる
+func spanKind(k string) string {
+	switch strings.TrimPrefix(strings.ToUpper(k), "SPAN_KIND_") {
+	case "INTERNAL", "1":
+		return KindInternal
+	case "SERVER", "2":
+		return KindServer
+	case "CLIENT", "3":
+		return KindClient
+	case "PRODUCER", "4":
+		return "producer"
+	case "CONSUMER", "5":
+		return "consumer"
+	default:
+		return KindInternal
+	}
+}
+
+// unixNano は文字列または数値のナノ秒を time.Time に変換する(protobuf の JSON では int64 は文字列)
+func unixNano(raw json.RawMessage) (time.Time, error) {
+	n, err := strconv.ParseInt(strings.Trim(string(raw), `"`), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n), nil
+}
+
+func attributes(kvs []tempoKeyValue) map[string]any {
+	out := make(map[string]any, len(kvs))
+	for _, kv := range kvs {
+		v := kv.Value
+		switch {
+		case v.StringValue != nil:
+			out[kv.Key] = *v.StringValue
+		case v.IntValue != nil:
+			if n, err := strconv.ParseInt(strings.Trim(string(v.IntValue), `"`), 10, 64); err == nil {
+				out[kv.Key] = n
+			}
+		case v.DoubleValue != nil:
+			out[kv.Key] = *v.DoubleValue
+		case v.BoolValue != nil:
+			out[kv.Key] = *v.BoolValue
+		}
+	}
+	return out
+}
@@ -0,0 +1,214 @@
+// Package tracereport は Tempo から取得したトレースを、クライアントの処理・ネットワーク・サーバーの処理・
+// 注入した遅延(latency_ms や疑似 downstream など)に分けて集計する。
+// 「どこで時間を使っているか」をトレースだけで説明するレイテンシの内訳レポート(client trace-report)に使う
+package tracereport
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	appserver "github.com/shtsukada/cloudnative-observability-app/pkg/server"
+)
+
+// Span.Kind の値
+const (
+	KindInternal = "internal"
+	KindServer   = "server"
+	KindClient   = "client"
+)
+
+// Span はトレース中の 1 span
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Service      string // resource の service.name
+	Kind         string
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]any
+}
+
+// Duration は span の長さ
+func (s Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// isGRPC は otelgrpc が作った RPC の span かどうか。疑似 downstream の client span などを除くため rpc.system で判定する
+func (s Span) isGRPC() bool {
+	return s.Attributes["rpc.system"] == "grpc"
+}
+
+// serverTiming はサーバーの span に載せた server_timing.<区間>_ms 属性を返す
+func (s Span) serverTiming(phase string) time.Duration {
+	ms, _ := s.Attributes[appserver.SpanAttributePrefix+phase+"_ms"].(float64)
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// injectedPhases は負荷の実験のために意図的に入れた待ち時間の区間
+var injectedPhases = []string{appserver.TimingSteal, appserver.TimingConsume, appserver.TimingDependency, appserver.TimingStartAt, appserver.TimingLatency}
+
+// serverPhases はサーバー自身の処理の区間
+var serverPhases = []string{appserver.TimingQueue, appserver.TimingValidate, appserver.TimingLoad}
+
+// Breakdown はトレース全体の時間の内訳。RPC が複数ある場合は合計する
+type Breakdown struct {
+	Total time.Duration // トレースの最初の span の開始から最後の span の終了まで
+	// ClientOverhead は Total のうち gRPC の呼び出しの外側(リクエストの組み立て、ログ出力など)
+	ClientOverhead time.Duration
+	// Network はクライアントの RPC span からサーバーの RPC span を引いた残り(送受信、シリアライズ、キューイング)
+	Network time.Duration
+	// Server はサーバーの RPC span から Injected を引いた残り
+	Server time.Duration
+	// Injected は latency_ms、疑似 downstream、noisy neighbor、遅い consumer で意図的に入れた待ち時間
+	Injected time.Duration
+
+	// ServerPhases / InjectedPhases は server_timing 属性の区間ごとの内訳
+	ServerPhases   map[string]time.Duration
+	InjectedPhases map[string]time.Duration
+
+	RPCs int // クライアントとサーバーの span の組を見つけた RPC の数
+}
+
+// Report は 1 トレース分のレポート
+type Report struct {
+	TraceID   string
+	Spans     []Span
+	Breakdown Breakdown
+}
+
+// Analyze は spans を集計してレポートを作る。
+// クライアントの RPC span(kind=client, rpc.system=grpc)と、その子のサーバーの RPC span(kind=server)を組にして
+// 差分を取る。サーバーの span が見つからない RPC(サーバー側でサンプリングされなかったなど)は Network に含める
+func Analyze(spans []Span) Report {
+	r := Report{Spans: spans}
+	b := Breakdown{ServerPhases: map[string]time.Duration{}, InjectedPhases: map[string]time.Duration{}}
+	if len(spans) == 0 {
+		r.Breakdown = b
+		return r
+	}
+	r.TraceID = spans[0].TraceID
+
+	start, end := spans[0].Start, spans[0].End
+	servers := map[string][]Span{} // 親の span ID → サーバーの RPC span
+	for _, s := range spans {
+		if s.Start.Before(start) {
+			start = s.Start
+		}
+		if s.End.After(end) {
+			end = s.End
+		}
+		if s.Kind == KindServer && s.isGRPC() {
+			servers[s.ParentSpanID] = append(servers[s.ParentSpanID], s)
+		}
+	}
+	b.Total = end.Sub(start)
+
+	var clientRPC, serverRPC time.Duration
+	for _, c := range spans {
+		if c.Kind != KindClient || !c.isGRPC() {
+			continue
+		}
+		clientRPC += c.Duration()
+		for _, s := range servers[c.SpanID] {
+			b.RPCs++
+			serverRPC += s.Duration()
+			for _, p := range injectedPhases {
+				if d := s.serverTiming(p); d > 0 {
+					b.InjectedPhases[p] += d
+					b.Injected += d
+				}
+			}
+			for _, p := range serverPhases {
+				if d := s.serverTiming(p); d > 0 {
+					b.ServerPhases[p] += d
+				}
+			}
+		}
+	}
+
+	b.ClientOverhead = max(b.Total-clientRPC, 0)
+	b.Network = max(clientRPC-serverRPC, 0)
+	b.Server = max(serverRPC-b.Injected, 0)
+	r.Breakdown = b
+	return r
+}
+
+// WriteText は span の木と内訳を人が読む形式で w に書く
+func (r Report) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "trace %s (%d spans, %d rpcs)\n\n", r.TraceID, len(r.Spans), r.Breakdown.RPCs)
+
+	r.writeTree(&sb)
+
+	b := r.Breakdown
+	sb.WriteString("\nlatency attribution\n")
+	line := func(indent, name string, d time.Duration) {
+		pct := 0.0
+		if b.Total > 0 {
+			pct = float64(d) / float64(b.Total) * 100
+		}
+		fmt.Fprintf(&sb, "%s%-*s %10.2fms %6.1f%%\n", indent, 20-len(indent), name, ms(d), pct)
+	}
+	line("  ", "total", b.Total)
+	line("  ", "client overhead", b.ClientOverhead)
+	line("  ", "network", b.Network)
+	line("  ", "server", b.Server)
+	for _, p := range serverPhases {
+		if d, ok := b.ServerPhases[p]; ok {
+			line("    ", p, d)
+		}
+	}
+	line("  ", "injected", b.Injected)
+	for _, p := range injectedPhases {
+		if d, ok := b.InjectedPhases[p]; ok {
+			line("    ", p, d)
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// writeTree は親子関係に沿って span を開始時刻順に字下げして書く
+func (r Report) writeTree(sb *strings.Builder) {
+	ids := make(map[string]bool, len(r.Spans))
+	for _, s := range r.Spans {
+		ids[s.SpanID] = true
+	}
+	children := map[string][]Span{}
+	var roots []Span
+	for _, s := range r.Spans {
+		if s.ParentSpanID == "" || !ids[s.ParentSpanID] {
+			roots = append(roots, s)
+			continue
+		}
+		children[s.ParentSpanID] = append(children[s.ParentSpanID], s)
+	}
+	byStart := func(ss []Span) {
+		sort.SliceStable(ss, func(i, j int) bool { return ss[i].Start.Before(ss[j].Start) })
+	}
+	byStart(roots)
+
+	var walk func(s Span, depth int)
+	walk = func(s Span, depth int) {
+		name := strings.Repeat("  ", depth) + s.Name
+		fmt.Fprintf(sb, "  %-60s %-16s %-8s %10.2fms\n", name, s.Service, s.Kind, ms(s.Duration()))
+		cs := children[s.SpanID]
+		byStart(cs)
+		for _, c := range cs {
+			walk(c, depth+1)
+		}
+	}
+	for _, s := range roots {
+		walk(s, 0)
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,113 @@
+package tracereport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadSpans(t *testing.T) []Span {
+	t.Helper()
+	f, err := os.Open("testdata/tempo_trace.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	spans, err := ParseTempoTrace(f)
+	if err != nil {
+		t.Fatalf("ParseTempoTrace: %v", err)
+	}
+	return spans
+}
+
+func TestParseTempoTrace(t *testing.T) {
+	spans := loadSpans(t)
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans, want 4", len(spans))
+	}
+
+	s := spans[2]
+	if s.TraceID != "0af7651916cd43dd8448eb211c80319c" || s.SpanID != "53995c3f42cd8ad8" || s.ParentSpanID != "00f067aa0ba902b7" {
+		t.Fatalf("ids = %s/%s/%s, want hex ids decoded from base64", s.TraceID, s.SpanID, s.ParentSpanID)
+	}
+	if s.Service != "cno-app" || s.Kind != KindServer {
+		t.Fatalf("service/kind = %s/%s", s.Service, s.Kind)
+	}
+	if s.Duration() != 260*time.Millisecond {
+		t.Fatalf("duration = %s", s.Duration())
+	}
+	if got := spans[0].Attributes["latency_ms"]; got != int64(300) {
+		t.Fatalf("int attribute = %#v", got)
+	}
+}
+
+// クライアントの外側 20ms、ネットワーク 20ms、サーバー 260ms のうち注入した遅延(dependency 50ms + latency 100ms)を分ける
+func TestAnalyze(t *testing.T) {
+	b := Analyze(loadSpans(t)).Breakdown
+
+	want := Breakdown{
+		Total:          300 * time.Millisecond,
+		ClientOverhead: 20 * time.Millisecond,
+		Network:        20 * time.Millisecond,
+		Server:         110 * time.Millisecond,
+		Injected:       150 * time.Millisecond,
+		RPCs:           1,
+	}
+	if b.Total != want.Total || b.ClientOverhead != want.ClientOverhead || b.Network != want.Network ||
+		b.Server != want.Server || b.Injected != want.Injected || b.RPCs != want.RPCs {
+		t.Fatalf("breakdown = %+v, want %+v", b, want)
+	}
+	if b.InjectedPhases["dependency"] != 50*time.Millisecond || b.ServerPhases["load"] != 90*time.Millisecond {
+		t.Fatalf("phases = %v / %v", b.InjectedPhases, b.ServerPhases)
+	}
+}
+
+func TestReport_WriteText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Analyze(loadSpans(t)).WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"trace 0af7651916cd43dd8448eb211c80319c (4 spans, 1 rpcs)",
+		"      dependency fake-downstream",
+		"  network                 20.00ms    6.7%",
+		"    latency              100.00ms   33.3%",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestClient_Trace(t *testing.T) {
+	body, err := os.ReadFile("testdata/tempo_trace.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/traces/0af7651916cd43dd8448eb211c80319c" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL + "/")
+	spans, err := c.Trace(context.Background(), "0af7651916cd43dd8448eb211c80319c")
+	if err != nil || len(spans) != 4 {
+		t.Fatalf("Trace: %d spans, err = %v", len(spans), err)
+	}
+	if _, err := c.Trace(context.Background(), "ffffffffffffffffffffffffffffffff"); !errors.Is(err, ErrTraceNotFound) {
+		t.Fatalf("Trace(unknown): err = %v, want ErrTraceNotFound", err)
+	}
+}
@@ -0,0 +1,143 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+// workMethods は通知の対象にする RPC(負荷を実行するもの)
+var workMethods = map[string]bool{
+	grpcburnerv1.Burner_DoWork_FullMethodName:                true,
+	grpcburnerv1.Burner_DoWorkServerStreaming_FullMethodName: true,
+	grpcburnerv1.Burner_DoWorkClientStreaming_FullMethodName: true,
+	grpcburnerv1.Burner_DoWorkBidiStreaming_FullMethodName:   true,
+}
+
+// UnaryServerInterceptor は DoWork の終了時に通知する
+func UnaryServerInterceptor(n *Notifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !workMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		t := &tally{}
+		t.requestID(req)
+		t.observe(resp)
+		n.Notify(t.event(ctx, info.FullMethod, err, start))
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor は DoWork の Streaming RPC の終了時に、送った結果を集計して通知する
+func StreamServerInterceptor(n *Notifier) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !workMethods[info.FullMethod] {
+			return handler(srv, ss)
+		}
+		start := time.Now()
+		ts := &tallyStream{ServerStream: ss}
+		err := handler(srv, ts)
+		n.Notify(ts.t.event(ss.Context(), info.FullMethod, err, start))
+		return err
+	}
+}
+
+// tally は 1 回の RPC で返した負荷の結果を数える
+type tally struct {
+	reqID    string
+	messages int
+	failed   int
+	errMsg   string
+}
+
+func (t *tally) requestID(msg any) {
+	if r, ok := msg.(interface{ GetRequestId() string }); ok && t.reqID == "" {
+		t.reqID = r.GetRequestId()
+	}
+}
+
+// observe は送った応答を数える。DoWorkSummary(Client Streaming)はその集計をそのまま使う
+func (t *tally) observe(msg any) {
+	switch m := msg.(type) {
+	case *grpcburnerv1.DoWorkSummary:
+		t.messages += int(m.GetTotal())
+		t.failed += int(m.GetFailed())
+	case *grpcburnerv1.DoWorkResponse:
+		t.messages++
+		if !m.GetOk() {
+			t.failed++
+			if t.errMsg == "" {
+				t.errMsg = m.GetErrorMessage()
+			}
+		}
+	default:
+		return
+	}
+	t.requestID(msg)
+}
+
+func (t *tally) event(ctx context.Context, method string, err error, start time.Time) Event {
+	end := time.Now()
+	ev := Event{
+		Event:          EventFinished,
+		Method:         method,
+		RequestID:      t.reqID,
+		Code:           status.Code(err).String(),
+		Error:          t.errMsg,
+		Messages:       t.messages,
+		FailedMessages: t.failed,
+		StartedAt:      start.UTC(),
+		FinishedAt:     end.UTC(),
+		DurationMs:     float64(end.Sub(start).Microseconds()) / 1000,
+		Instance:       observability.ServiceInstanceID(),
+	}
+	if ev.RequestID == "" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get("x-request-id"); len(v) > 0 {
+				ev.RequestID = v[0]
+			}
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		ev.TraceID = sc.TraceID().String()
+	}
+	if err != nil {
+		ev.Error = status.Convert(err).Message()
+	}
+	if err != nil || t.failed > 0 {
+		ev.Event = EventFailed
+	}
+	return ev
+}
+
+// tallyStream は送受信したメッセージを数える ServerStream
+type tallyStream struct {
+	grpc.ServerStream
+	t tally
+}
+
+func (s *tallyStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.t.observe(m)
+	}
+	return err
+}
+
+func (s *tallyStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.t.requestID(m)
+	}
+	return err
+}
@@ -0,0 +1,250 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/apperrors"
+)
+
+const (
+	// ServiceName は WebhookService のサービス名
+	ServiceName = "cno.app.v1.WebhookService"
+
+	RegisterWebhookFullMethodName   = "/" + ServiceName + "/RegisterWebhook"
+	UnregisterWebhookFullMethodName = "/" + ServiceName + "/UnregisterWebhook"
+	ListWebhooksFullMethodName      = "/" + ServiceName + "/ListWebhooks"
+)
+
+// WebhookServer は WebhookService のサーバー実装が満たすインターフェース。WebhookService は通知先を実行中に登録・削除・一覧する。
+// 任意の URL へサーバーから POST させられるため、一覧も含めて全ての RPC に admin の資格情報を求める。
+// proto リポジトリに RPC を追加するまでの間、clientconfig と同じく Struct / Empty で受け渡す手書きの ServiceDesc にしている。
+//
+//   - RegisterWebhook : {"url": "...", "events": ["work.failed"]}(events は省略可)
+//   - UnregisterWebhook : {"url": "..."}
+//   - ListWebhooks : {"webhooks": [{"url", "events", "source"}]}
+type WebhookServer interface {
+	RegisterWebhook(context.Context, *structpb.Struct) (*emptypb.Empty, error)
+	UnregisterWebhook(context.Context, *structpb.Struct) (*emptypb.Empty, error)
+	ListWebhooks(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+}
+
+// Authorizer は RPC の呼び出し元を認証し、監査ログに残す操作者を返す。
+// cmd/server は admin リスナーと同じ資格情報(metadata authorization の Bearer / Basic)で判定する
+type Authorizer func(ctx context.Context) (principal string, ok bool)
+
+// Server は Notifier の通知先を操作する WebhookServer
+type Server struct {
+	n         *Notifier
+	authorize Authorizer
+}
+
+// NewServer は n の通知先を操作する WebhookService の実装を返す。authorize が nil なら全ての呼び出しを拒否する
+func NewServer(n *Notifier, authorize Authorizer) *Server {
+	return &Server{n: n, authorize: authorize}
+}
+
+// errUnauthenticated は WebhookService の資格情報が無い/一致しない時のエラー
+var errUnauthenticated = &apperrors.Error{
+	Category: apperrors.Validation,
+	Reason:   "admin_unauthenticated",
+	Code:     codes.Unauthenticated,
+	Err:      errors.New("admin credentials are required"),
+}
+
+func (s *Server) principal(ctx context.Context) (string, error) {
+	if s.authorize == nil {
+		return "", errUnauthenticated
+	}
+	p, ok := s.authorize(ctx)
+	if !ok {
+		return "", errUnauthenticated
+	}
+	return p, nil
+}
+
+// audit は通知先の登録・削除を監査ログ(audit=true)に残す
+func (s *Server) audit(action, url, principal string) {
+	if s.n.logger == nil {
+		return
+	}
+	s.n.logger.Warnw("webhook "+action, "audit", true, "url", url, "principal", principal)
+}
+
+// errRPCDisabled は CNO_APP_WEBHOOK_RPC が無効な時の登録・削除のエラー
+var errRPCDisabled = &apperrors.Error{
+	Category: apperrors.Validation,
+	Reason:   "webhook_rpc_disabled",
+	Code:     codes.FailedPrecondition,
+	Err:      errors.New("webhook registration over RPC is disabled (set " + envWebhookRPC + "=true)"),
+}
+
+// RegisterWebhook は通知先を登録する。登録できる数を超えたら RESOURCE_EXHAUSTED、許可されていない送信先なら PERMISSION_DENIED
+func (s *Server) RegisterWebhook(ctx context.Context, in *structpb.Struct) (*emptypb.Empty, error) {
+	principal, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !s.n.AllowRPC() {
+		return nil, errRPCDisabled
+	}
+	t := Target{URL: in.GetFields()["url"].GetStringValue()}
+	for _, v := range in.GetFields()["events"].GetListValue().GetValues() {
+		t.Events = append(t.Events, v.GetStringValue())
+	}
+	if err := s.n.RegisterRPC(t); err != nil {
+		switch {
+		case errors.Is(err, ErrTooManyTargets):
+			return nil, apperrors.WithReason(apperrors.Limit, "too_many_webhooks", err)
+		case errors.Is(err, ErrDestinationNotAllowed):
+			return nil, &apperrors.Error{
+				Category: apperrors.Validation,
+				Reason:   "webhook_destination_not_allowed",
+				Code:     codes.PermissionDenied,
+				Err:      err,
+			}
+		}
+		return nil, apperrors.WithReason(apperrors.Validation, "invalid_webhook", err)
+	}
+	s.audit("registered", t.URL, principal)
+	return &emptypb.Empty{}, nil
+}
+
+// UnregisterWebhook は通知先を削除する。登録されていなければ NotFound
+func (s *Server) UnregisterWebhook(ctx context.Context, in *structpb.Struct) (*emptypb.Empty, error) {
+	principal, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !s.n.AllowRPC() {
+		return nil, errRPCDisabled
+	}
+	u := in.GetFields()["url"].GetStringValue()
+	if !s.n.Unregister(u) {
+		return nil, &apperrors.Error{
+			Category: apperrors.Validation,
+			Reason:   "webhook_not_found",
+			Code:     codes.NotFound,
+			Err:      fmt.Errorf("webhook %q is not registered", u),
+		}
+	}
+	s.audit("unregistered", u, principal)
+	return &emptypb.Empty{}, nil
+}
+
+// ListWebhooks は登録されている通知先を返す。資格情報があれば RPC での登録が無効でも参照できる
+func (s *Server) ListWebhooks(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	if _, err := s.principal(ctx); err != nil {
+		return nil, err
+	}
+	targets := s.n.Targets()
+	list := make([]any, len(targets))
+	for i, t := range targets {
+		events := make([]any, len(t.Events))
+		for j, e := range t.Events {
+			events[j] = e
+		}
+		list[i] = map[string]any{"url": t.URL, "events": events, "source": t.Source}
+	}
+	return structpb.NewStruct(map[string]any{"webhooks": list})
+}
+
+// Register は WebhookService を gRPC サーバーに登録する
+func Register(s grpc.ServiceRegistrar, srv WebhookServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*WebhookServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RegisterWebhook",
+			Handler:    registerWebhookHandler,
+		},
+		{
+			MethodName: "UnregisterWebhook",
+			Handler:    unregisterWebhookHandler,
+		},
+		{
+			MethodName: "ListWebhooks",
+			Handler:    listWebhooksHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func registerWebhookHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WebhookServer).RegisterWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RegisterWebhookFullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WebhookServer).RegisterWebhook(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func unregisterWebhookHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WebhookServer).UnregisterWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UnregisterWebhookFullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WebhookServer).UnregisterWebhook(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func listWebhooksHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WebhookServer).ListWebhooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ListWebhooksFullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WebhookServer).ListWebhooks(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
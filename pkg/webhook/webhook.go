@@ -0,0 +1,572 @@
+// Package webhook は負荷の実行(DoWork 系の RPC)が終わった時・失敗した時に、
+// 登録した URL へ署名付きの JSON を POST で通知する。Slack への中継や CI など、外部のシステムがバーナーの動きに反応できるようにする
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/shtsukada/cloudnative-observability-app/pkg/observability"
+)
+
+const (
+	envWebhookURLs        = "CNO_APP_WEBHOOK_URLS"
+	envWebhookEvents      = "CNO_APP_WEBHOOK_EVENTS"
+	envWebhookSecret      = "CNO_APP_WEBHOOK_SECRET"
+	envWebhookTimeout     = "CNO_APP_WEBHOOK_TIMEOUT"
+	envWebhookMaxAttempts = "CNO_APP_WEBHOOK_MAX_ATTEMPTS"
+	envWebhookRPC         = "CNO_APP_WEBHOOK_RPC"
+	envWebhookRPCMax      = "CNO_APP_WEBHOOK_RPC_MAX_TARGETS"
+	envWebhookRPCHosts    = "CNO_APP_WEBHOOK_RPC_ALLOWED_HOSTS"
+
+	defaultTimeout     = 5 * time.Second
+	defaultMaxAttempts = 3
+	// defaultRPCMaxTargets は RPC で登録できる通知先の数の既定の上限(起動時の設定の分は数えない)
+	defaultRPCMaxTargets = 8
+
+	// queueSize は送信待ちの通知の上限。埋まっている間の通知は捨てる(RPC の処理を待たせない)
+	queueSize = 256
+	// workers は同時に送信する数
+	workers = 2
+	// retryBackoff は 1 回目の再送までの待ち時間。以降は 2 倍ずつ増やす
+	retryBackoff = 500 * time.Millisecond
+
+	// SignatureHeader は本文の署名を載せるヘッダー。"t=<unix 秒>,v1=<hex>" の形で、
+	// v1 は CNO_APP_WEBHOOK_SECRET を鍵にした HMAC-SHA256("<t>.<本文>")
+	SignatureHeader = "X-Cno-Signature"
+	// EventHeader / DeliveryHeader はイベントの種類と、再送でも変わらない配信の ID
+	EventHeader    = "X-Cno-Event"
+	DeliveryHeader = "X-Cno-Delivery"
+)
+
+// 通知するイベントの種類
+const (
+	EventFinished = "work.finished" // 全ての負荷が成功した
+	EventFailed   = "work.failed"   // RPC がエラーになった、または ok=false の負荷があった
+)
+
+var allEvents = []string{EventFinished, EventFailed}
+
+// Config は通知の設定。
+//
+//   - CNO_APP_WEBHOOK_URLS : 起動時に登録する通知先(カンマ区切りの http(s) URL)
+//   - CNO_APP_WEBHOOK_EVENTS : CNO_APP_WEBHOOK_URLS に送るイベント(既定は work.finished,work.failed)
+//   - CNO_APP_WEBHOOK_SECRET : 署名の鍵。空なら署名ヘッダーを付けない
+//   - CNO_APP_WEBHOOK_TIMEOUT : 1 回の送信のタイムアウト(既定 5s)
+//   - CNO_APP_WEBHOOK_MAX_ATTEMPTS : 再送を含めた送信回数の上限(既定 3)。接続エラー・5xx・429 の時に再送する
+//   - CNO_APP_WEBHOOK_RPC : true なら WebhookService の RPC で通知先を登録・削除できる(既定 false)。
+//     任意の URL へサーバーから POST させられるため、信頼できるネットワークの中だけで有効にする
+//   - CNO_APP_WEBHOOK_RPC_MAX_TARGETS : RPC で登録できる通知先の数の上限(既定 8)
+//   - CNO_APP_WEBHOOK_RPC_ALLOWED_HOSTS : RPC で登録できる通知先のホスト名(カンマ区切り)。
+//     空ならどのホストでもよいが、ループバック・プライベート・リンクローカルなどのアドレスへは送らない(SSRF 対策)。
+//     指定した場合はそのホストだけを受け付け、アドレスの制限はかけない(クラスタ内の中継先を明示的に許可する)
+type Config struct {
+	Targets         []Target
+	Secret          string
+	Timeout         time.Duration
+	MaxAttempts     int
+	AllowRPC        bool
+	RPCMaxTargets   int
+	RPCAllowedHosts []string
+}
+
+// Target は 1 つの通知先
+type Target struct {
+	URL string `json:"url"`
+	// Events は送るイベントの種類。空なら全て
+	Events []string `json:"events"`
+	// Source は登録した経路(SourceConfig か SourceRPC)
+	Source string `json:"source"`
+}
+
+// 通知先を登録した経路
+const (
+	SourceConfig = "config"
+	SourceRPC    = "rpc"
+)
+
+// ConfigFromEnv は環境変数から通知の設定を読み取って検証する
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Secret:        os.Getenv(envWebhookSecret),
+		Timeout:       defaultTimeout,
+		MaxAttempts:   defaultMaxAttempts,
+		RPCMaxTargets: defaultRPCMaxTargets,
+	}
+	events, err := ParseEvents(os.Getenv(envWebhookEvents))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid %s: %w", envWebhookEvents, err)
+	}
+	for _, u := range strings.Split(os.Getenv(envWebhookURLs), ",") {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		if err := ValidateURL(u); err != nil {
+			return cfg, fmt.Errorf("invalid %s entry: %w", envWebhookURLs, err)
+		}
+		cfg.Targets = append(cfg.Targets, Target{URL: u, Events: events, Source: SourceConfig})
+	}
+	if v := os.Getenv(envWebhookTimeout); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid %s %q: want a positive duration", envWebhookTimeout, v)
+		}
+		cfg.Timeout = d
+	}
+	if v := os.Getenv(envWebhookMaxAttempts); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid %s %q: want a positive integer", envWebhookMaxAttempts, v)
+		}
+		cfg.MaxAttempts = n
+	}
+	if v := os.Getenv(envWebhookRPC); v != "" {
+		cfg.AllowRPC, err = strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s %q: %w", envWebhookRPC, v, err)
+		}
+	}
+	if v := os.Getenv(envWebhookRPCMax); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid %s %q: want a positive integer", envWebhookRPCMax, v)
+		}
+		cfg.RPCMaxTargets = n
+	}
+	for _, h := range strings.Split(os.Getenv(envWebhookRPCHosts), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			cfg.RPCAllowedHosts = append(cfg.RPCAllowedHosts, h)
+		}
+	}
+	return cfg, nil
+}
+
+// Enabled は通知先があるか、RPC で登録できるかどうかを返す
+func (c Config) Enabled() bool {
+	return len(c.Targets) > 0 || c.AllowRPC
+}
+
+// ValidateURL は通知先が http(s) の URL かどうかを確かめる
+func ValidateURL(u string) error {
+	p, err := url.Parse(u)
+	if err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return fmt.Errorf("%q: want an http(s) URL", u)
+	}
+	return nil
+}
+
+// ParseEvents はカンマ区切りのイベントの種類を検証する。空なら全て(nil)
+func ParseEvents(v string) ([]string, error) {
+	var events []string
+	for _, e := range strings.Split(v, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !slices.Contains(allEvents, e) {
+			return nil, fmt.Errorf("unknown event %q (expected %s)", e, strings.Join(allEvents, "|"))
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Event は通知の本文
+type Event struct {
+	ID        string `json:"id"`
+	Event     string `json:"event"`
+	Method    string `json:"method"`
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+	// Code は RPC のステータス。ok=false の負荷があっても RPC が成功していれば OK
+	Code  string `json:"code"`
+	Error string `json:"error,omitempty"`
+	// Messages / FailedMessages は負荷の数と、そのうち失敗した数(Unary は 1 件)
+	Messages       int       `json:"messages"`
+	FailedMessages int       `json:"failed_messages"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	DurationMs     float64   `json:"duration_ms"`
+	Instance       string    `json:"instance"`
+}
+
+// Sign は ts(Unix 秒)と本文から SignatureHeader の値を作る。受け取る側は同じ計算で照合し、t が古すぎるものは捨てる
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// delivery は 1 つの通知先への 1 件の通知
+type delivery struct {
+	id     string
+	event  string
+	target string
+	body   []byte
+	// guarded は送信先のアドレスを制限する(guardedDialControl)かどうか。
+	// RPC で登録され、CNO_APP_WEBHOOK_RPC_ALLOWED_HOSTS で明示的に許可されていない通知先
+	guarded bool
+}
+
+// Notifier は通知先を持ち、イベントをキューに入れてバックグラウンドで送る
+type Notifier struct {
+	cfg    Config
+	client *http.Client
+	// guardedClient は RPC で登録した通知先へ送るクライアント。名前解決した後のアドレスで送信先を制限する
+	guardedClient *http.Client
+	logger        *zap.SugaredLogger
+	backoff       time.Duration
+
+	targetsMu sync.RWMutex
+	targets   map[string]Target
+
+	// mu は queue への送信と close を排他にする
+	mu     sync.RWMutex
+	closed bool
+	queue  chan delivery
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewNotifier は cfg の通知先へ送る Notifier を返す。Close で送信待ちの通知を送ってから止める
+func NewNotifier(cfg Config, logger *zap.SugaredLogger) *Notifier {
+	if cfg.RPCMaxTargets <= 0 {
+		cfg.RPCMaxTargets = defaultRPCMaxTargets
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		guardedClient: &http.Client{
+			Timeout: cfg.Timeout,
+			// プロキシを経由すると接続先がプロキシになり、アドレスの制限が効かないため使わない
+			Transport: &http.Transport{
+				DialContext:         (&net.Dialer{Timeout: cfg.Timeout, Control: guardedDialControl}).DialContext,
+				TLSHandshakeTimeout: cfg.Timeout,
+			},
+			// リダイレクトで制限したアドレスへ飛ばされないよう、リダイレクトは追わない
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		logger:  logger,
+		backoff: retryBackoff,
+		targets: make(map[string]Target),
+		queue:   make(chan delivery, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, t := range cfg.Targets {
+		n.targets[t.URL] = t
+	}
+	observability.CNOAppWebhookTargets.Set(float64(len(n.targets)))
+	for range workers {
+		n.wg.Add(1)
+		go n.run()
+	}
+	return n
+}
+
+// AllowRPC は RPC での通知先の登録・削除を受け付けるかどうかを返す
+func (n *Notifier) AllowRPC() bool {
+	return n.cfg.AllowRPC
+}
+
+// ErrTooManyTargets は RPC で登録できる通知先の数(CNO_APP_WEBHOOK_RPC_MAX_TARGETS)を超えたことを表す
+var ErrTooManyTargets = errors.New("too many webhooks registered over RPC")
+
+// ErrDestinationNotAllowed は RPC で登録しようとした通知先が許可されていないことを表す
+var ErrDestinationNotAllowed = errors.New("webhook destination is not allowed")
+
+// RegisterRPC は RPC から通知先を登録する。Register に加えて、
+// CNO_APP_WEBHOOK_RPC_ALLOWED_HOSTS があればそのホストだけを、無ければ制限したアドレス(blockedAddr)以外を受け付け、
+// RPC で登録した通知先の数を CNO_APP_WEBHOOK_RPC_MAX_TARGETS までに抑える。
+// ホスト名の名前解決の結果は送信の時に確かめる(登録後に DNS の向き先を変えられても制限したアドレスへは送らない)
+func (n *Notifier) RegisterRPC(t Target) error {
+	if err := ValidateURL(t.URL); err != nil {
+		return err
+	}
+	if err := n.allowRPCDestination(t.URL); err != nil {
+		return err
+	}
+	t.Source = SourceRPC
+
+	n.targetsMu.Lock()
+	defer n.targetsMu.Unlock()
+	if cur, ok := n.targets[t.URL]; ok && cur.Source != SourceRPC {
+		return fmt.Errorf("%w: %q is registered by config", ErrDestinationNotAllowed, t.URL)
+	}
+	count := 0
+	for _, cur := range n.targets {
+		if cur.Source == SourceRPC && cur.URL != t.URL {
+			count++
+		}
+	}
+	if count >= n.cfg.RPCMaxTargets {
+		return fmt.Errorf("%w (max %d)", ErrTooManyTargets, n.cfg.RPCMaxTargets)
+	}
+	return n.registerLocked(t)
+}
+
+func (n *Notifier) allowRPCDestination(u string) error {
+	host := strings.ToLower(mustParseURL(u).Hostname())
+	if len(n.cfg.RPCAllowedHosts) > 0 {
+		if !slices.Contains(n.cfg.RPCAllowedHosts, host) {
+			return fmt.Errorf("%w: host %q is not in %s", ErrDestinationNotAllowed, host, envWebhookRPCHosts)
+		}
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %q is a loopback host", ErrDestinationNotAllowed, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && blockedAddr(addr) {
+		return fmt.Errorf("%w: %s is a loopback, private or link-local address", ErrDestinationNotAllowed, addr)
+	}
+	return nil
+}
+
+// guarded は t への送信で送信先のアドレスを制限するかどうかを返す
+func (n *Notifier) guarded(t Target) bool {
+	return t.Source == SourceRPC && len(n.cfg.RPCAllowedHosts) == 0
+}
+
+// cgnat はキャリアグレード NAT の範囲(クラスタ内のアドレスに使われることがある)
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// blockedAddr は RPC で登録した通知先として送らないアドレス(ループバック・プライベート・リンクローカル・未指定・マルチキャスト)
+func blockedAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsLoopback() || a.IsPrivate() || a.IsLinkLocalUnicast() || a.IsLinkLocalMulticast() ||
+		a.IsInterfaceLocalMulticast() || a.IsMulticast() || a.IsUnspecified() || cgnat.Contains(a)
+}
+
+// guardedDialControl は名前解決した後の接続先が blockedAddr なら接続しない
+func guardedDialControl(_, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrDestinationNotAllowed, address)
+	}
+	if blockedAddr(ap.Addr()) {
+		return fmt.Errorf("%w: %s is a loopback, private or link-local address", ErrDestinationNotAllowed, ap.Addr())
+	}
+	return nil
+}
+
+// mustParseURL は ValidateURL で検証済みの URL を解析する
+func mustParseURL(u string) *url.URL {
+	p, _ := url.Parse(u)
+	return p
+}
+
+// Register は通知先を登録する。同じ URL があれば置き換える
+func (n *Notifier) Register(t Target) error {
+	if err := ValidateURL(t.URL); err != nil {
+		return err
+	}
+	n.targetsMu.Lock()
+	defer n.targetsMu.Unlock()
+	return n.registerLocked(t)
+}
+
+// registerLocked は events を検証して t を登録する。targetsMu を保持して呼ぶ
+func (n *Notifier) registerLocked(t Target) error {
+	for _, e := range t.Events {
+		if !slices.Contains(allEvents, e) {
+			return fmt.Errorf("unknown event %q (expected %s)", e, strings.Join(allEvents, "|"))
+		}
+	}
+	n.targets[t.URL] = t
+	observability.CNOAppWebhookTargets.Set(float64(len(n.targets)))
+	return nil
+}
+
+// Unregister は通知先を削除し、登録されていたかどうかを返す
+func (n *Notifier) Unregister(u string) bool {
+	n.targetsMu.Lock()
+	defer n.targetsMu.Unlock()
+	_, ok := n.targets[u]
+	delete(n.targets, u)
+	observability.CNOAppWebhookTargets.Set(float64(len(n.targets)))
+	return ok
+}
+
+// Targets は登録されている通知先を URL 順に返す
+func (n *Notifier) Targets() []Target {
+	n.targetsMu.RLock()
+	defer n.targetsMu.RUnlock()
+	out := make([]Target, 0, len(n.targets))
+	for _, t := range n.targets {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	return out
+}
+
+// Notify は ev を、その種類を受け取る全ての通知先へ送るようキューに入れる。キューが埋まっていれば捨てる
+func (n *Notifier) Notify(ev Event) {
+	if ev.ID == "" {
+		ev.ID = uuid.New().String()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	for _, t := range n.Targets() {
+		if len(t.Events) > 0 && !slices.Contains(t.Events, ev.Event) {
+			continue
+		}
+		n.enqueue(delivery{id: ev.ID, event: ev.Event, target: t.URL, body: body, guarded: n.guarded(t)})
+	}
+}
+
+func (n *Notifier) enqueue(d delivery) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.queue <- d:
+	default:
+		observability.CNOAppWebhookDeliveriesTotal.WithLabelValues(d.event, "dropped").Inc()
+		if n.logger != nil {
+			n.logger.Warnw("webhook dropped", "url", d.target, "event", d.event, "delivery_id", d.id, "reason", "queue_full")
+		}
+	}
+}
+
+// Close は新しい通知を受け付けるのをやめ、送信待ちの通知を送り終えるか ctx が終わるまで待つ。
+// ctx が先に終わった時は、再送の待ちと送信中のリクエストを打ち切る
+func (n *Notifier) Close(ctx context.Context) {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		n.cancel()
+		<-done
+	}
+	n.cancel()
+}
+
+func (n *Notifier) run() {
+	defer n.wg.Done()
+	for d := range n.queue {
+		n.deliver(d)
+	}
+}
+
+// deliver は d を送り、接続エラー・5xx・429 なら MaxAttempts まで間隔を 2 倍にしながら再送する
+func (n *Notifier) deliver(d delivery) {
+	start := time.Now()
+	wait := n.backoff
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		var retryable bool
+		retryable, err = n.post(d)
+		if err == nil || !retryable || attempt >= n.cfg.MaxAttempts {
+			break
+		}
+		if n.logger != nil {
+			n.logger.Debugw("webhook retry", "url", d.target, "event", d.event, "delivery_id", d.id, "attempt", attempt, "error", err)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-n.ctx.Done():
+			t.Stop()
+		}
+		if n.ctx.Err() != nil {
+			break
+		}
+		wait *= 2
+	}
+
+	if err != nil {
+		observability.CNOAppWebhookDeliveriesTotal.WithLabelValues(d.event, "failure").Inc()
+		if n.logger != nil {
+			n.logger.Warnw("webhook delivery failed",
+				"url", d.target, "event", d.event, "delivery_id", d.id,
+				"attempts", attempt, "latency_ms", time.Since(start).Milliseconds(), "error", err)
+		}
+		return
+	}
+	observability.CNOAppWebhookDeliveriesTotal.WithLabelValues(d.event, "success").Inc()
+	if n.logger != nil {
+		n.logger.Debugw("webhook delivered",
+			"url", d.target, "event", d.event, "delivery_id", d.id,
+			"attempts", attempt, "latency_ms", time.Since(start).Milliseconds())
+	}
+}
+
+// post は 1 回送り、失敗した時は再送してよいかどうかも返す
+func (n *Notifier) post(d delivery) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, d.target, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cno-app/"+observability.ServiceVersion())
+	req.Header.Set(EventHeader, d.event)
+	req.Header.Set(DeliveryHeader, d.id)
+	if n.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(n.cfg.Secret, time.Now().Unix(), d.body))
+	}
+
+	client := n.client
+	if d.guarded {
+		client = n.guardedClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		observability.CNOAppWebhookAttemptsTotal.WithLabelValues("error").Inc()
+		// 許可されていない送信先は何度送っても同じ
+		return !errors.Is(err, ErrDestinationNotAllowed), err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	observability.CNOAppWebhookAttemptsTotal.WithLabelValues(strconv.Itoa(resp.StatusCode/100) + "xx").Inc()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
@@ -0,0 +1,409 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	grpcburnerv1 "github.com/shtsukada/cloudnative-observability-proto/gen/go/observability/grpcburner/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// received は受信側が受け取った 1 件の通知
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver は受け取った通知を記録し、statuses の順にステータスを返す(尽きたら 200)受信側を立てる
+func newReceiver(t *testing.T, statuses ...int) (*httptest.Server, func() []received) {
+	t.Helper()
+	var (
+		mu  sync.Mutex
+		got []received
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, received{header: r.Header.Clone(), body: body})
+		i := len(got) - 1
+		mu.Unlock()
+		if i < len(statuses) {
+			w.WriteHeader(statuses[i])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]received(nil), got...)
+	}
+}
+
+func newTestNotifier(t *testing.T, cfg Config) *Notifier {
+	t.Helper()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 1
+	}
+	n := NewNotifier(cfg, nil)
+	n.backoff = time.Millisecond
+	t.Cleanup(func() { closeNotifier(t, n) })
+	return n
+}
+
+func closeNotifier(t *testing.T, n *Notifier) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n.Close(ctx)
+}
+
+// 本文を署名して送り、受信側は同じ鍵で照合できる
+func TestNotifier_SignedDelivery(t *testing.T) {
+	srv, got := newReceiver(t)
+	n := newTestNotifier(t, Config{Targets: []Target{{URL: srv.URL}}, Secret: "s3cret"})
+	n.Notify(Event{Event: EventFailed, Method: "/m", RequestID: "req-1", Code: "INTERNAL"})
+	closeNotifier(t, n)
+
+	reqs := got()
+	if len(reqs) != 1 {
+		t.Fatalf("deliveries = %d, want 1", len(reqs))
+	}
+	r := reqs[0]
+	if r.header.Get(EventHeader) != EventFailed || r.header.Get(DeliveryHeader) == "" {
+		t.Fatalf("headers = %v", r.header)
+	}
+	sig := r.header.Get(SignatureHeader)
+	ts, err := strconv.ParseInt(strings.TrimPrefix(strings.SplitN(sig, ",", 2)[0], "t="), 10, 64)
+	if err != nil {
+		t.Fatalf("signature %q: %v", sig, err)
+	}
+	if want := Sign("s3cret", ts, r.body); sig != want {
+		t.Fatalf("signature = %q, want %q", sig, want)
+	}
+	var ev Event
+	if err := json.Unmarshal(r.body, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.RequestID != "req-1" || ev.ID != r.header.Get(DeliveryHeader) {
+		t.Fatalf("event = %+v", ev)
+	}
+}
+
+// 5xx は MaxAttempts まで再送し、4xx(429 以外)は再送しない
+func TestNotifier_Retries(t *testing.T) {
+	srv, got := newReceiver(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+	n := newTestNotifier(t, Config{Targets: []Target{{URL: srv.URL}}, MaxAttempts: 3})
+	n.Notify(Event{Event: EventFinished})
+	closeNotifier(t, n)
+	if reqs := got(); len(reqs) != 3 {
+		t.Fatalf("attempts = %d, want 3", len(reqs))
+	}
+	ids := map[string]bool{}
+	for _, r := range got() {
+		ids[r.header.Get(DeliveryHeader)] = true
+	}
+	if len(ids) != 1 {
+		t.Fatalf("delivery ids changed across retries: %v", ids)
+	}
+
+	srv, got = newReceiver(t, http.StatusBadRequest)
+	n = newTestNotifier(t, Config{Targets: []Target{{URL: srv.URL}}, MaxAttempts: 3})
+	n.Notify(Event{Event: EventFinished})
+	closeNotifier(t, n)
+	if reqs := got(); len(reqs) != 1 {
+		t.Fatalf("attempts on 400 = %d, want 1", len(reqs))
+	}
+}
+
+// 通知先ごとの events に含まれないイベントは送らない
+func TestNotifier_EventFilter(t *testing.T) {
+	all, gotAll := newReceiver(t)
+	failures, gotFailures := newReceiver(t)
+	n := newTestNotifier(t, Config{})
+	if err := n.Register(Target{URL: all.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Register(Target{URL: failures.URL, Events: []string{EventFailed}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Register(Target{URL: "ftp://example.com"}); err == nil {
+		t.Fatal("non-http URL accepted")
+	}
+	n.Notify(Event{Event: EventFinished})
+	n.Notify(Event{Event: EventFailed})
+	closeNotifier(t, n)
+
+	if len(gotAll()) != 2 || len(gotFailures()) != 1 {
+		t.Fatalf("deliveries all=%d failures=%d, want 2 and 1", len(gotAll()), len(gotFailures()))
+	}
+	if gotFailures()[0].header.Get(EventHeader) != EventFailed {
+		t.Fatal("failure-only target received work.finished")
+	}
+}
+
+// 送信が詰まっている間は RPC を待たせず、キューから溢れた通知を捨てる
+func TestNotifier_DropsWhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	var delivered atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		delivered.Add(1)
+	}))
+	defer srv.Close()
+	n := newTestNotifier(t, Config{Targets: []Target{{URL: srv.URL}}})
+
+	start := time.Now()
+	for range queueSize + workers + 10 {
+		n.Notify(Event{Event: EventFinished})
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("Notify blocked on a full queue")
+	}
+	close(release)
+	closeNotifier(t, n)
+	if got := delivered.Load(); got >= queueSize+workers+10 {
+		t.Fatalf("delivered = %d, want some dropped", got)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv(envWebhookURLs, "http://a.example/hook, https://b.example/hook")
+	t.Setenv(envWebhookEvents, "work.failed")
+	t.Setenv(envWebhookMaxAttempts, "5")
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Targets) != 2 || cfg.Targets[1].URL != "https://b.example/hook" || cfg.Targets[0].Events[0] != EventFailed {
+		t.Fatalf("targets = %+v", cfg.Targets)
+	}
+	if cfg.MaxAttempts != 5 || cfg.Timeout != defaultTimeout || !cfg.Enabled() {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+	if cfg.RPCMaxTargets != defaultRPCMaxTargets || cfg.RPCAllowedHosts != nil {
+		t.Fatalf("rpc limits = %d, %v", cfg.RPCMaxTargets, cfg.RPCAllowedHosts)
+	}
+	t.Setenv(envWebhookRPCMax, "2")
+	t.Setenv(envWebhookRPCHosts, "Relay.example, ,hooks.internal")
+	if cfg, err = ConfigFromEnv(); err != nil || cfg.RPCMaxTargets != 2 ||
+		!slices.Equal(cfg.RPCAllowedHosts, []string{"relay.example", "hooks.internal"}) {
+		t.Fatalf("rpc limits = %d, %v, %v", cfg.RPCMaxTargets, cfg.RPCAllowedHosts, err)
+	}
+
+	for env, v := range map[string]string{
+		envWebhookURLs:    "not a url",
+		envWebhookEvents:  "work.started",
+		envWebhookTimeout: "0s",
+		envWebhookRPC:     "maybe",
+		envWebhookRPCMax:  "0",
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, v)
+			if _, err := ConfigFromEnv(); err == nil {
+				t.Fatalf("%s=%q accepted", env, v)
+			}
+		})
+	}
+}
+
+// Streaming の DoWork は送った応答を集計し、ok=false があれば work.failed にする
+func TestStreamServerInterceptor(t *testing.T) {
+	srv, got := newReceiver(t)
+	n := newTestNotifier(t, Config{Targets: []Target{{URL: srv.URL}}})
+	interceptor := StreamServerInterceptor(n)
+	info := &grpc.StreamServerInfo{FullMethod: grpcburnerv1.Burner_DoWorkBidiStreaming_FullMethodName}
+	err := interceptor(nil, &fakeStream{}, info, func(_ any, ss grpc.ServerStream) error {
+		for i := range 3 {
+			_ = ss.SendMsg(&grpcburnerv1.DoWorkResponse{RequestId: "req-s", Ok: i != 1, ErrorMessage: "boom"})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 負荷以外の RPC は通知しない
+	_ = interceptor(nil, &fakeStream{}, &grpc.StreamServerInfo{FullMethod: "/grpc.health.v1.Health/Watch"},
+		func(any, grpc.ServerStream) error { return status.Error(codes.Internal, "x") })
+	closeNotifier(t, n)
+
+	reqs := got()
+	if len(reqs) != 1 {
+		t.Fatalf("deliveries = %d, want 1", len(reqs))
+	}
+	var ev Event
+	if err := json.Unmarshal(reqs[0].body, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Event != EventFailed || ev.Messages != 3 || ev.FailedMessages != 1 || ev.RequestID != "req-s" || ev.Code != "OK" || ev.Error != "boom" {
+		t.Fatalf("event = %+v", ev)
+	}
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+}
+
+func (*fakeStream) Context() context.Context { return context.Background() }
+func (*fakeStream) SendMsg(any) error        { return nil }
+
+// testAuthorizer は metadata authorization が "Bearer t" の呼び出しだけを受け付ける
+func testAuthorizer(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	vals := md.Get("authorization")
+	return "bearer", len(vals) == 1 && vals[0] == "Bearer t"
+}
+
+func authedContext() context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer t"))
+}
+
+// CNO_APP_WEBHOOK_RPC が無効なら RPC での登録を FailedPrecondition で断る
+func TestServer_RegisterWebhook(t *testing.T) {
+	in, _ := structpb.NewStruct(map[string]any{"url": "http://a.example/hook", "events": []any{EventFailed}})
+	ctx := authedContext()
+
+	disabled := NewServer(newTestNotifier(t, Config{}), testAuthorizer)
+	if _, err := disabled.RegisterWebhook(ctx, in); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("code = %v, want FailedPrecondition", status.Code(err))
+	}
+
+	n := newTestNotifier(t, Config{AllowRPC: true})
+	s := NewServer(n, testAuthorizer)
+	if _, err := s.RegisterWebhook(ctx, in); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.ListWebhooks(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := list.GetFields()["webhooks"].GetListValue().GetValues()
+	if len(hooks) != 1 || hooks[0].GetStructValue().GetFields()["source"].GetStringValue() != SourceRPC {
+		t.Fatalf("webhooks = %v", list)
+	}
+	if _, err := s.UnregisterWebhook(ctx, in); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UnregisterWebhook(ctx, in); status.Code(err) != codes.NotFound {
+		t.Fatalf("second unregister code = %v, want NotFound", status.Code(err))
+	}
+}
+
+// 一覧も含めて全ての RPC は資格情報が無ければ UNAUTHENTICATED で、authorize が nil なら全て拒否する
+func TestServer_Unauthenticated(t *testing.T) {
+	in, _ := structpb.NewStruct(map[string]any{"url": "http://a.example/hook"})
+	wrong := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer x"))
+	n := newTestNotifier(t, Config{AllowRPC: true})
+
+	for name, tt := range map[string]struct {
+		s   *Server
+		ctx context.Context
+	}{
+		"no credentials":    {NewServer(n, testAuthorizer), context.Background()},
+		"wrong credentials": {NewServer(n, testAuthorizer), wrong},
+		"nil authorizer":    {NewServer(n, nil), authedContext()},
+	} {
+		if _, err := tt.s.RegisterWebhook(tt.ctx, in); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("%s: RegisterWebhook = %v, want Unauthenticated", name, err)
+		}
+		if _, err := tt.s.UnregisterWebhook(tt.ctx, in); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("%s: UnregisterWebhook = %v, want Unauthenticated", name, err)
+		}
+		if _, err := tt.s.ListWebhooks(tt.ctx, nil); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("%s: ListWebhooks = %v, want Unauthenticated", name, err)
+		}
+	}
+	if len(n.Targets()) != 0 {
+		t.Fatalf("targets = %+v, want none", n.Targets())
+	}
+}
+
+// RPC で登録できる数は CNO_APP_WEBHOOK_RPC_MAX_TARGETS までで、同じ URL の置き換えと起動時の設定の分は数えない
+func TestServer_RegisterWebhookLimit(t *testing.T) {
+	n := newTestNotifier(t, Config{
+		AllowRPC:      true,
+		RPCMaxTargets: 2,
+		Targets:       []Target{{URL: "http://config.example/hook", Source: SourceConfig}},
+	})
+	s := NewServer(n, testAuthorizer)
+	register := func(u string) error {
+		in, _ := structpb.NewStruct(map[string]any{"url": u})
+		_, err := s.RegisterWebhook(authedContext(), in)
+		return err
+	}
+	for _, u := range []string{"http://a.example/hook", "http://b.example/hook", "http://a.example/hook"} {
+		if err := register(u); err != nil {
+			t.Fatalf("register %s: %v", u, err)
+		}
+	}
+	if err := register("http://c.example/hook"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("third target = %v, want ResourceExhausted", err)
+	}
+	if err := register("http://config.example/hook"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("config target = %v, want PermissionDenied", err)
+	}
+	if got := len(n.Targets()); got != 3 {
+		t.Fatalf("targets = %d, want 3", got)
+	}
+}
+
+// CNO_APP_WEBHOOK_RPC_ALLOWED_HOSTS が無ければループバックやプライベートのアドレスは登録できず、
+// 指定した場合はそのホストだけを受け付ける
+func TestServer_RegisterWebhookDestination(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		url     string
+		want    codes.Code
+	}{
+		{"public host", nil, "https://hooks.example/x", codes.OK},
+		{"loopback", nil, "http://127.0.0.1:8080/hook", codes.PermissionDenied},
+		{"localhost", nil, "http://localhost/hook", codes.PermissionDenied},
+		{"private", nil, "http://10.0.0.1/hook", codes.PermissionDenied},
+		{"metadata", nil, "http://169.254.169.254/latest", codes.PermissionDenied},
+		{"ipv6 loopback", nil, "http://[::1]/hook", codes.PermissionDenied},
+		{"mapped ipv4", nil, "http://[::ffff:127.0.0.1]/hook", codes.PermissionDenied},
+		{"allowed host", []string{"relay.internal"}, "http://relay.internal:8080/hook", codes.OK},
+		{"not in allowlist", []string{"relay.internal"}, "https://hooks.example/x", codes.PermissionDenied},
+		{"invalid url", nil, "ftp://hooks.example/x", codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		s := NewServer(newTestNotifier(t, Config{AllowRPC: true, RPCAllowedHosts: tt.allowed}), testAuthorizer)
+		in, _ := structpb.NewStruct(map[string]any{"url": tt.url})
+		if _, err := s.RegisterWebhook(authedContext(), in); status.Code(err) != tt.want {
+			t.Fatalf("%s: RegisterWebhook(%s) = %v, want %v", tt.name, tt.url, err, tt.want)
+		}
+	}
+}
+
+// RPC で登録した通知先へは、名前解決した後のアドレスがループバックなら送らない
+func TestNotifier_GuardedDelivery(t *testing.T) {
+	srv, got := newReceiver(t)
+	n := newTestNotifier(t, Config{})
+	if retryable, err := n.post(delivery{target: srv.URL, body: []byte("{}"), guarded: true}); retryable || !errors.Is(err, ErrDestinationNotAllowed) {
+		t.Fatalf("guarded post = %v, %v, want ErrDestinationNotAllowed", retryable, err)
+	}
+	if len(got()) != 0 {
+		t.Fatalf("received %d, want 0", len(got()))
+	}
+	if _, err := n.post(delivery{target: srv.URL, body: []byte("{}")}); err != nil {
+		t.Fatalf("post = %v", err)
+	}
+}